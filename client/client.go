@@ -0,0 +1,520 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// Package client calls the API of a timestampvm chain with typed requests
+// and replies, so Go integrators don't hand-write JSON-RPC calls, e.g.
+//
+//	c := client.NewClient("http://127.0.0.1:9650", chainID, client.WithRetries(3, time.Second))
+//	reply, err := c.GetBlockByData(ctx, &timestampvm.GetBlockByDataArgs{Data: data})
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/rpc/v2/json2"
+
+	"github.com/chain4travel/caminogo/utils/constants"
+	"github.com/chain4travel/caminogo/utils/rpc"
+
+	"github.com/chain4travel/camino-timestampvm/timestampvm"
+)
+
+// Interface compliance
+var _ Client = &client{}
+
+// Client for interacting with the API of a timestampvm chain. Every method
+// calls the API method of the same name.
+type Client interface {
+	// ProposeBlock proposes a block anchoring [args.Data]
+	ProposeBlock(ctx context.Context, args *timestampvm.ProposeBlockArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// ProposeSaltedContent proposes a block anchoring the hash of [args.Content]
+	// salted with a random salt, which is returned
+	ProposeSaltedContent(ctx context.Context, args *timestampvm.ProposeSaltedContentArgs, options ...rpc.Option) (*timestampvm.ProposeSaltedContentReply, error)
+	// VerifyTimestamp recomputes the digest of [args.Document] and returns the
+	// block anchoring it, whose timestamp proves the document existed by then
+	VerifyTimestamp(ctx context.Context, args *timestampvm.VerifyTimestampArgs, options ...rpc.Option) (*timestampvm.VerifyTimestampReply, error)
+	// ProposeTransfer proposes a block moving [args.Amount] from the account of
+	// the signer to the account of [args.To]
+	ProposeTransfer(ctx context.Context, args *timestampvm.ProposeTransferArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// GetAccount returns the account of [args.Address] as of the last accepted
+	// block
+	GetAccount(ctx context.Context, args *timestampvm.GetAccountArgs, options ...rpc.Option) (*timestampvm.GetAccountReply, error)
+	// ProposeCreditGrant proposes a block topping up the prepaid anchors of
+	// [args.To] by [args.Credits]
+	ProposeCreditGrant(ctx context.Context, args *timestampvm.ProposeCreditGrantArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// GetFreeAnchors returns the free anchors [args.Address] has left today, as
+	// of the last accepted block
+	GetFreeAnchors(ctx context.Context, args *timestampvm.GetFreeAnchorsArgs, options ...rpc.Option) (*timestampvm.GetFreeAnchorsReply, error)
+	// GetFeeSchedule returns how fees are computed and what a submission built on
+	// the preferred block costs, so clients can price submissions before sending
+	// them
+	GetFeeSchedule(ctx context.Context, options ...rpc.Option) (*timestampvm.GetFeeScheduleReply, error)
+	// EstimateFee returns the fee a block of [args.Size] bytes would be charged,
+	// based on the fee schedule and the submissions pending before it
+	EstimateFee(ctx context.Context, args *timestampvm.EstimateFeeArgs, options ...rpc.Option) (*timestampvm.EstimateFeeReply, error)
+	// GetFeeTotals returns where fees go and the running totals of the fees
+	// charged as of the last accepted block
+	GetFeeTotals(ctx context.Context, options ...rpc.Option) (*timestampvm.GetFeeTotalsReply, error)
+	// ProposeAllowlistUpdate proposes a block changing the submitter allowlist by
+	// [args.AllowlistUpdate]
+	ProposeAllowlistUpdate(ctx context.Context, args *timestampvm.ProposeAllowlistUpdateArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// ProposeSchemaUpdate proposes a block registering the payload schema of
+	// [args.SchemaUpdate]
+	ProposeSchemaUpdate(ctx context.Context, args *timestampvm.ProposeSchemaUpdateArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// GetSchemas returns the payload schemas as of the last accepted block
+	GetSchemas(ctx context.Context, options ...rpc.Option) (*timestampvm.GetSchemasReply, error)
+	// ProposeTravelDocument proposes a block anchoring the travel document of
+	// [args.TravelDocument]
+	ProposeTravelDocument(ctx context.Context, args *timestampvm.ProposeTravelDocumentArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// VerifyTravelDocument checks that the travel document of
+	// [args.TravelDocument] is anchored, returning the block anchoring it and
+	// whether it's currently valid
+	VerifyTravelDocument(ctx context.Context, args *timestampvm.VerifyTravelDocumentArgs, options ...rpc.Option) (*timestampvm.VerifyTravelDocumentReply, error)
+	// ProposeChainHead proposes a block anchoring the head of another chain
+	// [args.ChainHead]
+	ProposeChainHead(ctx context.Context, args *timestampvm.ProposeChainHeadArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// GetChainHead gets the head of the chain [args.Chain] at [args.Height] and
+	// the accepted block anchoring it
+	GetChainHead(ctx context.Context, args *timestampvm.GetChainHeadArgs, options ...rpc.Option) (*timestampvm.GetChainHeadReply, error)
+	// ProposeCID proposes a block anchoring the digest of the IPFS content
+	// [args.CID]
+	ProposeCID(ctx context.Context, args *timestampvm.ProposeCIDArgs, options ...rpc.Option) (*timestampvm.ProposeCIDReply, error)
+	// GetBlockByCID gets the earliest accepted block anchoring the digest of the
+	// IPFS content [args.CID], whether it was proposed as a CID or as a digest
+	// computed with the algorithm of the CID
+	GetBlockByCID(ctx context.Context, args *timestampvm.GetBlockByCIDArgs, options ...rpc.Option) (*timestampvm.GetBlockReply, error)
+	// ProposeRedaction proposes a block redacting the data of the accepted block
+	// [args.BlkID] at [args.Height]
+	ProposeRedaction(ctx context.Context, args *timestampvm.ProposeRedactionArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// ProposeCommitment proposes a block anchoring a commitment to a value which
+	// is revealed later with ProposeReveal
+	ProposeCommitment(ctx context.Context, args *timestampvm.ProposeCommitmentArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// ProposeReveal proposes a block anchoring [args.Value] and revealing it as
+	// the value of the accepted commitment it hashes to with [args.Salt]
+	ProposeReveal(ctx context.Context, args *timestampvm.ProposeRevealArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// GetCommitment returns whether the commitment [args.Commitment] is anchored
+	// and revealed, along with the blocks doing so
+	GetCommitment(ctx context.Context, args *timestampvm.GetCommitmentArgs, options ...rpc.Option) (*timestampvm.GetCommitmentReply, error)
+	// RegisterRecipientKey proposes a block registering [args.PublicKey] as the
+	// key payloads are encrypted to for the address signing it, replacing the key
+	// it registered before
+	RegisterRecipientKey(ctx context.Context, args *timestampvm.RegisterRecipientKeyArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// GetRecipientKey returns the public key [args.Address] registered, as of the
+	// last accepted block
+	GetRecipientKey(ctx context.Context, args *timestampvm.GetRecipientKeyArgs, options ...rpc.Option) (*timestampvm.GetRecipientKeyReply, error)
+	// ProposeEncryptedPayload proposes a block anchoring the hash of a payload
+	// encrypted to [args.Recipients], which must have registered their keys
+	ProposeEncryptedPayload(ctx context.Context, args *timestampvm.ProposeEncryptedPayloadArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error)
+	// GetCiphertext returns the ciphertext of the encrypted payload anchored as
+	// [args.Data] to one of its recipients
+	GetCiphertext(ctx context.Context, args *timestampvm.GetCiphertextArgs, options ...rpc.Option) (*timestampvm.GetCiphertextReply, error)
+	// GetSubmitterAllowlist returns the submitter allowlist as of the last
+	// accepted block
+	GetSubmitterAllowlist(ctx context.Context, options ...rpc.Option) (*timestampvm.GetSubmitterAllowlistReply, error)
+	// GetBlock gets the block whose ID is [args.ID], or the latest block if it's
+	// empty
+	GetBlock(ctx context.Context, args *timestampvm.GetBlockArgs, options ...rpc.Option) (*timestampvm.GetBlockReply, error)
+	// GetBlockHeader gets the header of the block whose ID is [args.ID], which is
+	// available even if the block's body was pruned
+	GetBlockHeader(ctx context.Context, args *timestampvm.GetBlockArgs, options ...rpc.Option) (*timestampvm.GetBlockHeaderReply, error)
+	// GetBlockBytes gets the bytes of the block whose ID is [args.ID], so it can
+	// be verified offline
+	GetBlockBytes(ctx context.Context, args *timestampvm.GetBlockArgs, options ...rpc.Option) (*timestampvm.GetBlockBytesReply, error)
+	// GetCertificate issues a proof-of-existence certificate of [args.Data],
+	// signed by this node, for handing to third parties
+	GetCertificate(ctx context.Context, args *timestampvm.GetCertificateArgs, options ...rpc.Option) (*timestampvm.GetCertificateReply, error)
+	// GetExternalAnchor returns the external transaction holding the ID of the
+	// accepted block [args.ID] or of its earliest published descendant
+	GetExternalAnchor(ctx context.Context, args *timestampvm.GetExternalAnchorArgs, options ...rpc.Option) (*timestampvm.ExternalAnchor, error)
+	// GetBlockByData gets the earliest accepted block whose data is [args.Data]
+	GetBlockByData(ctx context.Context, args *timestampvm.GetBlockByDataArgs, options ...rpc.Option) (*timestampvm.GetBlockReply, error)
+	// GetInclusionProof returns an ICS23 existence proof that [args.Data] was
+	// anchored at the timestamp of the earliest accepted block anchoring it
+	GetInclusionProof(ctx context.Context, args *timestampvm.GetInclusionProofArgs, options ...rpc.Option) (*timestampvm.GetInclusionProofReply, error)
+	// GetBlocksBySubmitter gets the accepted blocks signed by [args.Submitter],
+	// in height order, starting at [args.StartHeight]
+	GetBlocksBySubmitter(ctx context.Context, args *timestampvm.GetBlocksBySubmitterArgs, options ...rpc.Option) (*timestampvm.GetBlocksReply, error)
+	// GetByNamespace gets the accepted blocks anchoring data in [args.Namespace],
+	// in height order, starting at [args.FromHeight]
+	GetByNamespace(ctx context.Context, args *timestampvm.GetByNamespaceArgs, options ...rpc.Option) (*timestampvm.GetByNamespaceReply, error)
+	// GetByTag gets the accepted blocks of [args.Namespace] tagged
+	// [args.Key]=[args.Value], in height order, starting at [args.FromHeight]
+	GetByTag(ctx context.Context, args *timestampvm.GetByTagArgs, options ...rpc.Option) (*timestampvm.GetByNamespaceReply, error)
+	// GetBlockStats gets statistics of the intervals between the blocks accepted
+	// within [args.Window] and of the latencies of building and accepting blocks
+	// on this node
+	GetBlockStats(ctx context.Context, args *timestampvm.GetBlockStatsArgs, options ...rpc.Option) (*timestampvm.BlockStats, error)
+	// GetChainGrowth gets the number of blocks accepted per [args.Interval]
+	// between [args.Start] and [args.End], including intervals without blocks
+	GetChainGrowth(ctx context.Context, args *timestampvm.GetChainGrowthArgs, options ...rpc.Option) (*timestampvm.GetChainGrowthReply, error)
+	// GetBlockSummaries gets summaries of the accepted blocks below
+	// [args.BeforeHeight], highest first, as explorers list them
+	GetBlockSummaries(ctx context.Context, args *timestampvm.GetBlockSummariesArgs, options ...rpc.Option) (*timestampvm.GetBlockSummariesReply, error)
+	// GetHeadSummary gets the last accepted block and the state of the chain
+	GetHeadSummary(ctx context.Context, options ...rpc.Option) (*timestampvm.HeadSummary, error)
+	// GetRecentActivity gets the number of blocks accepted within
+	// [args.Window] and the namespaces and submitters anchoring the most of
+	// them
+	GetRecentActivity(ctx context.Context, args *timestampvm.GetRecentActivityArgs, options ...rpc.Option) (*timestampvm.RecentActivity, error)
+	// GetProofOfWork returns what a proof of work proposed now must satisfy
+	GetProofOfWork(ctx context.Context, options ...rpc.Option) (*timestampvm.GetProofOfWorkReply, error)
+	// GetChainInfo gets the node and chain this API is served by
+	GetChainInfo(ctx context.Context, options ...rpc.Option) (*timestampvm.GetChainInfoReply, error)
+}
+
+// client implements Client
+type client struct {
+	requester rpc.EndpointRequester
+	// options added to every call
+	options []rpc.Option
+	// number of times a failed call which doesn't propose is repeated
+	retries    int
+	retryDelay time.Duration
+}
+
+// Option configures a Client
+type Option func(*client)
+
+// WithRetries repeats failed calls up to [retries] times, waiting [delay]
+// before the first retry and twice as long before every next one. Calls are
+// only repeated if the API wasn't reached or replied with an HTTP error,
+// e.g. as the chain was rate limiting, not if it refused the request.
+// Proposals are never repeated, as the proposal may have succeeded without
+// the reply reaching the client.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(c *client) {
+		c.retries = retries
+		c.retryDelay = delay
+	}
+}
+
+// WithToken presents [token] with every call, as chains restricting their
+// API to token holders require
+func WithToken(token string) Option {
+	return WithRequestOptions(rpc.WithHeader("Authorization", "Bearer "+token))
+}
+
+// WithRequestOptions adds [options] to every call
+func WithRequestOptions(options ...rpc.Option) Option {
+	return func(c *client) {
+		c.options = append(c.options, options...)
+	}
+}
+
+// NewClient returns a Client calling the API of the chain [chain], its ID or
+// alias, served by the node at [uri], e.g. "http://127.0.0.1:9650"
+func NewClient(uri, chain string, options ...Option) Client {
+	c := &client{
+		requester: rpc.NewEndpointRequester(uri, fmt.Sprintf("/ext/%s", constants.ChainAliasPrefix+chain), timestampvm.Name),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// call calls [method], repeating it as configured if it fails
+func (c *client) call(ctx context.Context, method string, args interface{}, reply interface{}, options []rpc.Option) error {
+	options = append(c.options[:len(c.options):len(c.options)], options...)
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.requester.SendRequest(ctx, method, args, reply, options...)
+		if err == nil || attempt == c.retries || !retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// propose calls [method], which proposes a block, without repeating it
+func (c *client) propose(ctx context.Context, method string, args interface{}, reply interface{}, options []rpc.Option) error {
+	options = append(c.options[:len(c.options):len(c.options)], options...)
+	return c.requester.SendRequest(ctx, method, args, reply, options...)
+}
+
+// retryable returns true if the call failing with [err] may succeed when
+// repeated, i.e. unless the API refused it
+func retryable(err error) bool {
+	jsonErr := &json2.Error{}
+	return !errors.As(err, &jsonErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+func (c *client) ProposeBlock(ctx context.Context, args *timestampvm.ProposeBlockArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeBlock", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeSaltedContent(ctx context.Context, args *timestampvm.ProposeSaltedContentArgs, options ...rpc.Option) (*timestampvm.ProposeSaltedContentReply, error) {
+	reply := &timestampvm.ProposeSaltedContentReply{}
+	err := c.propose(ctx, "proposeSaltedContent", args, reply, options)
+	return reply, err
+}
+
+func (c *client) VerifyTimestamp(ctx context.Context, args *timestampvm.VerifyTimestampArgs, options ...rpc.Option) (*timestampvm.VerifyTimestampReply, error) {
+	reply := &timestampvm.VerifyTimestampReply{}
+	err := c.call(ctx, "verifyTimestamp", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeTransfer(ctx context.Context, args *timestampvm.ProposeTransferArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeTransfer", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetAccount(ctx context.Context, args *timestampvm.GetAccountArgs, options ...rpc.Option) (*timestampvm.GetAccountReply, error) {
+	reply := &timestampvm.GetAccountReply{}
+	err := c.call(ctx, "getAccount", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeCreditGrant(ctx context.Context, args *timestampvm.ProposeCreditGrantArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeCreditGrant", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetFreeAnchors(ctx context.Context, args *timestampvm.GetFreeAnchorsArgs, options ...rpc.Option) (*timestampvm.GetFreeAnchorsReply, error) {
+	reply := &timestampvm.GetFreeAnchorsReply{}
+	err := c.call(ctx, "getFreeAnchors", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetFeeSchedule(ctx context.Context, options ...rpc.Option) (*timestampvm.GetFeeScheduleReply, error) {
+	reply := &timestampvm.GetFeeScheduleReply{}
+	err := c.call(ctx, "getFeeSchedule", struct{}{}, reply, options)
+	return reply, err
+}
+
+func (c *client) EstimateFee(ctx context.Context, args *timestampvm.EstimateFeeArgs, options ...rpc.Option) (*timestampvm.EstimateFeeReply, error) {
+	reply := &timestampvm.EstimateFeeReply{}
+	err := c.call(ctx, "estimateFee", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetFeeTotals(ctx context.Context, options ...rpc.Option) (*timestampvm.GetFeeTotalsReply, error) {
+	reply := &timestampvm.GetFeeTotalsReply{}
+	err := c.call(ctx, "getFeeTotals", struct{}{}, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeAllowlistUpdate(ctx context.Context, args *timestampvm.ProposeAllowlistUpdateArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeAllowlistUpdate", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeSchemaUpdate(ctx context.Context, args *timestampvm.ProposeSchemaUpdateArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeSchemaUpdate", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetSchemas(ctx context.Context, options ...rpc.Option) (*timestampvm.GetSchemasReply, error) {
+	reply := &timestampvm.GetSchemasReply{}
+	err := c.call(ctx, "getSchemas", struct{}{}, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeTravelDocument(ctx context.Context, args *timestampvm.ProposeTravelDocumentArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeTravelDocument", args, reply, options)
+	return reply, err
+}
+
+func (c *client) VerifyTravelDocument(ctx context.Context, args *timestampvm.VerifyTravelDocumentArgs, options ...rpc.Option) (*timestampvm.VerifyTravelDocumentReply, error) {
+	reply := &timestampvm.VerifyTravelDocumentReply{}
+	err := c.call(ctx, "verifyTravelDocument", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeChainHead(ctx context.Context, args *timestampvm.ProposeChainHeadArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeChainHead", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetChainHead(ctx context.Context, args *timestampvm.GetChainHeadArgs, options ...rpc.Option) (*timestampvm.GetChainHeadReply, error) {
+	reply := &timestampvm.GetChainHeadReply{}
+	err := c.call(ctx, "getChainHead", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeCID(ctx context.Context, args *timestampvm.ProposeCIDArgs, options ...rpc.Option) (*timestampvm.ProposeCIDReply, error) {
+	reply := &timestampvm.ProposeCIDReply{}
+	err := c.propose(ctx, "proposeCID", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlockByCID(ctx context.Context, args *timestampvm.GetBlockByCIDArgs, options ...rpc.Option) (*timestampvm.GetBlockReply, error) {
+	reply := &timestampvm.GetBlockReply{}
+	err := c.call(ctx, "getBlockByCID", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeRedaction(ctx context.Context, args *timestampvm.ProposeRedactionArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeRedaction", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeCommitment(ctx context.Context, args *timestampvm.ProposeCommitmentArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeCommitment", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeReveal(ctx context.Context, args *timestampvm.ProposeRevealArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeReveal", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetCommitment(ctx context.Context, args *timestampvm.GetCommitmentArgs, options ...rpc.Option) (*timestampvm.GetCommitmentReply, error) {
+	reply := &timestampvm.GetCommitmentReply{}
+	err := c.call(ctx, "getCommitment", args, reply, options)
+	return reply, err
+}
+
+func (c *client) RegisterRecipientKey(ctx context.Context, args *timestampvm.RegisterRecipientKeyArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "registerRecipientKey", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetRecipientKey(ctx context.Context, args *timestampvm.GetRecipientKeyArgs, options ...rpc.Option) (*timestampvm.GetRecipientKeyReply, error) {
+	reply := &timestampvm.GetRecipientKeyReply{}
+	err := c.call(ctx, "getRecipientKey", args, reply, options)
+	return reply, err
+}
+
+func (c *client) ProposeEncryptedPayload(ctx context.Context, args *timestampvm.ProposeEncryptedPayloadArgs, options ...rpc.Option) (*timestampvm.ProposeBlockReply, error) {
+	reply := &timestampvm.ProposeBlockReply{}
+	err := c.propose(ctx, "proposeEncryptedPayload", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetCiphertext(ctx context.Context, args *timestampvm.GetCiphertextArgs, options ...rpc.Option) (*timestampvm.GetCiphertextReply, error) {
+	reply := &timestampvm.GetCiphertextReply{}
+	err := c.call(ctx, "getCiphertext", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetSubmitterAllowlist(ctx context.Context, options ...rpc.Option) (*timestampvm.GetSubmitterAllowlistReply, error) {
+	reply := &timestampvm.GetSubmitterAllowlistReply{}
+	err := c.call(ctx, "getSubmitterAllowlist", struct{}{}, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlock(ctx context.Context, args *timestampvm.GetBlockArgs, options ...rpc.Option) (*timestampvm.GetBlockReply, error) {
+	reply := &timestampvm.GetBlockReply{}
+	err := c.call(ctx, "getBlock", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlockHeader(ctx context.Context, args *timestampvm.GetBlockArgs, options ...rpc.Option) (*timestampvm.GetBlockHeaderReply, error) {
+	reply := &timestampvm.GetBlockHeaderReply{}
+	err := c.call(ctx, "getBlockHeader", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlockBytes(ctx context.Context, args *timestampvm.GetBlockArgs, options ...rpc.Option) (*timestampvm.GetBlockBytesReply, error) {
+	reply := &timestampvm.GetBlockBytesReply{}
+	err := c.call(ctx, "getBlockBytes", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetCertificate(ctx context.Context, args *timestampvm.GetCertificateArgs, options ...rpc.Option) (*timestampvm.GetCertificateReply, error) {
+	reply := &timestampvm.GetCertificateReply{}
+	err := c.call(ctx, "getCertificate", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetExternalAnchor(ctx context.Context, args *timestampvm.GetExternalAnchorArgs, options ...rpc.Option) (*timestampvm.ExternalAnchor, error) {
+	reply := &timestampvm.ExternalAnchor{}
+	err := c.call(ctx, "getExternalAnchor", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlockByData(ctx context.Context, args *timestampvm.GetBlockByDataArgs, options ...rpc.Option) (*timestampvm.GetBlockReply, error) {
+	reply := &timestampvm.GetBlockReply{}
+	err := c.call(ctx, "getBlockByData", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetInclusionProof(ctx context.Context, args *timestampvm.GetInclusionProofArgs, options ...rpc.Option) (*timestampvm.GetInclusionProofReply, error) {
+	reply := &timestampvm.GetInclusionProofReply{}
+	err := c.call(ctx, "getInclusionProof", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlocksBySubmitter(ctx context.Context, args *timestampvm.GetBlocksBySubmitterArgs, options ...rpc.Option) (*timestampvm.GetBlocksReply, error) {
+	reply := &timestampvm.GetBlocksReply{}
+	err := c.call(ctx, "getBlocksBySubmitter", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetByNamespace(ctx context.Context, args *timestampvm.GetByNamespaceArgs, options ...rpc.Option) (*timestampvm.GetByNamespaceReply, error) {
+	reply := &timestampvm.GetByNamespaceReply{}
+	err := c.call(ctx, "getByNamespace", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetByTag(ctx context.Context, args *timestampvm.GetByTagArgs, options ...rpc.Option) (*timestampvm.GetByNamespaceReply, error) {
+	reply := &timestampvm.GetByNamespaceReply{}
+	err := c.call(ctx, "getByTag", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlockStats(ctx context.Context, args *timestampvm.GetBlockStatsArgs, options ...rpc.Option) (*timestampvm.BlockStats, error) {
+	reply := &timestampvm.BlockStats{}
+	err := c.call(ctx, "getBlockStats", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetChainGrowth(ctx context.Context, args *timestampvm.GetChainGrowthArgs, options ...rpc.Option) (*timestampvm.GetChainGrowthReply, error) {
+	reply := &timestampvm.GetChainGrowthReply{}
+	err := c.call(ctx, "getChainGrowth", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetBlockSummaries(ctx context.Context, args *timestampvm.GetBlockSummariesArgs, options ...rpc.Option) (*timestampvm.GetBlockSummariesReply, error) {
+	reply := &timestampvm.GetBlockSummariesReply{}
+	err := c.call(ctx, "getBlockSummaries", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetHeadSummary(ctx context.Context, options ...rpc.Option) (*timestampvm.HeadSummary, error) {
+	reply := &timestampvm.HeadSummary{}
+	err := c.call(ctx, "getHeadSummary", struct{}{}, reply, options)
+	return reply, err
+}
+
+func (c *client) GetRecentActivity(ctx context.Context, args *timestampvm.GetRecentActivityArgs, options ...rpc.Option) (*timestampvm.RecentActivity, error) {
+	reply := &timestampvm.RecentActivity{}
+	err := c.call(ctx, "getRecentActivity", args, reply, options)
+	return reply, err
+}
+
+func (c *client) GetProofOfWork(ctx context.Context, options ...rpc.Option) (*timestampvm.GetProofOfWorkReply, error) {
+	reply := &timestampvm.GetProofOfWorkReply{}
+	err := c.call(ctx, "getProofOfWork", struct{}{}, reply, options)
+	return reply, err
+}
+
+func (c *client) GetChainInfo(ctx context.Context, options ...rpc.Option) (*timestampvm.GetChainInfoReply, error) {
+	reply := &timestampvm.GetChainInfoReply{}
+	err := c.call(ctx, "getChainInfo", struct{}{}, reply, options)
+	return reply, err
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package client
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/rpc/v2/json2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/json"
+
+	"github.com/chain4travel/camino-timestampvm/timestampvm"
+)
+
+const testChain = "timestamp"
+
+// testAPI serves JSON-RPC calls to the chain [testChain] with [serve],
+// counting the calls
+type testAPI struct {
+	t      *testing.T
+	server *httptest.Server
+	serve  func(w http.ResponseWriter, call *testCall)
+
+	lock  sync.Mutex
+	calls int
+}
+
+// testCall is a call received by a testAPI
+type testCall struct {
+	Method string             `json:"method"`
+	Params stdjson.RawMessage `json:"params"`
+	ID     stdjson.RawMessage `json:"id"`
+	header http.Header
+}
+
+func newTestAPI(t *testing.T, serve func(w http.ResponseWriter, call *testCall)) *testAPI {
+	api := &testAPI{t: t, serve: serve}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.lock.Lock()
+		api.calls++
+		api.lock.Unlock()
+
+		assert.Equal(t, "/ext/bc/"+testChain, r.URL.Path)
+		call := &testCall{header: r.Header}
+		assert.NoError(t, stdjson.NewDecoder(r.Body).Decode(call))
+		api.serve(w, call)
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// Calls returns the number of calls received
+func (api *testAPI) Calls() int {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	return api.calls
+}
+
+// reply writes the JSON-RPC response to [call] holding [result]
+func reply(t *testing.T, w http.ResponseWriter, call *testCall, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	assert.NoError(t, stdjson.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"result":  result,
+		"id":      call.ID,
+	}))
+}
+
+// failing returns a handler replying to the first [failures] calls with
+// [status], and to the others with a chain info
+func failing(t *testing.T, failures int, status int) func(w http.ResponseWriter, call *testCall) {
+	lock := sync.Mutex{}
+	return func(w http.ResponseWriter, call *testCall) {
+		lock.Lock()
+		failures--
+		failed := failures >= 0
+		lock.Unlock()
+		if failed {
+			http.Error(w, "busy", status)
+			return
+		}
+		reply(t, w, call, &timestampvm.GetChainInfoReply{})
+	}
+}
+
+func TestTypedCall(t *testing.T) {
+	assert := assert.New(t)
+	blkID := ids.ID{1}
+	api := newTestAPI(t, func(w http.ResponseWriter, call *testCall) {
+		assert.Equal("timestampvm.getBlockByData", call.Method)
+		assert.Equal("Bearer secret", call.header.Get("Authorization"))
+		args := timestampvm.GetBlockByDataArgs{}
+		assert.NoError(stdjson.Unmarshal(call.Params, &args))
+		assert.Equal("data", args.Data)
+		reply(t, w, call, &timestampvm.GetBlockReply{ID: blkID, Height: 7, Data: "data"})
+	})
+	c := NewClient(api.server.URL, testChain, WithToken("secret"))
+
+	block, err := c.GetBlockByData(context.Background(), &timestampvm.GetBlockByDataArgs{Data: "data"})
+	assert.NoError(err)
+	assert.Equal(blkID, block.ID)
+	assert.Equal(json.Uint64(7), block.Height)
+	assert.Equal("data", block.Data)
+	assert.Equal(1, api.Calls())
+}
+
+func TestRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	// HTTP errors are retried
+	api := newTestAPI(t, failing(t, 2, http.StatusServiceUnavailable))
+	_, err := NewClient(api.server.URL, testChain, WithRetries(2, time.Millisecond)).GetChainInfo(context.Background())
+	assert.NoError(err)
+	assert.Equal(3, api.Calls())
+
+	// as are calls which didn't reach the API
+	dropped := false
+	api = newTestAPI(t, func(w http.ResponseWriter, call *testCall) {
+		if !dropped {
+			dropped = true
+			conn, _, err := w.(http.Hijacker).Hijack()
+			assert.NoError(err)
+			assert.NoError(conn.Close())
+			return
+		}
+		reply(t, w, call, &timestampvm.GetChainInfoReply{})
+	})
+	_, err = NewClient(api.server.URL, testChain, WithRetries(1, time.Millisecond)).GetChainInfo(context.Background())
+	assert.NoError(err)
+	assert.Equal(2, api.Calls())
+
+	// but not calls the API refused
+	api = newTestAPI(t, func(w http.ResponseWriter, call *testCall) {
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(stdjson.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"error":   &json2.Error{Code: json2.E_BAD_PARAMS, Message: "bad data"},
+			"id":      call.ID,
+		}))
+	})
+	_, err = NewClient(api.server.URL, testChain, WithRetries(2, time.Millisecond)).GetChainInfo(context.Background())
+	assert.Error(err)
+	assert.Equal(1, api.Calls())
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert := assert.New(t)
+	api := newTestAPI(t, failing(t, 10, http.StatusInternalServerError))
+	c := NewClient(api.server.URL, testChain, WithRetries(2, 10*time.Millisecond))
+
+	// the delay doubles, and the last error is returned once the retries
+	// are exhausted
+	start := time.Now()
+	_, err := c.GetChainInfo(context.Background())
+	assert.Error(err)
+	assert.GreaterOrEqual(time.Since(start), 30*time.Millisecond)
+	assert.Equal(3, api.Calls())
+
+	// retries stop once the call is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c = NewClient(api.server.URL, testChain, WithRetries(5, time.Hour))
+	_, err = c.GetChainInfo(ctx)
+	assert.Error(err)
+	assert.Equal(4, api.Calls())
+}
+
+func TestProposalsAreNotRetried(t *testing.T) {
+	assert := assert.New(t)
+	api := newTestAPI(t, failing(t, 10, http.StatusServiceUnavailable))
+	c := NewClient(api.server.URL, testChain, WithRetries(3, time.Millisecond))
+
+	_, err := c.ProposeBlock(context.Background(), &timestampvm.ProposeBlockArgs{Data: "data"})
+	assert.Error(err)
+	assert.Equal(1, api.Calls())
+	_, err = c.ProposeSaltedContent(context.Background(), &timestampvm.ProposeSaltedContentArgs{Content: "content"})
+	assert.Error(err)
+	assert.Equal(2, api.Calls())
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// timestampvm-admin calls a method of the admin API of a running timestampvm
+// chain, e.g.
+//
+//	timestampvm-admin -uri http://127.0.0.1:9650/ext/bc/<chainID>/admin rebuildIndexes
+//	timestampvm-admin -uri ... -wait getRebuildIndexesStatus
+//	timestampvm-admin -uri ... -token-file admin.token -params '{"locked": true}' lockMempool
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const adminService = "admin"
+
+var errUsage = errors.New("usage: timestampvm-admin -uri <admin API URI> [-token-file <path>] [-params <JSON>] [-wait] <method>")
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("timestampvm-admin", flag.ContinueOnError)
+	uri := fs.String("uri", "", "URI of the chain's admin API")
+	params := fs.String("params", "{}", "parameters of the method as JSON object")
+	wait := fs.Bool("wait", false, "repeat a status method until the job is no longer running")
+	interval := fs.Duration("interval", time.Second, "time between two calls with -wait")
+	tokenFile := fs.String("token-file", "", "file holding the admin token, if the chain requires one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *uri == "" || fs.NArg() != 1 {
+		return errUsage
+	}
+	method := fs.Arg(0)
+	token := ""
+	if *tokenFile != "" {
+		tokenBytes, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(tokenBytes))
+	}
+
+	for {
+		result, err := call(*uri, token, method, json.RawMessage(*params))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(out, string(result)); err != nil {
+			return err
+		}
+		if !*wait || !isRunning(result) {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// call calls [method] of the admin API at [uri], presenting [token] unless
+// it's empty, and returns its result
+func call(uri string, token string, method string, params json.RawMessage) (json.RawMessage, error) {
+	body, err := json.Marshal(request{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  fmt.Sprintf("%s.%s", adminService, method),
+		Params:  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", uri, resp.Status)
+	}
+	reply := response{}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, fmt.Errorf("%s failed: %s", method, reply.Error.Message)
+	}
+	return reply.Result, nil
+}
+
+// isRunning returns true if [result] reports a running job
+func isRunning(result json.RawMessage) bool {
+	status := struct {
+		Running bool `json:"running"`
+	}{}
+	return json.Unmarshal(result, &status) == nil && status.Running
+}
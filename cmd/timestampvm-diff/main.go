@@ -0,0 +1,127 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// timestampvm-diff compares the states of two timestampvm chains and reports
+// their divergences as JSON, e.g.
+//
+//	timestampvm-diff /data/node-1/chain-db /data/node-2/chain-db
+//	timestampvm-diff -limit 10 /data/node-1/chain-db snapshot-1200-1656662400.tar
+//
+// Each database is a leveldb directory, which must not be open by a running
+// node, or a snapshot written by the admin API. Exits with status 1 if the
+// states diverge.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/database/encdb"
+	"github.com/chain4travel/caminogo/database/leveldb"
+	"github.com/chain4travel/caminogo/database/memdb"
+	"github.com/chain4travel/caminogo/utils/logging"
+
+	"github.com/chain4travel/camino-timestampvm/timestampvm"
+)
+
+var (
+	errUsage    = errors.New("usage: timestampvm-diff [-limit <n>] [-encryption-key-file <path>] <database A> <database B>")
+	errDiverged = errors.New("states diverge")
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("timestampvm-diff", flag.ContinueOnError)
+	limit := fs.Int("limit", 100, "maximum number of divergences listed")
+	keyFile := fs.String("encryption-key-file", "", "file holding the key both states are encrypted with, if any")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	key := []byte(nil)
+	if *keyFile != "" {
+		keyBytes, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		key = bytes.TrimSpace(keyBytes)
+	}
+
+	dbA, err := openDatabase(fs.Arg(0), key)
+	if err != nil {
+		return err
+	}
+	defer dbA.Close()
+	dbB, err := openDatabase(fs.Arg(1), key)
+	if err != nil {
+		return err
+	}
+	defer dbB.Close()
+
+	diff, err := timestampvm.DiffDatabases(dbA, dbB, *limit)
+	if err != nil {
+		return err
+	}
+	report, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, string(report)); err != nil {
+		return err
+	}
+	if !diff.Equal() {
+		return errDiverged
+	}
+	return nil
+}
+
+// openDatabase opens the leveldb directory or snapshot file at [path],
+// decrypting its values with [key] unless it's nil
+func openDatabase(path string, key []byte) (database.Database, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var db database.Database
+	if info.IsDir() {
+		db, err = leveldb.New(path, nil, logging.NoLog{})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open leveldb at %s: %w", path, err)
+		}
+	} else {
+		if !strings.HasSuffix(path, ".tar") {
+			return nil, fmt.Errorf("%s is neither a directory nor a snapshot", path)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		db = memdb.New()
+		if _, err := timestampvm.RestoreSnapshot(db, file); err != nil {
+			return nil, fmt.Errorf("couldn't read snapshot %s: %w", path, err)
+		}
+	}
+
+	if key == nil {
+		return db, nil
+	}
+	return encdb.New(key, db)
+}
@@ -19,6 +19,8 @@ import (
 	"os"
 
 	log "github.com/inconshreveable/log15"
+	// Registers the "postgres" driver the PostgreSQL export connects with
+	_ "github.com/lib/pq"
 
 	"github.com/chain4travel/camino-timestampvm/timestampvm"
 	"github.com/chain4travel/caminogo/vms/rpcchainvm"
@@ -0,0 +1,14 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import (
+	// Registers the "sqlite3" driver the SQLite mirror is written with. It
+	// requires cgo, so it's only linked into plugins built with the sqlite
+	// tag.
+	_ "github.com/mattn/go-sqlite3"
+)
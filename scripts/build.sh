@@ -26,6 +26,9 @@ elif [[ $# -ne 0 ]]; then
 fi
 
 
+# Build tags, e.g. BUILD_TAGS=sqlite links the SQLite driver, which requires cgo
+build_tags=${BUILD_TAGS:-}
+
 # Build timestampvm, which is run as a subprocess
 echo "Building timestampvm in $binary_directory/$name"
-go build -o "$binary_directory/$name" "main/"*.go
+go build -tags "$build_tags" -o "$binary_directory/$name" ./main
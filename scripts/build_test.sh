@@ -4,4 +4,5 @@ set -o errexit
 set -o nounset
 set -o pipefail
 
-go test -race -timeout="3m" -coverprofile="coverage.out" -covermode="atomic" ./...
+# The race detector requires cgo, so the tests of the SQLite driver run as well
+go test -race -tags sqlite -timeout="3m" -coverprofile="coverage.out" -covermode="atomic" ./...
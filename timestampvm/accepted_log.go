@@ -0,0 +1,119 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/cache"
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	errAcceptedLogGap = errors.New("accepted log must be appended to sequentially")
+
+	_ AcceptedLog = &acceptedLog{}
+)
+
+// AcceptedLog is an append-only log of accepted block IDs, ordered by height.
+// It allows iterating the canonical chain from genesis onwards without
+// walking parent pointers backwards.
+type AcceptedLog interface {
+	// AppendAccepted appends [blkID] at [height].
+	// Unless [height] is 0, the log must already contain [height]-1.
+	AppendAccepted(height uint64, blkID ids.ID) error
+	// RepairAccepted overwrites the entry at [height] with [blkID], even if
+	// [height]-1 is missing. Only meant for rebuilding the log.
+	RepairAccepted(height uint64, blkID ids.ID) error
+	// GetAcceptedID returns the ID of the accepted block at [height]
+	GetAcceptedID(height uint64) (ids.ID, error)
+	// GetAcceptedIDs returns at most [limit] accepted block IDs, in height
+	// order, starting at [startHeight]
+	GetAcceptedIDs(ctx context.Context, startHeight uint64, limit int) ([]ids.ID, error)
+}
+
+// acceptedLog implements AcceptedLog with a database mapping the big-endian
+// encoded height to the block ID, so iterating the database yields the IDs in
+// height order
+type acceptedLog struct {
+	// cache of height --> block ID
+	idCache cache.Cacher
+	logDB   database.Database
+}
+
+// NewAcceptedLog returns AcceptedLog stored in the given db, caching
+// lookups by height in [idCache]
+func NewAcceptedLog(db database.Database, idCache cache.Cacher) AcceptedLog {
+	return &acceptedLog{
+		idCache: idCache,
+		logDB:   db,
+	}
+}
+
+// AppendAccepted implements the AcceptedLog interface
+func (l *acceptedLog) AppendAccepted(height uint64, blkID ids.ID) error {
+	if height > 0 {
+		hasPrevious, err := l.logDB.Has(database.PackUInt64(height - 1))
+		if err != nil {
+			return err
+		}
+		if !hasPrevious {
+			return fmt.Errorf("%w: height %d is missing", errAcceptedLogGap, height-1)
+		}
+	}
+	if err := database.PutID(l.logDB, database.PackUInt64(height), blkID); err != nil {
+		return err
+	}
+	l.idCache.Put(height, blkID)
+	return nil
+}
+
+// RepairAccepted implements the AcceptedLog interface
+func (l *acceptedLog) RepairAccepted(height uint64, blkID ids.ID) error {
+	if err := database.PutID(l.logDB, database.PackUInt64(height), blkID); err != nil {
+		return err
+	}
+	l.idCache.Put(height, blkID)
+	return nil
+}
+
+// flushCache drops all cached block IDs
+func (l *acceptedLog) flushCache() {
+	l.idCache.Flush()
+}
+
+// GetAcceptedID implements the AcceptedLog interface
+func (l *acceptedLog) GetAcceptedID(height uint64) (ids.ID, error) {
+	if blkIDIntf, cached := l.idCache.Get(height); cached {
+		return blkIDIntf.(ids.ID), nil
+	}
+	blkID, err := database.GetID(l.logDB, database.PackUInt64(height))
+	if err != nil {
+		return ids.Empty, err
+	}
+	l.idCache.Put(height, blkID)
+	return blkID, nil
+}
+
+// GetAcceptedIDs implements the AcceptedLog interface
+func (l *acceptedLog) GetAcceptedIDs(ctx context.Context, startHeight uint64, limit int) ([]ids.ID, error) {
+	it := l.logDB.NewIteratorWithStart(database.PackUInt64(startHeight))
+	defer it.Release()
+
+	blkIDs := []ids.ID(nil)
+	for len(blkIDs) < limit && it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blkID, err := ids.ToID(it.Value())
+		if err != nil {
+			return nil, err
+		}
+		blkIDs = append(blkIDs, blkID)
+	}
+	return blkIDs, it.Error()
+}
@@ -0,0 +1,185 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	_ Accounts = &accounts{}
+
+	// marks the accounts as initialized with this key. It's shorter than the
+	// addresses, so it can't collide with them.
+	accountsInitializedKey = []byte{0}
+	// stores the fee totals with this key, which can't collide either
+	feeTotalsKey = []byte{1}
+)
+
+const (
+	// prefixes the address in the key of its prepaid credits. The keys are
+	// longer than the addresses, so they can't collide with them.
+	creditsKeyPrefix byte = 2
+	// prefixes the address and the data in the key marking a charged
+	// submission, which is longer than the keys of credits
+	chargedKeyPrefix byte = 3
+)
+
+// Account is the balance of an address paying fees
+type Account struct {
+	// Balance is the amount available to pay fees and transfers
+	Balance uint64 `serialize:"true" json:"balance"`
+	// Nonce is the number of accepted transfers from this account, including
+	// fundings by an issuer
+	Nonce uint64 `serialize:"true" json:"nonce"`
+}
+
+// FeeTotals are the fees charged by all accepted blocks
+type FeeTotals struct {
+	// Burnt is the amount of fees charged while they were burnt
+	Burnt uint64 `serialize:"true" json:"burnt"`
+	// Collected is the amount of fees credited to the fee recipient
+	Collected uint64 `serialize:"true" json:"collected"`
+}
+
+// Accounts holds the accounts paying fees as of the last accepted block
+type Accounts interface {
+	// GetAccount returns the account of [address], which is empty if it
+	// never held funds
+	GetAccount(address ids.ShortID) (*Account, error)
+	// PutAccount stores [account] as the account of [address]
+	PutAccount(address ids.ShortID, account *Account) error
+	// AccountsInitialized returns true if the accounts were initialized
+	AccountsInitialized() (bool, error)
+	// InitAccounts credits [balances] to their addresses and marks the
+	// accounts as initialized
+	InitAccounts(balances map[ids.ShortID]uint64) error
+	// GetCredits returns the number of prepaid anchors of [address]
+	GetCredits(address ids.ShortID) (uint64, error)
+	// PutCredits stores [credits] as the prepaid anchors of [address]
+	PutCredits(address ids.ShortID, credits uint64) error
+	// IsCharged returns true if a submission of [data] signed by [address]
+	// was charged
+	IsCharged(address ids.ShortID, data [dataLen]byte) (bool, error)
+	// PutCharged marks the submission of [data] signed by [address] as
+	// charged
+	PutCharged(address ids.ShortID, data [dataLen]byte) error
+	// GetFeeTotals returns the fees charged so far
+	GetFeeTotals() (*FeeTotals, error)
+	// PutFeeTotals stores [totals] as the fees charged so far
+	PutFeeTotals(totals *FeeTotals) error
+}
+
+// accounts implements Accounts with a database keyed by address
+type accounts struct {
+	accountDB database.Database
+}
+
+// NewAccounts returns Accounts stored in the given db
+func NewAccounts(db database.Database) Accounts {
+	return &accounts{accountDB: db}
+}
+
+// GetAccount implements the Accounts interface
+func (a *accounts) GetAccount(address ids.ShortID) (*Account, error) {
+	accountBytes, err := a.accountDB.Get(address.Bytes())
+	if err == database.ErrNotFound {
+		return &Account{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	account := &Account{}
+	_, err = Codec.Unmarshal(accountBytes, account)
+	return account, err
+}
+
+// PutAccount implements the Accounts interface
+func (a *accounts) PutAccount(address ids.ShortID, account *Account) error {
+	accountBytes, err := Codec.Marshal(CodecVersion, account)
+	if err != nil {
+		return err
+	}
+	return a.accountDB.Put(address.Bytes(), accountBytes)
+}
+
+// AccountsInitialized implements the Accounts interface
+func (a *accounts) AccountsInitialized() (bool, error) {
+	return a.accountDB.Has(accountsInitializedKey)
+}
+
+// InitAccounts implements the Accounts interface
+func (a *accounts) InitAccounts(balances map[ids.ShortID]uint64) error {
+	for address, balance := range balances {
+		if err := a.PutAccount(address, &Account{Balance: balance}); err != nil {
+			return err
+		}
+	}
+	return a.accountDB.Put(accountsInitializedKey, []byte{1})
+}
+
+// creditsKey returns the key of the prepaid credits of [address]
+func creditsKey(address ids.ShortID) []byte {
+	return append([]byte{creditsKeyPrefix}, address[:]...)
+}
+
+// GetCredits implements the Accounts interface
+func (a *accounts) GetCredits(address ids.ShortID) (uint64, error) {
+	creditsBytes, err := a.accountDB.Get(creditsKey(address))
+	if err == database.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return database.ParseUInt64(creditsBytes)
+}
+
+// PutCredits implements the Accounts interface
+func (a *accounts) PutCredits(address ids.ShortID, credits uint64) error {
+	return a.accountDB.Put(creditsKey(address), database.PackUInt64(credits))
+}
+
+// chargedKey returns the key marking the submission of [data] signed by
+// [address] as charged
+func chargedKey(address ids.ShortID, data [dataLen]byte) []byte {
+	key := make([]byte, 0, 1+len(address)+dataLen)
+	key = append(key, chargedKeyPrefix)
+	key = append(key, address[:]...)
+	return append(key, data[:]...)
+}
+
+// IsCharged implements the Accounts interface
+func (a *accounts) IsCharged(address ids.ShortID, data [dataLen]byte) (bool, error) {
+	return a.accountDB.Has(chargedKey(address, data))
+}
+
+// PutCharged implements the Accounts interface
+func (a *accounts) PutCharged(address ids.ShortID, data [dataLen]byte) error {
+	return a.accountDB.Put(chargedKey(address, data), []byte{1})
+}
+
+// GetFeeTotals implements the Accounts interface
+func (a *accounts) GetFeeTotals() (*FeeTotals, error) {
+	totalsBytes, err := a.accountDB.Get(feeTotalsKey)
+	if err == database.ErrNotFound {
+		return &FeeTotals{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	totals := &FeeTotals{}
+	_, err = Codec.Unmarshal(totalsBytes, totals)
+	return totals, err
+}
+
+// PutFeeTotals implements the Accounts interface
+func (a *accounts) PutFeeTotals(totals *FeeTotals) error {
+	totalsBytes, err := Codec.Marshal(CodecVersion, totals)
+	if err != nil {
+		return err
+	}
+	return a.accountDB.Put(feeTotalsKey, totalsBytes)
+}
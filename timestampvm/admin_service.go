@@ -0,0 +1,508 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/chain4travel/caminogo/api"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/json"
+	"github.com/chain4travel/caminogo/utils/logging"
+)
+
+// AdminService is the API service for operating this VM.
+// It's only served if enabled in the config.
+type AdminService struct{ vm *VM }
+
+// PruneBlocks starts pruning the blocks outside of the configured retention
+func (s *AdminService) PruneBlocks(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if err := s.vm.pruner.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetPruningStatus returns the progress of pruning
+func (s *AdminService) GetPruningStatus(_ *http.Request, _ *struct{}, reply *PruningStatus) error {
+	*reply = s.vm.pruner.Status()
+	return nil
+}
+
+// CompactDatabase starts compacting the database
+func (s *AdminService) CompactDatabase(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if err := s.vm.compactor.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetCompactionStatus returns the state of database compaction
+func (s *AdminService) GetCompactionStatus(_ *http.Request, _ *struct{}, reply *CompactionStatus) error {
+	*reply = s.vm.compactor.Status()
+	return nil
+}
+
+// VerifyIntegrity starts checking the accepted chain for corruption
+func (s *AdminService) VerifyIntegrity(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if err := s.vm.integrity.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetIntegrityStatus returns the progress and the issues found by the last
+// integrity check
+func (s *AdminService) GetIntegrityStatus(_ *http.Request, _ *struct{}, reply *IntegrityStatus) error {
+	*reply = s.vm.integrity.Status()
+	return nil
+}
+
+// CollectDatabaseStats starts counting the keys and bytes stored in each part
+// of the state
+func (s *AdminService) CollectDatabaseStats(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if err := s.vm.dbStats.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetDatabaseStats returns the space taken by the state, as counted by the
+// last CollectDatabaseStats, and the statistics of the caches
+func (s *AdminService) GetDatabaseStats(_ *http.Request, _ *struct{}, reply *DatabaseStats) error {
+	stats, err := s.vm.dbStats.Stats()
+	if err != nil {
+		return err
+	}
+	*reply = *stats
+	return nil
+}
+
+// GetAPIKeyUsageReply is the reply from GetAPIKeyUsage
+type GetAPIKeyUsageReply struct {
+	// Keys is the usage of every API key which called the public API since
+	// the VM started, by name
+	Keys map[string]APIKeyUsage `json:"keys"`
+}
+
+// GetAPIKeyUsage returns the calls made with each API key and the quotas
+// applying to them
+func (s *AdminService) GetAPIKeyUsage(_ *http.Request, _ *struct{}, reply *GetAPIKeyUsageReply) error {
+	reply.Keys = s.vm.usage.Usage(time.Now())
+	return nil
+}
+
+// CreateSnapshotArgs are the arguments to CreateSnapshot
+type CreateSnapshotArgs struct {
+	// Dir is the directory the snapshot is written to
+	Dir string `json:"dir"`
+}
+
+// CreateSnapshotReply is the reply from CreateSnapshot
+type CreateSnapshotReply struct {
+	// Path of the snapshot file once it's written
+	Path string `json:"path"`
+}
+
+// CreateSnapshot starts writing a snapshot of the current state to a file.
+// The chain keeps running while the snapshot is written.
+func (s *AdminService) CreateSnapshot(_ *http.Request, args *CreateSnapshotArgs, reply *CreateSnapshotReply) error {
+	path, err := s.vm.snapshotter.Trigger(args.Dir)
+	if err != nil {
+		return err
+	}
+	reply.Path = path
+	return nil
+}
+
+// GetSnapshotStatus returns the progress of writing a snapshot
+func (s *AdminService) GetSnapshotStatus(_ *http.Request, _ *struct{}, reply *SnapshotStatus) error {
+	*reply = s.vm.snapshotter.Status()
+	return nil
+}
+
+// CreateBackup starts writing a backup of the current state to the backup
+// store, outside of the schedule
+func (s *AdminService) CreateBackup(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if s.vm.backups == nil {
+		return errBackupsDisabled
+	}
+	if err := s.vm.backups.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetBackupStatus returns the state of scheduled backups and the stored
+// backups
+func (s *AdminService) GetBackupStatus(_ *http.Request, _ *struct{}, reply *BackupStatus) error {
+	if s.vm.backups == nil {
+		return errBackupsDisabled
+	}
+	status, err := s.vm.backups.Status()
+	if err != nil {
+		return err
+	}
+	*reply = *status
+	return nil
+}
+
+// GetExternalAnchorStatus returns the state of external anchoring
+func (s *AdminService) GetExternalAnchorStatus(_ *http.Request, _ *struct{}, reply *ExternalAnchorStatus) error {
+	if s.vm.externalAnchorer == nil {
+		return errExternalAnchorsDisabled
+	}
+	*reply = s.vm.externalAnchorer.Status()
+	return nil
+}
+
+// GetIPFSStatus returns the state of pinning the content of the anchored
+// CIDs
+func (s *AdminService) GetIPFSStatus(_ *http.Request, _ *struct{}, reply *IPFSStatus) error {
+	if s.vm.ipfs == nil {
+		return errIPFSDisabled
+	}
+	*reply = s.vm.ipfs.Status()
+	return nil
+}
+
+// GetContractEventsStatus returns the state of relaying the events of the
+// configured C-Chain contract
+func (s *AdminService) GetContractEventsStatus(_ *http.Request, _ *struct{}, reply *ContractEventsStatus) error {
+	if s.vm.contractEvents == nil {
+		return errContractEventsDisabled
+	}
+	*reply = s.vm.contractEvents.Status()
+	return nil
+}
+
+// GetPostgresExportStatus returns the state of the PostgreSQL export
+func (s *AdminService) GetPostgresExportStatus(_ *http.Request, _ *struct{}, reply *ExportStatus) error {
+	if s.vm.postgresExporter == nil {
+		return errPostgresExportDisabled
+	}
+	*reply = s.vm.postgresExporter.Status()
+	return nil
+}
+
+// BackfillPostgresArgs are the arguments to BackfillPostgres
+type BackfillPostgresArgs struct {
+	// FromHeight is the height of the first block exported again
+	FromHeight json.Uint64 `json:"fromHeight"`
+}
+
+// BackfillPostgres exports the accepted blocks to PostgreSQL again, starting
+// at [args.FromHeight], e.g. once the tables were truncated
+func (s *AdminService) BackfillPostgres(_ *http.Request, args *BackfillPostgresArgs, reply *api.SuccessResponse) error {
+	if s.vm.postgresExporter == nil {
+		return errPostgresExportDisabled
+	}
+	s.vm.postgresExporter.Backfill(uint64(args.FromHeight))
+	s.vm.ctx.Log.Info("backfilling PostgreSQL from height %d", args.FromHeight)
+	reply.Success = true
+	return nil
+}
+
+// GetSearchExportStatus returns the state of the search index export
+func (s *AdminService) GetSearchExportStatus(_ *http.Request, _ *struct{}, reply *ExportStatus) error {
+	if s.vm.searchExporter == nil {
+		return errSearchExportDisabled
+	}
+	*reply = s.vm.searchExporter.Status()
+	return nil
+}
+
+// BackfillSearchArgs are the arguments to BackfillSearch
+type BackfillSearchArgs struct {
+	// FromHeight is the height of the first block exported again
+	FromHeight json.Uint64 `json:"fromHeight"`
+}
+
+// BackfillSearch exports the accepted blocks to the search index again,
+// starting at [args.FromHeight], e.g. once the index was deleted
+func (s *AdminService) BackfillSearch(_ *http.Request, args *BackfillSearchArgs, reply *api.SuccessResponse) error {
+	if s.vm.searchExporter == nil {
+		return errSearchExportDisabled
+	}
+	s.vm.searchExporter.Backfill(uint64(args.FromHeight))
+	s.vm.ctx.Log.Info("backfilling the search index from height %d", args.FromHeight)
+	reply.Success = true
+	return nil
+}
+
+// GetSQLiteMirrorStatus returns the state of the SQLite mirror
+func (s *AdminService) GetSQLiteMirrorStatus(_ *http.Request, _ *struct{}, reply *ExportStatus) error {
+	if s.vm.sqliteMirror == nil {
+		return errSQLiteMirrorDisabled
+	}
+	*reply = s.vm.sqliteMirror.Status()
+	return nil
+}
+
+// BackfillSQLiteMirrorArgs are the arguments to BackfillSQLiteMirror
+type BackfillSQLiteMirrorArgs struct {
+	// FromHeight is the height of the first block mirrored again
+	FromHeight json.Uint64 `json:"fromHeight"`
+}
+
+// BackfillSQLiteMirror mirrors the accepted blocks to SQLite again, starting
+// at [args.FromHeight], e.g. once rows were deleted
+func (s *AdminService) BackfillSQLiteMirror(_ *http.Request, args *BackfillSQLiteMirrorArgs, reply *api.SuccessResponse) error {
+	if s.vm.sqliteMirror == nil {
+		return errSQLiteMirrorDisabled
+	}
+	s.vm.sqliteMirror.Backfill(uint64(args.FromHeight))
+	s.vm.ctx.Log.Info("backfilling the SQLite mirror from height %d", args.FromHeight)
+	reply.Success = true
+	return nil
+}
+
+// GetMQTTStatus returns the state of publishing to MQTT
+func (s *AdminService) GetMQTTStatus(_ *http.Request, _ *struct{}, reply *ExportStatus) error {
+	if s.vm.mqtt == nil {
+		return errMQTTPublisherDisabled
+	}
+	*reply = s.vm.mqtt.Status()
+	return nil
+}
+
+// ArchiveBlocks starts moving the bodies of old blocks to the archive store
+func (s *AdminService) ArchiveBlocks(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if s.vm.archiver == nil {
+		return errArchiveDisabled
+	}
+	if err := s.vm.archiver.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetArchiveStatus returns the progress of archiving
+func (s *AdminService) GetArchiveStatus(_ *http.Request, _ *struct{}, reply *ArchiveStatus) error {
+	if s.vm.archiver == nil {
+		return errArchiveDisabled
+	}
+	*reply = s.vm.archiver.Status()
+	return nil
+}
+
+// RebuildIndexes starts rebuilding the accepted log, the child links and the
+// data and submitter indexes from the stored blocks. An interrupted rebuild
+// resumes when the VM restarts.
+func (s *AdminService) RebuildIndexes(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if err := s.vm.reindexer.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetRebuildIndexesStatus returns the progress of rebuilding the indexes
+func (s *AdminService) GetRebuildIndexesStatus(_ *http.Request, _ *struct{}, reply *JobStatus) error {
+	*reply = s.vm.reindexer.Status()
+	return nil
+}
+
+// RecompressBlocks starts rewriting the bodies of accepted blocks stored with
+// another compression than the configured one
+func (s *AdminService) RecompressBlocks(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if err := s.vm.recompressor.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetRecompressionStatus returns the progress of recompressing blocks
+func (s *AdminService) GetRecompressionStatus(_ *http.Request, _ *struct{}, reply *JobStatus) error {
+	*reply = s.vm.recompressor.Status()
+	return nil
+}
+
+// ApplyRetention starts dropping the bodies of the blocks outside of the
+// retention period of their namespace, instead of waiting for the next
+// scheduled run
+func (s *AdminService) ApplyRetention(_ *http.Request, _ *struct{}, reply *api.SuccessResponse) error {
+	if len(s.vm.config.NamespaceRetention) == 0 {
+		return errRetentionDisabled
+	}
+	if err := s.vm.retention.Trigger(); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetRetentionStatus returns the progress of the last run of the retention
+// policy, counted in namespaces
+func (s *AdminService) GetRetentionStatus(_ *http.Request, _ *struct{}, reply *JobStatus) error {
+	*reply = s.vm.retention.Status()
+	return nil
+}
+
+// LogLevels are the levels of the VM's logger
+type LogLevels struct {
+	// LogLevel is the lowest level of messages written to the log file
+	LogLevel *logging.Level `json:"logLevel,omitempty"`
+	// DisplayLevel is the lowest level of messages displayed on the console
+	DisplayLevel *logging.Level `json:"displayLevel,omitempty"`
+}
+
+// SetLogLevel changes the levels of the VM's logger until it restarts.
+// Omitted levels are left unchanged.
+func (s *AdminService) SetLogLevel(_ *http.Request, args *LogLevels, reply *api.SuccessResponse) error {
+	if args.LogLevel != nil {
+		s.vm.ctx.Log.SetLogLevel(*args.LogLevel)
+	}
+	if args.DisplayLevel != nil {
+		s.vm.ctx.Log.SetDisplayLevel(*args.DisplayLevel)
+	}
+	s.vm.ctx.Log.Info("log level set to %s, display level set to %s", s.vm.ctx.Log.GetLogLevel(), s.vm.ctx.Log.GetDisplayLevel())
+	reply.Success = true
+	return nil
+}
+
+// GetLogLevel returns the levels of the VM's logger
+func (s *AdminService) GetLogLevel(_ *http.Request, _ *struct{}, reply *LogLevels) error {
+	logLevel := s.vm.ctx.Log.GetLogLevel()
+	displayLevel := s.vm.ctx.Log.GetDisplayLevel()
+	reply.LogLevel = &logLevel
+	reply.DisplayLevel = &displayLevel
+	return nil
+}
+
+// LockMempoolArgs are the arguments to LockMempool
+type LockMempoolArgs struct {
+	// Locked refuses new submissions if true, and accepts them again if
+	// false
+	Locked bool `json:"locked"`
+}
+
+// LockMempool refuses or accepts again new submissions, e.g. to drain the
+// mempool before maintenance. Pending submissions are still put into blocks,
+// and the lock is lifted when the VM restarts.
+func (s *AdminService) LockMempool(_ *http.Request, args *LockMempoolArgs, reply *api.SuccessResponse) error {
+	s.vm.mempool.SetLocked(args.Locked)
+	s.vm.ctx.Log.Info("mempool locked: %t", args.Locked)
+	reply.Success = true
+	return nil
+}
+
+// SetMaintenanceModeArgs are the arguments to SetMaintenanceMode
+type SetMaintenanceModeArgs struct {
+	// Enabled pauses proposals if true, and resumes them if false
+	Enabled bool `json:"enabled"`
+	// Reason is returned to submitters along with the error while proposals
+	// are paused, e.g. when the service is expected back
+	Reason string `json:"reason"`
+}
+
+// SetMaintenanceMode pauses or resumes proposals, e.g. for a migration.
+// Queries keep working, and pending submissions are still put into blocks.
+// Maintenance mode ends when the VM restarts.
+func (s *AdminService) SetMaintenanceMode(_ *http.Request, args *SetMaintenanceModeArgs, reply *api.SuccessResponse) error {
+	s.vm.maintenance = args.Enabled
+	s.vm.maintenanceReason = ""
+	if args.Enabled {
+		s.vm.maintenanceReason = args.Reason
+		s.vm.ctx.Log.Info("proposals paused for maintenance: %s", args.Reason)
+	} else {
+		s.vm.ctx.Log.Info("proposals resumed")
+	}
+	reply.Success = true
+	return nil
+}
+
+// GetProcessingBlocksReply is the reply from GetProcessingBlocks
+type GetProcessingBlocksReply struct {
+	// Preferred is the ID of the block new blocks are built on
+	Preferred ids.ID `json:"preferred"`
+	// Blocks are the headers of the verified blocks which are neither
+	// accepted nor rejected yet, ordered by height
+	Blocks []GetBlockHeaderReply `json:"blocks"`
+}
+
+// GetProcessingBlocks returns the blocks consensus is deciding on and the
+// preference, e.g. to debug a stalled chain
+func (s *AdminService) GetProcessingBlocks(_ *http.Request, _ *struct{}, reply *GetProcessingBlocksReply) error {
+	reply.Preferred = s.vm.preferred
+	reply.Blocks = make([]GetBlockHeaderReply, 0, len(s.vm.verifiedBlocks))
+	for blkID, blk := range s.vm.verifiedBlocks {
+		header := GetBlockHeaderReply{}
+		if err := fillBlockHeaderReply(blkID, newBlockHeader(blk), &header); err != nil {
+			return err
+		}
+		reply.Blocks = append(reply.Blocks, header)
+	}
+	sort.Slice(reply.Blocks, func(i, j int) bool {
+		if reply.Blocks[i].Height != reply.Blocks[j].Height {
+			return reply.Blocks[i].Height < reply.Blocks[j].Height
+		}
+		return bytes.Compare(reply.Blocks[i].ID[:], reply.Blocks[j].ID[:]) < 0
+	})
+	return nil
+}
+
+// DumpStateReply is the reply from DumpState
+type DumpStateReply struct {
+	LastAccepted       ids.ID      `json:"lastAccepted"`
+	LastAcceptedHeight json.Uint64 `json:"lastAcceptedHeight"`
+	Preferred          ids.ID      `json:"preferred"`
+	// ProcessingBlocks are the verified blocks which are neither accepted
+	// nor rejected yet
+	ProcessingBlocks []ids.ID `json:"processingBlocks"`
+	MempoolSize      int      `json:"mempoolSize"`
+	MempoolLocked    bool     `json:"mempoolLocked"`
+	// Maintenance is true while proposals are paused for maintenance
+	Maintenance bool `json:"maintenance"`
+	// PrunedHeight is the height of the first block which wasn't pruned
+	PrunedHeight json.Uint64 `json:"prunedHeight"`
+	// ArchivedHeight is the height of the first block which isn't archived
+	ArchivedHeight json.Uint64 `json:"archivedHeight"`
+	// Config is the configuration the VM runs with
+	Config Config `json:"config"`
+}
+
+// DumpState returns the in-memory state of the VM and the positions of the
+// background jobs, for debugging
+func (s *AdminService) DumpState(_ *http.Request, _ *struct{}, reply *DumpStateReply) error {
+	vm := s.vm
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return err
+	}
+	prunedHeight, err := vm.state.GetPrunedHeight()
+	if err != nil {
+		return err
+	}
+	archivedHeight, err := vm.state.GetArchivedHeight()
+	if err != nil {
+		return err
+	}
+
+	reply.LastAccepted = lastAccepted.ID()
+	reply.LastAcceptedHeight = json.Uint64(lastAccepted.Height())
+	reply.Preferred = vm.preferred
+	reply.ProcessingBlocks = make([]ids.ID, 0, len(vm.verifiedBlocks))
+	for blkID := range vm.verifiedBlocks {
+		reply.ProcessingBlocks = append(reply.ProcessingBlocks, blkID)
+	}
+	ids.SortIDs(reply.ProcessingBlocks)
+	reply.MempoolSize = vm.mempool.Len()
+	reply.MempoolLocked = vm.mempool.Locked()
+	reply.Maintenance = vm.maintenance
+	reply.PrunedHeight = json.Uint64(prunedHeight)
+	reply.ArchivedHeight = json.Uint64(archivedHeight)
+	reply.Config = vm.config
+	return nil
+}
@@ -0,0 +1,241 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+)
+
+const (
+	// time to wait for the webhook to accept an alert
+	alertWebhookTimeout = 10 * time.Second
+	// number of alerts waiting to be posted, beyond which alerts are dropped
+	alertQueueSize = 64
+)
+
+// Alert rules
+const (
+	MempoolSizeAlert    = "mempoolSize"
+	BuildFailuresAlert  = "buildFailures"
+	VerifyFailuresAlert = "verifyFailures"
+	HealthFlapsAlert    = "healthFlaps"
+)
+
+// Alert is posted as JSON to the configured webhook when a rule starts or
+// stops being violated
+type Alert struct {
+	Time    time.Time `json:"time"`
+	ChainID ids.ID    `json:"chainID"`
+	// Rule is the name of the violated rule
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Resolved is true if the rule is no longer violated
+	Resolved bool `json:"resolved"`
+}
+
+// eventWindow holds the times of recent events
+type eventWindow struct {
+	times []time.Time
+}
+
+// Add records an event at [now]
+func (w *eventWindow) Add(now time.Time) {
+	w.times = append(w.times, now)
+}
+
+// Count returns the number of events within [window] before [now], and
+// forgets older events
+func (w *eventWindow) Count(now time.Time, window time.Duration) int {
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(w.times) && w.times[i].Before(cutoff) {
+		i++
+	}
+	w.times = w.times[i:]
+	return len(w.times)
+}
+
+// alerter raises alerts when the thresholds configured with the alert
+// settings are crossed. Alerts are logged and posted to the webhook, if one
+// is configured.
+type alerter struct {
+	vm     *VM
+	client *http.Client
+	// alerts waiting to be posted to the webhook, in the order they were
+	// raised
+	queue chan Alert
+
+	lock           sync.Mutex
+	buildFailures  eventWindow
+	verifyFailures eventWindow
+	healthFlaps    eventWindow
+	// health reported by the last health check, nil before the first one
+	healthy *bool
+	// rules currently violated
+	firing map[string]bool
+}
+
+// newAlerter returns an alerter for [vm]
+func newAlerter(vm *VM) *alerter {
+	return &alerter{
+		vm:     vm,
+		client: &http.Client{Timeout: alertWebhookTimeout},
+		queue:  make(chan Alert, alertQueueSize),
+		firing: map[string]bool{},
+	}
+}
+
+// enabled returns true if any alert rule is configured
+func (a *alerter) enabled() bool {
+	c := &a.vm.config
+	return c.AlertMempoolSize > 0 || c.AlertBuildFailures > 0 || c.AlertVerifyFailures > 0 || c.AlertHealthFlaps > 0
+}
+
+// BuildFailed records that building a block failed
+func (a *alerter) BuildFailed() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.buildFailures.Add(time.Now())
+}
+
+// VerifyFailed records that verifying a block failed
+func (a *alerter) VerifyFailed() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.verifyFailures.Add(time.Now())
+}
+
+// HealthChecked records the result of a health check, counting changes of
+// the health as flaps
+func (a *alerter) HealthChecked(healthy bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.healthy != nil && *a.healthy != healthy {
+		a.healthFlaps.Add(time.Now())
+	}
+	a.healthy = &healthy
+}
+
+// runPeriodically evaluates the alert rules every [vm.config.AlertInterval]
+// and posts the raised alerts until the VM shuts down
+func (a *alerter) runPeriodically() {
+	if url := a.vm.config.AlertWebhookURL; url != "" {
+		go a.deliver(url)
+	}
+
+	ticker := time.NewTicker(a.vm.config.AlertInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.vm.ctx.Lock.Lock()
+			if !a.vm.isShutdown() {
+				a.evaluate()
+			}
+			a.vm.ctx.Lock.Unlock()
+		case <-a.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// evaluate raises an alert for every rule which started being violated, and
+// resolves those which stopped. Must be called holding the context lock.
+func (a *alerter) evaluate() {
+	config := &a.vm.config
+	window := config.AlertWindow.Duration
+	now := time.Now()
+	mempoolSize := a.vm.mempool.Len()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.check(now, MempoolSizeAlert, config.AlertMempoolSize, mempoolSize,
+		"%d submissions pending, threshold %d")
+	a.check(now, BuildFailuresAlert, config.AlertBuildFailures, a.buildFailures.Count(now, window),
+		"%d blocks failed to build within "+window.String()+", threshold %d")
+	a.check(now, VerifyFailuresAlert, config.AlertVerifyFailures, a.verifyFailures.Count(now, window),
+		"%d blocks failed verification within "+window.String()+", threshold %d")
+	a.check(now, HealthFlapsAlert, config.AlertHealthFlaps, a.healthFlaps.Count(now, window),
+		"health changed %d times within "+window.String()+", threshold %d")
+}
+
+// check raises or resolves the alert [rule] if [value] reached [threshold]
+// or fell below it. A threshold of 0 disables the rule.
+func (a *alerter) check(now time.Time, rule string, threshold int, value int, format string) {
+	if threshold <= 0 {
+		return
+	}
+	violated := value >= threshold
+	if violated == a.firing[rule] {
+		return
+	}
+	a.firing[rule] = violated
+	a.raise(Alert{
+		Time:     now.UTC(),
+		ChainID:  a.vm.ctx.ChainID,
+		Rule:     rule,
+		Message:  fmt.Sprintf(format, value, threshold),
+		Resolved: !violated,
+	})
+}
+
+// raise logs [alert] and queues it to be posted to the webhook
+func (a *alerter) raise(alert Alert) {
+	if alert.Resolved {
+		a.vm.ctx.Log.Info("alert %s resolved: %s", alert.Rule, alert.Message)
+	} else {
+		a.vm.ctx.Log.Warn("alert %s: %s", alert.Rule, alert.Message)
+	}
+	if a.vm.config.AlertWebhookURL == "" {
+		return
+	}
+	select {
+	case a.queue <- alert:
+	default:
+		a.vm.ctx.Log.Warn("alert webhook is falling behind, dropping alert %s", alert.Rule)
+	}
+}
+
+// deliver posts the queued alerts to [url] one at a time, so the webhook
+// receives them in order, until the VM shuts down
+func (a *alerter) deliver(url string) {
+	for {
+		select {
+		case alert := <-a.queue:
+			a.post(url, alert)
+		case <-a.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// post posts [alert] as JSON to [url]
+func (a *alerter) post(url string, alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		a.vm.ctx.Log.Error("couldn't encode alert: %s", err)
+		return
+	}
+	resp, err := a.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		a.vm.ctx.Log.Warn("couldn't post alert %s: %s", alert.Rule, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		a.vm.ctx.Log.Warn("alert webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+var (
+	errAllowlistDisabled       = errors.New("the submitter allowlist is disabled on this chain")
+	errAllowlistWithoutSigning = errors.New("the submitter allowlist requires signed submissions")
+	errUnsignedSubmission      = errors.New("submissions must be signed on this chain")
+	errSubmitterNotAllowed     = errors.New("submitter isn't on the allowlist")
+	errNotAllowlistAdmin       = errors.New("allowlist updates must be signed by an allowlist admin")
+	errAllowlistUpdateData     = errors.New("block's data isn't the hash of its allowlist update")
+	errAllowlistUpdateNonce    = errors.New("allowlist update has the wrong nonce")
+	errEmptyAllowlistUpdate    = errors.New("allowlist update changes nothing")
+	errDuplicateAllowlistEntry = errors.New("allowlist update lists an address more than once")
+
+	_ BlockVerifier = &allowlistVerifier{}
+)
+
+// AllowlistUpdate adds and removes addresses of the submitter allowlist.
+// It's anchored in a block of its own, whose data is the hash of the update
+// and whose signature must be by an allowlist admin.
+type AllowlistUpdate struct {
+	// Nonce is the number of updates accepted before this one, so an update
+	// can't be replayed
+	Nonce uint64 `serialize:"true" json:"nonce"`
+	// Add are the addresses allowed to anchor data from now on
+	Add []ids.ShortID `serialize:"true" json:"add"`
+	// Remove are the addresses no longer allowed to anchor data
+	Remove []ids.ShortID `serialize:"true" json:"remove"`
+}
+
+// Verify returns nil iff [u] changes the allowlist and lists each address
+// once
+func (u *AllowlistUpdate) Verify() error {
+	if len(u.Add) == 0 && len(u.Remove) == 0 {
+		return errEmptyAllowlistUpdate
+	}
+	listed := make(map[ids.ShortID]bool, len(u.Add)+len(u.Remove))
+	for _, submitters := range [][]ids.ShortID{u.Add, u.Remove} {
+		for _, submitter := range submitters {
+			if listed[submitter] {
+				return fmt.Errorf("%w: %s", errDuplicateAllowlistEntry, submitter)
+			}
+			listed[submitter] = true
+		}
+	}
+	return nil
+}
+
+// AllowlistUpdateData returns the data of the block anchoring [update]
+func AllowlistUpdateData(update *AllowlistUpdate) ([dataLen]byte, error) {
+	updateBytes, err := Codec.Marshal(CodecVersion, update)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(updateBytes), nil
+}
+
+// AllowlistUpdateMessage returns the message an allowlist admin signs to
+// propose [update] to the chain [chainID]. It's the message signed to submit
+// the data of the block anchoring the update.
+func AllowlistUpdateMessage(chainID ids.ID, update *AllowlistUpdate) ([]byte, error) {
+	data, err := AllowlistUpdateData(update)
+	if err != nil {
+		return nil, err
+	}
+	return SubmissionMessage(chainID, data)
+}
+
+// allowlistAdmin returns true if [address] may sign allowlist updates
+func (vm *VM) allowlistAdmin(address ids.ShortID) bool {
+	for _, admin := range vm.config.SubmitterAllowlistAdmins {
+		if admin == address {
+			return true
+		}
+	}
+	return false
+}
+
+// allowlistVerifier requires blocks to be signed by a submitter on the
+// allowlist, and allowlist updates to be signed by an admin. The allowlist
+// in effect for a block is the accepted one, changed by the updates of its
+// processing ancestors.
+type allowlistVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (a *allowlistVerifier) VerifyBlock(blk *Block) error {
+	pending, err := a.pendingUpdates(blk.Parent())
+	if err != nil {
+		return err
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+
+	update := blk.AllowlistUpdate()
+	if update == nil {
+		if !blk.IsSigned() {
+			return errUnsignedSubmission
+		}
+		return a.verifyAllowed(pending, submitter)
+	}
+	if !blk.IsSigned() || !a.vm.allowlistAdmin(submitter) {
+		return errNotAllowlistAdmin
+	}
+	data, err := AllowlistUpdateData(update)
+	if err != nil {
+		return err
+	}
+	if blk.Data() != data {
+		return errAllowlistUpdateData
+	}
+	nonce, err := a.nonce(pending)
+	if err != nil {
+		return err
+	}
+	if update.Nonce != nonce {
+		return fmt.Errorf("%w: expected %d, but found %d", errAllowlistUpdateNonce, nonce, update.Nonce)
+	}
+	return update.Verify()
+}
+
+// pendingUpdates returns the allowlist updates of [blkID] and its processing
+// ancestors, the most recent first
+func (a *allowlistVerifier) pendingUpdates(blkID ids.ID) ([]*AllowlistUpdate, error) {
+	updates := []*AllowlistUpdate(nil)
+	for {
+		blk, err := a.vm.getBlock(blkID)
+		if err != nil {
+			return nil, errDatabaseGet
+		}
+		// Accepted updates are applied to the state
+		if blk.Status() == choices.Accepted {
+			return updates, nil
+		}
+		if update := blk.AllowlistUpdate(); update != nil {
+			updates = append(updates, update)
+		}
+		blkID = blk.Parent()
+	}
+}
+
+// verifyAllowed returns errSubmitterNotAllowed if [submitter] isn't on the
+// accepted allowlist changed by [pending]
+func (a *allowlistVerifier) verifyAllowed(pending []*AllowlistUpdate, submitter ids.ShortID) error {
+	for _, update := range pending {
+		for _, added := range update.Add {
+			if added == submitter {
+				return nil
+			}
+		}
+		for _, removed := range update.Remove {
+			if removed == submitter {
+				return fmt.Errorf("%w: %s", errSubmitterNotAllowed, submitter)
+			}
+		}
+	}
+	allowed, err := a.vm.state.IsAllowlisted(submitter)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s", errSubmitterNotAllowed, submitter)
+	}
+	return nil
+}
+
+// nonce returns the nonce of the update following the accepted ones and
+// [pending]
+func (a *allowlistVerifier) nonce(pending []*AllowlistUpdate) (uint64, error) {
+	nonce, err := a.vm.state.GetAllowlistNonce()
+	if err != nil && err != database.ErrNotFound {
+		return 0, err
+	}
+	return nonce + uint64(len(pending)), nil
+}
+
+// initAllowlist initializes the allowlist with [vm.config.SubmitterAllowlist]
+// unless it was initialized before
+func (vm *VM) initAllowlist() error {
+	switch _, err := vm.state.GetAllowlistNonce(); err {
+	case nil:
+		return nil
+	case database.ErrNotFound:
+	default:
+		return err
+	}
+	vm.ctx.Log.Info("initializing the submitter allowlist with %d addresses", len(vm.config.SubmitterAllowlist))
+	if err := vm.state.InitAllowlist(vm.config.SubmitterAllowlist); err != nil {
+		return err
+	}
+	return vm.committer.Flush()
+}
@@ -0,0 +1,353 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/cache"
+	"github.com/chain4travel/caminogo/cache/metercacher"
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+var (
+	_ ArchiveStore = &dirArchiveStore{}
+
+	errArchiveDisabled     = errors.New("archiving is disabled")
+	errNoArchiveStore      = errors.New("archiving requires an archive directory or store")
+	errArchivedBlockBadID  = errors.New("archived block doesn't match its ID")
+	errArchiveWithPruning  = errors.New("archiving and pruning can't be enabled together")
+	errArchiveRunning      = errors.New("archiving is already running")
+	errArchiveRetainBlocks = errors.New("archiving must retain at least one block")
+)
+
+// ArchiveStore is an object storage holding the bodies of archived blocks,
+// such as an S3 or GCS bucket. Keys are the string representation of block
+// IDs.
+type ArchiveStore interface {
+	// Put stores [value] under [key], replacing any previous value
+	Put(key string, value []byte) error
+	// Get returns the value stored under [key].
+	// Returns database.ErrNotFound if there is none.
+	Get(key string) ([]byte, error)
+}
+
+// dirArchiveStore implements ArchiveStore with a file per key in a directory,
+// which may be a mounted bucket
+type dirArchiveStore struct {
+	dir string
+}
+
+// NewDirArchiveStore returns an ArchiveStore keeping its values in [dir]
+func NewDirArchiveStore(dir string) (ArchiveStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &dirArchiveStore{dir: dir}, nil
+}
+
+// Put implements the ArchiveStore interface
+func (s *dirArchiveStore) Put(key string, value []byte) error {
+	path := filepath.Join(s.dir, key)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, value, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Get implements the ArchiveStore interface
+func (s *dirArchiveStore) Get(key string) ([]byte, error) {
+	value, err := os.ReadFile(filepath.Join(s.dir, key))
+	if os.IsNotExist(err) {
+		return nil, database.ErrNotFound
+	}
+	return value, err
+}
+
+// ArchiveStatus reports the progress of archiving
+type ArchiveStatus struct {
+	// Running is true while blocks are being archived
+	Running bool `json:"running"`
+	// ArchivedHeight is the height of the first block which isn't archived
+	ArchivedHeight json.Uint64 `json:"archivedHeight"`
+	// TargetHeight is the height the current (or last) run archives up to,
+	// exclusive
+	TargetHeight json.Uint64 `json:"targetHeight"`
+	// LastError is the error which aborted the last run, if any
+	LastError string `json:"lastError,omitempty"`
+}
+
+// archivedBody is the stored body of an accepted block being archived
+type archivedBody struct {
+	blkID ids.ID
+	body  []byte
+}
+
+// archiver moves the bodies of accepted blocks older than
+// [vm.config.ArchiveRetainBlocks] to an archive store and reads them back on
+// demand. Headers and indexes stay in the local database.
+type archiver struct {
+	vm    *VM
+	store ArchiveStore
+	// decompresses bodies read from the archive
+	compressor *blockCompressor
+	// holds blocks read from the archive
+	cache cache.Cacher
+
+	lock   sync.Mutex
+	status ArchiveStatus
+}
+
+// newArchiver returns an archiver for [vm] moving bodies to [store]
+func newArchiver(vm *VM, store ArchiveStore) (*archiver, error) {
+	compressor, err := newBlockCompressor(vm.config.BlockCompression)
+	if err != nil {
+		return nil, err
+	}
+	archiveCache, err := metercacher.New(
+		"archive_cache",
+		vm.registry,
+		&cache.LRU{Size: vm.config.ArchiveCacheSize},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &archiver{
+		vm:         vm,
+		store:      store,
+		compressor: compressor,
+		cache:      archiveCache,
+	}, nil
+}
+
+// GetBlock returns the archived block [blkID].
+// Returns database.ErrNotFound if the block isn't archived.
+func (a *archiver) GetBlock(blkID ids.ID) (*Block, error) {
+	if blk, cached := a.cache.Get(blkID); cached {
+		return blk.(*Block), nil
+	}
+	archived, err := a.vm.state.IsArchived(blkID)
+	if err != nil {
+		return nil, err
+	}
+	if !archived {
+		return nil, database.ErrNotFound
+	}
+	// The archived body of a redacted block isn't served anymore
+	switch _, err := a.vm.state.GetRedaction(blkID); err {
+	case nil:
+		return nil, database.ErrNotFound
+	case database.ErrNotFound:
+	default:
+		return nil, err
+	}
+	storedBytes, err := a.store.Get(blkID.String())
+	if err != nil {
+		return nil, err
+	}
+	blk, err := parseStoredBlock(a.compressor, storedBytes, a.vm)
+	if err != nil {
+		return nil, err
+	}
+	if blk.ID() != blkID {
+		return nil, errArchivedBlockBadID
+	}
+	a.cache.Put(blkID, blk)
+	return blk, nil
+}
+
+// Status returns the progress of archiving
+func (a *archiver) Status() ArchiveStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.status
+}
+
+// Trigger starts archiving in the background
+func (a *archiver) Trigger() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.status.Running {
+		return errArchiveRunning
+	}
+	a.status.Running = true
+	a.status.LastError = ""
+	go a.run()
+	return nil
+}
+
+// runPeriodically triggers archiving every [vm.config.ArchiveInterval] until
+// the VM shuts down
+func (a *archiver) runPeriodically() {
+	ticker := time.NewTicker(a.vm.config.ArchiveInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Trigger(); err != nil {
+				a.vm.ctx.Log.Debug("skipping scheduled archiving: %s", err)
+			}
+		case <-a.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// run archives batches of blocks until the retention limit is reached
+func (a *archiver) run() {
+	err := a.archive()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.status.Running = false
+	if err != nil {
+		a.status.LastError = err.Error()
+		a.vm.ctx.Log.Warn("archiving failed: %s", err)
+	}
+}
+
+// archive moves bodies to the archive store until the retention limit is
+// reached
+func (a *archiver) archive() error {
+	for {
+		bodies, nextHeight, done, err := a.readBatch()
+		if err != nil || done {
+			return err
+		}
+		// Uploading doesn't hold the context lock, as it may be slow
+		for _, archived := range bodies {
+			if a.vm.isShutdown() {
+				return nil
+			}
+			if err := a.store.Put(archived.blkID.String(), archived.body); err != nil {
+				return err
+			}
+		}
+		if err := a.deleteBatch(bodies, nextHeight); err != nil {
+			return err
+		}
+	}
+}
+
+// readBatch returns the stored bodies of up to [jobBatchSize] blocks which
+// are due to be archived, and the height of the first block after them.
+// Returns true if there is nothing left to archive.
+func (a *archiver) readBatch() ([]archivedBody, uint64, bool, error) {
+	a.vm.ctx.Lock.Lock()
+	defer a.vm.ctx.Lock.Unlock()
+
+	// The database is closed once the VM shut down
+	if a.vm.isShutdown() {
+		return nil, 0, true, nil
+	}
+
+	state := a.vm.state
+	archivedHeight, err := state.GetArchivedHeight()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	targetHeight, err := a.targetHeight()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	a.setProgress(archivedHeight, targetHeight)
+	if archivedHeight >= targetHeight {
+		return nil, 0, true, nil
+	}
+
+	bodies := []archivedBody(nil)
+	height := archivedHeight
+	for ; height < targetHeight && len(bodies) < jobBatchSize; height++ {
+		blkID, err := state.GetAcceptedID(height)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		body, err := state.GetBlockBody(blkID)
+		if err == database.ErrNotFound {
+			continue // the body was deleted before archiving was enabled
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+		bodies = append(bodies, archivedBody{
+			blkID: blkID,
+			body:  body,
+		})
+	}
+	return bodies, height, false, nil
+}
+
+// deleteBatch records [bodies] as archived, deletes them from the local
+// database and commits
+func (a *archiver) deleteBatch(bodies []archivedBody, nextHeight uint64) (err error) {
+	a.vm.ctx.Lock.Lock()
+	defer a.vm.ctx.Lock.Unlock()
+
+	if a.vm.isShutdown() {
+		return nil
+	}
+	if err := a.vm.committer.Flush(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			// don't let a later commit persist a partial batch
+			a.vm.state.Abort()
+		}
+	}()
+
+	state := a.vm.state
+	for _, archived := range bodies {
+		if err := state.MarkArchived(archived.blkID); err != nil {
+			return err
+		}
+		if err := state.DeleteBlockBody(archived.blkID); err != nil {
+			return err
+		}
+	}
+	if err := state.SetArchivedHeight(nextHeight); err != nil {
+		return err
+	}
+	if err := state.Commit(); err != nil {
+		return err
+	}
+	a.lock.Lock()
+	a.status.ArchivedHeight = json.Uint64(nextHeight)
+	a.lock.Unlock()
+	return nil
+}
+
+// targetHeight returns the height up to which blocks may be archived,
+// exclusive, according to [vm.config.ArchiveRetainBlocks]
+func (a *archiver) targetHeight() (uint64, error) {
+	lastAccepted, err := a.vm.lastAcceptedBlock()
+	if err != nil {
+		return 0, err
+	}
+	retain := a.vm.config.ArchiveRetainBlocks
+	if lastAccepted.Height()+1 < retain {
+		return 0, nil
+	}
+	return lastAccepted.Height() + 1 - retain, nil
+}
+
+// setProgress updates the reported progress
+func (a *archiver) setProgress(archivedHeight, targetHeight uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.status.ArchivedHeight = json.Uint64(archivedHeight)
+	a.status.TargetHeight = json.Uint64(targetHeight)
+}
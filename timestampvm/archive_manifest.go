@@ -0,0 +1,66 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	_ ArchiveManifest = &archiveManifest{}
+
+	// persists the height up to which blocks are archived with this key.
+	// It's shorter than block IDs, so it can't collide with them.
+	archivedHeightKey = []byte{0}
+)
+
+// ArchiveManifest records which accepted blocks had their body moved to the
+// archive store
+type ArchiveManifest interface {
+	// MarkArchived records that the body of [blkID] is held by the archive
+	MarkArchived(blkID ids.ID) error
+	// IsArchived returns true if the body of [blkID] is held by the archive
+	IsArchived(blkID ids.ID) (bool, error)
+
+	// GetArchivedHeight returns the height of the first accepted block which
+	// isn't archived, ignoring the genesis block which is never archived
+	GetArchivedHeight() (uint64, error)
+	SetArchivedHeight(height uint64) error
+}
+
+// archiveManifest implements ArchiveManifest with a database keyed by block ID
+type archiveManifest struct {
+	manifestDB database.Database
+}
+
+// NewArchiveManifest returns ArchiveManifest stored in the given db
+func NewArchiveManifest(db database.Database) ArchiveManifest {
+	return &archiveManifest{manifestDB: db}
+}
+
+// MarkArchived implements the ArchiveManifest interface
+func (m *archiveManifest) MarkArchived(blkID ids.ID) error {
+	return m.manifestDB.Put(blkID[:], nil)
+}
+
+// IsArchived implements the ArchiveManifest interface
+func (m *archiveManifest) IsArchived(blkID ids.ID) (bool, error) {
+	return m.manifestDB.Has(blkID[:])
+}
+
+// GetArchivedHeight implements the ArchiveManifest interface
+func (m *archiveManifest) GetArchivedHeight() (uint64, error) {
+	height, err := database.GetUInt64(m.manifestDB, archivedHeightKey)
+	if err == database.ErrNotFound {
+		// nothing is archived, the first block after genesis is the first one
+		return 1, nil
+	}
+	return height, err
+}
+
+// SetArchivedHeight implements the ArchiveManifest interface
+func (m *archiveManifest) SetArchivedHeight(height uint64) error {
+	return database.PutUInt64(m.manifestDB, archivedHeightKey, height)
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	errAuditLogTampered = errors.New("audit log was modified")
+
+	// the methods of the public API recorded in the audit log, as they
+	// change the state. All calls to the admin API are recorded. Arguments
+	// holding what must not be logged, like the content ProposeSaltedContent
+	// keeps off the chain, are recorded as [auditRedactor] returns them.
+	auditedMethods = map[string]bool{
+		Name + ".ProposeBlock":            true,
+		Name + ".ProposeAllowlistUpdate":  true,
+		Name + ".ProposeTransfer":         true,
+		Name + ".ProposeCreditGrant":      true,
+		Name + ".ProposeSchemaUpdate":     true,
+		Name + ".ProposeTravelDocument":   true,
+		Name + ".ProposeRedaction":        true,
+		Name + ".ProposeCommitment":       true,
+		Name + ".ProposeReveal":           true,
+		Name + ".RegisterRecipientKey":    true,
+		Name + ".ProposeEncryptedPayload": true,
+		Name + ".ProposeChainHead":        true,
+		Name + ".ProposeCID":              true,
+		Name + ".ProposeSaltedContent":    true,
+	}
+)
+
+// auditRedactor is implemented by arguments holding values which must not
+// be recorded in the audit log
+type auditRedactor interface {
+	// auditParams returns the arguments to record
+	auditParams() interface{}
+}
+
+// AuditEntry is an operation recorded in the audit log. Entries are chained
+// by their hashes, so modifying, removing or reordering entries is detected.
+type AuditEntry struct {
+	// Time the operation completed at
+	Time time.Time `json:"time"`
+	// Remote is the network address the operation was requested from
+	Remote string `json:"remote"`
+	// Method is the API method called, or the path requested
+	Method string `json:"method"`
+	// Params are the arguments of the call
+	Params stdjson.RawMessage `json:"params,omitempty"`
+	// Error is the error the operation failed with, if any
+	Error string `json:"error,omitempty"`
+	// PrevHash is the hash of the previous entry, empty for the first one
+	PrevHash string `json:"prevHash"`
+	// Hash is the SHA-256 hash of this entry without its hash, in hex
+	Hash string `json:"hash"`
+}
+
+// hash returns the hash of [e] without its hash
+func (e AuditEntry) hash() (string, error) {
+	e.Hash = ""
+	entryBytes, err := stdjson.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(entryBytes)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// auditLog appends AuditEntries to a file, one JSON object per line
+type auditLog struct {
+	lock     sync.Mutex
+	file     *os.File
+	lastHash string
+}
+
+// openAuditLog returns the audit log in the file [path], after verifying the
+// entries it already holds
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	lastHash, _, err := VerifyAuditLog(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("couldn't verify audit log %s: %w", path, err)
+	}
+	return &auditLog{
+		file:     file,
+		lastHash: lastHash,
+	}, nil
+}
+
+// VerifyAuditLog checks the hash chain of the audit log read from [r].
+// Returns the hash of the last entry and the number of entries.
+func VerifyAuditLog(r io.Reader) (string, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	lastHash := ""
+	count := 0
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		if err := stdjson.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", 0, fmt.Errorf("%w: entry %d isn't valid: %v", errAuditLogTampered, count, err)
+		}
+		hash, err := entry.hash()
+		if err != nil {
+			return "", 0, err
+		}
+		if entry.PrevHash != lastHash || entry.Hash != hash {
+			return "", 0, fmt.Errorf("%w: entry %d doesn't match its hash", errAuditLogTampered, count)
+		}
+		lastHash = hash
+		count++
+	}
+	return lastHash, count, scanner.Err()
+}
+
+// Record appends [entry] to the log
+func (l *auditLog) Record(entry AuditEntry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry.PrevHash = l.lastHash
+	hash, err := entry.hash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	entryBytes, err := stdjson.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(entryBytes, '\n')); err != nil {
+		return err
+	}
+	l.lastHash = hash
+	return nil
+}
+
+// Close closes the log
+func (l *auditLog) Close() error {
+	return l.file.Close()
+}
+
+// audit records the call of [method] with [params] by the client of [r] in
+// the audit log, if enabled
+func (vm *VM) audit(r *http.Request, method string, params interface{}, callErr error) {
+	if vm.auditLog == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:   time.Now().UTC(),
+		Remote: r.RemoteAddr,
+		Method: method,
+	}
+	if redactor, ok := params.(auditRedactor); ok {
+		params = redactor.auditParams()
+	}
+	if params != nil {
+		paramsBytes, err := stdjson.Marshal(params)
+		if err != nil {
+			vm.ctx.Log.Error("couldn't encode audited params of %s: %s", method, err)
+		} else {
+			entry.Params = paramsBytes
+		}
+	}
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	}
+	if err := vm.auditLog.Record(entry); err != nil {
+		vm.ctx.Log.Error("couldn't record %s in the audit log: %s", method, err)
+	}
+}
+
+// auditStatusRecorder records the status code of a response
+type auditStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditStatusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// auditHTTP returns [handler] recording every request in the audit log
+func (vm *VM) auditHTTP(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &auditStatusRecorder{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		handler.ServeHTTP(recorder, r)
+
+		var err error
+		if recorder.status >= http.StatusBadRequest {
+			err = fmt.Errorf("status %d", recorder.status)
+		}
+		vm.audit(r, r.URL.Path, r.URL.Query(), err)
+	})
+}
@@ -0,0 +1,284 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// header API keys may be presented in instead of as bearer token
+const apiKeyHeader = "X-API-Key"
+
+// Roles granted to API callers. Each role includes the ones before it.
+const (
+	// ReaderRole may call the methods of the public API which don't change
+	// the state
+	ReaderRole = "reader"
+	// ProposerRole may also propose data
+	ProposerRole = "proposer"
+	// AdminRole may also call the admin API
+	AdminRole = "admin"
+)
+
+var (
+	errEmptyToken   = errors.New("token file is empty")
+	errEmptyAPIKey  = errors.New("API keys must not be empty")
+	errNoAPIKeys    = errors.New("API keys file holds no keys")
+	errUnknownRole  = errors.New("unknown role")
+	errForbidden    = errors.New("forbidden")
+	errOpenAdminAPI = errors.New("the admin API requires an admin token or a key granting the admin role")
+
+	roleLevels = map[string]int{
+		ReaderRole:   1,
+		ProposerRole: 2,
+		AdminRole:    3,
+	}
+
+	// the methods of the public API which require the proposer role. The
+	// other methods only require the reader role.
+	proposerMethods = map[string]bool{
+		Name + ".ProposeBlock":            true,
+		Name + ".ProposeAllowlistUpdate":  true,
+		Name + ".ProposeTransfer":         true,
+		Name + ".ProposeCreditGrant":      true,
+		Name + ".ProposeSchemaUpdate":     true,
+		Name + ".ProposeTravelDocument":   true,
+		Name + ".ProposeRedaction":        true,
+		Name + ".ProposeCommitment":       true,
+		Name + ".ProposeReveal":           true,
+		Name + ".RegisterRecipientKey":    true,
+		Name + ".ProposeEncryptedPayload": true,
+		Name + ".ProposeSaltedContent":    true,
+		Name + ".ProposeChainHead":        true,
+		Name + ".ProposeCID":              true,
+	}
+)
+
+// callerKey is the request context key of the apiCaller making the request
+type callerKey struct{}
+
+// apiCaller is an authenticated caller of the APIs
+type apiCaller struct {
+	// name of the API key the caller presented
+	name string
+	role string
+}
+
+// apiKey is an API key, its name and the role it grants
+type apiKey struct {
+	key  []byte
+	name string
+	role string
+}
+
+// apiKeyName returns the name of API keys which aren't given one, derived
+// from the hash of [key] so it can be reported without revealing the key
+func apiKeyName(key []byte) string {
+	hash := sha256.Sum256(key)
+	return "key-" + hex.EncodeToString(hash[:4])
+}
+
+// hasRole returns true if [role] includes [required]
+func hasRole(role string, required string) bool {
+	return roleLevels[role] >= roleLevels[required]
+}
+
+// methodRole returns the role required to call [method]
+func methodRole(method string) string {
+	switch {
+	case strings.HasPrefix(method, "admin."):
+		return AdminRole
+	case proposerMethods[method]:
+		return ProposerRole
+	default:
+		return ReaderRole
+	}
+}
+
+// readToken returns the token held by the file at [path]
+func readToken(path string) ([]byte, error) {
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read token: %w", err)
+	}
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("%w: %s", errEmptyToken, path)
+	}
+	return token, nil
+}
+
+// readAPIKeys returns the keys listed in the file at [path], one per line as
+// "<key> [<role> [<name>]]". The role defaults to ProposerRole and the name to
+// apiKeyName. Empty lines and lines starting with "#" are ignored.
+func readAPIKeys(path string) ([]apiKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read API keys: %w", err)
+	}
+	keys := []apiKey(nil)
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) == 0 || fields[0][0] == '#' {
+			continue
+		}
+		key := apiKey{
+			key:  fields[0],
+			name: apiKeyName(fields[0]),
+			role: ProposerRole,
+		}
+		if len(fields) > 1 {
+			key.role = string(fields[1])
+		}
+		if len(fields) > 2 {
+			key.name = string(fields[2])
+		}
+		if _, ok := roleLevels[key.role]; !ok || len(fields) > 3 {
+			return nil, fmt.Errorf("%w %q in %s", errUnknownRole, bytes.Join(fields[1:], []byte(" ")), path)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoAPIKeys, path)
+	}
+	return keys, nil
+}
+
+// accessControl grants roles to API callers presenting an API key
+type accessControl struct {
+	keys []apiKey
+	// true if the public API requires a role, rather than only the admin
+	// API
+	public bool
+}
+
+// newAccessControl returns the access control configured for [vm]. The
+// admin token is an API key granting AdminRole.
+func (vm *VM) newAccessControl() (*accessControl, error) {
+	a := &accessControl{}
+	for _, key := range vm.config.APIKeys {
+		name := key.Name
+		if name == "" {
+			name = apiKeyName([]byte(key.Key))
+		}
+		a.keys = append(a.keys, apiKey{
+			key:  []byte(key.Key),
+			name: name,
+			role: ProposerRole,
+		})
+	}
+	if vm.config.APIKeysFile != "" {
+		fileKeys, err := readAPIKeys(vm.config.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = append(a.keys, fileKeys...)
+	}
+	a.public = len(a.keys) > 0
+
+	if vm.config.AdminAPIEnabled && vm.config.AdminTokenFile != "" {
+		token, err := readToken(vm.config.AdminTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = append(a.keys, apiKey{
+			key:  token,
+			name: AdminRole,
+			role: AdminRole,
+		})
+	}
+	return a, nil
+}
+
+// grants returns true if an API key grants [role]
+func (a *accessControl) grants(role string) bool {
+	for _, key := range a.keys {
+		if hasRole(key.role, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// caller returns the caller of [r], or nil if it presents no known API key
+func (a *accessControl) caller(r *http.Request) *apiCaller {
+	presented := []byte(r.Header.Get(apiKeyHeader))
+	if len(presented) == 0 {
+		presented = []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}
+	var caller *apiCaller
+	if len(presented) > 0 {
+		// compare with every key, so the time taken doesn't tell which key
+		// was close
+		for _, key := range a.keys {
+			if subtle.ConstantTimeCompare(presented, key.key) == 1 {
+				caller = &apiCaller{
+					name: key.name,
+					role: key.role,
+				}
+			}
+		}
+	}
+	return caller
+}
+
+// require returns [handler] rejecting requests whose caller isn't granted
+// [required]. The caller is passed on in the request context, so methods
+// requiring more can be refused by authorize.
+func (a *accessControl) require(required string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := a.caller(r)
+		switch {
+		case caller == nil:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		case !hasRole(caller.role, required):
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	})
+}
+
+// requestCaller returns the authenticated caller of [r], or nil if the API
+// is open
+func requestCaller(r *http.Request) *apiCaller {
+	caller, _ := r.Context().Value(callerKey{}).(*apiCaller)
+	return caller
+}
+
+// authorize returns an error if the caller of [r] isn't granted the role
+// required to call [method]. Callers of an open API aren't restricted.
+func authorize(r *http.Request, method string) error {
+	caller := requestCaller(r)
+	if caller == nil {
+		return nil
+	}
+	if required := methodRole(method); !hasRole(caller.role, required) {
+		return fmt.Errorf("%w: %s requires the %s role", errForbidden, method, required)
+	}
+	return nil
+}
+
+// requireToken returns [handler] rejecting requests which don't present
+// [token] as a bearer token
+func requireToken(token []byte, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(presented), token) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,300 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/database"
+)
+
+var (
+	_ BackupStore = &dirBackupStore{}
+
+	errBackupsDisabled = errors.New("backups are disabled")
+	errNoBackupStore   = errors.New("backups require a backup directory or store")
+	errBackupRetain    = errors.New("backups must retain at least one snapshot")
+)
+
+// BackupStore is an object storage holding the snapshots written by scheduled
+// backups, such as an S3 or GCS bucket
+type BackupStore interface {
+	// Put stores the content read from [r] under [name]. A failed Put must
+	// not leave a partial value behind.
+	Put(name string, r io.Reader) error
+	// List returns the names of all stored values
+	List() ([]string, error)
+	// Delete removes the value stored under [name]
+	Delete(name string) error
+}
+
+// dirBackupStore implements BackupStore with a file per snapshot in a
+// directory, which may be a mounted bucket
+type dirBackupStore struct {
+	dir string
+}
+
+// NewDirBackupStore returns a BackupStore keeping its snapshots in [dir]
+func NewDirBackupStore(dir string) (BackupStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &dirBackupStore{dir: dir}, nil
+}
+
+// Put implements the BackupStore interface
+func (s *dirBackupStore) Put(name string, r io.Reader) error {
+	path := filepath.Join(s.dir, name)
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// List implements the BackupStore interface
+func (s *dirBackupStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string(nil)
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// Delete implements the BackupStore interface
+func (s *dirBackupStore) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return database.ErrNotFound
+	}
+	return err
+}
+
+// BackupStatus reports the state of scheduled backups
+type BackupStatus struct {
+	// Running is true while a backup is being written
+	Running bool `json:"running"`
+	// Schedule is the configured schedule
+	Schedule string `json:"schedule"`
+	// NextRun is when the next scheduled backup starts
+	NextRun time.Time `json:"nextRun"`
+	// Name is the name of the current (or last) backup in the store
+	Name string `json:"name,omitempty"`
+	// Snapshot describes the current (or last) backup. Records is only known
+	// once it's written.
+	Snapshot SnapshotInfo `json:"snapshot"`
+	// LastError is the error which aborted the last backup, if any
+	LastError string `json:"lastError,omitempty"`
+	// Backups are the names of the stored backups, oldest first
+	Backups []string `json:"backups"`
+}
+
+// backupScheduler writes snapshots of the database to a backup store on a
+// schedule and deletes the oldest ones beyond [vm.config.BackupRetain]
+type backupScheduler struct {
+	vm       *VM
+	store    BackupStore
+	schedule *schedule
+
+	lock   sync.Mutex
+	status BackupStatus
+}
+
+// newBackupScheduler returns a backupScheduler for [vm] writing to [store]
+func newBackupScheduler(vm *VM, store BackupStore) (*backupScheduler, error) {
+	schedule, err := parseSchedule(vm.config.BackupSchedule)
+	if err != nil {
+		return nil, err
+	}
+	return &backupScheduler{
+		vm:       vm,
+		store:    store,
+		schedule: schedule,
+		status: BackupStatus{
+			Schedule: vm.config.BackupSchedule,
+		},
+	}, nil
+}
+
+// Status returns the state of scheduled backups, listing the stored backups
+func (b *backupScheduler) Status() (*BackupStatus, error) {
+	backups, err := b.listBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	status := b.status
+	status.Backups = backups
+	return &status, nil
+}
+
+// Trigger starts writing a backup of the current state.
+// Must be called holding the context lock.
+func (b *backupScheduler) Trigger() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.status.Running {
+		return errJobRunning
+	}
+	it, info, err := b.vm.openSnapshot()
+	if err != nil {
+		return err
+	}
+	name := snapshotFileName(info)
+	b.status.Running = true
+	b.status.Name = name
+	b.status.Snapshot = info
+	b.status.LastError = ""
+	go b.run(name, it, info)
+	return nil
+}
+
+// runPeriodically triggers backups on the configured schedule until the VM
+// shuts down
+func (b *backupScheduler) runPeriodically() {
+	for {
+		next := b.schedule.Next(time.Now())
+		b.lock.Lock()
+		b.status.NextRun = next
+		b.lock.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			b.vm.ctx.Lock.Lock()
+			// The database is closed once the VM shut down
+			if !b.vm.isShutdown() {
+				if err := b.Trigger(); err != nil {
+					b.vm.ctx.Log.Warn("skipping scheduled backup: %s", err)
+				}
+			}
+			b.vm.ctx.Lock.Unlock()
+		case <-b.vm.shutdownChan:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// run writes the snapshot read from [it] to the store as [name] and deletes
+// the backups beyond the retention count
+func (b *backupScheduler) run(name string, it database.Iterator, info SnapshotInfo) {
+	info, err := b.write(name, it, info)
+	if err == nil {
+		err = b.prune()
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.status.Running = false
+	b.status.Snapshot = info
+	if err != nil {
+		b.status.LastError = err.Error()
+		b.vm.ctx.Log.Warn("backup %s failed: %s", name, err)
+		return
+	}
+	b.vm.ctx.Log.Info("backed up height %d as %s", info.Height, name)
+}
+
+// write streams the snapshot read from [it] into the store as [name]
+func (b *backupScheduler) write(name string, it database.Iterator, info SnapshotInfo) (SnapshotInfo, error) {
+	pr, pw := io.Pipe()
+	written := make(chan SnapshotInfo, 1)
+	go func() {
+		info, err := b.vm.writeSnapshot(pw, it, info)
+		// a nil error ends the content read by the store
+		_ = pw.CloseWithError(err)
+		written <- info
+	}()
+	err := b.store.Put(name, pr)
+	// unblock the writer if the store stopped reading early
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	return <-written, err
+}
+
+// prune deletes the oldest backups beyond [vm.config.BackupRetain]
+func (b *backupScheduler) prune() error {
+	backups, err := b.listBackups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > b.vm.config.BackupRetain {
+		if err := b.store.Delete(backups[0]); err != nil && err != database.ErrNotFound {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// listBackups returns the names of the snapshots in the store, oldest first.
+// Other values in the store are ignored.
+func (b *backupScheduler) listBackups() ([]string, error) {
+	names, err := b.store.List()
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		name            string
+		height, created uint64
+	}
+	backups := []backup(nil)
+	for _, name := range names {
+		var height, created uint64
+		if _, err := fmt.Sscanf(name, "snapshot-%d-%d.tar", &height, &created); err != nil {
+			continue
+		}
+		if name != fmt.Sprintf("snapshot-%d-%d.tar", height, created) {
+			continue
+		}
+		backups = append(backups, backup{
+			name:    name,
+			height:  height,
+			created: created,
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].created != backups[j].created {
+			return backups[i].created < backups[j].created
+		}
+		return backups[i].height < backups[j].height
+	})
+	sorted := make([]string, len(backups))
+	for i, backup := range backups {
+		sorted[i] = backup.name
+	}
+	return sorted, nil
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/rpc/v2/json2"
+)
+
+var errMaxBatchSize = errors.New("maxBatchSize must not be negative")
+
+// batchError is the response to a batch which can't be served at all
+type batchError struct {
+	Version string       `json:"jsonrpc"`
+	Error   *json2.Error `json:"error"`
+	// always null, as the batch has no ID
+	ID *stdjson.RawMessage `json:"id"`
+}
+
+// serveBatches returns [handler] serving JSON-RPC batches as well as single
+// calls. The calls of a batch are served by [handler] one after the other,
+// as if they were sent one by one, and their responses are returned in an
+// array. Notifications get no response, as for single calls. Batches of more
+// than [vm.config.MaxBatchSize] calls are refused, so a single request
+// doesn't hold the context lock for long.
+func (vm *VM) serveBatches(handler http.Handler) http.Handler {
+	maxSize := vm.config.MaxBatchSize
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "couldn't read request body", http.StatusBadRequest)
+			return
+		}
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) == 0 || trimmed[0] != '[' {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		calls := []stdjson.RawMessage(nil)
+		if err := stdjson.Unmarshal(trimmed, &calls); err != nil {
+			writeBatchError(w, json2.E_PARSE, fmt.Sprintf("couldn't parse batch: %s", err))
+			return
+		}
+		switch {
+		case len(calls) == 0:
+			writeBatchError(w, json2.E_INVALID_REQ, "empty batch")
+			return
+		case len(calls) > maxSize:
+			writeBatchError(w, json2.E_INVALID_REQ, fmt.Sprintf("batch of %d calls exceeds the limit of %d", len(calls), maxSize))
+			return
+		}
+
+		responses := make([]stdjson.RawMessage, 0, len(calls))
+		for _, call := range calls {
+			callRequest := r.Clone(r.Context())
+			callRequest.Body = io.NopCloser(bytes.NewReader(call))
+			callRequest.ContentLength = int64(len(call))
+			response := &bufferedResponse{header: make(http.Header)}
+			handler.ServeHTTP(response, callRequest)
+
+			result := bytes.TrimSpace(response.body.Bytes())
+			switch {
+			case len(result) == 0:
+				// notification
+			case stdjson.Valid(result):
+				responses = append(responses, result)
+			default:
+				responses = append(responses, batchErrorResponse(json2.E_INTERNAL, string(result)))
+			}
+		}
+		if len(responses) == 0 {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = stdjson.NewEncoder(w).Encode(responses)
+	})
+}
+
+// writeBatchError responds to a batch which can't be served with the error
+// of [code] and [message]
+func writeBatchError(w http.ResponseWriter, code json2.ErrorCode, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(batchErrorResponse(code, message))
+}
+
+// batchErrorResponse returns the JSON-RPC response holding the error of
+// [code] and [message]
+func batchErrorResponse(code json2.ErrorCode, message string) stdjson.RawMessage {
+	response, _ := stdjson.Marshal(&batchError{
+		Version: json2.Version,
+		Error:   &json2.Error{Code: code, Message: message},
+	})
+	return response
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+var errJobRunning = errors.New("job is already running")
+
+// number of items jobs process per batch
+const jobBatchSize = 1024
+
+// JobStatus reports the progress of a background job
+type JobStatus struct {
+	// Running is true while the job is running
+	Running bool `json:"running"`
+	// Processed is the number of items the current (or last) run processed
+	Processed json.Uint64 `json:"processed"`
+	// Total is the number of items the current (or last) run processes
+	Total json.Uint64 `json:"total"`
+	// LastError is the error which aborted the last run, if any
+	LastError string `json:"lastError,omitempty"`
+}
+
+// batchStep processes the next batch of a job.
+// [processed] is the number of items processed by earlier batches of the
+// same run. Returns the number of items processed by this batch, the total
+// number of items of the run and whether the run is complete.
+type batchStep func(processed uint64) (batchProcessed uint64, total uint64, done bool, err error)
+
+// batchJob runs a task in the background, one batch at a time. Each batch
+// holds the context lock, so consensus keeps making progress in between.
+type batchJob struct {
+	vm   *VM
+	name string
+	// [newRun] returns the step function of a new run
+	newRun func() batchStep
+
+	lock   sync.Mutex
+	status JobStatus
+}
+
+// newBatchJob returns a job of [vm] called [name]
+func newBatchJob(vm *VM, name string, newRun func() batchStep) *batchJob {
+	return &batchJob{
+		vm:     vm,
+		name:   name,
+		newRun: newRun,
+	}
+}
+
+// Status returns the progress of the job
+func (j *batchJob) Status() JobStatus {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.status
+}
+
+// Trigger starts a run of the job in the background
+func (j *batchJob) Trigger() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.status.Running {
+		return errJobRunning
+	}
+	j.status = JobStatus{Running: true}
+	go j.run(j.newRun())
+	return nil
+}
+
+// Run runs the job synchronously. Must not be called holding the context lock.
+func (j *batchJob) Run() error {
+	j.lock.Lock()
+	if j.status.Running {
+		j.lock.Unlock()
+		return errJobRunning
+	}
+	j.status = JobStatus{Running: true}
+	j.lock.Unlock()
+
+	return j.run(j.newRun())
+}
+
+// run calls [step] until the run is complete
+func (j *batchJob) run(step batchStep) error {
+	err := j.runSteps(step)
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.status.Running = false
+	if err != nil {
+		j.status.LastError = err.Error()
+		j.vm.ctx.Log.Warn("%s failed: %s", j.name, err)
+	}
+	return err
+}
+
+// runSteps calls [step] while holding the context lock until the run is
+// complete or the VM shuts down
+func (j *batchJob) runSteps(step batchStep) error {
+	processed := uint64(0)
+	for {
+		batchProcessed, total, done, err := j.runStep(step, processed)
+		if err != nil {
+			return err
+		}
+		processed += batchProcessed
+
+		j.lock.Lock()
+		j.status.Processed = json.Uint64(processed)
+		j.status.Total = json.Uint64(total)
+		j.lock.Unlock()
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// runStep calls [step] holding the context lock
+func (j *batchJob) runStep(step batchStep, processed uint64) (uint64, uint64, bool, error) {
+	j.vm.ctx.Lock.Lock()
+	defer j.vm.ctx.Lock.Unlock()
+
+	// The database is closed once the VM shut down
+	if j.vm.isShutdown() {
+		return 0, 0, true, nil
+	}
+	if err := j.vm.committer.Flush(); err != nil {
+		return 0, 0, false, err
+	}
+	batchProcessed, total, done, err := step(processed)
+	if err != nil {
+		// don't let a later commit persist a partial batch
+		j.vm.state.Abort()
+	}
+	return batchProcessed, total, done, err
+}
@@ -15,8 +15,10 @@
 package timestampvm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/chain4travel/caminogo/ids"
@@ -29,6 +31,8 @@ var (
 	errTimestampTooEarly = errors.New("block's timestamp is earlier than its parent's timestamp")
 	errDatabaseGet       = errors.New("error while retrieving data from database")
 	errTimestampTooLate  = errors.New("block's timestamp is more than 1 hour ahead of local time")
+	errNonCanonicalBlock = errors.New("unsigned block is encoded with the signed codec version")
+	errDroppedBlockField = errors.New("block field isn't serialized by the codec version of the block")
 
 	_ snowman.Block = &Block{}
 )
@@ -39,22 +43,71 @@ var (
 // 2) Height
 // 3) Timestamp
 // 4) A piece of data (a string)
+// 5) Optionally, the submitter's signature of the data
+// 6) Optionally, an update of the submitter allowlist
+// 7) Optionally, the P-chain height the submitter is checked against
+// 8) Optionally, a transfer of funds paying fees
+// 9) Optionally, a proof of work over the data
+// 10) Optionally, a grant of prepaid credits
+// 11) Optionally, the namespace of the data
+// 12) Optionally, key=value tags of the data
+// 13) Optionally, an update of the payload schemas
+// 14) Optionally, a redaction of the data of an earlier block
+// 15) Optionally, the salt revealing the data as the value of a commitment
+// 16) Optionally, a registration of the key payloads are encrypted to
+// 17) Optionally, an encrypted payload whose hash is the data
 type Block struct {
-	PrntID ids.ID        `serialize:"true" json:"parentID"`  // parent's ID
-	Hght   uint64        `serialize:"true" json:"height"`    // This block's height. The genesis block is at height 0.
-	Tmstmp int64         `serialize:"true" json:"timestamp"` // Time this block was proposed at
-	Dt     [dataLen]byte `serialize:"true" json:"data"`      // Arbitrary data
+	PrntID ids.ID            `serialize:"true" json:"parentID"`                                 // parent's ID
+	Hght   uint64            `serialize:"true" json:"height"`                                   // This block's height. The genesis block is at height 0.
+	Tmstmp int64             `serialize:"true" json:"timestamp"`                                // Time this block was proposed at
+	Dt     [dataLen]byte     `serialize:"true" json:"data"`                                     // Arbitrary data
+	Sgntr  []byte            `serializeSigned:"true" json:"signature"`                          // Submitter's signature, only present in signed blocks
+	Updt   *AllowlistUpdate  `serializeAllowlist:"true" json:"allowlistUpdate,omitempty"`       // Update of the submitter allowlist, only present in allowlist blocks
+	PChnHt uint64            `serializeValidators:"true" json:"pChainHeight,omitempty"`         // P-chain height whose validators may submit, only present in validator blocks
+	Trnsfr *Transfer         `serializeTransfer:"true" json:"transfer,omitempty"`               // Transfer of funds, only present in transfer blocks
+	PrfWrk *ProofOfWork      `serializeProofOfWork:"true" json:"proofOfWork,omitempty"`         // Proof of work over the data, only present in proof of work blocks
+	Grnt   *CreditGrant      `serializeCreditGrant:"true" json:"creditGrant,omitempty"`         // Grant of prepaid credits, only present in credit grant blocks
+	Nmspc  string            `serializeNamespace:"true" json:"namespace,omitempty"`             // Namespace of the data, only present in namespaced blocks
+	Tgs    []Tag             `serializeTags:"true" json:"tags,omitempty"`                       // Tags of the data, only present in tagged blocks
+	Schm   *SchemaUpdate     `serializeSchema:"true" json:"schemaUpdate,omitempty"`             // Update of the payload schemas, only present in schema blocks
+	Rdctn  *Redaction        `serializeRedaction:"true" json:"redaction,omitempty"`             // Redaction of an earlier block's data, only present in redaction blocks
+	Rvl    *Reveal           `serializeReveal:"true" json:"reveal,omitempty"`                   // Reveal of an earlier commitment, only present in reveal blocks
+	KyRg   *KeyRegistration  `serializeKeyRegistration:"true" json:"keyRegistration,omitempty"` // Registration of a recipient key, only present in key registration blocks
+	Ncrptd *EncryptedPayload `serializeEncrypted:"true" json:"encryptedPayload,omitempty"`      // Encrypted payload, only present in encrypted blocks
 
-	id     ids.ID         // hold this block's ID
-	bytes  []byte         // this block's encoded bytes
-	status choices.Status // block's status
-	vm     *VM            // the underlying VM reference, mostly used for state
+	id         ids.ID          // hold this block's ID
+	bytes      []byte          // this block's encoded bytes
+	status     choices.Status  // block's status
+	vm         *VM             // the underlying VM reference, mostly used for state
+	local      bool            // true if this block was built by this node
+	submitter  ids.ShortID     // address recovered from [Sgntr], set on first use
+	traceCtx   context.Context // holds the span this block was built in, nil if built by another node
+	proposedAt time.Time       // time its data was proposed to this node, zero if built by another node
 }
 
 // Verify returns nil iff this block is valid.
 // To be valid, it must be that:
 // b.parent.Timestamp < b.Timestamp <= [local time] + 1 hour
+// and every verifier registered with the VM must accept it.
 func (b *Block) Verify() error {
+	defer observeSince(b.vm.metrics.verifyDuration, time.Now())
+
+	ctx, span := b.vm.tracer.Start(tracedContext(b.vm.runCtx, b.traceCtx), "Verify", b.traceAttributes()...)
+	err := b.verify(ctx)
+	endSpan(span, err)
+	if err != nil {
+		b.vm.alerter.VerifyFailed()
+	}
+	return err
+}
+
+// verify implements Verify, running the registered verifiers with [ctx]
+func (b *Block) verify(ctx context.Context) error {
+	// A replica serves the chain it was opened with, it can't accept blocks
+	if b.vm.config.ReadOnly {
+		return errReadOnly
+	}
+
 	// Get [b]'s parent
 	parentID := b.Parent()
 	parent, err := b.vm.getBlock(parentID)
@@ -82,6 +135,65 @@ func (b *Block) Verify() error {
 		return errTimestampTooLate
 	}
 
+	// Only data is filed under a namespace, operations aren't
+	if b.Nmspc != "" && b.isOperation() {
+		return errNamespacedOperation
+	}
+	if err := verifyNamespace(b.Nmspc); err != nil {
+		return err
+	}
+	if len(b.Tgs) > 0 && b.isOperation() {
+		return errTaggedOperation
+	}
+	if err := verifyTags(b.Tgs); err != nil {
+		return err
+	}
+	if err := verifyHashAlgorithm(declaredHashAlgorithm(b.Tgs)); err != nil {
+		return err
+	}
+
+	// Only chains with an allowlist accept updates of it
+	if b.Updt != nil && !b.vm.config.SubmitterAllowlistEnabled {
+		return errAllowlistDisabled
+	}
+	// Only chains charging fees accept transfers of the funds paying them
+	if (b.Trnsfr != nil || b.Grnt != nil) && !b.vm.config.FeesEnabled {
+		return errFeesDisabled
+	}
+	// Only chains with schema admins accept schemas
+	if _, declared := declaredSchema(b.Tgs); (b.Schm != nil || declared) && len(b.vm.config.SchemaAdmins) == 0 {
+		return errSchemasDisabled
+	}
+	// Only chains allowing redactions accept them
+	if b.Rdctn != nil && !b.vm.config.RedactionsEnabled {
+		return errRedactionsDisabled
+	}
+	// Only chains allowing encrypted payloads accept them and their keys
+	if (b.KyRg != nil || b.Ncrptd != nil) && !b.vm.config.EncryptedPayloadsEnabled {
+		return errEncryptedPayloadsDisabled
+	}
+	// Only chains requiring proofs of work accept them
+	if b.PrfWrk != nil && b.vm.config.ProofOfWorkBits == 0 {
+		return errProofOfWorkDisabled
+	}
+
+	// Ensure [b]'s signature, if any, is valid. It covers the namespace and
+	// tags of the data, so the submitter recovered from a submission moved
+	// into another namespace or retagged isn't the one who signed it.
+	if len(b.Sgntr) > 0 {
+		if !b.vm.config.SignedSubmissions {
+			return errSignedSubmissionsDisabled
+		}
+		if _, err := b.Submitter(); err != nil {
+			return err
+		}
+	}
+
+	// Ensure [b] satisfies the rules registered at construction
+	if err := b.vm.runVerifiers(ctx, b); err != nil {
+		return err
+	}
+
 	// Put that block to verified blocks in memory
 	b.vm.verifiedBlocks[b.ID()] = b
 
@@ -98,9 +210,66 @@ func (b *Block) Initialize(bytes []byte, status choices.Status, vm *VM) {
 }
 
 // Accept sets this block's status to Accepted and sets lastAccepted to this
-// block's ID and saves this info to b.vm.DB
+// block's ID and saves this info to b.vm.DB.
+// All writes are committed atomically, so a failure part way through leaves
+// the state as it was. If commits are grouped, a failure also discards the
+// writes of the blocks accepted since the last commit. Those blocks can't be
+// unaccepted in memory, so the VM fails every later Accept and commit with
+// errAcceptedWritesLost until it's restarted and fetches them again, as
+// after a crash.
 func (b *Block) Accept() error {
+	_, span := b.vm.tracer.Start(traceContext(b.traceCtx), "Accept", b.traceAttributes()...)
+	err := b.accept()
+	endSpan(span, err)
+	return err
+}
+
+// accept implements Accept
+func (b *Block) accept() error {
+	if err := b.vm.committer.Err(); err != nil {
+		return err
+	}
 	b.SetStatus(choices.Accepted) // Change state of this block
+	if err := b.stageAccept(); err != nil {
+		return b.abortAccept(err)
+	}
+
+	// Commit changes to database, possibly along with later blocks
+	if err := b.vm.committer.Accepted(); err != nil {
+		return b.abortAccept(err)
+	}
+
+	// Delete this block from verified blocks as it's accepted
+	delete(b.vm.verifiedBlocks, b.ID())
+	b.vm.lastAcceptTime = time.Now()
+	if !b.proposedAt.IsZero() {
+		b.vm.acceptLatencies.Add(b.vm.lastAcceptTime.Sub(b.proposedAt))
+	}
+	b.vm.metrics.accepted.Inc()
+	if b.vm.ipfs != nil {
+		b.vm.ipfs.Accepted(b)
+	}
+	if b.vm.mqtt != nil {
+		b.vm.mqtt.Accepted()
+	}
+	return nil
+}
+
+// abortAccept discards the writes staged by an Accept which failed with
+// [err], so they can't be committed along with a later change, and returns
+// the error Accept fails with. If the writes of earlier accepted blocks were
+// discarded with them, that's errAcceptedWritesLost.
+func (b *Block) abortAccept(err error) error {
+	b.vm.state.Abort()
+	b.SetStatus(choices.Processing)
+	if lostErr := b.vm.committer.Discard(); lostErr != nil {
+		return fmt.Errorf("%w: %s", lostErr, err)
+	}
+	return err
+}
+
+// stageAccept stages all writes accepting this block, without committing them
+func (b *Block) stageAccept() error {
 	blkID := b.ID()
 
 	// Persist data
@@ -113,24 +282,144 @@ func (b *Block) Accept() error {
 		return err
 	}
 
-	// Delete this block from verified blocks as it's accepted
-	delete(b.vm.verifiedBlocks, b.ID())
+	// Append this block to the log of accepted blocks
+	if err := b.vm.state.AppendAccepted(b.Height(), blkID); err != nil {
+		return err
+	}
+
+	// Link this block to its parent, the genesis block has none
+	if b.Height() > 0 {
+		if err := b.vm.state.PutAcceptedChild(b.Parent(), blkID); err != nil {
+			return err
+		}
+	}
+
+	// Make this block's data discoverable
+	if err := b.vm.state.IndexData(b); err != nil {
+		return err
+	}
+
+	// Apply this block's update of the submitter allowlist
+	if b.Updt != nil {
+		if err := b.vm.state.ApplyAllowlistUpdate(b.Updt); err != nil {
+			return err
+		}
+	}
+	// Apply this block's update of the payload schemas
+	if b.Schm != nil {
+		if err := b.vm.state.ApplySchemaUpdate(b.Schm); err != nil {
+			return err
+		}
+	}
+	// Erase the data of the block this block redacts
+	if b.Rdctn != nil {
+		if err := b.vm.applyRedaction(b); err != nil {
+			return err
+		}
+	}
+	// Link the commitment this block reveals to it
+	if b.Rvl != nil {
+		commitment := Commitment(b.Rvl.Salt, b.Data())
+		if err := b.vm.state.IndexReveal(DataHash(commitment), blkID); err != nil {
+			return err
+		}
+	}
+	// Store the key this block registers
+	if b.KyRg != nil {
+		if err := b.vm.applyKeyRegistration(b); err != nil {
+			return err
+		}
+	}
+
+	// Charge this block's fee and apply its transfer. Whether it's free
+	// depends on the anchors counted before it.
+	if b.vm.config.FeesEnabled {
+		fee, err := b.vm.chargeFees(b.vm.state, b)
+		if err != nil {
+			return err
+		}
+		if err := b.vm.recordFee(fee); err != nil {
+			return err
+		}
+	}
+	if err := b.vm.recordAnchor(b); err != nil {
+		return err
+	}
 
-	// Commit changes to database
-	return b.vm.state.Commit()
+	// List this block under its namespace and its tags
+	if err := b.vm.indexNamespace(b); err != nil {
+		return err
+	}
+	// List this block under the chain head it anchors
+	if err := b.vm.indexChainHead(b); err != nil {
+		return err
+	}
+
+	// List this block under its submitter
+	if !b.IsSigned() {
+		return nil
+	}
+	submitter, err := b.Submitter()
+	if err != nil {
+		return err
+	}
+	return b.vm.state.IndexSubmitter(submitter, b.Height(), blkID)
 }
 
 // Reject sets this block's status to Rejected and saves the status in state
+// If this node built the block, its data is proposed again so it isn't lost
 // Recall that b.vm.DB.Commit() must be called to persist to the DB
 func (b *Block) Reject() error {
+	_, span := b.vm.tracer.Start(traceContext(b.traceCtx), "Reject", b.traceAttributes()...)
+	err := b.reject()
+	endSpan(span, err)
+	return err
+}
+
+// reject implements Reject
+func (b *Block) reject() error {
 	b.SetStatus(choices.Rejected) // Change state of this block
 	if err := b.vm.state.PutBlock(b); err != nil {
 		return err
 	}
 	// Delete this block from verified blocks as it's rejected
 	delete(b.vm.verifiedBlocks, b.ID())
-	// Commit changes to database
-	return b.vm.state.Commit()
+
+	// Give the data of our own block another chance to be accepted
+	sub := &submission{
+		data:       b.Dt,
+		sig:        b.Sgntr,
+		update:     b.Updt,
+		transfer:   b.Trnsfr,
+		pow:        b.PrfWrk,
+		grant:      b.Grnt,
+		namespace:  b.Nmspc,
+		tags:       b.Tgs,
+		schema:     b.Schm,
+		redaction:  b.Rdctn,
+		reveal:     b.Rvl,
+		keyReg:     b.KyRg,
+		encrypted:  b.Ncrptd,
+		traceCtx:   b.traceCtx,
+		proposedAt: b.proposedAt,
+	}
+	if b.local && b.vm.requeueSubmission(sub) {
+		b.vm.ctx.Log.Debug("requeued data of rejected block %s", b.ID())
+	}
+	// Commit changes to database, along with the pending accepted blocks
+	if err := b.vm.committer.Flush(); err != nil {
+		return err
+	}
+	b.vm.metrics.rejected.Inc()
+	return nil
+}
+
+// traceAttributes returns the attributes of the spans tracing this block
+func (b *Block) traceAttributes() []Attribute {
+	return []Attribute{
+		{Key: "blkID", Value: b.id},
+		{Key: "height", Value: b.Hght},
+	}
 }
 
 // ID returns the ID of this block
@@ -154,5 +443,156 @@ func (b *Block) Bytes() []byte { return b.bytes }
 // Data returns the data of this block
 func (b *Block) Data() [dataLen]byte { return b.Dt }
 
+// AllowlistUpdate returns the update of the submitter allowlist this block
+// anchors, or nil if it anchors data
+func (b *Block) AllowlistUpdate() *AllowlistUpdate { return b.Updt }
+
+// Transfer returns the transfer of funds this block anchors, or nil if it
+// doesn't anchor one
+func (b *Block) Transfer() *Transfer { return b.Trnsfr }
+
+// SchemaUpdate returns the update of the payload schemas this block anchors,
+// or nil if it anchors none
+func (b *Block) SchemaUpdate() *SchemaUpdate { return b.Schm }
+
+// Redaction returns the redaction of an earlier block's data this block
+// anchors, or nil if it anchors none
+func (b *Block) Redaction() *Redaction { return b.Rdctn }
+
+// Reveal returns the reveal of an earlier commitment this block's data is the
+// value of, or nil if it reveals none
+func (b *Block) Reveal() *Reveal { return b.Rvl }
+
+// KeyRegistration returns the registration of a recipient key this block
+// anchors, or nil if it anchors none
+func (b *Block) KeyRegistration() *KeyRegistration { return b.KyRg }
+
+// EncryptedPayload returns the encrypted payload whose hash is this block's
+// data, or nil if it anchors none
+func (b *Block) EncryptedPayload() *EncryptedPayload { return b.Ncrptd }
+
+// CreditGrant returns the grant of prepaid credits this block anchors, or nil
+// if it anchors none
+func (b *Block) CreditGrant() *CreditGrant { return b.Grnt }
+
+// isOperation returns true if this block's data is the hash of an operation
+// on the chain's state, rather than data of a submitter
+func (b *Block) isOperation() bool {
+	return b.Updt != nil || b.Trnsfr != nil || b.Grnt != nil || b.Schm != nil || b.Rdctn != nil || b.KyRg != nil
+}
+
+// Namespace returns the namespace of this block's data, or the empty string
+// if it has none
+func (b *Block) Namespace() string { return b.Nmspc }
+
+// Tags returns the tags of this block's data, sorted by key
+func (b *Block) Tags() []Tag { return b.Tgs }
+
+// ProofOfWork returns the proof of work over this block's data, or nil if it
+// carries none
+func (b *Block) ProofOfWork() *ProofOfWork { return b.PrfWrk }
+
+// PChainHeight returns the P-chain height whose validators may have
+// submitted this block, or 0 if it isn't restricted to validators
+func (b *Block) PChainHeight() uint64 { return b.PChnHt }
+
+// IsSigned returns true if this block carries a submitter's signature
+func (b *Block) IsSigned() bool { return len(b.Sgntr) > 0 }
+
+// Submitter returns the address whose key signed this block's data, along
+// with its namespace and tags.
+// Returns ids.ShortEmpty if the block is unsigned.
+func (b *Block) Submitter() (ids.ShortID, error) {
+	if !b.IsSigned() || b.submitter != ids.ShortEmpty {
+		return b.submitter, nil
+	}
+	submitter, err := recoverSubmitter(b.vm.ctx.ChainID, b.Dt, b.Nmspc, b.Tgs, b.Sgntr)
+	if err != nil {
+		return ids.ShortEmpty, err
+	}
+	b.submitter = submitter
+	return submitter, nil
+}
+
+// codecVersion returns the codec version this block is encoded with.
+// Unsigned blocks keep the original encoding.
+func (b *Block) codecVersion() uint16 {
+	switch {
+	case b.Updt != nil:
+		return AllowlistCodecVersion
+	case b.Trnsfr != nil:
+		return TransferCodecVersion
+	case b.Grnt != nil:
+		return CreditGrantCodecVersion
+	case b.Schm != nil:
+		return SchemaCodecVersion
+	case b.Rdctn != nil:
+		return RedactionCodecVersion
+	case b.KyRg != nil:
+		return KeyRegistrationCodecVersion
+	case b.Rvl != nil && b.PrfWrk != nil:
+		return RevealProofOfWorkCodecVersion
+	case b.Rvl != nil:
+		return RevealCodecVersion
+	case b.Ncrptd != nil && b.PrfWrk != nil:
+		return EncryptedProofOfWorkCodecVersion
+	case b.Ncrptd != nil:
+		return EncryptedCodecVersion
+	case len(b.Tgs) > 0 && b.PrfWrk != nil:
+		return TagsProofOfWorkCodecVersion
+	case len(b.Tgs) > 0:
+		return TagsCodecVersion
+	case b.Nmspc != "" && b.PrfWrk != nil:
+		return NamespaceProofOfWorkCodecVersion
+	case b.Nmspc != "":
+		return NamespaceCodecVersion
+	case b.PChnHt > 0:
+		return ValidatorsCodecVersion
+	case b.PrfWrk != nil:
+		return ProofOfWorkCodecVersion
+	case b.IsSigned():
+		return SignedCodecVersion
+	default:
+		return CodecVersion
+	}
+}
+
+// marshal returns the bytes of this block in its codec version. A codec
+// version only serializes the fields tagged for it, and silently leaves out
+// the others, so the bytes are parsed back and compared with this block:
+// a field set on one of them but not on the other fails with
+// errDroppedBlockField, instead of being lost to the nodes parsing the block.
+func (b *Block) marshal() ([]byte, error) {
+	version := b.codecVersion()
+	blockBytes, err := Codec.Marshal(version, b)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &Block{}
+	if _, err := Codec.Unmarshal(blockBytes, parsed); err != nil {
+		return nil, err
+	}
+	original, decoded := reflect.ValueOf(b).Elem(), reflect.ValueOf(parsed).Elem()
+	for i := 0; i < original.NumField(); i++ {
+		field := original.Type().Field(i)
+		if field.PkgPath != "" {
+			continue // unexported, not serialized
+		}
+		if isEmptyField(original.Field(i)) != isEmptyField(decoded.Field(i)) {
+			return nil, fmt.Errorf("%w: %s in version %d", errDroppedBlockField, field.Name, version)
+		}
+	}
+	return blockBytes, nil
+}
+
+// isEmptyField returns true if the field [value] is unset. Empty slices are
+// unset, as the codec decodes nil slices as empty ones.
+func isEmptyField(value reflect.Value) bool {
+	if value.Kind() == reflect.Slice {
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
 // SetStatus sets the status of this block
 func (b *Block) SetStatus(status choices.Status) { b.status = status }
@@ -16,6 +16,7 @@ package timestampvm
 
 import (
 	"github.com/chain4travel/caminogo/cache"
+	"github.com/chain4travel/caminogo/cache/metercacher"
 	"github.com/chain4travel/caminogo/database"
 	"github.com/chain4travel/caminogo/ids"
 	"github.com/chain4travel/caminogo/snow/choices"
@@ -23,32 +24,51 @@ import (
 
 const (
 	lastAcceptedByte byte = iota
+	prunedHeightByte
 )
 
-const (
-	// maximum block capacity of the cache
-	blockCacheSize = 8192
+var (
+	// persists lastAccepted block IDs with this key
+	lastAcceptedKey = []byte{lastAcceptedByte}
+	// persists the height up to which blocks are pruned with this key
+	prunedHeightKey = []byte{prunedHeightByte}
 )
 
-// persists lastAccepted block IDs with this key
-var lastAcceptedKey = []byte{lastAcceptedByte}
-
 var _ BlockState = &blockState{}
 
 // BlockState defines methods to manage state with Blocks and LastAcceptedIDs.
+// Blocks are stored as a header and a body, so the (larger) body can be
+// deleted while the header is retained.
 type BlockState interface {
 	GetBlock(blkID ids.ID) (*Block, error)
+	GetBlockHeader(blkID ids.ID) (*BlockHeader, error)
+	// GetBlockBody returns the body of a block as stored, possibly compressed
+	GetBlockBody(blkID ids.ID) ([]byte, error)
 	PutBlock(blk *Block) error
+	DeleteBlock(blkID ids.ID) error
+	DeleteBlockBody(blkID ids.ID) error
+	// RecompressBlock stores the body of the block with the configured
+	// compression, if it isn't already. Does nothing if the body was deleted.
+	RecompressBlock(blkID ids.ID) error
 	GetLastAccepted() (ids.ID, error)
 	SetLastAccepted(ids.ID) error
+
+	// GetPrunedHeight returns the height of the first accepted block which
+	// isn't pruned, ignoring the genesis block which is never pruned
+	GetPrunedHeight() (uint64, error)
+	SetPrunedHeight(height uint64) error
 }
 
 // blockState implements BlocksState interface with database and cache.
 type blockState struct {
 	// cache to store blocks
 	blkCache cache.Cacher
-	// block database
-	blockDB      database.Database
+	// block database, holds the block bodies
+	blockDB database.Database
+	// compresses block bodies
+	compressor *blockCompressor
+	// block header database
+	headerDB     database.Database
 	lastAccepted ids.ID
 
 	// vm reference
@@ -61,15 +81,52 @@ type blkWrapper struct {
 	Status choices.Status `serialize:"true"`
 }
 
-// NewBlockState returns BlockState with a new cache and given db
-func NewBlockState(db database.Database, vm *VM) BlockState {
-	return &blockState{
-		blkCache: &cache.LRU{Size: blockCacheSize},
-		blockDB:  db,
-		vm:       vm,
+// BlockHeader is the part of a block which is retained when its body is
+// deleted. The data itself is replaced by its hash.
+type BlockHeader struct {
+	PrntID   ids.ID         `serialize:"true" json:"parentID"`
+	Hght     uint64         `serialize:"true" json:"height"`
+	Tmstmp   int64          `serialize:"true" json:"timestamp"`
+	DataHash ids.ID         `serialize:"true" json:"dataHash"`
+	Status   choices.Status `serialize:"true" json:"status"`
+}
+
+// newBlockHeader returns the header of [blk]
+func newBlockHeader(blk *Block) *BlockHeader {
+	return &BlockHeader{
+		PrntID:   blk.Parent(),
+		Hght:     blk.Height(),
+		Tmstmp:   blk.Tmstmp,
+		DataHash: DataHash(blk.Data()),
+		Status:   blk.Status(),
 	}
 }
 
+// NewBlockState returns BlockState with a new cache and given dbs.
+// The cache holds at most [vm.config.BlockCacheSize] blocks and reports its
+// hits and misses to [vm.registry].
+func NewBlockState(db database.Database, headerDB database.Database, vm *VM) (BlockState, error) {
+	blkCache, err := metercacher.New(
+		"block_cache",
+		vm.registry,
+		&cache.LRU{Size: vm.config.BlockCacheSize},
+	)
+	if err != nil {
+		return nil, err
+	}
+	compressor, err := newBlockCompressor(vm.config.BlockCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &blockState{
+		blkCache:   blkCache,
+		blockDB:    db,
+		compressor: compressor,
+		headerDB:   headerDB,
+		vm:         vm,
+	}, nil
+}
+
 // GetBlock gets Block from either cache or database
 func (s *blockState) GetBlock(blkID ids.ID) (*Block, error) {
 	// Check if cache has this blkID
@@ -83,7 +140,7 @@ func (s *blockState) GetBlock(blkID ids.ID) (*Block, error) {
 	}
 
 	// get block bytes from db with the blkID key
-	wrappedBytes, err := s.blockDB.Get(blkID[:])
+	storedBytes, err := s.blockDB.Get(blkID[:])
 	if err != nil {
 		// we could not find it in the db, let's cache this blkID with nil value
 		// so next time we try to fetch the same key we can return error
@@ -94,6 +151,23 @@ func (s *blockState) GetBlock(blkID ids.ID) (*Block, error) {
 		// could not find the block, return error
 		return nil, err
 	}
+	blk, err := parseStoredBlock(s.compressor, storedBytes, s.vm)
+	if err != nil {
+		return nil, err
+	}
+
+	// put block into cache
+	s.blkCache.Put(blkID, blk)
+
+	return blk, nil
+}
+
+// parseStoredBlock returns the block whose stored body is [storedBytes]
+func parseStoredBlock(compressor *blockCompressor, storedBytes []byte, vm *VM) (*Block, error) {
+	wrappedBytes, err := compressor.Decompress(storedBytes)
+	if err != nil {
+		return nil, err
+	}
 
 	// first decode/unmarshal the block wrapper so we can have status and block bytes
 	blkw := blkWrapper{}
@@ -108,14 +182,15 @@ func (s *blockState) GetBlock(blkID ids.ID) (*Block, error) {
 	}
 
 	// initialize block with block bytes, status and vm
-	blk.Initialize(blkw.Blk, blkw.Status, s.vm)
-
-	// put block into cache
-	s.blkCache.Put(blkID, blk)
-
+	blk.Initialize(blkw.Blk, blkw.Status, vm)
 	return blk, nil
 }
 
+// GetBlockBody implements the BlockState interface
+func (s *blockState) GetBlockBody(blkID ids.ID) ([]byte, error) {
+	return s.blockDB.Get(blkID[:])
+}
+
 // PutBlock puts block into both database and cache
 func (s *blockState) PutBlock(blk *Block) error {
 	// create block wrapper with block bytes and status
@@ -130,20 +205,108 @@ func (s *blockState) PutBlock(blk *Block) error {
 		return err
 	}
 
+	storedBytes, err := s.compressor.Compress(wrappedBytes)
+	if err != nil {
+		return err
+	}
+	headerBytes, err := Codec.Marshal(CodecVersion, newBlockHeader(blk))
+	if err != nil {
+		return err
+	}
+
 	blkID := blk.ID()
 	// put actual block to cache, so we can directly fetch it from cache
 	s.blkCache.Put(blkID, blk)
 
-	// put wrapped block bytes into database
-	return s.blockDB.Put(blkID[:], wrappedBytes)
+	// put header and wrapped block bytes into database
+	if err := s.headerDB.Put(blkID[:], headerBytes); err != nil {
+		return err
+	}
+	return s.blockDB.Put(blkID[:], storedBytes)
+}
+
+// RecompressBlock implements the BlockState interface
+func (s *blockState) RecompressBlock(blkID ids.ID) error {
+	storedBytes, err := s.blockDB.Get(blkID[:])
+	if err == database.ErrNotFound {
+		return nil // the body was pruned
+	}
+	if err != nil || s.compressor.IsCurrent(storedBytes) {
+		return err
+	}
+	wrappedBytes, err := s.compressor.Decompress(storedBytes)
+	if err != nil {
+		return err
+	}
+	storedBytes, err = s.compressor.Compress(wrappedBytes)
+	if err != nil {
+		return err
+	}
+	return s.blockDB.Put(blkID[:], storedBytes)
+}
+
+// GetBlockHeader gets the header of a block, even if its body was deleted
+func (s *blockState) GetBlockHeader(blkID ids.ID) (*BlockHeader, error) {
+	headerBytes, err := s.headerDB.Get(blkID[:])
+	if err == database.ErrNotFound {
+		// blocks stored before headers were split off only have a body
+		blk, err := s.GetBlock(blkID)
+		if err != nil {
+			return nil, err
+		}
+		return newBlockHeader(blk), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := &BlockHeader{}
+	if _, err := Codec.Unmarshal(headerBytes, header); err != nil {
+		return nil, err
+	}
+	return header, nil
 }
 
 // DeleteBlock deletes block from both cache and database
 func (s *blockState) DeleteBlock(blkID ids.ID) error {
+	if err := s.DeleteBlockBody(blkID); err != nil {
+		return err
+	}
+	return s.headerDB.Delete(blkID[:])
+}
+
+// DeleteBlockBody deletes the block from both cache and database, but keeps
+// its header
+func (s *blockState) DeleteBlockBody(blkID ids.ID) error {
+	// make sure the header outlives the body of blocks stored before headers
+	// were split off
+	hasHeader, err := s.headerDB.Has(blkID[:])
+	if err != nil {
+		return err
+	}
+	if !hasHeader {
+		blk, err := s.GetBlock(blkID)
+		if err != nil {
+			return err
+		}
+		headerBytes, err := Codec.Marshal(CodecVersion, newBlockHeader(blk))
+		if err != nil {
+			return err
+		}
+		if err := s.headerDB.Put(blkID[:], headerBytes); err != nil {
+			return err
+		}
+	}
 	s.blkCache.Put(blkID, nil)
 	return s.blockDB.Delete(blkID[:])
 }
 
+// flushCache drops all cached blocks and the cached last accepted ID
+func (s *blockState) flushCache() {
+	s.blkCache.Flush()
+	s.lastAccepted = ids.Empty
+}
+
 // GetLastAccepted returns last accepted block ID
 func (s *blockState) GetLastAccepted() (ids.ID, error) {
 	// check if we already have lastAccepted ID in state memory
@@ -166,6 +329,21 @@ func (s *blockState) GetLastAccepted() (ids.ID, error) {
 	return lastAccepted, nil
 }
 
+// GetPrunedHeight returns the height of the first block which isn't pruned
+func (s *blockState) GetPrunedHeight() (uint64, error) {
+	height, err := database.GetUInt64(s.blockDB, prunedHeightKey)
+	if err == database.ErrNotFound {
+		// nothing is pruned, the first block after genesis is the first one
+		return 1, nil
+	}
+	return height, err
+}
+
+// SetPrunedHeight persists the height of the first block which isn't pruned
+func (s *blockState) SetPrunedHeight(height uint64) error {
+	return database.PutUInt64(s.blockDB, prunedHeightKey, height)
+}
+
 // SetLastAccepted persists lastAccepted ID into both cache and database
 func (s *blockState) SetLastAccepted(lastAccepted ids.ID) error {
 	// if the ID in memory and the given memory are same don't do anything
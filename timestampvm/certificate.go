@@ -0,0 +1,146 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/constants"
+	"github.com/chain4travel/caminogo/utils/crypto"
+	"github.com/chain4travel/caminogo/utils/formatting"
+)
+
+// certificateVersion is the version of the certificates issued
+const certificateVersion = 1
+
+var (
+	errCertificatesDisabled = errors.New("no certificate key is configured")
+	errBadCertificateKey    = fmt.Errorf("certificate key must be a secp256k1 private key formatted as %s<cb58>", constants.SecretKeyPrefix)
+
+	// certificateInstructions tell the holder of a certificate how to verify
+	// it without trusting the issuing node
+	certificateInstructions = []string{
+		"1. Recover the signer from the signature of the SHA-256 hash of the certificate's exact bytes, and compare it with the issuing node's published address.",
+		"2. Hash the document with the hash algorithm and compare the digest with the data.",
+		"3. Check the SHA-256 hash of the block bytes is the block ID, and that they hold the parent ID, height, timestamp and data.",
+		"4. Fetch the blocks from the block ID up to a block you trust from any node with getBlockBytes, and check each is the parent of the next, e.g. with the verify package.",
+	}
+)
+
+// Certificate attests that an item was anchored in an accepted block. It's
+// signed by the issuing node and carries the bytes of the block, so third
+// parties can check it without access to the chain.
+type Certificate struct {
+	Version uint16 `json:"version"`
+	ChainID ids.ID `json:"chainID"`
+	// Base 58 encoded data anchored
+	Data string `json:"data"`
+	// HashAlgorithm is the algorithm the data was declared to be computed
+	// with
+	HashAlgorithm string       `json:"hashAlgorithm"`
+	BlockID       ids.ID       `json:"blockID"`
+	ParentID      ids.ID       `json:"parentID"`
+	Height        uint64       `json:"height"`
+	Timestamp     time.Time    `json:"timestamp"`
+	Submitter     *ids.ShortID `json:"submitter,omitempty"`
+	Namespace     string       `json:"namespace,omitempty"`
+	// Hex encoded bytes of the block, with a checksum
+	BlockBytes string `json:"blockBytes"`
+	// LastAcceptedID is the ID of the last accepted block when the
+	// certificate was issued, a descendant of the block
+	LastAcceptedID     ids.ID    `json:"lastAcceptedID"`
+	LastAcceptedHeight uint64    `json:"lastAcceptedHeight"`
+	IssuedAt           time.Time `json:"issuedAt"`
+	Instructions       []string  `json:"instructions"`
+}
+
+// readCertificateKey returns the key certificates are signed with, read from
+// the file at [path], or nil if [path] is empty
+func readCertificateKey(path string) (crypto.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read certificate key: %w", err)
+	}
+	encoded := string(bytes.TrimSpace(content))
+	if !strings.HasPrefix(encoded, constants.SecretKeyPrefix) {
+		return nil, errBadCertificateKey
+	}
+	keyBytes, err := formatting.Decode(formatting.CB58, strings.TrimPrefix(encoded, constants.SecretKeyPrefix))
+	if err != nil {
+		return nil, errBadCertificateKey
+	}
+	key, err := secpFactory.ToPrivateKey(keyBytes)
+	if err != nil {
+		return nil, errBadCertificateKey
+	}
+	return key, nil
+}
+
+// issueCertificate returns the certificate of [blk]'s data, encoded as
+// canonical JSON, and its signature by [vm.certificateKey]
+func (vm *VM) issueCertificate(blk *Block) ([]byte, []byte, error) {
+	if vm.certificateKey == nil {
+		return nil, nil, errCertificatesDisabled
+	}
+	data := blk.Data()
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	blockBytes, err := formatting.EncodeWithChecksum(formatting.Hex, blk.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	lastAcceptedID, err := vm.state.GetLastAccepted()
+	if err != nil {
+		return nil, nil, errCannotGetLastAccepted
+	}
+	lastAccepted, err := vm.state.GetBlockHeader(lastAcceptedID)
+	if err != nil {
+		return nil, nil, errDatabaseGet
+	}
+
+	certificate := &Certificate{
+		Version:            certificateVersion,
+		ChainID:            vm.ctx.ChainID,
+		Data:               encodedData,
+		HashAlgorithm:      declaredHashAlgorithm(blk.Tags()),
+		BlockID:            blk.ID(),
+		ParentID:           blk.Parent(),
+		Height:             blk.Height(),
+		Timestamp:          blk.Timestamp().UTC(),
+		Namespace:          blk.Namespace(),
+		BlockBytes:         blockBytes,
+		LastAcceptedID:     lastAcceptedID,
+		LastAcceptedHeight: lastAccepted.Hght,
+		IssuedAt:           time.Now().UTC().Truncate(time.Second),
+		Instructions:       certificateInstructions,
+	}
+	if blk.IsSigned() {
+		submitter, err := blk.Submitter()
+		if err != nil {
+			return nil, nil, err
+		}
+		certificate.Submitter = &submitter
+	}
+	certificateBytes, err := stdjson.Marshal(certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := vm.certificateKey.Sign(certificateBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificateBytes, signature, nil
+}
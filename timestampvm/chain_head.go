@@ -0,0 +1,210 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+const (
+	// chainHeadProfile is the profile of blocks anchoring the head of
+	// another chain
+	chainHeadProfile = "chainHead"
+	// chainTagKey, chainHeightTagKey and chainHashTagKey are the keys of the
+	// tags holding the anchored chain head, so it can be indexed
+	chainTagKey       = "chain"
+	chainHeightTagKey = "chainHeight"
+	chainHashTagKey   = "chainHash"
+)
+
+var (
+	errBadChainName             = fmt.Errorf("chain names must be 1 to %d letters, digits, '.', '_' or '-'", maxTagValueLen)
+	errEmptyChainHash           = errors.New("chain heads must have a hash")
+	errBadChainHeadTags         = errors.New("chain head tags don't match the data")
+	errChainHeadsDisabled       = errors.New("no chain head sources are configured")
+	errChainHeadsWithoutSigning = errors.New("chainHeadSources requires signed submissions")
+	errChainHeadForbidden       = errors.New("submitter isn't a source of the chain")
+	errChainHeadNotAnchored     = errors.New("chain head isn't anchored in an accepted block")
+
+	_ BlockVerifier = &chainHeadVerifier{}
+)
+
+// ChainHead is the head of another chain, e.g. a private ledger or another
+// blockchain, anchored here by one of the chain's sources, so this chain
+// serves as an anchoring hub for others. The data of the block anchoring it
+// is the SHA-256 hash of the encoded head, see [ChainHeadData], and the
+// block is tagged with the head's fields.
+type ChainHead struct {
+	// Chain names the anchored chain
+	Chain string `serialize:"true" json:"chain"`
+	// Height is the height of the head in the anchored chain
+	Height uint64 `serialize:"true" json:"height"`
+	// Hash is the hash of the head in the anchored chain
+	Hash ids.ID `serialize:"true" json:"hash"`
+}
+
+// Verify returns nil iff [head] is well formed
+func (head *ChainHead) Verify() error {
+	if err := verifyChainName(head.Chain); err != nil {
+		return err
+	}
+	if head.Hash == ids.Empty {
+		return errEmptyChainHash
+	}
+	return nil
+}
+
+// verifyChainName returns nil iff [chain] is a valid chain name
+func verifyChainName(chain string) error {
+	if chain == "" || len(chain) > maxTagValueLen || verifyNamespace(chain) != nil {
+		return fmt.Errorf("%w: %q", errBadChainName, chain)
+	}
+	return nil
+}
+
+// ChainHeadData returns the data of the block anchoring [head]
+func ChainHeadData(head *ChainHead) ([dataLen]byte, error) {
+	headBytes, err := Codec.Marshal(CodecVersion, head)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(headBytes), nil
+}
+
+// ChainHeadMessage returns the message a source of the chain signs to
+// propose [head] in [namespace] to the chain [chainID]. It's the message
+// signed to submit the data and tags of the block anchoring the head.
+func ChainHeadMessage(chainID ids.ID, namespace string, head *ChainHead) ([]byte, error) {
+	data, err := ChainHeadData(head)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := parseTags(chainHeadTags(head))
+	if err != nil {
+		return nil, err
+	}
+	return ScopedSubmissionMessage(chainID, data, namespace, tags)
+}
+
+// chainHeadTags returns the tags of the block anchoring [head]
+func chainHeadTags(head *ChainHead) map[string]string {
+	return map[string]string{
+		profileTagKey:     chainHeadProfile,
+		chainTagKey:       head.Chain,
+		chainHeightTagKey: strconv.FormatUint(head.Height, 10),
+		chainHashTagKey:   head.Hash.String(),
+	}
+}
+
+// parseChainHead returns the chain head anchored as [data] with [tags], or
+// nil if the tags don't declare the chain head profile. The head is read from
+// the tags, which must match the data.
+func parseChainHead(tagList []Tag, data [dataLen]byte) (*ChainHead, error) {
+	tags := tagMap(tagList)
+	if tags[profileTagKey] != chainHeadProfile {
+		return nil, nil
+	}
+	height, err := strconv.ParseUint(tags[chainHeightTagKey], 10, 64)
+	if err != nil {
+		return nil, errBadChainHeadTags
+	}
+	hash, err := ids.FromString(tags[chainHashTagKey])
+	if err != nil {
+		return nil, errBadChainHeadTags
+	}
+	head := &ChainHead{
+		Chain:  tags[chainTagKey],
+		Height: height,
+		Hash:   hash,
+	}
+	if err := head.Verify(); err != nil {
+		return nil, err
+	}
+	headData, err := ChainHeadData(head)
+	if err != nil {
+		return nil, err
+	}
+	if headData != data {
+		return nil, errBadChainHeadTags
+	}
+	return head, nil
+}
+
+// blockChainHead returns the chain head anchored by [blk], or nil if it
+// doesn't have the chain head profile
+func blockChainHead(blk *Block) (*ChainHead, error) {
+	return parseChainHead(blk.Tags(), blk.Data())
+}
+
+// chainHeadSources are the submitters allowed to anchor the heads of each
+// chain
+type chainHeadSources map[string]ids.ShortSet
+
+// newChainHeadSources returns the sources of [config], or nil if there are
+// none
+func newChainHeadSources(config *Config) chainHeadSources {
+	if len(config.ChainHeadSources) == 0 {
+		return nil
+	}
+	s := make(chainHeadSources, len(config.ChainHeadSources))
+	for chain, submitters := range config.ChainHeadSources {
+		set := ids.NewShortSet(len(submitters))
+		set.Add(submitters...)
+		s[chain] = set
+	}
+	return s
+}
+
+// verify returns nil iff [submitter] may anchor the heads of [chain].
+// Unsigned heads have no submitter, they are never allowed.
+func (s chainHeadSources) verify(submitter *ids.ShortID, chain string) error {
+	if submitter != nil {
+		if sources, ok := s[chain]; ok && sources.Contains(*submitter) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", errChainHeadForbidden, chain)
+}
+
+// chainHeadVerifier requires the blocks with the chain head profile to anchor
+// the head their tags describe, signed by a source of the chain
+type chainHeadVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *chainHeadVerifier) VerifyBlock(blk *Block) error {
+	head, err := blockChainHead(blk)
+	if err != nil || head == nil {
+		return err
+	}
+	if !blk.IsSigned() {
+		return v.vm.chainHeadSources.verify(nil, head.Chain)
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	return v.vm.chainHeadSources.verify(&submitter, head.Chain)
+}
+
+// indexChainHead lists the accepted [blk] under the chain head it anchors,
+// if chain heads are enabled and it has the chain head profile
+func (vm *VM) indexChainHead(blk *Block) error {
+	if vm.chainHeadSources == nil {
+		return nil
+	}
+	head, err := blockChainHead(blk)
+	if err != nil || head == nil {
+		// Blocks accepted before chain heads were enabled weren't verified,
+		// they aren't listed unless they are well formed
+		return nil
+	}
+	return vm.state.IndexChainHead(head.Chain, head.Height, blk.ID())
+}
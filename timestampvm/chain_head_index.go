@@ -0,0 +1,86 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+var _ ChainHeadIndex = &chainHeadIndex{}
+
+// ChainHeadIndex lists the accepted blocks anchoring the heads of other
+// chains, by chain and height
+type ChainHeadIndex interface {
+	// IndexChainHead records that the accepted block [blkID] anchors the
+	// head at [height] of [chain]. It replaces the block anchoring an
+	// earlier head at [height], e.g. before the chain was reorganized.
+	IndexChainHead(chain string, height uint64, blkID ids.ID) error
+	// GetChainHead returns the ID of the accepted block anchoring the head
+	// at [height] of [chain].
+	// Returns database.ErrNotFound if there's none.
+	GetChainHead(chain string, height uint64) (ids.ID, error)
+	// GetLatestChainHead returns the highest height of [chain] whose head is
+	// anchored, and the ID of the block anchoring it.
+	// Returns database.ErrNotFound if no head of [chain] is anchored.
+	GetLatestChainHead(chain string) (uint64, ids.ID, error)
+}
+
+// chainHeadIndex implements ChainHeadIndex with a database keyed by the hash
+// of the chain name followed by the big-endian encoded height of the head.
+// The hash of the chain name alone holds the highest height anchored.
+type chainHeadIndex struct {
+	indexDB database.Database
+}
+
+// NewChainHeadIndex returns ChainHeadIndex stored in the given db
+func NewChainHeadIndex(db database.Database) ChainHeadIndex {
+	return &chainHeadIndex{indexDB: db}
+}
+
+// chainHeadPrefix returns the prefix of the keys of the heads of [chain]
+func chainHeadPrefix(chain string) []byte {
+	p := wrappers.Packer{MaxSize: wrappers.ShortLen + maxTagValueLen}
+	p.PackStr(chain)
+	return hashing.ComputeHash256(p.Bytes)
+}
+
+// chainHeadKey returns the key of the head at [height] of [chain]
+func chainHeadKey(chain string, height uint64) []byte {
+	return append(chainHeadPrefix(chain), database.PackUInt64(height)...)
+}
+
+// IndexChainHead implements the ChainHeadIndex interface
+func (i *chainHeadIndex) IndexChainHead(chain string, height uint64, blkID ids.ID) error {
+	if err := database.PutID(i.indexDB, chainHeadKey(chain, height), blkID); err != nil {
+		return err
+	}
+	latestKey := chainHeadPrefix(chain)
+	latest, err := database.GetUInt64(i.indexDB, latestKey)
+	switch {
+	case err == database.ErrNotFound:
+	case err != nil:
+		return err
+	case latest > height:
+		return nil
+	}
+	return database.PutUInt64(i.indexDB, latestKey, height)
+}
+
+// GetChainHead implements the ChainHeadIndex interface
+func (i *chainHeadIndex) GetChainHead(chain string, height uint64) (ids.ID, error) {
+	return database.GetID(i.indexDB, chainHeadKey(chain, height))
+}
+
+// GetLatestChainHead implements the ChainHeadIndex interface
+func (i *chainHeadIndex) GetLatestChainHead(chain string) (uint64, ids.ID, error) {
+	height, err := database.GetUInt64(i.indexDB, chainHeadPrefix(chain))
+	if err != nil {
+		return 0, ids.Empty, err
+	}
+	blkID, err := i.GetChainHead(chain, height)
+	return height, blkID, err
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var _ ChildIndex = &childIndex{}
+
+// ChildIndex links accepted blocks to their accepted child, allowing the
+// canonical chain to be walked forward from genesis
+type ChildIndex interface {
+	// PutAcceptedChild records [childID] as the accepted child of [parentID]
+	PutAcceptedChild(parentID ids.ID, childID ids.ID) error
+	// GetAcceptedChild returns the ID of the accepted child of [parentID].
+	// Returns database.ErrNotFound if [parentID] is the last accepted block.
+	GetAcceptedChild(parentID ids.ID) (ids.ID, error)
+}
+
+// childIndex implements ChildIndex with a database keyed by parent ID
+type childIndex struct {
+	childDB database.Database
+}
+
+// NewChildIndex returns ChildIndex stored in the given db
+func NewChildIndex(db database.Database) ChildIndex {
+	return &childIndex{childDB: db}
+}
+
+// PutAcceptedChild implements the ChildIndex interface
+func (c *childIndex) PutAcceptedChild(parentID ids.ID, childID ids.ID) error {
+	return database.PutID(c.childDB, parentID[:], childID)
+}
+
+// GetAcceptedChild implements the ChildIndex interface
+func (c *childIndex) GetAcceptedChild(parentID ids.ID) (ids.ID, error) {
+	return database.GetID(c.childDB, parentID[:])
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+const (
+	// cidTagKey is the key of the tag holding the CID of the content whose
+	// digest is the data of a block
+	cidTagKey = "cid"
+
+	// multihash codes of the digest algorithms
+	sha256Multihash     = 0x12
+	sha3256Multihash    = 0x16
+	blake2b256Multihash = 0xb220
+
+	// dagPBCodec is the codec of the content of CIDs of version 0
+	dagPBCodec = 0x70
+)
+
+var (
+	errBadCID            = errors.New("invalid CID")
+	errUnsupportedCID    = errors.New("CID digest must be a 32 byte SHA-256, SHA3-256 or BLAKE2b-256 multihash")
+	errUnknownMultibase  = errors.New("CIDs must be encoded in base32, base58btc or base16")
+	errCIDDigestMismatch = errors.New("CID tag doesn't match the data")
+
+	// lower case base32 without padding, the default multibase of CIDs
+	cidBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+	// the digest algorithms by multihash code
+	multihashAlgorithms = map[uint64]string{
+		sha256Multihash:     SHA256,
+		sha3256Multihash:    SHA3256,
+		blake2b256Multihash: Blake2b256,
+	}
+)
+
+// CID is an IPFS content identifier whose multihash is a 32 byte digest in
+// one of the supported algorithms, so the digest can be anchored as data
+type CID struct {
+	// Version is 0 or 1
+	Version uint64
+	// Codec is the multicodec of the content, e.g. 0x55 for raw bytes
+	Codec uint64
+	// HashAlgorithm is the algorithm of the multihash, e.g. [SHA256]
+	HashAlgorithm string
+	Digest        [dataLen]byte
+}
+
+// ParseCID returns the CID encoded as [s]. CIDs of version 0 are base58btc
+// encoded SHA-256 multihashes; CIDs of version 1 may be encoded in base32,
+// base58btc or base16, as indicated by their multibase prefix.
+func ParseCID(s string) (*CID, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		multihash, err := base58.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errBadCID, err)
+		}
+		cid := &CID{Codec: dagPBCodec}
+		if err := cid.parseMultihash(multihash); err != nil {
+			return nil, err
+		}
+		if cid.HashAlgorithm != SHA256 {
+			return nil, errBadCID
+		}
+		return cid, nil
+	}
+	if s == "" {
+		return nil, errBadCID
+	}
+
+	var (
+		cidBytes []byte
+		err      error
+	)
+	switch s[0] {
+	case 'b':
+		cidBytes, err = cidBase32.DecodeString(s[1:])
+	case 'B':
+		cidBytes, err = cidBase32.DecodeString(strings.ToLower(s[1:]))
+	case 'z':
+		cidBytes, err = base58.Decode(s[1:])
+	case 'f':
+		cidBytes, err = hex.DecodeString(s[1:])
+	default:
+		return nil, errUnknownMultibase
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errBadCID, err)
+	}
+	version, n := binary.Uvarint(cidBytes)
+	if n <= 0 || version != 1 {
+		return nil, fmt.Errorf("%w: unsupported version", errBadCID)
+	}
+	cidBytes = cidBytes[n:]
+	codec, n := binary.Uvarint(cidBytes)
+	if n <= 0 {
+		return nil, errBadCID
+	}
+	cid := &CID{
+		Version: version,
+		Codec:   codec,
+	}
+	if err := cid.parseMultihash(cidBytes[n:]); err != nil {
+		return nil, err
+	}
+	return cid, nil
+}
+
+// parseMultihash sets the hash algorithm and the digest of [cid] to the ones
+// of [multihash]
+func (cid *CID) parseMultihash(multihash []byte) error {
+	code, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return errBadCID
+	}
+	multihash = multihash[n:]
+	length, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return errBadCID
+	}
+	multihash = multihash[n:]
+	algorithm, ok := multihashAlgorithms[code]
+	if !ok || length != dataLen {
+		return errUnsupportedCID
+	}
+	if len(multihash) != dataLen {
+		return errBadCID
+	}
+	cid.HashAlgorithm = algorithm
+	copy(cid.Digest[:], multihash)
+	return nil
+}
+
+// Bytes returns the binary encoding of [cid]
+func (cid *CID) Bytes() []byte {
+	b := make([]byte, 0, 4*binary.MaxVarintLen64+dataLen)
+	if cid.Version == 1 {
+		b = appendUvarint(b, cid.Version)
+		b = appendUvarint(b, cid.Codec)
+	}
+	for code, algorithm := range multihashAlgorithms {
+		if algorithm == cid.HashAlgorithm {
+			b = appendUvarint(b, code)
+			break
+		}
+	}
+	b = appendUvarint(b, dataLen)
+	return append(b, cid.Digest[:]...)
+}
+
+// appendUvarint appends the varint encoding of [x] to [b]
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(b, buf[:n]...)
+}
+
+// String returns the canonical encoding of [cid]: base58btc for version 0,
+// base32 for version 1
+func (cid *CID) String() string {
+	if cid.Version == 0 {
+		return base58.Encode(cid.Bytes())
+	}
+	return "b" + cidBase32.EncodeToString(cid.Bytes())
+}
+
+// blockCID returns the CID [blk] is tagged with, or nil if it has none. The
+// digest of the CID must be the data of [blk].
+func blockCID(blk *Block) (*CID, error) {
+	encoded, ok := tagMap(blk.Tags())[cidTagKey]
+	if !ok {
+		return nil, nil
+	}
+	cid, err := ParseCID(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if cid.Digest != blk.Data() {
+		return nil, errCIDDigestMismatch
+	}
+	return cid, nil
+}
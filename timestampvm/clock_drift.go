@@ -0,0 +1,244 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+const (
+	// port NTP servers listen on if the configured address has none
+	defaultNTPPort = "123"
+	// time to wait for the response of a time source
+	timeSourceTimeout = 5 * time.Second
+	// seconds from the NTP epoch (1900) to the unix epoch (1970)
+	ntpEpochOffset = 2208988800
+	// size of an NTP packet without extensions
+	ntpPacketLen = 48
+)
+
+var (
+	_ TimeSource = &ntpSource{}
+
+	errBadNTPResponse   = errors.New("invalid NTP response")
+	errNoTimeSource     = errors.New("no time source responded")
+	errNoClockDriftRule = errors.New("healthMaxClockDrift requires clockDriftServers or time sources")
+)
+
+// TimeSource is an external clock the local clock is compared with, such as
+// an NTP or Roughtime server
+type TimeSource interface {
+	// Offset returns how far the external clock is ahead of the local clock
+	Offset() (time.Duration, error)
+	// String returns the name of the source, used in logs
+	String() string
+}
+
+// ntpSource implements TimeSource querying an NTP server with SNTP
+// (RFC 4330)
+type ntpSource struct {
+	address string
+}
+
+// newNTPSource returns a TimeSource querying the NTP server at [address]
+func newNTPSource(address string) TimeSource {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultNTPPort)
+	}
+	return &ntpSource{address: address}
+}
+
+func (s *ntpSource) String() string { return "ntp://" + s.address }
+
+// Offset implements the TimeSource interface
+func (s *ntpSource) Offset() (time.Duration, error) {
+	conn, err := net.Dial("udp", s.address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeSourceTimeout)); err != nil {
+		return 0, err
+	}
+
+	request := make([]byte, ntpPacketLen)
+	request[0] = 0x23 // no leap warning, version 4, client mode
+	sent := time.Now()
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	response := make([]byte, ntpPacketLen)
+	n, err := conn.Read(response)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case n < ntpPacketLen:
+		return 0, fmt.Errorf("%w: %d bytes", errBadNTPResponse, n)
+	case response[0]&0x7 != 4:
+		return 0, fmt.Errorf("%w: not in server mode", errBadNTPResponse)
+	case response[1] == 0:
+		return 0, fmt.Errorf("%w: kiss-o'-death %q", errBadNTPResponse, response[12:16])
+	case binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]):
+		return 0, fmt.Errorf("%w: doesn't answer the request", errBadNTPResponse)
+	}
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(response[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(response[40:]))
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// toNTPTime returns [t] as an NTP timestamp: seconds since 1900 in the upper
+// 32 bits, fractions of a second in the lower 32 bits
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// fromNTPTime returns the time of the NTP timestamp [ntpTime]
+func fromNTPTime(ntpTime uint64) time.Time {
+	seconds := int64(ntpTime>>32) - ntpEpochOffset
+	nanos := (ntpTime & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(seconds, int64(nanos))
+}
+
+// initDriftMonitor sets [vm.driftMonitor] if the local clock is compared with
+// any time sources
+func (vm *VM) initDriftMonitor() error {
+	sources := vm.timeSources
+	for _, address := range vm.config.ClockDriftServers {
+		sources = append(sources, newNTPSource(address))
+	}
+	if len(sources) == 0 {
+		if vm.config.HealthMaxClockDrift.Duration > 0 {
+			return errNoClockDriftRule
+		}
+		return nil
+	}
+	var err error
+	vm.driftMonitor, err = newDriftMonitor(vm, sources, vm.registry)
+	return err
+}
+
+// ClockDriftStatus reports the last comparison of the local clock with the
+// configured time sources
+type ClockDriftStatus struct {
+	// Drift is the median of how far the time sources are ahead of the local
+	// clock
+	Drift Duration `json:"drift"`
+	// CheckedAt is when the drift was last measured
+	CheckedAt time.Time `json:"checkedAt"`
+	// LastError is the error of the last check if no source responded
+	LastError string `json:"lastError,omitempty"`
+}
+
+// driftMonitor periodically measures the drift of the local clock, which
+// block timestamps are taken from, against external time sources
+type driftMonitor struct {
+	vm      *VM
+	sources []TimeSource
+
+	lock   sync.Mutex
+	status ClockDriftStatus
+	// true once the drift was measured successfully
+	measured bool
+
+	drift    prometheus.Gauge
+	failures prometheus.Counter
+}
+
+// newDriftMonitor returns a driftMonitor for [vm] comparing its clock with
+// [sources] and reporting metrics to [registerer]
+func newDriftMonitor(vm *VM, sources []TimeSource, registerer prometheus.Registerer) (*driftMonitor, error) {
+	m := &driftMonitor{
+		vm:      vm,
+		sources: sources,
+		drift: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "clock_drift_seconds",
+			Help: "median of how far (in seconds) the time sources are ahead of the local clock",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "clock_drift_check_failures",
+			Help: "# of clock drift checks no time source responded to",
+		}),
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(m.drift),
+		registerer.Register(m.failures),
+	)
+	return m, errs.Err
+}
+
+// Status returns the last measured drift. Returns false if the drift was
+// never measured successfully.
+func (m *driftMonitor) Status() (ClockDriftStatus, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.status, m.measured
+}
+
+// runPeriodically measures the drift right away and then every
+// [vm.config.ClockDriftInterval] until the VM shuts down
+func (m *driftMonitor) runPeriodically() {
+	ticker := time.NewTicker(m.vm.config.ClockDriftInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		m.check()
+		select {
+		case <-ticker.C:
+		case <-m.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// check queries all time sources and records the median of their offsets.
+// Sources which don't respond are ignored.
+func (m *driftMonitor) check() {
+	offsets := []time.Duration(nil)
+	errs := []string(nil)
+	for _, source := range m.sources {
+		offset, err := source.Offset()
+		if err != nil {
+			m.vm.ctx.Log.Debug("couldn't query time source %s: %s", source, err)
+			errs = append(errs, fmt.Sprintf("%s: %s", source, err))
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.status.CheckedAt = time.Now()
+	if len(offsets) == 0 {
+		m.status.LastError = fmt.Sprintf("%s: %s", errNoTimeSource, strings.Join(errs, "; "))
+		m.failures.Inc()
+		m.vm.ctx.Log.Warn("couldn't measure clock drift: %s", m.status.LastError)
+		return
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	drift := offsets[len(offsets)/2]
+	m.status.Drift = Duration{drift}
+	m.status.LastError = ""
+	m.measured = true
+	m.drift.Set(drift.Seconds())
+}
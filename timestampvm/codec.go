@@ -17,14 +17,111 @@ package timestampvm
 import (
 	"github.com/chain4travel/caminogo/codec"
 	"github.com/chain4travel/caminogo/codec/linearcodec"
+	"github.com/chain4travel/caminogo/codec/reflectcodec"
 )
 
 const (
 	// CodecVersion is the current default codec version
 	CodecVersion = 0
+	// SignedCodecVersion is the codec version of blocks with a submission
+	// signature. It additionally serializes the fields tagged [signedTagName].
+	SignedCodecVersion = 1
+	// AllowlistCodecVersion is the codec version of blocks updating the
+	// submitter allowlist. It additionally serializes the fields tagged
+	// [allowlistTagName].
+	AllowlistCodecVersion = 2
+	// ValidatorsCodecVersion is the codec version of signed blocks of chains
+	// only accepting submissions by validators. It additionally serializes
+	// the fields tagged [validatorsTagName].
+	ValidatorsCodecVersion = 3
+	// TransferCodecVersion is the codec version of blocks transferring funds
+	// between accounts. It additionally serializes the fields tagged
+	// [transferTagName].
+	TransferCodecVersion = 4
+	// ProofOfWorkCodecVersion is the codec version of blocks carrying a proof
+	// of work. It additionally serializes the fields tagged [signedTagName]
+	// and [proofOfWorkTagName].
+	ProofOfWorkCodecVersion = 5
+	// CreditGrantCodecVersion is the codec version of blocks granting prepaid
+	// credits. It additionally serializes the fields tagged [signedTagName]
+	// and [creditGrantTagName].
+	CreditGrantCodecVersion = 6
+	// NamespaceCodecVersion is the codec version of blocks anchoring data in
+	// a namespace. It additionally serializes the fields tagged
+	// [signedTagName], [validatorsTagName] and [namespaceTagName].
+	NamespaceCodecVersion = 7
+	// NamespaceProofOfWorkCodecVersion is the codec version of blocks
+	// anchoring data in a namespace with a proof of work. It additionally
+	// serializes the fields tagged [signedTagName], [proofOfWorkTagName] and
+	// [namespaceTagName].
+	NamespaceProofOfWorkCodecVersion = 8
+	// TagsCodecVersion is the codec version of tagged blocks. It additionally
+	// serializes the fields tagged [signedTagName], [validatorsTagName],
+	// [namespaceTagName] and [tagsTagName].
+	TagsCodecVersion = 9
+	// TagsProofOfWorkCodecVersion is the codec version of tagged blocks with
+	// a proof of work. It additionally serializes the fields tagged
+	// [signedTagName], [proofOfWorkTagName], [namespaceTagName] and
+	// [tagsTagName].
+	TagsProofOfWorkCodecVersion = 10
+	// SchemaCodecVersion is the codec version of blocks updating the payload
+	// schemas. It additionally serializes the fields tagged [signedTagName]
+	// and [schemaTagName].
+	SchemaCodecVersion = 11
+	// RedactionCodecVersion is the codec version of blocks redacting the data
+	// of an earlier block. It additionally serializes the fields tagged
+	// [signedTagName] and [redactionTagName].
+	RedactionCodecVersion = 12
+	// RevealCodecVersion is the codec version of blocks revealing the value
+	// of an earlier commitment. It additionally serializes the fields tagged
+	// [signedTagName], [validatorsTagName], [namespaceTagName], [tagsTagName]
+	// and [revealTagName].
+	RevealCodecVersion = 13
+	// RevealProofOfWorkCodecVersion is the codec version of blocks revealing
+	// the value of an earlier commitment with a proof of work. It
+	// additionally serializes the fields tagged [signedTagName],
+	// [proofOfWorkTagName], [namespaceTagName], [tagsTagName] and
+	// [revealTagName].
+	RevealProofOfWorkCodecVersion = 14
+	// KeyRegistrationCodecVersion is the codec version of blocks registering
+	// the public key payloads are encrypted to. It additionally serializes
+	// the fields tagged [signedTagName] and [keyRegistrationTagName].
+	KeyRegistrationCodecVersion = 15
+	// EncryptedCodecVersion is the codec version of blocks anchoring an
+	// encrypted payload. It additionally serializes the fields tagged
+	// [signedTagName], [validatorsTagName], [namespaceTagName], [tagsTagName]
+	// and [encryptedTagName].
+	EncryptedCodecVersion = 16
+	// EncryptedProofOfWorkCodecVersion is the codec version of blocks
+	// anchoring an encrypted payload with a proof of work. It additionally
+	// serializes the fields tagged [signedTagName], [proofOfWorkTagName],
+	// [namespaceTagName], [tagsTagName] and [encryptedTagName].
+	EncryptedProofOfWorkCodecVersion = 17
+
+	signedTagName     = "serializeSigned"
+	allowlistTagName  = "serializeAllowlist"
+	validatorsTagName = "serializeValidators"
+	transferTagName   = "serializeTransfer"
+	// blocks anchoring data may be signed and carry a proof of work at once
+	proofOfWorkTagName = "serializeProofOfWork"
+	creditGrantTagName = "serializeCreditGrant"
+	namespaceTagName   = "serializeNamespace"
+	tagsTagName        = "serializeTags"
+	schemaTagName      = "serializeSchema"
+	redactionTagName   = "serializeRedaction"
+	revealTagName      = "serializeReveal"
+	// recipient keys are registered apart from the payloads encrypted to them
+	keyRegistrationTagName = "serializeKeyRegistration"
+	encryptedTagName       = "serializeEncrypted"
+
+	// maxSliceLength is the maximum length of serialized slices,
+	// equal to the linearcodec default
+	maxSliceLength = 256 * 1024
 )
 
-// Codecs do serialization and deserialization
+// Codecs do serialization and deserialization. Each codec version of blocks
+// serializes its own set of optional fields, built blocks are checked to
+// have no other field set by [Block.marshal].
 var (
 	Codec codec.Manager
 )
@@ -38,4 +135,103 @@ func init() {
 	if err := Codec.RegisterCodec(CodecVersion, c); err != nil {
 		panic(err)
 	}
+
+	// Register the codec for signed blocks, so unsigned blocks keep their
+	// original encoding
+	signedCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(SignedCodecVersion, signedCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for allowlist updates, which are always signed
+	allowlistCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, allowlistTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(AllowlistCodecVersion, allowlistCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for blocks recording the P-chain height their
+	// submitter is checked against
+	validatorsCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, validatorsTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(ValidatorsCodecVersion, validatorsCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for transfers, which are always signed
+	transferCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, transferTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(TransferCodecVersion, transferCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for blocks carrying a proof of work, which may be
+	// signed as well
+	proofOfWorkCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, proofOfWorkTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(ProofOfWorkCodecVersion, proofOfWorkCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for credit grants, which are always signed
+	creditGrantCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, creditGrantTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(CreditGrantCodecVersion, creditGrantCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codecs for blocks anchoring data in a namespace, which may
+	// be signed and carry either a P-chain height or a proof of work
+	namespaceCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, validatorsTagName, namespaceTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(NamespaceCodecVersion, namespaceCodec); err != nil {
+		panic(err)
+	}
+	namespaceProofOfWorkCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, proofOfWorkTagName, namespaceTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(NamespaceProofOfWorkCodecVersion, namespaceProofOfWorkCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codecs for tagged blocks, which may have a namespace too
+	tagsCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, validatorsTagName, namespaceTagName, tagsTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(TagsCodecVersion, tagsCodec); err != nil {
+		panic(err)
+	}
+	tagsProofOfWorkCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, proofOfWorkTagName, namespaceTagName, tagsTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(TagsProofOfWorkCodecVersion, tagsProofOfWorkCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for schema updates, which are always signed
+	schemaCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, schemaTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(SchemaCodecVersion, schemaCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for redactions, which are always signed
+	redactionCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, redactionTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(RedactionCodecVersion, redactionCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codecs for reveals, which anchor data like tagged blocks
+	revealCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, validatorsTagName, namespaceTagName, tagsTagName, revealTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(RevealCodecVersion, revealCodec); err != nil {
+		panic(err)
+	}
+	revealProofOfWorkCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, proofOfWorkTagName, namespaceTagName, tagsTagName, revealTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(RevealProofOfWorkCodecVersion, revealProofOfWorkCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codec for key registrations, which are always signed
+	keyRegistrationCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, keyRegistrationTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(KeyRegistrationCodecVersion, keyRegistrationCodec); err != nil {
+		panic(err)
+	}
+
+	// Register the codecs for encrypted payloads, which anchor data like
+	// tagged blocks
+	encryptedCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, validatorsTagName, namespaceTagName, tagsTagName, encryptedTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(EncryptedCodecVersion, encryptedCodec); err != nil {
+		panic(err)
+	}
+	encryptedProofOfWorkCodec := linearcodec.New([]string{reflectcodec.DefaultTagName, signedTagName, proofOfWorkTagName, namespaceTagName, tagsTagName, encryptedTagName}, maxSliceLength)
+	if err := Codec.RegisterCodec(EncryptedProofOfWorkCodecVersion, encryptedProofOfWorkCodec); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,128 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errAcceptedWritesLost = errors.New("writes of accepted blocks were discarded before they were committed")
+
+// acceptCommitter groups the commits of accepted blocks. Accepted blocks are
+// staged in the versiondb and committed once [vm.config.CommitBatchSize] of
+// them are pending, or at the latest [vm.config.CommitInterval] after they
+// were accepted. Blocks accepted since the last commit are lost on a crash and
+// fetched again from the network on restart.
+type acceptCommitter struct {
+	vm *VM
+	// number of accepted blocks staged since the last commit
+	pending int
+	// lost is set once the writes of pending blocks were discarded. The
+	// blocks are accepted in memory but not in the database, so nothing is
+	// committed anymore until the VM is restarted.
+	lost error
+
+	// number of blocks committed together
+	batchSize prometheus.Histogram
+}
+
+// newAcceptCommitter returns an acceptCommitter for [vm] reporting its
+// metrics to [registerer]
+func newAcceptCommitter(vm *VM, registerer prometheus.Registerer) (*acceptCommitter, error) {
+	c := &acceptCommitter{
+		vm: vm,
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "accept_commit_batch_size",
+			Help:    "Number of accepted blocks committed together",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+	}
+	return c, registerer.Register(c.batchSize)
+}
+
+// Accepted records that a block's accept was staged and commits the pending
+// blocks if the batch is full. Must be called with the context lock held.
+func (c *acceptCommitter) Accepted() error {
+	c.pending++
+	if c.pending < c.vm.config.CommitBatchSize {
+		return nil
+	}
+	if err := c.Flush(); err != nil {
+		// The block's writes are aborted, it isn't pending
+		c.pending--
+		return err
+	}
+	return nil
+}
+
+// Discard forgets the pending blocks, after their writes were aborted. If
+// blocks accepted before were pending, their writes were aborted too, and
+// errAcceptedWritesLost is returned by Discard and every later Flush.
+func (c *acceptCommitter) Discard() error {
+	lost := c.pending
+	c.pending = 0
+	if lost == 0 {
+		return nil
+	}
+	c.lost = fmt.Errorf("%w: %d blocks accepted since the last commit", errAcceptedWritesLost, lost)
+	return c.lost
+}
+
+// Err returns errAcceptedWritesLost if writes of accepted blocks were
+// discarded, nil otherwise
+func (c *acceptCommitter) Err() error {
+	return c.lost
+}
+
+// Flush commits all staged writes, including the pending blocks. It must be
+// called instead of committing the state directly while blocks may be
+// pending, and before staging writes which may be aborted, so the pending
+// blocks aren't discarded with them. Must be called with the context lock
+// held.
+func (c *acceptCommitter) Flush() error {
+	if c.lost != nil {
+		return c.lost
+	}
+	if err := c.vm.state.Commit(); err != nil {
+		return err
+	}
+	if c.pending > 0 {
+		c.batchSize.Observe(float64(c.pending))
+	}
+	c.pending = 0
+	return nil
+}
+
+// runPeriodically commits the pending blocks every
+// [vm.config.CommitInterval] until the VM shuts down
+func (c *acceptCommitter) runPeriodically() {
+	ticker := time.NewTicker(c.vm.config.CommitInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flushLocked()
+		case <-c.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// flushLocked calls Flush holding the context lock
+func (c *acceptCommitter) flushLocked() {
+	c.vm.ctx.Lock.Lock()
+	defer c.vm.ctx.Lock.Unlock()
+
+	if c.vm.isShutdown() {
+		return
+	}
+	if err := c.Flush(); err != nil {
+		c.vm.ctx.Log.Error("couldn't commit accepted blocks: %s", err)
+	}
+}
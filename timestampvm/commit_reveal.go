@@ -0,0 +1,121 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+const (
+	// CommitmentCommitted is the status of a commitment which is anchored
+	// but not revealed yet
+	CommitmentCommitted = "committed"
+	// CommitmentRevealed is the status of a commitment whose value is
+	// revealed
+	CommitmentRevealed = "revealed"
+)
+
+var (
+	errEmptySalt          = errors.New("reveals must have a salt")
+	errCommitmentUnknown  = errors.New("commitment isn't anchored in an accepted block")
+	errCommitmentRevealed = errors.New("commitment is already revealed")
+
+	_ BlockVerifier = &revealVerifier{}
+)
+
+// Reveal discloses the value of a commitment anchored earlier, proving the
+// value was fixed when the commitment was accepted without disclosing it
+// then. The commitment is anchored as plain data, see [Commitment]. The data
+// of the block revealing it is the value, which is linked to the block
+// anchoring the commitment once it's accepted. Each commitment is revealed
+// once. The salt must be kept secret until the reveal, and should be random,
+// so the value can't be guessed from the commitment.
+type Reveal struct {
+	// Salt is the salt hashed with the value into the commitment
+	Salt ids.ID `serialize:"true" json:"salt"`
+}
+
+// Commitment returns the data anchoring a commitment to [value] salted with
+// [salt]
+func Commitment(salt ids.ID, value [dataLen]byte) [dataLen]byte {
+	return SaltedHash(salt, value[:])
+}
+
+// SaltedHash returns the SHA-256 hash of [salt] followed by [content], so
+// content of low entropy can't be guessed from its hash
+func SaltedHash(salt ids.ID, content []byte) [dataLen]byte {
+	return hashing.ComputeHash256Array(append(salt[:], content...))
+}
+
+// verifyReveal returns nil iff [value] salted with [reveal]'s salt is the
+// value of an accepted commitment, which is revealed neither in the accepted
+// state nor by the processing ancestors recorded in [pending]
+func (vm *VM) verifyReveal(pending ids.Set, reveal *Reveal, value [dataLen]byte) error {
+	if reveal.Salt == ids.Empty {
+		return errEmptySalt
+	}
+	commitmentHash := DataHash(Commitment(reveal.Salt, value))
+	switch _, err := vm.state.GetDataEntry(commitmentHash); err {
+	case nil:
+	case database.ErrNotFound:
+		return errCommitmentUnknown
+	default:
+		return err
+	}
+	if pending.Contains(commitmentHash) {
+		return errCommitmentRevealed
+	}
+	switch revealID, err := vm.state.GetReveal(commitmentHash); err {
+	case nil:
+		return fmt.Errorf("%w by %s", errCommitmentRevealed, revealID)
+	case database.ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+}
+
+// revealVerifier requires reveals to reveal accepted commitments, each once
+type revealVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *revealVerifier) VerifyBlock(blk *Block) error {
+	reveal := blk.Reveal()
+	if reveal == nil {
+		return nil
+	}
+	pending, err := v.pendingReveals(blk.Parent())
+	if err != nil {
+		return err
+	}
+	return v.vm.verifyReveal(pending, reveal, blk.Data())
+}
+
+// pendingReveals returns the hashes of the commitments revealed by [blkID]
+// and its processing ancestors
+func (v *revealVerifier) pendingReveals(blkID ids.ID) (ids.Set, error) {
+	pending := ids.Set{}
+	for {
+		blk, err := v.vm.getBlock(blkID)
+		if err != nil {
+			return nil, errDatabaseGet
+		}
+		// Accepted reveals are recorded in the reveal index
+		if blk.Status() == choices.Accepted {
+			return pending, nil
+		}
+		if reveal := blk.Reveal(); reveal != nil {
+			pending.Add(DataHash(Commitment(reveal.Salt, blk.Data())))
+		}
+		blkID = blk.Parent()
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/utils/json"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+// leveldbStatsProperty is the leveldb property reporting the size of each level
+const leveldbStatsProperty = "leveldb.stats"
+
+// CompactionStatus reports the state of database compaction
+type CompactionStatus struct {
+	// Running is true while the database is being compacted
+	Running bool `json:"running"`
+	// LastStart is when the last compaction started
+	LastStart time.Time `json:"lastStart"`
+	// LastDuration is how long the last compaction took
+	LastDuration Duration `json:"lastDuration"`
+	// LastReclaimedBytes is the disk space freed by the last compaction.
+	// Only reported if the database exposes its size.
+	LastReclaimedBytes json.Uint64 `json:"lastReclaimedBytes"`
+	// LastError is the error which aborted the last compaction, if any
+	LastError string `json:"lastError,omitempty"`
+}
+
+// compactor compacts the VM's database, discarding deleted and overwritten
+// values so pruned data actually frees disk space
+type compactor struct {
+	vm *VM
+
+	lock   sync.Mutex
+	status CompactionStatus
+
+	duration  prometheus.Histogram
+	runs      prometheus.Counter
+	reclaimed prometheus.Counter
+}
+
+// newCompactor returns a compactor for [vm] reporting metrics to [registerer]
+func newCompactor(vm *VM, registerer prometheus.Registerer) (*compactor, error) {
+	c := &compactor{
+		vm: vm,
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "compaction_duration_seconds",
+			Help:    "time (in seconds) database compactions took",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+		}),
+		runs: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "compactions",
+			Help: "# of database compactions",
+		}),
+		reclaimed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "compaction_reclaimed_bytes",
+			Help: "disk space (in bytes) freed by database compactions",
+		}),
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(c.duration),
+		registerer.Register(c.runs),
+		registerer.Register(c.reclaimed),
+	)
+	return c, errs.Err
+}
+
+// Status returns the state of compaction
+func (c *compactor) Status() CompactionStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.status
+}
+
+// Trigger starts compacting the database in the background
+func (c *compactor) Trigger() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.status.Running {
+		return errJobRunning
+	}
+	c.status.Running = true
+	go c.compact()
+	return nil
+}
+
+// runPeriodically triggers compaction every [vm.config.CompactionInterval]
+// until the VM shuts down
+func (c *compactor) runPeriodically() {
+	ticker := time.NewTicker(c.vm.config.CompactionInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Trigger(); err != nil {
+				c.vm.ctx.Log.Debug("skipping scheduled compaction: %s", err)
+			}
+		case <-c.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// compact compacts the whole database.
+// The database synchronizes compaction with reads and writes, so the context
+// lock isn't held.
+func (c *compactor) compact() {
+	sizeBefore, sizeKnown := c.diskSize()
+	start := time.Now()
+	err := c.vm.state.Compact(nil, nil)
+	duration := time.Since(start)
+	sizeAfter, sizeKnownAfter := c.diskSize()
+
+	reclaimed := uint64(0)
+	if sizeKnown && sizeKnownAfter && sizeAfter < sizeBefore {
+		reclaimed = sizeBefore - sizeAfter
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.status = CompactionStatus{
+		LastStart:          start,
+		LastDuration:       Duration{duration},
+		LastReclaimedBytes: json.Uint64(reclaimed),
+	}
+	if err != nil {
+		c.status.LastError = err.Error()
+		c.vm.ctx.Log.Warn("database compaction failed: %s", err)
+		return
+	}
+	c.duration.Observe(duration.Seconds())
+	c.runs.Inc()
+	c.reclaimed.Add(float64(reclaimed))
+	c.vm.ctx.Log.Info("compacted database in %s, reclaiming %d bytes", duration, reclaimed)
+}
+
+// diskSize returns the size of the database on disk.
+// Returns false if the database doesn't report its size.
+func (c *compactor) diskSize() (uint64, bool) {
+	stats, err := c.vm.state.Stat(leveldbStatsProperty)
+	if err != nil {
+		return 0, false
+	}
+	return parseLevelDBSize(stats)
+}
+
+// parseLevelDBSize returns the total size reported by the leveldb stats
+// property [stats]. Its last line sums up all levels:
+//
+//	Total | <tables> | <size in MB> | ...
+func parseLevelDBSize(stats string) (uint64, bool) {
+	for _, line := range strings.Split(stats, "\n") {
+		columns := strings.Split(line, "|")
+		if len(columns) < 3 || strings.TrimSpace(columns[0]) != "Total" {
+			continue
+		}
+		sizeMB, err := strconv.ParseFloat(strings.TrimSpace(columns[2]), 64)
+		if err != nil {
+			return 0, false
+		}
+		return uint64(sizeMB * 1024 * 1024), true
+	}
+	return 0, false
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/snappy"
+
+	"github.com/chain4travel/caminogo/utils/compression"
+)
+
+// Compression algorithms block bodies can be stored with
+const (
+	NoCompression     = "none"
+	SnappyCompression = "snappy"
+	GzipCompression   = "gzip"
+)
+
+// Stored block bodies are prefixed with a marker byte naming the algorithm.
+// Uncompressed bodies are codec encoded, so they start with the first byte of
+// the codec version, which is 0. Hence bodies stored before compression was
+// introduced are read as uncompressed.
+const (
+	uncompressedMarker byte = iota
+	snappyMarker
+	gzipMarker
+)
+
+const (
+	// maximum size of a decompressed block body
+	maxBlockBodySize = 256 * 1024
+)
+
+var (
+	errUnknownCompression = errors.New("unknown compression")
+	errEmptyBlockBody     = errors.New("stored block body is empty")
+)
+
+// blockCompressor compresses block bodies with the configured algorithm and
+// decompresses bodies stored with any supported algorithm
+type blockCompressor struct {
+	marker byte
+	gzip   compression.Compressor
+}
+
+// newBlockCompressor returns a blockCompressor compressing with [algorithm]
+func newBlockCompressor(algorithm string) (*blockCompressor, error) {
+	c := &blockCompressor{
+		gzip: compression.NewGzipCompressor(maxBlockBodySize),
+	}
+	switch algorithm {
+	case "", NoCompression:
+		c.marker = uncompressedMarker
+	case SnappyCompression:
+		c.marker = snappyMarker
+	case GzipCompression:
+		c.marker = gzipMarker
+	default:
+		return nil, fmt.Errorf("%w %q", errUnknownCompression, algorithm)
+	}
+	return c, nil
+}
+
+// Compress returns [body] as it should be stored
+func (c *blockCompressor) Compress(body []byte) ([]byte, error) {
+	switch c.marker {
+	case snappyMarker:
+		return append([]byte{snappyMarker}, snappy.Encode(nil, body)...), nil
+	case gzipMarker:
+		compressed, err := c.gzip.Compress(body)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{gzipMarker}, compressed...), nil
+	default:
+		return body, nil
+	}
+}
+
+// Decompress returns the body stored as [stored]
+func (c *blockCompressor) Decompress(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, errEmptyBlockBody
+	}
+	switch stored[0] {
+	case uncompressedMarker:
+		return stored, nil
+	case snappyMarker:
+		decodedLen, err := snappy.DecodedLen(stored[1:])
+		if err != nil {
+			return nil, err
+		}
+		if decodedLen > maxBlockBodySize {
+			return nil, fmt.Errorf("block body length (%d) > maximum length (%d)", decodedLen, maxBlockBodySize)
+		}
+		return snappy.Decode(nil, stored[1:])
+	case gzipMarker:
+		return c.gzip.Decompress(stored[1:])
+	default:
+		return nil, fmt.Errorf("%w marker %d", errUnknownCompression, stored[0])
+	}
+}
+
+// IsCurrent returns true if [stored] is stored with the configured algorithm
+func (c *blockCompressor) IsCurrent(stored []byte) bool {
+	return len(stored) > 0 && stored[0] == c.marker
+}
+
+// newRecompressionRun returns the step function of a run which rewrites the
+// bodies of all accepted blocks that are stored with another algorithm than
+// the configured one. Allows migrating existing data after changing the
+// configured algorithm.
+func (vm *VM) newRecompressionRun() batchStep {
+	nextHeight := uint64(0)
+	return func(uint64) (uint64, uint64, bool, error) {
+		lastAccepted, err := vm.lastAcceptedBlock()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		total := lastAccepted.Height() + 1
+
+		blkIDs, err := vm.state.GetAcceptedIDs(vm.runCtx, nextHeight, jobBatchSize)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		for _, blkID := range blkIDs {
+			if err := vm.state.RecompressBlock(blkID); err != nil {
+				return 0, 0, false, err
+			}
+		}
+		nextHeight += uint64(len(blkIDs))
+		return uint64(len(blkIDs)), total, nextHeight >= total, vm.state.Commit()
+	}
+}
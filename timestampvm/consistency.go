@@ -0,0 +1,111 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+)
+
+var errInconsistentState = errors.New("inconsistent state")
+
+// checkConsistency verifies that the last accepted block [lastAcceptedID] can
+// be loaded, links to a known parent and is recorded at its height in the
+// accepted log. If [vm.config.RepairOnStartup] is set, a mismatching accepted
+// log is rewritten instead.
+func (vm *VM) checkConsistency(lastAcceptedID ids.ID) error {
+	blk, err := vm.state.GetBlock(lastAcceptedID)
+	if err != nil {
+		return fmt.Errorf("%w: couldn't load last accepted block %s: %v", errInconsistentState, lastAcceptedID, err)
+	}
+	if blk.Status() != choices.Accepted {
+		return fmt.Errorf("%w: last accepted block %s has status %s", errInconsistentState, lastAcceptedID, blk.Status())
+	}
+	height := blk.Height()
+	if height > 0 {
+		if err := vm.checkParent(blk); err != nil {
+			return err
+		}
+	}
+
+	loggedID, err := vm.state.GetAcceptedID(height)
+	if err == database.ErrNotFound || (err == nil && loggedID == lastAcceptedID) {
+		// missing entries are filled by initAcceptedLog
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !vm.config.RepairOnStartup {
+		return fmt.Errorf(
+			"%w: accepted log stores %s at height %d instead of the last accepted block %s, restart with repairOnStartup to rewrite it",
+			errInconsistentState, loggedID, height, lastAcceptedID,
+		)
+	}
+	return vm.repairAcceptedLog(blk)
+}
+
+// checkParent verifies that the parent of [blk] is a known block one height
+// below it. The parent may have been pruned including its header, as long as
+// the accepted log still references it.
+func (vm *VM) checkParent(blk *Block) error {
+	parentID := blk.Parent()
+	header, err := vm.state.GetBlockHeader(parentID)
+	if err == database.ErrNotFound {
+		loggedID, err := vm.state.GetAcceptedID(blk.Height() - 1)
+		if err == nil && loggedID == parentID {
+			return nil
+		}
+		return fmt.Errorf("%w: parent %s of the last accepted block %s is unknown", errInconsistentState, parentID, blk.ID())
+	}
+	if err != nil {
+		return fmt.Errorf("%w: couldn't load parent %s of the last accepted block %s: %v", errInconsistentState, parentID, blk.ID(), err)
+	}
+	if header.Hght+1 != blk.Height() {
+		return fmt.Errorf(
+			"%w: parent %s has height %d but the last accepted block %s has height %d",
+			errInconsistentState, parentID, header.Hght, blk.ID(), blk.Height(),
+		)
+	}
+	return nil
+}
+
+// repairAcceptedLog rewrites the accepted log and child links from [blk]
+// down to the first height where the log matches the chain of [blk]
+func (vm *VM) repairAcceptedLog(blk *Block) error {
+	blkID := blk.ID()
+	header := newBlockHeader(blk)
+	repaired := 0
+	for {
+		loggedID, err := vm.state.GetAcceptedID(header.Hght)
+		if err == nil && loggedID == blkID {
+			break
+		}
+		if err != nil && err != database.ErrNotFound {
+			return err
+		}
+		if err := vm.state.AppendAccepted(header.Hght, blkID); err != nil {
+			return err
+		}
+		repaired++
+		if header.Hght == 0 {
+			break
+		}
+		if err := vm.state.PutAcceptedChild(header.PrntID, blkID); err != nil {
+			return err
+		}
+
+		blkID = header.PrntID
+		header, err = vm.state.GetBlockHeader(blkID)
+		if err != nil {
+			return fmt.Errorf("%w: couldn't load accepted block %s while repairing the accepted log: %v", errInconsistentState, blkID, err)
+		}
+	}
+	vm.ctx.Log.Warn("repaired %d entries of the accepted log", repaired)
+	return vm.state.Commit()
+}
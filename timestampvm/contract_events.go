@@ -0,0 +1,500 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+const (
+	// contractEventsJobName is the job the position of the relayer in the
+	// C-Chain is persisted as
+	contractEventsJobName = "contractEvents"
+	// maxContractEventsRange is the largest number of C-Chain blocks whose
+	// events are requested at once, the limit of C-Chain nodes
+	maxContractEventsRange = 2048
+	// maxContractEventsReplySize is the largest reply read from the C-Chain
+	// node
+	maxContractEventsReplySize = 16 << 20
+
+	// contractEventProfile is the profile of blocks anchoring a contract
+	// event
+	contractEventProfile = "contractEvent"
+	// evmContractTagKey, evmBlockTagKey, evmTxTagKey and evmLogTagKey are the
+	// keys of the tags locating the anchored event in the C-Chain, so it can
+	// be looked up by transaction
+	evmContractTagKey = "evmContract"
+	evmBlockTagKey    = "evmBlock"
+	evmTxTagKey       = "evmTx"
+	evmLogTagKey      = "evmLog"
+)
+
+var (
+	errContractEventsDisabled = errors.New("contract event relaying is disabled")
+	errContractEventsFailed   = errors.New("C-Chain call failed")
+	errBadContractAddress     = errors.New("contract address must be 20 hex encoded bytes")
+	errBadEventTopic          = errors.New("event topics must be 32 hex encoded bytes")
+)
+
+// ContractEvent is an event emitted by a C-Chain contract. The data of the
+// block anchoring it is the SHA-256 hash of its encoding, see
+// [ContractEventData]. Hex fields are lower case with a "0x" prefix.
+type ContractEvent struct {
+	// Contract is the address of the contract which emitted the event
+	Contract string `serialize:"true"`
+	// BlockNumber is the number of the C-Chain block holding the event
+	BlockNumber uint64 `serialize:"true"`
+	// TxHash is the hash of the transaction which emitted the event
+	TxHash string `serialize:"true"`
+	// LogIndex is the position of the event in the block
+	LogIndex uint64 `serialize:"true"`
+	// Topics are the indexed topics of the event, the first being the hash
+	// of its signature
+	Topics []string `serialize:"true"`
+	// Data are the non indexed arguments of the event
+	Data []byte `serialize:"true"`
+}
+
+// ContractEventData returns the data of the block anchoring [event]
+func ContractEventData(event *ContractEvent) ([dataLen]byte, error) {
+	eventBytes, err := Codec.Marshal(CodecVersion, event)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(eventBytes), nil
+}
+
+// contractEventTags returns the tags of the block anchoring [event]
+func contractEventTags(event *ContractEvent) map[string]string {
+	return map[string]string{
+		profileTagKey:     contractEventProfile,
+		evmContractTagKey: strings.TrimPrefix(event.Contract, "0x"),
+		evmBlockTagKey:    strconv.FormatUint(event.BlockNumber, 10),
+		evmTxTagKey:       strings.TrimPrefix(event.TxHash, "0x"),
+		evmLogTagKey:      strconv.FormatUint(event.LogIndex, 10),
+	}
+}
+
+// verifyContractAddress returns nil iff [address] is a hex encoded contract
+// address
+func verifyContractAddress(address string) error {
+	if !isHex(address, 20) {
+		return fmt.Errorf("%w: %q", errBadContractAddress, address)
+	}
+	return nil
+}
+
+// verifyEventTopic returns nil iff [topic] is a hex encoded event topic
+func verifyEventTopic(topic string) error {
+	if !isHex(topic, 32) {
+		return fmt.Errorf("%w: %q", errBadEventTopic, topic)
+	}
+	return nil
+}
+
+// isHex returns true if [s] is the "0x" prefixed hex encoding of [size]
+// bytes
+func isHex(s string, size int) bool {
+	if !strings.HasPrefix(s, "0x") || len(s) != 2+2*size {
+		return false
+	}
+	_, err := hex.DecodeString(s[2:])
+	return err == nil
+}
+
+// contractEventsProgress is the persisted position of the relayer in the
+// C-Chain
+type contractEventsProgress struct {
+	// NextBlock is the C-Chain block whose events are relayed next
+	NextBlock uint64 `serialize:"true"`
+	// NextLog is the index of the first event of [NextBlock] not relayed
+	// yet, if relaying stopped within the block
+	NextLog uint64 `serialize:"true"`
+}
+
+// ContractEventsStatus reports the state of relaying contract events
+type ContractEventsStatus struct {
+	// NextBlock is the C-Chain block whose events are relayed next
+	NextBlock uint64 `json:"nextBlock"`
+	// Relayed is the number of events proposed since the VM started
+	Relayed uint64 `json:"relayed"`
+	// CheckedAt is when the C-Chain was last polled
+	CheckedAt time.Time `json:"checkedAt"`
+	// LastError is the error of the last poll, if it failed
+	LastError string `json:"lastError,omitempty"`
+}
+
+// evmLog is an event as returned by eth_getLogs
+type evmLog struct {
+	Address     string   `json:"address"`
+	Topics      []string `json:"topics"`
+	Data        string   `json:"data"`
+	BlockNumber string   `json:"blockNumber"`
+	TxHash      string   `json:"transactionHash"`
+	LogIndex    string   `json:"logIndex"`
+	// Removed is true if the block holding the event was reorganized away
+	Removed bool `json:"removed"`
+}
+
+// contractEventRelayer periodically polls a C-Chain node for the events of a
+// contract and proposes their hashes, so the activity of the contract is
+// timestamped on this chain without an external bot. The position reached in
+// the C-Chain is persisted, so events are relayed once, unless the node stops
+// before the blocks anchoring them are accepted and the mempool isn't
+// persisted.
+type contractEventRelayer struct {
+	vm        *VM
+	url       string
+	address   string
+	topics    []string
+	namespace string
+	client    *http.Client
+
+	lock   sync.Mutex
+	status ContractEventsStatus
+
+	relayed  prometheus.Counter
+	failures prometheus.Counter
+}
+
+// newContractEventRelayer returns the contractEventRelayer of [vm], reporting
+// metrics to [registerer], or nil if relaying isn't configured
+func newContractEventRelayer(vm *VM, registerer prometheus.Registerer) (*contractEventRelayer, error) {
+	config := &vm.config
+	if config.ContractEventsURL == "" {
+		return nil, nil
+	}
+	topics := make([]string, len(config.ContractEventsTopics))
+	for i, topic := range config.ContractEventsTopics {
+		topics[i] = strings.ToLower(topic)
+	}
+	r := &contractEventRelayer{
+		vm:        vm,
+		url:       config.ContractEventsURL,
+		address:   strings.ToLower(config.ContractEventsAddress),
+		topics:    topics,
+		namespace: config.ContractEventsNamespace,
+		client:    &http.Client{Timeout: config.ContractEventsTimeout.Duration},
+		relayed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "contract_events_relayed",
+			Help: "# of contract events proposed",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "contract_events_failures",
+			Help: "# of polls of the C-Chain which failed",
+		}),
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(r.relayed),
+		registerer.Register(r.failures),
+	)
+	return r, errs.Err
+}
+
+// Status returns the state of relaying
+func (r *contractEventRelayer) Status() ContractEventsStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.status
+}
+
+// runPeriodically relays the new events right away and then every
+// [vm.config.ContractEventsInterval] until the VM shuts down
+func (r *contractEventRelayer) runPeriodically() {
+	ticker := time.NewTicker(r.vm.config.ContractEventsInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		if err := r.poll(); err != nil {
+			r.failures.Inc()
+			r.vm.ctx.Log.Warn("couldn't relay contract events: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-r.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// poll relays the events emitted since the last poll. The C-Chain node is
+// called without holding the context lock.
+func (r *contractEventRelayer) poll() error {
+	err := r.relay()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.status.CheckedAt = time.Now()
+	r.status.LastError = ""
+	if err != nil {
+		r.status.LastError = err.Error()
+	}
+	return err
+}
+
+// relay relays the events of the blocks from the persisted position up to
+// the current C-Chain block
+func (r *contractEventRelayer) relay() error {
+	var head uint64
+	if err := r.call("eth_blockNumber", []interface{}{}, (*hexQuantity)(&head)); err != nil {
+		return err
+	}
+	progress, err := r.loadProgress(head)
+	if err != nil || progress == nil {
+		return err
+	}
+	for progress.NextBlock <= head {
+		to := head
+		if to-progress.NextBlock >= maxContractEventsRange {
+			to = progress.NextBlock + maxContractEventsRange - 1
+		}
+		logs, err := r.getLogs(progress.NextBlock, to)
+		if err != nil {
+			return err
+		}
+		events, err := parseEVMLogs(logs, progress)
+		if err != nil {
+			return err
+		}
+		done, err := r.propose(events, progress, to)
+		if err != nil || !done {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadProgress returns the persisted position of the relayer, or [head] or
+// [vm.config.ContractEventsFromBlock] if none is. Returns nil if the VM shut
+// down.
+func (r *contractEventRelayer) loadProgress(head uint64) (*contractEventsProgress, error) {
+	r.vm.ctx.Lock.Lock()
+	defer r.vm.ctx.Lock.Unlock()
+
+	if r.vm.isShutdown() {
+		return nil, nil
+	}
+	progressBytes, err := r.vm.state.GetJobProgress(contractEventsJobName)
+	switch {
+	case err == nil:
+		progress := &contractEventsProgress{}
+		_, err := Codec.Unmarshal(progressBytes, progress)
+		return progress, err
+	case err != database.ErrNotFound:
+		return nil, err
+	}
+	progress := &contractEventsProgress{NextBlock: r.vm.config.ContractEventsFromBlock}
+	if progress.NextBlock == 0 {
+		progress.NextBlock = head
+	}
+	return progress, nil
+}
+
+// getLogs returns the events of the contract in the C-Chain blocks [from] to
+// [to]
+func (r *contractEventRelayer) getLogs(from uint64, to uint64) ([]evmLog, error) {
+	filter := map[string]interface{}{
+		"fromBlock": hexQuantity(from),
+		"toBlock":   hexQuantity(to),
+		"address":   r.address,
+	}
+	if len(r.topics) > 0 {
+		filter["topics"] = [][]string{r.topics}
+	}
+	logs := []evmLog(nil)
+	return logs, r.call("eth_getLogs", []interface{}{filter}, &logs)
+}
+
+// parseEVMLogs returns the events of [logs] not relayed yet according to
+// [progress]
+func parseEVMLogs(logs []evmLog, progress *contractEventsProgress) ([]*ContractEvent, error) {
+	events := make([]*ContractEvent, 0, len(logs))
+	for _, log := range logs {
+		if log.Removed {
+			continue
+		}
+		event := &ContractEvent{
+			Contract: strings.ToLower(log.Address),
+			TxHash:   strings.ToLower(log.TxHash),
+			Topics:   make([]string, len(log.Topics)),
+		}
+		if err := verifyContractAddress(event.Contract); err != nil {
+			return nil, fmt.Errorf("%w: %s", errContractEventsFailed, err)
+		}
+		if !isHex(event.TxHash, 32) {
+			return nil, fmt.Errorf("%w: bad transaction hash %q", errContractEventsFailed, log.TxHash)
+		}
+		for i, topic := range log.Topics {
+			event.Topics[i] = strings.ToLower(topic)
+			if err := verifyEventTopic(event.Topics[i]); err != nil {
+				return nil, fmt.Errorf("%w: %s", errContractEventsFailed, err)
+			}
+		}
+		data, err := hex.DecodeString(strings.TrimPrefix(log.Data, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad event data", errContractEventsFailed)
+		}
+		event.Data = data
+		if err := (*hexQuantity)(&event.BlockNumber).UnmarshalText([]byte(log.BlockNumber)); err != nil {
+			return nil, err
+		}
+		if err := (*hexQuantity)(&event.LogIndex).UnmarshalText([]byte(log.LogIndex)); err != nil {
+			return nil, err
+		}
+		if event.BlockNumber == progress.NextBlock && event.LogIndex < progress.NextLog {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// propose proposes [events], the events of the C-Chain blocks from
+// [progress.NextBlock] to [to], and persists the position reached. Returns
+// false if not all events could be proposed, e.g. as the mempool is full,
+// in which case the rest is proposed by the next poll.
+func (r *contractEventRelayer) propose(events []*ContractEvent, progress *contractEventsProgress, to uint64) (bool, error) {
+	r.vm.ctx.Lock.Lock()
+	defer r.vm.ctx.Lock.Unlock()
+
+	if r.vm.isShutdown() {
+		return false, nil
+	}
+	service := &Service{vm: r.vm}
+	next := contractEventsProgress{NextBlock: to + 1}
+	var proposeErr error
+	proposed := uint64(0)
+	for _, event := range events {
+		if proposeErr = r.proposeEvent(service, event); proposeErr != nil {
+			next = contractEventsProgress{
+				NextBlock: event.BlockNumber,
+				NextLog:   event.LogIndex,
+			}
+			break
+		}
+		proposed++
+	}
+	r.relayed.Add(float64(proposed))
+
+	if err := r.vm.committer.Flush(); err != nil {
+		return false, err
+	}
+	progressBytes, err := Codec.Marshal(CodecVersion, &next)
+	if err != nil {
+		return false, err
+	}
+	if err := r.vm.state.SetJobProgress(contractEventsJobName, progressBytes); err != nil {
+		r.vm.state.Abort()
+		return false, err
+	}
+	if err := r.vm.state.Commit(); err != nil {
+		return false, err
+	}
+	*progress = next
+
+	r.lock.Lock()
+	r.status.NextBlock = next.NextBlock
+	r.status.Relayed += proposed
+	r.lock.Unlock()
+	return proposeErr == nil, proposeErr
+}
+
+// proposeEvent proposes the block anchoring [event]
+func (r *contractEventRelayer) proposeEvent(service *Service, event *ContractEvent) error {
+	data, err := ContractEventData(event)
+	if err != nil {
+		return err
+	}
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	if err != nil {
+		return err
+	}
+	return service.ProposeBlock(nil, &ProposeBlockArgs{
+		Data:      encodedData,
+		Namespace: r.namespace,
+		Tags:      contractEventTags(event),
+	}, &ProposeBlockReply{})
+}
+
+// call calls the JSON-RPC [method] of the C-Chain node with [params] and
+// decodes its result into [result]
+func (r *contractEventRelayer) call(method string, params []interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Post(r.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %s", errContractEventsFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", errContractEventsFailed, resp.Status)
+	}
+
+	reply := struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxContractEventsReplySize)).Decode(&reply); err != nil {
+		return fmt.Errorf("%w: %s", errContractEventsFailed, err)
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%w: %s", errContractEventsFailed, reply.Error.Message)
+	}
+	if err := json.Unmarshal(reply.Result, result); err != nil {
+		return fmt.Errorf("%w: %s", errContractEventsFailed, err)
+	}
+	return nil
+}
+
+// hexQuantity is an integer encoded as a "0x" prefixed hex string, as by
+// the Ethereum JSON-RPC API
+type hexQuantity uint64
+
+// MarshalText implements the encoding.TextMarshaler interface
+func (q hexQuantity) MarshalText() ([]byte, error) {
+	return []byte("0x" + strconv.FormatUint(uint64(q), 16)), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (q *hexQuantity) UnmarshalText(text []byte) error {
+	s := string(text)
+	if !strings.HasPrefix(s, "0x") {
+		return fmt.Errorf("%w: bad quantity %q", errContractEventsFailed, s)
+	}
+	n, err := strconv.ParseUint(s[2:], 16, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad quantity %q", errContractEventsFailed, s)
+	}
+	*q = hexQuantity(n)
+	return nil
+}
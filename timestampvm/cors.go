@@ -0,0 +1,90 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// seconds browsers may cache the answer to a preflight request
+const corsMaxAge = 600
+
+// allows requests from any origin when listed in [Config.CORSAllowedOrigins]
+const anyOrigin = "*"
+
+var errBadCORSOrigin = errors.New("invalid CORS origin")
+
+// corsPolicy admits browser requests by the origin they are sent from and
+// answers their preflight requests
+type corsPolicy struct {
+	origins   map[string]bool
+	anyOrigin bool
+	methods   string
+	headers   string
+}
+
+// newCORSPolicy returns the policy admitting requests from [origins], with
+// [methods] and [headers]. Origins are given as "scheme://host[:port]", or
+// "*" for any origin.
+func newCORSPolicy(origins []string, methods []string, headers []string) (*corsPolicy, error) {
+	policy := &corsPolicy{
+		origins: make(map[string]bool, len(origins)),
+		methods: strings.Join(methods, ", "),
+		headers: strings.Join(headers, ", "),
+	}
+	for _, origin := range origins {
+		if origin == anyOrigin {
+			policy.anyOrigin = true
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" || parsed.RawQuery != "" {
+			return nil, fmt.Errorf("%w %q", errBadCORSOrigin, origin)
+		}
+		policy.origins[strings.ToLower(origin)] = true
+	}
+	return policy, nil
+}
+
+// enabled returns true if the policy admits any origin
+func (p *corsPolicy) enabled() bool {
+	return p.anyOrigin || len(p.origins) > 0
+}
+
+// Allowed returns true if requests from [origin] are admitted
+func (p *corsPolicy) Allowed(origin string) bool {
+	return p.anyOrigin || p.origins[strings.ToLower(origin)]
+}
+
+// wrap returns [handler] rejecting requests from origins which aren't
+// admitted and answering preflight requests. Requests without an origin,
+// i.e. not sent by a browser, are passed on.
+func (p *corsPolicy) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !p.Allowed(origin) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", p.methods)
+		w.Header().Set("Access-Control-Allow-Headers", p.headers)
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/formatting"
+)
+
+// maxCoSignerReplySize is the largest reply read from the co-signer
+const maxCoSignerReplySize = 64 * 1024
+
+var (
+	errCoSignerAddress      = errors.New("coSignerAddress must be set along with coSignerURL")
+	errCoSignerCertificates = errors.New("coSignerURL requires certificateKeyFile")
+	errCoSignerFailed       = errors.New("co-signer didn't sign the certificate")
+	errWrongCoSigner        = errors.New("certificate was co-signed by another key")
+)
+
+// coSignRequest is posted to the co-signer
+type coSignRequest struct {
+	ChainID ids.ID `json:"chainID"`
+	// Certificate is the canonical JSON of the certificate to co-sign
+	Certificate string `json:"certificate"`
+}
+
+// coSignReply is the reply of the co-signer
+type coSignReply struct {
+	// Base 58 encoded recoverable secp256k1 signature of the SHA-256 hash of
+	// the certificate
+	Signature string `json:"signature"`
+}
+
+// coSigner is an external notary, e.g. backed by an HSM, co-signing the
+// certificates issued by this node, so they don't depend on the node's key
+// alone
+type coSigner struct {
+	url     string
+	address ids.ShortID
+	// bearer token presented to the co-signer, empty if none
+	token  []byte
+	client *http.Client
+}
+
+// newCoSigner returns the co-signer configured by [config], or nil if none
+// is configured
+func newCoSigner(config *Config) (*coSigner, error) {
+	if config.CoSignerURL == "" {
+		return nil, nil
+	}
+	c := &coSigner{
+		url:     config.CoSignerURL,
+		address: config.CoSignerAddress,
+		client:  &http.Client{Timeout: config.CoSignerTimeout.Duration},
+	}
+	if config.CoSignerTokenFile != "" {
+		token, err := readToken(config.CoSignerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		c.token = token
+	}
+	return c, nil
+}
+
+// CoSign returns the co-signer's signature of [certificate], issued on the
+// chain [chainID]. The signature must recover to the configured address.
+func (c *coSigner) CoSign(chainID ids.ID, certificate []byte) ([]byte, error) {
+	body, err := json.Marshal(&coSignRequest{
+		ChainID:     chainID,
+		Certificate: string(certificate),
+	})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if len(c.token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+string(c.token))
+	}
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errCoSignerFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", errCoSignerFailed, resp.Status)
+	}
+
+	reply := coSignReply{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCoSignerReplySize)).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("%w: %s", errCoSignerFailed, err)
+	}
+	signature, err := formatting.Decode(formatting.CB58, reply.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errCoSignerFailed, err)
+	}
+	pubKey, err := secpFactory.RecoverPublicKey(certificate, signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errCoSignerFailed, err)
+	}
+	if pubKey.Address() != c.address {
+		return nil, errWrongCoSigner
+	}
+	return signature, nil
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+var (
+	errCreditGrantData  = errors.New("block's data isn't the hash of its credit grant")
+	errEmptyCreditGrant = errors.New("credit grant must grant credits")
+	errNotFundingIssuer = errors.New("credits can only be granted by a funding issuer")
+)
+
+// CreditGrant tops up the prepaid anchors of an account, e.g. sold to an
+// enterprise client off-chain. Each credit pays for the inclusion of one
+// block anchoring data instead of its fee. It's anchored in a block of its
+// own, whose data is the hash of the grant and whose signature is by a
+// funding issuer.
+type CreditGrant struct {
+	// Nonce is the number of transfers and grants accepted from the issuer
+	// before this one, so a grant can't be replayed
+	Nonce uint64 `serialize:"true" json:"nonce"`
+	// To is the address credited
+	To ids.ShortID `serialize:"true" json:"to"`
+	// Credits is the number of anchors granted
+	Credits uint64 `serialize:"true" json:"credits"`
+}
+
+// CreditGrantData returns the data of the block anchoring [grant]
+func CreditGrantData(grant *CreditGrant) ([dataLen]byte, error) {
+	grantBytes, err := Codec.Marshal(CodecVersion, grant)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(grantBytes), nil
+}
+
+// CreditGrantMessage returns the message the issuer signs to propose
+// [grant] to the chain [chainID]. It's the message signed to submit the data
+// of the block anchoring the grant.
+func CreditGrantMessage(chainID ids.ID, grant *CreditGrant) ([]byte, error) {
+	data, err := CreditGrantData(grant)
+	if err != nil {
+		return nil, err
+	}
+	return SubmissionMessage(chainID, data)
+}
+
+// verifyCreditGrant returns nil iff [blk] anchors a valid credit grant
+func verifyCreditGrant(blk *Block) error {
+	grant := blk.CreditGrant()
+	data, err := CreditGrantData(grant)
+	if err != nil {
+		return err
+	}
+	if blk.Data() != data {
+		return errCreditGrantData
+	}
+	if grant.Credits == 0 {
+		return errEmptyCreditGrant
+	}
+	return nil
+}
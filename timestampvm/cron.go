@@ -0,0 +1,138 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how far ahead a schedule is searched for its next run
+const maxScheduleSearch = 5 * 366 * 24 * time.Hour
+
+var errBadSchedule = errors.New("invalid schedule")
+
+// schedule is a cron-like schedule. It's written as the five fields
+//
+//	minute hour day-of-month month day-of-week
+//
+// each one "*", a value, a range "a-b" or a comma separated list of those,
+// optionally followed by a step "/n". Days of the week count from 0
+// (Sunday). If both days of the month and of the week are restricted, either
+// one has to match, like in cron. Times are in UTC.
+type schedule struct {
+	minutes, hours, daysOfMonth, months, daysOfWeek []bool
+	// true if the field was restricted, rather than "*"
+	domRestricted, dowRestricted bool
+}
+
+// parseSchedule returns the schedule written as [spec]
+func parseSchedule(spec string) (*schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: expected 5 fields", errBadSchedule, spec)
+	}
+	s := &schedule{}
+	var err error
+	if s.minutes, _, err = parseScheduleField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("%w %q: minute: %v", errBadSchedule, spec, err)
+	}
+	if s.hours, _, err = parseScheduleField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("%w %q: hour: %v", errBadSchedule, spec, err)
+	}
+	if s.daysOfMonth, s.domRestricted, err = parseScheduleField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("%w %q: day of month: %v", errBadSchedule, spec, err)
+	}
+	if s.months, _, err = parseScheduleField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("%w %q: month: %v", errBadSchedule, spec, err)
+	}
+	if s.daysOfWeek, s.dowRestricted, err = parseScheduleField(fields[4], 0, 6); err != nil {
+		return nil, fmt.Errorf("%w %q: day of week: %v", errBadSchedule, spec, err)
+	}
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%w %q: never runs", errBadSchedule, spec)
+	}
+	return s, nil
+}
+
+// parseScheduleField returns the values between [min] and [max] matched by
+// [field], indexed by value, and whether the field restricts the values
+func parseScheduleField(field string, min int, max int) ([]bool, bool, error) {
+	matches := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, false, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			low, err1 = strconv.Atoi(bounds[0])
+			high, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, false, fmt.Errorf("bad range %q", part)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, false, fmt.Errorf("bad value %q", part)
+			}
+			low, high = value, value
+			if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, false, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			matches[value] = true
+		}
+	}
+	return matches, field != "*", nil
+}
+
+// Next returns the first time after [t] the schedule runs at, or the zero
+// time if it doesn't run within [maxScheduleSearch]
+func (s *schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxScheduleSearch)
+	for t.Before(limit) {
+		switch {
+		case !s.months[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !s.hours[t.Hour()]:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !s.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay returns true if the schedule runs on the day of [t]
+func (s *schedule) matchesDay(t time.Time) bool {
+	dom := s.daysOfMonth[t.Day()]
+	dow := s.daysOfWeek[t.Weekday()]
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
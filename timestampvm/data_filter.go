@@ -0,0 +1,169 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+const (
+	// number of bytes of the filter stored under one key. Adding a hash
+	// rewrites the pages holding its bits.
+	dataFilterPageSize = 256
+)
+
+// persists the parameters of the filter with this key. It's shorter than the
+// page keys, so it can't collide with them.
+var dataFilterParamsKey = []byte{0}
+
+// dataFilterParams are the parameters of a dataFilter
+type dataFilterParams struct {
+	NumBits   uint64 `serialize:"true"`
+	NumHashes uint64 `serialize:"true"`
+}
+
+// newDataFilterParams returns the parameters of a filter holding [capacity]
+// hashes with a false positive rate of [falsePositiveRate]
+func newDataFilterParams(capacity uint64, falsePositiveRate float64) dataFilterParams {
+	numBits := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	// the filter is made of whole pages
+	pageBits := uint64(dataFilterPageSize * 8)
+	numBits = (numBits + pageBits - 1) / pageBits * pageBits
+	numHashes := uint64(math.Round(float64(numBits) / float64(capacity) * math.Ln2))
+	if numHashes == 0 {
+		numHashes = 1
+	}
+	return dataFilterParams{
+		NumBits:   numBits,
+		NumHashes: numHashes,
+	}
+}
+
+// dataFilter is a bloom filter over data hashes, persisted in pages.
+// Data hashes are uniformly distributed, so the bit positions are derived
+// from the hash itself.
+type dataFilter struct {
+	db     database.Database
+	params dataFilterParams
+	bits   []byte
+}
+
+// loadDataFilter returns the filter with [params] stored in [db].
+// Returns false if no such filter is stored, so it has to be rebuilt.
+func loadDataFilter(db database.Database, params dataFilterParams) (*dataFilter, bool, error) {
+	f := &dataFilter{
+		db:     db,
+		params: params,
+		bits:   make([]byte, params.NumBits/8),
+	}
+	storedParamsBytes, err := db.Get(dataFilterParamsKey)
+	if err == database.ErrNotFound {
+		return f, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	storedParams := dataFilterParams{}
+	if _, err := Codec.Unmarshal(storedParamsBytes, &storedParams); err != nil {
+		return nil, false, err
+	}
+	if storedParams != params {
+		return f, false, nil
+	}
+
+	it := db.NewIterator()
+	defer it.Release()
+	for it.Next() {
+		key := it.Key()
+		if len(key) != wrappers.LongLen {
+			continue
+		}
+		offset := binary.BigEndian.Uint64(key) * dataFilterPageSize
+		if offset >= uint64(len(f.bits)) {
+			continue
+		}
+		copy(f.bits[offset:], it.Value())
+	}
+	return f, true, it.Error()
+}
+
+// positions returns the bits set for [dataHash], using double hashing
+func (f *dataFilter) positions(dataHash ids.ID) []uint64 {
+	h1 := binary.BigEndian.Uint64(dataHash[0:])
+	h2 := binary.BigEndian.Uint64(dataHash[8:])
+	positions := make([]uint64, f.params.NumHashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % f.params.NumBits
+	}
+	return positions
+}
+
+// MayContain returns false if [dataHash] was never added
+func (f *dataFilter) MayContain(dataHash ids.ID) bool {
+	for _, position := range f.positions(dataHash) {
+		if f.bits[position/8]&(1<<(position%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add adds [dataHash] to the filter and writes the pages holding its bits.
+// Pages are written even if the bits were already set, as they may have been
+// set by a write which was aborted.
+func (f *dataFilter) Add(dataHash ids.ID) error {
+	pages := map[uint64]struct{}{}
+	for _, position := range f.positions(dataHash) {
+		f.bits[position/8] |= 1 << (position % 8)
+		pages[position/8/dataFilterPageSize] = struct{}{}
+	}
+	for page := range pages {
+		if err := f.writePage(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild replaces the content of the filter with the data hashes iterated by
+// [it] and writes the whole filter
+func (f *dataFilter) Rebuild(it database.Iterator) error {
+	f.bits = make([]byte, f.params.NumBits/8)
+	for it.Next() {
+		dataHash, err := ids.ToID(it.Key())
+		if err != nil {
+			return err
+		}
+		for _, position := range f.positions(dataHash) {
+			f.bits[position/8] |= 1 << (position % 8)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for page := uint64(0); page < uint64(len(f.bits))/dataFilterPageSize; page++ {
+		if err := f.writePage(page); err != nil {
+			return err
+		}
+	}
+	paramsBytes, err := Codec.Marshal(CodecVersion, &f.params)
+	if err != nil {
+		return err
+	}
+	return f.db.Put(dataFilterParamsKey, paramsBytes)
+}
+
+// writePage persists [page] of the filter
+func (f *dataFilter) writePage(page uint64) error {
+	offset := page * dataFilterPageSize
+	// the database may keep the value, which must not change with the filter
+	pageBytes := make([]byte, dataFilterPageSize)
+	copy(pageBytes, f.bits[offset:])
+	return f.db.Put(database.PackUInt64(page), pageBytes)
+}
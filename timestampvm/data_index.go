@@ -0,0 +1,115 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+var _ DataIndex = &dataIndex{}
+
+// DataIndex maps the hash of anchored data to the accepted block containing it
+type DataIndex interface {
+	// IndexData adds the data of the accepted block [blk] to the index.
+	// If the same data was already indexed, the earlier block is kept.
+	IndexData(blk *Block) error
+	// GetDataEntry returns the entry of the earliest accepted block which
+	// contains data hashing to [dataHash]
+	GetDataEntry(dataHash ids.ID) (*DataEntry, error)
+	// PutDataEntry overwrites the entry of [dataHash]. Only meant for
+	// rebuilding the index.
+	PutDataEntry(dataHash ids.ID, entry *DataEntry) error
+}
+
+// DataEntry references the accepted block some data is anchored in
+type DataEntry struct {
+	BlkID  ids.ID `serialize:"true" json:"blockID"`
+	Height uint64 `serialize:"true" json:"height"`
+}
+
+// dataIndex implements DataIndex with a database keyed by data hash
+type dataIndex struct {
+	indexDB database.Database
+	// answers most lookups of data which isn't indexed without reading
+	// [indexDB], nil if disabled
+	filter *dataFilter
+}
+
+// NewDataIndex returns DataIndex stored in the given db
+func NewDataIndex(db database.Database) DataIndex {
+	return &dataIndex{indexDB: db}
+}
+
+// NewFilteredDataIndex returns DataIndex stored in [db] with a bloom filter,
+// stored in [filterDB], sized for [capacity] entries at a false positive rate
+// of [falsePositiveRate]. The filter is rebuilt if it's missing or was sized
+// differently.
+func NewFilteredDataIndex(db database.Database, filterDB database.Database, capacity uint64, falsePositiveRate float64) (DataIndex, error) {
+	filter, loaded, err := loadDataFilter(filterDB, newDataFilterParams(capacity, falsePositiveRate))
+	if err != nil {
+		return nil, err
+	}
+	if !loaded {
+		it := db.NewIterator()
+		defer it.Release()
+		if err := filter.Rebuild(it); err != nil {
+			return nil, err
+		}
+	}
+	return &dataIndex{
+		indexDB: db,
+		filter:  filter,
+	}, nil
+}
+
+// DataHash returns the key [data] is indexed with
+func DataHash(data [dataLen]byte) ids.ID {
+	return hashing.ComputeHash256Array(data[:])
+}
+
+// IndexData implements the DataIndex interface
+func (i *dataIndex) IndexData(blk *Block) error {
+	dataHash := DataHash(blk.Data())
+	// Keep the first block the data was anchored in
+	has, err := i.indexDB.Has(dataHash[:])
+	if err != nil || has {
+		return err
+	}
+	return i.PutDataEntry(dataHash, &DataEntry{
+		BlkID:  blk.ID(),
+		Height: blk.Height(),
+	})
+}
+
+// PutDataEntry implements the DataIndex interface
+func (i *dataIndex) PutDataEntry(dataHash ids.ID, entry *DataEntry) error {
+	entryBytes, err := Codec.Marshal(CodecVersion, entry)
+	if err != nil {
+		return err
+	}
+	if i.filter != nil {
+		if err := i.filter.Add(dataHash); err != nil {
+			return err
+		}
+	}
+	return i.indexDB.Put(dataHash[:], entryBytes)
+}
+
+// GetDataEntry implements the DataIndex interface
+func (i *dataIndex) GetDataEntry(dataHash ids.ID) (*DataEntry, error) {
+	if i.filter != nil && !i.filter.MayContain(dataHash) {
+		return nil, database.ErrNotFound
+	}
+	entryBytes, err := i.indexDB.Get(dataHash[:])
+	if err != nil {
+		return nil, err
+	}
+	entry := &DataEntry{}
+	if _, err := Codec.Unmarshal(entryBytes, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
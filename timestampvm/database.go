@@ -0,0 +1,75 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/database/leveldb"
+	"github.com/chain4travel/caminogo/database/memdb"
+)
+
+// Database backends the state can be stored in
+const (
+	// NodeDatabase is the database the node provides to the VM
+	NodeDatabase = "node"
+	// LevelDBDatabase is a leveldb opened by the VM itself, which can be
+	// tuned independently of the node's database
+	LevelDBDatabase = "leveldb"
+	// MemDatabase keeps the state in memory, so it's lost on shutdown
+	MemDatabase = "memdb"
+)
+
+var (
+	errUnknownDatabaseBackend = errors.New("unknown database backend")
+	errNoDatabaseDir          = errors.New("the leveldb database backend requires a database directory")
+)
+
+// openDatabase sets [vm.db] to the database the state is stored in
+func (vm *VM) openDatabase() error {
+	// the database provided by the factory takes precedence
+	if vm.db != nil {
+		return nil
+	}
+
+	switch vm.config.DatabaseBackend {
+	case "", NodeDatabase:
+		vm.db = vm.dbManager.Current().Database
+	case LevelDBDatabase:
+		db, err := leveldb.New(vm.config.DatabaseDir, vm.config.DatabaseConfig, vm.ctx.Log)
+		if err != nil {
+			return fmt.Errorf("couldn't open leveldb at %s: %w", vm.config.DatabaseDir, err)
+		}
+		vm.db = db
+		vm.ownedDB = db
+	case MemDatabase:
+		vm.db = memdb.New()
+		vm.ownedDB = vm.db
+	default:
+		return fmt.Errorf("%w %q", errUnknownDatabaseBackend, vm.config.DatabaseBackend)
+	}
+	return nil
+}
+
+// isEmpty returns true if [db] holds no keys
+func isEmpty(db database.Database) (bool, error) {
+	it := db.NewIterator()
+	defer it.Release()
+
+	return !it.Next(), it.Error()
+}
+
+// closeDatabase closes [vm.db] unless it's owned by the node
+func (vm *VM) closeDatabase() error {
+	if vm.ownedDB == nil {
+		return nil
+	}
+	err := vm.ownedDB.Close()
+	if err == database.ErrClosed {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"sync"
+
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+// statsPrefixes are the prefixes of the state whose usage is counted, in the
+// order they are scanned
+var statsPrefixes = [][]byte{
+	singletonStatePrefix,
+	blockStatePrefix,
+	blockHeaderPrefix,
+	acceptedLogPrefix,
+	dataIndexPrefix,
+	dataFilterPrefix,
+	childIndexPrefix,
+	submitterIndexPrefix,
+	namespaceIndexPrefix,
+	tagIndexPrefix,
+	revealIndexPrefix,
+	chainHeadIndexPrefix,
+	archiveManifestPrefix,
+	jobProgressPrefix,
+	savedMempoolPrefix,
+	externalAnchorPrefix,
+}
+
+// caches whose hit rate is reported, by the namespace of their metrics
+var statsCaches = []string{"block_cache", "block_id_cache", "archive_cache"}
+
+// PrefixUsage is the space taken by a part of the state
+type PrefixUsage struct {
+	Keys json.Uint64 `json:"keys"`
+	// Bytes is the size of the keys and values, before compression by the
+	// database
+	Bytes json.Uint64 `json:"bytes"`
+}
+
+// CacheStats are the lookups of a cache since the VM started
+type CacheStats struct {
+	Hits   json.Uint64 `json:"hits"`
+	Misses json.Uint64 `json:"misses"`
+}
+
+// DatabaseStats reports the space taken by the state and the use of the
+// caches in front of it
+type DatabaseStats struct {
+	// JobStatus is the progress of counting the keys, in prefixes
+	JobStatus
+	// Prefixes is the usage of each part of the state counted by the current
+	// (or last) run. "header" holds an entry per stored block, including
+	// those whose body was pruned or archived. "accepted", "data", "child"
+	// and "submitter" are the indexes.
+	Prefixes map[string]PrefixUsage `json:"prefixes"`
+	// DiskSize is the size of the database on disk, if the database reports
+	// it
+	DiskSize json.Uint64 `json:"diskSize,omitempty"`
+	// Caches are the lookups of each cache
+	Caches map[string]CacheStats `json:"caches"`
+}
+
+// dbStatsCollector counts the keys and bytes stored under each prefix of the
+// state in the background
+type dbStatsCollector struct {
+	vm  *VM
+	job *batchJob
+
+	lock  sync.Mutex
+	usage map[string]PrefixUsage
+}
+
+// newDBStatsCollector returns a database statistics collector for [vm]
+func newDBStatsCollector(vm *VM) *dbStatsCollector {
+	c := &dbStatsCollector{vm: vm}
+	c.job = newBatchJob(vm, "database statistics", c.newRun)
+	return c
+}
+
+// Trigger starts counting the usage of the state in the background
+func (c *dbStatsCollector) Trigger() error {
+	return c.job.Trigger()
+}
+
+// Stats returns the usage counted by the current (or last) run and the
+// current disk size and cache statistics
+func (c *dbStatsCollector) Stats() (*DatabaseStats, error) {
+	stats := &DatabaseStats{
+		JobStatus: c.job.Status(),
+		Prefixes:  map[string]PrefixUsage{},
+		Caches:    map[string]CacheStats{},
+	}
+
+	c.lock.Lock()
+	for prefix, usage := range c.usage {
+		stats.Prefixes[prefix] = usage
+	}
+	c.lock.Unlock()
+
+	if size, ok := c.vm.compactor.diskSize(); ok {
+		stats.DiskSize = json.Uint64(size)
+	}
+	families, err := c.vm.registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	counters := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if counter := metric.GetCounter(); counter != nil {
+				counters[family.GetName()] += counter.GetValue()
+			}
+		}
+	}
+	for _, cache := range statsCaches {
+		hits, hasHits := counters[cache+"_hit"]
+		misses, hasMisses := counters[cache+"_miss"]
+		if hasHits || hasMisses {
+			stats.Caches[cache] = CacheStats{
+				Hits:   json.Uint64(hits),
+				Misses: json.Uint64(misses),
+			}
+		}
+	}
+	return stats, nil
+}
+
+// newRun resets the usage and returns the step function of a new run, which
+// scans [jobBatchSize] keys per batch
+func (c *dbStatsCollector) newRun() batchStep {
+	c.lock.Lock()
+	c.usage = map[string]PrefixUsage{}
+	c.lock.Unlock()
+
+	prefixIndex := 0
+	next := []byte(nil)
+	total := uint64(len(statsPrefixes))
+	return func(uint64) (uint64, uint64, bool, error) {
+		prefix := string(statsPrefixes[prefixIndex])
+		keys, size, nextKey, err := c.vm.state.ScanUsage(c.vm.runCtx, prefix, next, jobBatchSize)
+		if err != nil {
+			return 0, 0, false, err
+		}
+
+		c.lock.Lock()
+		usage := c.usage[prefix]
+		usage.Keys += json.Uint64(keys)
+		usage.Bytes += json.Uint64(size)
+		c.usage[prefix] = usage
+		c.lock.Unlock()
+
+		next = nextKey
+		if next != nil {
+			return 0, total, false, nil
+		}
+		prefixIndex++
+		return 1, total, prefixIndex == len(statsPrefixes), nil
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/database/prefixdb"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/json"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+// Kinds of divergences between two databases
+const (
+	// OnlyInA is a key only stored in the first database
+	OnlyInA = "onlyInA"
+	// OnlyInB is a key only stored in the second database
+	OnlyInB = "onlyInB"
+	// Different is a key stored in both databases with different values
+	Different = "different"
+)
+
+// diffPrefixes are the prefixes of the state compared by DiffDatabases. The
+// data filter, the progress of jobs, the saved mempool and the external
+// anchors are local to a node and may differ between nodes agreeing on the
+// chain.
+var diffPrefixes = [][]byte{
+	singletonStatePrefix,
+	blockStatePrefix,
+	blockHeaderPrefix,
+	acceptedLogPrefix,
+	dataIndexPrefix,
+	childIndexPrefix,
+	submitterIndexPrefix,
+	namespaceIndexPrefix,
+	tagIndexPrefix,
+	archiveManifestPrefix,
+	allowlistPrefix,
+	accountPrefix,
+	freeUsagePrefix,
+	schemaPrefix,
+	redactionPrefix,
+	revealIndexPrefix,
+	recipientKeyPrefix,
+	chainHeadIndexPrefix,
+}
+
+// Divergence is a key whose value differs between two databases
+type Divergence struct {
+	// Prefix names the part of the state holding the key
+	Prefix string `json:"prefix"`
+	// Key is the hex encoded key within the prefix
+	Key string `json:"key"`
+	// Kind is OnlyInA, OnlyInB or Different
+	Kind string `json:"kind"`
+	// ValueA and ValueB are the hex encoded values, if stored
+	ValueA string `json:"valueA,omitempty"`
+	ValueB string `json:"valueB,omitempty"`
+}
+
+// PrefixDiff counts the divergences of a part of the state
+type PrefixDiff struct {
+	OnlyInA   json.Uint64 `json:"onlyInA"`
+	OnlyInB   json.Uint64 `json:"onlyInB"`
+	Different json.Uint64 `json:"different"`
+}
+
+// StateDiff reports the divergences between the states stored in two
+// databases
+type StateDiff struct {
+	// LastAcceptedA and LastAcceptedB are the last accepted blocks, or empty
+	// if the database holds no chain
+	LastAcceptedA ids.ID `json:"lastAcceptedA"`
+	LastAcceptedB ids.ID `json:"lastAcceptedB"`
+	// AcceptedHeightA and AcceptedHeightB are the heights of the last entry
+	// of the accepted logs
+	AcceptedHeightA json.Uint64 `json:"acceptedHeightA"`
+	AcceptedHeightB json.Uint64 `json:"acceptedHeightB"`
+	// FirstConflictingHeight is the lowest height at which the accepted logs
+	// hold different blocks, if any. A database lagging behind the other
+	// doesn't conflict.
+	FirstConflictingHeight *json.Uint64 `json:"firstConflictingHeight,omitempty"`
+	// Prefixes counts the divergences of each part of the state which has
+	// any
+	Prefixes map[string]PrefixDiff `json:"prefixes"`
+	// Divergences are the first divergences found, in key order within each
+	// prefix
+	Divergences []Divergence `json:"divergences"`
+}
+
+// Equal returns true if no divergence was found
+func (d *StateDiff) Equal() bool {
+	return len(d.Prefixes) == 0
+}
+
+// DiffDatabases compares the states stored in [a] and [b] and reports the
+// divergences, listing up to [limit] of them. Block bodies are compared after
+// decompression, so databases using different compression don't diverge.
+// Encrypted databases have to be wrapped to decrypt their values.
+func DiffDatabases(a database.Database, b database.Database, limit int) (*StateDiff, error) {
+	compressor, err := newBlockCompressor(NoCompression)
+	if err != nil {
+		return nil, err
+	}
+	diff := &StateDiff{
+		Prefixes:    make(map[string]PrefixDiff),
+		Divergences: []Divergence{},
+	}
+	for _, prefix := range diffPrefixes {
+		d := &stateDiffer{
+			diff:       diff,
+			compressor: compressor,
+			prefix:     string(prefix),
+			limit:      limit,
+		}
+		if err := d.compare(prefixdb.New(prefix, a), prefixdb.New(prefix, b)); err != nil {
+			return nil, err
+		}
+	}
+	return diff, nil
+}
+
+// stateDiffer compares the keys stored under one prefix of two databases
+type stateDiffer struct {
+	diff       *StateDiff
+	compressor *blockCompressor
+	prefix     string
+	limit      int
+}
+
+// compare iterates [a] and [b] in key order and records their divergences
+func (d *stateDiffer) compare(a database.Database, b database.Database) error {
+	itA := a.NewIterator()
+	defer itA.Release()
+	itB := b.NewIterator()
+	defer itB.Release()
+
+	hasA, hasB := itA.Next(), itB.Next()
+	for hasA || hasB {
+		order := 0
+		switch {
+		case !hasA:
+			order = 1
+		case !hasB:
+			order = -1
+		default:
+			order = bytes.Compare(itA.Key(), itB.Key())
+		}
+
+		switch {
+		case order < 0:
+			d.track(itA.Key(), itA.Value(), nil)
+			d.record(itA.Key(), OnlyInA, itA.Value(), nil)
+			hasA = itA.Next()
+		case order > 0:
+			d.track(itB.Key(), nil, itB.Value())
+			d.record(itB.Key(), OnlyInB, nil, itB.Value())
+			hasB = itB.Next()
+		default:
+			d.track(itA.Key(), itA.Value(), itB.Value())
+			if !d.equalValues(itA.Key(), itA.Value(), itB.Value()) {
+				d.record(itA.Key(), Different, itA.Value(), itB.Value())
+			}
+			hasA, hasB = itA.Next(), itB.Next()
+		}
+	}
+	if err := itA.Error(); err != nil {
+		return err
+	}
+	return itB.Error()
+}
+
+// record records a divergence of [kind] at [key], with the values stored in
+// both databases
+func (d *stateDiffer) record(key []byte, kind string, valueA []byte, valueB []byte) {
+	counts := d.diff.Prefixes[d.prefix]
+	switch kind {
+	case OnlyInA:
+		counts.OnlyInA++
+	case OnlyInB:
+		counts.OnlyInB++
+	default:
+		counts.Different++
+		if d.prefix == string(acceptedLogPrefix) && d.diff.FirstConflictingHeight == nil && len(key) == wrappers.LongLen {
+			height := json.Uint64(binary.BigEndian.Uint64(key))
+			d.diff.FirstConflictingHeight = &height
+		}
+	}
+	d.diff.Prefixes[d.prefix] = counts
+
+	if len(d.diff.Divergences) < d.limit {
+		d.diff.Divergences = append(d.diff.Divergences, Divergence{
+			Prefix: d.prefix,
+			Key:    hex.EncodeToString(key),
+			Kind:   kind,
+			ValueA: hex.EncodeToString(valueA),
+			ValueB: hex.EncodeToString(valueB),
+		})
+	}
+}
+
+// track records the chain tips found while comparing. The values of [key]
+// are nil if it isn't stored in a database.
+func (d *stateDiffer) track(key []byte, valueA []byte, valueB []byte) {
+	switch {
+	case d.prefix == string(blockStatePrefix) && bytes.Equal(key, lastAcceptedKey):
+		d.diff.LastAcceptedA, _ = ids.ToID(valueA)
+		d.diff.LastAcceptedB, _ = ids.ToID(valueB)
+	case d.prefix == string(acceptedLogPrefix) && len(key) == wrappers.LongLen:
+		// the log is iterated in height order, and its values are block IDs
+		height := json.Uint64(binary.BigEndian.Uint64(key))
+		if len(valueA) > 0 {
+			d.diff.AcceptedHeightA = height
+		}
+		if len(valueB) > 0 {
+			d.diff.AcceptedHeightB = height
+		}
+	}
+}
+
+// equalValues returns true if [valueA] and [valueB] are equal values of [key]
+func (d *stateDiffer) equalValues(key []byte, valueA []byte, valueB []byte) bool {
+	if bytes.Equal(valueA, valueB) {
+		return true
+	}
+	if d.prefix != string(blockStatePrefix) || len(key) != len(ids.Empty) {
+		return false
+	}
+	// block bodies may be stored with different compression
+	bodyA, errA := d.compressor.Decompress(valueA)
+	bodyB, errB := d.compressor.Decompress(valueB)
+	return errA == nil && errB == nil && bytes.Equal(bodyA, bodyB)
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/database/manager"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow"
+	"github.com/chain4travel/caminogo/snow/engine/common"
+	"github.com/chain4travel/caminogo/utils/logging"
+	"github.com/chain4travel/caminogo/version"
+)
+
+var errEmbeddedShutdown = errors.New("embedded chain is shut down")
+
+// EmbeddedConfig configures a chain run in process by NewEmbedded
+type EmbeddedConfig struct {
+	// NetworkID and ChainID identify the chain, e.g. in certificates
+	NetworkID uint32
+	ChainID   ids.ID
+	// Genesis is the data of the genesis block, at most 32 bytes
+	Genesis []byte
+	// Config is the JSON config of the chain, as a node would pass it
+	Config []byte
+	// DB holds the state of the chain. If it's nil, the state is kept in
+	// memory and lost on shutdown.
+	DB database.Database
+	// Log is the logger of the chain. If it's nil, nothing is logged.
+	Log logging.Logger
+	// Factory creates the VM, e.g. with verifiers or stores. If it's nil,
+	// the VM is created with the defaults.
+	Factory *Factory
+}
+
+// Embedded runs a chain in process, without a node or consensus, for
+// tests, simulators and single binary appliances. A stub engine builds and
+// accepts a block as soon as a submission is pending, as a chain with a
+// single validator does. The VM is driven as the node drives it: every call
+// holds the context lock.
+type Embedded struct {
+	vm       *VM
+	toEngine chan common.Message
+	// closed to stop the engine
+	stop chan struct{}
+	// closed once the engine stopped
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewEmbedded returns the chain of [config], started. It must be shut down
+// with [Embedded.Shutdown].
+func NewEmbedded(config EmbeddedConfig) (*Embedded, error) {
+	factory := config.Factory
+	if factory == nil {
+		factory = &Factory{}
+	}
+	ctx := snow.DefaultContextTest()
+	ctx.NetworkID = config.NetworkID
+	ctx.ChainID = config.ChainID
+	if config.Log != nil {
+		ctx.Log = config.Log
+	}
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	if config.DB != nil {
+		var err error
+		dbManager, err = manager.NewManagerFromDBs([]*manager.VersionedDatabase{{
+			Database: config.DB,
+			Version:  version.DefaultVersion1_0_0,
+		}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	vmIntf, err := factory.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e := &Embedded{
+		vm:       vmIntf.(*VM),
+		toEngine: make(chan common.Message, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if err := e.vm.Initialize(ctx, dbManager, config.Genesis, nil, config.Config, e.toEngine, nil, nil); err != nil {
+		return nil, err
+	}
+	if err := e.start(); err != nil {
+		_ = e.vm.Shutdown()
+		return nil, err
+	}
+	go e.runEngine()
+	return e, nil
+}
+
+// start moves the VM to normal operation on top of its last accepted block
+func (e *Embedded) start() error {
+	e.vm.ctx.Lock.Lock()
+	defer e.vm.ctx.Lock.Unlock()
+
+	if err := e.vm.SetState(snow.Bootstrapping); err != nil {
+		return err
+	}
+	if err := e.vm.SetState(snow.NormalOp); err != nil {
+		return err
+	}
+	lastAccepted, err := e.vm.LastAccepted()
+	if err != nil {
+		return err
+	}
+	return e.vm.SetPreference(lastAccepted)
+}
+
+// VM returns the VM of the chain. Its methods must only be called while
+// holding the context lock, e.g. with [Embedded.Call].
+func (e *Embedded) VM() *VM {
+	return e.vm
+}
+
+// Call calls [f] with the API of the chain, holding the context lock, as
+// the API handlers do. Requests passed to the API may be nil.
+func (e *Embedded) Call(f func(s *Service) error) error {
+	e.vm.ctx.Lock.Lock()
+	defer e.vm.ctx.Lock.Unlock()
+
+	if e.vm.isShutdown() {
+		return errEmbeddedShutdown
+	}
+	return f(&Service{vm: e.vm})
+}
+
+// Handlers returns the HTTP handlers of the chain's APIs, by path relative
+// to the chain's base path, so they can be served by the embedding program
+func (e *Embedded) Handlers() (map[string]http.Handler, error) {
+	handlers, err := e.vm.CreateHandlers()
+	if err != nil {
+		return nil, err
+	}
+	httpHandlers := make(map[string]http.Handler, len(handlers))
+	for path, handler := range handlers {
+		httpHandlers[path] = handler.Handler
+	}
+	return httpHandlers, nil
+}
+
+// Shutdown stops the engine and shuts the VM down
+func (e *Embedded) Shutdown() error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.stopped
+	return e.vm.Shutdown()
+}
+
+// runEngine builds and accepts blocks whenever the VM reports pending
+// submissions, until the chain shuts down
+func (e *Embedded) runEngine() {
+	defer close(e.stopped)
+
+	for {
+		select {
+		case <-e.toEngine:
+			e.acceptPending()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// acceptPending builds and accepts blocks until no submission is pending.
+// Built blocks are verified by the VM already. If a block can't be built,
+// the VM reports the submissions left pending again.
+func (e *Embedded) acceptPending() {
+	e.vm.ctx.Lock.Lock()
+	defer e.vm.ctx.Lock.Unlock()
+
+	for e.vm.mempool.Len() > 0 && !e.vm.isShutdown() {
+		blk, err := e.vm.BuildBlock()
+		if err != nil {
+			e.vm.ctx.Log.Warn("embedded engine couldn't build a block: %s", err)
+			return
+		}
+		if err := e.vm.SetPreference(blk.ID()); err != nil {
+			e.vm.ctx.Log.Error("embedded engine couldn't set the preference: %s", err)
+			return
+		}
+		if err := blk.Accept(); err != nil {
+			e.vm.ctx.Log.Error("embedded engine couldn't accept block %s: %s", blk.ID(), err)
+			return
+		}
+	}
+}
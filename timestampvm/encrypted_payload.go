@@ -0,0 +1,258 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+const (
+	// maxRecipients is the maximum number of recipients of an encrypted
+	// payload
+	maxRecipients = 16
+	// maxCiphertextSize is the upper bound of [Config.MaxCiphertextSize],
+	// leaving room for the rest of the block within the codec's maximum size
+	maxCiphertextSize = maxSliceLength / 2
+)
+
+var (
+	errEncryptedPayloadsDisabled = errors.New("encrypted payloads are disabled on this chain")
+	errEncryptionWithoutSigning  = errors.New("encrypted payloads require signed submissions")
+	errEncryptionWithValidators  = errors.New("encrypted payloads and validator submissions can't be enabled together")
+	errMaxCiphertextSize         = fmt.Errorf("maxCiphertextSize must be between 1 and %d", maxCiphertextSize)
+	errEncryptedPayloadData      = errors.New("block's data isn't the hash of its encrypted payload")
+	errBadRecipients             = fmt.Errorf("encrypted payloads must have 1 to %d sorted and unique recipients", maxRecipients)
+	errCiphertextSize            = errors.New("ciphertext is empty or exceeds the maximum size")
+	errKeyRegistrationData       = errors.New("block's data isn't the hash of its key registration")
+	errKeyRegistrationNonce      = errors.New("invalid key registration nonce")
+	errUnsignedKeyRegistration   = errors.New("key registrations must be signed by the registering address")
+	errBadPublicKey              = errors.New("public key isn't a valid secp256k1 public key")
+	errRecipientNotRegistered    = errors.New("recipient registered no public key")
+	errNoEncryptedPayload        = errors.New("block anchors no encrypted payload")
+	errCiphertextDropped         = errors.New("ciphertext is no longer stored")
+	errNotRecipient              = errors.New("requester isn't a recipient of the encrypted payload")
+
+	_ BlockVerifier = &encryptionVerifier{}
+)
+
+// EncryptedPayload is a payload encrypted by its submitter to the public keys
+// its recipients registered, see [KeyRegistration]. The VM neither encrypts
+// nor decrypts it. The data of the block anchoring it is its hash, under
+// which the ciphertext is served to the recipients.
+type EncryptedPayload struct {
+	// Recipients are the addresses whose registered keys the payload is
+	// encrypted to, sorted
+	Recipients []ids.ShortID `serialize:"true" json:"recipients"`
+	// Ciphertext is the encrypted payload
+	Ciphertext []byte `serialize:"true" json:"ciphertext"`
+}
+
+// Verify returns nil iff [payload] is well formed and its ciphertext is at
+// most [maxSize] bytes long
+func (payload *EncryptedPayload) Verify(maxSize int) error {
+	if len(payload.Recipients) == 0 || len(payload.Recipients) > maxRecipients || !ids.IsSortedAndUniqueShortIDs(payload.Recipients) {
+		return errBadRecipients
+	}
+	if len(payload.Ciphertext) == 0 || len(payload.Ciphertext) > maxSize {
+		return errCiphertextSize
+	}
+	return nil
+}
+
+// includes returns true if [address] is a recipient of [payload]
+func (payload *EncryptedPayload) includes(address ids.ShortID) bool {
+	for _, recipient := range payload.Recipients {
+		if recipient == address {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptedPayloadData returns the data of the block anchoring [payload]
+func EncryptedPayloadData(payload *EncryptedPayload) ([dataLen]byte, error) {
+	payloadBytes, err := Codec.Marshal(CodecVersion, payload)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(payloadBytes), nil
+}
+
+// KeyRegistration registers the public key payloads are encrypted to for the
+// address signing it. A later registration replaces the key. It's anchored
+// in a block of its own, whose data is the hash of the registration.
+type KeyRegistration struct {
+	// Nonce is the number of registrations accepted from the address before
+	// this one, so a replaced key can't be registered again by a replay
+	Nonce uint64 `serialize:"true" json:"nonce"`
+	// PublicKey is the secp256k1 public key payloads are encrypted to. It
+	// should differ from the key signing submissions.
+	PublicKey []byte `serialize:"true" json:"publicKey"`
+}
+
+// Verify returns nil iff [registration] holds a valid public key
+func (registration *KeyRegistration) Verify() error {
+	if _, err := secpFactory.ToPublicKey(registration.PublicKey); err != nil {
+		return errBadPublicKey
+	}
+	return nil
+}
+
+// KeyRegistrationData returns the data of the block anchoring [registration]
+func KeyRegistrationData(registration *KeyRegistration) ([dataLen]byte, error) {
+	registrationBytes, err := Codec.Marshal(CodecVersion, registration)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(registrationBytes), nil
+}
+
+// KeyRegistrationMessage returns the message the registering address signs to
+// propose [registration] to the chain [chainID]. It's the message signed to
+// submit the data of the block anchoring the registration.
+func KeyRegistrationMessage(chainID ids.ID, registration *KeyRegistration) ([]byte, error) {
+	data, err := KeyRegistrationData(registration)
+	if err != nil {
+		return nil, err
+	}
+	return SubmissionMessage(chainID, data)
+}
+
+// ciphertextRequest is the message signed by a recipient requesting the
+// ciphertext anchored as [Data]. Its purpose sets it apart from the message
+// signed to submit [Data].
+type ciphertextRequest struct {
+	Purpose string        `serialize:"true"`
+	ChainID ids.ID        `serialize:"true"`
+	Data    [dataLen]byte `serialize:"true"`
+}
+
+// CiphertextRequestMessage returns the message a recipient signs to retrieve
+// the ciphertext anchored as [data] on the chain [chainID]. The signature only
+// proves the requester is a recipient. It can be replayed, but only ever
+// releases the ciphertext.
+func CiphertextRequestMessage(chainID ids.ID, data [dataLen]byte) ([]byte, error) {
+	return Codec.Marshal(CodecVersion, &ciphertextRequest{
+		Purpose: "ciphertextRequest",
+		ChainID: chainID,
+		Data:    data,
+	})
+}
+
+// verifyKeyRegistration returns nil iff [registrant] may register
+// [registration], given the numbers of registrations by processing ancestors
+// in [pending]
+func (vm *VM) verifyKeyRegistration(pending map[ids.ShortID]uint64, registration *KeyRegistration, registrant ids.ShortID) error {
+	nonce, err := vm.keyRegistrationNonce(registrant)
+	if err != nil {
+		return err
+	}
+	nonce += pending[registrant]
+	if registration.Nonce != nonce {
+		return fmt.Errorf("%w: expected %d, but found %d", errKeyRegistrationNonce, nonce, registration.Nonce)
+	}
+	return registration.Verify()
+}
+
+// keyRegistrationNonce returns the nonce of the next registration accepted
+// from [address]
+func (vm *VM) keyRegistrationNonce(address ids.ShortID) (uint64, error) {
+	key, err := vm.state.GetRecipientKey(address)
+	switch err {
+	case nil:
+		return key.Nonce, nil
+	case database.ErrNotFound:
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+// applyKeyRegistration stores the key registered by the accepted [blk]
+func (vm *VM) applyKeyRegistration(blk *Block) error {
+	registrant, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	registration := blk.KeyRegistration()
+	return vm.state.PutRecipientKey(registrant, &RecipientKey{
+		Nonce:     registration.Nonce + 1,
+		PublicKey: registration.PublicKey,
+	})
+}
+
+// encryptionVerifier requires encrypted payloads and key registrations to be
+// well formed, and key registrations to be signed with the next nonce of the
+// registering address
+type encryptionVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *encryptionVerifier) VerifyBlock(blk *Block) error {
+	if payload := blk.EncryptedPayload(); payload != nil {
+		data, err := EncryptedPayloadData(payload)
+		if err != nil {
+			return err
+		}
+		if blk.Data() != data {
+			return errEncryptedPayloadData
+		}
+		return payload.Verify(v.vm.config.MaxCiphertextSize)
+	}
+
+	registration := blk.KeyRegistration()
+	if registration == nil {
+		return nil
+	}
+	if !blk.IsSigned() {
+		return errUnsignedKeyRegistration
+	}
+	data, err := KeyRegistrationData(registration)
+	if err != nil {
+		return err
+	}
+	if blk.Data() != data {
+		return errKeyRegistrationData
+	}
+	pending, err := v.pendingRegistrations(blk.Parent())
+	if err != nil {
+		return err
+	}
+	registrant, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	return v.vm.verifyKeyRegistration(pending, registration, registrant)
+}
+
+// pendingRegistrations returns the numbers of key registrations by address of
+// [blkID] and its processing ancestors
+func (v *encryptionVerifier) pendingRegistrations(blkID ids.ID) (map[ids.ShortID]uint64, error) {
+	pending := map[ids.ShortID]uint64{}
+	for {
+		blk, err := v.vm.getBlock(blkID)
+		if err != nil {
+			return nil, errDatabaseGet
+		}
+		// Accepted registrations are recorded in the state
+		if blk.Status() == choices.Accepted {
+			return pending, nil
+		}
+		if blk.KeyRegistration() != nil {
+			registrant, err := blk.Submitter()
+			if err != nil {
+				return nil, err
+			}
+			pending[registrant]++
+		}
+		blkID = blk.Parent()
+	}
+}
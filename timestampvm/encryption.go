@@ -0,0 +1,97 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/database/encdb"
+)
+
+var (
+	// Stored in the database, encrypted, once encryption is enabled. The
+	// state's keys are prefixed with hashes, so they can't collide with it.
+	encryptionMarkerKey   = []byte("encryption")
+	encryptionMarkerValue = []byte("timestampvm")
+
+	errMultipleEncryptionKeys = errors.New("only one of encryptionKeyEnv and encryptionKeyFile may be set")
+	errEmptyEncryptionKey     = errors.New("encryption key is empty")
+	errWrongEncryptionKey     = errors.New("database is encrypted with another key")
+	errEncryptedDatabase      = errors.New("database is encrypted but no encryption key is configured")
+	errUnencryptedDatabase    = errors.New("encryption can't be enabled for a database which already holds unencrypted state")
+)
+
+// encryptionKey returns the key the state is encrypted with, or nil if
+// encryption is disabled
+func (vm *VM) encryptionKey() ([]byte, error) {
+	var (
+		key []byte
+		err error
+	)
+	switch {
+	case vm.newEncryptionKey != nil:
+		key, err = vm.newEncryptionKey(vm.ctx)
+	case vm.config.EncryptionKeyEnv != "":
+		key = []byte(os.Getenv(vm.config.EncryptionKeyEnv))
+	case vm.config.EncryptionKeyFile != "":
+		key, err = os.ReadFile(vm.config.EncryptionKeyFile)
+		key = bytes.TrimSpace(key)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get encryption key: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, errEmptyEncryptionKey
+	}
+	return key, nil
+}
+
+// stateDatabase returns the database the state is stored in: [vm.db] itself,
+// or [vm.db] encrypting all values if an encryption key is configured.
+// Encryption can only be enabled for an empty database, and can't be
+// disabled afterwards.
+func (vm *VM) stateDatabase() (database.Database, error) {
+	key, err := vm.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := vm.db.Has(encryptionMarkerKey)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if encrypted {
+			return nil, errEncryptedDatabase
+		}
+		return vm.db, nil
+	}
+
+	encDB, err := encdb.New(key, vm.db)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		marker, err := encDB.Get(encryptionMarkerKey)
+		if err != nil || !bytes.Equal(marker, encryptionMarkerValue) {
+			return nil, errWrongEncryptionKey
+		}
+		return encDB, nil
+	}
+
+	empty, err := isEmpty(vm.db)
+	if err != nil {
+		return nil, err
+	}
+	if !empty {
+		return nil, errUnencryptedDatabase
+	}
+	vm.ctx.Log.Info("encrypting the state at rest")
+	return encDB, encDB.Put(encryptionMarkerKey, encryptionMarkerValue)
+}
@@ -0,0 +1,295 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+// Kinds of blocks, by what they anchor
+const (
+	DataBlockKind            = "data"
+	EncryptedBlockKind       = "encrypted"
+	AllowlistBlockKind       = "allowlistUpdate"
+	TransferBlockKind        = "transfer"
+	CreditGrantBlockKind     = "creditGrant"
+	SchemaBlockKind          = "schemaUpdate"
+	RedactionBlockKind       = "redaction"
+	RevealBlockKind          = "reveal"
+	KeyRegistrationBlockKind = "keyRegistration"
+)
+
+const (
+	// maxSummaries is the maximum number of block summaries returned at once
+	maxSummaries = 100
+	// defaultActivityEntries is the number of namespaces and submitters
+	// returned by GetRecentActivity unless set otherwise
+	defaultActivityEntries = 10
+	// maxActivityEntries is the maximum number of namespaces and submitters
+	// returned by GetRecentActivity
+	maxActivityEntries = 100
+)
+
+// BlockSummary is an accepted block as explorers list it. Fields which are
+// only set for some kinds of blocks are summarized by Preview.
+type BlockSummary struct {
+	ID        ids.ID      `json:"id"`
+	ParentID  ids.ID      `json:"parentID"`
+	Height    json.Uint64 `json:"height"`
+	Timestamp json.Uint64 `json:"timestamp"`
+	// Kind of the block, e.g. "data" or "transfer", empty if its body was
+	// dropped or it's restricted
+	Kind string `json:"kind,omitempty"`
+	// Preview is a short description of what the block anchors, e.g. the
+	// hash of the document or the amount transferred
+	Preview string `json:"preview,omitempty"`
+	// Base 58 encoded data, as returned by GetBlock
+	Data string `json:"data,omitempty"`
+	// Hex encoded data, as printed by tools like sha256sum
+	Hash string `json:"hash,omitempty"`
+	// Hash algorithm declared by the submitter, if any
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	// CID of the content the data is the digest of, if the block has one
+	CID       string            `json:"cid,omitempty"`
+	Submitter *ids.ShortID      `json:"submitter,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	// Proofs are the calls returning proofs of the data, which explorers
+	// link to
+	Proofs []ProofLink `json:"proofs,omitempty"`
+	// BodyDropped is true if the body of the block is no longer stored, only
+	// its header is summarized
+	BodyDropped bool `json:"bodyDropped,omitempty"`
+	// ID of the block redacting this block, only set for redacted blocks
+	RedactedBy *ids.ID `json:"redactedBy,omitempty"`
+	// Restricted is true if the block is in a namespace the caller may not
+	// read, only its header is summarized
+	Restricted bool `json:"restricted,omitempty"`
+}
+
+// ProofLink is a call of this API returning a proof of a block's data
+type ProofLink struct {
+	// Kind of the proof, "inclusion" or "certificate"
+	Kind   string            `json:"kind"`
+	Method string            `json:"method"`
+	Params map[string]string `json:"params"`
+}
+
+// ActivityCount is the number of blocks accepted recently for a namespace
+// or a submitter
+type ActivityCount struct {
+	Key    string      `json:"key"`
+	Blocks json.Uint64 `json:"blocks"`
+}
+
+// blockKind returns the kind of [blk] and a preview of what it anchors
+func blockKind(blk *Block) (string, string) {
+	switch {
+	case blk.AllowlistUpdate() != nil:
+		update := blk.AllowlistUpdate()
+		return AllowlistBlockKind, fmt.Sprintf("allowlist update adding %d and removing %d submitters", len(update.Add), len(update.Remove))
+	case blk.Transfer() != nil:
+		transfer := blk.Transfer()
+		return TransferBlockKind, fmt.Sprintf("transfer of %d to %s", transfer.Amount, transfer.To)
+	case blk.CreditGrant() != nil:
+		grant := blk.CreditGrant()
+		return CreditGrantBlockKind, fmt.Sprintf("grant of %d credits to %s", grant.Credits, grant.To)
+	case blk.SchemaUpdate() != nil:
+		return SchemaBlockKind, fmt.Sprintf("schema update of %q", blk.SchemaUpdate().Schema.Name)
+	case blk.Redaction() != nil:
+		redaction := blk.Redaction()
+		return RedactionBlockKind, fmt.Sprintf("redaction of block %s at height %d", redaction.BlkID, redaction.Height)
+	case blk.Reveal() != nil:
+		return RevealBlockKind, "reveal of an earlier commitment"
+	case blk.KeyRegistration() != nil:
+		return KeyRegistrationBlockKind, "registration of a recipient key"
+	case blk.EncryptedPayload() != nil:
+		return EncryptedBlockKind, fmt.Sprintf("payload encrypted to %d recipients", len(blk.EncryptedPayload().Recipients))
+	default:
+		return DataBlockKind, ""
+	}
+}
+
+// blockSummary returns the summary of the accepted block [blkID], or of its
+// header if its body was dropped or the caller of [r] may not read it
+func (vm *VM) blockSummary(r *http.Request, blkID ids.ID) (*BlockSummary, error) {
+	blk, err := vm.getBlock(blkID)
+	if err != nil {
+		header := GetBlockReply{}
+		if err := vm.fillDroppedReply(blkID, &header); err != nil {
+			return nil, err
+		}
+		return &BlockSummary{
+			ID:          header.ID,
+			ParentID:    header.ParentID,
+			Height:      header.Height,
+			Timestamp:   header.Timestamp,
+			BodyDropped: true,
+			RedactedBy:  header.RedactedBy,
+		}, nil
+	}
+	summary := &BlockSummary{
+		ID:        blkID,
+		ParentID:  blk.Parent(),
+		Height:    json.Uint64(blk.Height()),
+		Timestamp: json.Uint64(blk.Timestamp().Unix()),
+	}
+	if vm.authorizeNamespaceRead(r, blk.Namespace()) != nil {
+		summary.Restricted = true
+		return summary, nil
+	}
+
+	data := blk.Data()
+	summary.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	if err != nil {
+		return nil, err
+	}
+	summary.Hash = hex.EncodeToString(data[:])
+	summary.HashAlgorithm = declaredHashAlgorithm(blk.Tags())
+	summary.Namespace = blk.Namespace()
+	summary.Tags = tagMap(blk.Tags())
+	if blk.IsSigned() {
+		submitter, err := blk.Submitter()
+		if err != nil {
+			return nil, err
+		}
+		summary.Submitter = &submitter
+	}
+	summary.Kind, summary.Preview = blockKind(blk)
+	if summary.Kind == DataBlockKind {
+		summary.Preview = summary.Hash
+		if summary.HashAlgorithm != "" {
+			summary.Preview = summary.HashAlgorithm + ":" + summary.Hash
+		}
+		// Tags aren't verified by the VM, so a bad CID is left out
+		if cid, err := blockCID(blk); err == nil && cid != nil {
+			summary.CID = cid.String()
+			summary.Preview = cid.String()
+		}
+	}
+
+	params := map[string]string{"data": summary.Data}
+	summary.Proofs = []ProofLink{{Kind: "inclusion", Method: Name + ".getInclusionProof", Params: params}}
+	if vm.certificateKey != nil {
+		summary.Proofs = append(summary.Proofs, ProofLink{Kind: "certificate", Method: Name + ".getCertificate", Params: params})
+	}
+	return summary, nil
+}
+
+// blockSummaries returns the summaries of up to [limit] accepted blocks
+// below [beforeHeight], highest first, and the height to continue below,
+// nil once the genesis block or the first block whose header is kept was
+// summarized
+func (vm *VM) blockSummaries(ctx context.Context, r *http.Request, beforeHeight uint64, limit int) ([]BlockSummary, *json.Uint64, error) {
+	summaries := []BlockSummary{}
+	height := beforeHeight
+	for height > 0 && len(summaries) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		height--
+		blkID, err := vm.state.GetAcceptedID(height)
+		if err == database.ErrNotFound {
+			return summaries, nil, nil // pruned
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		summary, err := vm.blockSummary(r, blkID)
+		if err == database.ErrNotFound {
+			return summaries, nil, nil // pruned
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		summaries = append(summaries, *summary)
+	}
+	if height == 0 {
+		return summaries, nil, nil
+	}
+	nextHeight := json.Uint64(height)
+	return summaries, &nextHeight, nil
+}
+
+// recentActivity returns the number of blocks accepted since [cutoff], up
+// to [maxStatsBlocks] of them, whether there were more, and the [entries]
+// namespaces and submitters with the most blocks. Blocks the caller of [r]
+// may not read are counted, but not by namespace or submitter.
+func (vm *VM) recentActivity(ctx context.Context, r *http.Request, cutoff time.Time, entries int) (uint64, bool, []ActivityCount, []ActivityCount, error) {
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return 0, false, nil, nil, err
+	}
+	blocks := uint64(0)
+	truncated := false
+	namespaces := map[string]uint64{}
+	submitters := map[string]uint64{}
+	// The genesis block has no meaningful timestamp
+	for height := lastAccepted.Height(); height > 0; height-- {
+		if err := ctx.Err(); err != nil {
+			return 0, false, nil, nil, err
+		}
+		if blocks == maxStatsBlocks {
+			truncated = true
+			break
+		}
+		blkID, err := vm.state.GetAcceptedID(height)
+		if err == database.ErrNotFound {
+			break // pruned
+		}
+		if err != nil {
+			return 0, false, nil, nil, err
+		}
+		blk, err := vm.exportedBlock(blkID)
+		if err == database.ErrNotFound {
+			break // pruned
+		}
+		if err != nil {
+			return 0, false, nil, nil, err
+		}
+		if blk.Timestamp.Before(cutoff) {
+			break
+		}
+		blocks++
+		if blk.BodyDropped || vm.authorizeNamespaceRead(r, blk.Namespace) != nil {
+			continue
+		}
+		if blk.Namespace != "" {
+			namespaces[blk.Namespace]++
+		}
+		if blk.Submitter != nil {
+			submitters[blk.Submitter.String()]++
+		}
+	}
+	return blocks, truncated, topActivity(namespaces, entries), topActivity(submitters, entries), nil
+}
+
+// topActivity returns the [entries] keys of [counts] with the most blocks,
+// most first
+func topActivity(counts map[string]uint64, entries int) []ActivityCount {
+	activity := make([]ActivityCount, 0, len(counts))
+	for key, blocks := range counts {
+		activity = append(activity, ActivityCount{Key: key, Blocks: json.Uint64(blocks)})
+	}
+	sort.Slice(activity, func(i, j int) bool {
+		if activity[i].Blocks != activity[j].Blocks {
+			return activity[i].Blocks > activity[j].Blocks
+		}
+		return activity[i].Key < activity[j].Key
+	})
+	if len(activity) > entries {
+		activity = activity[:entries]
+	}
+	return activity
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+)
+
+// exportedBlock is an accepted block as exported to external stores. The
+// fields read from the body are unset if it was dropped.
+type exportedBlock struct {
+	ID        ids.ID
+	ParentID  ids.ID
+	Height    uint64
+	Timestamp time.Time
+	// Data is nil if the body was dropped
+	Data          []byte
+	Namespace     string
+	Submitter     *ids.ShortID
+	HashAlgorithm string
+	Tags          []Tag
+	BodyDropped   bool
+}
+
+// exportedBlocks returns at most [limit] accepted blocks, in height order,
+// starting at [height]
+func (vm *VM) exportedBlocks(height uint64, limit int) ([]*exportedBlock, error) {
+	blkIDs, err := vm.state.GetAcceptedIDs(vm.runCtx, height, limit)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]*exportedBlock, 0, len(blkIDs))
+	for _, blkID := range blkIDs {
+		exported, err := vm.exportedBlock(blkID)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, exported)
+	}
+	return blocks, nil
+}
+
+// exportedBlock returns the accepted block [blkID] as exported, or its header
+// if its body was dropped
+func (vm *VM) exportedBlock(blkID ids.ID) (*exportedBlock, error) {
+	blk, err := vm.getBlock(blkID)
+	if err != nil {
+		header, err := vm.state.GetBlockHeader(blkID)
+		if err != nil {
+			return nil, err
+		}
+		return &exportedBlock{
+			ID:          blkID,
+			ParentID:    header.PrntID,
+			Height:      header.Hght,
+			Timestamp:   time.Unix(header.Tmstmp, 0),
+			BodyDropped: true,
+		}, nil
+	}
+	data := blk.Data()
+	exported := &exportedBlock{
+		ID:            blkID,
+		ParentID:      blk.Parent(),
+		Height:        blk.Height(),
+		Timestamp:     blk.Timestamp(),
+		Data:          data[:],
+		Namespace:     blk.Namespace(),
+		HashAlgorithm: declaredHashAlgorithm(blk.Tags()),
+		Tags:          blk.Tags(),
+	}
+	if blk.IsSigned() {
+		submitter, err := blk.Submitter()
+		if err != nil {
+			return nil, err
+		}
+		exported.Submitter = &submitter
+	}
+	return exported, nil
+}
+
+// ExportStatus reports the state of an export of the accepted blocks to an
+// external store
+type ExportStatus struct {
+	// NextHeight is the height of the next block to export
+	NextHeight uint64 `json:"nextHeight"`
+	// Exported is the number of blocks exported since the VM started
+	Exported uint64 `json:"exported"`
+	// CheckedAt is when the accepted blocks were last checked
+	CheckedAt time.Time `json:"checkedAt"`
+	// LastError is the error of the last export, if it failed
+	LastError string `json:"lastError,omitempty"`
+}
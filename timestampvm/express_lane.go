@@ -0,0 +1,59 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	errBadExpressLaneShare       = errors.New("express lane share must be between 0 and 1")
+	errExpressLaneWithoutSigning = errors.New("the express lane requires signed submissions")
+)
+
+// expressLane recognizes the submissions of privileged submitters, which the
+// mempool puts into a reserved share of the blocks built by this node
+type expressLane struct {
+	chainID    ids.ID
+	submitters ids.ShortSet
+}
+
+// newExpressLane returns the express lane of [config] on the chain
+// [chainID], or nil if it's disabled
+func newExpressLane(config *Config, chainID ids.ID) (*expressLane, error) {
+	if config.ExpressLaneShare == 0 {
+		return nil, nil
+	}
+	submitters := ids.NewShortSet(len(config.ExpressLaneSubmitters))
+	submitters.Add(config.ExpressLaneSubmitters...)
+	if config.ExpressLaneValidators {
+		validatorSubmitters, err := parseValidatorSubmitters(config.ValidatorSubmitters)
+		if err != nil {
+			return nil, err
+		}
+		for _, submitter := range validatorSubmitters {
+			submitters.Add(submitter)
+		}
+	}
+	return &expressLane{
+		chainID:    chainID,
+		submitters: submitters,
+	}, nil
+}
+
+// Includes returns true if [sub] is signed by a privileged submitter
+func (l *expressLane) Includes(sub *submission) bool {
+	if len(sub.sig) == 0 {
+		return false
+	}
+	submitter, err := recoverSubmitter(l.chainID, sub.data, sub.namespace, sub.tags, sub.sig)
+	return err == nil && l.submitters.Contains(submitter)
+}
+
+// markExpress flags [sub] if it's in the express lane
+func (vm *VM) markExpress(sub *submission) {
+	sub.express = vm.expressLane != nil && vm.expressLane.Includes(sub)
+}
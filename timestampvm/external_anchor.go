@@ -0,0 +1,267 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+// maxExternalAnchorReplySize is the largest reply read from the bridge
+const maxExternalAnchorReplySize = 64 * 1024
+
+var (
+	errExternalAnchorsDisabled = errors.New("external anchoring is disabled")
+	errExternalAnchorFailed    = errors.New("bridge didn't publish the block")
+	errNoExternalAnchor        = errors.New("no descendant of the block is anchored externally yet")
+)
+
+// externalAnchorRequest is posted to the bridge
+type externalAnchorRequest struct {
+	ChainID ids.ID `json:"chainID"`
+	BlockID ids.ID `json:"blockID"`
+	Height  uint64 `json:"height"`
+	// Unix time in seconds of the block
+	Timestamp int64 `json:"timestamp"`
+}
+
+// externalAnchorReply is the reply of the bridge
+type externalAnchorReply struct {
+	// Network names the external chain, e.g. "ethereum" or "bitcoin"
+	Network string `json:"network"`
+	// TxID is the ID of the transaction holding the block ID
+	TxID string `json:"txID"`
+}
+
+// ExternalAnchorStatus reports the state of external anchoring
+type ExternalAnchorStatus struct {
+	// Latest is the anchor of the highest block published, if any
+	Latest *ExternalAnchor `json:"latest,omitempty"`
+	// CheckedAt is when the last accepted block was last checked
+	CheckedAt time.Time `json:"checkedAt"`
+	// LastError is the error of the last attempt to publish a block, if it
+	// failed
+	LastError string `json:"lastError,omitempty"`
+}
+
+// externalAnchorer periodically publishes the ID of the last accepted block
+// to an external chain, such as an Ethereum contract or a Bitcoin OP_RETURN
+// output, through a bridge service, and records the external transaction.
+// As every accepted block is an ancestor of the published block, the
+// transaction bounds the time all of them existed by, independently of this
+// chain's validators.
+type externalAnchorer struct {
+	vm  *VM
+	url string
+	// bearer token presented to the bridge, empty if none
+	token  []byte
+	client *http.Client
+
+	lock   sync.Mutex
+	status ExternalAnchorStatus
+
+	height   prometheus.Gauge
+	failures prometheus.Counter
+}
+
+// newExternalAnchorer returns the externalAnchorer of [vm], reporting metrics
+// to [registerer], or nil if external anchoring isn't configured
+func newExternalAnchorer(vm *VM, registerer prometheus.Registerer) (*externalAnchorer, error) {
+	config := &vm.config
+	if config.ExternalAnchorURL == "" {
+		return nil, nil
+	}
+	a := &externalAnchorer{
+		vm:     vm,
+		url:    config.ExternalAnchorURL,
+		client: &http.Client{Timeout: config.ExternalAnchorTimeout.Duration},
+		height: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "external_anchor_height",
+			Help: "height of the highest block published to the external chain",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "external_anchor_failures",
+			Help: "# of attempts to publish a block to the external chain which failed",
+		}),
+	}
+	if config.ExternalAnchorTokenFile != "" {
+		token, err := readToken(config.ExternalAnchorTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.token = token
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(a.height),
+		registerer.Register(a.failures),
+	)
+	return a, errs.Err
+}
+
+// Status returns the state of external anchoring
+func (a *externalAnchorer) Status() ExternalAnchorStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.status
+}
+
+// runPeriodically publishes the last accepted block right away and then
+// every [vm.config.ExternalAnchorInterval] until the VM shuts down
+func (a *externalAnchorer) runPeriodically() {
+	ticker := time.NewTicker(a.vm.config.ExternalAnchorInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		if err := a.anchor(); err != nil {
+			a.failures.Inc()
+			a.vm.ctx.Log.Warn("couldn't anchor last accepted block externally: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-a.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// anchor publishes the last accepted block, unless it's already published.
+// The bridge is called without holding the context lock.
+func (a *externalAnchorer) anchor() error {
+	request, latest, err := a.nextRequest()
+	if err == nil && request != nil {
+		latest, err = a.publish(request)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.status.CheckedAt = time.Now()
+	if latest != nil {
+		a.status.Latest = latest
+		a.height.Set(float64(latest.Height))
+	}
+	a.status.LastError = ""
+	if err != nil {
+		a.status.LastError = err.Error()
+	}
+	return err
+}
+
+// nextRequest returns the request publishing the last accepted block, or nil
+// if it's already published, and the latest anchor recorded
+func (a *externalAnchorer) nextRequest() (*externalAnchorRequest, *ExternalAnchor, error) {
+	a.vm.ctx.Lock.Lock()
+	defer a.vm.ctx.Lock.Unlock()
+
+	if a.vm.isShutdown() {
+		return nil, nil, nil
+	}
+	state := a.vm.state
+	latest, err := state.GetLatestExternalAnchor()
+	switch {
+	case err == database.ErrNotFound:
+		latest = nil
+	case err != nil:
+		return nil, nil, err
+	}
+	lastAcceptedID, err := state.GetLastAccepted()
+	if err != nil {
+		return nil, nil, errCannotGetLastAccepted
+	}
+	if latest != nil && latest.BlockID == lastAcceptedID {
+		return nil, latest, nil
+	}
+	lastAccepted, err := state.GetBlockHeader(lastAcceptedID)
+	if err != nil {
+		return nil, nil, errDatabaseGet
+	}
+	return &externalAnchorRequest{
+		ChainID:   a.vm.ctx.ChainID,
+		BlockID:   lastAcceptedID,
+		Height:    lastAccepted.Hght,
+		Timestamp: lastAccepted.Tmstmp,
+	}, latest, nil
+}
+
+// publish posts [request] to the bridge and records the transaction it
+// reports
+func (a *externalAnchorer) publish(request *externalAnchorRequest) (*ExternalAnchor, error) {
+	reply, err := a.post(request)
+	if err != nil {
+		return nil, err
+	}
+	anchor := &ExternalAnchor{
+		BlockID:    request.BlockID,
+		Height:     request.Height,
+		Network:    reply.Network,
+		TxID:       reply.TxID,
+		AnchoredAt: time.Now().Unix(),
+	}
+
+	a.vm.ctx.Lock.Lock()
+	defer a.vm.ctx.Lock.Unlock()
+
+	if a.vm.isShutdown() {
+		return nil, nil
+	}
+	if err := a.vm.committer.Flush(); err != nil {
+		return nil, err
+	}
+	if err := a.vm.state.PutExternalAnchor(anchor); err != nil {
+		a.vm.state.Abort()
+		return nil, err
+	}
+	if err := a.vm.state.Commit(); err != nil {
+		return nil, err
+	}
+	a.vm.ctx.Log.Info("anchored block %s at height %d in %s transaction %s", anchor.BlockID, anchor.Height, anchor.Network, anchor.TxID)
+	return anchor, nil
+}
+
+// post posts [request] to the bridge and returns its reply
+func (a *externalAnchorer) post(request *externalAnchorRequest) (*externalAnchorReply, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if len(a.token) > 0 {
+		httpRequest.Header.Set("Authorization", "Bearer "+string(a.token))
+	}
+	resp, err := a.client.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errExternalAnchorFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", errExternalAnchorFailed, resp.Status)
+	}
+
+	reply := &externalAnchorReply{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxExternalAnchorReplySize)).Decode(reply); err != nil {
+		return nil, fmt.Errorf("%w: %s", errExternalAnchorFailed, err)
+	}
+	if reply.TxID == "" {
+		return nil, fmt.Errorf("%w: no transaction ID", errExternalAnchorFailed)
+	}
+	return reply, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	_ ExternalAnchors = &externalAnchors{}
+
+	// persists the height of the latest anchor with this key. It's shorter
+	// than heights, so it can't collide with them.
+	latestExternalAnchorKey = []byte{0}
+)
+
+// ExternalAnchor records that an accepted block's ID was published to an
+// external chain
+type ExternalAnchor struct {
+	BlockID ids.ID `serialize:"true" json:"blockID"`
+	Height  uint64 `serialize:"true" json:"height"`
+	// Network names the external chain, as reported by the bridge
+	Network string `serialize:"true" json:"network"`
+	// TxID is the ID of the external transaction holding the block ID
+	TxID string `serialize:"true" json:"txID"`
+	// AnchoredAt is the Unix time in seconds the transaction was reported
+	AnchoredAt int64 `serialize:"true" json:"anchoredAt"`
+}
+
+// ExternalAnchors records the accepted blocks published to an external chain.
+// They are local to this node, so they aren't compared with other nodes.
+type ExternalAnchors interface {
+	// PutExternalAnchor records [anchor] as the latest anchor. Blocks must be
+	// published in height order.
+	PutExternalAnchor(anchor *ExternalAnchor) error
+	// GetExternalAnchor returns the anchor of the first block published at
+	// or above [height], which is the block at [height] or a descendant.
+	// Returns database.ErrNotFound if there's none.
+	GetExternalAnchor(height uint64) (*ExternalAnchor, error)
+	// GetLatestExternalAnchor returns the anchor of the highest block
+	// published.
+	// Returns database.ErrNotFound if there's none.
+	GetLatestExternalAnchor() (*ExternalAnchor, error)
+}
+
+// externalAnchors implements ExternalAnchors with a database keyed by the
+// big-endian encoded height, so iterating it yields the anchors in height
+// order
+type externalAnchors struct {
+	anchorDB database.Database
+}
+
+// NewExternalAnchors returns ExternalAnchors stored in the given db
+func NewExternalAnchors(db database.Database) ExternalAnchors {
+	return &externalAnchors{anchorDB: db}
+}
+
+// PutExternalAnchor implements the ExternalAnchors interface
+func (a *externalAnchors) PutExternalAnchor(anchor *ExternalAnchor) error {
+	anchorBytes, err := Codec.Marshal(CodecVersion, anchor)
+	if err != nil {
+		return err
+	}
+	height := database.PackUInt64(anchor.Height)
+	if err := a.anchorDB.Put(height, anchorBytes); err != nil {
+		return err
+	}
+	return a.anchorDB.Put(latestExternalAnchorKey, height)
+}
+
+// GetExternalAnchor implements the ExternalAnchors interface
+func (a *externalAnchors) GetExternalAnchor(height uint64) (*ExternalAnchor, error) {
+	it := a.anchorDB.NewIteratorWithStart(database.PackUInt64(height))
+	defer it.Release()
+
+	if !it.Next() {
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+		return nil, database.ErrNotFound
+	}
+	return parseExternalAnchor(it.Value())
+}
+
+// GetLatestExternalAnchor implements the ExternalAnchors interface
+func (a *externalAnchors) GetLatestExternalAnchor() (*ExternalAnchor, error) {
+	height, err := a.anchorDB.Get(latestExternalAnchorKey)
+	if err != nil {
+		return nil, err
+	}
+	anchorBytes, err := a.anchorDB.Get(height)
+	if err != nil {
+		return nil, err
+	}
+	return parseExternalAnchor(anchorBytes)
+}
+
+// parseExternalAnchor returns the anchor encoded as [anchorBytes]
+func parseExternalAnchor(anchorBytes []byte) (*ExternalAnchor, error) {
+	anchor := &ExternalAnchor{}
+	_, err := Codec.Unmarshal(anchorBytes, anchor)
+	return anchor, err
+}
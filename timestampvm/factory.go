@@ -15,14 +15,78 @@
 package timestampvm
 
 import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
 	"github.com/chain4travel/caminogo/snow"
 	"github.com/chain4travel/caminogo/vms"
 )
 
-var _ vms.Factory = &Factory{}
+var (
+	// ID is the ID the VM is registered under by nodes, the plugin binary's
+	// name
+	ID = ids.ID{'t', 'i', 'm', 'e', 's', 't', 'a', 'm', 'p'}
 
-// Factory ...
-type Factory struct{}
+	_ vms.Factory = &Factory{}
+)
+
+// Factory creates the VMs of the chains the node runs. Its fields configure
+// parts of the VMs which can't be set in the chain config.
+type Factory struct {
+	// Verifiers are registered with every VM created by this factory
+	Verifiers []BlockVerifier
+	// ArchiveStore, if set, holds archived blocks instead of the directory
+	// configured with archiveDir
+	ArchiveStore ArchiveStore
+	// BackupStore, if set, holds scheduled backups instead of the directory
+	// configured with backupDir
+	BackupStore BackupStore
+	// NewDatabase, if set, opens the database the state of a VM is stored in
+	// instead of the configured backend. The VM closes it on shutdown.
+	NewDatabase func(ctx *snow.Context) (database.Database, error)
+	// EncryptionKey, if set, returns the key the state of a VM is encrypted
+	// with, e.g. fetched from a KMS, instead of the configured key
+	EncryptionKey func(ctx *snow.Context) ([]byte, error)
+	// NewState, if set, creates the state of a VM on top of its database
+	// instead of NewState, e.g. to wrap the State returned by NewState with
+	// a mock in unit tests or to try another storage layout. Combined with
+	// the "memdb" database backend, the state is kept in memory.
+	NewState func(db database.Database, vm *VM) (State, error)
+	// Tracer, if set, traces the VMs if tracing is enabled in their config,
+	// instead of exporting or logging their spans. It's meant to wrap an
+	// OpenTelemetry tracer.
+	Tracer Tracer
+	// TimeSources are compared with the local clock of every VM, in addition
+	// to the NTP servers in their config, e.g. Roughtime servers
+	TimeSources []TimeSource
+}
+
+// New implements the vms.Factory interface
+func (f *Factory) New(ctx *snow.Context) (interface{}, error) {
+	vm := NewVM(f.Verifiers...)
+	vm.archiveStore = f.ArchiveStore
+	vm.backupStore = f.BackupStore
+	vm.newEncryptionKey = f.EncryptionKey
+	vm.newState = f.NewState
+	vm.tracer = f.Tracer
+	vm.timeSources = f.TimeSources
+	if f.NewDatabase != nil {
+		db, err := f.NewDatabase(ctx)
+		if err != nil {
+			return nil, err
+		}
+		vm.db = db
+		vm.ownedDB = db
+	}
+	return vm, nil
+}
 
-// New ...
-func (f *Factory) New(*snow.Context) (interface{}, error) { return &VM{}, nil }
+// Register registers [factory] with [manager] under [ID], so a custom node
+// build runs the VM in process instead of as a plugin. If [factory] is nil,
+// the VMs are created with the defaults. Aliases, e.g. [Name], are left to
+// the node's VM aliases.
+func Register(manager vms.Manager, factory *Factory) error {
+	if factory == nil {
+		factory = &Factory{}
+	}
+	return manager.RegisterFactory(ID, factory)
+}
@@ -0,0 +1,565 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	safemath "github.com/chain4travel/caminogo/utils/math"
+)
+
+var (
+	errFeesDisabled        = errors.New("fees are disabled on this chain")
+	errFeesWithoutSigning  = errors.New("fees require signed submissions")
+	errFeesWithValidators  = errors.New("fees and validator submissions can't be enabled together")
+	errBadFeeAddress       = errors.New("invalid fee address")
+	errInsufficientBalance = errors.New("submitter's balance doesn't cover the fee")
+	errTransferNonce       = errors.New("transfer or credit grant has the wrong nonce")
+	errReplayedSubmission  = errors.New("submission was already charged to its submitter")
+	errTransferData        = errors.New("block's data isn't the hash of its transfer")
+	errEmptyTransfer       = errors.New("transfer amount must be positive")
+	errFeeDemandCurve      = errors.New("feeDemandCurve must have increasing block counts and positive percentages")
+	errRetentionBelowCurve = errors.New("pruning must retain at least the blocks of the fee demand curve")
+	errFundingWithoutFees  = errors.New("funding issuers require fees")
+	errUnknownFeePriority  = errors.New("unknown fee priority")
+
+	_ BlockVerifier = &feeVerifier{}
+)
+
+// Transfer moves funds from the account of its signer to another account.
+// It's anchored in a block of its own, whose data is the hash of the
+// transfer and whose signature is by the sender. A transfer signed by a
+// funding issuer credits new funds instead, e.g. funds paid for off-chain.
+type Transfer struct {
+	// Nonce is the number of transfers accepted from the sender before this
+	// one, so a transfer can't be replayed
+	Nonce uint64 `serialize:"true" json:"nonce"`
+	// To is the address credited
+	To ids.ShortID `serialize:"true" json:"to"`
+	// Amount is the amount moved, on top of the fee
+	Amount uint64 `serialize:"true" json:"amount"`
+}
+
+// TransferData returns the data of the block anchoring [transfer]
+func TransferData(transfer *Transfer) ([dataLen]byte, error) {
+	transferBytes, err := Codec.Marshal(CodecVersion, transfer)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(transferBytes), nil
+}
+
+// TransferMessage returns the message the sender signs to propose [transfer]
+// to the chain [chainID]. It's the message signed to submit the data of the
+// block anchoring the transfer.
+func TransferMessage(chainID ids.ID, transfer *Transfer) ([]byte, error) {
+	data, err := TransferData(transfer)
+	if err != nil {
+		return nil, err
+	}
+	return SubmissionMessage(chainID, data)
+}
+
+// Priorities of fee estimates. Blocks are built in the order submissions are
+// proposed, so the priority is the congestion an estimate budgets for.
+const (
+	// LowFeePriority estimates the fee of a block built right away
+	LowFeePriority = "low"
+	// MediumFeePriority estimates the fee of a block built after the
+	// submissions pending in the mempool
+	MediumFeePriority = "medium"
+	// HighFeePriority estimates the highest fee the demand curve charges
+	HighFeePriority = "high"
+)
+
+// FeeStep is a step of the fee demand curve
+type FeeStep struct {
+	// Blocks is the number of recent blocks from which the step applies
+	Blocks uint64 `json:"blocks"`
+	// Percent is the percentage of the size-based fee charged from then on
+	Percent uint64 `json:"percent"`
+}
+
+// verifyFeeDemandCurve returns errFeeDemandCurve unless the steps of [curve]
+// apply to increasing numbers of blocks and charge positive percentages
+func verifyFeeDemandCurve(curve []FeeStep) error {
+	for i, step := range curve {
+		if step.Percent == 0 || (i > 0 && step.Blocks <= curve[i-1].Blocks) {
+			return errFeeDemandCurve
+		}
+	}
+	return nil
+}
+
+// maxDemandBlocks returns the number of recent blocks from which the last
+// step of the fee demand curve applies, 0 if there are no steps
+func (c *Config) maxDemandBlocks() uint64 {
+	if len(c.FeeDemandCurve) == 0 {
+		return 0
+	}
+	return c.FeeDemandCurve[len(c.FeeDemandCurve)-1].Blocks
+}
+
+// recentBlocks returns the number of blocks within
+// [vm.config.FeeDemandWindow] up to and including [parent], counting at most
+// up to the last step of the fee demand curve. The genesis block isn't a
+// submission, so it doesn't count.
+func (vm *VM) recentBlocks(parent *Block) (uint64, error) {
+	maxBlocks := vm.config.maxDemandBlocks()
+	since := parent.Timestamp().Add(-vm.config.FeeDemandWindow.Duration)
+	count := uint64(0)
+	// Headers are retained for redacted ancestors
+	header := newBlockHeader(parent)
+	for count < maxBlocks && header.Hght > 0 && time.Unix(header.Tmstmp, 0).After(since) {
+		count++
+		var err error
+		header, err = vm.getBlockHeader(header.PrntID)
+		if err != nil {
+			return 0, errDatabaseGet
+		}
+	}
+	return count, nil
+}
+
+// demandPercent returns the percentage of the size-based fee charged for a
+// child of [parent], according to the fee demand curve
+func (vm *VM) demandPercent(parent *Block) (uint64, error) {
+	recent, err := vm.recentBlocks(parent)
+	if err != nil {
+		return 0, err
+	}
+	return vm.curvePercent(recent), nil
+}
+
+// curvePercent returns the percentage of the size-based fee charged after
+// [recent] recent blocks, according to the fee demand curve
+func (vm *VM) curvePercent(recent uint64) uint64 {
+	percent := uint64(100)
+	for _, step := range vm.config.FeeDemandCurve {
+		if recent < step.Blocks {
+			break
+		}
+		percent = step.Percent
+	}
+	return percent
+}
+
+// blockFee returns the fee charged for a child of [parent] of [size] bytes:
+// [vm.config.SubmissionFee] plus [vm.config.FeePerByte] per byte, scaled by
+// the demand for blocks up to [parent]
+func (vm *VM) blockFee(parent *Block, size uint64) (uint64, error) {
+	percent, err := vm.demandPercent(parent)
+	if err != nil {
+		return 0, err
+	}
+	return vm.scaledFee(size, percent)
+}
+
+// scaledFee returns the fee of a block of [size] bytes at [percent] of the
+// size-based fee
+func (vm *VM) scaledFee(size uint64, percent uint64) (uint64, error) {
+	sizeFee, err := safemath.Mul64(vm.config.FeePerByte, size)
+	if err != nil {
+		return 0, err
+	}
+	fee, err := safemath.Add64(vm.config.SubmissionFee, sizeFee)
+	if err != nil {
+		return 0, err
+	}
+	fee, err = safemath.Mul64(fee, percent)
+	if err != nil {
+		return 0, err
+	}
+	return fee / 100, nil
+}
+
+// estimateFee returns the fee of a block of [size] bytes built on the
+// preferred block at [priority], and the percentage of the size-based fee
+// it's charged
+func (vm *VM) estimateFee(size uint64, priority string) (uint64, uint64, error) {
+	preferred, err := vm.getBlock(vm.preferred)
+	if err != nil {
+		return 0, 0, errDatabaseGet
+	}
+	recent, err := vm.recentBlocks(preferred)
+	if err != nil {
+		return 0, 0, err
+	}
+	percent := uint64(0)
+	switch priority {
+	case LowFeePriority:
+		percent = vm.curvePercent(recent)
+	case "", MediumFeePriority:
+		percent = vm.curvePercent(recent + uint64(vm.mempool.Len()))
+	case HighFeePriority:
+		percent = 100
+		for _, step := range vm.config.FeeDemandCurve {
+			if step.Percent > percent {
+				percent = step.Percent
+			}
+		}
+	default:
+		return 0, 0, fmt.Errorf("%w %q", errUnknownFeePriority, priority)
+	}
+	fee, err := vm.scaledFee(size, percent)
+	return fee, percent, err
+}
+
+// submissionFee returns the fee charged for [sub] if it was built on the
+// preferred block now, and the size of that block
+func (vm *VM) submissionFee(sub *submission) (uint64, uint64, error) {
+	preferred, err := vm.getBlock(vm.preferred)
+	if err != nil {
+		return 0, 0, err
+	}
+	blk, err := vm.newBlock(preferred.ID(), preferred.Height()+1, sub, time.Now())
+	if err != nil {
+		return 0, 0, err
+	}
+	size := uint64(len(blk.Bytes()))
+	fee, err := vm.blockFee(preferred, size)
+	return fee, size, err
+}
+
+// parseFeeBalances returns the balances of [Config.FeeBalances] by address
+func parseFeeBalances(balances map[string]uint64) (map[ids.ShortID]uint64, error) {
+	parsed := make(map[ids.ShortID]uint64, len(balances))
+	for addressStr, balance := range balances {
+		address, err := ids.ShortFromString(addressStr)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", errBadFeeAddress, addressStr)
+		}
+		parsed[address] = balance
+	}
+	return parsed, nil
+}
+
+// accountView reads and writes accounts
+type accountView interface {
+	GetAccount(address ids.ShortID) (*Account, error)
+	PutAccount(address ids.ShortID, account *Account) error
+	GetCredits(address ids.ShortID) (uint64, error)
+	PutCredits(address ids.ShortID, credits uint64) error
+	IsCharged(address ids.ShortID, data [dataLen]byte) (bool, error)
+	PutCharged(address ids.ShortID, data [dataLen]byte) error
+}
+
+// chargedSubmission identifies a submission charged to its submitter
+type chargedSubmission struct {
+	address ids.ShortID
+	data    [dataLen]byte
+}
+
+// accountOverlay is an accountView buffering writes over the accepted
+// accounts, used to verify blocks on top of processing ancestors
+type accountOverlay struct {
+	accepted       Accounts
+	changed        map[ids.ShortID]*Account
+	changedCredits map[ids.ShortID]uint64
+	charged        map[chargedSubmission]struct{}
+}
+
+// GetAccount returns the account of [address], as changed by the writes
+func (o *accountOverlay) GetAccount(address ids.ShortID) (*Account, error) {
+	if account, ok := o.changed[address]; ok {
+		copied := *account
+		return &copied, nil
+	}
+	return o.accepted.GetAccount(address)
+}
+
+// PutAccount buffers [account] as the account of [address]
+func (o *accountOverlay) PutAccount(address ids.ShortID, account *Account) error {
+	o.changed[address] = account
+	return nil
+}
+
+// GetCredits returns the prepaid credits of [address], as changed by the
+// writes
+func (o *accountOverlay) GetCredits(address ids.ShortID) (uint64, error) {
+	if credits, ok := o.changedCredits[address]; ok {
+		return credits, nil
+	}
+	return o.accepted.GetCredits(address)
+}
+
+// PutCredits buffers [credits] as the prepaid credits of [address]
+func (o *accountOverlay) PutCredits(address ids.ShortID, credits uint64) error {
+	o.changedCredits[address] = credits
+	return nil
+}
+
+// IsCharged returns true if the submission of [data] signed by [address]
+// was charged, including by the writes
+func (o *accountOverlay) IsCharged(address ids.ShortID, data [dataLen]byte) (bool, error) {
+	if _, ok := o.charged[chargedSubmission{address: address, data: data}]; ok {
+		return true, nil
+	}
+	return o.accepted.IsCharged(address, data)
+}
+
+// PutCharged buffers the submission of [data] signed by [address] as
+// charged
+func (o *accountOverlay) PutCharged(address ids.ShortID, data [dataLen]byte) error {
+	o.charged[chargedSubmission{address: address, data: data}] = struct{}{}
+	return nil
+}
+
+// feeVerifier requires the submitters of blocks to pay their fee and the
+// senders of transfers to cover them.
+// Balances are the accepted ones, changed by the processing ancestors.
+type feeVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (f *feeVerifier) VerifyBlock(blk *Block) error {
+	// Allowlist updates are signed by admins, who don't pay for them
+	if blk.AllowlistUpdate() != nil {
+		return nil
+	}
+	if !blk.IsSigned() {
+		return errUnsignedSubmission
+	}
+	if transfer := blk.Transfer(); transfer != nil {
+		data, err := TransferData(transfer)
+		if err != nil {
+			return err
+		}
+		if blk.Data() != data {
+			return errTransferData
+		}
+		if transfer.Amount == 0 {
+			return errEmptyTransfer
+		}
+	}
+	if blk.CreditGrant() != nil {
+		if err := verifyCreditGrant(blk); err != nil {
+			return err
+		}
+	}
+
+	pending, err := f.pendingBlocks(blk.Parent())
+	if err != nil {
+		return err
+	}
+	overlay := &accountOverlay{
+		accepted:       f.vm.state,
+		changed:        make(map[ids.ShortID]*Account),
+		changedCredits: make(map[ids.ShortID]uint64),
+		charged:        make(map[chargedSubmission]struct{}),
+	}
+	for _, ancestor := range pending {
+		if _, err := f.vm.chargeFees(overlay, ancestor); err != nil {
+			return err
+		}
+	}
+	_, err = f.vm.chargeFees(overlay, blk)
+	return err
+}
+
+// pendingBlocks returns [blkID] and its processing ancestors, the oldest
+// first
+func (f *feeVerifier) pendingBlocks(blkID ids.ID) ([]*Block, error) {
+	pending := []*Block(nil)
+	for {
+		blk, err := f.vm.getBlock(blkID)
+		if err != nil {
+			return nil, errDatabaseGet
+		}
+		// Accepted blocks are charged in the state
+		if blk.Status() == choices.Accepted {
+			break
+		}
+		pending = append(pending, blk)
+		blkID = blk.Parent()
+	}
+	for i, j := 0, len(pending)-1; i < j; i, j = i+1, j-1 {
+		pending[i], pending[j] = pending[j], pending[i]
+	}
+	return pending, nil
+}
+
+// chargeFees debits the fee and the transfer of [blk], if any, from the
+// account of its submitter in [accounts], and credits them to the fee
+// recipient and the transfer's recipient. Transfers signed by a funding
+// issuer only credit their recipient, and credit grants the credits of
+// theirs. Blocks anchoring data are paid by a prepaid credit, if the
+// submitter has one left.
+// Signatures of data don't cover a nonce, so each data is charged to a
+// submitter once. Otherwise anyone could propose a signed submission again
+// to charge its submitter again. Transfers and credit grants are protected
+// by their nonce instead.
+// Returns the fee charged, errReplayedSubmission if the submission was
+// charged before, and errInsufficientBalance if the submitter can't pay.
+func (vm *VM) chargeFees(accounts accountView, blk *Block) (uint64, error) {
+	if blk.AllowlistUpdate() != nil || blk.SchemaUpdate() != nil || blk.Redaction() != nil || !blk.IsSigned() {
+		return 0, nil
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return 0, err
+	}
+	sender, err := accounts.GetAccount(submitter)
+	if err != nil {
+		return 0, err
+	}
+	transfer := blk.Transfer()
+	if transfer != nil && transfer.Nonce != sender.Nonce {
+		return 0, fmt.Errorf("%w: expected %d, but found %d", errTransferNonce, sender.Nonce, transfer.Nonce)
+	}
+	if grant := blk.CreditGrant(); grant != nil {
+		if !vm.fundingIssuers.Contains(submitter) {
+			return 0, errNotFundingIssuer
+		}
+		if grant.Nonce != sender.Nonce {
+			return 0, fmt.Errorf("%w: expected %d, but found %d", errTransferNonce, sender.Nonce, grant.Nonce)
+		}
+		sender.Nonce++
+		if err := accounts.PutAccount(submitter, sender); err != nil {
+			return 0, err
+		}
+		credits, err := accounts.GetCredits(grant.To)
+		if err != nil {
+			return 0, err
+		}
+		credits, err = safemath.Add64(credits, grant.Credits)
+		if err != nil {
+			return 0, err
+		}
+		return 0, accounts.PutCredits(grant.To, credits)
+	}
+	// Issuers fund accounts free of charge. Their nonce still protects the
+	// funding from being replayed.
+	if transfer != nil && vm.fundingIssuers.Contains(submitter) {
+		sender.Nonce++
+		if err := accounts.PutAccount(submitter, sender); err != nil {
+			return 0, err
+		}
+		return 0, credit(accounts, transfer.To, transfer.Amount)
+	}
+
+	if transfer == nil {
+		charged, err := accounts.IsCharged(submitter, blk.Data())
+		if err != nil {
+			return 0, err
+		}
+		if charged {
+			return 0, fmt.Errorf("%w: %s", errReplayedSubmission, submitter)
+		}
+		if err := accounts.PutCharged(submitter, blk.Data()); err != nil {
+			return 0, err
+		}
+	}
+
+	parent, err := vm.getBlock(blk.Parent())
+	if err != nil {
+		return 0, errDatabaseGet
+	}
+	fee, err := vm.blockFee(parent, uint64(len(blk.Bytes())))
+	if err != nil {
+		return 0, err
+	}
+	free, err := vm.isFreeAnchor(blk)
+	if err != nil {
+		return 0, err
+	}
+	if free {
+		fee = 0
+	}
+	if !free && countsAsAnchor(blk) {
+		credits, err := accounts.GetCredits(submitter)
+		if err != nil {
+			return 0, err
+		}
+		if credits > 0 {
+			if err := accounts.PutCredits(submitter, credits-1); err != nil {
+				return 0, err
+			}
+			fee = 0
+		}
+	}
+	required := fee
+	if transfer != nil {
+		required, err = safemath.Add64(required, transfer.Amount)
+		if err != nil {
+			return 0, err
+		}
+		sender.Nonce++
+	}
+	if sender.Balance < required {
+		return 0, fmt.Errorf("%w: %s holds %d of %d", errInsufficientBalance, submitter, sender.Balance, required)
+	}
+	sender.Balance -= required
+	if err := accounts.PutAccount(submitter, sender); err != nil {
+		return 0, err
+	}
+
+	if transfer != nil {
+		if err := credit(accounts, transfer.To, transfer.Amount); err != nil {
+			return 0, err
+		}
+	}
+	// Without a recipient, fees are burnt
+	if vm.config.FeeRecipient == ids.ShortEmpty {
+		return fee, nil
+	}
+	return fee, credit(accounts, vm.config.FeeRecipient, fee)
+}
+
+// recordFee adds [fee] to the accepted total of burnt or collected fees
+func (vm *VM) recordFee(fee uint64) error {
+	if fee == 0 {
+		return nil
+	}
+	totals, err := vm.state.GetFeeTotals()
+	if err != nil {
+		return err
+	}
+	if vm.config.FeeRecipient == ids.ShortEmpty {
+		totals.Burnt, err = safemath.Add64(totals.Burnt, fee)
+	} else {
+		totals.Collected, err = safemath.Add64(totals.Collected, fee)
+	}
+	if err != nil {
+		return err
+	}
+	return vm.state.PutFeeTotals(totals)
+}
+
+// credit adds [amount] to the balance of [address] in [accounts]
+func credit(accounts accountView, address ids.ShortID, amount uint64) error {
+	account, err := accounts.GetAccount(address)
+	if err != nil {
+		return err
+	}
+	account.Balance, err = safemath.Add64(account.Balance, amount)
+	if err != nil {
+		return err
+	}
+	return accounts.PutAccount(address, account)
+}
+
+// initAccounts credits [vm.config.FeeBalances] to their addresses unless the
+// accounts were initialized before
+func (vm *VM) initAccounts() error {
+	initialized, err := vm.state.AccountsInitialized()
+	if err != nil || initialized {
+		return err
+	}
+	balances, err := parseFeeBalances(vm.config.FeeBalances)
+	if err != nil {
+		return err
+	}
+	vm.ctx.Log.Info("initializing the balances of %d accounts", len(balances))
+	if err := vm.state.InitAccounts(balances); err != nil {
+		return err
+	}
+	return vm.committer.Flush()
+}
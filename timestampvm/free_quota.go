@@ -0,0 +1,107 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+)
+
+var errFreeAnchorsWithoutSigning = errors.New("free daily anchors require signed submissions")
+
+// utcDay returns the UTC day of [t], in days since the unix epoch
+func utcDay(t time.Time) uint64 {
+	return uint64(t.Unix()) / secondsPerDay
+}
+
+// countsAsAnchor returns true if [blk] anchors data of a submitter, which
+// may be free. Operations are paid for otherwise.
+func countsAsAnchor(blk *Block) bool {
+	return blk.IsSigned() && !blk.isOperation()
+}
+
+// isFreeAnchor returns true if [blk] is within the free daily allowance of
+// its submitter: fewer than [vm.config.FreeDailyAnchors] anchors of the
+// submitter precede it on the UTC day of its timestamp. Anchors are counted
+// in the accepted state and the processing ancestors of [blk].
+func (vm *VM) isFreeAnchor(blk *Block) (bool, error) {
+	if vm.config.FreeDailyAnchors == 0 || !countsAsAnchor(blk) {
+		return false, nil
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return false, err
+	}
+	day := utcDay(blk.Timestamp())
+	used := uint64(0)
+	ancestorID := blk.Parent()
+	for {
+		ancestor, err := vm.getBlock(ancestorID)
+		if err != nil {
+			return false, errDatabaseGet
+		}
+		if ancestor.Status() == choices.Accepted {
+			break
+		}
+		if countsAsAnchor(ancestor) && utcDay(ancestor.Timestamp()) == day {
+			ancestorSubmitter, err := ancestor.Submitter()
+			if err != nil {
+				return false, err
+			}
+			if ancestorSubmitter == submitter {
+				used++
+			}
+		}
+		ancestorID = ancestor.Parent()
+	}
+	usage, err := vm.state.GetFreeUsage(submitter)
+	if err != nil {
+		return false, err
+	}
+	if usage.Day == day {
+		used += usage.Anchors
+	}
+	return used < vm.config.FreeDailyAnchors, nil
+}
+
+// freeAnchorsLeft returns the number of free anchors [submitter] has left
+// on the UTC day of [now], as of the last accepted block
+func (vm *VM) freeAnchorsLeft(submitter ids.ShortID, now time.Time) (uint64, error) {
+	usage, err := vm.state.GetFreeUsage(submitter)
+	if err != nil {
+		return 0, err
+	}
+	if usage.Day != utcDay(now) {
+		return vm.config.FreeDailyAnchors, nil
+	}
+	if usage.Anchors >= vm.config.FreeDailyAnchors {
+		return 0, nil
+	}
+	return vm.config.FreeDailyAnchors - usage.Anchors, nil
+}
+
+// recordAnchor counts the accepted [blk] against the free daily allowance of
+// its submitter
+func (vm *VM) recordAnchor(blk *Block) error {
+	if vm.config.FreeDailyAnchors == 0 || !countsAsAnchor(blk) {
+		return nil
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	usage, err := vm.state.GetFreeUsage(submitter)
+	if err != nil {
+		return err
+	}
+	day := utcDay(blk.Timestamp())
+	if usage.Day != day {
+		usage = &FreeUsage{Day: day}
+	}
+	usage.Anchors++
+	return vm.state.PutFreeUsage(submitter, usage)
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var _ FreeUsages = &freeUsages{}
+
+// FreeUsage counts the anchors of an address on its last day of use
+type FreeUsage struct {
+	// Day is the UTC day of the last anchor, in days since the unix epoch
+	Day uint64 `serialize:"true" json:"day"`
+	// Anchors is the number of anchors on [Day], including the ones which
+	// exceeded the free allowance
+	Anchors uint64 `serialize:"true" json:"anchors"`
+}
+
+// FreeUsages holds the use of the free daily allowance as of the last
+// accepted block
+type FreeUsages interface {
+	// GetFreeUsage returns the usage of [address], which is empty if it
+	// never anchored data
+	GetFreeUsage(address ids.ShortID) (*FreeUsage, error)
+	// PutFreeUsage stores [usage] as the usage of [address]
+	PutFreeUsage(address ids.ShortID, usage *FreeUsage) error
+}
+
+// freeUsages implements FreeUsages with a database keyed by address
+type freeUsages struct {
+	usageDB database.Database
+}
+
+// NewFreeUsages returns FreeUsages stored in the given db
+func NewFreeUsages(db database.Database) FreeUsages {
+	return &freeUsages{usageDB: db}
+}
+
+// GetFreeUsage implements the FreeUsages interface
+func (u *freeUsages) GetFreeUsage(address ids.ShortID) (*FreeUsage, error) {
+	usageBytes, err := u.usageDB.Get(address.Bytes())
+	if err == database.ErrNotFound {
+		return &FreeUsage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	usage := &FreeUsage{}
+	_, err = Codec.Unmarshal(usageBytes, usage)
+	return usage, err
+}
+
+// PutFreeUsage implements the FreeUsages interface
+func (u *freeUsages) PutFreeUsage(address ids.ShortID, usage *FreeUsage) error {
+	usageBytes, err := Codec.Marshal(CodecVersion, usage)
+	if err != nil {
+		return err
+	}
+	return u.usageDB.Put(address.Bytes(), usageBytes)
+}
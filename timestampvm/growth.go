@@ -0,0 +1,144 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+// Intervals the chain growth can be aggregated by
+const (
+	HourInterval = "hour"
+	DayInterval  = "day"
+)
+
+const (
+	// maximum number of buckets returned by a single call
+	maxGrowthBuckets = 1000
+	// number of buckets returned if no start is requested
+	defaultGrowthBuckets = 24
+)
+
+var (
+	errUnknownInterval = errors.New("interval must be \"hour\" or \"day\"")
+	errBadGrowthRange  = errors.New("start must be before end")
+	errTooManyBuckets  = fmt.Errorf("at most %d buckets can be requested", maxGrowthBuckets)
+)
+
+// GrowthBucket is the number of blocks accepted within an interval
+type GrowthBucket struct {
+	// Start is the unix time the interval starts at
+	Start json.Uint64 `json:"start"`
+	// Blocks is the number of accepted blocks whose timestamp is within the
+	// interval. Each block anchors one piece of data.
+	Blocks json.Uint64 `json:"blocks"`
+}
+
+// intervalLength returns the length of [interval] in seconds
+func intervalLength(interval string) (int64, error) {
+	switch interval {
+	case HourInterval:
+		return int64(time.Hour / time.Second), nil
+	case DayInterval:
+		return int64(24 * time.Hour / time.Second), nil
+	default:
+		return 0, errUnknownInterval
+	}
+}
+
+// chainGrowth returns the number of blocks accepted per [interval], for the
+// intervals from the one holding [start] up to the one holding [end],
+// both unix times. Intervals are aligned to UTC.
+func (vm *VM) chainGrowth(ctx context.Context, interval string, start, end int64) ([]GrowthBucket, error) {
+	length, err := intervalLength(interval)
+	if err != nil {
+		return nil, err
+	}
+	if start > end {
+		return nil, errBadGrowthRange
+	}
+	start -= start % length
+	numBuckets := (end-start)/length + 1
+	if numBuckets > maxGrowthBuckets {
+		return nil, errTooManyBuckets
+	}
+	buckets := make([]GrowthBucket, numBuckets)
+	for i := range buckets {
+		buckets[i].Start = json.Uint64(start + int64(i)*length)
+	}
+
+	height, err := vm.firstHeightAt(start)
+	if err != nil {
+		return nil, err
+	}
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return nil, err
+	}
+	for ; height <= lastAccepted.Height(); height++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		header, err := vm.acceptedHeader(height)
+		if err != nil {
+			return nil, err
+		}
+		if header.Tmstmp > end {
+			break
+		}
+		buckets[(header.Tmstmp-start)/length].Blocks++
+	}
+	return buckets, nil
+}
+
+// firstHeightAt returns the height of the first accepted block whose
+// timestamp is at least [timestamp], or the height after the last accepted
+// block if there is none. Timestamps don't decrease along the chain, so the
+// accepted log is searched like a time index.
+func (vm *VM) firstHeightAt(timestamp int64) (uint64, error) {
+	// The genesis block has no meaningful timestamp, and the headers of
+	// pruned blocks may be deleted
+	low := uint64(1)
+	if !vm.config.PruningKeepHeaders {
+		prunedHeight, err := vm.state.GetPrunedHeight()
+		if err != nil {
+			return 0, err
+		}
+		if prunedHeight > low {
+			low = prunedHeight
+		}
+	}
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return 0, err
+	}
+	high := lastAccepted.Height() + 1
+	for low < high {
+		mid := low + (high-low)/2
+		header, err := vm.acceptedHeader(mid)
+		if err != nil {
+			return 0, err
+		}
+		if header.Tmstmp < timestamp {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
+
+// acceptedHeader returns the header of the block accepted at [height]
+func (vm *VM) acceptedHeader(height uint64) (*BlockHeader, error) {
+	blkID, err := vm.state.GetAcceptedID(height)
+	if err != nil {
+		return nil, err
+	}
+	return vm.state.GetBlockHeader(blkID)
+}
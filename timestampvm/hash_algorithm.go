@@ -0,0 +1,108 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// hashAlgorithmTagKey is the key of the tag declaring the digest
+	// algorithm the data of a block was computed with. Data of blocks
+	// declaring none is taken to be a SHA-256 digest.
+	hashAlgorithmTagKey = "hashAlgorithm"
+
+	// SHA256 is the digest algorithm SHA-256, the default
+	SHA256 = "sha256"
+	// SHA3256 is the digest algorithm SHA3-256
+	SHA3256 = "sha3-256"
+	// Blake2b256 is the digest algorithm BLAKE2b with a 256 bit digest
+	Blake2b256 = "blake2b-256"
+)
+
+var (
+	errUnknownHashAlgorithm = errors.New("unknown hash algorithm")
+	errRecordHashAlgorithm  = fmt.Errorf("records are hashed with %s", SHA256)
+	errConflictingAlgorithm = errors.New("tags declare another hash algorithm")
+	errSaltedHashAlgorithm  = fmt.Errorf("salted documents are hashed with %s", SHA256)
+
+	// the digest algorithms by name, in the order documents are looked up
+	// when no algorithm is given
+	hashAlgorithms = []string{SHA256, SHA3256, Blake2b256}
+)
+
+// Digest returns the digest of [document] computed with [algorithm]
+func Digest(algorithm string, document []byte) ([dataLen]byte, error) {
+	digest := [dataLen]byte{}
+	hasher, err := newHasher(algorithm)
+	if err != nil {
+		return digest, err
+	}
+	_, _ = hasher.Write(document)
+	copy(digest[:], hasher.Sum(nil))
+	return digest, nil
+}
+
+// newHasher returns a hash computing digests with [algorithm], so documents
+// can be hashed as they are read
+func newHasher(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA3256:
+		return sha3.New256(), nil
+	case Blake2b256:
+		// only fails for keys longer than 64 bytes
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("%w %q", errUnknownHashAlgorithm, algorithm)
+	}
+}
+
+// declaredHashAlgorithm returns the digest algorithm [tags] declare, SHA-256
+// if they declare none
+func declaredHashAlgorithm(tags []Tag) string {
+	for _, tag := range tags {
+		if tag.Key == hashAlgorithmTagKey {
+			return tag.Value
+		}
+	}
+	return SHA256
+}
+
+// verifyHashAlgorithm returns nil iff [algorithm] is a known digest algorithm
+func verifyHashAlgorithm(algorithm string) error {
+	for _, known := range hashAlgorithms {
+		if algorithm == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", errUnknownHashAlgorithm, algorithm)
+}
+
+// declareHashAlgorithm returns [tags] with the tag declaring [algorithm]
+// added, unless it's empty
+func declareHashAlgorithm(tags map[string]string, algorithm string) (map[string]string, error) {
+	if algorithm == "" {
+		return tags, nil
+	}
+	if err := verifyHashAlgorithm(algorithm); err != nil {
+		return nil, err
+	}
+	if declared, ok := tags[hashAlgorithmTagKey]; ok && declared != algorithm {
+		return nil, errConflictingAlgorithm
+	}
+	declared := make(map[string]string, len(tags)+1)
+	for key, value := range tags {
+		declared[key] = value
+	}
+	declared[hashAlgorithmTagKey] = algorithm
+	return declared, nil
+}
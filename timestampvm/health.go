@@ -0,0 +1,112 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+var errUnhealthy = errors.New("vm is unhealthy")
+
+// HealthStatus is reported to the node's health checks
+type HealthStatus struct {
+	// LastAccepted is the ID of the last accepted block
+	LastAccepted ids.ID `json:"lastAccepted"`
+	// LastAcceptedHeight is the height of the last accepted block
+	LastAcceptedHeight json.Uint64 `json:"lastAcceptedHeight"`
+	// TimeSinceAccept is the time since this node last accepted a block, or
+	// since it started if it accepted none
+	TimeSinceAccept Duration `json:"timeSinceAccept"`
+	// MempoolSize is the number of submissions waiting to be put into a block
+	MempoolSize int `json:"mempoolSize"`
+	// ProcessingBlocks is the number of verified blocks which are neither
+	// accepted nor rejected yet
+	ProcessingBlocks int `json:"processingBlocks"`
+	// ClockDrift is the last comparison of the local clock with the time
+	// sources, if any are configured and were checked
+	ClockDrift *ClockDriftStatus `json:"clockDrift,omitempty"`
+	// Problems are the violated liveness rules configured with the health
+	// settings, the VM is unhealthy if there are any
+	Problems []string `json:"problems,omitempty"`
+}
+
+// healthStatus returns the health of the VM according to the liveness rules
+// configured in [vm.config]
+func (vm *VM) healthStatus() (*HealthStatus, error) {
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	status := &HealthStatus{
+		LastAccepted:       lastAccepted.ID(),
+		LastAcceptedHeight: json.Uint64(lastAccepted.Height()),
+		TimeSinceAccept:    Duration{now.Sub(vm.lastAcceptTime)},
+		MempoolSize:        vm.mempool.Len(),
+		ProcessingBlocks:   len(vm.verifiedBlocks),
+	}
+
+	if maxDelay := vm.config.HealthMaxAcceptDelay.Duration; maxDelay > 0 && status.MempoolSize > 0 {
+		// Data proposed after the last accept can't have waited longer than
+		// since it was proposed
+		waitingSince := vm.lastAcceptTime
+		if nonEmptySince := vm.mempool.NonEmptySince(); nonEmptySince.After(waitingSince) {
+			waitingSince = nonEmptySince
+		}
+		if waiting := now.Sub(waitingSince); waiting > maxDelay {
+			status.Problems = append(status.Problems, fmt.Sprintf("no block accepted for %s while data is pending", waiting.Round(time.Second)))
+		}
+	}
+	if maxSize := vm.config.HealthMaxMempoolSize; maxSize > 0 && status.MempoolSize > maxSize {
+		status.Problems = append(status.Problems, fmt.Sprintf("%d submissions pending, more than %d", status.MempoolSize, maxSize))
+	}
+	if maxProcessing := vm.config.HealthMaxProcessingBlocks; maxProcessing > 0 && status.ProcessingBlocks > maxProcessing {
+		status.Problems = append(status.Problems, fmt.Sprintf("%d blocks processing, more than %d", status.ProcessingBlocks, maxProcessing))
+	}
+	if vm.driftMonitor != nil {
+		vm.checkClockDrift(status)
+	}
+	return status, nil
+}
+
+// checkClockDrift adds the last clock drift check to [status]
+func (vm *VM) checkClockDrift(status *HealthStatus) {
+	drift, measured := vm.driftMonitor.Status()
+	if drift.CheckedAt.IsZero() {
+		return // not checked yet
+	}
+	status.ClockDrift = &drift
+
+	maxDrift := vm.config.HealthMaxClockDrift.Duration
+	if maxDrift <= 0 {
+		return
+	}
+	if drift.LastError != "" {
+		status.Problems = append(status.Problems, "clock drift unknown: "+drift.LastError)
+		return
+	}
+	if offset := drift.Drift.Duration; measured && (offset > maxDrift || offset < -maxDrift) {
+		status.Problems = append(status.Problems, fmt.Sprintf("local clock is off by %s, more than %s", offset, maxDrift))
+	}
+}
+
+// HealthCheck implements the common.VM interface. The node calls it holding
+// the context lock.
+func (vm *VM) HealthCheck() (interface{}, error) {
+	status, err := vm.healthStatus()
+	if err != nil {
+		return nil, err
+	}
+	vm.alerter.HealthChecked(len(status.Problems) == 0)
+	if len(status.Problems) > 0 {
+		return status, fmt.Errorf("%w: %s", errUnhealthy, strings.Join(status.Problems, "; "))
+	}
+	return status, nil
+}
@@ -0,0 +1,291 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+const (
+	// maxInclusionProofDepth is the largest number of blocks between a block
+	// and the root of a proof of its data
+	maxInclusionProofDepth = 1024
+
+	// offsets of the parent ID, the timestamp and the data in the bytes of
+	// every block, which start with the codec version
+	parentIDOffset  = wrappers.ShortLen
+	timestampOffset = parentIDOffset + hashing.HashLen + wrappers.LongLen
+	dataOffset      = timestampOffset + wrappers.LongLen
+)
+
+var (
+	errProofRootNotDescendant = errors.New("proof root isn't the block or an accepted descendant of it")
+	errProofTooDeep           = fmt.Errorf("proof root must be at most %d blocks above the block", maxInclusionProofDepth)
+	errBadHashOp              = errors.New("unknown ICS23 hash operation")
+	errBadLengthOp            = errors.New("unknown ICS23 length operation")
+	errEmptyProofInput        = errors.New("ICS23 operations need a non empty key, value and child")
+)
+
+// HashOp is the hash operation of an ICS23 proof step
+type HashOp int32
+
+const (
+	// HashOpNoHash leaves the input as is
+	HashOpNoHash HashOp = 0
+	// HashOpSHA256 hashes the input with SHA-256
+	HashOpSHA256 HashOp = 1
+)
+
+// hashOpNames are the names of the hash operations in the protobuf JSON
+// encoding of ICS23 proofs
+var hashOpNames = map[HashOp]string{
+	HashOpNoHash: "NO_HASH",
+	HashOpSHA256: "SHA256",
+}
+
+// MarshalText implements the encoding.TextMarshaler interface
+func (op HashOp) MarshalText() ([]byte, error) {
+	name, ok := hashOpNames[op]
+	if !ok {
+		return nil, errBadHashOp
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (op *HashOp) UnmarshalText(text []byte) error {
+	for value, name := range hashOpNames {
+		if name == string(text) {
+			*op = value
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", errBadHashOp, text)
+}
+
+// apply returns [preimage] hashed with [op]
+func (op HashOp) apply(preimage []byte) ([]byte, error) {
+	switch op {
+	case HashOpNoHash:
+		return preimage, nil
+	case HashOpSHA256:
+		return hashing.ComputeHash256(preimage), nil
+	default:
+		return nil, errBadHashOp
+	}
+}
+
+// LengthOp is the length prefix an ICS23 leaf adds to its key and value
+type LengthOp int32
+
+// LengthOpNoPrefix adds no length prefix
+const LengthOpNoPrefix LengthOp = 0
+
+// MarshalText implements the encoding.TextMarshaler interface
+func (op LengthOp) MarshalText() ([]byte, error) {
+	if op != LengthOpNoPrefix {
+		return nil, errBadLengthOp
+	}
+	return []byte("NO_PREFIX"), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (op *LengthOp) UnmarshalText(text []byte) error {
+	if string(text) != "NO_PREFIX" {
+		return fmt.Errorf("%w: %q", errBadLengthOp, text)
+	}
+	*op = LengthOpNoPrefix
+	return nil
+}
+
+// CommitmentProof is an ICS23 commitment proof. It's encoded as JSON like
+// the protobuf JSON encoding of the ICS23 message, and [CommitmentProof.Bytes]
+// returns its protobuf encoding, so ICS23 libraries decode it as is.
+type CommitmentProof struct {
+	Exist *ExistenceProof `json:"exist"`
+}
+
+// ExistenceProof is an ICS23 proof that [Key] maps to [Value] under the
+// root it computes, see [ExistenceProof.Calculate]
+type ExistenceProof struct {
+	Key   []byte     `json:"key"`
+	Value []byte     `json:"value"`
+	Leaf  *LeafOp    `json:"leaf"`
+	Path  []*InnerOp `json:"path"`
+}
+
+// LeafOp computes the first node of an ICS23 proof from its key and value:
+// the hash of the prefix, the key and the value
+type LeafOp struct {
+	Hash         HashOp   `json:"hash"`
+	PrehashKey   HashOp   `json:"prehashKey"`
+	PrehashValue HashOp   `json:"prehashValue"`
+	Length       LengthOp `json:"length"`
+	Prefix       []byte   `json:"prefix"`
+}
+
+// InnerOp computes the next node of an ICS23 proof from the last one: the
+// hash of the prefix, the last node and the suffix
+type InnerOp struct {
+	Hash   HashOp `json:"hash"`
+	Prefix []byte `json:"prefix"`
+	Suffix []byte `json:"suffix"`
+}
+
+// Calculate returns the root computed by [p], as ICS23 computes it
+func (p *ExistenceProof) Calculate() ([]byte, error) {
+	if len(p.Key) == 0 || len(p.Value) == 0 || p.Leaf == nil {
+		return nil, errEmptyProofInput
+	}
+	key, err := p.Leaf.PrehashKey.apply(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.Leaf.PrehashValue.apply(p.Value)
+	if err != nil {
+		return nil, err
+	}
+	if p.Leaf.Length != LengthOpNoPrefix {
+		return nil, errBadLengthOp
+	}
+	preimage := append(append(append([]byte(nil), p.Leaf.Prefix...), key...), value...)
+	node, err := p.Leaf.Hash.apply(preimage)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range p.Path {
+		if len(node) == 0 {
+			return nil, errEmptyProofInput
+		}
+		preimage := append(append(append([]byte(nil), op.Prefix...), node...), op.Suffix...)
+		if node, err = op.Hash.apply(preimage); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// Bytes returns the protobuf encoding of [p]
+func (p *CommitmentProof) Bytes() []byte {
+	return appendProtoMessage(nil, 1, p.Exist.bytes())
+}
+
+// bytes returns the protobuf encoding of [p]
+func (p *ExistenceProof) bytes() []byte {
+	b := appendProtoBytes(nil, 1, p.Key)
+	b = appendProtoBytes(b, 2, p.Value)
+	b = appendProtoMessage(b, 3, p.Leaf.bytes())
+	for _, op := range p.Path {
+		b = appendProtoMessage(b, 4, op.bytes())
+	}
+	return b
+}
+
+// bytes returns the protobuf encoding of [op]
+func (op *LeafOp) bytes() []byte {
+	b := appendProtoEnum(nil, 1, int32(op.Hash))
+	b = appendProtoEnum(b, 2, int32(op.PrehashKey))
+	b = appendProtoEnum(b, 3, int32(op.PrehashValue))
+	b = appendProtoEnum(b, 4, int32(op.Length))
+	return appendProtoBytes(b, 5, op.Prefix)
+}
+
+// bytes returns the protobuf encoding of [op]
+func (op *InnerOp) bytes() []byte {
+	b := appendProtoEnum(nil, 1, int32(op.Hash))
+	b = appendProtoBytes(b, 2, op.Prefix)
+	return appendProtoBytes(b, 3, op.Suffix)
+}
+
+// appendProtoBytes appends the bytes field [num] to [b], unless it's empty,
+// as protobuf omits empty fields
+func appendProtoBytes(b []byte, num protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	return appendProtoMessage(b, num, value)
+}
+
+// appendProtoMessage appends the embedded message field [num], encoded as
+// [message], to [b]
+func appendProtoMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+// appendProtoEnum appends the enum field [num] to [b], unless it's the
+// default value
+func appendProtoEnum(b []byte, num protowire.Number, value int32) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+// inclusionProof returns the ICS23 proof that the accepted [blk] anchors its
+// data at its timestamp, under the root [rootID], the ID of [blk] or of an
+// accepted descendant. The key is the big-endian encoded Unix time in
+// seconds of the block and the value its data.
+//
+// The proof follows the block encoding: the leaf is the block bytes up to the
+// data, the first step hashes them with the rest of the block into its ID
+// and each next step hashes the ID with the rest of the child block, which
+// starts with the codec version followed by the parent ID, into the child's
+// ID. The root is thus trusted once it's known to be accepted.
+func (vm *VM) inclusionProof(blk *Block, rootID ids.ID) (*CommitmentProof, error) {
+	chain := []*Block{}
+	for id := rootID; id != blk.ID(); {
+		child, err := vm.getBlock(id)
+		if err != nil {
+			return nil, errNoSuchBlock
+		}
+		if child.Height() <= blk.Height() {
+			return nil, errProofRootNotDescendant
+		}
+		if len(chain) == maxInclusionProofDepth {
+			return nil, errProofTooDeep
+		}
+		chain = append(chain, child)
+		id = child.Parent()
+	}
+
+	blkBytes := blk.Bytes()
+	key := make([]byte, wrappers.LongLen)
+	binary.BigEndian.PutUint64(key, uint64(blk.Tmstmp))
+	data := blk.Data()
+	proof := &ExistenceProof{
+		Key:   key,
+		Value: data[:],
+		Leaf: &LeafOp{
+			Hash:         HashOpNoHash,
+			PrehashKey:   HashOpNoHash,
+			PrehashValue: HashOpNoHash,
+			Length:       LengthOpNoPrefix,
+			Prefix:       blkBytes[:timestampOffset],
+		},
+		Path: make([]*InnerOp, 0, len(chain)+1),
+	}
+	proof.Path = append(proof.Path, &InnerOp{
+		Hash:   HashOpSHA256,
+		Suffix: blkBytes[dataOffset+dataLen:],
+	})
+	for i := len(chain) - 1; i >= 0; i-- {
+		childBytes := chain[i].Bytes()
+		proof.Path = append(proof.Path, &InnerOp{
+			Hash:   HashOpSHA256,
+			Prefix: childBytes[:parentIDOffset],
+			Suffix: childBytes[parentIDOffset+hashing.HashLen:],
+		})
+	}
+	return &CommitmentProof{Exist: proof}, nil
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+)
+
+var (
+	_ IndexStatus = &indexStatus{}
+
+	dataIndexCompleteKey = []byte("dataIndexComplete")
+)
+
+// IndexStatus records whether the indexes introduced after a chain was
+// created cover the blocks accepted before
+type IndexStatus interface {
+	// IsDataIndexComplete returns whether the data index covers all accepted
+	// blocks
+	IsDataIndexComplete() (bool, error)
+	SetDataIndexComplete() error
+}
+
+// indexStatus implements IndexStatus with flags stored next to the
+// initialization flag of the chain
+type indexStatus struct {
+	singletonDB database.Database
+}
+
+// NewIndexStatus returns IndexStatus stored in the given db
+func NewIndexStatus(db database.Database) IndexStatus {
+	return &indexStatus{singletonDB: db}
+}
+
+// IsDataIndexComplete implements the IndexStatus interface
+func (s *indexStatus) IsDataIndexComplete() (bool, error) {
+	return s.singletonDB.Has(dataIndexCompleteKey)
+}
+
+// SetDataIndexComplete implements the IndexStatus interface
+func (s *indexStatus) SetDataIndexComplete() error {
+	return s.singletonDB.Put(dataIndexCompleteKey, nil)
+}
@@ -0,0 +1,347 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+// maximum number of issues an integrity check reports in detail
+const maxIntegrityIssues = 256
+
+var errCorruptState = errors.New("state is corrupt")
+
+// IntegrityIssue is an inconsistency found in the stored chain
+type IntegrityIssue struct {
+	// Height of the accepted block the issue was found at
+	Height json.Uint64 `json:"height"`
+	// BlkID is the ID the accepted log stores at [Height], if any
+	BlkID ids.ID `json:"blockID"`
+	// Problem describes what is inconsistent
+	Problem string `json:"problem"`
+}
+
+func (i IntegrityIssue) String() string {
+	return fmt.Sprintf("height %d (block %s): %s", i.Height, i.BlkID, i.Problem)
+}
+
+// IntegrityStatus reports the progress and the findings of an integrity check
+type IntegrityStatus struct {
+	JobStatus
+	// IssueCount is the number of issues found by the current (or last) run
+	IssueCount json.Uint64 `json:"issueCount"`
+	// Issues are the first [maxIntegrityIssues] issues found
+	Issues []IntegrityIssue `json:"issues"`
+}
+
+// integrityChecker walks the accepted chain from the last accepted block back
+// to genesis, verifying that the stored blocks hash to their IDs, link to
+// their parents and are consistently indexed
+type integrityChecker struct {
+	vm  *VM
+	job *batchJob
+
+	lock       sync.Mutex
+	issueCount uint64
+	issues     []IntegrityIssue
+}
+
+// newIntegrityChecker returns an integrity checker for [vm]
+func newIntegrityChecker(vm *VM) *integrityChecker {
+	c := &integrityChecker{vm: vm}
+	c.job = newBatchJob(vm, "integrity check", c.newRun)
+	return c
+}
+
+// Trigger starts an integrity check in the background
+func (c *integrityChecker) Trigger() error {
+	return c.job.Trigger()
+}
+
+// Status returns the progress and the findings of the integrity check
+func (c *integrityChecker) Status() IntegrityStatus {
+	status := IntegrityStatus{JobStatus: c.job.Status()}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	status.IssueCount = json.Uint64(c.issueCount)
+	status.Issues = append([]IntegrityIssue(nil), c.issues...)
+	return status
+}
+
+// Verify checks the whole chain synchronously. Returns errCorruptState if an
+// issue was found, after logging every reported issue.
+// Must only be called while nothing else accesses the state.
+func (c *integrityChecker) Verify() error {
+	step := c.newRun()
+	processed := uint64(0)
+	for {
+		batchProcessed, _, done, err := step(processed)
+		if err != nil {
+			return err
+		}
+		processed += batchProcessed
+		if done {
+			break
+		}
+	}
+
+	status := c.Status()
+	if status.IssueCount == 0 {
+		c.vm.ctx.Log.Info("verified the integrity of %d accepted blocks", processed)
+		return nil
+	}
+	for _, issue := range status.Issues {
+		c.vm.ctx.Log.Error("integrity check: %s", issue)
+	}
+	return fmt.Errorf("%w: found %d issues, first at %s", errCorruptState, status.IssueCount, status.Issues[0])
+}
+
+// newRun resets the findings and returns the step function of a new run
+func (c *integrityChecker) newRun() batchStep {
+	c.lock.Lock()
+	c.issueCount = 0
+	c.issues = nil
+	c.lock.Unlock()
+
+	started := false
+	nextHeight := uint64(0)
+	total := uint64(0)
+	return func(uint64) (uint64, uint64, bool, error) {
+		if !started {
+			lastAccepted, err := c.vm.lastAcceptedBlock()
+			if err != nil {
+				return 0, 0, false, err
+			}
+			started = true
+			nextHeight = lastAccepted.Height()
+			total = nextHeight + 1
+
+			topID, err := c.vm.state.GetAcceptedID(nextHeight)
+			if err != nil && err != database.ErrNotFound {
+				return 0, 0, false, err
+			}
+			if err == nil && topID != lastAccepted.ID() {
+				c.report(nextHeight, topID, fmt.Sprintf("last accepted block is %s", lastAccepted.ID()))
+			}
+		}
+
+		prunedHeight, err := c.vm.state.GetPrunedHeight()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		processed := uint64(0)
+		for ; processed < jobBatchSize; processed++ {
+			if err := c.verifyHeight(nextHeight, prunedHeight); err != nil {
+				return 0, 0, false, err
+			}
+			if nextHeight == 0 {
+				return processed + 1, total, true, nil
+			}
+			nextHeight--
+		}
+		return processed, total, false, nil
+	}
+}
+
+// verifyHeight checks the accepted block at [height]. Blocks below
+// [prunedHeight] may lack their body or header.
+// Inconsistencies are reported as issues, other errors are returned.
+func (c *integrityChecker) verifyHeight(height uint64, prunedHeight uint64) error {
+	state := c.vm.state
+	blkID, err := state.GetAcceptedID(height)
+	if err == database.ErrNotFound {
+		c.report(height, ids.Empty, "missing from the accepted log")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	parentID := ids.Empty
+	if height > 0 {
+		parentID, err = state.GetAcceptedID(height - 1)
+		if err == database.ErrNotFound {
+			// reported when verifying the parent's height
+			parentID = ids.Empty
+		} else if err != nil {
+			return err
+		} else if err := c.verifyChildLink(height, parentID, blkID); err != nil {
+			return err
+		}
+	}
+
+	header, err := state.GetBlockHeader(blkID)
+	if err == database.ErrNotFound {
+		if height >= prunedHeight {
+			c.report(height, blkID, "block is missing although it isn't pruned")
+		}
+		return nil
+	}
+	if err != nil {
+		c.report(height, blkID, fmt.Sprintf("couldn't read header: %s", err))
+		return nil
+	}
+	if header.Hght != height {
+		c.report(height, blkID, fmt.Sprintf("header has height %d", header.Hght))
+	}
+	if header.Status != choices.Accepted {
+		c.report(height, blkID, fmt.Sprintf("header has status %s", header.Status))
+	}
+	if height > 0 && parentID != ids.Empty && header.PrntID != parentID {
+		c.report(height, blkID, fmt.Sprintf("parent is %s but the accepted log stores %s below", header.PrntID, parentID))
+	}
+	if err := c.verifyDataEntry(height, blkID, header.DataHash); err != nil {
+		return err
+	}
+
+	blk, err := state.GetBlock(blkID)
+	if err == database.ErrNotFound {
+		// archived bodies aren't fetched, as that may be slow
+		archived, err := state.IsArchived(blkID)
+		if err != nil {
+			return err
+		}
+		if archived || height < prunedHeight {
+			return nil
+		}
+		switch _, err := state.GetRedaction(blkID); err {
+		case nil:
+			return nil
+		case database.ErrNotFound:
+		default:
+			return err
+		}
+		dropped, err := c.vm.droppedByRetention(blkID, height)
+		if err != nil {
+			return err
+		}
+		if !dropped {
+			c.report(height, blkID, "body is missing although it isn't pruned, archived, redacted or expired")
+		}
+		return nil
+	}
+	if err != nil {
+		c.report(height, blkID, fmt.Sprintf("couldn't read body: %s", err))
+		return nil
+	}
+	if blk.ID() != blkID {
+		c.report(height, blkID, fmt.Sprintf("body hashes to %s", blk.ID()))
+	}
+	if *newBlockHeader(blk) != *header {
+		c.report(height, blkID, "body doesn't match the header")
+	}
+	if err := c.verifyNamespaceEntries(height, blkID, blk); err != nil {
+		return err
+	}
+	if blk.IsSigned() {
+		return c.verifySubmitterEntry(height, blkID, blk)
+	}
+	return nil
+}
+
+// verifyChildLink checks that [parentID] links to the accepted child [blkID]
+func (c *integrityChecker) verifyChildLink(height uint64, parentID ids.ID, blkID ids.ID) error {
+	childID, err := c.vm.state.GetAcceptedChild(parentID)
+	switch {
+	case err == database.ErrNotFound:
+		c.report(height, blkID, fmt.Sprintf("parent %s isn't linked to its accepted child", parentID))
+	case err != nil:
+		return err
+	case childID != blkID:
+		c.report(height, blkID, fmt.Sprintf("parent %s is linked to the child %s", parentID, childID))
+	}
+	return nil
+}
+
+// verifyDataEntry checks that the data index entry of [dataHash] references
+// an accepted block. Blocks accepted before the index was introduced aren't
+// indexed, so a missing entry isn't an issue.
+func (c *integrityChecker) verifyDataEntry(height uint64, blkID ids.ID, dataHash ids.ID) error {
+	entry, err := c.vm.state.GetDataEntry(dataHash)
+	if err == database.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry.Height == height {
+		if entry.BlkID != blkID {
+			c.report(height, blkID, fmt.Sprintf("data index references %s", entry.BlkID))
+		}
+		return nil
+	}
+	acceptedID, err := c.vm.state.GetAcceptedID(entry.Height)
+	if err == database.ErrNotFound || (err == nil && acceptedID != entry.BlkID) {
+		c.report(height, blkID, fmt.Sprintf("data index references %s at height %d, which isn't accepted", entry.BlkID, entry.Height))
+		return nil
+	}
+	return err
+}
+
+// verifySubmitterEntry checks that the signed block [blk] is indexed by its
+// submitter
+func (c *integrityChecker) verifySubmitterEntry(height uint64, blkID ids.ID, blk *Block) error {
+	submitter, err := blk.Submitter()
+	if err != nil {
+		c.report(height, blkID, fmt.Sprintf("couldn't recover submitter: %s", err))
+		return nil
+	}
+	blkIDs, err := c.vm.state.GetSubmitterBlockIDs(c.vm.runCtx, submitter, height, 1)
+	if err != nil {
+		return err
+	}
+	if len(blkIDs) == 0 || blkIDs[0] != blkID {
+		c.report(height, blkID, fmt.Sprintf("missing from the index of submitter %s", submitter))
+	}
+	return nil
+}
+
+// verifyNamespaceEntries checks that [blk] is indexed under its namespace, if
+// it has one, and under each of its tags
+func (c *integrityChecker) verifyNamespaceEntries(height uint64, blkID ids.ID, blk *Block) error {
+	namespace := blk.Namespace()
+	if namespace != "" {
+		blkIDs, err := c.vm.state.GetNamespaceBlockIDs(c.vm.runCtx, namespace, height, 1)
+		if err != nil {
+			return err
+		}
+		if len(blkIDs) == 0 || blkIDs[0] != blkID {
+			c.report(height, blkID, fmt.Sprintf("missing from the index of namespace %q", namespace))
+		}
+	}
+	for _, tag := range blk.Tags() {
+		blkIDs, err := c.vm.state.GetTagBlockIDs(c.vm.runCtx, namespace, tag, height, 1)
+		if err != nil {
+			return err
+		}
+		if len(blkIDs) == 0 || blkIDs[0] != blkID {
+			c.report(height, blkID, fmt.Sprintf("missing from the index of tag %s=%s", tag.Key, tag.Value))
+		}
+	}
+	return nil
+}
+
+// report records an issue found at [height]
+func (c *integrityChecker) report(height uint64, blkID ids.ID, problem string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.issueCount++
+	if len(c.issues) < maxIntegrityIssues {
+		c.issues = append(c.issues, IntegrityIssue{
+			Height:  json.Uint64(height),
+			BlkID:   blkID,
+			Problem: problem,
+		})
+	}
+}
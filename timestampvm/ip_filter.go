@@ -0,0 +1,107 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var errBadIPRange = errors.New("invalid IP address or CIDR range")
+
+// ipFilter admits requests by the address they are sent from
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newIPFilter returns a filter admitting the addresses in [allow], or all
+// addresses if it's empty, except those in [deny]. Entries are CIDR ranges
+// or single addresses.
+func newIPFilter(allow []string, deny []string) (*ipFilter, error) {
+	allowNets, err := parseIPRanges(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseIPRanges(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &ipFilter{
+		allow: allowNets,
+		deny:  denyNets,
+	}, nil
+}
+
+// parseIPRanges parses CIDR ranges, treating single addresses as ranges
+// holding only them
+func parseIPRanges(ranges []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ranges))
+	for _, ipRange := range ranges {
+		if !strings.Contains(ipRange, "/") {
+			ip := net.ParseIP(ipRange)
+			if ip == nil {
+				return nil, fmt.Errorf("%w %q", errBadIPRange, ipRange)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", errBadIPRange, ipRange)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// enabled returns true if the filter rejects any address
+func (f *ipFilter) enabled() bool {
+	return len(f.allow) > 0 || len(f.deny) > 0
+}
+
+// Allowed returns true if requests from [ip] are admitted
+func (f *ipFilter) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range f.deny {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, ipNet := range f.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns [handler] rejecting requests from addresses which aren't
+// admitted. The address is the one connecting to the node, so requests
+// forwarded by a proxy are filtered by the proxy's address.
+func (f *ipFilter) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !f.Allowed(net.ParseIP(host)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
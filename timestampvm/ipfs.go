@@ -0,0 +1,188 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+const (
+	// ipfsQueueSize is the number of CIDs of accepted blocks waiting to be
+	// pinned or checked, beyond which further CIDs are skipped
+	ipfsQueueSize = 1024
+	// maxIPFSReplySize is the largest reply read from the IPFS API
+	maxIPFSReplySize = 64 * 1024
+)
+
+var (
+	errIPFSDisabled  = errors.New("no IPFS API is configured")
+	errIPFSFailed    = errors.New("IPFS API call failed")
+	errIPFSQueueFull = errors.New("too many CIDs are queued")
+)
+
+// IPFSStatus reports the pinning, or checking, of the content of the CIDs
+// anchored in accepted blocks
+type IPFSStatus struct {
+	// Pin is true if the content is pinned, rather than only checked to be
+	// retrievable
+	Pin bool `json:"pin"`
+	// Queued is the number of CIDs waiting to be processed
+	Queued int `json:"queued"`
+	// Processed is the number of CIDs whose content was retrieved since the
+	// VM started
+	Processed uint64 `json:"processed"`
+	// Failed is the number of CIDs whose content couldn't be retrieved or
+	// which were skipped as the queue was full
+	Failed uint64 `json:"failed"`
+	// LastFailedCID and LastError describe the last failure, if any
+	LastFailedCID string `json:"lastFailedCID,omitempty"`
+	LastError     string `json:"lastError,omitempty"`
+}
+
+// ipfsPinner pins the content of the CIDs anchored in accepted blocks with
+// the Kubo RPC API of an IPFS node, or only checks it's retrievable, so the
+// anchored content stays available. CIDs are processed in the background, in
+// the order their blocks are accepted. CIDs queued when the VM shuts down
+// aren't processed.
+type ipfsPinner struct {
+	vm     *VM
+	apiURL string
+	pin    bool
+	client *http.Client
+	queue  chan *CID
+
+	lock   sync.Mutex
+	status IPFSStatus
+
+	processed prometheus.Counter
+	failures  prometheus.Counter
+}
+
+// newIPFSPinner returns the ipfsPinner of [vm], reporting metrics to
+// [registerer], or nil if no IPFS API is configured
+func newIPFSPinner(vm *VM, registerer prometheus.Registerer) (*ipfsPinner, error) {
+	config := &vm.config
+	if config.IPFSAPIURL == "" {
+		return nil, nil
+	}
+	p := &ipfsPinner{
+		vm:     vm,
+		apiURL: strings.TrimSuffix(config.IPFSAPIURL, "/"),
+		pin:    config.IPFSPin,
+		client: &http.Client{Timeout: config.IPFSTimeout.Duration},
+		queue:  make(chan *CID, ipfsQueueSize),
+		status: IPFSStatus{Pin: config.IPFSPin},
+		processed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ipfs_processed",
+			Help: "# of anchored CIDs whose content was pinned or found",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ipfs_failures",
+			Help: "# of anchored CIDs whose content couldn't be pinned or found",
+		}),
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(p.processed),
+		registerer.Register(p.failures),
+	)
+	return p, errs.Err
+}
+
+// Accepted queues the CID anchored by the accepted [blk], if it's tagged
+// with one
+func (p *ipfsPinner) Accepted(blk *Block) {
+	cid, err := blockCID(blk)
+	if err != nil || cid == nil {
+		return
+	}
+	select {
+	case p.queue <- cid:
+	default:
+		p.failed(cid, errIPFSQueueFull)
+	}
+}
+
+// Status returns the state of pinning
+func (p *ipfsPinner) Status() IPFSStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	status := p.status
+	status.Queued = len(p.queue)
+	return status
+}
+
+// run processes the queued CIDs until the VM shuts down
+func (p *ipfsPinner) run() {
+	for {
+		select {
+		case cid := <-p.queue:
+			if err := p.process(cid); err != nil {
+				p.failed(cid, err)
+				continue
+			}
+			p.processed.Inc()
+			p.lock.Lock()
+			p.status.Processed++
+			p.lock.Unlock()
+		case <-p.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// failed records that the content of [cid] couldn't be processed
+func (p *ipfsPinner) failed(cid *CID, err error) {
+	p.vm.ctx.Log.Warn("couldn't retrieve the content of anchored CID %s: %s", cid, err)
+	p.failures.Inc()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.status.Failed++
+	p.status.LastFailedCID = cid.String()
+	p.status.LastError = err.Error()
+}
+
+// process pins the content of [cid], or checks it's retrievable
+func (p *ipfsPinner) process(cid *CID) error {
+	if p.pin {
+		return p.call("pin/add", cid)
+	}
+	return p.call("block/stat", cid)
+}
+
+// call calls the Kubo RPC API [method] with [cid] as argument
+func (p *ipfsPinner) call(method string, cid *CID) error {
+	endpoint := fmt.Sprintf("%s/api/v0/%s?arg=%s", p.apiURL, method, url.QueryEscape(cid.String()))
+	// The Kubo RPC API only accepts POST requests
+	resp, err := p.client.Post(endpoint, "", nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errIPFSFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxIPFSReplySize))
+		return nil
+	}
+	reply := struct {
+		Message string `json:"Message"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxIPFSReplySize)).Decode(&reply); err != nil || reply.Message == "" {
+		return fmt.Errorf("%w: %s", errIPFSFailed, resp.Status)
+	}
+	return fmt.Errorf("%w: %s", errIPFSFailed, reply.Message)
+}
@@ -0,0 +1,46 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+)
+
+var _ JobProgress = &jobProgress{}
+
+// JobProgress persists the progress of long running jobs, so they can resume
+// where they left off after a restart
+type JobProgress interface {
+	// GetJobProgress returns the progress stored for [job].
+	// Returns database.ErrNotFound if [job] isn't in progress.
+	GetJobProgress(job string) ([]byte, error)
+	SetJobProgress(job string, progress []byte) error
+	// DeleteJobProgress removes the progress of [job] once it's complete
+	DeleteJobProgress(job string) error
+}
+
+// jobProgress implements JobProgress with a database keyed by job name
+type jobProgress struct {
+	progressDB database.Database
+}
+
+// NewJobProgress returns JobProgress stored in the given db
+func NewJobProgress(db database.Database) JobProgress {
+	return &jobProgress{progressDB: db}
+}
+
+// GetJobProgress implements the JobProgress interface
+func (p *jobProgress) GetJobProgress(job string) ([]byte, error) {
+	return p.progressDB.Get([]byte(job))
+}
+
+// SetJobProgress implements the JobProgress interface
+func (p *jobProgress) SetJobProgress(job string, progress []byte) error {
+	return p.progressDB.Put([]byte(job), progress)
+}
+
+// DeleteJobProgress implements the JobProgress interface
+func (p *jobProgress) DeleteJobProgress(job string) error {
+	return p.progressDB.Delete([]byte(job))
+}
@@ -0,0 +1,263 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+var (
+	errUnsupportedKeyword = errors.New("unsupported JSON Schema keyword")
+	errBadSchemaType      = errors.New("JSON Schema type must be a type name or a list of them")
+	errMissingRecord      = errors.New("submissions to this namespace must include their record")
+	errRecordData         = errors.New("data isn't the hash of the record")
+	errInvalidRecord      = errors.New("record doesn't satisfy the JSON Schema of its namespace")
+
+	// keywords of JSON Schema which aren't implemented. Schemas using them
+	// are refused rather than partially enforced.
+	unsupportedKeywords = []string{
+		"$ref", "allOf", "anyOf", "oneOf", "not", "if", "then", "else",
+		"dependentRequired", "dependentSchemas", "patternProperties",
+		"propertyNames", "prefixItems", "contains", "uniqueItems",
+		"minProperties", "maxProperties", "exclusiveMinimum",
+		"exclusiveMaximum", "multipleOf",
+	}
+
+	jsonTypes = map[string]bool{
+		"object": true, "array": true, "string": true, "number": true,
+		"integer": true, "boolean": true, "null": true,
+	}
+)
+
+// jsonSchema is a JSON Schema restricted to the keywords validating types,
+// object properties, array items, enumerations, numeric ranges, lengths and
+// patterns. Annotations such as "title" are ignored.
+type jsonSchema struct {
+	Type                 jsonTypeList           `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                *interface{}           `json:"const"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// jsonTypeList is the "type" keyword, which names one type or a list of them
+type jsonTypeList []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (l *jsonTypeList) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := stdjson.Unmarshal(b, &name); err == nil {
+		*l = jsonTypeList{name}
+	} else if err := stdjson.Unmarshal(b, (*[]string)(l)); err != nil {
+		return errBadSchemaType
+	}
+	for _, name := range *l {
+		if !jsonTypes[name] {
+			return fmt.Errorf("%w: %q", errBadSchemaType, name)
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It refuses the
+// keywords which aren't implemented and compiles the pattern.
+func (s *jsonSchema) UnmarshalJSON(b []byte) error {
+	keywords := map[string]stdjson.RawMessage{}
+	if err := stdjson.Unmarshal(b, &keywords); err != nil {
+		return err
+	}
+	for _, keyword := range unsupportedKeywords {
+		if _, ok := keywords[keyword]; ok {
+			return fmt.Errorf("%w: %s", errUnsupportedKeyword, keyword)
+		}
+	}
+	type plain jsonSchema
+	if err := stdjson.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	if s.Pattern != "" {
+		var err error
+		s.pattern, err = regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseJSONSchema parses the JSON Schema [schemaBytes]
+func parseJSONSchema(schemaBytes []byte) (*jsonSchema, error) {
+	schema := &jsonSchema{}
+	if err := stdjson.Unmarshal(schemaBytes, schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// parseRecordSchemas parses the JSON Schemas of [schemas], by namespace
+func parseRecordSchemas(schemas map[string]stdjson.RawMessage) (map[string]*jsonSchema, error) {
+	parsed := make(map[string]*jsonSchema, len(schemas))
+	for namespace, schemaBytes := range schemas {
+		if err := verifyNamespaceConfig(namespace); err != nil {
+			return nil, err
+		}
+		schema, err := parseJSONSchema(schemaBytes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse the record schema of %q: %w", namespace, err)
+		}
+		parsed[namespace] = schema
+	}
+	return parsed, nil
+}
+
+// Validate returns nil iff [record] satisfies [s]
+func (s *jsonSchema) Validate(record []byte) error {
+	var value interface{}
+	if err := stdjson.Unmarshal(record, &value); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidRecord, err)
+	}
+	return s.validate(value, "$")
+}
+
+// validate returns nil iff [value], found at [path], satisfies [s]
+func (s *jsonSchema) validate(value interface{}, path string) error {
+	if len(s.Type) > 0 && !s.hasType(value) {
+		return fmt.Errorf("%w: %s must be of type %v", errInvalidRecord, path, []string(s.Type))
+	}
+	if s.Const != nil && !reflect.DeepEqual(value, *s.Const) {
+		return fmt.Errorf("%w: %s must be %v", errInvalidRecord, path, *s.Const)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			found = found || reflect.DeepEqual(value, allowed)
+		}
+		if !found {
+			return fmt.Errorf("%w: %s must be one of %v", errInvalidRecord, path, s.Enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%w: %s must be at least %v", errInvalidRecord, path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%w: %s must be at most %v", errInvalidRecord, path, *s.Maximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%w: %s must be at least %d characters long", errInvalidRecord, path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%w: %s must be at most %d characters long", errInvalidRecord, path, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%w: %s must match %q", errInvalidRecord, path, s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Errorf("%w: %s must have at least %d items", errInvalidRecord, path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%w: %s must have at most %d items", errInvalidRecord, path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%w: %s.%s is required", errInvalidRecord, path, name)
+			}
+		}
+		// validate in a stable order, so the same error is reported
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			switch {
+			case ok:
+				if err := property.validate(v[name], path+"."+name); err != nil {
+					return err
+				}
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				return fmt.Errorf("%w: %s.%s isn't allowed", errInvalidRecord, path, name)
+			}
+		}
+	}
+	return nil
+}
+
+// hasType returns true if [value] is of one of the types of [s]
+func (s *jsonSchema) hasType(value interface{}) bool {
+	for _, name := range s.Type {
+		switch v := value.(type) {
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case float64:
+			if name == "number" || (name == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RecordData returns the data anchoring the structured [record], the SHA-256
+// hash of its bytes with insignificant whitespace removed
+func RecordData(record []byte) ([dataLen]byte, error) {
+	compacted := bytes.Buffer{}
+	if err := stdjson.Compact(&compacted, record); err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(compacted.Bytes()), nil
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mempool holds submissions that were proposed to this VM but haven't been
+// put into a block yet. Submissions are handed out in FIFO order, or in turns
+// between namespaces if a scheduler is set, except that express submissions
+// skip the queue for a reserved share of the blocks.
+type mempool struct {
+	pending []*submission
+	// share of the blocks reserved for express submissions
+	expressShare float64
+	// share of a block the express lane is owed, up to a whole block
+	expressCredit float64
+	// takes turns between namespaces, nil for FIFO order
+	scheduler *namespaceScheduler
+	// time the mempool last became non-empty
+	nonEmptySince time.Time
+	// true if new submissions are refused
+	locked bool
+	// reports the number of pending submissions
+	size prometheus.Gauge
+}
+
+// newMempool returns an empty mempool reporting its size to [size],
+// reserving [expressShare] of the blocks for express submissions and
+// scheduling the others with [scheduler], if it isn't nil
+func newMempool(size prometheus.Gauge, expressShare float64, scheduler *namespaceScheduler) *mempool {
+	return &mempool{
+		expressShare: expressShare,
+		scheduler:    scheduler,
+		size:         size,
+	}
+}
+
+// Len returns the number of pending submissions
+func (m *mempool) Len() int { return len(m.pending) }
+
+// NonEmptySince returns the time the mempool last became non-empty
+func (m *mempool) NonEmptySince() time.Time { return m.nonEmptySince }
+
+// Locked returns true if new submissions are refused
+func (m *mempool) Locked() bool { return m.locked }
+
+// SetLocked sets whether new submissions are refused. Pending submissions are
+// still put into blocks while the mempool is locked.
+func (m *mempool) SetLocked(locked bool) { m.locked = locked }
+
+// setPending replaces the pending submissions with [pending]
+func (m *mempool) setPending(pending []*submission) {
+	if len(m.pending) == 0 && len(pending) > 0 {
+		m.nonEmptySince = time.Now()
+	}
+	m.pending = pending
+	m.size.Set(float64(len(pending)))
+}
+
+// Add appends [sub] to the end of the mempool
+func (m *mempool) Add(sub *submission) {
+	m.setPending(append(m.pending, sub))
+}
+
+// Requeue puts [sub] back at the front of the mempool, so it's the next to be
+// put into a block. Returns false (and does nothing) if a submission with the
+// same data is already pending.
+func (m *mempool) Requeue(sub *submission) bool {
+	if m.Has(sub.data) {
+		return false
+	}
+	m.setPending(append([]*submission{sub}, m.pending...))
+	return true
+}
+
+// Has returns true if a submission of [data] is pending
+func (m *mempool) Has(data [dataLen]byte) bool {
+	for _, pending := range m.pending {
+		if pending.data == data {
+			return true
+		}
+	}
+	return false
+}
+
+// Pop removes and returns the oldest pending submission, or the oldest
+// express submission if the express lane is owed a block. With a scheduler,
+// it's the oldest submission of the namespace whose turn it is instead.
+// Returns false if the mempool is empty.
+func (m *mempool) Pop() (*submission, bool) {
+	if len(m.pending) == 0 {
+		return nil, false
+	}
+	// The credit doesn't pile up while there's no express traffic, so public
+	// submissions are never starved either
+	m.expressCredit += m.expressShare
+	if m.expressCredit > 1 {
+		m.expressCredit = 1
+	}
+	i := -1
+	if m.expressCredit >= 1 {
+		for j, pending := range m.pending {
+			if pending.express {
+				i = j
+				break
+			}
+		}
+	}
+	switch {
+	case i >= 0:
+	case m.scheduler != nil:
+		i = m.scheduler.Next(m.pending)
+	default:
+		i = 0
+	}
+	sub := m.pending[i]
+	if sub.express {
+		m.expressCredit--
+		if m.expressCredit < 0 {
+			m.expressCredit = 0
+		}
+	}
+	pending := make([]*submission, 0, len(m.pending)-1)
+	pending = append(pending, m.pending[:i]...)
+	m.setPending(append(pending, m.pending[i+1:]...))
+	return sub, true
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+// vmMetrics are the metrics of the block lifecycle. The node exports them,
+// along with the database and cache metrics, in the VM's namespace of the
+// chain.
+type vmMetrics struct {
+	mempoolSize    prometheus.Gauge
+	built          prometheus.Counter
+	accepted       prometheus.Counter
+	rejected       prometheus.Counter
+	buildDuration  prometheus.Histogram
+	verifyDuration prometheus.Histogram
+}
+
+// newVMMetrics returns the metrics of the block lifecycle, registered with
+// [registerer]
+func newVMMetrics(registerer prometheus.Registerer) (*vmMetrics, error) {
+	m := &vmMetrics{
+		mempoolSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mempool_size",
+			Help: "# of submissions waiting to be put into a block",
+		}),
+		built: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blocks_built",
+			Help: "# of blocks built by this node",
+		}),
+		accepted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blocks_accepted",
+			Help: "# of blocks accepted",
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "blocks_rejected",
+			Help: "# of blocks rejected",
+		}),
+		buildDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "block_build_duration_seconds",
+			Help:    "time (in seconds) building a block took, including its verification",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+		verifyDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "block_verify_duration_seconds",
+			Help:    "time (in seconds) verifying a block took",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(m.mempoolSize),
+		registerer.Register(m.built),
+		registerer.Register(m.accepted),
+		registerer.Register(m.rejected),
+		registerer.Register(m.buildDuration),
+		registerer.Register(m.verifyDuration),
+	)
+	return m, errs.Err
+}
+
+// observeSince records the time elapsed since [start] in [histogram]
+func observeSince(histogram prometheus.Histogram, start time.Time) {
+	histogram.Observe(time.Since(start).Seconds())
+}
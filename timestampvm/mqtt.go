@@ -0,0 +1,153 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// mqttProtocolVersion is the protocol version of MQTT 3.1.1
+	mqttProtocolVersion = 4
+	// mqttDisconnectQuiesce is the time the DISCONNECT packet has to be sent
+	// before the connection is closed
+	mqttDisconnectQuiesce = 250 * time.Millisecond
+)
+
+var (
+	errMQTTFailed         = errors.New("MQTT broker call failed")
+	errMQTTTimeout        = errors.New("MQTT broker didn't reply in time")
+	errMQTTConnectionLost = errors.New("MQTT connection was lost")
+	errBadMQTTScheme      = errors.New("mqttBrokerURL must be a tcp://, mqtt://, ssl://, tls:// or mqtts:// URL")
+)
+
+// mqttMessage is a message published to an MQTT topic
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// mqttClient publishes messages to a single broker with the Eclipse Paho
+// client. The connection isn't restored once lost, the publisher dials again
+// and publishes from its cursor instead.
+type mqttClient struct {
+	client  mqtt.Client
+	qos     byte
+	retain  bool
+	timeout time.Duration
+}
+
+// mqttConnectOptions are the settings of the MQTT connection
+type mqttConnectOptions struct {
+	brokerURL string
+	clientID  string
+	username  string
+	password  []byte
+	qos       byte
+	retain    bool
+	keepAlive time.Duration
+	timeout   time.Duration
+}
+
+// mqttBrokerAddress returns [brokerURL] with the default port of its scheme
+// if it has none
+func mqttBrokerAddress(brokerURL string) (string, error) {
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return "", err
+	}
+	defaultPort := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		defaultPort = "8883"
+	default:
+		return "", fmt.Errorf("%w: %q", errBadMQTTScheme, brokerURL)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.String(), nil
+}
+
+// dialMQTT connects to the broker of [opts] and opens a clean session
+func dialMQTT(opts *mqttConnectOptions) (*mqttClient, error) {
+	brokerURL, err := mqttBrokerAddress(opts.brokerURL)
+	if err != nil {
+		return nil, err
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID(opts.clientID).
+		SetUsername(opts.username).
+		SetPassword(string(opts.password)).
+		SetProtocolVersion(mqttProtocolVersion).
+		// clean session, as unacknowledged messages are published again
+		// from the cursor after reconnecting
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetKeepAlive(opts.keepAlive).
+		SetPingTimeout(opts.timeout).
+		SetConnectTimeout(opts.timeout).
+		SetWriteTimeout(opts.timeout).
+		SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	c := &mqttClient{
+		client:  mqtt.NewClient(options),
+		qos:     opts.qos,
+		retain:  opts.retain,
+		timeout: opts.timeout,
+	}
+	if err := c.wait(c.client.Connect()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Publish publishes [messages] with the QoS of the client and returns once
+// the broker acknowledged all of them
+func (c *mqttClient) Publish(messages []mqttMessage) error {
+	tokens := make([]mqtt.Token, len(messages))
+	for i, msg := range messages {
+		tokens[i] = c.client.Publish(msg.topic, c.qos, c.retain, msg.payload)
+	}
+	for _, token := range tokens {
+		if err := c.wait(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckConnection returns an error if the connection was lost, e.g. because
+// the broker didn't answer a keep alive ping
+func (c *mqttClient) CheckConnection() error {
+	if !c.client.IsConnectionOpen() {
+		return errMQTTConnectionLost
+	}
+	return nil
+}
+
+// Close disconnects from the broker
+func (c *mqttClient) Close() {
+	c.client.Disconnect(uint(mqttDisconnectQuiesce / time.Millisecond))
+}
+
+// wait returns the result of the operation of [token], or an error if the
+// broker doesn't reply in time
+func (c *mqttClient) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(c.timeout) {
+		return errMQTTTimeout
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("%w: %s", errMQTTFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+const (
+	// mqttPublisherJobName is the job the height of the next block to
+	// publish to MQTT is persisted as
+	mqttPublisherJobName = "mqttPublisher"
+	// mqttBatchSize is the number of blocks read from the database at once
+	mqttBatchSize = 256
+	// mqttKeepAlive is the keep alive interval of the MQTT connection
+	mqttKeepAlive = time.Minute
+)
+
+var (
+	errMQTTPublisherDisabled = errors.New("MQTT publisher is disabled")
+	errBadMQTTQoS            = errors.New("mqttQoS must be 0, 1 or 2")
+	errBadMQTTTopic          = errors.New("mqttTopic must be non empty and mustn't hold the wildcards '+' and '#'")
+)
+
+// mqttEvent is the payload of the message published when a block is
+// accepted
+type mqttEvent struct {
+	ChainID   string    `json:"chainID"`
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parentID"`
+	Height    uint64    `json:"height"`
+	Timestamp time.Time `json:"timestamp"`
+	// Base 58 encoded data, as returned by the API
+	Data string `json:"data,omitempty"`
+	// Hex encoded data, as printed by tools like sha256sum
+	Hash          string            `json:"hash,omitempty"`
+	Namespace     string            `json:"namespace,omitempty"`
+	Submitter     string            `json:"submitter,omitempty"`
+	HashAlgorithm string            `json:"hashAlgorithm,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	BodyDropped   bool              `json:"bodyDropped"`
+}
+
+// verifyMQTTTopic returns an error if [topic], a topic template, can't be
+// published to
+func verifyMQTTTopic(topic string) error {
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("%w: %q", errBadMQTTTopic, topic)
+	}
+	return nil
+}
+
+// mqttPublisher publishes an event to an MQTT broker for every accepted
+// block, so IoT backends timestamping sensor data consume acceptances on the
+// protocol they already use. The topic of every event is the topic template
+// with "{chainID}", "{namespace}", "{submitter}", "{height}" and "{id}"
+// replaced with the values of the block, empty if it has none.
+//
+// The height of the next block to publish is persisted by this node once the
+// broker acknowledged the events below it, so events are published at least
+// once, in height order, even across restarts. Without it, events are
+// published for all accepted blocks from genesis.
+type mqttPublisher struct {
+	vm      *VM
+	options mqttConnectOptions
+	topic   string
+	// open connection, nil until connected and after a failure
+	client *mqttClient
+	// wakes the publisher up early
+	wake chan struct{}
+
+	lock   sync.Mutex
+	status ExportStatus
+
+	height   prometheus.Gauge
+	failures prometheus.Counter
+}
+
+// newMQTTPublisher returns the mqttPublisher of [vm], reporting metrics to
+// [registerer], or nil if no broker is configured. The broker is connected
+// to lazily.
+func newMQTTPublisher(vm *VM, registerer prometheus.Registerer) (*mqttPublisher, error) {
+	config := &vm.config
+	if config.MQTTBrokerURL == "" {
+		return nil, nil
+	}
+	clientID := config.MQTTClientID
+	if clientID == "" {
+		clientID = "timestampvm-" + vm.ctx.ChainID.String()
+	}
+	p := &mqttPublisher{
+		vm: vm,
+		options: mqttConnectOptions{
+			brokerURL: config.MQTTBrokerURL,
+			clientID:  clientID,
+			username:  config.MQTTUsername,
+			qos:       config.MQTTQoS,
+			retain:    config.MQTTRetain,
+			keepAlive: mqttKeepAlive,
+			timeout:   config.MQTTTimeout.Duration,
+		},
+		topic: config.MQTTTopic,
+		wake:  make(chan struct{}, 1),
+		height: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mqtt_publish_height",
+			Help: "height of the next block to publish to MQTT",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mqtt_publish_failures",
+			Help: "# of publications to MQTT which failed",
+		}),
+	}
+	if config.MQTTPasswordFile != "" {
+		password, err := readToken(config.MQTTPasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		p.options.password = password
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(p.height),
+		registerer.Register(p.failures),
+	)
+	return p, errs.Err
+}
+
+// Accepted wakes the publisher up to publish the newly accepted blocks
+func (p *mqttPublisher) Accepted() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the state of publishing
+func (p *mqttPublisher) Status() ExportStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.status
+}
+
+// runPeriodically publishes the blocks accepted since the last publication
+// right away, then whenever a block is accepted and every
+// [vm.config.MQTTInterval] until the VM shuts down
+func (p *mqttPublisher) runPeriodically() {
+	ticker := time.NewTicker(p.vm.config.MQTTInterval.Duration)
+	defer ticker.Stop()
+	defer p.disconnect()
+
+	for {
+		err := p.publish()
+		p.lock.Lock()
+		p.status.CheckedAt = time.Now()
+		p.status.LastError = ""
+		if err != nil {
+			p.status.LastError = err.Error()
+		}
+		p.lock.Unlock()
+		if err != nil {
+			p.disconnect()
+			p.failures.Inc()
+			p.vm.ctx.Log.Warn("couldn't publish blocks to MQTT: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.wake:
+		case <-p.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// disconnect closes the connection to the broker, if it's open
+func (p *mqttPublisher) disconnect() {
+	if p.client != nil {
+		p.client.Close()
+		p.client = nil
+	}
+}
+
+// publish publishes the blocks accepted since the last publication. The
+// broker is called without holding the context lock.
+func (p *mqttPublisher) publish() error {
+	if p.client == nil {
+		client, err := dialMQTT(&p.options)
+		if err != nil {
+			return err
+		}
+		p.client = client
+	}
+	for {
+		blocks, err := p.nextBlocks()
+		if err != nil {
+			return err
+		}
+		if len(blocks) == 0 {
+			return p.client.CheckConnection()
+		}
+		messages := make([]mqttMessage, len(blocks))
+		for i, blk := range blocks {
+			if messages[i], err = p.message(blk); err != nil {
+				return err
+			}
+		}
+		if err := p.client.Publish(messages); err != nil {
+			return err
+		}
+		nextHeight := blocks[len(blocks)-1].Height + 1
+		if err := p.saveCursor(nextHeight); err != nil {
+			return err
+		}
+
+		p.height.Set(float64(nextHeight))
+		p.lock.Lock()
+		p.status.NextHeight = nextHeight
+		p.status.Exported += uint64(len(blocks))
+		p.lock.Unlock()
+	}
+}
+
+// message returns the message announcing the acceptance of [blk]
+func (p *mqttPublisher) message(blk *exportedBlock) (mqttMessage, error) {
+	event := &mqttEvent{
+		ChainID:       p.vm.ctx.ChainID.String(),
+		ID:            blk.ID.String(),
+		ParentID:      blk.ParentID.String(),
+		Height:        blk.Height,
+		Timestamp:     blk.Timestamp.UTC(),
+		Namespace:     blk.Namespace,
+		HashAlgorithm: blk.HashAlgorithm,
+		BodyDropped:   blk.BodyDropped,
+	}
+	if blk.Data != nil {
+		data, err := formatting.EncodeWithChecksum(formatting.CB58, blk.Data)
+		if err != nil {
+			return mqttMessage{}, err
+		}
+		event.Data = data
+		event.Hash = hex.EncodeToString(blk.Data)
+	}
+	if blk.Submitter != nil {
+		event.Submitter = blk.Submitter.String()
+	}
+	if len(blk.Tags) > 0 {
+		event.Tags = make(map[string]string, len(blk.Tags))
+		for _, tag := range blk.Tags {
+			event.Tags[tag.Key] = tag.Value
+		}
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return mqttMessage{}, err
+	}
+	topic := strings.NewReplacer(
+		"{chainID}", event.ChainID,
+		"{namespace}", event.Namespace,
+		"{submitter}", event.Submitter,
+		"{height}", fmt.Sprint(event.Height),
+		"{id}", event.ID,
+	).Replace(p.topic)
+	return mqttMessage{topic: topic, payload: payload}, nil
+}
+
+// nextBlocks returns the next batch of accepted blocks to publish, none if
+// the VM shut down
+func (p *mqttPublisher) nextBlocks() ([]*exportedBlock, error) {
+	p.vm.ctx.Lock.Lock()
+	defer p.vm.ctx.Lock.Unlock()
+
+	if p.vm.isShutdown() {
+		return nil, nil
+	}
+	nextHeight := uint64(0)
+	cursor, err := p.vm.state.GetJobProgress(mqttPublisherJobName)
+	switch {
+	case err == nil:
+		nextHeight, err = database.ParseUInt64(cursor)
+		if err != nil {
+			return nil, err
+		}
+	case err != database.ErrNotFound:
+		return nil, err
+	}
+	return p.vm.exportedBlocks(nextHeight, mqttBatchSize)
+}
+
+// saveCursor persists [nextHeight] as the height of the next block to
+// publish
+func (p *mqttPublisher) saveCursor(nextHeight uint64) error {
+	p.vm.ctx.Lock.Lock()
+	defer p.vm.ctx.Lock.Unlock()
+
+	if p.vm.isShutdown() {
+		return nil
+	}
+	if err := p.vm.committer.Flush(); err != nil {
+		return err
+	}
+	if err := p.vm.state.SetJobProgress(mqttPublisherJobName, database.PackUInt64(nextHeight)); err != nil {
+		p.vm.state.Abort()
+		return err
+	}
+	return p.vm.state.Commit()
+}
@@ -0,0 +1,35 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxNamespaceLen is the maximum length of a namespace, in bytes
+const maxNamespaceLen = 64
+
+var (
+	errBadNamespace        = errors.New("namespace must only contain letters, digits, '.', '_' and '-'")
+	errNamespaceTooLong    = fmt.Errorf("namespace must be at most %d bytes long", maxNamespaceLen)
+	errNamespacedOperation = errors.New("only blocks anchoring data may have a namespace")
+)
+
+// verifyNamespace returns nil iff [namespace] is a valid namespace. The empty
+// namespace is valid and stands for data of no particular application.
+func verifyNamespace(namespace string) error {
+	if len(namespace) > maxNamespaceLen {
+		return errNamespaceTooLong
+	}
+	for _, c := range namespace {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return fmt.Errorf("%w: %q", errBadNamespace, namespace)
+		}
+	}
+	return nil
+}
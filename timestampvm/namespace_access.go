@@ -0,0 +1,163 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	errNamespacesWithoutSigning = errors.New("namespace submitters require signed submissions")
+	errNoNamespaces             = errors.New("namespace access must grant at least one namespace")
+	errNamespaceForbidden       = errors.New("submitter may not anchor data into this namespace")
+
+	_ BlockVerifier = &namespaceVerifier{}
+)
+
+// NamespaceAccess restricts an API key to namespaces
+type NamespaceAccess struct {
+	// Namespaces are the only namespaces the caller may propose data into
+	Namespaces []string `json:"namespaces"`
+	// RestrictReads additionally limits the blocks the caller may read to
+	// the ones in [Namespaces]
+	RestrictReads bool `json:"restrictReads"`
+}
+
+// includes returns true if [namespace] is granted by [a]
+func (a *NamespaceAccess) includes(namespace string) bool {
+	for _, granted := range a.Namespaces {
+		if granted == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyNamespaceConfig returns nil iff [namespaces] are valid, non-empty
+// namespaces
+func verifyNamespaceConfig(namespaces ...string) error {
+	for _, namespace := range namespaces {
+		if namespace == "" {
+			return errMissingNamespace
+		}
+		if err := verifyNamespace(namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaceSubmitters binds submitters to namespaces. A namespace with
+// submitters is reserved to them, and a submitter bound to namespaces may
+// only anchor data into them.
+type namespaceSubmitters struct {
+	// submitters by reserved namespace
+	byNamespace map[string]ids.ShortSet
+	// submitters bound to any namespace
+	bound ids.ShortSet
+}
+
+// newNamespaceSubmitters returns the bindings of [config], or nil if there
+// are none
+func newNamespaceSubmitters(config *Config) *namespaceSubmitters {
+	if len(config.NamespaceSubmitters) == 0 {
+		return nil
+	}
+	s := &namespaceSubmitters{
+		byNamespace: make(map[string]ids.ShortSet, len(config.NamespaceSubmitters)),
+	}
+	for namespace, submitters := range config.NamespaceSubmitters {
+		set := ids.NewShortSet(len(submitters))
+		set.Add(submitters...)
+		s.byNamespace[namespace] = set
+		s.bound.Add(submitters...)
+	}
+	return s
+}
+
+// allows returns true if [submitter] may anchor data into [namespace]
+func (s *namespaceSubmitters) allows(submitter ids.ShortID, namespace string) bool {
+	if submitters, reserved := s.byNamespace[namespace]; reserved {
+		return submitters.Contains(submitter)
+	}
+	return !s.bound.Contains(submitter)
+}
+
+// verify returns nil iff data of [submitter] may be anchored into
+// [namespace]. Unsigned data has no submitter, it may only be anchored
+// outside of the reserved namespaces.
+func (s *namespaceSubmitters) verify(submitter *ids.ShortID, namespace string) error {
+	if submitter == nil {
+		if _, reserved := s.byNamespace[namespace]; reserved {
+			return fmt.Errorf("%w: %q", errNamespaceForbidden, namespace)
+		}
+		return nil
+	}
+	if !s.allows(*submitter, namespace) {
+		return fmt.Errorf("%w: %s into %q", errNamespaceForbidden, submitter, namespace)
+	}
+	return nil
+}
+
+// namespaceVerifier enforces [vm.namespaceSubmitters] on blocks anchoring
+// data. Operations have no namespace.
+type namespaceVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *namespaceVerifier) VerifyBlock(blk *Block) error {
+	if blk.isOperation() {
+		return nil
+	}
+	if !blk.IsSigned() {
+		return v.vm.namespaceSubmitters.verify(nil, blk.Namespace())
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	return v.vm.namespaceSubmitters.verify(&submitter, blk.Namespace())
+}
+
+// callerNamespaces returns the name and the namespace access of the caller
+// of [r], or a nil access if it isn't restricted to namespaces
+func (vm *VM) callerNamespaces(r *http.Request) (string, *NamespaceAccess) {
+	if r == nil {
+		return "", nil
+	}
+	caller := requestCaller(r)
+	if caller == nil {
+		return "", nil
+	}
+	access, ok := vm.config.APIKeyNamespaces[caller.name]
+	if !ok {
+		return "", nil
+	}
+	return caller.name, &access
+}
+
+// authorizeNamespaceWrite returns an error if the caller of [r] may not
+// propose data into [namespace]
+func (vm *VM) authorizeNamespaceWrite(r *http.Request, namespace string) error {
+	name, access := vm.callerNamespaces(r)
+	if access == nil || access.includes(namespace) {
+		return nil
+	}
+	return fmt.Errorf("%w: namespace %q isn't granted to %s", errForbidden, namespace, name)
+}
+
+// authorizeNamespaceRead returns an error if the caller of [r] may not read
+// blocks in [namespace]
+func (vm *VM) authorizeNamespaceRead(r *http.Request, namespace string) error {
+	name, access := vm.callerNamespaces(r)
+	if access == nil || !access.RestrictReads || access.includes(namespace) {
+		return nil
+	}
+	return fmt.Errorf("%w: namespace %q isn't readable by %s", errForbidden, namespace, name)
+}
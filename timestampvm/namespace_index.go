@@ -0,0 +1,86 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+var _ NamespaceIndex = &namespaceIndex{}
+
+// NamespaceIndex lists the accepted blocks anchoring data in each namespace
+type NamespaceIndex interface {
+	// IndexNamespace adds the accepted block [blkID] at [height] to the
+	// blocks of [namespace]
+	IndexNamespace(namespace string, height uint64, blkID ids.ID) error
+	// GetNamespaceBlockIDs returns at most [limit] IDs of accepted blocks in
+	// [namespace], in height order, starting at [startHeight]
+	GetNamespaceBlockIDs(ctx context.Context, namespace string, startHeight uint64, limit int) ([]ids.ID, error)
+}
+
+// namespaceIndex implements NamespaceIndex with a database keyed by the hash
+// of the namespace followed by the big-endian encoded block height. Hashing
+// gives namespaces keys of equal length, so none is a prefix of another.
+type namespaceIndex struct {
+	indexDB database.Database
+}
+
+// NewNamespaceIndex returns NamespaceIndex stored in the given db
+func NewNamespaceIndex(db database.Database) NamespaceIndex {
+	return &namespaceIndex{indexDB: db}
+}
+
+// namespacePrefix returns the prefix of the keys of the blocks in [namespace]
+func namespacePrefix(namespace string) []byte {
+	return hashing.ComputeHash256([]byte(namespace))
+}
+
+// namespaceKey returns the key of the block at [height] in [namespace]
+func namespaceKey(namespace string, height uint64) []byte {
+	return append(namespacePrefix(namespace), database.PackUInt64(height)...)
+}
+
+// IndexNamespace implements the NamespaceIndex interface
+func (i *namespaceIndex) IndexNamespace(namespace string, height uint64, blkID ids.ID) error {
+	return database.PutID(i.indexDB, namespaceKey(namespace, height), blkID)
+}
+
+// GetNamespaceBlockIDs implements the NamespaceIndex interface
+func (i *namespaceIndex) GetNamespaceBlockIDs(ctx context.Context, namespace string, startHeight uint64, limit int) ([]ids.ID, error) {
+	it := i.indexDB.NewIteratorWithStartAndPrefix(namespaceKey(namespace, startHeight), namespacePrefix(namespace))
+	defer it.Release()
+
+	blkIDs := []ids.ID(nil)
+	for len(blkIDs) < limit && it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blkID, err := ids.ToID(it.Value())
+		if err != nil {
+			return nil, err
+		}
+		blkIDs = append(blkIDs, blkID)
+	}
+	return blkIDs, it.Error()
+}
+
+// indexNamespace lists the accepted [blk] under its namespace, if it has one,
+// and under each of its tags within the namespace
+func (vm *VM) indexNamespace(blk *Block) error {
+	if blk.Namespace() != "" {
+		if err := vm.state.IndexNamespace(blk.Namespace(), blk.Height(), blk.ID()); err != nil {
+			return err
+		}
+	}
+	for _, tag := range blk.Tags() {
+		if err := vm.state.IndexTag(blk.Namespace(), tag, blk.Height(), blk.ID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import "errors"
+
+var errZeroNamespaceWeight = errors.New("namespace weights must be positive")
+
+// namespaceScheduler takes turns between the namespaces with pending
+// submissions, in proportion to their weights. It's a smooth weighted
+// round-robin: the turns of a namespace are spread over the round rather than
+// taken in a row.
+type namespaceScheduler struct {
+	// weights by namespace, 1 for namespaces not listed
+	weights map[string]uint64
+	// turns owed to each namespace with pending submissions
+	current map[string]int64
+}
+
+// newNamespaceScheduler returns the scheduler configured by [config], or nil
+// if submissions are scheduled in FIFO order
+func newNamespaceScheduler(config *Config) *namespaceScheduler {
+	if !config.NamespaceRoundRobin {
+		return nil
+	}
+	return &namespaceScheduler{
+		weights: config.NamespaceWeights,
+		current: make(map[string]int64),
+	}
+}
+
+// weight returns the weight of [namespace]
+func (s *namespaceScheduler) weight(namespace string) int64 {
+	if weight, ok := s.weights[namespace]; ok {
+		return int64(weight)
+	}
+	return 1
+}
+
+// Next returns the index in [pending] of the oldest submission of the
+// namespace whose turn it is. [pending] must not be empty.
+func (s *namespaceScheduler) Next(pending []*submission) int {
+	// the oldest submission of each namespace, in the order they are pending
+	oldest := make(map[string]int)
+	namespaces := []string(nil)
+	for i, sub := range pending {
+		if _, ok := oldest[sub.namespace]; !ok {
+			oldest[sub.namespace] = i
+			namespaces = append(namespaces, sub.namespace)
+		}
+	}
+	// namespaces without pending submissions neither gain nor owe turns
+	for namespace := range s.current {
+		if _, ok := oldest[namespace]; !ok {
+			delete(s.current, namespace)
+		}
+	}
+
+	total := int64(0)
+	next := ""
+	for i, namespace := range namespaces {
+		weight := s.weight(namespace)
+		s.current[namespace] += weight
+		total += weight
+		if i == 0 || s.current[namespace] > s.current[next] {
+			next = namespace
+		}
+	}
+	s.current[next] -= total
+	return oldest[next]
+}
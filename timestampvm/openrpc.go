@@ -0,0 +1,263 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding"
+	stdjson "encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+const (
+	// openRPCVersion is the version of the OpenRPC specification the
+	// documents conform to
+	openRPCVersion = "1.2.6"
+	// discoveryService is the name of the service serving the documents, so
+	// they're returned by the rpc.discover method the specification defines
+	discoveryService = "rpc"
+	// schemaRefPrefix prefixes the names of the schemas referred to
+	schemaRefPrefix = "#/components/schemas/"
+)
+
+var (
+	httpRequestType   = reflect.TypeOf((*http.Request)(nil))
+	errorType         = reflect.TypeOf((*error)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*stdjson.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// openRPCDocument describes the methods of an API, so clients in other
+// languages are generated from it, see https://spec.open-rpc.org
+type openRPCDocument struct {
+	OpenRPC    string            `json:"openrpc"`
+	Info       openRPCInfo       `json:"info"`
+	Methods    []openRPCMethod   `json:"methods"`
+	Components openRPCComponents `json:"components"`
+}
+
+// openRPCInfo describes the API
+type openRPCInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// openRPCMethod describes a method of the API. The arguments of methods are
+// passed by name.
+type openRPCMethod struct {
+	Name           string                     `json:"name"`
+	ParamStructure string                     `json:"paramStructure"`
+	Params         []openRPCContentDescriptor `json:"params"`
+	Result         openRPCContentDescriptor   `json:"result"`
+}
+
+// openRPCContentDescriptor describes an argument or the result of a method
+type openRPCContentDescriptor struct {
+	Name   string     `json:"name"`
+	Schema typeSchema `json:"schema"`
+}
+
+// openRPCComponents holds the schemas of the types used by the methods,
+// referred to by name
+type openRPCComponents struct {
+	Schemas map[string]typeSchema `json:"schemas"`
+}
+
+// typeSchema is the JSON Schema of a Go type
+type typeSchema map[string]interface{}
+
+// rpcDiscovery serves the OpenRPC document of the API it's registered with
+type rpcDiscovery struct {
+	document stdjson.RawMessage
+}
+
+// Discover returns the OpenRPC document describing the methods of the API
+func (d *rpcDiscovery) Discover(_ *http.Request, _ *struct{}, reply *stdjson.RawMessage) error {
+	*reply = d.document
+	return nil
+}
+
+// registerDiscovery registers the rpc.discover method with [server],
+// returning the OpenRPC document titled [title] of the methods of [rcvr],
+// which is registered with [server] as [service]
+func registerDiscovery(server *rpc.Server, title string, rcvr interface{}, service string) error {
+	document, err := stdjson.Marshal(newOpenRPCDocument(title, rcvr, service))
+	if err != nil {
+		return err
+	}
+	return server.RegisterService(&rpcDiscovery{document: document}, discoveryService)
+}
+
+// newOpenRPCDocument returns the OpenRPC document titled [title] of the
+// methods of [rcvr], registered as [service]. As the RPC server, it
+// considers the exported methods taking a request, arguments and a reply.
+// The types of the arguments and replies are described as they are encoded
+// to JSON.
+func newOpenRPCDocument(title string, rcvr interface{}, service string) *openRPCDocument {
+	g := &schemaGenerator{
+		names:   map[reflect.Type]string{},
+		schemas: map[string]typeSchema{},
+	}
+	document := &openRPCDocument{
+		OpenRPC: openRPCVersion,
+		Info: openRPCInfo{
+			Title:   title,
+			Version: Version.String(),
+		},
+		Methods:    []openRPCMethod{},
+		Components: openRPCComponents{Schemas: g.schemas},
+	}
+	rcvrType := reflect.TypeOf(rcvr)
+	for i := 0; i < rcvrType.NumMethod(); i++ {
+		method := rcvrType.Method(i)
+		methodType := method.Type
+		if methodType.NumIn() != 4 || methodType.In(1) != httpRequestType ||
+			methodType.In(2).Kind() != reflect.Ptr || methodType.In(3).Kind() != reflect.Ptr ||
+			methodType.NumOut() != 1 || methodType.Out(0) != errorType {
+			continue
+		}
+		params := []openRPCContentDescriptor{}
+		args := methodType.In(2).Elem()
+		if args.Kind() == reflect.Struct {
+			g.fields(args, func(name string, schema typeSchema) {
+				params = append(params, openRPCContentDescriptor{Name: name, Schema: schema})
+			})
+		}
+		reply := methodType.In(3).Elem()
+		document.Methods = append(document.Methods, openRPCMethod{
+			Name:           service + "." + lowerFirst(method.Name),
+			ParamStructure: "by-name",
+			Params:         params,
+			Result: openRPCContentDescriptor{
+				Name:   reply.Name(),
+				Schema: g.schema(reply),
+			},
+		})
+	}
+	return document
+}
+
+// lowerFirst returns [s] with its first letter in lower case, as methods
+// are called
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
+
+// schemaGenerator generates the JSON Schemas of types. The schemas of named
+// structs are collected in [schemas] and referred to, so recursive types
+// are described.
+type schemaGenerator struct {
+	// name of the schema of every named struct
+	names   map[reflect.Type]string
+	schemas map[string]typeSchema
+}
+
+// schema returns the JSON Schema of [t]
+func (g *schemaGenerator) schema(t reflect.Type) typeSchema {
+	switch {
+	case t == timeType:
+		return typeSchema{"type": "string", "format": "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType),
+		t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		// Types encoding themselves, e.g. IDs and integers, are encoded as
+		// strings
+		return typeSchema{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return typeSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return typeSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return typeSchema{"type": "number"}
+	case reflect.String:
+		return typeSchema{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return typeSchema{"type": "string", "contentEncoding": "base64"}
+		}
+		return typeSchema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Array:
+		return typeSchema{"type": "array", "items": g.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return typeSchema{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = t.Name()
+			if _, taken := g.schemas[name]; taken {
+				name = strings.ReplaceAll(t.String(), ".", "_")
+			}
+			g.names[t] = name
+			// Registered before describing the fields, which may refer to it
+			g.schemas[name] = typeSchema{}
+			g.schemas[name] = g.object(t)
+		}
+		return typeSchema{"$ref": schemaRefPrefix + name}
+	default:
+		// Interfaces may hold any value
+		return typeSchema{}
+	}
+}
+
+// object returns the JSON Schema of the struct [t]
+func (g *schemaGenerator) object(t reflect.Type) typeSchema {
+	properties := map[string]typeSchema{}
+	g.fields(t, func(name string, schema typeSchema) {
+		properties[name] = schema
+	})
+	return typeSchema{"type": "object", "properties": properties}
+}
+
+// fields calls [f] with the name and JSON Schema of every field of the
+// struct [t] which is encoded to JSON, including the fields of embedded
+// structs
+func (g *schemaGenerator) fields(t reflect.Type, f func(name string, schema typeSchema)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.IndexByte(tag, ','); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			g.fields(fieldType, f)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := g.schema(field.Type)
+		for _, option := range strings.Split(options, ",") {
+			if option == "string" {
+				schema = typeSchema{"type": "string"}
+			}
+		}
+		f(name, schema)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/snow"
+	"github.com/chain4travel/caminogo/utils/logging"
+)
+
+// otlpTracesPath is the path OTLP/HTTP collectors receive spans at
+const otlpTracesPath = "/v1/traces"
+
+// otlpScopeName names the instrumentation the exported spans come from
+const otlpScopeName = "github.com/chain4travel/camino-timestampvm"
+
+// otlpMaxPendingSpans is the number of ended spans held while the collector
+// is unreachable. The oldest spans are dropped beyond.
+const otlpMaxPendingSpans = 10000
+
+// Span kind and status code of exported spans, as defined by the OTLP
+// protocol
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+var (
+	_ Tracer = &otlpTracer{}
+
+	errBadOTLPEndpoint    = errors.New("tracingOTLPEndpoint must be an http or https URL")
+	errOTLPWithoutTracing = errors.New("tracingOTLPEndpoint requires tracingEnabled")
+	errOTLPExportFailed   = errors.New("OTLP collector refused the spans")
+)
+
+// otlpTracerKey is the context key of the otlpActiveSpan an otlpTracer started
+type otlpTracerKey struct{}
+
+// otlpTracer is the Tracer used if tracing is enabled with an OTLP endpoint.
+// Ended spans are exported in batches to the collector with OTLP/HTTP, in its
+// JSON encoding.
+type otlpTracer struct {
+	url      string
+	client   *http.Client
+	log      logging.Logger
+	interval time.Duration
+	resource otlpResource
+
+	lock    sync.Mutex
+	pending []otlpSpan
+	dropped int
+}
+
+// otlpResource is the entity producing the spans
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+// otlpSpan is an ended span as encoded by OTLP/JSON. Trace and span IDs are
+// hex encoded and 64-bit integers are strings.
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+// otlpAttribute is an Attribute as encoded by OTLP/JSON
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue holds exactly one of its fields
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpStatus is the status of a failed span
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpActiveSpan is a span started by an otlpTracer
+type otlpActiveSpan struct {
+	tracer *otlpTracer
+	start  time.Time
+	span   otlpSpan
+}
+
+// newOTLPTracer returns the tracer exporting the spans of the VM running in
+// [ctx] to the collector configured in [config]
+func newOTLPTracer(ctx *snow.Context, config *Config) *otlpTracer {
+	return &otlpTracer{
+		url:      strings.TrimSuffix(config.TracingOTLPEndpoint, "/") + otlpTracesPath,
+		client:   &http.Client{Timeout: config.TracingOTLPTimeout.Duration},
+		log:      ctx.Log,
+		interval: config.TracingOTLPInterval.Duration,
+		resource: otlpResource{Attributes: otlpAttributes([]Attribute{
+			{Key: "service.name", Value: Name},
+			{Key: "camino.chain_id", Value: ctx.ChainID},
+		})},
+	}
+}
+
+// verifyOTLPEndpoint returns an error if [endpoint] isn't the URL of an
+// OTLP/HTTP collector
+func verifyOTLPEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q", errBadOTLPEndpoint, endpoint)
+	}
+	return nil
+}
+
+// Start implements the Tracer interface
+func (t *otlpTracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	span := &otlpActiveSpan{
+		tracer: t,
+		start:  time.Now(),
+		span: otlpSpan{
+			SpanID:     randomHex(8),
+			Name:       name,
+			Kind:       otlpSpanKindInternal,
+			Attributes: otlpAttributes(attributes),
+		},
+	}
+	if parent, ok := ctx.Value(otlpTracerKey{}).(*otlpActiveSpan); ok {
+		span.span.TraceID = parent.span.TraceID
+		span.span.ParentSpanID = parent.span.SpanID
+	} else {
+		span.span.TraceID = randomHex(16)
+	}
+	return context.WithValue(ctx, otlpTracerKey{}, span), span
+}
+
+// RecordError implements the Span interface
+func (s *otlpActiveSpan) RecordError(err error) {
+	s.span.Status = &otlpStatus{
+		Code:    otlpStatusCodeError,
+		Message: err.Error(),
+	}
+}
+
+// End implements the Span interface
+func (s *otlpActiveSpan) End() {
+	s.span.StartTimeUnixNano = strconv.FormatInt(s.start.UnixNano(), 10)
+	s.span.EndTimeUnixNano = strconv.FormatInt(time.Now().UnixNano(), 10)
+	s.tracer.add(s.span)
+}
+
+// add queues [span] for the next export
+func (t *otlpTracer) add(span otlpSpan) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.pending = append(t.pending, span)
+	t.trimPending()
+}
+
+// trimPending drops the oldest pending spans beyond [otlpMaxPendingSpans].
+// Must be called holding [t.lock].
+func (t *otlpTracer) trimPending() {
+	if excess := len(t.pending) - otlpMaxPendingSpans; excess > 0 {
+		t.pending = t.pending[excess:]
+		t.dropped += excess
+	}
+}
+
+// runPeriodically exports the ended spans every [t.interval] until [stop] is
+// closed
+func (t *otlpTracer) runPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		if err := t.Flush(); err != nil {
+			t.log.Warn("couldn't export spans: %s", err)
+		}
+	}
+}
+
+// Flush exports the spans ended since the last export. Spans which couldn't
+// be exported are retried with the next export.
+func (t *otlpTracer) Flush() error {
+	t.lock.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.lock.Unlock()
+
+	if dropped > 0 {
+		t.log.Warn("dropped %d spans the OTLP collector didn't receive in time", dropped)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	if err := t.export(spans); err != nil {
+		t.lock.Lock()
+		t.pending = append(spans, t.pending...)
+		t.trimPending()
+		t.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// export posts [spans] to the collector
+func (t *otlpTracer) export(spans []otlpSpan) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": t.resource,
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": otlpScopeName},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := t.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("%w: %s", errOTLPExportFailed, response.Status)
+	}
+	return nil
+}
+
+// otlpAttributes encodes [attributes] for OTLP/JSON. Values which are
+// neither numbers nor booleans are exported as strings.
+func otlpAttributes(attributes []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		value := otlpValue{}
+		switch v := attribute.Value.(type) {
+		case bool:
+			value.BoolValue = &v
+		case int, int32, int64, uint, uint32, uint64:
+			s := fmt.Sprint(v)
+			value.IntValue = &s
+		case float32:
+			f := float64(v)
+			value.DoubleValue = &f
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return encoded
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errPostgresExportDisabled = errors.New("PostgreSQL export is disabled")
+	errBadPostgresSchema      = errors.New("postgresSchema must be a lower case SQL identifier")
+	errPostgresBatchSize      = errors.New("postgresBatchSize must be positive")
+
+	postgresIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+	// postgresTables are the statements creating the tables blocks are
+	// exported to, formatted with the schema
+	postgresTables = []string{
+		`CREATE SCHEMA IF NOT EXISTS %[1]s`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.blocks (
+			height BIGINT PRIMARY KEY,
+			id TEXT NOT NULL UNIQUE,
+			parent_id TEXT NOT NULL,
+			timestamp TIMESTAMPTZ NOT NULL,
+			data BYTEA,
+			namespace TEXT,
+			submitter TEXT,
+			hash_algorithm TEXT,
+			body_dropped BOOLEAN NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS blocks_data ON %[1]s.blocks (data)`,
+		`CREATE INDEX IF NOT EXISTS blocks_namespace ON %[1]s.blocks (namespace, height)`,
+		`CREATE INDEX IF NOT EXISTS blocks_submitter ON %[1]s.blocks (submitter, height)`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.tags (
+			height BIGINT NOT NULL REFERENCES %[1]s.blocks ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (height, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS tags_key_value ON %[1]s.tags (key, value, height)`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.cursor (
+			name TEXT PRIMARY KEY,
+			next_height BIGINT NOT NULL
+		)`,
+	}
+)
+
+// newPostgresExporter returns the sqlExporter streaming the accepted blocks
+// of [vm] into the tables of a PostgreSQL schema, so integrators query them
+// with SQL instead of writing their own indexer, reporting metrics to
+// [registerer], or nil if the export isn't configured. The database is
+// connected to lazily.
+func newPostgresExporter(vm *VM, registerer prometheus.Registerer) (*sqlExporter, error) {
+	config := &vm.config
+	if config.PostgresDSNFile == "" {
+		return nil, nil
+	}
+	dsn, err := readToken(config.PostgresDSNFile)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(config.PostgresDriver, string(dsn))
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, len(postgresTables))
+	for i, statement := range postgresTables {
+		tables[i] = fmt.Sprintf(statement, config.PostgresSchema)
+	}
+	return newSQLExporter(vm, db, sqlTarget{
+		name:          "PostgreSQL",
+		metricsPrefix: "postgres_export",
+		tables:        tables,
+		prefix:        config.PostgresSchema + ".",
+		batchSize:     config.PostgresBatchSize,
+		interval:      config.PostgresInterval.Duration,
+	}, registerer)
+}
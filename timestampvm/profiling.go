@@ -0,0 +1,55 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/chain4travel/caminogo/snow/engine/common"
+)
+
+// profilingPath is the path the profiles are served under
+const profilingPath = "/admin/pprof"
+
+var (
+	errProfilingWithoutAdmin = errors.New("profiling requires the admin API to be enabled")
+	errNoProfilingToken      = errors.New("profiling requires a token file")
+)
+
+// profilingToken returns the token requests for profiles must present, which
+// is the admin token unless a separate one is configured
+func (vm *VM) profilingToken() ([]byte, error) {
+	if vm.config.ProfilingTokenFile == "" {
+		return readToken(vm.config.AdminTokenFile)
+	}
+	return readToken(vm.config.ProfilingTokenFile)
+}
+
+// profilingHandlers returns the handlers serving the pprof profiles of the
+// node at the paths net/http/pprof uses, under [profilingPath], e.g.
+// "/admin/pprof/heap". Requests must present [token] as a bearer token.
+func (vm *VM) profilingHandlers(token []byte) map[string]*common.HTTPHandler {
+	handlers := map[string]http.Handler{
+		"cmdline": http.HandlerFunc(pprof.Cmdline),
+		"profile": http.HandlerFunc(pprof.Profile),
+		"symbol":  http.HandlerFunc(pprof.Symbol),
+		"trace":   http.HandlerFunc(pprof.Trace),
+	}
+	for _, profile := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		handlers[profile] = pprof.Handler(profile)
+	}
+
+	httpHandlers := make(map[string]*common.HTTPHandler, len(handlers))
+	for name, handler := range handlers {
+		// Profiling doesn't touch the state, and CPU profiles and traces
+		// take several seconds
+		httpHandlers[profilingPath+"/"+name] = &common.HTTPHandler{
+			LockOptions: common.NoLock,
+			Handler:     vm.auditHTTP(requireToken(token, handler)),
+		}
+	}
+	return httpHandlers
+}
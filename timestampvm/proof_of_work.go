@@ -0,0 +1,130 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+// maxProofOfWorkBits is the highest difficulty which can be configured.
+// Higher difficulties couldn't be met by clients in reasonable time.
+const maxProofOfWorkBits = 64
+
+var (
+	errProofOfWorkDisabled       = errors.New("proofs of work aren't accepted on this chain")
+	errProofOfWorkWithValidators = errors.New("proofs of work and validator submissions can't be enabled together")
+	errProofOfWorkTooHard        = errors.New("proof of work difficulty is too high")
+	errNoProofOfWorkAge          = errors.New("proofs of work must be allowed to be based on at least one block")
+	errRetentionBelowProofOfWork = errors.New("pruning must retain more blocks than the maximum age of proofs of work")
+	errMissingProofOfWork        = errors.New("submissions require a proof of work on this chain")
+	errInsufficientWork          = errors.New("proof of work doesn't meet the difficulty")
+	errStaleProofOfWork          = errors.New("proof of work isn't based on a recent ancestor of the block")
+
+	_ BlockVerifier = &proofOfWorkVerifier{}
+)
+
+// ProofOfWork shows work done by the client over the data of a submission
+// and a recent block, so spamming the chain has a cost even without fees
+type ProofOfWork struct {
+	// RecentID is the ID of a recent block the work is based on, so it can't
+	// be done in advance
+	RecentID ids.ID `serialize:"true" json:"recentID"`
+	// Nonce is chosen by the client so the hash meets the difficulty
+	Nonce uint64 `serialize:"true" json:"nonce"`
+}
+
+// ProofOfWorkHash returns the hash which must start with enough zero bits
+// for [nonce] to be a proof of work over [data] based on [recentID]
+func ProofOfWorkHash(recentID ids.ID, data [dataLen]byte, nonce uint64) ids.ID {
+	msg := make([]byte, 0, len(recentID)+dataLen+wrappers.LongLen)
+	msg = append(msg, recentID[:]...)
+	msg = append(msg, data[:]...)
+	msg = append(msg, make([]byte, wrappers.LongLen)...)
+	binary.BigEndian.PutUint64(msg[len(msg)-wrappers.LongLen:], nonce)
+	return hashing.ComputeHash256Array(msg)
+}
+
+// SolveProofOfWork returns a proof of work over [data] based on [recentID]
+// whose hash starts with at least [difficulty] zero bits
+func SolveProofOfWork(recentID ids.ID, data [dataLen]byte, difficulty uint64) *ProofOfWork {
+	nonce := uint64(0)
+	for leadingZeroBits(ProofOfWorkHash(recentID, data, nonce)) < difficulty {
+		nonce++
+	}
+	return &ProofOfWork{
+		RecentID: recentID,
+		Nonce:    nonce,
+	}
+}
+
+// leadingZeroBits returns the number of zero bits [hash] starts with
+func leadingZeroBits(hash ids.ID) uint64 {
+	zeros := uint64(0)
+	for _, b := range hash {
+		if b != 0 {
+			return zeros + uint64(bits.LeadingZeros8(b))
+		}
+		zeros += 8
+	}
+	return zeros
+}
+
+// proofOfWorkVerifier requires blocks anchoring data to carry a proof of
+// work meeting [vm.config.ProofOfWorkBits], unless they are within the free
+// daily allowance of their submitter. Operations are authorized by their
+// signatures instead.
+type proofOfWorkVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *proofOfWorkVerifier) VerifyBlock(blk *Block) error {
+	if blk.isOperation() {
+		return nil
+	}
+	free, err := v.vm.isFreeAnchor(blk)
+	if err != nil || free {
+		return err
+	}
+	parent, err := v.vm.getBlock(blk.Parent())
+	if err != nil {
+		return errDatabaseGet
+	}
+	return v.vm.verifyProofOfWork(parent, blk.Dt, blk.PrfWrk)
+}
+
+// verifyProofOfWork returns nil iff [pow] is a proof of work over [data]
+// meeting the difficulty, based on [parent] or one of its ancestors less
+// than [vm.config.ProofOfWorkMaxAge] blocks before it
+func (vm *VM) verifyProofOfWork(parent *Block, data [dataLen]byte, pow *ProofOfWork) error {
+	if pow == nil {
+		return errMissingProofOfWork
+	}
+	if leadingZeroBits(ProofOfWorkHash(pow.RecentID, data, pow.Nonce)) < vm.config.ProofOfWorkBits {
+		return errInsufficientWork
+	}
+	// Headers are retained for redacted ancestors
+	blkID, header := parent.ID(), newBlockHeader(parent)
+	for age := uint64(1); ; age++ {
+		if blkID == pow.RecentID {
+			return nil
+		}
+		if age >= vm.config.ProofOfWorkMaxAge || header.Hght == 0 {
+			return fmt.Errorf("%w: %s", errStaleProofOfWork, pow.RecentID)
+		}
+		blkID = header.PrntID
+		var err error
+		header, err = vm.getBlockHeader(blkID)
+		if err != nil {
+			return errDatabaseGet
+		}
+	}
+}
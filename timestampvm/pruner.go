@@ -0,0 +1,209 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+var (
+	errPruningDisabled = errors.New("pruning is disabled")
+	errPruningRunning  = errors.New("pruning is already running")
+)
+
+// PruningStatus reports the progress of pruning
+type PruningStatus struct {
+	// Running is true while blocks are being pruned
+	Running bool `json:"running"`
+	// PrunedHeight is the height of the first block which isn't pruned
+	PrunedHeight json.Uint64 `json:"prunedHeight"`
+	// TargetHeight is the height the current (or last) run prunes up to,
+	// exclusive. Time based retention may stop a run earlier.
+	TargetHeight json.Uint64 `json:"targetHeight"`
+	// LastError is the error which aborted the last run, if any
+	LastError string `json:"lastError,omitempty"`
+}
+
+// pruner deletes accepted blocks which are outside of the retention limits
+// configured in [vm.config]. Blocks are deleted in batches, each one holding
+// the context lock, so consensus keeps making progress while pruning.
+type pruner struct {
+	vm *VM
+
+	lock   sync.Mutex
+	status PruningStatus
+}
+
+// newPruner returns a pruner for [vm]
+func newPruner(vm *VM) *pruner {
+	return &pruner{vm: vm}
+}
+
+// Status returns the progress of pruning
+func (p *pruner) Status() PruningStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.status
+}
+
+// Trigger starts pruning in the background
+func (p *pruner) Trigger() error {
+	if !p.vm.config.PruningEnabled {
+		return errPruningDisabled
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.status.Running {
+		return errPruningRunning
+	}
+	p.status.Running = true
+	p.status.LastError = ""
+	go p.run()
+	return nil
+}
+
+// runPeriodically triggers pruning every [vm.config.PruningInterval] until
+// the VM shuts down
+func (p *pruner) runPeriodically() {
+	ticker := time.NewTicker(p.vm.config.PruningInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Trigger(); err != nil {
+				p.vm.ctx.Log.Debug("skipping scheduled pruning: %s", err)
+			}
+		case <-p.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// run prunes batches of blocks until the retention limits are reached
+func (p *pruner) run() {
+	err := p.prune()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.status.Running = false
+	if err != nil {
+		p.status.LastError = err.Error()
+		p.vm.ctx.Log.Warn("pruning failed: %s", err)
+	}
+}
+
+// prune deletes blocks until the retention limits are reached
+func (p *pruner) prune() error {
+	for {
+		done, err := p.pruneBatch()
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// pruneBatch deletes up to [vm.config.PruningBatchSize] blocks and commits.
+// Returns true if there is nothing left to prune.
+func (p *pruner) pruneBatch() (done bool, err error) {
+	p.vm.ctx.Lock.Lock()
+	defer p.vm.ctx.Lock.Unlock()
+
+	// The database is closed once the VM shut down
+	if p.vm.isShutdown() {
+		return true, nil
+	}
+	if err := p.vm.committer.Flush(); err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			// don't let a later commit persist a partial batch
+			p.vm.state.Abort()
+		}
+	}()
+
+	state := p.vm.state
+	prunedHeight, err := state.GetPrunedHeight()
+	if err != nil {
+		return false, err
+	}
+	targetHeight, err := p.targetHeight()
+	if err != nil {
+		return false, err
+	}
+	p.setProgress(prunedHeight, targetHeight)
+
+	cutoff := time.Now().Add(-p.vm.config.PruningRetainPeriod.Duration)
+	for i := 0; i < p.vm.config.PruningBatchSize && prunedHeight < targetHeight; i++ {
+		blkID, err := state.GetAcceptedID(prunedHeight)
+		if err != nil {
+			return false, err
+		}
+		if p.vm.config.PruningRetainPeriod.Duration > 0 {
+			header, err := state.GetBlockHeader(blkID)
+			if err != nil {
+				return false, err
+			}
+			// Timestamps never decrease, so all later blocks are retained
+			if time.Unix(header.Tmstmp, 0).After(cutoff) {
+				targetHeight = prunedHeight
+				break
+			}
+		}
+		if p.vm.config.PruningKeepHeaders {
+			err = state.DeleteBlockBody(blkID)
+		} else {
+			err = state.DeleteBlock(blkID)
+		}
+		if err != nil {
+			return false, err
+		}
+		prunedHeight++
+	}
+
+	if err := state.SetPrunedHeight(prunedHeight); err != nil {
+		return false, err
+	}
+	if err := state.Commit(); err != nil {
+		return false, err
+	}
+	p.setProgress(prunedHeight, targetHeight)
+	return prunedHeight >= targetHeight, nil
+}
+
+// targetHeight returns the height up to which blocks may be pruned, exclusive,
+// according to [vm.config.PruningRetainBlocks]
+func (p *pruner) targetHeight() (uint64, error) {
+	lastAccepted, err := p.vm.lastAcceptedBlock()
+	if err != nil {
+		return 0, err
+	}
+	// The last accepted block is always retained
+	retain := p.vm.config.PruningRetainBlocks
+	if retain == 0 {
+		retain = 1
+	}
+	if lastAccepted.Height()+1 < retain {
+		return 0, nil
+	}
+	return lastAccepted.Height() + 1 - retain, nil
+}
+
+// setProgress updates the reported progress
+func (p *pruner) setProgress(prunedHeight, targetHeight uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.status.PrunedHeight = json.Uint64(prunedHeight)
+	p.status.TargetHeight = json.Uint64(targetHeight)
+}
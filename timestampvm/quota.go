@@ -0,0 +1,157 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+const (
+	secondsPerHour = 60 * 60
+	secondsPerDay  = 24 * secondsPerHour
+)
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// APIKeyQuota limits the calls to the public API made with an API key within
+// the current hour and day, in UTC. 0 doesn't limit.
+type APIKeyQuota struct {
+	// HourlyRequests and DailyRequests limit the calls of any method
+	HourlyRequests uint64 `json:"hourlyRequests"`
+	DailyRequests  uint64 `json:"dailyRequests"`
+	// HourlyProposals and DailyProposals limit the calls proposing data
+	HourlyProposals uint64 `json:"hourlyProposals"`
+	DailyProposals  uint64 `json:"dailyProposals"`
+}
+
+// APIKeyUsage counts the calls to the public API made with an API key since
+// the VM started
+type APIKeyUsage struct {
+	Role string `json:"role"`
+	// Requests and Proposals count the admitted calls
+	Requests  json.Uint64 `json:"requests"`
+	Proposals json.Uint64 `json:"proposals"`
+	// Rejected counts the calls refused by the quota
+	Rejected json.Uint64 `json:"rejected"`
+	// the admitted calls within the current hour and day
+	HourRequests  json.Uint64 `json:"hourRequests"`
+	HourProposals json.Uint64 `json:"hourProposals"`
+	DayRequests   json.Uint64 `json:"dayRequests"`
+	DayProposals  json.Uint64 `json:"dayProposals"`
+	// Quota applying to the calls
+	Quota APIKeyQuota `json:"quota"`
+}
+
+// keyUsage is the usage of an API key and the windows it's counted in
+type keyUsage struct {
+	APIKeyUsage
+	// the current hour and day, in hours and days since the epoch
+	hour, day int64
+}
+
+// roll resets the counts of the windows which ended before [now]
+func (u *keyUsage) roll(now time.Time) {
+	if hour := now.Unix() / secondsPerHour; hour != u.hour {
+		u.hour = hour
+		u.HourRequests = 0
+		u.HourProposals = 0
+	}
+	if day := now.Unix() / secondsPerDay; day != u.day {
+		u.day = day
+		u.DayRequests = 0
+		u.DayProposals = 0
+	}
+}
+
+// usageTracker counts the calls of each API key and enforces their quotas.
+// The counts are kept in memory, so they start over when the VM restarts.
+type usageTracker struct {
+	vm *VM
+
+	lock  sync.Mutex
+	usage map[string]*keyUsage
+}
+
+// newUsageTracker returns a usage tracker for [vm]
+func newUsageTracker(vm *VM) *usageTracker {
+	return &usageTracker{
+		vm:    vm,
+		usage: make(map[string]*keyUsage),
+	}
+}
+
+// quota returns the quota of the API key named [name]
+func (t *usageTracker) quota(name string) APIKeyQuota {
+	if quota, ok := t.vm.config.APIKeyQuotas[name]; ok {
+		return quota
+	}
+	return t.vm.config.DefaultAPIKeyQuota
+}
+
+// Charge counts a call of [method] by [caller] at [now], or returns an error
+// if it exceeds the caller's quota. Calls to the admin API aren't counted.
+func (t *usageTracker) Charge(caller *apiCaller, method string, now time.Time) error {
+	role := methodRole(method)
+	if role == AdminRole {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	usage, ok := t.usage[caller.name]
+	if !ok {
+		usage = &keyUsage{}
+		t.usage[caller.name] = usage
+	}
+	usage.Role = caller.role
+	usage.Quota = t.quota(caller.name)
+	usage.roll(now)
+
+	proposal := role == ProposerRole
+	exceeded := ""
+	switch quota := usage.Quota; {
+	case quota.HourlyRequests > 0 && uint64(usage.HourRequests) >= quota.HourlyRequests:
+		exceeded = fmt.Sprintf("hourly request quota of %d", quota.HourlyRequests)
+	case quota.DailyRequests > 0 && uint64(usage.DayRequests) >= quota.DailyRequests:
+		exceeded = fmt.Sprintf("daily request quota of %d", quota.DailyRequests)
+	case proposal && quota.HourlyProposals > 0 && uint64(usage.HourProposals) >= quota.HourlyProposals:
+		exceeded = fmt.Sprintf("hourly proposal quota of %d", quota.HourlyProposals)
+	case proposal && quota.DailyProposals > 0 && uint64(usage.DayProposals) >= quota.DailyProposals:
+		exceeded = fmt.Sprintf("daily proposal quota of %d", quota.DailyProposals)
+	}
+	if exceeded != "" {
+		usage.Rejected++
+		return fmt.Errorf("%w: %s reached the %s", errQuotaExceeded, caller.name, exceeded)
+	}
+
+	usage.Requests++
+	usage.HourRequests++
+	usage.DayRequests++
+	if proposal {
+		usage.Proposals++
+		usage.HourProposals++
+		usage.DayProposals++
+	}
+	return nil
+}
+
+// Usage returns the usage of every API key which called the public API, by
+// name, as of [now]
+func (t *usageTracker) Usage(now time.Time) map[string]APIKeyUsage {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	usage := make(map[string]APIKeyUsage, len(t.usage))
+	for name, keyUsage := range t.usage {
+		keyUsage.roll(now)
+		usage[name] = keyUsage.APIKeyUsage
+	}
+	return usage
+}
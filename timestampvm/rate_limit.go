@@ -0,0 +1,104 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Buckets calls to the public API are rate limited by
+const (
+	readBucket    = "read"
+	proposeBucket = "propose"
+)
+
+var (
+	errRateLimited    = errors.New("rate limit exceeded, retry later")
+	errRateLimitBurst = errors.New("rate limit burst must be at least 1")
+)
+
+// tokenBucket admits events at [rate] per second on average, and up to
+// [burst] at once
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token at [now], returning false if there is none
+func (b *tokenBucket) Allow(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter limits the calls to the public API across all clients, with
+// separate buckets for proposing data and for the other methods. The admin
+// API isn't limited, so operators can still reach a VM flooded by clients.
+type rateLimiter struct {
+	// nil if the methods aren't limited
+	read    *tokenBucket
+	propose *tokenBucket
+}
+
+// newRateLimiter returns the rate limiter configured by [config]
+func newRateLimiter(config *Config) *rateLimiter {
+	l := &rateLimiter{}
+	if config.RateLimitReadRPS > 0 {
+		l.read = newTokenBucket(config.RateLimitReadRPS, config.RateLimitReadBurst)
+	}
+	if config.RateLimitProposeRPS > 0 {
+		l.propose = newTokenBucket(config.RateLimitProposeRPS, config.RateLimitProposeBurst)
+	}
+	return l
+}
+
+// Allow returns an error if a call of [method] exceeds the rate limit, and
+// the bucket the call is limited by, if any
+func (l *rateLimiter) Allow(method string) (string, error) {
+	var (
+		bucketName string
+		bucket     *tokenBucket
+	)
+	switch methodRole(method) {
+	case AdminRole:
+		return "", nil
+	case ProposerRole:
+		bucketName, bucket = proposeBucket, l.propose
+	default:
+		bucketName, bucket = readBucket, l.read
+	}
+	if bucket == nil || bucket.Allow(time.Now()) {
+		return bucketName, nil
+	}
+	return bucketName, fmt.Errorf("%w: %s", errRateLimited, method)
+}
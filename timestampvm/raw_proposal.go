@@ -0,0 +1,89 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/formatting"
+)
+
+// rawProposalType is the content type of the bodies of raw proposals
+const rawProposalType = "application/octet-stream"
+
+var errRawProposalSize = fmt.Errorf("raw proposals must be %d bytes long", dataLen)
+
+// RawProposalReply is the reply of the raw proposal endpoint
+type RawProposalReply struct {
+	Success bool `json:"success"`
+	// Base 58 encoded data proposed, as taken by GetBlockByData
+	Data string `json:"data"`
+	// Address recovered from the signature, only set for signed submissions
+	Submitter *ids.ShortID `json:"submitter,omitempty"`
+}
+
+// serveRawProposal proposes a block whose data is the body, sent as
+// application/octet-stream, so clients like curl or embedded devices can
+// propose without encoding JSON-RPC calls:
+//
+//	curl --data-binary @digest.bin -H "Content-Type: application/octet-stream" <uri>/propose
+//
+// The body is the 32 bytes of the data. The query may set the base 58
+// encoded "signature" of the data, its "namespace", "hashAlgorithm" and any
+// number of "tag"s given as key=value, as the arguments of ProposeBlock.
+func (vm *VM) serveRawProposal(w http.ResponseWriter, r *http.Request) {
+	if !vm.admitProposal(w, r) {
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != rawProposalType {
+		http.Error(w, fmt.Sprintf("raw proposals must be sent as %s", rawProposalType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.ContentLength > dataLen {
+		http.Error(w, errRawProposalSize.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, dataLen+1))
+	switch {
+	case err != nil:
+		http.Error(w, "couldn't read request body", http.StatusBadRequest)
+		return
+	case len(body) > dataLen:
+		http.Error(w, errRawProposalSize.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case len(body) < dataLen:
+		http.Error(w, errRawProposalSize.Error(), http.StatusBadRequest)
+		return
+	}
+
+	args, err := parseProposalArgs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	args.Signature = r.URL.Query().Get("signature")
+	args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reply := ProposeBlockReply{}
+	vm.ctx.Lock.Lock()
+	err = (&Service{vm: vm}).ProposeBlock(r, args, &reply)
+	vm.ctx.Lock.Unlock()
+	if err != nil {
+		writeProposalError(w, err)
+		return
+	}
+	writeJSON(w, &RawProposalReply{
+		Success:   true,
+		Data:      args.Data,
+		Submitter: reply.Submitter,
+	})
+}
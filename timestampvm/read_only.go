@@ -0,0 +1,48 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+
+	"github.com/chain4travel/caminogo/database"
+)
+
+var (
+	_ database.Database = &readOnlyDB{}
+	_ database.Batch    = &readOnlyBatch{}
+
+	errReadOnly              = errors.New("the VM runs as a read-only replica")
+	errReadOnlyUninitialized = errors.New("a read-only replica requires an initialized database")
+	errReadOnlyConflict      = errors.New("readOnly can't be combined with options writing to the database")
+)
+
+// readOnlyDB is a database refusing all writes to the database it wraps.
+// Commits which don't write anything succeed.
+type readOnlyDB struct {
+	database.Database
+}
+
+// Put implements the database.KeyValueWriter interface
+func (*readOnlyDB) Put([]byte, []byte) error { return errReadOnly }
+
+// Delete implements the database.KeyValueDeleter interface
+func (*readOnlyDB) Delete([]byte) error { return errReadOnly }
+
+// NewBatch implements the database.Batcher interface
+func (*readOnlyDB) NewBatch() database.Batch { return &readOnlyBatch{} }
+
+// Compact implements the database.Compacter interface
+func (*readOnlyDB) Compact([]byte, []byte) error { return errReadOnly }
+
+// readOnlyBatch is an always empty batch
+type readOnlyBatch struct{}
+
+func (*readOnlyBatch) Put([]byte, []byte) error                    { return errReadOnly }
+func (*readOnlyBatch) Delete([]byte) error                         { return errReadOnly }
+func (*readOnlyBatch) Size() int                                   { return 0 }
+func (*readOnlyBatch) Write() error                                { return nil }
+func (*readOnlyBatch) Reset()                                      {}
+func (*readOnlyBatch) Replay(database.KeyValueWriterDeleter) error { return nil }
+func (b *readOnlyBatch) Inner() database.Batch                     { return b }
@@ -0,0 +1,61 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var _ RecipientKeys = &recipientKeys{}
+
+// RecipientKeys stores the public keys payloads are encrypted to, by the
+// address registering them
+type RecipientKeys interface {
+	// GetRecipientKey returns the key registered by [address].
+	// Returns database.ErrNotFound if [address] registered none.
+	GetRecipientKey(address ids.ShortID) (*RecipientKey, error)
+	// PutRecipientKey stores [key] as the key registered by [address]
+	PutRecipientKey(address ids.ShortID, key *RecipientKey) error
+}
+
+// RecipientKey is the public key an address registered last
+type RecipientKey struct {
+	// Nonce is the nonce of the next registration of the address
+	Nonce uint64 `serialize:"true" json:"nonce"`
+	// PublicKey is the secp256k1 public key payloads are encrypted to
+	PublicKey []byte `serialize:"true" json:"publicKey"`
+}
+
+// recipientKeys implements RecipientKeys with a database keyed by address
+type recipientKeys struct {
+	keyDB database.Database
+}
+
+// NewRecipientKeys returns RecipientKeys stored in the given db
+func NewRecipientKeys(db database.Database) RecipientKeys {
+	return &recipientKeys{keyDB: db}
+}
+
+// GetRecipientKey implements the RecipientKeys interface
+func (k *recipientKeys) GetRecipientKey(address ids.ShortID) (*RecipientKey, error) {
+	keyBytes, err := k.keyDB.Get(address[:])
+	if err != nil {
+		return nil, err
+	}
+	key := &RecipientKey{}
+	if _, err := Codec.Unmarshal(keyBytes, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// PutRecipientKey implements the RecipientKeys interface
+func (k *recipientKeys) PutRecipientKey(address ids.ShortID, key *RecipientKey) error {
+	keyBytes, err := Codec.Marshal(CodecVersion, key)
+	if err != nil {
+		return err
+	}
+	return k.keyDB.Put(address[:], keyBytes)
+}
@@ -0,0 +1,187 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+var (
+	errRedactionsDisabled       = errors.New("redactions are disabled on this chain")
+	errRedactionsWithoutSigning = errors.New("redactions require signed submissions")
+	errRedactionsWithValidators = errors.New("redactions and validator submissions can't be enabled together")
+	errRedactionData            = errors.New("block's data isn't the hash of its redaction")
+	errRedactionTarget          = errors.New("only accepted blocks after genesis can be redacted")
+	errAlreadyRedacted          = errors.New("block is already redacted")
+	errNotRedactor              = errors.New("redactions must be signed by the submitter of the block or a redaction admin")
+
+	_ ContextBlockVerifier = &redactionVerifier{}
+)
+
+// Redaction erases the data of an earlier accepted block, e.g. to honor a
+// request under the GDPR. It's anchored in a block of its own, whose data is
+// the hash of the redaction. Once it's accepted, the body of the redacted
+// block is deleted and its data is no longer served. Its header, including
+// the hash of its data, is retained, so the chain can still be verified.
+type Redaction struct {
+	// BlkID is the ID of the redacted block
+	BlkID ids.ID `serialize:"true" json:"blockID"`
+	// Height is the height of the redacted block
+	Height uint64 `serialize:"true" json:"height"`
+}
+
+// RedactionData returns the data of the block anchoring [redaction]
+func RedactionData(redaction *Redaction) ([dataLen]byte, error) {
+	redactionBytes, err := Codec.Marshal(CodecVersion, redaction)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(redactionBytes), nil
+}
+
+// RedactionMessage returns the message the redactor signs to propose
+// [redaction] to the chain [chainID]. It's the message signed to submit the
+// data of the block anchoring the redaction.
+func RedactionMessage(chainID ids.ID, redaction *Redaction) ([]byte, error) {
+	data, err := RedactionData(redaction)
+	if err != nil {
+		return nil, err
+	}
+	return SubmissionMessage(chainID, data)
+}
+
+// redactionAdmin returns true if [address] may redact any block
+func (vm *VM) redactionAdmin(address ids.ShortID) bool {
+	for _, admin := range vm.config.RedactionAdmins {
+		if admin == address {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyRedaction returns nil iff [redactor] may sign [redaction], given the
+// blocks redacted by processing ancestors in [pending]. Only the accepted
+// log and indexes are consulted, which are the same on all nodes whether
+// they prune bodies or not.
+func (vm *VM) verifyRedaction(ctx context.Context, pending ids.Set, redaction *Redaction, redactor ids.ShortID) error {
+	if redaction.Height == 0 {
+		return errRedactionTarget
+	}
+	acceptedID, err := vm.state.GetAcceptedID(redaction.Height)
+	if err == database.ErrNotFound || (err == nil && acceptedID != redaction.BlkID) {
+		return fmt.Errorf("%w: %s isn't accepted at height %d", errRedactionTarget, redaction.BlkID, redaction.Height)
+	}
+	if err != nil {
+		return err
+	}
+	if pending.Contains(redaction.BlkID) {
+		return errAlreadyRedacted
+	}
+	switch _, err := vm.state.GetRedaction(redaction.BlkID); err {
+	case nil:
+		return errAlreadyRedacted
+	case database.ErrNotFound:
+	default:
+		return err
+	}
+	if vm.redactionAdmin(redactor) {
+		return nil
+	}
+	submitted, err := vm.state.GetSubmitterBlockIDs(ctx, redactor, redaction.Height, 1)
+	if err != nil {
+		return err
+	}
+	if len(submitted) == 0 || submitted[0] != redaction.BlkID {
+		return errNotRedactor
+	}
+	return nil
+}
+
+// applyRedaction records the redaction anchored in the accepted [blk] and
+// deletes the body of the redacted block, keeping its header. Archived
+// bodies are no longer read back, but must be purged from the archive store
+// by its operator.
+func (vm *VM) applyRedaction(blk *Block) error {
+	blkID := blk.Redaction().BlkID
+	if err := vm.state.PutRedaction(blkID, blk.ID()); err != nil {
+		return err
+	}
+	if vm.archiver != nil {
+		vm.archiver.cache.Evict(blkID)
+	}
+	switch _, err := vm.state.GetBlockBody(blkID); err {
+	case nil:
+		return vm.state.DeleteBlockBody(blkID)
+	case database.ErrNotFound:
+		return nil // already pruned or archived
+	default:
+		return err
+	}
+}
+
+// redactionVerifier requires redactions to be signed by the submitter of the
+// redacted block or by an admin, and each block to be redacted once
+type redactionVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *redactionVerifier) VerifyBlock(blk *Block) error {
+	return v.VerifyBlockContext(v.vm.runCtx, blk)
+}
+
+// VerifyBlockContext implements the ContextBlockVerifier interface
+func (v *redactionVerifier) VerifyBlockContext(ctx context.Context, blk *Block) error {
+	redaction := blk.Redaction()
+	if redaction == nil {
+		return nil
+	}
+	if !blk.IsSigned() {
+		return errNotRedactor
+	}
+	data, err := RedactionData(redaction)
+	if err != nil {
+		return err
+	}
+	if blk.Data() != data {
+		return errRedactionData
+	}
+	pending, err := v.pendingRedactions(blk.Parent())
+	if err != nil {
+		return err
+	}
+	redactor, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	return v.vm.verifyRedaction(ctx, pending, redaction, redactor)
+}
+
+// pendingRedactions returns the blocks redacted by [blkID] and its processing
+// ancestors
+func (v *redactionVerifier) pendingRedactions(blkID ids.ID) (ids.Set, error) {
+	pending := ids.Set{}
+	for {
+		blk, err := v.vm.getBlock(blkID)
+		if err != nil {
+			return nil, errDatabaseGet
+		}
+		// Accepted redactions are recorded in the state
+		if blk.Status() == choices.Accepted {
+			return pending, nil
+		}
+		if redaction := blk.Redaction(); redaction != nil {
+			pending.Add(redaction.BlkID)
+		}
+		blkID = blk.Parent()
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var _ Redactions = &redactions{}
+
+// Redactions records the accepted blocks whose data was redacted
+type Redactions interface {
+	// GetRedaction returns the ID of the block redacting [blkID].
+	// Returns database.ErrNotFound if [blkID] isn't redacted.
+	GetRedaction(blkID ids.ID) (ids.ID, error)
+	// PutRedaction records that [redactionID] redacts [blkID]
+	PutRedaction(blkID ids.ID, redactionID ids.ID) error
+}
+
+// redactions implements Redactions with a database keyed by the ID of the
+// redacted block
+type redactions struct {
+	redactionDB database.Database
+}
+
+// NewRedactions returns Redactions stored in the given db
+func NewRedactions(db database.Database) Redactions {
+	return &redactions{redactionDB: db}
+}
+
+// GetRedaction implements the Redactions interface
+func (r *redactions) GetRedaction(blkID ids.ID) (ids.ID, error) {
+	return database.GetID(r.redactionDB, blkID[:])
+}
+
+// PutRedaction implements the Redactions interface
+func (r *redactions) PutRedaction(blkID ids.ID, redactionID ids.ID) error {
+	return database.PutID(r.redactionDB, blkID[:], redactionID)
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+// name the progress of rebuilding the indexes is persisted under
+const reindexJobName = "reindex"
+
+// Phases of rebuilding the indexes
+const (
+	// The accepted log and child links are rebuilt walking the parent links
+	// from the last accepted block down to genesis
+	reindexAcceptedLog byte = iota
+	// The data, namespace, tag, chain head and submitter indexes are rebuilt
+	// walking the accepted log from genesis up
+	reindexLookups
+)
+
+// reindexProgress is the persisted progress of rebuilding the indexes
+type reindexProgress struct {
+	Phase byte `serialize:"true"`
+	// NextID is the next block to add to the accepted log
+	NextID ids.ID `serialize:"true"`
+	// NextHeight is the height of the next block to index
+	NextHeight uint64 `serialize:"true"`
+	// TipHeight is the height of the last accepted block when the rebuild
+	// started. Blocks accepted since are indexed when they are accepted.
+	TipHeight uint64 `serialize:"true"`
+}
+
+// processed returns the number of indexed blocks, counting each block once
+// per phase
+func (p *reindexProgress) processed() uint64 {
+	if p.Phase == reindexAcceptedLog {
+		return p.TipHeight - p.NextHeight
+	}
+	return p.TipHeight + 1 + p.NextHeight
+}
+
+// newReindexRun returns the step function rebuilding the accepted log, the
+// child links and the data, namespace, tag, chain head and submitter indexes
+// from the stored blocks. A run interrupted by a shutdown resumes where it
+// left off.
+func (vm *VM) newReindexRun() batchStep {
+	var progress *reindexProgress
+	return func(uint64) (uint64, uint64, bool, error) {
+		resumed := uint64(0)
+		if progress == nil {
+			loaded, err := vm.loadReindexProgress()
+			if err != nil {
+				return 0, 0, false, err
+			}
+			progress = loaded
+			resumed = progress.processed()
+		}
+
+		var (
+			processed uint64
+			err       error
+		)
+		if progress.Phase == reindexAcceptedLog {
+			processed, err = vm.reindexAcceptedLog(progress)
+		} else {
+			processed, err = vm.reindexLookups(progress)
+		}
+		if err != nil {
+			return 0, 0, false, err
+		}
+
+		done := progress.Phase == reindexLookups && progress.NextHeight > progress.TipHeight
+		if done {
+			err = vm.state.DeleteJobProgress(reindexJobName)
+		} else {
+			err = vm.saveReindexProgress(progress)
+		}
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return resumed + processed, 2 * (progress.TipHeight + 1), done, vm.state.Commit()
+	}
+}
+
+// loadReindexProgress returns the progress of the interrupted rebuild, or the
+// start of a new one
+func (vm *VM) loadReindexProgress() (*reindexProgress, error) {
+	progressBytes, err := vm.state.GetJobProgress(reindexJobName)
+	if err == nil {
+		progress := &reindexProgress{}
+		_, err := Codec.Unmarshal(progressBytes, progress)
+		return progress, err
+	}
+	if err != database.ErrNotFound {
+		return nil, err
+	}
+
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &reindexProgress{
+		Phase:      reindexAcceptedLog,
+		NextID:     lastAccepted.ID(),
+		NextHeight: lastAccepted.Height(),
+		TipHeight:  lastAccepted.Height(),
+	}, nil
+}
+
+// saveReindexProgress persists [progress]
+func (vm *VM) saveReindexProgress(progress *reindexProgress) error {
+	progressBytes, err := Codec.Marshal(CodecVersion, progress)
+	if err != nil {
+		return err
+	}
+	return vm.state.SetJobProgress(reindexJobName, progressBytes)
+}
+
+// reindexAcceptedLog adds up to [jobBatchSize] blocks to the accepted log and
+// links them to their parents, following the parent links down from
+// [progress.NextID]. Returns the number of blocks added.
+func (vm *VM) reindexAcceptedLog(progress *reindexProgress) (uint64, error) {
+	prunedHeight, err := vm.state.GetPrunedHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	processed := uint64(0)
+	for ; processed < jobBatchSize; processed++ {
+		header, err := vm.state.GetBlockHeader(progress.NextID)
+		if err == database.ErrNotFound && progress.NextHeight < prunedHeight {
+			// The blocks below were pruned including their headers, so the
+			// log can't be rebuilt any further
+			loggedID, err := vm.state.GetAcceptedID(progress.NextHeight)
+			if err != nil || loggedID != progress.NextID {
+				return 0, fmt.Errorf("%w: pruned block %s isn't in the accepted log at height %d", errInconsistentState, progress.NextID, progress.NextHeight)
+			}
+			vm.ctx.Log.Info("keeping the accepted log of pruned blocks below height %d", progress.NextHeight+1)
+			progress.Phase = reindexLookups
+			progress.NextHeight = 0
+			return processed, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: couldn't load accepted block %s: %v", errInconsistentState, progress.NextID, err)
+		}
+		if header.Hght != progress.NextHeight {
+			return 0, fmt.Errorf("%w: block %s has height %d instead of %d", errInconsistentState, progress.NextID, header.Hght, progress.NextHeight)
+		}
+
+		if err := vm.state.RepairAccepted(header.Hght, progress.NextID); err != nil {
+			return 0, err
+		}
+		if header.Hght == 0 {
+			progress.Phase = reindexLookups
+			progress.NextHeight = 0
+			return processed + 1, nil
+		}
+		if err := vm.state.PutAcceptedChild(header.PrntID, progress.NextID); err != nil {
+			return 0, err
+		}
+		progress.NextID = header.PrntID
+		progress.NextHeight--
+	}
+	return processed, nil
+}
+
+// reindexLookups adds up to [jobBatchSize] accepted blocks, starting at
+// [progress.NextHeight], to the data, namespace, tag, chain head and
+// submitter indexes. Returns the number of blocks indexed.
+func (vm *VM) reindexLookups(progress *reindexProgress) (uint64, error) {
+	limit := progress.TipHeight + 1 - progress.NextHeight
+	if limit > jobBatchSize {
+		limit = jobBatchSize
+	}
+	blkIDs, err := vm.state.GetAcceptedIDs(vm.runCtx, progress.NextHeight, int(limit))
+	if err != nil {
+		return 0, err
+	}
+	if uint64(len(blkIDs)) < limit {
+		return 0, fmt.Errorf("%w: accepted log ends before height %d", errInconsistentState, progress.TipHeight)
+	}
+
+	for i, blkID := range blkIDs {
+		height := progress.NextHeight + uint64(i)
+		header, err := vm.state.GetBlockHeader(blkID)
+		if err == database.ErrNotFound {
+			continue // pruned including its header
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := vm.reindexData(height, blkID, header.DataHash); err != nil {
+			return 0, err
+		}
+
+		blk, err := vm.getBlock(blkID)
+		if err == database.ErrNotFound {
+			continue // the submitter can't be recovered without the body
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := vm.indexNamespace(blk); err != nil {
+			return 0, err
+		}
+		if err := vm.indexChainHead(blk); err != nil {
+			return 0, err
+		}
+		if !blk.IsSigned() {
+			continue
+		}
+		submitter, err := blk.Submitter()
+		if err != nil {
+			return 0, err
+		}
+		if err := vm.state.IndexSubmitter(submitter, height, blkID); err != nil {
+			return 0, err
+		}
+	}
+	progress.NextHeight += limit
+	return limit, nil
+}
+
+// reindexData points the data index entry of [dataHash] to the accepted
+// block [blkID] at [height], unless it references an earlier accepted block.
+// The accepted log must be rebuilt up to [height].
+func (vm *VM) reindexData(height uint64, blkID ids.ID, dataHash ids.ID) error {
+	entry, err := vm.state.GetDataEntry(dataHash)
+	if err != nil && err != database.ErrNotFound {
+		return err
+	}
+	if err == nil && entry.Height <= height {
+		acceptedID, err := vm.state.GetAcceptedID(entry.Height)
+		if err != nil && err != database.ErrNotFound {
+			return err
+		}
+		if err == nil && acceptedID == entry.BlkID {
+			return nil
+		}
+	}
+	return vm.state.PutDataEntry(dataHash, &DataEntry{
+		BlkID:  blkID,
+		Height: height,
+	})
+}
@@ -0,0 +1,217 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// seconds clients are asked to wait before retrying a request refused as the
+// VM is busy
+const busyRetryAfter = 1
+
+var (
+	errMaxRequestBodySize    = errors.New("maxRequestBodySize must be at least 1")
+	errMaxConcurrentRequests = errors.New("maxConcurrentRequests must not be negative")
+	errReadTimeout           = errors.New("timed out reading request body")
+)
+
+// limitConcurrency returns [handler] refusing requests while
+// [vm.config.MaxConcurrentRequests] requests to any of the VM's APIs are
+// being served, so clients can't tie up an unbounded number of them.
+// [slots] is shared by all handlers, nil doesn't limit.
+func limitConcurrency(slots chan struct{}, handler http.Handler) http.Handler {
+	if slots == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-slots }()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// callContext returns a context derived from [parent] for serving an RPC
+// call, which is canceled once [vm.config.RPCTimeout] elapsed or the VM
+// shuts down, and the function releasing it once the call was served.
+// Handlers pass it on to the state reads and the blocks they build.
+func (vm *VM) callContext(parent context.Context) (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout := vm.config.RPCTimeout.Duration; timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	go func() {
+		select {
+		case <-vm.runCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// requestContext returns the context of [r], which is nil when the API is
+// called in process
+func requestContext(r *http.Request) context.Context {
+	if r == nil {
+		return context.Background()
+	}
+	return r.Context()
+}
+
+// bufferedResponse holds a response until it's written to the client
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header implements the http.ResponseWriter interface
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+// Write implements the http.ResponseWriter interface
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// WriteHeader implements the http.ResponseWriter interface
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// timeoutBody is a request body whose reads fail with errReadTimeout unless
+// they complete within [timeout], for bodies streamed while they're read,
+// like uploads, which may take longer than [vm.config.RequestReadTimeout] as
+// a whole. Each read is done by a goroutine into a buffer of its own, so a
+// read which timed out, and only ends once the client sends or the
+// connection closes, doesn't write into the caller's buffer later.
+type timeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	buf     []byte
+	reads   chan timedRead
+	// true once a read timed out, the body can't be read anymore
+	timedOut bool
+}
+
+// timedRead is the result of a read of a timeoutBody
+type timedRead struct {
+	n   int
+	err error
+}
+
+// newTimeoutBody returns [body] whose reads time out after [timeout]
+func newTimeoutBody(body io.ReadCloser, timeout time.Duration) *timeoutBody {
+	return &timeoutBody{
+		body:    body,
+		timeout: timeout,
+		reads:   make(chan timedRead, 1),
+	}
+}
+
+// Read implements the io.Reader interface
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	if b.timedOut {
+		return 0, errReadTimeout
+	}
+	if len(b.buf) < len(p) {
+		b.buf = make([]byte, len(p))
+	}
+	buf := b.buf[:len(p)]
+	go func() {
+		n, err := b.body.Read(buf)
+		b.reads <- timedRead{n: n, err: err}
+	}()
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+
+	select {
+	case read := <-b.reads:
+		copy(p, buf[:read.n])
+		return read.n, read.err
+	case <-timer.C:
+		b.timedOut = true
+		return 0, errReadTimeout
+	}
+}
+
+// Close implements the io.Closer interface
+func (b *timeoutBody) Close() error {
+	return b.body.Close()
+}
+
+// serveLocked returns [handler] serving requests holding the context lock,
+// which must therefore be registered without a lock. The body is read before
+// taking the lock, bounded by [vm.config.MaxRequestBodySize] and
+// [vm.config.RequestReadTimeout], and the response is written after
+// releasing it, so slow clients don't hold up the chain.
+func (vm *VM) serveLocked(handler http.Handler) http.Handler {
+	maxSize := vm.config.MaxRequestBodySize
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxSize {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		type readResult struct {
+			body []byte
+			err  error
+		}
+		read := make(chan readResult, 1)
+		go func() {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+			read <- readResult{body: body, err: err}
+		}()
+		timer := time.NewTimer(vm.config.RequestReadTimeout.Duration)
+		defer timer.Stop()
+
+		var result readResult
+		select {
+		case result = <-read:
+		case <-timer.C:
+			// The read ends once the client sends or the connection closes
+			http.Error(w, "timed out reading request body", http.StatusRequestTimeout)
+			return
+		}
+		switch {
+		case result.err != nil:
+			http.Error(w, "couldn't read request body", http.StatusBadRequest)
+			return
+		case int64(len(result.body)) > maxSize:
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(result.body))
+
+		response := &bufferedResponse{header: make(http.Header)}
+		vm.ctx.Lock.Lock()
+		handler.ServeHTTP(response, r)
+		vm.ctx.Lock.Unlock()
+
+		for key, values := range response.header {
+			w.Header()[key] = values
+		}
+		if response.status != 0 {
+			w.WriteHeader(response.status)
+		}
+		_, _ = w.Write(response.body.Bytes())
+	})
+}
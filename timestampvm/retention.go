@@ -0,0 +1,180 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+// retentionJobPrefix prefixes the job names under which the height up to
+// which the bodies of a namespace were dropped is persisted
+const retentionJobPrefix = "retention:"
+
+var (
+	errRetentionDisabled    = errors.New("no namespace has a retention period")
+	errNonPositiveRetention = errors.New("retention periods must be positive")
+)
+
+// verifyRetentionConfig returns nil iff [retention] lists valid namespaces
+// with positive periods
+func verifyRetentionConfig(retention map[string]Duration) error {
+	for namespace, period := range retention {
+		if err := verifyNamespaceConfig(namespace); err != nil {
+			return err
+		}
+		if period.Duration <= 0 {
+			return fmt.Errorf("%w: %q", errNonPositiveRetention, namespace)
+		}
+	}
+	return nil
+}
+
+// newRetentionRun returns the step function of a run dropping the bodies of
+// the blocks older than the retention period of their namespace, see
+// [Config.NamespaceRetention]. It processes the namespaces one after the
+// other, each from where the last run left off.
+func (vm *VM) newRetentionRun() batchStep {
+	namespaces := make([]string, 0, len(vm.config.NamespaceRetention))
+	for namespace := range vm.config.NamespaceRetention {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	now := time.Now()
+
+	next := 0
+	return func(uint64) (uint64, uint64, bool, error) {
+		total := uint64(len(namespaces))
+		lastAccepted, err := vm.lastAcceptedBlock()
+		if err != nil {
+			return 0, total, false, err
+		}
+		processed := uint64(0)
+		for budget := jobBatchSize; next < len(namespaces) && budget > 0; {
+			namespace := namespaces[next]
+			cutoff := now.Add(-vm.config.NamespaceRetention[namespace].Duration)
+			visited, done, err := vm.dropExpiredBodies(namespace, cutoff, lastAccepted.Height(), budget)
+			if err != nil {
+				return processed, total, false, err
+			}
+			budget -= visited
+			if done {
+				processed++
+				next++
+			}
+		}
+		if err := vm.state.Commit(); err != nil {
+			return processed, total, false, err
+		}
+		return processed, total, next == len(namespaces), nil
+	}
+}
+
+// dropExpiredBodies drops the bodies of up to [limit] blocks of [namespace]
+// whose timestamp isn't after [cutoff], keeping their headers. Blocks at
+// [tipHeight] or above are retained. Returns the number of blocks visited
+// and whether all expired blocks of [namespace] are dropped.
+func (vm *VM) dropExpiredBodies(namespace string, cutoff time.Time, tipHeight uint64, limit int) (int, bool, error) {
+	height, err := vm.retentionHeight(namespace)
+	if err != nil {
+		return 0, false, err
+	}
+	// Pruned blocks may have lost their headers too
+	prunedHeight, err := vm.state.GetPrunedHeight()
+	if err != nil {
+		return 0, false, err
+	}
+	if height < prunedHeight {
+		height = prunedHeight
+	}
+
+	blkIDs, err := vm.state.GetNamespaceBlockIDs(vm.runCtx, namespace, height, limit)
+	if err != nil {
+		return 0, false, err
+	}
+	done := len(blkIDs) < limit
+	visited := 0
+	for _, blkID := range blkIDs {
+		header, err := vm.state.GetBlockHeader(blkID)
+		if err != nil {
+			return visited, false, err
+		}
+		// Timestamps never decrease, so all later blocks are retained
+		if header.Hght >= tipHeight || time.Unix(header.Tmstmp, 0).After(cutoff) {
+			done = true
+			break
+		}
+		switch _, err := vm.state.GetBlockBody(blkID); err {
+		case nil:
+			if err := vm.state.DeleteBlockBody(blkID); err != nil {
+				return visited, false, err
+			}
+		case database.ErrNotFound:
+			// already pruned, archived or redacted
+		default:
+			return visited, false, err
+		}
+		height = header.Hght + 1
+		visited++
+	}
+	return visited, done, vm.state.SetJobProgress(retentionJobPrefix+namespace, database.PackUInt64(height))
+}
+
+// retentionHeight returns the height up to which the bodies of the blocks of
+// [namespace] were dropped, exclusive
+func (vm *VM) retentionHeight(namespace string) (uint64, error) {
+	progress, err := vm.state.GetJobProgress(retentionJobPrefix + namespace)
+	if err == database.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return database.ParseUInt64(progress)
+}
+
+// droppedByRetention returns true if the body of the accepted block [blkID]
+// at [height] was dropped by the retention policy of its namespace
+func (vm *VM) droppedByRetention(blkID ids.ID, height uint64) (bool, error) {
+	for namespace := range vm.config.NamespaceRetention {
+		retentionHeight, err := vm.retentionHeight(namespace)
+		if err != nil {
+			return false, err
+		}
+		if height >= retentionHeight {
+			continue
+		}
+		blkIDs, err := vm.state.GetNamespaceBlockIDs(vm.runCtx, namespace, height, 1)
+		if err != nil {
+			return false, err
+		}
+		if len(blkIDs) > 0 && blkIDs[0] == blkID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// runRetentionPeriodically applies [Config.NamespaceRetention] every
+// [Config.RetentionInterval] until the VM shuts down
+func (vm *VM) runRetentionPeriodically() {
+	ticker := time.NewTicker(vm.config.RetentionInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := vm.retention.Trigger(); err != nil {
+				vm.ctx.Log.Debug("skipping scheduled retention: %s", err)
+			}
+		case <-vm.shutdownChan:
+			return
+		}
+	}
+}
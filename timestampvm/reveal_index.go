@@ -0,0 +1,44 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var _ RevealIndex = &revealIndex{}
+
+// RevealIndex links anchored commitments to the accepted blocks revealing
+// them
+type RevealIndex interface {
+	// GetReveal returns the ID of the block revealing the commitment whose
+	// data hashes to [commitmentHash].
+	// Returns database.ErrNotFound if it isn't revealed.
+	GetReveal(commitmentHash ids.ID) (ids.ID, error)
+	// IndexReveal records that [revealID] reveals the commitment whose data
+	// hashes to [commitmentHash]
+	IndexReveal(commitmentHash ids.ID, revealID ids.ID) error
+}
+
+// revealIndex implements RevealIndex with a database keyed by the hash of the
+// commitment, as in the data index
+type revealIndex struct {
+	indexDB database.Database
+}
+
+// NewRevealIndex returns RevealIndex stored in the given db
+func NewRevealIndex(db database.Database) RevealIndex {
+	return &revealIndex{indexDB: db}
+}
+
+// GetReveal implements the RevealIndex interface
+func (i *revealIndex) GetReveal(commitmentHash ids.ID) (ids.ID, error) {
+	return database.GetID(i.indexDB, commitmentHash[:])
+}
+
+// IndexReveal implements the RevealIndex interface
+func (i *revealIndex) IndexReveal(commitmentHash ids.ID, revealID ids.ID) error {
+	return database.PutID(i.indexDB, commitmentHash[:], revealID)
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/rpc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+// rpcCallKey is the request context key of the rpcCall being served
+type rpcCallKey struct{}
+
+// rpcCall is an RPC call being served
+type rpcCall struct {
+	start time.Time
+	span  Span
+	// releases the context the call is served with
+	cancel context.CancelFunc
+	// arguments of the call, only set if it's audited
+	args interface{}
+}
+
+// rpcMetrics are the metrics of the calls served by the VM's APIs, per method
+type rpcMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	limited  *prometheus.CounterVec
+}
+
+// newRPCMetrics returns the metrics of RPC calls, registered with
+// [registerer]
+func newRPCMetrics(registerer prometheus.Registerer) (*rpcMetrics, error) {
+	m := &rpcMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpc_requests",
+			Help: "# of RPC calls served, by method and status code (200, or 400 if the call failed)",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpc_duration_seconds",
+			Help:    "time (in seconds) serving an RPC call took, by method",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"method"}),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpc_rate_limited",
+			Help: "# of RPC calls refused by the rate limit, by bucket (read or propose)",
+		}, []string{"bucket"}),
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(m.requests),
+		registerer.Register(m.duration),
+		registerer.Register(m.limited),
+	)
+	return m, errs.Err
+}
+
+// instrumentRPC records the metrics of, and traces, every call to a method
+// of [server]. Requests which don't name a registered method aren't
+// recorded, so clients can't create arbitrary labels.
+// The request passed to the method holds the span of the call, and its
+// context is canceled once the call timed out or the VM shuts down.
+// Calls of methods for which [audited] returns true are recorded in the
+// audit log. Calls by callers lacking the role the method requires, and
+// calls beyond the rate limit or the caller's quota, are refused.
+func (vm *VM) instrumentRPC(server *rpc.Server, audited func(method string) bool) {
+	server.RegisterInterceptFunc(func(i *rpc.RequestInfo) *http.Request {
+		ctx, cancel := vm.callContext(i.Request.Context())
+		ctx, span := vm.tracer.Start(ctx, i.Method)
+		ctx = context.WithValue(ctx, rpcCallKey{}, &rpcCall{
+			start:  time.Now(),
+			span:   span,
+			cancel: cancel,
+		})
+		return i.Request.WithContext(ctx)
+	})
+	server.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		if call, ok := i.Request.Context().Value(rpcCallKey{}).(*rpcCall); ok && audited(i.Method) {
+			call.args = args
+		}
+		if err := authorize(i.Request, i.Method); err != nil {
+			return err
+		}
+		bucket, err := vm.rateLimiter.Allow(i.Method)
+		if err != nil {
+			vm.rpcMetrics.limited.WithLabelValues(bucket).Inc()
+			return err
+		}
+		if caller := requestCaller(i.Request); caller != nil {
+			return vm.usage.Charge(caller, i.Method, time.Now())
+		}
+		return nil
+	})
+	server.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		call, ok := i.Request.Context().Value(rpcCallKey{}).(*rpcCall)
+		if !ok {
+			return
+		}
+		call.cancel()
+		endSpan(call.span, i.Error)
+		if audited(i.Method) {
+			vm.audit(i.Request, i.Method, call.args, i.Error)
+		}
+		vm.rpcMetrics.duration.WithLabelValues(i.Method).Observe(time.Since(call.start).Seconds())
+		vm.rpcMetrics.requests.WithLabelValues(i.Method, strconv.Itoa(i.StatusCode)).Inc()
+	})
+}
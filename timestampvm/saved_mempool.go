@@ -0,0 +1,70 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+)
+
+var _ SavedMempool = &savedMempool{}
+
+// SavedMempool holds the submissions which were pending when the VM shut
+// down, so they can be proposed again when it restarts
+type SavedMempool interface {
+	// SaveSubmission stores [submission] as the [index]th pending submission
+	SaveSubmission(index uint64, submission []byte) error
+	// GetSavedSubmissions returns the stored submissions in the order of
+	// their indexes
+	GetSavedSubmissions() ([][]byte, error)
+	// DeleteSavedSubmissions removes all stored submissions
+	DeleteSavedSubmissions() error
+}
+
+// savedMempool implements SavedMempool with a database keyed by index
+type savedMempool struct {
+	mempoolDB database.Database
+}
+
+// NewSavedMempool returns SavedMempool stored in the given db
+func NewSavedMempool(db database.Database) SavedMempool {
+	return &savedMempool{mempoolDB: db}
+}
+
+// SaveSubmission implements the SavedMempool interface
+func (m *savedMempool) SaveSubmission(index uint64, submission []byte) error {
+	return m.mempoolDB.Put(database.PackUInt64(index), submission)
+}
+
+// GetSavedSubmissions implements the SavedMempool interface
+func (m *savedMempool) GetSavedSubmissions() ([][]byte, error) {
+	it := m.mempoolDB.NewIterator()
+	defer it.Release()
+
+	submissions := [][]byte(nil)
+	for it.Next() {
+		// the iterator's values are only valid until the next call to Next
+		submissions = append(submissions, append([]byte(nil), it.Value()...))
+	}
+	return submissions, it.Error()
+}
+
+// DeleteSavedSubmissions implements the SavedMempool interface
+func (m *savedMempool) DeleteSavedSubmissions() error {
+	it := m.mempoolDB.NewIterator()
+	defer it.Release()
+
+	keys := [][]byte(nil)
+	for it.Next() {
+		keys = append(keys, append([]byte(nil), it.Key()...))
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := m.mempoolDB.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+// schemaTagKey is the key of the tag declaring the schema the data of a block
+// conforms to
+const schemaTagKey = "schema"
+
+// Types of the fields of a schema. Integers are encoded big-endian.
+const (
+	Uint8Field  = "uint8"
+	Uint16Field = "uint16"
+	Uint32Field = "uint32"
+	Uint64Field = "uint64"
+	// BytesField is an opaque field of [SchemaField.Size] bytes
+	BytesField = "bytes"
+)
+
+var (
+	errSchemasDisabled       = errors.New("payload schemas are disabled on this chain")
+	errSchemasWithoutSigning = errors.New("payload schemas require signed submissions")
+	errSchemasWithValidators = errors.New("payload schemas and validator submissions can't be enabled together")
+	errNotSchemaAdmin        = errors.New("schema updates must be signed by a schema admin")
+	errSchemaUpdateData      = errors.New("block's data isn't the hash of its schema update")
+	errSchemaUpdateNonce     = errors.New("schema update has the wrong nonce")
+	errBadSchemaName         = errors.New("schema names must be 1 to 64 letters, digits, '.', '_' or '-'")
+	errNoSchemaFields        = errors.New("schemas must have at least one field")
+	errBadSchemaField        = errors.New("schema fields must have a unique name and a known type")
+	errSchemaTooLarge        = fmt.Errorf("schema fields must fit in %d bytes", dataLen)
+	errBadFieldRange         = errors.New("field's minimum is above its maximum")
+	errUnknownSchema         = errors.New("schema isn't registered")
+	errNonConformingData     = errors.New("data doesn't conform to its schema")
+
+	_ BlockVerifier = &schemaVerifier{}
+
+	// sizes of the integer field types
+	fieldSizes = map[string]uint32{
+		Uint8Field:  1,
+		Uint16Field: 2,
+		Uint32Field: 4,
+		Uint64Field: 8,
+	}
+)
+
+// SchemaField is a field of a payload schema, laid out right after the
+// field before it
+type SchemaField struct {
+	Name string `serialize:"true" json:"name"`
+	Type string `serialize:"true" json:"type"`
+	// Size is the length of bytes fields, integers have the size of their
+	// type
+	Size uint32 `serialize:"true" json:"size"`
+	// Min and Max bound integer fields. A Max of 0 leaves them unbounded
+	// above.
+	Min uint64 `serialize:"true" json:"min"`
+	Max uint64 `serialize:"true" json:"max"`
+}
+
+// size returns the number of bytes [f] takes
+func (f *SchemaField) size() uint32 {
+	if f.Type == BytesField {
+		return f.Size
+	}
+	return fieldSizes[f.Type]
+}
+
+// PayloadSchema is a named layout of the data of blocks, so anchors can be
+// structured records rather than opaque hashes. The bytes after the last
+// field must be zero.
+type PayloadSchema struct {
+	Name   string        `serialize:"true" json:"name"`
+	Fields []SchemaField `serialize:"true" json:"fields"`
+}
+
+// Verify returns nil iff [s] is a well formed schema fitting the data of a
+// block
+func (s *PayloadSchema) Verify() error {
+	if s.Name == "" || verifyNamespace(s.Name) != nil {
+		return fmt.Errorf("%w: %q", errBadSchemaName, s.Name)
+	}
+	if len(s.Fields) == 0 {
+		return errNoSchemaFields
+	}
+	names := make(map[string]bool, len(s.Fields))
+	size := uint64(0)
+	for _, field := range s.Fields {
+		_, isInt := fieldSizes[field.Type]
+		switch {
+		case field.Name == "" || names[field.Name] || verifyNamespace(field.Name) != nil,
+			!isInt && (field.Type != BytesField || field.Size == 0):
+			return fmt.Errorf("%w: %q", errBadSchemaField, field.Name)
+		case isInt && field.Max > 0 && field.Min > field.Max:
+			return fmt.Errorf("%w: %q", errBadFieldRange, field.Name)
+		}
+		names[field.Name] = true
+		size += uint64(field.size())
+	}
+	if size > dataLen {
+		return errSchemaTooLarge
+	}
+	return nil
+}
+
+// Conforms returns nil iff [data] is laid out as [s] describes
+func (s *PayloadSchema) Conforms(data [dataLen]byte) error {
+	offset := uint32(0)
+	for _, field := range s.Fields {
+		size := field.size()
+		value := data[offset : offset+size]
+		offset += size
+		if field.Type == BytesField {
+			continue
+		}
+		padded := make([]byte, 8)
+		copy(padded[8-size:], value)
+		n := binary.BigEndian.Uint64(padded)
+		if n < field.Min || (field.Max > 0 && n > field.Max) {
+			return fmt.Errorf("%w: field %q of schema %q is out of range", errNonConformingData, field.Name, s.Name)
+		}
+	}
+	for _, b := range data[offset:] {
+		if b != 0 {
+			return fmt.Errorf("%w: bytes after the fields of schema %q must be zero", errNonConformingData, s.Name)
+		}
+	}
+	return nil
+}
+
+// SchemaUpdate registers a payload schema, or replaces the schema of the
+// same name. It's anchored in a block of its own, whose data is the hash of
+// the update and whose signature must be by a schema admin.
+type SchemaUpdate struct {
+	// Nonce is the number of updates accepted before this one, so an update
+	// can't be replayed
+	Nonce  uint64        `serialize:"true" json:"nonce"`
+	Schema PayloadSchema `serialize:"true" json:"schema"`
+}
+
+// SchemaUpdateData returns the data of the block anchoring [update]
+func SchemaUpdateData(update *SchemaUpdate) ([dataLen]byte, error) {
+	updateBytes, err := Codec.Marshal(CodecVersion, update)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(updateBytes), nil
+}
+
+// SchemaUpdateMessage returns the message a schema admin signs to propose
+// [update] to the chain [chainID]. It's the message signed to submit the data
+// of the block anchoring the update.
+func SchemaUpdateMessage(chainID ids.ID, update *SchemaUpdate) ([]byte, error) {
+	data, err := SchemaUpdateData(update)
+	if err != nil {
+		return nil, err
+	}
+	return SubmissionMessage(chainID, data)
+}
+
+// declaredSchema returns the name of the schema [tags] declare, if any
+func declaredSchema(tags []Tag) (string, bool) {
+	for _, tag := range tags {
+		if tag.Key == schemaTagKey {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// schemaAdmin returns true if [address] may sign schema updates
+func (vm *VM) schemaAdmin(address ids.ShortID) bool {
+	for _, admin := range vm.config.SchemaAdmins {
+		if admin == address {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaVerifier requires schema updates to be signed by an admin, and the
+// data of blocks declaring a schema to conform to it. The schemas in effect
+// for a block are the accepted ones, changed by the updates of its processing
+// ancestors.
+type schemaVerifier struct {
+	vm *VM
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *schemaVerifier) VerifyBlock(blk *Block) error {
+	update := blk.SchemaUpdate()
+	name, declared := declaredSchema(blk.Tags())
+	if update == nil && !declared {
+		return nil
+	}
+	pending, err := v.pendingUpdates(blk.Parent())
+	if err != nil {
+		return err
+	}
+
+	if update == nil {
+		schema, err := v.vm.lookupSchema(pending, name)
+		if err != nil {
+			return err
+		}
+		return schema.Conforms(blk.Data())
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	if !blk.IsSigned() || !v.vm.schemaAdmin(submitter) {
+		return errNotSchemaAdmin
+	}
+	data, err := SchemaUpdateData(update)
+	if err != nil {
+		return err
+	}
+	if blk.Data() != data {
+		return errSchemaUpdateData
+	}
+	nonce, err := v.vm.state.GetSchemaNonce()
+	if err != nil {
+		return err
+	}
+	if expected := nonce + uint64(len(pending)); update.Nonce != expected {
+		return fmt.Errorf("%w: expected %d, but found %d", errSchemaUpdateNonce, expected, update.Nonce)
+	}
+	return update.Schema.Verify()
+}
+
+// pendingUpdates returns the schema updates of [blkID] and its processing
+// ancestors, the most recent first
+func (v *schemaVerifier) pendingUpdates(blkID ids.ID) ([]*SchemaUpdate, error) {
+	updates := []*SchemaUpdate(nil)
+	for {
+		blk, err := v.vm.getBlock(blkID)
+		if err != nil {
+			return nil, errDatabaseGet
+		}
+		// Accepted updates are applied to the state
+		if blk.Status() == choices.Accepted {
+			return updates, nil
+		}
+		if update := blk.SchemaUpdate(); update != nil {
+			updates = append(updates, update)
+		}
+		blkID = blk.Parent()
+	}
+}
+
+// lookupSchema returns the schema named [name] as registered by the accepted
+// updates and [pending], the most recent first
+func (vm *VM) lookupSchema(pending []*SchemaUpdate, name string) (*PayloadSchema, error) {
+	for _, update := range pending {
+		if update.Schema.Name == name {
+			return &update.Schema, nil
+		}
+	}
+	schema, err := vm.state.GetSchema(name)
+	if err == database.ErrNotFound {
+		return nil, fmt.Errorf("%w: %q", errUnknownSchema, name)
+	}
+	return schema, err
+}
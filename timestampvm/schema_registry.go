@@ -0,0 +1,95 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+)
+
+var (
+	_ SchemaRegistry = &schemaRegistry{}
+
+	// persists the number of accepted updates with this key. Schema names
+	// don't contain zero bytes, so it can't collide with them.
+	schemaNonceKey = []byte{0}
+)
+
+// SchemaRegistry holds the payload schemas as of the last accepted block
+type SchemaRegistry interface {
+	// GetSchema returns the schema named [name]. Returns
+	// database.ErrNotFound if it isn't registered.
+	GetSchema(name string) (*PayloadSchema, error)
+	// GetSchemas returns the registered schemas, by name in byte order
+	GetSchemas() ([]*PayloadSchema, error)
+	// GetSchemaNonce returns the number of accepted schema updates
+	GetSchemaNonce() (uint64, error)
+	// ApplySchemaUpdate applies the accepted [update] and counts it
+	ApplySchemaUpdate(update *SchemaUpdate) error
+}
+
+// schemaRegistry implements SchemaRegistry with a database keyed by schema
+// name
+type schemaRegistry struct {
+	schemaDB database.Database
+}
+
+// NewSchemaRegistry returns SchemaRegistry stored in the given db
+func NewSchemaRegistry(db database.Database) SchemaRegistry {
+	return &schemaRegistry{schemaDB: db}
+}
+
+// GetSchema implements the SchemaRegistry interface
+func (r *schemaRegistry) GetSchema(name string) (*PayloadSchema, error) {
+	schemaBytes, err := r.schemaDB.Get([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+	schema := &PayloadSchema{}
+	_, err = Codec.Unmarshal(schemaBytes, schema)
+	return schema, err
+}
+
+// GetSchemas implements the SchemaRegistry interface
+func (r *schemaRegistry) GetSchemas() ([]*PayloadSchema, error) {
+	it := r.schemaDB.NewIterator()
+	defer it.Release()
+
+	schemas := []*PayloadSchema(nil)
+	for it.Next() {
+		if string(it.Key()) == string(schemaNonceKey) {
+			continue
+		}
+		schema := &PayloadSchema{}
+		if _, err := Codec.Unmarshal(it.Value(), schema); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, it.Error()
+}
+
+// GetSchemaNonce implements the SchemaRegistry interface
+func (r *schemaRegistry) GetSchemaNonce() (uint64, error) {
+	nonce, err := database.GetUInt64(r.schemaDB, schemaNonceKey)
+	if err == database.ErrNotFound {
+		return 0, nil
+	}
+	return nonce, err
+}
+
+// ApplySchemaUpdate implements the SchemaRegistry interface
+func (r *schemaRegistry) ApplySchemaUpdate(update *SchemaUpdate) error {
+	nonce, err := r.GetSchemaNonce()
+	if err != nil {
+		return err
+	}
+	schemaBytes, err := Codec.Marshal(CodecVersion, &update.Schema)
+	if err != nil {
+		return err
+	}
+	if err := r.schemaDB.Put([]byte(update.Schema.Name), schemaBytes); err != nil {
+		return err
+	}
+	return database.PutUInt64(r.schemaDB, schemaNonceKey, nonce+1)
+}
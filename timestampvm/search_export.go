@@ -0,0 +1,429 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+const (
+	// searchExportJobName is the job the height of the next block to export
+	// to the search index is persisted as
+	searchExportJobName = "searchExport"
+	// maxSearchReplySize is the largest reply read from the search cluster
+	maxSearchReplySize = 16 << 20
+	// searchIndexExists is the type of the error creating an index which
+	// exists already
+	searchIndexExists = "resource_already_exists_exception"
+)
+
+var (
+	errSearchExportDisabled = errors.New("search export is disabled")
+	errSearchExportFailed   = errors.New("search cluster call failed")
+	errBadSearchIndex       = errors.New("searchIndex must be a lower case index name")
+	errSearchBatchSize      = errors.New("searchBatchSize must be positive")
+
+	searchIndexName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,254}$`)
+
+	// searchMapping maps the fields of the exported documents. Identifiers
+	// and tags are matched exactly, [searchDocument.Text] is analyzed for
+	// free-text search.
+	searchMapping = map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"id":            map[string]string{"type": "keyword"},
+				"parentID":      map[string]string{"type": "keyword"},
+				"height":        map[string]string{"type": "long"},
+				"timestamp":     map[string]string{"type": "date"},
+				"data":          map[string]string{"type": "keyword"},
+				"hash":          map[string]string{"type": "keyword"},
+				"namespace":     map[string]string{"type": "keyword"},
+				"submitter":     map[string]string{"type": "keyword"},
+				"hashAlgorithm": map[string]string{"type": "keyword"},
+				"tags":          map[string]string{"type": "keyword"},
+				"text":          map[string]string{"type": "text"},
+				"bodyDropped":   map[string]string{"type": "boolean"},
+			},
+		},
+	}
+)
+
+// searchDocument is the summary of an accepted block indexed by the search
+// cluster. Its ID is the block ID.
+type searchDocument struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parentID"`
+	Height    uint64    `json:"height"`
+	Timestamp time.Time `json:"timestamp"`
+	// Base 58 encoded data, as returned by the API
+	Data string `json:"data,omitempty"`
+	// Hex encoded data, as printed by tools like sha256sum
+	Hash          string `json:"hash,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Submitter     string `json:"submitter,omitempty"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	// Tags are formatted as key=value, as tag keys may hold dots, which the
+	// search cluster reads as paths of object fields
+	Tags []string `json:"tags,omitempty"`
+	// Text holds the namespace and the tag values, for free-text search
+	Text        string `json:"text,omitempty"`
+	BodyDropped bool   `json:"bodyDropped"`
+}
+
+// newSearchDocument returns the document indexing [blk]
+func newSearchDocument(blk *exportedBlock) (*searchDocument, error) {
+	doc := &searchDocument{
+		ID:            blk.ID.String(),
+		ParentID:      blk.ParentID.String(),
+		Height:        blk.Height,
+		Timestamp:     blk.Timestamp.UTC(),
+		Namespace:     blk.Namespace,
+		HashAlgorithm: blk.HashAlgorithm,
+		BodyDropped:   blk.BodyDropped,
+	}
+	if blk.Data != nil {
+		data, err := formatting.EncodeWithChecksum(formatting.CB58, blk.Data)
+		if err != nil {
+			return nil, err
+		}
+		doc.Data = data
+		doc.Hash = hex.EncodeToString(blk.Data)
+	}
+	if blk.Submitter != nil {
+		doc.Submitter = blk.Submitter.String()
+	}
+	text := []string{}
+	if blk.Namespace != "" {
+		text = append(text, blk.Namespace)
+	}
+	for _, tag := range blk.Tags {
+		doc.Tags = append(doc.Tags, tag.Key+"="+tag.Value)
+		text = append(text, tag.Value)
+	}
+	doc.Text = strings.Join(text, " ")
+	return doc, nil
+}
+
+// searchExporter pushes summaries of the accepted blocks, with their
+// namespaces and tags, into an Elasticsearch or OpenSearch index, so they
+// can be searched from existing enterprise search stacks. The height of the
+// next block to export is persisted by this node once the cluster indexed
+// the blocks below it, so the export resumes where it left off. Without it,
+// all accepted blocks are backfilled from genesis. Documents are keyed by
+// block ID, so blocks can be exported again, see [searchExporter.Backfill].
+type searchExporter struct {
+	vm    *VM
+	url   string
+	index string
+	// value of the Authorization header, empty if none
+	authorization []byte
+	batchSize     int
+	client        *http.Client
+	// wakes the exporter up early
+	wake chan struct{}
+
+	lock   sync.Mutex
+	status ExportStatus
+	// height the export restarts from, nil unless a backfill was requested
+	backfillHeight *uint64
+
+	height   prometheus.Gauge
+	failures prometheus.Counter
+}
+
+// newSearchExporter returns the searchExporter of [vm], reporting metrics to
+// [registerer], or nil if the export isn't configured
+func newSearchExporter(vm *VM, registerer prometheus.Registerer) (*searchExporter, error) {
+	config := &vm.config
+	if config.SearchURL == "" {
+		return nil, nil
+	}
+	e := &searchExporter{
+		vm:        vm,
+		url:       strings.TrimSuffix(config.SearchURL, "/"),
+		index:     config.SearchIndex,
+		batchSize: config.SearchBatchSize,
+		client:    &http.Client{Timeout: config.SearchTimeout.Duration},
+		wake:      make(chan struct{}, 1),
+		height: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "search_export_height",
+			Help: "height of the next block to export to the search index",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "search_export_failures",
+			Help: "# of exports to the search index which failed",
+		}),
+	}
+	if config.SearchAuthorizationFile != "" {
+		authorization, err := readToken(config.SearchAuthorizationFile)
+		if err != nil {
+			return nil, err
+		}
+		e.authorization = authorization
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(e.height),
+		registerer.Register(e.failures),
+	)
+	return e, errs.Err
+}
+
+// Status returns the state of the export
+func (e *searchExporter) Status() ExportStatus {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.status
+}
+
+// Backfill exports the accepted blocks again, starting at [height], e.g. once
+// the index was deleted
+func (e *searchExporter) Backfill(height uint64) {
+	e.lock.Lock()
+	e.backfillHeight = &height
+	e.lock.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runPeriodically exports the blocks accepted since the last export right
+// away and then every [vm.config.SearchInterval] until the VM shuts down
+func (e *searchExporter) runPeriodically() {
+	ticker := time.NewTicker(e.vm.config.SearchInterval.Duration)
+	defer ticker.Stop()
+
+	initialized := false
+	for {
+		err := error(nil)
+		if !initialized {
+			err = e.createIndex()
+			initialized = err == nil
+		}
+		if err == nil {
+			err = e.export()
+		}
+		e.lock.Lock()
+		e.status.CheckedAt = time.Now()
+		e.status.LastError = ""
+		if err != nil {
+			e.status.LastError = err.Error()
+		}
+		e.lock.Unlock()
+		if err != nil {
+			e.failures.Inc()
+			e.vm.ctx.Log.Warn("couldn't export blocks to the search index: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-e.wake:
+		case <-e.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// createIndex creates the index with [searchMapping], unless it exists
+func (e *searchExporter) createIndex() error {
+	body, err := json.Marshal(searchMapping)
+	if err != nil {
+		return err
+	}
+	status, reply, err := e.call(http.MethodPut, "/"+e.index, "application/json", body)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	failure := struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(reply, &failure); err != nil {
+		return fmt.Errorf("%w: %d", errSearchExportFailed, status)
+	}
+	if failure.Error.Type == searchIndexExists {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errSearchExportFailed, failure.Error.Reason)
+}
+
+// export exports the blocks accepted since the last export, in batches of
+// [batchSize] blocks. The cluster is called without holding the context
+// lock.
+func (e *searchExporter) export() error {
+	e.lock.Lock()
+	backfillHeight := e.backfillHeight
+	e.lock.Unlock()
+
+	if backfillHeight != nil {
+		if err := e.saveCursor(*backfillHeight); err != nil {
+			return err
+		}
+		e.lock.Lock()
+		// Unless another backfill was requested meanwhile
+		if e.backfillHeight == backfillHeight {
+			e.backfillHeight = nil
+		}
+		e.lock.Unlock()
+	}
+	for {
+		blocks, err := e.nextBlocks()
+		if err != nil || len(blocks) == 0 {
+			return err
+		}
+		if err := e.bulkIndex(blocks); err != nil {
+			return err
+		}
+		nextHeight := blocks[len(blocks)-1].Height + 1
+		if err := e.saveCursor(nextHeight); err != nil {
+			return err
+		}
+
+		e.height.Set(float64(nextHeight))
+		e.lock.Lock()
+		e.status.NextHeight = nextHeight
+		e.status.Exported += uint64(len(blocks))
+		e.lock.Unlock()
+	}
+}
+
+// nextBlocks returns the next batch of accepted blocks to export, none if
+// the VM shut down
+func (e *searchExporter) nextBlocks() ([]*exportedBlock, error) {
+	e.vm.ctx.Lock.Lock()
+	defer e.vm.ctx.Lock.Unlock()
+
+	if e.vm.isShutdown() {
+		return nil, nil
+	}
+	nextHeight := uint64(0)
+	cursor, err := e.vm.state.GetJobProgress(searchExportJobName)
+	switch {
+	case err == nil:
+		nextHeight, err = database.ParseUInt64(cursor)
+		if err != nil {
+			return nil, err
+		}
+	case err != database.ErrNotFound:
+		return nil, err
+	}
+	return e.vm.exportedBlocks(nextHeight, e.batchSize)
+}
+
+// saveCursor persists [nextHeight] as the height of the next block to export
+func (e *searchExporter) saveCursor(nextHeight uint64) error {
+	e.vm.ctx.Lock.Lock()
+	defer e.vm.ctx.Lock.Unlock()
+
+	if e.vm.isShutdown() {
+		return nil
+	}
+	if err := e.vm.committer.Flush(); err != nil {
+		return err
+	}
+	if err := e.vm.state.SetJobProgress(searchExportJobName, database.PackUInt64(nextHeight)); err != nil {
+		e.vm.state.Abort()
+		return err
+	}
+	return e.vm.state.Commit()
+}
+
+// bulkIndex indexes [blocks] with a single bulk request
+func (e *searchExporter) bulkIndex(blocks []*exportedBlock) error {
+	body := bytes.Buffer{}
+	encoder := json.NewEncoder(&body)
+	for _, blk := range blocks {
+		doc, err := newSearchDocument(blk)
+		if err != nil {
+			return err
+		}
+		action := map[string]interface{}{
+			"index": map[string]string{"_index": e.index, "_id": doc.ID},
+		}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+	}
+	status, reply, err := e.call(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%w: %d", errSearchExportFailed, status)
+	}
+
+	result := struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}{}
+	if err := json.Unmarshal(reply, &result); err != nil {
+		return fmt.Errorf("%w: %s", errSearchExportFailed, err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for _, outcome := range item {
+			if outcome.Error != nil {
+				return fmt.Errorf("%w: %s", errSearchExportFailed, outcome.Error.Reason)
+			}
+		}
+	}
+	return errSearchExportFailed
+}
+
+// call sends [body] with [method] to [path] of the cluster and returns the
+// status and the body of the reply
+func (e *searchExporter) call(method string, path string, contentType string, body []byte) (int, []byte, error) {
+	request, err := http.NewRequest(method, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	if len(e.authorization) > 0 {
+		request.Header.Set("Authorization", string(e.authorization))
+	}
+	resp, err := e.client.Do(request)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %s", errSearchExportFailed, err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, maxSearchReplySize))
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %s", errSearchExportFailed, err)
+	}
+	return resp.StatusCode, reply, nil
+}
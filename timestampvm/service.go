@@ -14,10 +14,19 @@
 package timestampvm
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stdjson "encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/chain4travel/caminogo/database"
 	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/crypto"
 	"github.com/chain4travel/caminogo/utils/formatting"
 	"github.com/chain4travel/caminogo/utils/json"
 )
@@ -26,59 +35,1768 @@ var (
 	errBadData               = errors.New("data must be base 58 repr. of 32 bytes")
 	errNoSuchBlock           = errors.New("couldn't get block from database. Does it exist?")
 	errCannotGetLastAccepted = errors.New("problem getting last accepted")
+	errDataNotAnchored       = errors.New("data isn't anchored in an accepted block")
+	errBadSignatureEncoding  = errors.New("signature must be base 58 repr. of a signature")
+	errMempoolLocked         = errors.New("the mempool is locked by an operator")
+	errServicePaused         = errors.New("service paused for maintenance")
+	errMissingNamespace      = errors.New("a namespace must be given")
+	errBlockBodyDropped      = errors.New("block's body is no longer stored")
+)
+
+const (
+	// maximum number of blocks returned by a single paginated call
+	maxPageSize = 1024
 )
 
 // Service is the API service for this VM
 type Service struct{ vm *VM }
 
+// NewService returns the API service of [vm], e.g. to call it in process.
+// Like the API handlers, callers racing with the engine must hold the
+// context lock of [vm].
+func NewService(vm *VM) *Service {
+	return &Service{vm: vm}
+}
+
 // ProposeBlockArgs are the arguments to function ProposeValue
 type ProposeBlockArgs struct {
 	// Data in the block. Must be base 58 encoding of 32 bytes.
 	Data string `json:"data"`
+	// Optional base 58 encoded signature of the data by its submitter.
+	// See [ScopedSubmissionMessage] for what must be signed.
+	Signature string `json:"signature"`
+	// Proof of work over the data, required on chains configured with
+	// [Config.ProofOfWorkBits]. See [SolveProofOfWork].
+	ProofOfWork *ProofOfWork `json:"proofOfWork"`
+	// Optional namespace of the data, so applications sharing the chain can
+	// query only their own records. It's covered by the signature.
+	Namespace string `json:"namespace"`
+	// Optional key=value tags of the data, indexed within its namespace.
+	// They're covered by the signature as well.
+	Tags map[string]string `json:"tags"`
+	// Optional structured record whose hash is the data, see [RecordData].
+	// The data may be left blank if a record is given. Required by the
+	// namespaces configured with [Config.RecordSchemas].
+	Record stdjson.RawMessage `json:"record"`
+	// Optional digest algorithm the data was computed with, one of
+	// [SHA256], [SHA3256] and [Blake2b256]. It's declared by the tag
+	// "hashAlgorithm". SHA-256 is assumed if none is declared.
+	HashAlgorithm string `json:"hashAlgorithm"`
 }
 
 // ProposeBlockReply is the reply from function ProposeBlock
-type ProposeBlockReply struct{ Success bool }
+type ProposeBlockReply struct {
+	Success bool
+	// Address recovered from the signature, only set for signed submissions.
+	// Clients should compare it with their own address.
+	Submitter *ids.ShortID `json:"submitter,omitempty"`
+}
+
+// ProposeBlock is an API method to propose a new block whose data is [args].Data.
+// [args].Data must be a string repr. of a 32 byte array
+func (s *Service) ProposeBlock(r *http.Request, args *ProposeBlockArgs, reply *ProposeBlockReply) error {
+	data, err := s.proposedData(args)
+	if err != nil {
+		return err
+	}
+	if len(args.Record) > 0 && args.HashAlgorithm != "" && args.HashAlgorithm != SHA256 {
+		return errRecordHashAlgorithm
+	}
+	tags, err := declareHashAlgorithm(args.Tags, args.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+	declared := *args
+	declared.Tags = tags
+	return s.proposeData(r, &declared, &submission{data: data}, reply)
+}
+
+// ProposeSaltedContentArgs are the arguments to ProposeSaltedContent
+type ProposeSaltedContentArgs struct {
+	// Base 58 encoded content to anchor. It's neither stored nor logged.
+	Content string `json:"content"`
+	// Optional namespace and tags of the salted hash
+	Namespace string            `json:"namespace"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// auditParams returns [args] without the content, which is kept off the
+// audit log as well
+func (args *ProposeSaltedContentArgs) auditParams() interface{} {
+	return &struct {
+		Namespace string            `json:"namespace"`
+		Tags      map[string]string `json:"tags"`
+	}{
+		Namespace: args.Namespace,
+		Tags:      args.Tags,
+	}
+}
+
+// ProposeSaltedContentReply is the reply from ProposeSaltedContent
+type ProposeSaltedContentReply struct {
+	Success bool `json:"success"`
+	// Salt generated for the content. It must be kept along with the
+	// content, which can't be verified without it.
+	Salt ids.ID `json:"salt"`
+	// Base 58 encoded salted hash anchored as the data, see [SaltedHash]
+	Data string `json:"data"`
+}
+
+// ProposeSaltedContent proposes a block anchoring the hash of [args.Content]
+// salted with a random salt, which is returned. Only the salted hash is
+// anchored, so documents of low entropy, like a booking confirmation, can't
+// be brute-forced from the chain. The salted hash isn't signed, so chains
+// requiring signatures or proofs of work refuse it.
+func (s *Service) ProposeSaltedContent(r *http.Request, args *ProposeSaltedContentArgs, reply *ProposeSaltedContentReply) error {
+	content, err := formatting.Decode(formatting.CB58, args.Content)
+	if err != nil {
+		return errBadData
+	}
+	// Salted hashes aren't records, so they can't be anchored in namespaces
+	// requiring records
+	if s.vm.recordSchemas[args.Namespace] != nil {
+		return errMissingRecord
+	}
+	var salt ids.ID
+	if _, err := rand.Read(salt[:]); err != nil {
+		return err
+	}
+	data := SaltedHash(salt, content)
+	if err := s.proposeData(r, &ProposeBlockArgs{
+		Namespace: args.Namespace,
+		Tags:      args.Tags,
+	}, &submission{data: data}, &ProposeBlockReply{}); err != nil {
+		return err
+	}
+	reply.Success = true
+	reply.Salt = salt
+	reply.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	return err
+}
+
+// VerifyTimestampArgs are the arguments to VerifyTimestamp
+type VerifyTimestampArgs struct {
+	// Base 58 encoded document whose digest is anchored
+	Document string `json:"document"`
+	// Optional digest algorithm of the document. If left blank, the digests
+	// of all supported algorithms are looked up.
+	HashAlgorithm string `json:"hashAlgorithm"`
+	// Optional salt the document was hashed with, see ProposeSaltedContent.
+	// Salted documents are hashed with SHA-256.
+	Salt ids.ID `json:"salt"`
+}
+
+// VerifyTimestampReply is the reply from VerifyTimestamp
+type VerifyTimestampReply struct {
+	// Block is the earliest accepted block anchoring the digest
+	Block GetBlockReply `json:"block"`
+	// HashAlgorithm is the algorithm the anchored digest was computed with
+	HashAlgorithm string `json:"hashAlgorithm"`
+}
+
+// VerifyTimestamp recomputes the digest of [args.Document] and returns the
+// block anchoring it, whose timestamp proves the document existed by then.
+// A digest only matches blocks declaring the algorithm it was computed with.
+func (s *Service) VerifyTimestamp(r *http.Request, args *VerifyTimestampArgs, reply *VerifyTimestampReply) error {
+	document, err := formatting.Decode(formatting.CB58, args.Document)
+	if err != nil {
+		return errBadData
+	}
+	if args.Salt != ids.Empty {
+		if args.HashAlgorithm != "" && args.HashAlgorithm != SHA256 {
+			return errSaltedHashAlgorithm
+		}
+		data := SaltedHash(args.Salt, document)
+		entry, err := s.vm.state.GetDataEntry(DataHash(data))
+		if err == database.ErrNotFound {
+			return errDataNotAnchored
+		}
+		if err != nil {
+			return err
+		}
+		reply.HashAlgorithm = SHA256
+		return s.fillAcceptedReply(r, entry.BlkID, &reply.Block)
+	}
+	algorithms := hashAlgorithms
+	if args.HashAlgorithm != "" {
+		if err := verifyHashAlgorithm(args.HashAlgorithm); err != nil {
+			return err
+		}
+		algorithms = []string{args.HashAlgorithm}
+	}
+	for _, algorithm := range algorithms {
+		data, err := Digest(algorithm, document)
+		if err != nil {
+			return err
+		}
+		entry, err := s.vm.state.GetDataEntry(DataHash(data))
+		if err == database.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		block, err := s.vm.getBlock(entry.BlkID)
+		if err != nil {
+			// The declaration was dropped along with the body
+			if s.vm.fillDroppedReply(entry.BlkID, &reply.Block) != nil {
+				return errNoSuchBlock
+			}
+			reply.HashAlgorithm = algorithm
+			return nil
+		}
+		if declaredHashAlgorithm(block.Tags()) != algorithm {
+			continue
+		}
+		if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+			return err
+		}
+		reply.HashAlgorithm = algorithm
+		return fillBlockReply(block, &reply.Block)
+	}
+	return errDataNotAnchored
+}
+
+// proposeData proposes the data of [sub] with the signature, proof of work,
+// namespace and tags of [args]
+func (s *Service) proposeData(r *http.Request, args *ProposeBlockArgs, sub *submission, reply *ProposeBlockReply) error {
+	data := sub.data
+	if err := s.checkProposing(); err != nil {
+		return err
+	}
+	if err := verifyNamespace(args.Namespace); err != nil {
+		return err
+	}
+	if err := s.vm.authorizeNamespaceWrite(r, args.Namespace); err != nil {
+		return err
+	}
+	tags, err := parseTags(args.Tags)
+	if err != nil {
+		return err
+	}
+	// Refuse data not conforming to its schema right away instead of failing
+	// to build
+	if name, declared := declaredSchema(tags); declared {
+		if len(s.vm.config.SchemaAdmins) == 0 {
+			return errSchemasDisabled
+		}
+		schema, err := s.vm.lookupSchema(nil, name)
+		if err != nil {
+			return err
+		}
+		if err := schema.Conforms(data); err != nil {
+			return err
+		}
+	}
+	sub.namespace = args.Namespace
+	sub.tags = tags
+	if r != nil {
+		sub.traceCtx = r.Context()
+	}
+	if args.Signature != "" {
+		if !s.vm.config.SignedSubmissions {
+			return errSignedSubmissionsDisabled
+		}
+		sub.sig, err = formatting.Decode(formatting.CB58, args.Signature)
+		if err != nil {
+			return errBadSignatureEncoding
+		}
+		// Refuse bad signatures right away instead of failing to build
+		submitter, err := recoverSubmitter(s.vm.ctx.ChainID, data, sub.namespace, sub.tags, sub.sig)
+		if err != nil {
+			return err
+		}
+		reply.Submitter = &submitter
+	}
+	if s.vm.namespaceSubmitters != nil {
+		if err := s.vm.namespaceSubmitters.verify(reply.Submitter, args.Namespace); err != nil {
+			return err
+		}
+	}
+	// Refuse chain heads the submitter may not anchor right away instead of
+	// failing to build
+	if s.vm.chainHeadSources != nil {
+		head, err := parseChainHead(tags, data)
+		if err != nil {
+			return err
+		}
+		if head != nil {
+			if err := s.vm.chainHeadSources.verify(reply.Submitter, head.Chain); err != nil {
+				return err
+			}
+		}
+	}
+	if args.ProofOfWork != nil && s.vm.config.ProofOfWorkBits == 0 {
+		return errProofOfWorkDisabled
+	}
+	// Submissions within the free allowance, as far as it's known now, pay
+	// neither fees nor a proof of work
+	free := false
+	if s.vm.config.FreeDailyAnchors > 0 && reply.Submitter != nil {
+		left, err := s.vm.freeAnchorsLeft(*reply.Submitter, time.Now())
+		if err != nil {
+			return err
+		}
+		free = left > 0
+	}
+	if args.ProofOfWork != nil {
+		sub.pow = args.ProofOfWork
+	}
+	if s.vm.config.ProofOfWorkBits > 0 && !free {
+		// Refuse missing or stale work right away instead of failing to build
+		preferred, err := s.vm.getBlock(s.vm.preferred)
+		if err != nil {
+			return errDatabaseGet
+		}
+		if err := s.vm.verifyProofOfWork(preferred, data, args.ProofOfWork); err != nil {
+			return err
+		}
+	}
+	if s.vm.config.SubmitterAllowlistEnabled {
+		if reply.Submitter == nil {
+			return errUnsignedSubmission
+		}
+		allowed, err := s.vm.state.IsAllowlisted(*reply.Submitter)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s", errSubmitterNotAllowed, reply.Submitter)
+		}
+	}
+	if s.vm.config.FeesEnabled {
+		if reply.Submitter == nil {
+			return errUnsignedSubmission
+		}
+		credits, err := s.vm.state.GetCredits(*reply.Submitter)
+		if err != nil {
+			return err
+		}
+		if !free && credits == 0 {
+			if err := s.checkBalance(*reply.Submitter, sub, 0); err != nil {
+				return err
+			}
+		}
+	}
+	if s.vm.validators != nil {
+		if reply.Submitter == nil {
+			return errUnsignedSubmission
+		}
+		pChainHeight, err := s.vm.ctx.ValidatorState.GetCurrentHeight()
+		if err != nil {
+			return err
+		}
+		if err := s.vm.validators.verifyValidator(pChainHeight, *reply.Submitter); err != nil {
+			return err
+		}
+	}
+	s.vm.proposeSubmission(sub)
+	reply.Success = true
+	return nil
+}
+
+// proposedData returns the data [args] propose, which is the hash of the
+// record if one is given. Records are validated against the JSON Schema of
+// their namespace, which requires them.
+func (s *Service) proposedData(args *ProposeBlockArgs) ([dataLen]byte, error) {
+	schema := s.vm.recordSchemas[args.Namespace]
+	if len(args.Record) == 0 {
+		if schema != nil {
+			return [dataLen]byte{}, errMissingRecord
+		}
+		return parseData(args.Data)
+	}
+	if schema != nil {
+		if err := schema.Validate(args.Record); err != nil {
+			return [dataLen]byte{}, err
+		}
+	}
+	data, err := RecordData(args.Record)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	if args.Data != "" {
+		given, err := parseData(args.Data)
+		if err != nil {
+			return [dataLen]byte{}, err
+		}
+		if given != data {
+			return [dataLen]byte{}, errRecordData
+		}
+	}
+	return data, nil
+}
+
+// checkProposing returns an error if proposals are currently refused
+func (s *Service) checkProposing() error {
+	if s.vm.config.ReadOnly {
+		return errReadOnly
+	}
+	if s.vm.maintenance {
+		if s.vm.maintenanceReason == "" {
+			return errServicePaused
+		}
+		return fmt.Errorf("%w: %s", errServicePaused, s.vm.maintenanceReason)
+	}
+	if s.vm.mempool.Locked() {
+		return errMempoolLocked
+	}
+	return nil
+}
+
+// checkBalance returns errInsufficientBalance if the accepted balance of
+// [submitter] doesn't cover the current fee of [sub] and [amount]
+func (s *Service) checkBalance(submitter ids.ShortID, sub *submission, amount uint64) error {
+	account, err := s.vm.state.GetAccount(submitter)
+	if err != nil {
+		return err
+	}
+	fee, _, err := s.vm.submissionFee(sub)
+	if err != nil {
+		return err
+	}
+	if account.Balance < fee || account.Balance-fee < amount {
+		return fmt.Errorf("%w: %s holds %d of %d", errInsufficientBalance, submitter, account.Balance, fee)
+	}
+	return nil
+}
+
+// ProposeTransferArgs are the arguments to ProposeTransfer
+type ProposeTransferArgs struct {
+	Nonce  json.Uint64 `json:"nonce"`
+	To     ids.ShortID `json:"to"`
+	Amount json.Uint64 `json:"amount"`
+	// Base 58 encoded signature of the transfer by the sender.
+	// See [TransferMessage] for what must be signed.
+	Signature string `json:"signature"`
+}
+
+// ProposeTransfer proposes a block moving [args.Amount] from the account of
+// the signer to the account of [args.To]. The fee is charged on top. If the
+// signer is a funding issuer, [args.To] is funded with new funds instead.
+func (s *Service) ProposeTransfer(r *http.Request, args *ProposeTransferArgs, reply *ProposeBlockReply) error {
+	if !s.vm.config.FeesEnabled {
+		return errFeesDisabled
+	}
+	if err := s.checkProposing(); err != nil {
+		return err
+	}
+	transfer := &Transfer{
+		Nonce:  uint64(args.Nonce),
+		To:     args.To,
+		Amount: uint64(args.Amount),
+	}
+	if transfer.Amount == 0 {
+		return errEmptyTransfer
+	}
+	data, err := TransferData(transfer)
+	if err != nil {
+		return err
+	}
+	sub := &submission{
+		data:     data,
+		transfer: transfer,
+	}
+	if r != nil {
+		sub.traceCtx = r.Context()
+	}
+	sub.sig, err = formatting.Decode(formatting.CB58, args.Signature)
+	if err != nil || len(sub.sig) == 0 {
+		return errBadSignatureEncoding
+	}
+	// Refuse transfers which can't be paid right away instead of failing to
+	// build
+	submitter, err := recoverSubmitter(s.vm.ctx.ChainID, data, "", nil, sub.sig)
+	if err != nil {
+		return err
+	}
+	if !s.vm.fundingIssuers.Contains(submitter) {
+		if err := s.checkBalance(submitter, sub, transfer.Amount); err != nil {
+			return err
+		}
+	}
+	s.vm.proposeSubmission(sub)
+	reply.Success = true
+	reply.Submitter = &submitter
+	return nil
+}
+
+// GetAccountArgs are the arguments to GetAccount
+type GetAccountArgs struct {
+	Address ids.ShortID `json:"address"`
+}
+
+// GetAccountReply is the reply from GetAccount
+type GetAccountReply struct {
+	// Balance is the amount available to pay fees and transfers
+	Balance json.Uint64 `json:"balance"`
+	// Nonce is the nonce of the next transfer from the account
+	Nonce json.Uint64 `json:"nonce"`
+	// Fee is the amount currently charged for a signed block anchoring data
+	Fee json.Uint64 `json:"fee"`
+	// Credits is the number of prepaid anchors left
+	Credits json.Uint64 `json:"credits"`
+}
+
+// GetAccount returns the account of [args.Address] as of the last accepted
+// block
+func (s *Service) GetAccount(_ *http.Request, args *GetAccountArgs, reply *GetAccountReply) error {
+	if !s.vm.config.FeesEnabled {
+		return errFeesDisabled
+	}
+	account, err := s.vm.state.GetAccount(args.Address)
+	if err != nil {
+		return err
+	}
+	fee, _, err := s.vm.submissionFee(s.signedDataSubmission())
+	if err != nil {
+		return err
+	}
+	reply.Balance = json.Uint64(account.Balance)
+	reply.Nonce = json.Uint64(account.Nonce)
+	credits, err := s.vm.state.GetCredits(args.Address)
+	if err != nil {
+		return err
+	}
+	reply.Fee = json.Uint64(fee)
+	reply.Credits = json.Uint64(credits)
+	return nil
+}
+
+// ProposeCreditGrantArgs are the arguments to ProposeCreditGrant
+type ProposeCreditGrantArgs struct {
+	Nonce   json.Uint64 `json:"nonce"`
+	To      ids.ShortID `json:"to"`
+	Credits json.Uint64 `json:"credits"`
+	// Base 58 encoded signature of the grant by a funding issuer.
+	// See [CreditGrantMessage] for what must be signed.
+	Signature string `json:"signature"`
+}
+
+// ProposeCreditGrant proposes a block topping up the prepaid anchors of
+// [args.To] by [args.Credits]
+func (s *Service) ProposeCreditGrant(r *http.Request, args *ProposeCreditGrantArgs, reply *ProposeBlockReply) error {
+	if !s.vm.config.FeesEnabled {
+		return errFeesDisabled
+	}
+	if err := s.checkProposing(); err != nil {
+		return err
+	}
+	grant := &CreditGrant{
+		Nonce:   uint64(args.Nonce),
+		To:      args.To,
+		Credits: uint64(args.Credits),
+	}
+	if grant.Credits == 0 {
+		return errEmptyCreditGrant
+	}
+	data, err := CreditGrantData(grant)
+	if err != nil {
+		return err
+	}
+	sub := &submission{
+		data:  data,
+		grant: grant,
+	}
+	if r != nil {
+		sub.traceCtx = r.Context()
+	}
+	sub.sig, err = formatting.Decode(formatting.CB58, args.Signature)
+	if err != nil || len(sub.sig) == 0 {
+		return errBadSignatureEncoding
+	}
+	// Refuse grants by others right away instead of failing to build
+	submitter, err := recoverSubmitter(s.vm.ctx.ChainID, data, "", nil, sub.sig)
+	if err != nil {
+		return err
+	}
+	if !s.vm.fundingIssuers.Contains(submitter) {
+		return errNotFundingIssuer
+	}
+	s.vm.proposeSubmission(sub)
+	reply.Success = true
+	reply.Submitter = &submitter
+	return nil
+}
+
+// GetFreeAnchorsArgs are the arguments to GetFreeAnchors
+type GetFreeAnchorsArgs struct {
+	Address ids.ShortID `json:"address"`
+}
+
+// GetFreeAnchorsReply is the reply from GetFreeAnchors
+type GetFreeAnchorsReply struct {
+	// Limit is the number of free anchors per UTC day
+	Limit json.Uint64 `json:"limit"`
+	// Left is the number of free anchors left today
+	Left json.Uint64 `json:"left"`
+}
+
+// GetFreeAnchors returns the free anchors [args.Address] has left today, as
+// of the last accepted block. Pending submissions aren't counted yet.
+func (s *Service) GetFreeAnchors(_ *http.Request, args *GetFreeAnchorsArgs, reply *GetFreeAnchorsReply) error {
+	left, err := s.vm.freeAnchorsLeft(args.Address, time.Now())
+	if err != nil {
+		return err
+	}
+	reply.Limit = json.Uint64(s.vm.config.FreeDailyAnchors)
+	reply.Left = json.Uint64(left)
+	return nil
+}
+
+// signedDataSubmission returns a signed submission anchoring data, whose
+// block has the size of any such block
+func (s *Service) signedDataSubmission() *submission {
+	sub := &submission{sig: make([]byte, crypto.SECP256K1RSigLen)}
+	if s.vm.config.ProofOfWorkBits > 0 {
+		sub.pow = &ProofOfWork{}
+	}
+	return sub
+}
+
+// GetFeeScheduleReply is the reply from GetFeeSchedule
+type GetFeeScheduleReply struct {
+	// Enabled is true if fees are charged
+	Enabled bool `json:"enabled"`
+	// BaseFee is the amount charged per block
+	BaseFee json.Uint64 `json:"baseFee"`
+	// FeePerByte is the amount charged per byte of the encoded block
+	FeePerByte json.Uint64 `json:"feePerByte"`
+	// DemandWindow is the period before a block's parent whose blocks count
+	// as recent demand
+	DemandWindow Duration `json:"demandWindow"`
+	// DemandCurve are the percentages of the fee charged from numbers of
+	// recent blocks on
+	DemandCurve []FeeStep `json:"demandCurve"`
+	// RecentBlocks is the number of recent blocks up to the preferred block
+	RecentBlocks json.Uint64 `json:"recentBlocks"`
+	// Percent is the percentage of the fee currently charged
+	Percent json.Uint64 `json:"percent"`
+	// SubmissionSize is the size of a signed block anchoring data
+	SubmissionSize json.Uint64 `json:"submissionSize"`
+	// SubmissionFee is the amount currently charged for a signed block
+	// anchoring data
+	SubmissionFee json.Uint64 `json:"submissionFee"`
+}
+
+// GetFeeSchedule returns how fees are computed and what a submission built
+// on the preferred block costs, so clients can price submissions before
+// sending them
+func (s *Service) GetFeeSchedule(_ *http.Request, _ *struct{}, reply *GetFeeScheduleReply) error {
+	config := s.vm.config
+	reply.Enabled = config.FeesEnabled
+	reply.BaseFee = json.Uint64(config.SubmissionFee)
+	reply.FeePerByte = json.Uint64(config.FeePerByte)
+	reply.DemandWindow = config.FeeDemandWindow
+	reply.DemandCurve = config.FeeDemandCurve
+	if !config.FeesEnabled {
+		return nil
+	}
+
+	preferred, err := s.vm.getBlock(s.vm.preferred)
+	if err != nil {
+		return errDatabaseGet
+	}
+	recent, err := s.vm.recentBlocks(preferred)
+	if err != nil {
+		return err
+	}
+	percent, err := s.vm.demandPercent(preferred)
+	if err != nil {
+		return err
+	}
+	fee, size, err := s.vm.submissionFee(s.signedDataSubmission())
+	if err != nil {
+		return err
+	}
+	reply.RecentBlocks = json.Uint64(recent)
+	reply.Percent = json.Uint64(percent)
+	reply.SubmissionSize = json.Uint64(size)
+	reply.SubmissionFee = json.Uint64(fee)
+	return nil
+}
+
+// Fee sinks reported by GetFeeTotals
+const (
+	// FeesBurnt means fees are taken out of circulation
+	FeesBurnt = "burn"
+	// FeesToTreasury means fees are credited to [Config.FeeRecipient]
+	FeesToTreasury = "treasury"
+)
+
+// EstimateFeeArgs are the arguments to EstimateFee
+type EstimateFeeArgs struct {
+	// Size is the encoded size of the block, in bytes. 0 estimates the fee
+	// of a signed block anchoring data, whose size is fixed.
+	Size json.Uint64 `json:"size"`
+	// Priority is LowFeePriority, MediumFeePriority (the default) or
+	// HighFeePriority
+	Priority string `json:"priority"`
+	// Submitter, if set, is the address the fee would be charged to. Data
+	// it anchors within its free daily allowance, as far as it's known now,
+	// is estimated free of charge.
+	Submitter *ids.ShortID `json:"submitter,omitempty"`
+}
+
+// EstimateFeeReply is the reply from EstimateFee
+type EstimateFeeReply struct {
+	// Fee is the estimated fee
+	Fee json.Uint64 `json:"fee"`
+	// Size is the size of the block the estimate is for
+	Size json.Uint64 `json:"size"`
+	// Percent is the percentage of the size-based fee estimated
+	Percent json.Uint64 `json:"percent"`
+	// Pending is the number of submissions pending in the mempool
+	Pending json.Uint64 `json:"pending"`
+}
+
+// EstimateFee returns the fee a block of [args.Size] bytes would be charged,
+// based on the fee schedule and the submissions pending before it
+func (s *Service) EstimateFee(_ *http.Request, args *EstimateFeeArgs, reply *EstimateFeeReply) error {
+	if !s.vm.config.FeesEnabled {
+		return errFeesDisabled
+	}
+	size := uint64(args.Size)
+	if size == 0 {
+		_, dataSize, err := s.vm.submissionFee(s.signedDataSubmission())
+		if err != nil {
+			return err
+		}
+		size = dataSize
+	}
+	fee, percent, err := s.vm.estimateFee(size, args.Priority)
+	if err != nil {
+		return err
+	}
+	if args.Size == 0 && args.Submitter != nil && s.vm.config.FreeDailyAnchors > 0 {
+		left, err := s.vm.freeAnchorsLeft(*args.Submitter, time.Now())
+		if err != nil {
+			return err
+		}
+		if left > 0 {
+			fee = 0
+		}
+	}
+	reply.Fee = json.Uint64(fee)
+	reply.Size = json.Uint64(size)
+	reply.Percent = json.Uint64(percent)
+	reply.Pending = json.Uint64(s.vm.mempool.Len())
+	return nil
+}
+
+// GetFeeTotalsReply is the reply from GetFeeTotals
+type GetFeeTotalsReply struct {
+	// Sink is where fees go, FeesBurnt or FeesToTreasury
+	Sink string `json:"sink"`
+	// Treasury is the address credited with the fees, only set if they go
+	// to a treasury
+	Treasury *ids.ShortID `json:"treasury,omitempty"`
+	// Burnt is the amount of fees burnt by the accepted blocks
+	Burnt json.Uint64 `json:"burnt"`
+	// Collected is the amount of fees credited to the treasury by the
+	// accepted blocks
+	Collected json.Uint64 `json:"collected"`
+}
+
+// GetFeeTotals returns where fees go and the running totals of the fees
+// charged as of the last accepted block
+func (s *Service) GetFeeTotals(_ *http.Request, _ *struct{}, reply *GetFeeTotalsReply) error {
+	if !s.vm.config.FeesEnabled {
+		return errFeesDisabled
+	}
+	totals, err := s.vm.state.GetFeeTotals()
+	if err != nil {
+		return err
+	}
+	reply.Sink = FeesBurnt
+	if treasury := s.vm.config.FeeRecipient; treasury != ids.ShortEmpty {
+		reply.Sink = FeesToTreasury
+		reply.Treasury = &treasury
+	}
+	reply.Burnt = json.Uint64(totals.Burnt)
+	reply.Collected = json.Uint64(totals.Collected)
+	return nil
+}
+
+// ProposeAllowlistUpdateArgs are the arguments to ProposeAllowlistUpdate
+type ProposeAllowlistUpdateArgs struct {
+	AllowlistUpdate
+	// Base 58 encoded signature of the update by an allowlist admin.
+	// See [AllowlistUpdateMessage] for what must be signed.
+	Signature string `json:"signature"`
+}
+
+// ProposeAllowlistUpdate proposes a block changing the submitter allowlist
+// by [args.AllowlistUpdate]. The update takes effect for the descendants of
+// that block.
+func (s *Service) ProposeAllowlistUpdate(r *http.Request, args *ProposeAllowlistUpdateArgs, reply *ProposeBlockReply) error {
+	if !s.vm.config.SubmitterAllowlistEnabled {
+		return errAllowlistDisabled
+	}
+	if err := s.checkProposing(); err != nil {
+		return err
+	}
+	update := args.AllowlistUpdate
+	if err := update.Verify(); err != nil {
+		return err
+	}
+	data, err := AllowlistUpdateData(&update)
+	if err != nil {
+		return err
+	}
+	sub := &submission{
+		data:   data,
+		update: &update,
+	}
+	if r != nil {
+		sub.traceCtx = r.Context()
+	}
+	sub.sig, err = formatting.Decode(formatting.CB58, args.Signature)
+	if err != nil || len(sub.sig) == 0 {
+		return errBadSignatureEncoding
+	}
+	// Refuse updates by others right away instead of failing to build
+	submitter, err := recoverSubmitter(s.vm.ctx.ChainID, data, "", nil, sub.sig)
+	if err != nil {
+		return err
+	}
+	if !s.vm.allowlistAdmin(submitter) {
+		return errNotAllowlistAdmin
+	}
+	s.vm.proposeSubmission(sub)
+	reply.Success = true
+	reply.Submitter = &submitter
+	return nil
+}
+
+// ProposeSchemaUpdateArgs are the arguments to ProposeSchemaUpdate
+type ProposeSchemaUpdateArgs struct {
+	SchemaUpdate
+	// Base 58 encoded signature of the update by a schema admin.
+	// See [SchemaUpdateMessage] for what must be signed.
+	Signature string `json:"signature"`
+}
+
+// ProposeSchemaUpdate proposes a block registering the payload schema of
+// [args.SchemaUpdate]. The schema takes effect for the descendants of that
+// block.
+func (s *Service) ProposeSchemaUpdate(r *http.Request, args *ProposeSchemaUpdateArgs, reply *ProposeBlockReply) error {
+	if len(s.vm.config.SchemaAdmins) == 0 {
+		return errSchemasDisabled
+	}
+	if err := s.checkProposing(); err != nil {
+		return err
+	}
+	update := args.SchemaUpdate
+	if err := update.Schema.Verify(); err != nil {
+		return err
+	}
+	data, err := SchemaUpdateData(&update)
+	if err != nil {
+		return err
+	}
+	sub := &submission{
+		data:   data,
+		schema: &update,
+	}
+	if r != nil {
+		sub.traceCtx = r.Context()
+	}
+	sub.sig, err = formatting.Decode(formatting.CB58, args.Signature)
+	if err != nil || len(sub.sig) == 0 {
+		return errBadSignatureEncoding
+	}
+	// Refuse updates by others right away instead of failing to build
+	submitter, err := recoverSubmitter(s.vm.ctx.ChainID, data, "", nil, sub.sig)
+	if err != nil {
+		return err
+	}
+	if !s.vm.schemaAdmin(submitter) {
+		return errNotSchemaAdmin
+	}
+	s.vm.proposeSubmission(sub)
+	reply.Success = true
+	reply.Submitter = &submitter
+	return nil
+}
+
+// GetSchemasReply is the reply from GetSchemas
+type GetSchemasReply struct {
+	// Enabled is true if blocks may declare a schema
+	Enabled bool `json:"enabled"`
+	// Nonce is the nonce of the next update
+	Nonce   json.Uint64      `json:"nonce"`
+	Schemas []*PayloadSchema `json:"schemas"`
+	// Admins are the addresses allowed to sign updates
+	Admins []ids.ShortID `json:"admins"`
+}
+
+// GetSchemas returns the payload schemas as of the last accepted block
+func (s *Service) GetSchemas(_ *http.Request, _ *struct{}, reply *GetSchemasReply) error {
+	nonce, err := s.vm.state.GetSchemaNonce()
+	if err != nil {
+		return err
+	}
+	schemas, err := s.vm.state.GetSchemas()
+	if err != nil {
+		return err
+	}
+	reply.Enabled = len(s.vm.config.SchemaAdmins) > 0
+	reply.Nonce = json.Uint64(nonce)
+	reply.Schemas = schemas
+	reply.Admins = s.vm.config.SchemaAdmins
+	return nil
+}
+
+// ProposeTravelDocumentArgs are the arguments to ProposeTravelDocument
+type ProposeTravelDocumentArgs struct {
+	TravelDocument
+	// Optional base 58 encoded signature of the document by its supplier.
+	// See [TravelDocumentMessage] for what must be signed.
+	Signature string `json:"signature"`
+	// Proof of work over the data, required on chains configured with
+	// [Config.ProofOfWorkBits]
+	ProofOfWork *ProofOfWork `json:"proofOfWork"`
+	// Optional namespace of the document
+	Namespace string `json:"namespace"`
+}
+
+// ProposeTravelDocument proposes a block anchoring the travel document of
+// [args.TravelDocument]. The block is tagged with the profile and the
+// supplier of the document, so the documents of a supplier can be listed
+// with GetByTag.
+func (s *Service) ProposeTravelDocument(r *http.Request, args *ProposeTravelDocumentArgs, reply *ProposeBlockReply) error {
+	doc := args.TravelDocument
+	if err := doc.Verify(); err != nil {
+		return err
+	}
+	data, err := TravelDocumentData(&doc)
+	if err != nil {
+		return err
+	}
+	dataStr, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	if err != nil {
+		return err
+	}
+	return s.ProposeBlock(r, &ProposeBlockArgs{
+		Data:        dataStr,
+		Signature:   args.Signature,
+		ProofOfWork: args.ProofOfWork,
+		Namespace:   args.Namespace,
+		Tags:        travelDocumentTags(&doc),
+	}, reply)
+}
+
+// VerifyTravelDocumentArgs are the arguments to VerifyTravelDocument
+type VerifyTravelDocumentArgs struct {
+	TravelDocument
+	// Time at which the validity of the document is checked, in unix
+	// seconds. If left blank, the current time is used.
+	At json.Uint64 `json:"at"`
+}
+
+// VerifyTravelDocumentReply is the reply from VerifyTravelDocument
+type VerifyTravelDocumentReply struct {
+	// Block is the earliest accepted block anchoring the document
+	Block GetBlockReply `json:"block"`
+	// Valid is true if the document is within its validity window at
+	// [VerifyTravelDocumentArgs.At]
+	Valid bool `json:"valid"`
+}
+
+// VerifyTravelDocument checks that the travel document of
+// [args.TravelDocument] is anchored, returning the block anchoring it and
+// whether it's currently valid. It fails if any field of the document differs
+// from the anchored one.
+func (s *Service) VerifyTravelDocument(r *http.Request, args *VerifyTravelDocumentArgs, reply *VerifyTravelDocumentReply) error {
+	doc := args.TravelDocument
+	if err := doc.Verify(); err != nil {
+		return err
+	}
+	data, err := TravelDocumentData(&doc)
+	if err != nil {
+		return err
+	}
+	entry, err := s.vm.state.GetDataEntry(DataHash(data))
+	if err == database.ErrNotFound {
+		return errDocumentNotAnchored
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.fillAcceptedReply(r, entry.BlkID, &reply.Block); err != nil {
+		return err
+	}
+	at := uint64(args.At)
+	if at == 0 {
+		at = uint64(time.Now().Unix())
+	}
+	reply.Valid = doc.ValidAt(at)
+	return nil
+}
+
+// ProposeChainHeadArgs are the arguments to ProposeChainHead
+type ProposeChainHeadArgs struct {
+	ChainHead
+	// Base 58 encoded signature of the head by a source of the chain.
+	// See [ChainHeadMessage] for what must be signed.
+	Signature string `json:"signature"`
+	// Proof of work over the data, required on chains configured with
+	// [Config.ProofOfWorkBits]
+	ProofOfWork *ProofOfWork `json:"proofOfWork"`
+	// Optional namespace of the head
+	Namespace string `json:"namespace"`
+}
+
+// ProposeChainHead proposes a block anchoring the head of another chain
+// [args.ChainHead]. The block is tagged with the profile and the fields of
+// the head, so the heads of a chain can be looked up with GetChainHead.
+func (s *Service) ProposeChainHead(r *http.Request, args *ProposeChainHeadArgs, reply *ProposeBlockReply) error {
+	if s.vm.chainHeadSources == nil {
+		return errChainHeadsDisabled
+	}
+	head := args.ChainHead
+	if err := head.Verify(); err != nil {
+		return err
+	}
+	data, err := ChainHeadData(&head)
+	if err != nil {
+		return err
+	}
+	dataStr, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	if err != nil {
+		return err
+	}
+	return s.ProposeBlock(r, &ProposeBlockArgs{
+		Data:        dataStr,
+		Signature:   args.Signature,
+		ProofOfWork: args.ProofOfWork,
+		Namespace:   args.Namespace,
+		Tags:        chainHeadTags(&head),
+	}, reply)
+}
+
+// GetChainHeadArgs are the arguments to GetChainHead
+type GetChainHeadArgs struct {
+	// Chain whose head to get
+	Chain string `json:"chain"`
+	// Height of the head in the chain. If left blank, gets the head at the
+	// highest height anchored.
+	Height *json.Uint64 `json:"height"`
+}
+
+// GetChainHeadReply is the reply from GetChainHead
+type GetChainHeadReply struct {
+	ChainHead ChainHead `json:"chainHead"`
+	// Block is the accepted block anchoring the head
+	Block GetBlockReply `json:"block"`
+}
+
+// GetChainHead gets the head of the chain [args.Chain] at [args.Height] and
+// the accepted block anchoring it
+func (s *Service) GetChainHead(r *http.Request, args *GetChainHeadArgs, reply *GetChainHeadReply) error {
+	if s.vm.chainHeadSources == nil {
+		return errChainHeadsDisabled
+	}
+	if err := verifyChainName(args.Chain); err != nil {
+		return err
+	}
+	var (
+		height uint64
+		blkID  ids.ID
+		err    error
+	)
+	if args.Height == nil {
+		height, blkID, err = s.vm.state.GetLatestChainHead(args.Chain)
+	} else {
+		height = uint64(*args.Height)
+		blkID, err = s.vm.state.GetChainHead(args.Chain, height)
+	}
+	if err == database.ErrNotFound {
+		return errChainHeadNotAnchored
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.fillAcceptedReply(r, blkID, &reply.Block); err != nil {
+		return err
+	}
+	if reply.Block.BodyDropped {
+		return errBlockBodyDropped
+	}
+	hash, err := ids.FromString(reply.Block.Tags[chainHashTagKey])
+	if err != nil {
+		return err
+	}
+	reply.ChainHead = ChainHead{
+		Chain:  args.Chain,
+		Height: height,
+		Hash:   hash,
+	}
+	return nil
+}
+
+// ProposeCIDArgs are the arguments to ProposeCID
+type ProposeCIDArgs struct {
+	// CID of the content to anchor, e.g. "bafkrei..." or "Qm...". Its
+	// multihash must be a SHA-256, SHA3-256 or BLAKE2b-256 digest.
+	CID string `json:"cid"`
+	// Optional base 58 encoded signature of the digest of the CID by its
+	// submitter. See [ScopedSubmissionMessage] for what must be signed, the
+	// tags being the CID and its hash algorithm.
+	Signature string `json:"signature"`
+	// Proof of work over the data, required on chains configured with
+	// [Config.ProofOfWorkBits]
+	ProofOfWork *ProofOfWork `json:"proofOfWork"`
+	// Optional namespace of the CID
+	Namespace string `json:"namespace"`
+}
+
+// ProposeCIDReply is the reply from ProposeCID
+type ProposeCIDReply struct {
+	ProposeBlockReply
+	// Base 58 encoded data proposed, the digest of the CID
+	Data string `json:"data"`
+	// CID is the canonical encoding of the CID the block is tagged with
+	CID string `json:"cid"`
+}
+
+// ProposeCID proposes a block anchoring the digest of the IPFS content
+// [args.CID]. The block declares the digest algorithm of the CID and is
+// tagged with the CID, so the content can be found from the block. If an
+// IPFS API is configured, the content is pinned once the block is accepted.
+func (s *Service) ProposeCID(r *http.Request, args *ProposeCIDArgs, reply *ProposeCIDReply) error {
+	cid, err := ParseCID(args.CID)
+	if err != nil {
+		return err
+	}
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, cid.Digest[:])
+	if err != nil {
+		return err
+	}
+	reply.Data = data
+	reply.CID = cid.String()
+	return s.ProposeBlock(r, &ProposeBlockArgs{
+		Data:          data,
+		Signature:     args.Signature,
+		ProofOfWork:   args.ProofOfWork,
+		Namespace:     args.Namespace,
+		Tags:          map[string]string{cidTagKey: reply.CID},
+		HashAlgorithm: cid.HashAlgorithm,
+	}, &reply.ProposeBlockReply)
+}
+
+// GetBlockByCIDArgs are the arguments to GetBlockByCID
+type GetBlockByCIDArgs struct {
+	// CID of the content to look up
+	CID string `json:"cid"`
+}
+
+// GetBlockByCID gets the earliest accepted block anchoring the digest of
+// the IPFS content [args.CID], whether it was proposed as a CID or as a
+// digest computed with the algorithm of the CID
+func (s *Service) GetBlockByCID(r *http.Request, args *GetBlockByCIDArgs, reply *GetBlockReply) error {
+	cid, err := ParseCID(args.CID)
+	if err != nil {
+		return err
+	}
+	entry, err := s.vm.state.GetDataEntry(DataHash(cid.Digest))
+	if err == database.ErrNotFound {
+		return errDataNotAnchored
+	}
+	if err != nil {
+		return err
+	}
+	block, err := s.vm.getBlock(entry.BlkID)
+	if err != nil {
+		// The declaration was dropped along with the body
+		if s.vm.fillDroppedReply(entry.BlkID, reply) != nil {
+			return errNoSuchBlock
+		}
+		return nil
+	}
+	if declaredHashAlgorithm(block.Tags()) != cid.HashAlgorithm {
+		return errDataNotAnchored
+	}
+	if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+		return err
+	}
+	return fillBlockReply(block, reply)
+}
+
+// ProposeRedactionArgs are the arguments to ProposeRedaction
+type ProposeRedactionArgs struct {
+	Redaction
+	// Base 58 encoded signature of the redaction by the submitter of the
+	// redacted block or a redaction admin.
+	// See [RedactionMessage] for what must be signed.
+	Signature string `json:"signature"`
+}
+
+// ProposeRedaction proposes a block redacting the data of the accepted block
+// [args.BlkID] at [args.Height]. Once it's accepted, the redacted block is
+// only served as a header.
+func (s *Service) ProposeRedaction(r *http.Request, args *ProposeRedactionArgs, reply *ProposeBlockReply) error {
+	if !s.vm.config.RedactionsEnabled {
+		return errRedactionsDisabled
+	}
+	if err := s.checkProposing(); err != nil {
+		return err
+	}
+	redaction := args.Redaction
+	data, err := RedactionData(&redaction)
+	if err != nil {
+		return err
+	}
+	sub := &submission{
+		data:      data,
+		redaction: &redaction,
+	}
+	if r != nil {
+		sub.traceCtx = r.Context()
+	}
+	sub.sig, err = formatting.Decode(formatting.CB58, args.Signature)
+	if err != nil || len(sub.sig) == 0 {
+		return errBadSignatureEncoding
+	}
+	// Refuse redactions by others right away instead of failing to build
+	submitter, err := recoverSubmitter(s.vm.ctx.ChainID, data, "", nil, sub.sig)
+	if err != nil {
+		return err
+	}
+	if err := s.vm.verifyRedaction(requestContext(r), nil, &redaction, submitter); err != nil {
+		return err
+	}
+	s.vm.proposeSubmission(sub)
+	reply.Success = true
+	reply.Submitter = &submitter
+	return nil
+}
+
+// ProposeCommitmentArgs are the arguments to ProposeCommitment
+type ProposeCommitmentArgs struct {
+	// Commitment to anchor. Must be base 58 encoding of 32 bytes, see
+	// [Commitment].
+	Commitment string `json:"commitment"`
+	// Optional base 58 encoded signature of the commitment by its submitter.
+	// See [ScopedSubmissionMessage] for what must be signed.
+	Signature string `json:"signature"`
+	// Proof of work over the commitment, required on chains configured with
+	// [Config.ProofOfWorkBits]
+	ProofOfWork *ProofOfWork `json:"proofOfWork"`
+	// Optional namespace and tags of the commitment
+	Namespace string            `json:"namespace"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// ProposeCommitment proposes a block anchoring a commitment to a value which
+// is revealed later with ProposeReveal. Only the commitment is sent, so
+// neither the value nor the salt is disclosed before the reveal.
+func (s *Service) ProposeCommitment(r *http.Request, args *ProposeCommitmentArgs, reply *ProposeBlockReply) error {
+	return s.ProposeBlock(r, &ProposeBlockArgs{
+		Data:        args.Commitment,
+		Signature:   args.Signature,
+		ProofOfWork: args.ProofOfWork,
+		Namespace:   args.Namespace,
+		Tags:        args.Tags,
+	}, reply)
+}
+
+// ProposeRevealArgs are the arguments to ProposeReveal
+type ProposeRevealArgs struct {
+	// Salt of the commitment
+	Salt ids.ID `json:"salt"`
+	// Value committed to. Must be base 58 encoding of 32 bytes.
+	Value string `json:"value"`
+	// Optional base 58 encoded signature of the value by its submitter.
+	// See [ScopedSubmissionMessage] for what must be signed.
+	Signature string `json:"signature"`
+	// Proof of work over the value, required on chains configured with
+	// [Config.ProofOfWorkBits]
+	ProofOfWork *ProofOfWork `json:"proofOfWork"`
+	// Optional namespace and tags of the value
+	Namespace string            `json:"namespace"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// ProposeReveal proposes a block anchoring [args.Value] and revealing it as
+// the value of the accepted commitment it hashes to with [args.Salt]
+func (s *Service) ProposeReveal(r *http.Request, args *ProposeRevealArgs, reply *ProposeBlockReply) error {
+	value, err := parseData(args.Value)
+	if err != nil {
+		return err
+	}
+	// Values aren't records, so they can't be revealed in namespaces
+	// requiring records
+	if s.vm.recordSchemas[args.Namespace] != nil {
+		return errMissingRecord
+	}
+	// Refuse reveals of unknown or revealed commitments right away instead
+	// of failing to build
+	reveal := &Reveal{Salt: args.Salt}
+	if err := s.vm.verifyReveal(nil, reveal, value); err != nil {
+		return err
+	}
+	return s.proposeData(r, &ProposeBlockArgs{
+		Signature:   args.Signature,
+		ProofOfWork: args.ProofOfWork,
+		Namespace:   args.Namespace,
+		Tags:        args.Tags,
+	}, &submission{data: value, reveal: reveal}, reply)
+}
+
+// GetCommitmentArgs are the arguments to GetCommitment
+type GetCommitmentArgs struct {
+	// Commitment to look up. Must be base 58 encoding of 32 bytes.
+	Commitment string `json:"commitment"`
+}
+
+// GetCommitmentReply is the reply from GetCommitment
+type GetCommitmentReply struct {
+	// Status is [CommitmentCommitted] or [CommitmentRevealed]
+	Status string `json:"status"`
+	// Commitment is the earliest accepted block anchoring the commitment
+	Commitment GetBlockReply `json:"commitment"`
+	// Reveal is the accepted block revealing the value of the commitment,
+	// only set for revealed commitments
+	Reveal *GetBlockReply `json:"reveal,omitempty"`
+}
+
+// GetCommitment returns whether the commitment [args.Commitment] is anchored
+// and revealed, along with the blocks doing so
+func (s *Service) GetCommitment(r *http.Request, args *GetCommitmentArgs, reply *GetCommitmentReply) error {
+	commitment, err := parseData(args.Commitment)
+	if err != nil {
+		return err
+	}
+	commitmentHash := DataHash(commitment)
+	entry, err := s.vm.state.GetDataEntry(commitmentHash)
+	if err == database.ErrNotFound {
+		return errCommitmentUnknown
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.fillAcceptedReply(r, entry.BlkID, &reply.Commitment); err != nil {
+		return err
+	}
+	revealID, err := s.vm.state.GetReveal(commitmentHash)
+	switch err {
+	case nil:
+	case database.ErrNotFound:
+		reply.Status = CommitmentCommitted
+		return nil
+	default:
+		return err
+	}
+	reply.Status = CommitmentRevealed
+	reply.Reveal = &GetBlockReply{}
+	return s.fillAcceptedReply(r, revealID, reply.Reveal)
+}
+
+// RegisterRecipientKeyArgs are the arguments to RegisterRecipientKey
+type RegisterRecipientKeyArgs struct {
+	// Nonce of the registration, see [GetRecipientKeyReply.Nonce]
+	Nonce json.Uint64 `json:"nonce"`
+	// Base 58 encoded secp256k1 public key payloads are encrypted to
+	PublicKey string `json:"publicKey"`
+	// Base 58 encoded signature of the registration by the registering
+	// address. See [KeyRegistrationMessage] for what must be signed.
+	Signature string `json:"signature"`
+}
+
+// RegisterRecipientKey proposes a block registering [args.PublicKey] as the
+// key payloads are encrypted to for the address signing it, replacing the
+// key it registered before
+func (s *Service) RegisterRecipientKey(r *http.Request, args *RegisterRecipientKeyArgs, reply *ProposeBlockReply) error {
+	if !s.vm.config.EncryptedPayloadsEnabled {
+		return errEncryptedPayloadsDisabled
+	}
+	if err := s.checkProposing(); err != nil {
+		return err
+	}
+	publicKey, err := formatting.Decode(formatting.CB58, args.PublicKey)
+	if err != nil {
+		return errBadPublicKey
+	}
+	registration := &KeyRegistration{
+		Nonce:     uint64(args.Nonce),
+		PublicKey: publicKey,
+	}
+	data, err := KeyRegistrationData(registration)
+	if err != nil {
+		return err
+	}
+	sub := &submission{
+		data:   data,
+		keyReg: registration,
+	}
+	if r != nil {
+		sub.traceCtx = r.Context()
+	}
+	sub.sig, err = formatting.Decode(formatting.CB58, args.Signature)
+	if err != nil || len(sub.sig) == 0 {
+		return errBadSignatureEncoding
+	}
+	// Refuse stale registrations right away instead of failing to build
+	registrant, err := recoverSubmitter(s.vm.ctx.ChainID, data, "", nil, sub.sig)
+	if err != nil {
+		return err
+	}
+	if err := s.vm.verifyKeyRegistration(nil, registration, registrant); err != nil {
+		return err
+	}
+	s.vm.proposeSubmission(sub)
+	reply.Success = true
+	reply.Submitter = &registrant
+	return nil
+}
+
+// GetRecipientKeyArgs are the arguments to GetRecipientKey
+type GetRecipientKeyArgs struct {
+	Address ids.ShortID `json:"address"`
+}
+
+// GetRecipientKeyReply is the reply from GetRecipientKey
+type GetRecipientKeyReply struct {
+	// Nonce is the nonce of the next registration of the address
+	Nonce json.Uint64 `json:"nonce"`
+	// Base 58 encoded public key the address registered last
+	PublicKey string `json:"publicKey"`
+}
+
+// GetRecipientKey returns the public key [args.Address] registered, as of the
+// last accepted block
+func (s *Service) GetRecipientKey(_ *http.Request, args *GetRecipientKeyArgs, reply *GetRecipientKeyReply) error {
+	key, err := s.vm.state.GetRecipientKey(args.Address)
+	if err == database.ErrNotFound {
+		return fmt.Errorf("%w: %s", errRecipientNotRegistered, args.Address)
+	}
+	if err != nil {
+		return err
+	}
+	reply.Nonce = json.Uint64(key.Nonce)
+	reply.PublicKey, err = formatting.EncodeWithChecksum(formatting.CB58, key.PublicKey)
+	return err
+}
+
+// ProposeEncryptedPayloadArgs are the arguments to ProposeEncryptedPayload
+type ProposeEncryptedPayloadArgs struct {
+	// Recipients whose registered keys the payload is encrypted to
+	Recipients []ids.ShortID `json:"recipients"`
+	// Base 58 encoded ciphertext
+	Ciphertext string `json:"ciphertext"`
+	// Optional base 58 encoded signature of the hash of the payload by its
+	// submitter. See [EncryptedPayloadData] for the data signed.
+	Signature string `json:"signature"`
+	// Proof of work over the hash of the payload, required on chains
+	// configured with [Config.ProofOfWorkBits]
+	ProofOfWork *ProofOfWork `json:"proofOfWork"`
+	// Optional namespace and tags of the payload
+	Namespace string            `json:"namespace"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// ProposeEncryptedPayload proposes a block anchoring the hash of a payload
+// encrypted to [args.Recipients], which must have registered their keys. The
+// ciphertext is stored along with the block.
+func (s *Service) ProposeEncryptedPayload(r *http.Request, args *ProposeEncryptedPayloadArgs, reply *ProposeBlockReply) error {
+	if !s.vm.config.EncryptedPayloadsEnabled {
+		return errEncryptedPayloadsDisabled
+	}
+	ciphertext, err := formatting.Decode(formatting.CB58, args.Ciphertext)
+	if err != nil {
+		return errCiphertextSize
+	}
+	recipients := make([]ids.ShortID, len(args.Recipients))
+	copy(recipients, args.Recipients)
+	ids.SortShortIDs(recipients)
+	payload := &EncryptedPayload{
+		Recipients: recipients,
+		Ciphertext: ciphertext,
+	}
+	if err := payload.Verify(s.vm.config.MaxCiphertextSize); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if _, err := s.vm.state.GetRecipientKey(recipient); err == database.ErrNotFound {
+			return fmt.Errorf("%w: %s", errRecipientNotRegistered, recipient)
+		} else if err != nil {
+			return err
+		}
+	}
+	// Payloads aren't records, so they can't be anchored in namespaces
+	// requiring records
+	if s.vm.recordSchemas[args.Namespace] != nil {
+		return errMissingRecord
+	}
+	data, err := EncryptedPayloadData(payload)
+	if err != nil {
+		return err
+	}
+	return s.proposeData(r, &ProposeBlockArgs{
+		Signature:   args.Signature,
+		ProofOfWork: args.ProofOfWork,
+		Namespace:   args.Namespace,
+		Tags:        args.Tags,
+	}, &submission{data: data, encrypted: payload}, reply)
+}
+
+// GetCiphertextArgs are the arguments to GetCiphertext
+type GetCiphertextArgs struct {
+	// Data anchoring the encrypted payload. Must be base 58 encoding of 32
+	// bytes.
+	Data string `json:"data"`
+	// Base 58 encoded signature of the request by a recipient. See
+	// [CiphertextRequestMessage] for what must be signed.
+	Signature string `json:"signature"`
+}
+
+// GetCiphertextReply is the reply from GetCiphertext
+type GetCiphertextReply struct {
+	// BlockID is the ID of the earliest accepted block anchoring the payload
+	BlockID ids.ID `json:"blockID"`
+	// Recipients are the addresses the payload is encrypted to
+	Recipients []ids.ShortID `json:"recipients"`
+	// Base 58 encoded ciphertext
+	Ciphertext string `json:"ciphertext"`
+}
+
+// GetCiphertext returns the ciphertext of the encrypted payload anchored as
+// [args.Data] to one of its recipients
+func (s *Service) GetCiphertext(r *http.Request, args *GetCiphertextArgs, reply *GetCiphertextReply) error {
+	data, err := parseData(args.Data)
+	if err != nil {
+		return err
+	}
+	sig, err := formatting.Decode(formatting.CB58, args.Signature)
+	if err != nil || len(sig) == 0 {
+		return errBadSignatureEncoding
+	}
+	msg, err := CiphertextRequestMessage(s.vm.ctx.ChainID, data)
+	if err != nil {
+		return err
+	}
+	requester, err := secpFactory.RecoverPublicKey(msg, sig)
+	if err != nil {
+		return errBadSignature
+	}
+
+	entry, err := s.vm.state.GetDataEntry(DataHash(data))
+	if err == database.ErrNotFound {
+		return errDataNotAnchored
+	}
+	if err != nil {
+		return err
+	}
+	block, err := s.vm.getBlock(entry.BlkID)
+	if err != nil {
+		return errCiphertextDropped
+	}
+	if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+		return err
+	}
+	payload := block.EncryptedPayload()
+	if payload == nil {
+		return errNoEncryptedPayload
+	}
+	if !payload.includes(requester.Address()) {
+		return errNotRecipient
+	}
+	reply.BlockID = entry.BlkID
+	reply.Recipients = payload.Recipients
+	reply.Ciphertext, err = formatting.EncodeWithChecksum(formatting.CB58, payload.Ciphertext)
+	return err
+}
+
+// GetSubmitterAllowlistReply is the reply from GetSubmitterAllowlist
+type GetSubmitterAllowlistReply struct {
+	// Enabled is true if only submitters on the allowlist may anchor data
+	Enabled bool `json:"enabled"`
+	// Nonce is the nonce of the next update
+	Nonce json.Uint64 `json:"nonce"`
+	// Submitters are the addresses allowed to anchor data
+	Submitters []ids.ShortID `json:"submitters"`
+	// Admins are the addresses allowed to sign updates
+	Admins []ids.ShortID `json:"admins"`
+}
+
+// GetSubmitterAllowlist returns the submitter allowlist as of the last
+// accepted block
+func (s *Service) GetSubmitterAllowlist(_ *http.Request, _ *struct{}, reply *GetSubmitterAllowlistReply) error {
+	reply.Enabled = s.vm.config.SubmitterAllowlistEnabled
+	reply.Admins = s.vm.config.SubmitterAllowlistAdmins
+	nonce, err := s.vm.state.GetAllowlistNonce()
+	switch err {
+	case nil:
+	case database.ErrNotFound:
+		return nil // never initialized
+	default:
+		return err
+	}
+	reply.Nonce = json.Uint64(nonce)
+	reply.Submitters, err = s.vm.state.GetAllowlist()
+	return err
+}
+
+// GetBlockArgs are the arguments to GetBlock
+type GetBlockArgs struct {
+	// ID of the block we're getting.
+	// If left blank, gets the latest block
+	ID *ids.ID `json:"id"`
+}
+
+// GetBlockReply is the reply from GetBlock
+type GetBlockReply struct {
+	Timestamp json.Uint64 `json:"timestamp"` // Timestamp of most recent block
+	Data      string      `json:"data"`      // Data in the most recent block. Base 58 repr. of 5 bytes.
+	ID        ids.ID      `json:"id"`        // String repr. of ID of the most recent block
+	ParentID  ids.ID      `json:"parentID"`  // String repr. of ID of the most recent block's parent
+	Height    json.Uint64 `json:"height"`    // Height of the block
+
+	// Address of the submitter, only set for signed blocks
+	Submitter *ids.ShortID `json:"submitter,omitempty"`
+	// Update of the submitter allowlist, only set for blocks anchoring one
+	AllowlistUpdate *AllowlistUpdate `json:"allowlistUpdate,omitempty"`
+	// Transfer of funds, only set for blocks anchoring one
+	Transfer *Transfer `json:"transfer,omitempty"`
+	// Proof of work over the data, only set for blocks carrying one
+	ProofOfWork *ProofOfWork `json:"proofOfWork,omitempty"`
+	// Grant of prepaid credits, only set for blocks anchoring one
+	CreditGrant *CreditGrant `json:"creditGrant,omitempty"`
+	// Namespace of the data, only set for blocks having one
+	Namespace string `json:"namespace,omitempty"`
+	// Tags of the data by key, only set for tagged blocks
+	Tags map[string]string `json:"tags,omitempty"`
+	// Update of the payload schemas, only set for blocks anchoring one
+	SchemaUpdate *SchemaUpdate `json:"schemaUpdate,omitempty"`
+	// Redaction of an earlier block's data, only set for blocks anchoring one
+	Redaction *Redaction `json:"redaction,omitempty"`
+	// Reveal of an earlier commitment whose value is the data, only set for
+	// blocks revealing one
+	Reveal *Reveal `json:"reveal,omitempty"`
+	// Registration of a recipient key, only set for blocks anchoring one
+	KeyRegistration *KeyRegistration `json:"keyRegistration,omitempty"`
+	// Recipients of the encrypted payload whose hash is the data, only set
+	// for blocks anchoring one. The ciphertext is only served to them, see
+	// GetCiphertext.
+	EncryptedTo []ids.ShortID `json:"encryptedTo,omitempty"`
+	// BodyDropped is true if the body of the block is no longer stored, as
+	// it was redacted, expired or pruned. Only its header is served, the data
+	// and the other fields are left blank.
+	BodyDropped bool `json:"bodyDropped,omitempty"`
+	// ID of the block redacting this block, only set for redacted blocks
+	RedactedBy *ids.ID `json:"redactedBy,omitempty"`
+}
+
+// GetBlock gets the block whose ID is [args.ID]
+// If [args.ID] is empty, get the latest block
+func (s *Service) GetBlock(r *http.Request, args *GetBlockArgs, reply *GetBlockReply) error {
+	// If an ID is given, parse its string representation to an ids.ID
+	// If no ID is given, ID becomes the ID of last accepted block
+	var (
+		id  ids.ID
+		err error
+	)
+
+	if args.ID == nil {
+		id, err = s.vm.state.GetLastAccepted()
+		if err != nil {
+			return errCannotGetLastAccepted
+		}
+	} else {
+		id = *args.ID
+	}
+
+	// Get the block from the database
+	block, err := s.vm.getBlock(id)
+	if err != nil {
+		if s.vm.fillDroppedReply(id, reply) == nil {
+			return nil
+		}
+		return errNoSuchBlock
+	}
+	if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+		return err
+	}
+
+	return fillBlockReply(block, reply)
+}
+
+// GetBlockHeaderReply is the reply from GetBlockHeader
+type GetBlockHeaderReply struct {
+	ID        ids.ID         `json:"id"`
+	ParentID  ids.ID         `json:"parentID"`
+	Height    json.Uint64    `json:"height"`
+	Timestamp json.Uint64    `json:"timestamp"`
+	DataHash  ids.ID         `json:"dataHash"` // SHA-256 hash of the block's data
+	Status    choices.Status `json:"status"`
+}
+
+// GetBlockHeader gets the header of the block whose ID is [args.ID], which is
+// available even if the block's body was pruned. Callers restricted to
+// reading some namespaces only get the headers of blocks in them, or whose
+// body, and so namespace, is gone.
+// If [args.ID] is empty, get the header of the latest block
+func (s *Service) GetBlockHeader(r *http.Request, args *GetBlockArgs, reply *GetBlockHeaderReply) error {
+	var (
+		id  ids.ID
+		err error
+	)
+	if args.ID == nil {
+		id, err = s.vm.state.GetLastAccepted()
+		if err != nil {
+			return errCannotGetLastAccepted
+		}
+	} else {
+		id = *args.ID
+	}
 
-// ProposeBlock is an API method to propose a new block whose data is [args].Data.
-// [args].Data must be a string repr. of a 32 byte array
-func (s *Service) ProposeBlock(_ *http.Request, args *ProposeBlockArgs, reply *ProposeBlockReply) error {
-	bytes, err := formatting.Decode(formatting.CB58, args.Data)
-	if err != nil || len(bytes) != dataLen {
-		return errBadData
+	header, err := s.vm.getBlockHeader(id)
+	if err != nil {
+		return errNoSuchBlock
 	}
-	var data [dataLen]byte         // The data as an array of bytes
-	copy(data[:], bytes[:dataLen]) // Copy the bytes in dataSlice to data
-	s.vm.proposeBlock(data)
-	reply.Success = true
-	return nil
+	block, err := s.vm.getBlock(id)
+	switch err {
+	case nil:
+		if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+			return err
+		}
+	case database.ErrNotFound:
+	default:
+		return err
+	}
+	return fillBlockHeaderReply(id, header, reply)
 }
 
-// GetBlockArgs are the arguments to GetBlock
-type GetBlockArgs struct {
-	// ID of the block we're getting.
-	// If left blank, gets the latest block
-	ID *ids.ID `json:"id"`
+// fillBlockHeaderReply fills out [reply] with the header [header] of [id]
+func fillBlockHeaderReply(id ids.ID, header *BlockHeader, reply *GetBlockHeaderReply) error {
+	reply.ID = id
+	reply.ParentID = header.PrntID
+	reply.Height = json.Uint64(header.Hght)
+	reply.Timestamp = json.Uint64(header.Tmstmp)
+	reply.DataHash = header.DataHash
+	reply.Status = header.Status
+	return nil
 }
 
-// GetBlockReply is the reply from GetBlock
-type GetBlockReply struct {
-	Timestamp json.Uint64 `json:"timestamp"` // Timestamp of most recent block
-	Data      string      `json:"data"`      // Data in the most recent block. Base 58 repr. of 5 bytes.
-	ID        ids.ID      `json:"id"`        // String repr. of ID of the most recent block
-	ParentID  ids.ID      `json:"parentID"`  // String repr. of ID of the most recent block's parent
+// GetBlockBytesReply is the reply from GetBlockBytes
+type GetBlockBytesReply struct {
+	ID ids.ID `json:"id"`
+	// Hex encoded bytes of the block, with a checksum. The block's ID is
+	// their SHA-256 hash.
+	Bytes string `json:"bytes"`
 }
 
-// GetBlock gets the block whose ID is [args.ID]
-// If [args.ID] is empty, get the latest block
-func (s *Service) GetBlock(_ *http.Request, args *GetBlockArgs, reply *GetBlockReply) error {
-	// If an ID is given, parse its string representation to an ids.ID
-	// If no ID is given, ID becomes the ID of last accepted block
+// GetBlockBytes gets the bytes of the block whose ID is [args.ID], so it can
+// be verified offline, e.g. with the verify package. Blocks whose bodies
+// were dropped can't be served.
+// If [args.ID] is empty, get the bytes of the latest block
+func (s *Service) GetBlockBytes(r *http.Request, args *GetBlockArgs, reply *GetBlockBytesReply) error {
 	var (
 		id  ids.ID
 		err error
 	)
-
 	if args.ID == nil {
 		id, err = s.vm.state.GetLastAccepted()
 		if err != nil {
@@ -88,18 +1806,724 @@ func (s *Service) GetBlock(_ *http.Request, args *GetBlockArgs, reply *GetBlockR
 		id = *args.ID
 	}
 
-	// Get the block from the database
 	block, err := s.vm.getBlock(id)
 	if err != nil {
+		if _, err := s.vm.state.GetBlockHeader(id); err == nil {
+			return errBlockBodyDropped
+		}
+		return errNoSuchBlock
+	}
+	if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+		return err
+	}
+	reply.ID = id
+	reply.Bytes, err = formatting.EncodeWithChecksum(formatting.Hex, block.Bytes())
+	return err
+}
+
+// GetCertificateArgs are the arguments to GetCertificate
+type GetCertificateArgs struct {
+	// Data whose certificate to issue. Must be base 58 encoding of 32 bytes.
+	Data string `json:"data"`
+}
+
+// GetCertificateReply is the reply from GetCertificate
+type GetCertificateReply struct {
+	// Certificate encoded as canonical JSON. It's a string, so clients keep
+	// the exact bytes which are signed.
+	Certificate string `json:"certificate"`
+	// Base 58 encoded signature of the certificate by the node. It's a
+	// recoverable secp256k1 signature of the SHA-256 hash of the
+	// certificate, as checked by verify.VerifyCertificate.
+	Signature string `json:"signature"`
+	// Signer is the address of the node's certificate key
+	Signer ids.ShortID `json:"signer"`
+	// Base 58 encoded signature of the certificate by the co-signer, in the
+	// same format, only set if one is configured
+	CoSignature string `json:"coSignature,omitempty"`
+	// CoSigner is the address of the co-signer's key, only set if one is
+	// configured
+	CoSigner *ids.ShortID `json:"coSigner,omitempty"`
+}
+
+// GetCertificate issues a proof-of-existence certificate of [args.Data],
+// signed by this node, for handing to third parties. It names the earliest
+// accepted block anchoring the data and holds the block's bytes along with
+// instructions for verifying it, see [Certificate]. If a co-signer is
+// configured, the certificate is also signed by it. Blocks whose bodies were
+// dropped can't be certified.
+func (s *Service) GetCertificate(r *http.Request, args *GetCertificateArgs, reply *GetCertificateReply) error {
+	if s.vm.certificateKey == nil {
+		return errCertificatesDisabled
+	}
+	data, err := parseData(args.Data)
+	if err != nil {
+		return err
+	}
+	entry, err := s.vm.state.GetDataEntry(DataHash(data))
+	if err == database.ErrNotFound {
+		return errDataNotAnchored
+	}
+	if err != nil {
+		return err
+	}
+	block, err := s.vm.getBlock(entry.BlkID)
+	if err != nil {
+		return errBlockBodyDropped
+	}
+	if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+		return err
+	}
+
+	certificate, signature, err := s.vm.issueCertificate(block)
+	if err != nil {
+		return err
+	}
+	reply.Certificate = string(certificate)
+	reply.Signer = s.vm.certificateKey.PublicKey().Address()
+	reply.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, signature)
+	if err != nil || s.vm.coSigner == nil {
+		return err
+	}
+
+	coSignature, err := s.vm.coSigner.CoSign(s.vm.ctx.ChainID, certificate)
+	if err != nil {
+		return err
+	}
+	coSigner := s.vm.coSigner.address
+	reply.CoSigner = &coSigner
+	reply.CoSignature, err = formatting.EncodeWithChecksum(formatting.CB58, coSignature)
+	return err
+}
+
+// GetExternalAnchorArgs are the arguments to GetExternalAnchor
+type GetExternalAnchorArgs struct {
+	// ID of the accepted block whose external anchor to get
+	ID ids.ID `json:"id"`
+}
+
+// GetExternalAnchor returns the external transaction holding the ID of the
+// accepted block [args.ID] or of its earliest published descendant. As each
+// block commits to its parent's ID, the transaction proves the block existed
+// by the time it was included in the external chain; the blocks linking it
+// to the published block can be fetched with GetBlockBytes.
+func (s *Service) GetExternalAnchor(_ *http.Request, args *GetExternalAnchorArgs, reply *ExternalAnchor) error {
+	if s.vm.externalAnchorer == nil {
+		return errExternalAnchorsDisabled
+	}
+	header, err := s.vm.state.GetBlockHeader(args.ID)
+	if err != nil {
+		return errNoSuchBlock
+	}
+	if acceptedID, err := s.vm.state.GetAcceptedID(header.Hght); err != nil || acceptedID != args.ID {
+		return errNoSuchBlock
+	}
+	anchor, err := s.vm.state.GetExternalAnchor(header.Hght)
+	if err == database.ErrNotFound {
+		return errNoExternalAnchor
+	}
+	if err != nil {
+		return err
+	}
+	*reply = *anchor
+	return nil
+}
+
+// GetBlockByDataArgs are the arguments to GetBlockByData
+type GetBlockByDataArgs struct {
+	// Data to look up. Must be base 58 encoding of 32 bytes.
+	Data string `json:"data"`
+}
+
+// GetBlockByData gets the earliest accepted block whose data is [args.Data]
+func (s *Service) GetBlockByData(r *http.Request, args *GetBlockByDataArgs, reply *GetBlockReply) error {
+	data, err := parseData(args.Data)
+	if err != nil {
+		return err
+	}
+
+	entry, err := s.vm.state.GetDataEntry(DataHash(data))
+	if err == database.ErrNotFound {
+		return errDataNotAnchored
+	}
+	if err != nil {
+		return err
+	}
+
+	block, err := s.vm.getBlock(entry.BlkID)
+	if err != nil {
+		if s.vm.fillDroppedReply(entry.BlkID, reply) == nil {
+			return nil
+		}
+		return errNoSuchBlock
+	}
+	if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+		return err
+	}
+
+	return fillBlockReply(block, reply)
+}
+
+// GetInclusionProofArgs are the arguments to GetInclusionProof
+type GetInclusionProofArgs struct {
+	// Data to prove. Must be base 58 encoding of 32 bytes.
+	Data string `json:"data"`
+	// Optional ID of the accepted block the proof leads to, the block
+	// anchoring the data or a descendant. Defaults to the anchoring block.
+	RootID ids.ID `json:"rootID"`
+}
+
+// GetInclusionProofReply is the reply from GetInclusionProof
+type GetInclusionProofReply struct {
+	// BlockID is the ID of the earliest accepted block anchoring the data
+	BlockID ids.ID `json:"blockID"`
+	// RootID is the root the proof computes
+	RootID ids.ID `json:"rootID"`
+	// Proof that the big-endian encoded timestamp of the block maps to the
+	// data under the root
+	Proof *CommitmentProof `json:"proof"`
+	// Hex encoded protobuf encoding of the proof
+	ProofBytes string `json:"proofBytes"`
+}
+
+// GetInclusionProof returns an ICS23 existence proof that [args.Data] was
+// anchored at the timestamp of the earliest accepted block anchoring it, so
+// verifiers using ICS23 libraries, e.g. IBC light clients, check it without
+// custom code. The proof's root is the ID of an accepted block, see
+// [VM.inclusionProof], which the verifier must trust. Blocks whose bodies
+// were dropped can't be proven.
+func (s *Service) GetInclusionProof(r *http.Request, args *GetInclusionProofArgs, reply *GetInclusionProofReply) error {
+	data, err := parseData(args.Data)
+	if err != nil {
+		return err
+	}
+	entry, err := s.vm.state.GetDataEntry(DataHash(data))
+	if err == database.ErrNotFound {
+		return errDataNotAnchored
+	}
+	if err != nil {
+		return err
+	}
+	block, err := s.vm.getBlock(entry.BlkID)
+	if err != nil {
+		return errNoSuchBlock
+	}
+	if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+		return err
+	}
+
+	rootID := args.RootID
+	if rootID == ids.Empty {
+		rootID = block.ID()
+	}
+	header, err := s.vm.state.GetBlockHeader(rootID)
+	if err != nil {
+		return errNoSuchBlock
+	}
+	if acceptedID, err := s.vm.state.GetAcceptedID(header.Hght); err != nil || acceptedID != rootID {
 		return errNoSuchBlock
 	}
+	proof, err := s.vm.inclusionProof(block, rootID)
+	if err != nil {
+		return err
+	}
+	reply.BlockID = block.ID()
+	reply.RootID = rootID
+	reply.Proof = proof
+	reply.ProofBytes = hex.EncodeToString(proof.Bytes())
+	return nil
+}
+
+// GetBlocksBySubmitterArgs are the arguments to GetBlocksBySubmitter
+type GetBlocksBySubmitterArgs struct {
+	Submitter   ids.ShortID `json:"submitter"`
+	StartHeight json.Uint64 `json:"startHeight"`
+	// Maximum number of blocks to return, at most [maxPageSize].
+	// If left blank, [maxPageSize] blocks are returned.
+	Limit json.Uint32 `json:"limit"`
+}
+
+// GetBlocksReply is the reply from paginated calls returning blocks
+type GetBlocksReply struct {
+	Blocks []GetBlockReply `json:"blocks"`
+}
+
+// GetBlocksBySubmitter gets the accepted blocks signed by [args.Submitter],
+// in height order, starting at [args.StartHeight]. Callers restricted to
+// reading namespaces are refused if a block isn't in one of theirs.
+func (s *Service) GetBlocksBySubmitter(r *http.Request, args *GetBlocksBySubmitterArgs, reply *GetBlocksReply) error {
+	blkIDs, err := s.vm.state.GetSubmitterBlockIDs(requestContext(r), args.Submitter, uint64(args.StartHeight), pageSize(args.Limit))
+	if err != nil {
+		return err
+	}
+	if err := fillBlocksReply(requestContext(r), s.vm, blkIDs, reply); err != nil {
+		return err
+	}
+	for _, block := range reply.Blocks {
+		if err := s.vm.authorizeNamespaceRead(r, block.Namespace); err != nil {
+			reply.Blocks = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByNamespaceArgs are the arguments to GetByNamespace
+type GetByNamespaceArgs struct {
+	Namespace  string      `json:"namespace"`
+	FromHeight json.Uint64 `json:"fromHeight"`
+	// Maximum number of blocks to return, at most [maxPageSize].
+	// If left blank, [maxPageSize] blocks are returned.
+	Limit json.Uint32 `json:"limit"`
+}
+
+// GetByNamespaceReply is the reply from GetByNamespace and GetByTag
+type GetByNamespaceReply struct {
+	// Blocks anchoring data in the namespace, in height order
+	Blocks []GetBlockReply `json:"blocks"`
+	// NextHeight is the height the next page starts at, only set if there
+	// are more blocks
+	NextHeight *json.Uint64 `json:"nextHeight,omitempty"`
+}
+
+// GetByNamespace gets the accepted blocks anchoring data in
+// [args.Namespace], in height order, starting at [args.FromHeight]
+func (s *Service) GetByNamespace(r *http.Request, args *GetByNamespaceArgs, reply *GetByNamespaceReply) error {
+	if args.Namespace == "" {
+		return errMissingNamespace
+	}
+	if err := verifyNamespace(args.Namespace); err != nil {
+		return err
+	}
+	if err := s.vm.authorizeNamespaceRead(r, args.Namespace); err != nil {
+		return err
+	}
+	limit := pageSize(args.Limit)
+	// look one block ahead to tell if there's another page
+	blkIDs, err := s.vm.state.GetNamespaceBlockIDs(requestContext(r), args.Namespace, uint64(args.FromHeight), limit+1)
+	if err != nil {
+		return err
+	}
+	return fillBlocksPage(requestContext(r), s.vm, blkIDs, limit, reply)
+}
+
+// GetByTagArgs are the arguments to GetByTag
+type GetByTagArgs struct {
+	// Namespace the blocks are in, empty for blocks without a namespace
+	Namespace  string      `json:"namespace"`
+	Key        string      `json:"key"`
+	Value      string      `json:"value"`
+	FromHeight json.Uint64 `json:"fromHeight"`
+	// Maximum number of blocks to return, at most [maxPageSize].
+	// If left blank, [maxPageSize] blocks are returned.
+	Limit json.Uint32 `json:"limit"`
+}
+
+// GetByTag gets the accepted blocks of [args.Namespace] tagged
+// [args.Key]=[args.Value], in height order, starting at [args.FromHeight]
+func (s *Service) GetByTag(r *http.Request, args *GetByTagArgs, reply *GetByNamespaceReply) error {
+	if err := verifyNamespace(args.Namespace); err != nil {
+		return err
+	}
+	tag := Tag{Key: args.Key, Value: args.Value}
+	if err := verifyTag(tag); err != nil {
+		return err
+	}
+	if err := s.vm.authorizeNamespaceRead(r, args.Namespace); err != nil {
+		return err
+	}
+	limit := pageSize(args.Limit)
+	// look one block ahead to tell if there's another page
+	blkIDs, err := s.vm.state.GetTagBlockIDs(requestContext(r), args.Namespace, tag, uint64(args.FromHeight), limit+1)
+	if err != nil {
+		return err
+	}
+	return fillBlocksPage(requestContext(r), s.vm, blkIDs, limit, reply)
+}
+
+// GetBlockStatsArgs are the arguments to GetBlockStats
+type GetBlockStatsArgs struct {
+	// Window is the period, up to now, the statistics cover. Defaults to an
+	// hour.
+	Window Duration `json:"window"`
+}
+
+// GetBlockStats gets statistics of the intervals between the blocks accepted
+// within [args.Window] and of the latencies of building and accepting blocks
+// on this node
+func (s *Service) GetBlockStats(r *http.Request, args *GetBlockStatsArgs, reply *BlockStats) error {
+	window := args.Window.Duration
+	if window == 0 {
+		window = defaultStatsWindow
+	}
+	stats, err := s.vm.blockStats(requestContext(r), window)
+	if err != nil {
+		return err
+	}
+	*reply = *stats
+	return nil
+}
+
+// GetChainGrowthArgs are the arguments to GetChainGrowth
+type GetChainGrowthArgs struct {
+	// Interval the blocks are counted by, "hour" or "day"
+	Interval string `json:"interval"`
+	// Start is the unix time to start counting at. Defaults to 24 intervals
+	// before [End].
+	Start json.Uint64 `json:"start"`
+	// End is the unix time to stop counting at. Defaults to now.
+	End json.Uint64 `json:"end"`
+}
+
+// GetChainGrowthReply is the reply from GetChainGrowth
+type GetChainGrowthReply struct {
+	Buckets []GrowthBucket `json:"buckets"`
+}
+
+// GetChainGrowth gets the number of blocks accepted per [args.Interval]
+// between [args.Start] and [args.End], including intervals without blocks
+func (s *Service) GetChainGrowth(r *http.Request, args *GetChainGrowthArgs, reply *GetChainGrowthReply) error {
+	length, err := intervalLength(args.Interval)
+	if err != nil {
+		return err
+	}
+	end := int64(args.End)
+	if end == 0 {
+		end = time.Now().Unix()
+	}
+	start := int64(args.Start)
+	if start == 0 {
+		start = end - (defaultGrowthBuckets-1)*length
+	}
+	buckets, err := s.vm.chainGrowth(requestContext(r), args.Interval, start, end)
+	reply.Buckets = buckets
+	return err
+}
+
+// GetBlockSummariesArgs are the arguments to GetBlockSummaries
+type GetBlockSummariesArgs struct {
+	// BeforeHeight is the height to summarize the blocks below. If left
+	// blank, the blocks up to the last accepted block are summarized.
+	BeforeHeight json.Uint64 `json:"beforeHeight"`
+	// Maximum number of blocks to summarize, at most [maxSummaries].
+	// If left blank, [maxSummaries] blocks are summarized.
+	Limit json.Uint32 `json:"limit"`
+}
+
+// GetBlockSummariesReply is the reply from GetBlockSummaries
+type GetBlockSummariesReply struct {
+	// Blocks are the summaries of the blocks, highest first
+	Blocks []BlockSummary `json:"blocks"`
+	// NextHeight is the BeforeHeight of the next page, only set if there are
+	// more blocks
+	NextHeight *json.Uint64 `json:"nextHeight,omitempty"`
+}
+
+// GetBlockSummaries gets summaries of the accepted blocks below
+// [args.BeforeHeight], highest first, as explorers list them: with a preview
+// of what they anchor and the calls returning proofs of their data. Blocks
+// in namespaces the caller may not read are summarized by their headers.
+func (s *Service) GetBlockSummaries(r *http.Request, args *GetBlockSummariesArgs, reply *GetBlockSummariesReply) error {
+	limit := int(args.Limit)
+	if limit == 0 || limit > maxSummaries {
+		limit = maxSummaries
+	}
+	beforeHeight := uint64(args.BeforeHeight)
+	if beforeHeight == 0 {
+		lastAccepted, err := s.vm.lastAcceptedBlock()
+		if err != nil {
+			return err
+		}
+		beforeHeight = lastAccepted.Height() + 1
+	}
+	blocks, nextHeight, err := s.vm.blockSummaries(requestContext(r), r, beforeHeight, limit)
+	if err != nil {
+		return err
+	}
+	reply.Blocks = blocks
+	reply.NextHeight = nextHeight
+	return nil
+}
+
+// HeadSummary is the state of the chain as explorers show it
+type HeadSummary struct {
+	ChainID ids.ID `json:"chainID"`
+	// LastAccepted is the summary of the last accepted block
+	LastAccepted BlockSummary `json:"lastAccepted"`
+	// Pending is the number of submissions waiting for a block on this node
+	Pending json.Uint64 `json:"pending"`
+	// PrunedHeight is the height of the first accepted block which isn't
+	// pruned, ignoring the genesis block
+	PrunedHeight json.Uint64 `json:"prunedHeight"`
+	// BlocksLastHour is the number of blocks accepted within the last hour
+	BlocksLastHour json.Uint64 `json:"blocksLastHour"`
+}
+
+// GetHeadSummary gets the last accepted block and the state of the chain,
+// as explorers show it on their landing page
+func (s *Service) GetHeadSummary(r *http.Request, _ *struct{}, reply *HeadSummary) error {
+	lastAccepted, err := s.vm.lastAcceptedBlock()
+	if err != nil {
+		return err
+	}
+	summary, err := s.vm.blockSummary(r, lastAccepted.ID())
+	if err != nil {
+		return err
+	}
+	prunedHeight, err := s.vm.state.GetPrunedHeight()
+	if err != nil {
+		return err
+	}
+	blocks, _, _, _, err := s.vm.recentActivity(requestContext(r), r, time.Now().Add(-time.Hour), 0)
+	if err != nil {
+		return err
+	}
+	reply.ChainID = s.vm.ctx.ChainID
+	reply.LastAccepted = *summary
+	reply.Pending = json.Uint64(s.vm.mempool.Len())
+	reply.PrunedHeight = json.Uint64(prunedHeight)
+	reply.BlocksLastHour = json.Uint64(blocks)
+	return nil
+}
+
+// GetRecentActivityArgs are the arguments to GetRecentActivity
+type GetRecentActivityArgs struct {
+	// Window is the period, up to now, the activity covers. Defaults to an
+	// hour.
+	Window Duration `json:"window"`
+	// Maximum number of namespaces and submitters to return, at most
+	// [maxActivityEntries]. Defaults to [defaultActivityEntries].
+	Limit json.Uint32 `json:"limit"`
+}
+
+// RecentActivity is the activity on the chain within a window
+type RecentActivity struct {
+	Window Duration `json:"window"`
+	// Blocks is the number of blocks accepted within the window
+	Blocks json.Uint64 `json:"blocks"`
+	// Truncated is true if the window holds more blocks than are inspected,
+	// in which case only the latest blocks are counted
+	Truncated bool `json:"truncated"`
+	// Namespaces and Submitters are the ones with the most blocks within the
+	// window, most first
+	Namespaces []ActivityCount `json:"namespaces"`
+	Submitters []ActivityCount `json:"submitters"`
+}
+
+// GetRecentActivity gets the number of blocks accepted within [args.Window]
+// and the namespaces and submitters anchoring the most of them. Blocks in
+// namespaces the caller may not read are only counted in the total.
+func (s *Service) GetRecentActivity(r *http.Request, args *GetRecentActivityArgs, reply *RecentActivity) error {
+	window := args.Window.Duration
+	if window == 0 {
+		window = defaultStatsWindow
+	}
+	if window < 0 {
+		return errNonPositiveWindow
+	}
+	entries := int(args.Limit)
+	switch {
+	case entries == 0:
+		entries = defaultActivityEntries
+	case entries > maxActivityEntries:
+		entries = maxActivityEntries
+	}
+	blocks, truncated, namespaces, submitters, err := s.vm.recentActivity(requestContext(r), r, time.Now().Add(-window), entries)
+	if err != nil {
+		return err
+	}
+	reply.Window = Duration{window}
+	reply.Blocks = json.Uint64(blocks)
+	reply.Truncated = truncated
+	reply.Namespaces = namespaces
+	reply.Submitters = submitters
+	return nil
+}
+
+// GetProofOfWorkReply is the reply from GetProofOfWork
+type GetProofOfWorkReply struct {
+	// Enabled is true if submissions require a proof of work
+	Enabled bool `json:"enabled"`
+	// Bits is the number of zero bits the hash of a proof of work must
+	// start with
+	Bits json.Uint64 `json:"bits"`
+	// MaxAge is the number of most recent blocks before a block its proof of
+	// work may be based on
+	MaxAge json.Uint64 `json:"maxAge"`
+	// RecentID is the preferred block, which proofs of work proposed now
+	// should be based on
+	RecentID ids.ID `json:"recentID"`
+}
+
+// GetProofOfWork returns what a proof of work proposed now must satisfy
+func (s *Service) GetProofOfWork(_ *http.Request, _ *struct{}, reply *GetProofOfWorkReply) error {
+	reply.Enabled = s.vm.config.ProofOfWorkBits > 0
+	reply.Bits = json.Uint64(s.vm.config.ProofOfWorkBits)
+	reply.MaxAge = json.Uint64(s.vm.config.ProofOfWorkMaxAge)
+	reply.RecentID = s.vm.preferred
+	return nil
+}
+
+// GetChainInfoReply is the reply from GetChainInfo
+type GetChainInfoReply struct {
+	NodeID    ids.ShortID `json:"nodeID"`
+	NetworkID json.Uint32 `json:"networkID"`
+	SubnetID  ids.ID      `json:"subnetID"`
+	ChainID   ids.ID      `json:"chainID"`
+	// ChainAlias is the primary alias of the chain, used in its API paths.
+	// It's the chain ID if the chain has no alias.
+	ChainAlias string `json:"chainAlias"`
+	// VMName and VMVersion identify the VM running the chain
+	VMName    string `json:"vmName"`
+	VMVersion string `json:"vmVersion"`
+}
+
+// GetChainInfo gets the node and chain this API is served by
+func (s *Service) GetChainInfo(_ *http.Request, _ *struct{}, reply *GetChainInfoReply) error {
+	ctx := s.vm.ctx
+	reply.NodeID = ctx.NodeID
+	reply.NetworkID = json.Uint32(ctx.NetworkID)
+	reply.SubnetID = ctx.SubnetID
+	reply.ChainID = ctx.ChainID
+	reply.ChainAlias = ctx.ChainID.String()
+	if ctx.BCLookup != nil {
+		if alias, err := ctx.BCLookup.PrimaryAlias(ctx.ChainID); err == nil {
+			reply.ChainAlias = alias
+		}
+	}
+	reply.VMName = Name
+	reply.VMVersion = Version.String()
+	return nil
+}
+
+// pageSize returns the number of items to return for the requested [limit]
+func pageSize(limit json.Uint32) int {
+	if limit == 0 || limit > maxPageSize {
+		return maxPageSize
+	}
+	return int(limit)
+}
+
+// fillBlocksReply fills out [reply] with the blocks [blkIDs], unless [ctx] is
+// canceled meanwhile
+func fillBlocksReply(ctx context.Context, vm *VM, blkIDs []ids.ID, reply *GetBlocksReply) error {
+	reply.Blocks = make([]GetBlockReply, len(blkIDs))
+	for i, blkID := range blkIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		block, err := vm.getBlock(blkID)
+		if err != nil {
+			if vm.fillDroppedReply(blkID, &reply.Blocks[i]) == nil {
+				continue
+			}
+			return errNoSuchBlock
+		}
+		if err := fillBlockReply(block, &reply.Blocks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillBlocksPage fills out [reply] with the first [limit] blocks of [blkIDs].
+// If there are more, the page ends with the height of the next block.
+func fillBlocksPage(ctx context.Context, vm *VM, blkIDs []ids.ID, limit int, reply *GetByNamespaceReply) error {
+	more := len(blkIDs) > limit
+	if more {
+		blkIDs = blkIDs[:limit]
+	}
+	page := GetBlocksReply{}
+	if err := fillBlocksReply(ctx, vm, blkIDs, &page); err != nil {
+		return err
+	}
+	reply.Blocks = page.Blocks
+	if more {
+		nextHeight := page.Blocks[len(page.Blocks)-1].Height + 1
+		reply.NextHeight = &nextHeight
+	}
+	return nil
+}
 
-	// Fill out the response with the block's data
+// fillBlockReply fills out [reply] with [block]'s data
+func fillBlockReply(block *Block, reply *GetBlockReply) error {
 	reply.ID = block.ID()
 	reply.Timestamp = json.Uint64(block.Timestamp().Unix())
 	reply.ParentID = block.Parent()
+	reply.Height = json.Uint64(block.Height())
+	if block.IsSigned() {
+		submitter, err := block.Submitter()
+		if err != nil {
+			return err
+		}
+		reply.Submitter = &submitter
+	}
+	reply.AllowlistUpdate = block.AllowlistUpdate()
+	reply.Transfer = block.Transfer()
+	reply.ProofOfWork = block.ProofOfWork()
+	reply.CreditGrant = block.CreditGrant()
+	reply.Namespace = block.Namespace()
+	reply.Tags = tagMap(block.Tags())
+	reply.SchemaUpdate = block.SchemaUpdate()
+	reply.Redaction = block.Redaction()
+	reply.Reveal = block.Reveal()
+	reply.KeyRegistration = block.KeyRegistration()
+	if payload := block.EncryptedPayload(); payload != nil {
+		reply.EncryptedTo = payload.Recipients
+	}
 	data := block.Data()
+	var err error
 	reply.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
-
 	return err
 }
+
+// fillAcceptedReply fills out [reply] with the accepted block [blkID], or with
+// its header if its body was dropped
+func (s *Service) fillAcceptedReply(r *http.Request, blkID ids.ID, reply *GetBlockReply) error {
+	block, err := s.vm.getBlock(blkID)
+	switch {
+	case err == nil:
+		if err := s.vm.authorizeNamespaceRead(r, block.Namespace()); err != nil {
+			return err
+		}
+		return fillBlockReply(block, reply)
+	case s.vm.fillDroppedReply(blkID, reply) != nil:
+		return errNoSuchBlock
+	}
+	return nil
+}
+
+// fillDroppedReply fills out [reply] with the header of the block [blkID],
+// whose body was dropped. Returns database.ErrNotFound if the header was
+// dropped too.
+func (vm *VM) fillDroppedReply(blkID ids.ID, reply *GetBlockReply) error {
+	header, err := vm.state.GetBlockHeader(blkID)
+	if err != nil {
+		return err
+	}
+	reply.ID = blkID
+	reply.ParentID = header.PrntID
+	reply.Height = json.Uint64(header.Hght)
+	reply.Timestamp = json.Uint64(header.Tmstmp)
+	reply.BodyDropped = true
+	redactionID, err := vm.state.GetRedaction(blkID)
+	switch err {
+	case nil:
+		reply.RedactedBy = &redactionID
+	case database.ErrNotFound:
+	default:
+		return err
+	}
+	return nil
+}
+
+// parseData parses the base 58 repr. of 32 bytes of data
+func parseData(dataStr string) ([dataLen]byte, error) {
+	var data [dataLen]byte // The data as an array of bytes
+	bytes, err := formatting.Decode(formatting.CB58, dataStr)
+	if err != nil || len(bytes) != dataLen {
+		return data, errBadData
+	}
+	copy(data[:], bytes[:dataLen]) // Copy the bytes in dataSlice to data
+	return data, nil
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+var errShutdownTimeout = errors.New("shutdown timed out")
+
+// savedSubmission is the stored form of a submission pending on shutdown
+type savedSubmission struct {
+	Data [dataLen]byte `serialize:"true"`
+	Sig  []byte        `serialize:"true"`
+	// ProposedAt is the time, in unix nanoseconds, the submission was first
+	// proposed to this node
+	ProposedAt int64 `serialize:"true"`
+	// Update is the allowlist update whose hash is [Data], only present in
+	// submissions of updates
+	Update *AllowlistUpdate `serializeAllowlist:"true"`
+	// Transfer is the transfer whose hash is [Data], only present in
+	// submissions of transfers
+	Transfer *Transfer `serializeTransfer:"true"`
+	// CreditGrant is the grant of credits whose hash is [Data], only present
+	// in submissions of grants
+	CreditGrant *CreditGrant `serializeCreditGrant:"true"`
+	// SchemaUpdate is the update of the payload schemas whose hash is
+	// [Data], only present in submissions of schema updates
+	SchemaUpdate *SchemaUpdate `serializeSchema:"true"`
+	// Redaction is the redaction of an earlier block whose hash is [Data],
+	// only present in submissions of redactions
+	Redaction *Redaction `serializeRedaction:"true"`
+	// Reveal is the reveal of an earlier commitment whose value is [Data],
+	// only present in submissions of reveals
+	Reveal *Reveal `serializeReveal:"true"`
+	// KeyRegistration is the registration of a recipient key whose hash is
+	// [Data], only present in submissions of key registrations
+	KeyRegistration *KeyRegistration `serializeKeyRegistration:"true"`
+	// EncryptedPayload is the encrypted payload whose hash is [Data], only
+	// present in submissions of encrypted payloads
+	EncryptedPayload *EncryptedPayload `serializeEncrypted:"true"`
+	// ProofOfWork is the proof of work over [Data], only present in
+	// submissions to chains requiring one
+	ProofOfWork *ProofOfWork `serializeProofOfWork:"true"`
+	// Namespace is the namespace of [Data], only present in submissions to a
+	// namespace
+	Namespace string `serializeNamespace:"true"`
+	// Tags are the tags of [Data], only present in tagged submissions
+	Tags []Tag `serializeTags:"true"`
+}
+
+// shutdown saves or drops the mempool, commits and closes the database
+func (vm *VM) shutdown() error {
+	errs := wrappers.Errs{}
+	if pending := vm.mempool.Len(); pending > 0 {
+		if vm.config.PersistMempool {
+			vm.ctx.Log.Info("saving %d pending submissions", pending)
+			errs.Add(vm.saveMempool())
+		} else {
+			vm.ctx.Log.Warn("dropping %d pending submissions", pending)
+		}
+	}
+	errs.Add(
+		vm.committer.Flush(), // commit the saved mempool and the blocks accepted since the last commit
+		vm.state.Close(),     // close versionDB
+		vm.closeDatabase(),   // close the database unless it's the node's
+	)
+	if vm.auditLog != nil {
+		errs.Add(vm.auditLog.Close())
+	}
+	if vm.otlpTracer != nil {
+		// spans which can't be exported don't fail the shutdown
+		if err := vm.otlpTracer.Flush(); err != nil {
+			vm.ctx.Log.Warn("couldn't export spans: %s", err)
+		}
+	}
+	return errs.Err
+}
+
+// saveMempool stores the pending submissions, to be committed
+func (vm *VM) saveMempool() error {
+	if err := vm.state.DeleteSavedSubmissions(); err != nil {
+		return err
+	}
+	for i, sub := range vm.mempool.pending {
+		// Encoded as the block anchoring it, whose codec version serializes
+		// the same optional fields
+		subBytes, err := Codec.Marshal(sub.block().codecVersion(), &savedSubmission{
+			Data:             sub.data,
+			Sig:              sub.sig,
+			ProposedAt:       sub.proposedAt.UnixNano(),
+			Update:           sub.update,
+			Transfer:         sub.transfer,
+			CreditGrant:      sub.grant,
+			SchemaUpdate:     sub.schema,
+			Redaction:        sub.redaction,
+			Reveal:           sub.reveal,
+			KeyRegistration:  sub.keyReg,
+			EncryptedPayload: sub.encrypted,
+			ProofOfWork:      sub.pow,
+			Namespace:        sub.namespace,
+			Tags:             sub.tags,
+		})
+		if err != nil {
+			return err
+		}
+		if err := vm.state.SaveSubmission(uint64(i), subBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreMempool proposes the submissions saved on the last shutdown again,
+// unless their data was anchored in the meantime
+func (vm *VM) restoreMempool() error {
+	saved, err := vm.state.GetSavedSubmissions()
+	if err != nil || len(saved) == 0 {
+		return err
+	}
+
+	restored := 0
+	for _, subBytes := range saved {
+		savedSub := savedSubmission{}
+		if _, err := Codec.Unmarshal(subBytes, &savedSub); err != nil {
+			return err
+		}
+		switch _, err := vm.state.GetDataEntry(DataHash(savedSub.Data)); err {
+		case nil:
+			continue // already anchored
+		case database.ErrNotFound:
+		default:
+			return err
+		}
+		sub := &submission{
+			data:      savedSub.Data,
+			sig:       savedSub.Sig,
+			update:    savedSub.Update,
+			transfer:  savedSub.Transfer,
+			grant:     savedSub.CreditGrant,
+			schema:    savedSub.SchemaUpdate,
+			redaction: savedSub.Redaction,
+			reveal:    savedSub.Reveal,
+			keyReg:    savedSub.KeyRegistration,
+			encrypted: savedSub.EncryptedPayload,
+			pow:       savedSub.ProofOfWork,
+			namespace: savedSub.Namespace,
+			tags:      savedSub.Tags,
+		}
+		vm.proposeSubmission(sub)
+		sub.proposedAt = time.Unix(0, savedSub.ProposedAt)
+		restored++
+	}
+	vm.ctx.Log.Info("restored %d of %d submissions pending on the last shutdown", restored, len(saved))
+
+	if err := vm.state.DeleteSavedSubmissions(); err != nil {
+		return err
+	}
+	return vm.state.Commit()
+}
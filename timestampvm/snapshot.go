@@ -0,0 +1,428 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+// A snapshot is a tar archive holding the key/value pairs of the database in
+// chunks, each one a sequence of records:
+//
+//	uvarint(len(key)) | key | uvarint(len(value)) | value
+//
+// The metadata entry comes last and counts the records for verification.
+const (
+	snapshotMetadataName = "metadata.json"
+	snapshotChunkPrefix  = "chunk-"
+	// size at which a chunk is written to the archive
+	snapshotChunkSize = 4 * 1024 * 1024
+	// maximum length of a key or value in a snapshot
+	maxSnapshotRecordLen = snapshotChunkSize
+)
+
+var (
+	errNoSnapshotDir     = errors.New("snapshot directory must be set")
+	errBadSnapshot       = errors.New("invalid snapshot")
+	errSnapshotAborted   = errors.New("snapshot aborted by shutdown")
+	errSnapshotRecordLen = errors.New("snapshot record is too long")
+)
+
+// SnapshotInfo describes the state captured by a snapshot
+type SnapshotInfo struct {
+	// Height of the last accepted block
+	Height json.Uint64 `json:"height"`
+	// LastAccepted is the ID of the last accepted block
+	LastAccepted ids.ID `json:"lastAccepted"`
+	// Created is when the snapshot was taken
+	Created time.Time `json:"created"`
+	// Records is the number of key/value pairs in the snapshot
+	Records json.Uint64 `json:"records"`
+}
+
+// SnapshotStatus reports the progress of writing a snapshot to disk
+type SnapshotStatus struct {
+	// Running is true while a snapshot is being written
+	Running bool `json:"running"`
+	// Path is the file the current (or last) snapshot is written to
+	Path string `json:"path"`
+	// Snapshot describes the current (or last) snapshot. Records is only
+	// known once it's written.
+	Snapshot SnapshotInfo `json:"snapshot"`
+	// LastError is the error which aborted the last snapshot, if any
+	LastError string `json:"lastError,omitempty"`
+}
+
+// snapshotter writes snapshots of the database to disk in the background
+type snapshotter struct {
+	vm *VM
+
+	lock   sync.Mutex
+	status SnapshotStatus
+}
+
+// newSnapshotter returns a snapshotter for [vm]
+func newSnapshotter(vm *VM) *snapshotter {
+	return &snapshotter{vm: vm}
+}
+
+// Status returns the progress of writing a snapshot
+func (s *snapshotter) Status() SnapshotStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.status
+}
+
+// Trigger starts writing a snapshot of the current state into [dir].
+// Returns the path of the snapshot file.
+// Must be called holding the context lock.
+func (s *snapshotter) Trigger(dir string) (string, error) {
+	if dir == "" {
+		return "", errNoSnapshotDir
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.status.Running {
+		return "", errJobRunning
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	it, info, err := s.vm.openSnapshot()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, snapshotFileName(info))
+	s.status = SnapshotStatus{
+		Running:  true,
+		Path:     path,
+		Snapshot: info,
+	}
+	go s.write(path, it, info)
+	return path, nil
+}
+
+// write writes the snapshot read from [it] to [path]
+func (s *snapshotter) write(path string, it database.Iterator, info SnapshotInfo) {
+	info, err := s.vm.writeSnapshotFile(path, it, info)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.status.Running = false
+	s.status.Snapshot = info
+	if err != nil {
+		s.status.LastError = err.Error()
+		s.vm.ctx.Log.Warn("writing snapshot to %s failed: %s", path, err)
+		return
+	}
+	s.vm.ctx.Log.Info("wrote snapshot of height %d to %s", info.Height, path)
+}
+
+// snapshotFileName returns the name of the file the snapshot described by
+// [info] is written to
+func snapshotFileName(info SnapshotInfo) string {
+	return fmt.Sprintf("snapshot-%d-%d.tar", info.Height, info.Created.Unix())
+}
+
+// openSnapshot returns an iterator over the current content of the database
+// and a description of it. Must be called holding the context lock.
+// The iterator keeps reading the content it was opened on while the chain
+// keeps running.
+func (vm *VM) openSnapshot() (database.Iterator, SnapshotInfo, error) {
+	// Every change of the state but grouped accepts is committed before the
+	// context lock is released, so once these are committed the database
+	// underneath the state is consistent.
+	if err := vm.committer.Flush(); err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+	info := SnapshotInfo{
+		Height:       json.Uint64(lastAccepted.Height()),
+		LastAccepted: lastAccepted.ID(),
+		Created:      time.Now().UTC(),
+	}
+	return vm.db.NewIterator(), info, nil
+}
+
+// writeSnapshotFile writes the snapshot read from [it] to [path]. The file
+// only appears at [path] once it's complete.
+func (vm *VM) writeSnapshotFile(path string, it database.Iterator, info SnapshotInfo) (SnapshotInfo, error) {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		it.Release()
+		return info, err
+	}
+	info, err = vm.writeSnapshot(file, it, info)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return info, err
+	}
+	return info, os.Rename(tmpPath, path)
+}
+
+// writeSnapshot writes the snapshot read from [it] as tar archive to [w] and
+// releases [it]. Returns [info] with the number of records written.
+func (vm *VM) writeSnapshot(w io.Writer, it database.Iterator, info SnapshotInfo) (SnapshotInfo, error) {
+	defer it.Release()
+
+	tw := tar.NewWriter(w)
+	chunk := bytes.Buffer{}
+	chunks := 0
+	writeEntry := func(name string, content []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o600,
+			Size:    int64(len(content)),
+			ModTime: info.Created,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+	flushChunk := func() error {
+		if chunk.Len() == 0 {
+			return nil
+		}
+		err := writeEntry(fmt.Sprintf("%s%06d", snapshotChunkPrefix, chunks), chunk.Bytes())
+		chunk.Reset()
+		chunks++
+		return err
+	}
+
+	records := uint64(0)
+	lenBytes := make([]byte, binary.MaxVarintLen64)
+	for it.Next() {
+		// The database is closed once the VM shut down
+		if vm.isShutdown() {
+			return info, errSnapshotAborted
+		}
+		for _, field := range [][]byte{it.Key(), it.Value()} {
+			n := binary.PutUvarint(lenBytes, uint64(len(field)))
+			chunk.Write(lenBytes[:n])
+			chunk.Write(field)
+		}
+		records++
+		if chunk.Len() >= snapshotChunkSize {
+			if err := flushChunk(); err != nil {
+				return info, err
+			}
+		}
+	}
+	if err := it.Error(); err != nil {
+		return info, err
+	}
+	if err := flushChunk(); err != nil {
+		return info, err
+	}
+
+	info.Records = json.Uint64(records)
+	metadata, err := stdjson.Marshal(info)
+	if err != nil {
+		return info, err
+	}
+	if err := writeEntry(snapshotMetadataName, metadata); err != nil {
+		return info, err
+	}
+	return info, tw.Close()
+}
+
+// serveSnapshot streams a snapshot of the current state as tar archive
+func (vm *VM) serveSnapshot(w http.ResponseWriter, _ *http.Request) {
+	vm.ctx.Lock.Lock()
+	it, info, err := vm.openSnapshot()
+	vm.ctx.Lock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", snapshotFileName(info)))
+	if _, err := vm.writeSnapshot(w, it, info); err != nil {
+		// The status was already sent, so the client notices the truncated
+		// archive
+		vm.ctx.Log.Warn("streaming snapshot failed: %s", err)
+	}
+}
+
+// restoreSnapshot loads the snapshot at [path] into the empty database.
+// Databases which aren't empty are left untouched, so the VM can keep being
+// started with the same config once the snapshot was restored.
+func (vm *VM) restoreSnapshot(path string) error {
+	db := vm.db
+	empty, err := isEmpty(db)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		vm.ctx.Log.Info("database isn't empty, skipping restore of snapshot %s", path)
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := RestoreSnapshot(db, file)
+	if err != nil {
+		// Remove what was restored so far, so the restore is retried on the
+		// next start
+		if clearErr := clearDatabase(db); clearErr != nil {
+			vm.ctx.Log.Error("couldn't clear partially restored database: %s", clearErr)
+		}
+		return fmt.Errorf("couldn't restore snapshot %s: %w", path, err)
+	}
+	vm.ctx.Log.Info("restored snapshot of height %d (block %s) from %s", info.Height, info.LastAccepted, path)
+	return nil
+}
+
+// RestoreSnapshot writes the snapshot read from [r] into [db]
+func RestoreSnapshot(db database.Database, r io.Reader) (SnapshotInfo, error) {
+	tr := tar.NewReader(r)
+	batch := db.NewBatch()
+	records := uint64(0)
+	var info *SnapshotInfo
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return SnapshotInfo{}, err
+		}
+
+		switch {
+		case header.Name == snapshotMetadataName:
+			info = &SnapshotInfo{}
+			if err := stdjson.NewDecoder(tr).Decode(info); err != nil {
+				return SnapshotInfo{}, err
+			}
+		case strings.HasPrefix(header.Name, snapshotChunkPrefix):
+			chunkRecords, err := restoreChunk(batch, bufio.NewReader(tr))
+			if err != nil {
+				return SnapshotInfo{}, err
+			}
+			records += chunkRecords
+		default:
+			return SnapshotInfo{}, fmt.Errorf("%w: unexpected entry %q", errBadSnapshot, header.Name)
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if info == nil {
+		return SnapshotInfo{}, fmt.Errorf("%w: missing %s", errBadSnapshot, snapshotMetadataName)
+	}
+	if uint64(info.Records) != records {
+		return SnapshotInfo{}, fmt.Errorf("%w: expected %d records but found %d", errBadSnapshot, info.Records, records)
+	}
+	return *info, nil
+}
+
+// restoreChunk writes the records read from [r] into [batch], writing the
+// batch whenever it grows too large. Returns the number of records read.
+func restoreChunk(batch database.Batch, r *bufio.Reader) (uint64, error) {
+	records := uint64(0)
+	for {
+		key, err := readSnapshotField(r)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		value, err := readSnapshotField(r)
+		if err == io.EOF {
+			return 0, fmt.Errorf("%w: truncated record", errBadSnapshot)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := batch.Put(key, value); err != nil {
+			return 0, err
+		}
+		records++
+
+		if batch.Size() >= snapshotChunkSize {
+			if err := batch.Write(); err != nil {
+				return 0, err
+			}
+			batch.Reset()
+		}
+	}
+}
+
+// readSnapshotField reads a length prefixed key or value from [r]
+func readSnapshotField(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxSnapshotRecordLen {
+		return nil, errSnapshotRecordLen
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, fmt.Errorf("%w: truncated record", errBadSnapshot)
+	}
+	return field, nil
+}
+
+// clearDatabase deletes all keys of [db]
+func clearDatabase(db database.Database) error {
+	it := db.NewIterator()
+	defer it.Release()
+
+	batch := db.NewBatch()
+	for it.Next() {
+		if err := batch.Delete(it.Key()); err != nil {
+			return err
+		}
+		if batch.Size() >= snapshotChunkSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
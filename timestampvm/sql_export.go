@@ -0,0 +1,282 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+// exportCursor names the row of the cursor table holding the height of the
+// next block to export
+const exportCursor = "blocks"
+
+// sqlTarget describes a database accepted blocks are exported to by a
+// sqlExporter
+type sqlTarget struct {
+	// name is the name of the database in logs
+	name string
+	// metricsPrefix prefixes the names of the metrics of the export
+	metricsPrefix string
+	// tables are the statements creating the tables, unless they exist
+	tables []string
+	// prefix qualifies the names of the tables in queries, e.g. with a schema
+	prefix    string
+	batchSize int
+	interval  time.Duration
+}
+
+// sqlExporter streams the accepted blocks, with their tags, into the tables
+// of a SQL database, so they're queried with SQL instead of with a custom
+// indexer. The height of the next block to export is kept in the same
+// database and updated in the same transaction as the blocks, so the export
+// resumes where it left off. Without a cursor, all accepted blocks are
+// backfilled from genesis. Blocks are upserted, so they can be exported
+// again, see [sqlExporter.Backfill].
+type sqlExporter struct {
+	vm     *VM
+	db     *sql.DB
+	target sqlTarget
+	// wakes the exporter up early
+	wake chan struct{}
+
+	lock   sync.Mutex
+	status ExportStatus
+	// height the export restarts from, nil unless a backfill was requested
+	backfillHeight *uint64
+
+	height   prometheus.Gauge
+	failures prometheus.Counter
+}
+
+// newSQLExporter returns the sqlExporter of [vm] writing to [db], described
+// by [target], reporting metrics to [registerer]
+func newSQLExporter(vm *VM, db *sql.DB, target sqlTarget, registerer prometheus.Registerer) (*sqlExporter, error) {
+	e := &sqlExporter{
+		vm:     vm,
+		db:     db,
+		target: target,
+		wake:   make(chan struct{}, 1),
+		height: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: target.metricsPrefix + "_height",
+			Help: "height of the next block to export to " + target.name,
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: target.metricsPrefix + "_failures",
+			Help: "# of exports to " + target.name + " which failed",
+		}),
+	}
+	errs := wrappers.Errs{}
+	errs.Add(
+		registerer.Register(e.height),
+		registerer.Register(e.failures),
+	)
+	return e, errs.Err
+}
+
+// Status returns the state of the export
+func (e *sqlExporter) Status() ExportStatus {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.status
+}
+
+// Backfill exports the accepted blocks again, starting at [height], e.g. once
+// the tables were truncated
+func (e *sqlExporter) Backfill(height uint64) {
+	e.lock.Lock()
+	e.backfillHeight = &height
+	e.lock.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runPeriodically exports the blocks accepted since the last export right
+// away and then every [target.interval] until the VM shuts down
+func (e *sqlExporter) runPeriodically() {
+	defer e.db.Close()
+
+	ticker := time.NewTicker(e.target.interval)
+	defer ticker.Stop()
+
+	initialized := false
+	for {
+		err := error(nil)
+		if !initialized {
+			err = e.createTables()
+			initialized = err == nil
+		}
+		if err == nil {
+			err = e.export()
+		}
+		e.lock.Lock()
+		e.status.CheckedAt = time.Now()
+		e.status.LastError = ""
+		if err != nil {
+			e.status.LastError = err.Error()
+		}
+		e.lock.Unlock()
+		if err != nil {
+			e.failures.Inc()
+			e.vm.ctx.Log.Warn("couldn't export blocks to %s: %s", e.target.name, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-e.wake:
+		case <-e.vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// createTables creates the tables, unless they exist
+func (e *sqlExporter) createTables() error {
+	for _, statement := range e.target.tables {
+		if _, err := e.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// export exports the blocks accepted since the last export, in batches of
+// [target.batchSize] blocks. The database is written to without holding the
+// context lock.
+func (e *sqlExporter) export() error {
+	e.lock.Lock()
+	backfillHeight := e.backfillHeight
+	e.lock.Unlock()
+
+	if backfillHeight != nil {
+		if err := e.setCursor(e.db, *backfillHeight); err != nil {
+			return err
+		}
+		e.lock.Lock()
+		// Unless another backfill was requested meanwhile
+		if e.backfillHeight == backfillHeight {
+			e.backfillHeight = nil
+		}
+		e.lock.Unlock()
+	}
+	nextHeight := uint64(0)
+	err := e.db.QueryRow(
+		fmt.Sprintf(`SELECT next_height FROM %scursor WHERE name = $1`, e.target.prefix),
+		exportCursor,
+	).Scan(&nextHeight)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	for {
+		blocks, err := e.nextBlocks(nextHeight)
+		if err != nil || len(blocks) == 0 {
+			return err
+		}
+		nextHeight = blocks[len(blocks)-1].Height + 1
+		if err := e.write(blocks, nextHeight); err != nil {
+			return err
+		}
+
+		e.height.Set(float64(nextHeight))
+		e.lock.Lock()
+		e.status.NextHeight = nextHeight
+		e.status.Exported += uint64(len(blocks))
+		e.lock.Unlock()
+	}
+}
+
+// nextBlocks returns the next batch of accepted blocks starting at [height],
+// none if the VM shut down
+func (e *sqlExporter) nextBlocks(height uint64) ([]*exportedBlock, error) {
+	e.vm.ctx.Lock.Lock()
+	defer e.vm.ctx.Lock.Unlock()
+
+	if e.vm.isShutdown() {
+		return nil, nil
+	}
+	return e.vm.exportedBlocks(height, e.target.batchSize)
+}
+
+// write upserts [blocks] and moves the cursor to [nextHeight] in a single
+// transaction
+func (e *sqlExporter) write(blocks []*exportedBlock, nextHeight uint64) error {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	prefix := e.target.prefix
+	for _, blk := range blocks {
+		var submitter interface{}
+		if blk.Submitter != nil {
+			submitter = blk.Submitter.String()
+		}
+		_, err := tx.Exec(
+			fmt.Sprintf(`INSERT INTO %sblocks (height, id, parent_id, timestamp, data, namespace, submitter, hash_algorithm, body_dropped)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				ON CONFLICT (height) DO UPDATE SET id = EXCLUDED.id, parent_id = EXCLUDED.parent_id,
+					timestamp = EXCLUDED.timestamp, data = EXCLUDED.data, namespace = EXCLUDED.namespace,
+					submitter = EXCLUDED.submitter, hash_algorithm = EXCLUDED.hash_algorithm,
+					body_dropped = EXCLUDED.body_dropped`, prefix),
+			int64(blk.Height), blk.ID.String(), blk.ParentID.String(), blk.Timestamp.UTC(),
+			blk.Data, nullString(blk.Namespace), submitter, nullString(blk.HashAlgorithm), blk.BodyDropped,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %stags WHERE height = $1`, prefix), int64(blk.Height)); err != nil {
+			return err
+		}
+		for _, tag := range blk.Tags {
+			_, err := tx.Exec(
+				fmt.Sprintf(`INSERT INTO %stags (height, key, value) VALUES ($1, $2, $3)`, prefix),
+				int64(blk.Height), tag.Key, tag.Value,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := e.setCursor(tx, nextHeight); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlExecer is implemented by both databases and transactions
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// setCursor sets the height of the next block to export to [nextHeight]
+func (e *sqlExporter) setCursor(db sqlExecer, nextHeight uint64) error {
+	_, err := db.Exec(
+		fmt.Sprintf(`INSERT INTO %scursor (name, next_height) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET next_height = EXCLUDED.next_height`, e.target.prefix),
+		exportCursor, int64(nextHeight),
+	)
+	return err
+}
+
+// nullString returns [s], or NULL if it's empty
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
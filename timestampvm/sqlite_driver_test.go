@@ -0,0 +1,73 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+//go:build sqlite
+// +build sqlite
+
+package timestampvm
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	// the driver plugins built with the sqlite tag link
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteDriver(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "mirror.db")
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"sqlitePath": %q, "sqliteInterval": "1h"}`, path)))
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return vm.sqliteMirror.Status().NextHeight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+	data := hashing.ComputeHash256Array([]byte{1})
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: "docs", Tags: map[string]string{"k": "v"}}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	// blocks are upserted when backfilled again
+	vm.sqliteMirror.Backfill(0)
+	assert.Eventually(func() bool {
+		status := vm.sqliteMirror.Status()
+		return status.NextHeight == 2 && status.Exported == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(vm.sqliteMirror.Status().LastError)
+	assert.NoError(vm.Shutdown())
+
+	db, err := sql.Open("sqlite3", path)
+	assert.NoError(err)
+	defer db.Close()
+	blocks := 0
+	assert.NoError(db.QueryRow(`SELECT COUNT(*) FROM blocks`).Scan(&blocks))
+	assert.Equal(2, blocks)
+	var id, namespace, value string
+	var mirroredData []byte
+	assert.NoError(db.QueryRow(
+		`SELECT b.id, b.namespace, b.data, t.value FROM blocks b JOIN tags t USING (height) WHERE t.key = $1`,
+		"k",
+	).Scan(&id, &namespace, &mirroredData, &value))
+	assert.Equal(blk.ID().String(), id)
+	assert.Equal("docs", namespace)
+	assert.Equal(data[:], mirroredData)
+	assert.Equal("v", value)
+	nextHeight := 0
+	assert.NoError(db.QueryRow(`SELECT next_height FROM cursor WHERE name = $1`, exportCursor).Scan(&nextHeight))
+	assert.Equal(2, nextHeight)
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errSQLiteMirrorDisabled = errors.New("SQLite mirror is disabled")
+	errSQLiteBatchSize      = errors.New("sqliteBatchSize must be positive")
+	errNoSQLiteDriver       = errors.New("sqliteDriver isn't registered, the plugin links the sqlite3 driver if built with the sqlite tag")
+
+	// sqliteTables are the statements creating the tables of the mirror,
+	// laid out like the tables of the PostgreSQL export
+	sqliteTables = []string{
+		`CREATE TABLE IF NOT EXISTS blocks (
+			height INTEGER PRIMARY KEY,
+			id TEXT NOT NULL UNIQUE,
+			parent_id TEXT NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			data BLOB,
+			namespace TEXT,
+			submitter TEXT,
+			hash_algorithm TEXT,
+			body_dropped BOOLEAN NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS blocks_data ON blocks (data)`,
+		`CREATE INDEX IF NOT EXISTS blocks_namespace ON blocks (namespace, height)`,
+		`CREATE INDEX IF NOT EXISTS blocks_submitter ON blocks (submitter, height)`,
+		`CREATE TABLE IF NOT EXISTS tags (
+			height INTEGER NOT NULL REFERENCES blocks ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (height, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS tags_key_value ON tags (key, value, height)`,
+		`CREATE TABLE IF NOT EXISTS cursor (
+			name TEXT PRIMARY KEY,
+			next_height INTEGER NOT NULL
+		)`,
+	}
+)
+
+// newSQLiteMirror returns the sqlExporter keeping a SQLite database file on
+// the node in sync with the headers and the tags of the accepted blocks of
+// [vm], so operators run ad-hoc SQL against the chain, e.g. with the sqlite3
+// shell, without running a database server, reporting metrics to
+// [registerer], or nil if the mirror isn't configured. The mirror is only
+// written by the VM and is rebuilt from genesis if the file is deleted while
+// the VM is stopped.
+func newSQLiteMirror(vm *VM, registerer prometheus.Registerer) (*sqlExporter, error) {
+	config := &vm.config
+	if config.SQLitePath == "" {
+		return nil, nil
+	}
+	if !isSQLDriver(config.SQLiteDriver) {
+		return nil, fmt.Errorf("%w: %q", errNoSQLiteDriver, config.SQLiteDriver)
+	}
+	db, err := sql.Open(config.SQLiteDriver, config.SQLitePath)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, concurrent connections would only wait
+	// for each other
+	db.SetMaxOpenConns(1)
+	return newSQLExporter(vm, db, sqlTarget{
+		name:          "SQLite",
+		metricsPrefix: "sqlite_mirror",
+		tables:        sqliteTables,
+		batchSize:     config.SQLiteBatchSize,
+		interval:      config.SQLiteInterval.Duration,
+	}, registerer)
+}
+
+// isSQLDriver returns true if a database/sql driver is registered as [name]
+func isSQLDriver(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
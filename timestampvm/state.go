@@ -15,6 +15,12 @@
 package timestampvm
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/cache"
+	"github.com/chain4travel/caminogo/cache/metercacher"
 	"github.com/chain4travel/caminogo/database"
 	"github.com/chain4travel/caminogo/database/prefixdb"
 	"github.com/chain4travel/caminogo/database/versiondb"
@@ -24,46 +30,241 @@ import (
 var (
 	// These are prefixes for db keys.
 	// It's important to set different prefixes for each separate database objects.
-	singletonStatePrefix = []byte("singleton")
-	blockStatePrefix     = []byte("block")
+	singletonStatePrefix  = []byte("singleton")
+	blockStatePrefix      = []byte("block")
+	blockHeaderPrefix     = []byte("header")
+	acceptedLogPrefix     = []byte("accepted")
+	dataIndexPrefix       = []byte("data")
+	childIndexPrefix      = []byte("child")
+	submitterIndexPrefix  = []byte("submitter")
+	archiveManifestPrefix = []byte("archive")
+	jobProgressPrefix     = []byte("progress")
+	dataFilterPrefix      = []byte("dataFilter")
+	savedMempoolPrefix    = []byte("mempool")
+	allowlistPrefix       = []byte("allowlist")
+	accountPrefix         = []byte("account")
+	freeUsagePrefix       = []byte("freeUsage")
+	namespaceIndexPrefix  = []byte("namespace")
+	tagIndexPrefix        = []byte("tag")
+	schemaPrefix          = []byte("schema")
+	redactionPrefix       = []byte("redaction")
+	revealIndexPrefix     = []byte("reveal")
+	recipientKeyPrefix    = []byte("recipientKey")
+	externalAnchorPrefix  = []byte("externalAnchor")
+	chainHeadIndexPrefix  = []byte("chainHead")
 
 	_ State = &state{}
+
+	errUnknownPrefix = errors.New("unknown state prefix")
 )
 
-// State is a wrapper around avax.SingleTonState and BlockState
+// State is a wrapper around avax.SingleTonState, BlockState and the indexes
+// maintained for accepted blocks
 // State also exposes a few methods needed for managing database commits and close.
+// The methods iterating the database stop once their context is canceled.
 type State interface {
 	// SingletonState is defined in avalanchego,
 	// it is used to understand if db is initialized already.
 	avax.SingletonState
 	BlockState
+	AcceptedLog
+	DataIndex
+	ChildIndex
+	SubmitterIndex
+	NamespaceIndex
+	TagIndex
+	ArchiveManifest
+	JobProgress
+	SavedMempool
+	SubmitterAllowlist
+	Accounts
+	FreeUsages
+	SchemaRegistry
+	Redactions
+	RevealIndex
+	RecipientKeys
+	ExternalAnchors
+	ChainHeadIndex
+	IndexStatus
+
+	// Stater and Compacter are forwarded to the underlying database
+	database.Stater
+	database.Compacter
+
+	// ScanUsage counts up to [limit] keys stored under the prefix named
+	// [prefix], starting at [start], and the bytes of their keys and values.
+	// Returns the key to continue at, or nil once all keys are counted.
+	ScanUsage(ctx context.Context, prefix string, start []byte, limit int) (keys uint64, size uint64, next []byte, err error)
 
 	Commit() error
+	// Abort discards all operations since the last commit
+	Abort()
 	Close() error
 }
 
+// cacheFlusher is implemented by state components caching database values,
+// which have to be dropped when uncommitted operations are discarded
+type cacheFlusher interface {
+	flushCache()
+}
+
 type state struct {
 	avax.SingletonState
 	BlockState
+	AcceptedLog
+	DataIndex
+	ChildIndex
+	SubmitterIndex
+	NamespaceIndex
+	TagIndex
+	ArchiveManifest
+	JobProgress
+	SavedMempool
+	SubmitterAllowlist
+	Accounts
+	FreeUsages
+	SchemaRegistry
+	Redactions
+	RevealIndex
+	RecipientKeys
+	ExternalAnchors
+	ChainHeadIndex
+	IndexStatus
 
 	baseDB *versiondb.Database
+	// the prefixed databases of the components, by prefix
+	prefixDBs map[string]database.Database
 }
 
-func NewState(db database.Database, vm *VM) State {
+func NewState(db database.Database, vm *VM) (State, error) {
 	// create a new baseDB
 	baseDB := versiondb.New(db)
 
 	// create a prefixed "blockDB" from baseDB
 	blockDB := prefixdb.New(blockStatePrefix, baseDB)
+	// create a prefixed "headerDB" from baseDB
+	headerDB := prefixdb.New(blockHeaderPrefix, baseDB)
 	// create a prefixed "singletonDB" from baseDB
 	singletonDB := prefixdb.New(singletonStatePrefix, baseDB)
+	// create a prefixed "acceptedLogDB" from baseDB
+	acceptedLogDB := prefixdb.New(acceptedLogPrefix, baseDB)
+	// create a prefixed "dataIndexDB" from baseDB
+	dataIndexDB := prefixdb.New(dataIndexPrefix, baseDB)
+	// create a prefixed "dataFilterDB" from baseDB
+	dataFilterDB := prefixdb.New(dataFilterPrefix, baseDB)
+	// create a prefixed "childIndexDB" from baseDB
+	childIndexDB := prefixdb.New(childIndexPrefix, baseDB)
+	// create a prefixed "submitterIndexDB" from baseDB
+	submitterIndexDB := prefixdb.New(submitterIndexPrefix, baseDB)
+	// create a prefixed "namespaceIndexDB" from baseDB
+	namespaceIndexDB := prefixdb.New(namespaceIndexPrefix, baseDB)
+	// create a prefixed "tagIndexDB" from baseDB
+	tagIndexDB := prefixdb.New(tagIndexPrefix, baseDB)
+	// create a prefixed "archiveManifestDB" from baseDB
+	archiveManifestDB := prefixdb.New(archiveManifestPrefix, baseDB)
+	// create a prefixed "jobProgressDB" from baseDB
+	jobProgressDB := prefixdb.New(jobProgressPrefix, baseDB)
+	// create a prefixed "savedMempoolDB" from baseDB
+	savedMempoolDB := prefixdb.New(savedMempoolPrefix, baseDB)
+	// create a prefixed "allowlistDB" from baseDB
+	allowlistDB := prefixdb.New(allowlistPrefix, baseDB)
+	// create a prefixed "accountDB" from baseDB
+	accountDB := prefixdb.New(accountPrefix, baseDB)
+	// create a prefixed "freeUsageDB" from baseDB
+	freeUsageDB := prefixdb.New(freeUsagePrefix, baseDB)
+	// create a prefixed "schemaDB" from baseDB
+	schemaDB := prefixdb.New(schemaPrefix, baseDB)
+	// create a prefixed "redactionDB" from baseDB
+	redactionDB := prefixdb.New(redactionPrefix, baseDB)
+	// create a prefixed "revealIndexDB" from baseDB
+	revealIndexDB := prefixdb.New(revealIndexPrefix, baseDB)
+	// create a prefixed "recipientKeyDB" from baseDB
+	recipientKeyDB := prefixdb.New(recipientKeyPrefix, baseDB)
+	// create a prefixed "externalAnchorDB" from baseDB
+	externalAnchorDB := prefixdb.New(externalAnchorPrefix, baseDB)
+	// create a prefixed "chainHeadIndexDB" from baseDB
+	chainHeadIndexDB := prefixdb.New(chainHeadIndexPrefix, baseDB)
+
+	blockState, err := NewBlockState(blockDB, headerDB, vm)
+	if err != nil {
+		return nil, err
+	}
+	blkIDCache, err := metercacher.New(
+		"block_id_cache",
+		vm.registry,
+		&cache.LRU{Size: vm.config.BlockIDCacheSize},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dataIndex := NewDataIndex(dataIndexDB)
+	// A read-only replica can't rebuild the filter, so it reads the index
+	if vm.config.DataFilterCapacity > 0 && !vm.config.ReadOnly {
+		dataIndex, err = NewFilteredDataIndex(
+			dataIndexDB,
+			dataFilterDB,
+			vm.config.DataFilterCapacity,
+			vm.config.DataFilterFalsePositiveRate,
+		)
+		if err != nil {
+			return nil, err
+		}
+		// persist the filter, in case it was rebuilt
+		if err := baseDB.Commit(); err != nil {
+			return nil, err
+		}
+	}
 
 	// return state with created sub state components
 	return &state{
-		BlockState:     NewBlockState(blockDB, vm),
-		SingletonState: avax.NewSingletonState(singletonDB),
-		baseDB:         baseDB,
-	}
+		BlockState:         blockState,
+		SingletonState:     avax.NewSingletonState(singletonDB),
+		AcceptedLog:        NewAcceptedLog(acceptedLogDB, blkIDCache),
+		DataIndex:          dataIndex,
+		ChildIndex:         NewChildIndex(childIndexDB),
+		SubmitterIndex:     NewSubmitterIndex(submitterIndexDB),
+		NamespaceIndex:     NewNamespaceIndex(namespaceIndexDB),
+		TagIndex:           NewTagIndex(tagIndexDB),
+		ArchiveManifest:    NewArchiveManifest(archiveManifestDB),
+		JobProgress:        NewJobProgress(jobProgressDB),
+		SavedMempool:       NewSavedMempool(savedMempoolDB),
+		SubmitterAllowlist: NewSubmitterAllowlist(allowlistDB),
+		Accounts:           NewAccounts(accountDB),
+		FreeUsages:         NewFreeUsages(freeUsageDB),
+		SchemaRegistry:     NewSchemaRegistry(schemaDB),
+		Redactions:         NewRedactions(redactionDB),
+		RevealIndex:        NewRevealIndex(revealIndexDB),
+		RecipientKeys:      NewRecipientKeys(recipientKeyDB),
+		ExternalAnchors:    NewExternalAnchors(externalAnchorDB),
+		ChainHeadIndex:     NewChainHeadIndex(chainHeadIndexDB),
+		IndexStatus:        NewIndexStatus(singletonDB),
+		baseDB:             baseDB,
+		prefixDBs: map[string]database.Database{
+			string(singletonStatePrefix):  singletonDB,
+			string(blockStatePrefix):      blockDB,
+			string(blockHeaderPrefix):     headerDB,
+			string(acceptedLogPrefix):     acceptedLogDB,
+			string(dataIndexPrefix):       dataIndexDB,
+			string(dataFilterPrefix):      dataFilterDB,
+			string(childIndexPrefix):      childIndexDB,
+			string(submitterIndexPrefix):  submitterIndexDB,
+			string(namespaceIndexPrefix):  namespaceIndexDB,
+			string(tagIndexPrefix):        tagIndexDB,
+			string(archiveManifestPrefix): archiveManifestDB,
+			string(jobProgressPrefix):     jobProgressDB,
+			string(savedMempoolPrefix):    savedMempoolDB,
+			string(allowlistPrefix):       allowlistDB,
+			string(accountPrefix):         accountDB,
+			string(freeUsagePrefix):       freeUsageDB,
+			string(schemaPrefix):          schemaDB,
+			string(redactionPrefix):       redactionDB,
+			string(revealIndexPrefix):     revealIndexDB,
+			string(recipientKeyPrefix):    recipientKeyDB,
+			string(externalAnchorPrefix):  externalAnchorDB,
+			string(chainHeadIndexPrefix):  chainHeadIndexDB,
+		},
+	}, nil
 }
 
 // Commit commits pending operations to baseDB
@@ -71,6 +272,50 @@ func (s *state) Commit() error {
 	return s.baseDB.Commit()
 }
 
+// Abort discards pending operations and the cached values they may have
+// affected
+func (s *state) Abort() {
+	s.baseDB.Abort()
+	for _, component := range []interface{}{s.BlockState, s.AcceptedLog} {
+		if flusher, ok := component.(cacheFlusher); ok {
+			flusher.flushCache()
+		}
+	}
+}
+
+// ScanUsage implements the State interface
+func (s *state) ScanUsage(ctx context.Context, prefix string, start []byte, limit int) (uint64, uint64, []byte, error) {
+	db, ok := s.prefixDBs[prefix]
+	if !ok {
+		return 0, 0, nil, fmt.Errorf("%w %q", errUnknownPrefix, prefix)
+	}
+	it := db.NewIteratorWithStart(start)
+	defer it.Release()
+
+	keys, size := uint64(0), uint64(0)
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, nil, err
+		}
+		if keys == uint64(limit) {
+			return keys, size, append([]byte(nil), it.Key()...), it.Error()
+		}
+		keys++
+		size += uint64(len(it.Key()) + len(it.Value()))
+	}
+	return keys, size, nil, it.Error()
+}
+
+// Stat returns the [property] of the underlying database
+func (s *state) Stat(property string) (string, error) {
+	return s.baseDB.Stat(property)
+}
+
+// Compact compacts the underlying database in the range [start, limit)
+func (s *state) Compact(start []byte, limit []byte) error {
+	return s.baseDB.Compact(start, limit)
+}
+
 // Close closes the underlying base database
 func (s *state) Close() error {
 	return s.baseDB.Close()
@@ -0,0 +1,160 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/utils/json"
+)
+
+const (
+	// number of latency samples kept per kind, older ones are dropped
+	maxLatencySamples = 4096
+	// number of blocks inspected for interval statistics, which bounds the
+	// cost of a request over a long window on a busy chain
+	maxStatsBlocks = 100_000
+	// window statistics are computed over if none is requested
+	defaultStatsWindow = time.Hour
+)
+
+var errNonPositiveWindow = errors.New("window must be positive")
+
+// DurationStats summarizes a set of durations
+type DurationStats struct {
+	// Count is the number of durations
+	Count int `json:"count"`
+	// Min, Median, P95 and Max are zero if there are no durations
+	Min    Duration `json:"min"`
+	Median Duration `json:"median"`
+	P95    Duration `json:"p95"`
+	Max    Duration `json:"max"`
+}
+
+// newDurationStats returns the summary of [durations], which it sorts
+func newDurationStats(durations []time.Duration) DurationStats {
+	if len(durations) == 0 {
+		return DurationStats{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	// percentile returns the nearest-rank [p]th percentile
+	percentile := func(p int) Duration {
+		rank := (p*len(durations) + 99) / 100
+		return Duration{durations[rank-1]}
+	}
+	return DurationStats{
+		Count:  len(durations),
+		Min:    Duration{durations[0]},
+		Median: percentile(50),
+		P95:    percentile(95),
+		Max:    Duration{durations[len(durations)-1]},
+	}
+}
+
+// latencySample is a latency observed at a point in time
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// latencyWindow keeps the last [maxLatencySamples] latencies observed
+type latencyWindow struct {
+	samples []latencySample
+	// index the next sample is written at, once [samples] is full
+	next int
+}
+
+// Add records [latency] observed now
+func (w *latencyWindow) Add(latency time.Duration) {
+	sample := latencySample{
+		at:      time.Now(),
+		latency: latency,
+	}
+	if len(w.samples) < maxLatencySamples {
+		w.samples = append(w.samples, sample)
+		return
+	}
+	w.samples[w.next] = sample
+	w.next = (w.next + 1) % maxLatencySamples
+}
+
+// Since returns the latencies observed after [cutoff]
+func (w *latencyWindow) Since(cutoff time.Time) []time.Duration {
+	latencies := []time.Duration(nil)
+	for _, sample := range w.samples {
+		if sample.at.After(cutoff) {
+			latencies = append(latencies, sample.latency)
+		}
+	}
+	return latencies
+}
+
+// BlockStats are statistics of the blocks accepted within a window
+type BlockStats struct {
+	// Window is the period the statistics cover, up to now
+	Window Duration `json:"window"`
+	// Blocks is the number of blocks accepted within the window, according
+	// to their timestamps
+	Blocks json.Uint64 `json:"blocks"`
+	// Truncated is true if the window holds too many blocks, so only the
+	// most recent ones are covered
+	Truncated bool `json:"truncated"`
+	// Intervals are the times between the timestamps of consecutive blocks,
+	// which have a resolution of a second
+	Intervals DurationStats `json:"intervals"`
+	// BuildDurations are the times building a block took on this node
+	BuildDurations DurationStats `json:"buildDurations"`
+	// AcceptLatencies are the times from data being proposed to this node
+	// to the block holding it being accepted
+	AcceptLatencies DurationStats `json:"acceptLatencies"`
+}
+
+// blockStats returns the statistics of the blocks accepted within [window]
+// up to now. Latencies are only known since the VM started.
+func (vm *VM) blockStats(ctx context.Context, window time.Duration) (*BlockStats, error) {
+	if window <= 0 {
+		return nil, errNonPositiveWindow
+	}
+	cutoff := time.Now().Add(-window)
+	stats := &BlockStats{
+		Window:          Duration{window},
+		BuildDurations:  newDurationStats(vm.buildDurations.Since(cutoff)),
+		AcceptLatencies: newDurationStats(vm.acceptLatencies.Since(cutoff)),
+	}
+
+	lastAccepted, err := vm.lastAcceptedBlock()
+	if err != nil {
+		return nil, err
+	}
+	intervals := []time.Duration(nil)
+	header := newBlockHeader(lastAccepted)
+	for header.Hght > 0 && header.Tmstmp >= cutoff.Unix() {
+		if stats.Blocks == maxStatsBlocks {
+			stats.Truncated = true
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stats.Blocks++
+		parent, err := vm.state.GetBlockHeader(header.PrntID)
+		if err == database.ErrNotFound {
+			break // pruned
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The genesis block has no meaningful timestamp
+		if parent.Hght > 0 && parent.Tmstmp >= cutoff.Unix() {
+			intervals = append(intervals, time.Duration(header.Tmstmp-parent.Tmstmp)*time.Second)
+		}
+		header = parent
+	}
+	stats.Intervals = newDurationStats(intervals)
+	return stats, nil
+}
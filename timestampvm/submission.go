@@ -0,0 +1,149 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/crypto"
+)
+
+var (
+	errBadSignature              = errors.New("submission signature is invalid")
+	errSignedSubmissionsDisabled = errors.New("signed submissions are disabled on this chain")
+
+	secpFactory = crypto.FactorySECP256K1R{}
+)
+
+// submission is a piece of data proposed to this VM, optionally signed by
+// the submitter
+type submission struct {
+	data [dataLen]byte
+	// signature of [ScopedSubmissionMessage], empty if the submission is
+	// unsigned
+	sig []byte
+	// update of the submitter allowlist whose hash is [data], nil if the
+	// submission anchors data
+	update *AllowlistUpdate
+	// transfer of funds whose hash is [data], nil if the submission anchors
+	// data
+	transfer *Transfer
+	// grant of prepaid credits whose hash is [data], nil if the submission
+	// anchors data
+	grant *CreditGrant
+	// update of the payload schemas whose hash is [data], nil if the
+	// submission anchors data
+	schema *SchemaUpdate
+	// redaction of an earlier block whose hash is [data], nil if the
+	// submission anchors data
+	redaction *Redaction
+	// reveal of an earlier commitment whose value is [data], nil if the
+	// submission reveals none
+	reveal *Reveal
+	// registration of a recipient key whose hash is [data], nil if the
+	// submission anchors data
+	keyReg *KeyRegistration
+	// encrypted payload whose hash is [data], nil if the submission anchors
+	// plain data
+	encrypted *EncryptedPayload
+	// proof of work over [data], nil if none is required
+	pow *ProofOfWork
+	// namespace of [data], empty if it has none
+	namespace string
+	// tags of [data], sorted by key
+	tags []Tag
+	// true if the submitter is privileged, see [expressLane]
+	express bool
+
+	// holds the span the submission was proposed in, nil if none
+	traceCtx context.Context
+	// traces the time the submission spends in the mempool
+	mempoolSpan Span
+	// time the submission was first proposed to this node
+	proposedAt time.Time
+}
+
+// block returns the block anchoring [sub], without its parent, height and
+// timestamp
+func (sub *submission) block() *Block {
+	return &Block{
+		Dt:     sub.data,
+		Sgntr:  sub.sig,
+		Updt:   sub.update,
+		Trnsfr: sub.transfer,
+		PrfWrk: sub.pow,
+		Grnt:   sub.grant,
+		Schm:   sub.schema,
+		Rdctn:  sub.redaction,
+		Rvl:    sub.reveal,
+		KyRg:   sub.keyReg,
+		Ncrptd: sub.encrypted,
+		Nmspc:  sub.namespace,
+		Tgs:    sub.tags,
+	}
+}
+
+// unsignedSubmission is what a submitter signs.
+// The chain ID prevents a signature from being replayed on other chains.
+type unsignedSubmission struct {
+	ChainID ids.ID        `serialize:"true"`
+	Data    [dataLen]byte `serialize:"true"`
+}
+
+// unsignedScopedSubmission is what a submitter of data in a namespace or
+// with tags signs, so neither can be changed without changing the submitter
+// the signature is recovered to.
+type unsignedScopedSubmission struct {
+	ChainID   ids.ID        `serialize:"true"`
+	Data      [dataLen]byte `serialize:"true"`
+	Namespace string        `serialize:"true"`
+	Tags      []Tag         `serialize:"true"`
+}
+
+// SubmissionMessage returns the message a submitter of [data] to the chain
+// [chainID] signs. The signature is a recoverable secp256k1 signature of the
+// SHA-256 hash of the message.
+func SubmissionMessage(chainID ids.ID, data [dataLen]byte) ([]byte, error) {
+	return Codec.Marshal(CodecVersion, &unsignedSubmission{
+		ChainID: chainID,
+		Data:    data,
+	})
+}
+
+// ScopedSubmissionMessage returns the message a submitter of [data] in
+// [namespace] with [tags] to the chain [chainID] signs. The tags are signed
+// sorted by key, as blocks keep them, including the tags the API adds, like
+// the one declaring the hash algorithm. Data without a namespace and tags is
+// signed as by [SubmissionMessage].
+func ScopedSubmissionMessage(chainID ids.ID, data [dataLen]byte, namespace string, tags []Tag) ([]byte, error) {
+	if namespace == "" && len(tags) == 0 {
+		return SubmissionMessage(chainID, data)
+	}
+	sorted := append([]Tag(nil), tags...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return Codec.Marshal(CodecVersion, &unsignedScopedSubmission{
+		ChainID:   chainID,
+		Data:      data,
+		Namespace: namespace,
+		Tags:      sorted,
+	})
+}
+
+// recoverSubmitter returns the address of the key which signed [data] in
+// [namespace] with [tags] with [sig] on the chain [chainID]
+func recoverSubmitter(chainID ids.ID, data [dataLen]byte, namespace string, tags []Tag, sig []byte) (ids.ShortID, error) {
+	msg, err := ScopedSubmissionMessage(chainID, data, namespace, tags)
+	if err != nil {
+		return ids.ShortID{}, err
+	}
+	pubKey, err := secpFactory.RecoverPublicKey(msg, sig)
+	if err != nil {
+		return ids.ShortID{}, errBadSignature
+	}
+	return pubKey.Address(), nil
+}
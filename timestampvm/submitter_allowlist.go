@@ -0,0 +1,105 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	_ SubmitterAllowlist = &submitterAllowlist{}
+
+	// persists the number of accepted updates with this key. It's shorter
+	// than the addresses, so it can't collide with them.
+	allowlistNonceKey = []byte{0}
+
+	// value stored under the addresses on the allowlist
+	allowlistedValue = []byte{1}
+)
+
+// SubmitterAllowlist holds the addresses allowed to anchor data as of the
+// last accepted block
+type SubmitterAllowlist interface {
+	// IsAllowlisted returns true if [submitter] is on the allowlist
+	IsAllowlisted(submitter ids.ShortID) (bool, error)
+	// GetAllowlist returns the addresses on the allowlist, in byte order
+	GetAllowlist() ([]ids.ShortID, error)
+	// GetAllowlistNonce returns the number of accepted allowlist updates.
+	// Returns database.ErrNotFound if the allowlist isn't initialized.
+	GetAllowlistNonce() (uint64, error)
+	// InitAllowlist initializes the allowlist with [submitters]
+	InitAllowlist(submitters []ids.ShortID) error
+	// ApplyAllowlistUpdate applies the accepted [update] and counts it
+	ApplyAllowlistUpdate(update *AllowlistUpdate) error
+}
+
+// submitterAllowlist implements SubmitterAllowlist with a database keyed by
+// address
+type submitterAllowlist struct {
+	allowlistDB database.Database
+}
+
+// NewSubmitterAllowlist returns SubmitterAllowlist stored in the given db
+func NewSubmitterAllowlist(db database.Database) SubmitterAllowlist {
+	return &submitterAllowlist{allowlistDB: db}
+}
+
+// IsAllowlisted implements the SubmitterAllowlist interface
+func (a *submitterAllowlist) IsAllowlisted(submitter ids.ShortID) (bool, error) {
+	return a.allowlistDB.Has(submitter.Bytes())
+}
+
+// GetAllowlist implements the SubmitterAllowlist interface
+func (a *submitterAllowlist) GetAllowlist() ([]ids.ShortID, error) {
+	it := a.allowlistDB.NewIterator()
+	defer it.Release()
+
+	submitters := []ids.ShortID(nil)
+	for it.Next() {
+		if len(it.Key()) != len(ids.ShortEmpty) {
+			continue
+		}
+		submitter, err := ids.ToShortID(it.Key())
+		if err != nil {
+			return nil, err
+		}
+		submitters = append(submitters, submitter)
+	}
+	return submitters, it.Error()
+}
+
+// GetAllowlistNonce implements the SubmitterAllowlist interface
+func (a *submitterAllowlist) GetAllowlistNonce() (uint64, error) {
+	return database.GetUInt64(a.allowlistDB, allowlistNonceKey)
+}
+
+// InitAllowlist implements the SubmitterAllowlist interface
+func (a *submitterAllowlist) InitAllowlist(submitters []ids.ShortID) error {
+	for _, submitter := range submitters {
+		if err := a.allowlistDB.Put(submitter.Bytes(), allowlistedValue); err != nil {
+			return err
+		}
+	}
+	return database.PutUInt64(a.allowlistDB, allowlistNonceKey, 0)
+}
+
+// ApplyAllowlistUpdate implements the SubmitterAllowlist interface
+func (a *submitterAllowlist) ApplyAllowlistUpdate(update *AllowlistUpdate) error {
+	nonce, err := a.GetAllowlistNonce()
+	if err != nil && err != database.ErrNotFound {
+		return err
+	}
+	for _, submitter := range update.Add {
+		if err := a.allowlistDB.Put(submitter.Bytes(), allowlistedValue); err != nil {
+			return err
+		}
+	}
+	for _, submitter := range update.Remove {
+		if err := a.allowlistDB.Delete(submitter.Bytes()); err != nil {
+			return err
+		}
+	}
+	return database.PutUInt64(a.allowlistDB, allowlistNonceKey, nonce+1)
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var _ SubmitterIndex = &submitterIndex{}
+
+// SubmitterIndex lists the accepted blocks signed by each submitter
+type SubmitterIndex interface {
+	// IndexSubmitter adds the accepted block [blkID] at [height] to the
+	// blocks of [submitter]
+	IndexSubmitter(submitter ids.ShortID, height uint64, blkID ids.ID) error
+	// GetSubmitterBlockIDs returns at most [limit] IDs of accepted blocks
+	// signed by [submitter], in height order, starting at [startHeight]
+	GetSubmitterBlockIDs(ctx context.Context, submitter ids.ShortID, startHeight uint64, limit int) ([]ids.ID, error)
+}
+
+// submitterIndex implements SubmitterIndex with a database keyed by the
+// submitter's address followed by the big-endian encoded block height
+type submitterIndex struct {
+	indexDB database.Database
+}
+
+// NewSubmitterIndex returns SubmitterIndex stored in the given db
+func NewSubmitterIndex(db database.Database) SubmitterIndex {
+	return &submitterIndex{indexDB: db}
+}
+
+// submitterKey returns the key of the block at [height] signed by [submitter]
+func submitterKey(submitter ids.ShortID, height uint64) []byte {
+	return append(submitter.Bytes(), database.PackUInt64(height)...)
+}
+
+// IndexSubmitter implements the SubmitterIndex interface
+func (i *submitterIndex) IndexSubmitter(submitter ids.ShortID, height uint64, blkID ids.ID) error {
+	return database.PutID(i.indexDB, submitterKey(submitter, height), blkID)
+}
+
+// GetSubmitterBlockIDs implements the SubmitterIndex interface
+func (i *submitterIndex) GetSubmitterBlockIDs(ctx context.Context, submitter ids.ShortID, startHeight uint64, limit int) ([]ids.ID, error) {
+	it := i.indexDB.NewIteratorWithStartAndPrefix(submitterKey(submitter, startHeight), submitter.Bytes())
+	defer it.Release()
+
+	blkIDs := []ids.ID(nil)
+	for len(blkIDs) < limit && it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blkID, err := ids.ToID(it.Value())
+		if err != nil {
+			return nil, err
+		}
+		blkIDs = append(blkIDs, blkID)
+	}
+	return blkIDs, it.Error()
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	"github.com/chain4travel/caminogo/utils/wrappers"
+)
+
+var _ TagIndex = &tagIndex{}
+
+// TagIndex lists the accepted blocks carrying each tag, within each namespace
+type TagIndex interface {
+	// IndexTag adds the accepted block [blkID] at [height] to the blocks of
+	// [namespace] carrying [tag]
+	IndexTag(namespace string, tag Tag, height uint64, blkID ids.ID) error
+	// GetTagBlockIDs returns at most [limit] IDs of accepted blocks of
+	// [namespace] carrying [tag], in height order, starting at [startHeight]
+	GetTagBlockIDs(ctx context.Context, namespace string, tag Tag, startHeight uint64, limit int) ([]ids.ID, error)
+}
+
+// tagIndex implements TagIndex with a database keyed by the hash of the
+// namespace and the tag followed by the big-endian encoded block height
+type tagIndex struct {
+	indexDB database.Database
+}
+
+// NewTagIndex returns TagIndex stored in the given db
+func NewTagIndex(db database.Database) TagIndex {
+	return &tagIndex{indexDB: db}
+}
+
+// tagPrefix returns the prefix of the keys of the blocks of [namespace]
+// carrying [tag]. The strings are length prefixed, so no two combinations
+// hash the same bytes.
+func tagPrefix(namespace string, tag Tag) []byte {
+	p := wrappers.Packer{MaxSize: 3*wrappers.ShortLen + maxNamespaceLen + maxTagKeyLen + maxTagValueLen}
+	p.PackStr(namespace)
+	p.PackStr(tag.Key)
+	p.PackStr(tag.Value)
+	return hashing.ComputeHash256(p.Bytes)
+}
+
+// tagKey returns the key of the block at [height] of [namespace] carrying
+// [tag]
+func tagKey(namespace string, tag Tag, height uint64) []byte {
+	return append(tagPrefix(namespace, tag), database.PackUInt64(height)...)
+}
+
+// IndexTag implements the TagIndex interface
+func (i *tagIndex) IndexTag(namespace string, tag Tag, height uint64, blkID ids.ID) error {
+	return database.PutID(i.indexDB, tagKey(namespace, tag, height), blkID)
+}
+
+// GetTagBlockIDs implements the TagIndex interface
+func (i *tagIndex) GetTagBlockIDs(ctx context.Context, namespace string, tag Tag, startHeight uint64, limit int) ([]ids.ID, error) {
+	it := i.indexDB.NewIteratorWithStartAndPrefix(tagKey(namespace, tag, startHeight), tagPrefix(namespace, tag))
+	defer it.Release()
+
+	blkIDs := []ids.ID(nil)
+	for len(blkIDs) < limit && it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blkID, err := ids.ToID(it.Value())
+		if err != nil {
+			return nil, err
+		}
+		blkIDs = append(blkIDs, blkID)
+	}
+	return blkIDs, it.Error()
+}
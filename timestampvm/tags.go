@@ -0,0 +1,86 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// maxTags is the maximum number of tags of a block
+	maxTags = 8
+	// maxTagKeyLen and maxTagValueLen are the maximum lengths of the key and
+	// the value of a tag, in bytes
+	maxTagKeyLen   = 32
+	maxTagValueLen = 64
+)
+
+var (
+	errTooManyTags     = fmt.Errorf("blocks may have at most %d tags", maxTags)
+	errBadTagKey       = fmt.Errorf("tag keys must be 1 to %d letters, digits, '.', '_' or '-'", maxTagKeyLen)
+	errBadTagValue     = fmt.Errorf("tag values must be 1 to %d bytes long", maxTagValueLen)
+	errUnsortedTags    = errors.New("tags must be sorted by key, each key given once")
+	errTaggedOperation = errors.New("only blocks anchoring data may have tags")
+)
+
+// Tag is a key=value label of the data of a block, e.g. docType=invoice,
+// indexed within the namespace of the block
+type Tag struct {
+	Key   string `serialize:"true" json:"key"`
+	Value string `serialize:"true" json:"value"`
+}
+
+// verifyTag returns nil iff [tag] has a valid key and value
+func verifyTag(tag Tag) error {
+	if tag.Key == "" || len(tag.Key) > maxTagKeyLen || verifyNamespace(tag.Key) != nil {
+		return fmt.Errorf("%w: %q", errBadTagKey, tag.Key)
+	}
+	if tag.Value == "" || len(tag.Value) > maxTagValueLen {
+		return fmt.Errorf("%w: %q", errBadTagValue, tag.Key)
+	}
+	return nil
+}
+
+// verifyTags returns nil iff [tags] are valid and in their canonical order
+func verifyTags(tags []Tag) error {
+	if len(tags) > maxTags {
+		return errTooManyTags
+	}
+	for i, tag := range tags {
+		if err := verifyTag(tag); err != nil {
+			return err
+		}
+		if i > 0 && tags[i-1].Key >= tag.Key {
+			return errUnsortedTags
+		}
+	}
+	return nil
+}
+
+// parseTags returns the tags [tagMap] lists by key, in canonical order
+func parseTags(tagMap map[string]string) ([]Tag, error) {
+	if len(tagMap) == 0 {
+		return nil, nil
+	}
+	tags := make([]Tag, 0, len(tagMap))
+	for key, value := range tagMap {
+		tags = append(tags, Tag{Key: key, Value: value})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return tags, verifyTags(tags)
+}
+
+// tagMap returns [tags] by key, or nil if there are none
+func tagMap(tags []Tag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	tagMap := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		tagMap[tag.Key] = tag.Value
+	}
+	return tagMap
+}
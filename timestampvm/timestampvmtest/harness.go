@@ -0,0 +1,204 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// Package timestampvmtest runs a timestampvm VM in the tests of downstream
+// projects, with a memory database and a fake engine channel, and builds and
+// accepts blocks as the consensus engine would, e.g.
+//
+//	h := timestampvmtest.New(t, timestampvmtest.Options{Config: []byte(`{"pruningEnabled": true}`)})
+//	blk := h.ProposeAndAccept([32]byte{1})
+package timestampvmtest
+
+import (
+	"testing"
+
+	"github.com/chain4travel/caminogo/database/manager"
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/snow"
+	"github.com/chain4travel/caminogo/snow/consensus/snowman"
+	"github.com/chain4travel/caminogo/snow/engine/common"
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/version"
+
+	"github.com/chain4travel/camino-timestampvm/timestampvm"
+)
+
+// ChainID is the ID of the chains run by a Harness, unless set in Options
+var ChainID = ids.ID{1, 2, 3}
+
+// Options configure the VM run by a Harness. The zero value runs the VM
+// with the default config.
+type Options struct {
+	// Config is the JSON config of the chain
+	Config []byte
+	// Genesis is the data of the genesis block, at most 32 bytes
+	Genesis []byte
+	// ChainID is the ID of the chain, [ChainID] if it's empty
+	ChainID ids.ID
+	// Factory creates the VM, e.g. with verifiers or stores. If it's nil,
+	// the VM is created with the defaults.
+	Factory *timestampvm.Factory
+	// DB holds the state of the chain, a new memory database if it's nil
+	DB manager.Manager
+}
+
+// Harness is a VM initialized for a test. Failures of its helpers fail the
+// test. The VM is shut down once the test completes.
+type Harness struct {
+	t       testing.TB
+	options Options
+	// true once the VM was shut down by [Harness.Restart]
+	shutdown bool
+
+	VM  *timestampvm.VM
+	Ctx *snow.Context
+	// DB holds the state of the VM, it's reused by [Harness.Restart]
+	DB manager.Manager
+	// ToEngine receives the messages the VM sends the consensus engine
+	ToEngine chan common.Message
+	// Service is the API of the VM. Its methods are called without holding
+	// the context lock, as the test drives the VM alone.
+	Service *timestampvm.Service
+}
+
+// New returns a Harness running a VM configured with [options]
+func New(t testing.TB, options Options) *Harness {
+	t.Helper()
+
+	if options.DB == nil {
+		options.DB = manager.NewMemDB(version.DefaultVersion1_0_0)
+	}
+	if options.ChainID == ids.Empty {
+		options.ChainID = ChainID
+	}
+	factory := options.Factory
+	if factory == nil {
+		factory = &timestampvm.Factory{}
+	}
+	ctx := snow.DefaultContextTest()
+	ctx.ChainID = options.ChainID
+	vmIntf, err := factory.New(ctx)
+	if err != nil {
+		t.Fatalf("couldn't create the VM: %s", err)
+	}
+	h := &Harness{
+		t:        t,
+		options:  options,
+		VM:       vmIntf.(*timestampvm.VM),
+		Ctx:      ctx,
+		DB:       options.DB,
+		ToEngine: make(chan common.Message, 1),
+	}
+	h.Service = timestampvm.NewService(h.VM)
+	if err := h.VM.Initialize(ctx, options.DB, options.Genesis, nil, options.Config, h.ToEngine, nil, nil); err != nil {
+		t.Fatalf("couldn't initialize the VM: %s", err)
+	}
+	if err := h.VM.SetState(snow.NormalOp); err != nil {
+		t.Fatalf("couldn't start the VM: %s", err)
+	}
+	lastAccepted, err := h.VM.LastAccepted()
+	if err != nil {
+		t.Fatalf("couldn't get the last accepted block: %s", err)
+	}
+	if err := h.VM.SetPreference(lastAccepted); err != nil {
+		t.Fatalf("couldn't set the preference: %s", err)
+	}
+	t.Cleanup(func() {
+		if h.shutdown {
+			return
+		}
+		if err := h.VM.Shutdown(); err != nil {
+			t.Errorf("couldn't shut the VM down: %s", err)
+		}
+	})
+	return h
+}
+
+// Restart shuts the VM down and returns a Harness running a new VM on the
+// same database, configured with [config]
+func (h *Harness) Restart(config []byte) *Harness {
+	h.t.Helper()
+
+	h.shutdown = true
+	if err := h.VM.Shutdown(); err != nil {
+		h.t.Fatalf("couldn't shut the VM down: %s", err)
+	}
+	options := h.options
+	options.Config = config
+	return New(h.t, options)
+}
+
+// Propose proposes a block anchoring [data]
+func (h *Harness) Propose(data [32]byte) {
+	h.t.Helper()
+
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	if err != nil {
+		h.t.Fatalf("couldn't encode the data: %s", err)
+	}
+	h.ProposeArgs(&timestampvm.ProposeBlockArgs{Data: encodedData})
+}
+
+// ProposeArgs proposes a block with [args], e.g. with a namespace or tags
+func (h *Harness) ProposeArgs(args *timestampvm.ProposeBlockArgs) {
+	h.t.Helper()
+
+	if err := h.Service.ProposeBlock(nil, args, &timestampvm.ProposeBlockReply{}); err != nil {
+		h.t.Fatalf("couldn't propose the block: %s", err)
+	}
+}
+
+// BuildBlock builds a block on top of the preferred block, as the engine
+// does once the VM reports pending submissions, and verifies it
+func (h *Harness) BuildBlock() snowman.Block {
+	h.t.Helper()
+
+	blk, err := h.VM.BuildBlock()
+	if err != nil {
+		h.t.Fatalf("couldn't build a block: %s", err)
+	}
+	if err := blk.Verify(); err != nil {
+		h.t.Fatalf("couldn't verify block %s: %s", blk.ID(), err)
+	}
+	return blk
+}
+
+// Accept prefers and accepts [blk], as the engine does once consensus is
+// reached
+func (h *Harness) Accept(blk snowman.Block) {
+	h.t.Helper()
+
+	if err := h.VM.SetPreference(blk.ID()); err != nil {
+		h.t.Fatalf("couldn't set the preference: %s", err)
+	}
+	if err := blk.Accept(); err != nil {
+		h.t.Fatalf("couldn't accept block %s: %s", blk.ID(), err)
+	}
+}
+
+// BuildAndAccept builds, verifies and accepts the next block
+func (h *Harness) BuildAndAccept() snowman.Block {
+	h.t.Helper()
+
+	blk := h.BuildBlock()
+	h.Accept(blk)
+	return blk
+}
+
+// ProposeAndAccept proposes [data] and accepts the block anchoring it
+func (h *Harness) ProposeAndAccept(data [32]byte) snowman.Block {
+	h.t.Helper()
+
+	h.Propose(data)
+	return h.BuildAndAccept()
+}
+
+// Reject rejects [blk], whose data is proposed again if it was built by
+// this VM
+func (h *Harness) Reject(blk snowman.Block) {
+	h.t.Helper()
+
+	if err := blk.Reject(); err != nil {
+		h.t.Fatalf("couldn't reject block %s: %s", blk.ID(), err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvmtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chain4travel/caminogo/snow/choices"
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/utils/json"
+
+	"github.com/chain4travel/camino-timestampvm/timestampvm"
+)
+
+func TestHarness(t *testing.T) {
+	assert := assert.New(t)
+	h := New(t, Options{Genesis: []byte("genesis")})
+	assert.Equal(ChainID, h.Ctx.ChainID)
+
+	genesisID, err := h.VM.LastAccepted()
+	assert.NoError(err)
+	first := h.ProposeAndAccept([32]byte{1})
+	assert.Equal(choices.Accepted, first.Status())
+	assert.Equal(genesisID, first.Parent())
+	assert.Equal(uint64(1), first.Height())
+	second := h.ProposeAndAccept([32]byte{2})
+	assert.Equal(first.ID(), second.Parent())
+	assert.Equal(uint64(2), second.Height())
+
+	// The accepted blocks survive a restart on the same database
+	restarted := h.Restart(nil)
+	assert.Equal(h.DB, restarted.DB)
+	lastAccepted, err := restarted.VM.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(second.ID(), lastAccepted)
+	for _, blk := range []struct {
+		data   [32]byte
+		height uint64
+	}{{[32]byte{1}, 1}, {[32]byte{2}, 2}} {
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, blk.data[:])
+		assert.NoError(err)
+		reply := timestampvm.GetBlockReply{}
+		assert.NoError(restarted.Service.GetBlockByData(nil, &timestampvm.GetBlockByDataArgs{Data: encodedData}, &reply))
+		assert.Equal(json.Uint64(blk.height), reply.Height)
+		assert.Equal(encodedData, reply.Data)
+	}
+
+	// and the restarted VM keeps building on them
+	third := restarted.ProposeAndAccept([32]byte{3})
+	assert.Equal(second.ID(), third.Parent())
+	assert.Equal(uint64(3), third.Height())
+}
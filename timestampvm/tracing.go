@@ -0,0 +1,157 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chain4travel/caminogo/utils/logging"
+)
+
+var (
+	_ Tracer = noopTracer{}
+	_ Tracer = &logTracer{}
+)
+
+// Attribute is a key/value pair describing a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is an operation being traced
+type Span interface {
+	// RecordError marks the span as failed with [err]
+	RecordError(err error)
+	// End completes the span
+	End()
+}
+
+// Tracer starts spans. It mirrors the part of the OpenTelemetry tracing API
+// the VM uses. The VM exports spans to an OTLP collector itself, or a node
+// embedding it can plug in an OpenTelemetry tracer with Factory.Tracer.
+type Tracer interface {
+	// Start starts a span named [name], child of the span held by [ctx] if
+	// any, and returns a context holding the new span
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// noopTracer is the Tracer used if tracing is disabled
+type noopTracer struct{}
+
+// Start implements the Tracer interface
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) RecordError(error) {}
+func (noopSpan) End()              {}
+
+// logTracerKey is the context key of the logSpan a logTracer started
+type logTracerKey struct{}
+
+// logTracer is the Tracer used if tracing is enabled without an OTLP
+// collector and the node doesn't provide one. It logs every span once it
+// ends.
+type logTracer struct {
+	log logging.Logger
+}
+
+// logSpan is a span started by a logTracer
+type logSpan struct {
+	tracer     *logTracer
+	name       string
+	traceID    string
+	spanID     string
+	parentID   string
+	start      time.Time
+	attributes []Attribute
+	err        error
+}
+
+// Start implements the Tracer interface
+func (t *logTracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	span := &logSpan{
+		tracer:     t,
+		name:       name,
+		spanID:     randomHex(8),
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	if parent, ok := ctx.Value(logTracerKey{}).(*logSpan); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+	return context.WithValue(ctx, logTracerKey{}, span), span
+}
+
+// RecordError implements the Span interface
+func (s *logSpan) RecordError(err error) { s.err = err }
+
+// End implements the Span interface
+func (s *logSpan) End() {
+	fields := make([]string, 0, len(s.attributes)+1)
+	for _, attribute := range s.attributes {
+		fields = append(fields, fmt.Sprintf("%s=%v", attribute.Key, attribute.Value))
+	}
+	if s.err != nil {
+		fields = append(fields, fmt.Sprintf("error=%q", s.err))
+	}
+	s.tracer.log.Info("span %s trace=%s span=%s parent=%s duration=%s %s",
+		s.name, s.traceID, s.spanID, s.parentID, time.Since(s.start), strings.Join(fields, " "))
+}
+
+// randomHex returns [n] random bytes in hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// endSpan records [err], if any, in [span] and ends it
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// tracedContext returns a context canceled with [parent] which holds the span
+// [traceCtx] was traced in, if any. Blocks are built and verified in the
+// span of the call proposing their data without being canceled with the call.
+func tracedContext(parent context.Context, traceCtx context.Context) context.Context {
+	if traceCtx == nil {
+		return parent
+	}
+	return &valuesContext{Context: parent, values: traceCtx}
+}
+
+// valuesContext is canceled with its Context but holds the values of [values]
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+// Value implements the context.Context interface
+func (c *valuesContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// traceContext returns the context holding the span [ctx] was traced in,
+// which is nil for operations which didn't start in this node
+func traceContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+const (
+	// profileTagKey is the key of the tag naming the payload profile of the
+	// data of a block
+	profileTagKey = "profile"
+	// travelDocumentProfile is the profile of blocks anchoring a travel
+	// document
+	travelDocumentProfile = "travelDocument"
+	// supplierTagKey is the key of the tag holding the supplier of an
+	// anchored travel document, so the documents of a supplier can be listed
+	supplierTagKey = "supplier"
+)
+
+var (
+	errBadSupplierID       = fmt.Errorf("supplier IDs must be 1 to %d bytes long", maxTagValueLen)
+	errEmptyDocumentHash   = errors.New("travel documents must have a document hash")
+	errBadValidityWindow   = errors.New("travel documents must expire after they become valid")
+	errDocumentNotAnchored = errors.New("travel document isn't anchored in an accepted block")
+)
+
+// TravelDocument is the notarization profile of a travel or booking record,
+// e.g. a ticket or a hotel voucher. Only hashes are anchored, so neither the
+// booking reference nor the document are disclosed by the chain. The data of
+// the block anchoring it is the hash of the document.
+type TravelDocument struct {
+	// BookingRefHash is the hash of the booking reference, e.g. a PNR
+	BookingRefHash ids.ID `serialize:"true" json:"bookingRefHash"`
+	// SupplierID identifies the supplier issuing the document, e.g. an
+	// airline or a hotel chain
+	SupplierID string `serialize:"true" json:"supplierID"`
+	// DocumentHash is the hash of the document itself
+	DocumentHash ids.ID `serialize:"true" json:"documentHash"`
+	// ValidFrom and ValidUntil bound the validity window of the document, in
+	// unix seconds
+	ValidFrom  uint64 `serialize:"true" json:"validFrom"`
+	ValidUntil uint64 `serialize:"true" json:"validUntil"`
+}
+
+// Verify returns nil iff [doc] is well formed
+func (doc *TravelDocument) Verify() error {
+	if doc.SupplierID == "" || len(doc.SupplierID) > maxTagValueLen {
+		return errBadSupplierID
+	}
+	if doc.DocumentHash == ids.Empty {
+		return errEmptyDocumentHash
+	}
+	if doc.ValidUntil <= doc.ValidFrom {
+		return errBadValidityWindow
+	}
+	return nil
+}
+
+// ValidAt returns true if [timestamp], in unix seconds, is within the
+// validity window of [doc]
+func (doc *TravelDocument) ValidAt(timestamp uint64) bool {
+	return doc.ValidFrom <= timestamp && timestamp < doc.ValidUntil
+}
+
+// TravelDocumentData returns the data of the block anchoring [doc]
+func TravelDocumentData(doc *TravelDocument) ([dataLen]byte, error) {
+	docBytes, err := Codec.Marshal(CodecVersion, doc)
+	if err != nil {
+		return [dataLen]byte{}, err
+	}
+	return hashing.ComputeHash256Array(docBytes), nil
+}
+
+// TravelDocumentMessage returns the message the supplier signs to propose
+// [doc] in [namespace] to the chain [chainID]. It's the message signed to
+// submit the data and tags of the block anchoring the document.
+func TravelDocumentMessage(chainID ids.ID, namespace string, doc *TravelDocument) ([]byte, error) {
+	data, err := TravelDocumentData(doc)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := parseTags(travelDocumentTags(doc))
+	if err != nil {
+		return nil, err
+	}
+	return ScopedSubmissionMessage(chainID, data, namespace, tags)
+}
+
+// travelDocumentTags returns the tags of the block anchoring [doc]
+func travelDocumentTags(doc *TravelDocument) map[string]string {
+	return map[string]string{
+		profileTagKey:  travelDocumentProfile,
+		supplierTagKey: doc.SupplierID,
+	}
+}
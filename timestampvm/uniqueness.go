@@ -0,0 +1,84 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+
+	"github.com/chain4travel/caminogo/database"
+	"github.com/chain4travel/caminogo/snow/choices"
+)
+
+var (
+	errDuplicateData       = errors.New("block's data is identical to the data of a recent ancestor")
+	errIncompleteDataIndex = errors.New("rejectAnchoredData requires a data index covering all accepted blocks")
+
+	_ BlockVerifier = &uniquenessVerifier{}
+)
+
+// uniquenessVerifier rejects blocks whose data is identical to the data of
+// one of their last [window] ancestors, or of any ancestor if [ever] is set.
+// Ancestors are looked up through the VM, so blocks which are verified but
+// not yet accepted are taken into account as well.
+type uniquenessVerifier struct {
+	vm     *VM
+	window uint64
+	ever   bool
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (u *uniquenessVerifier) VerifyBlock(blk *Block) error {
+	if u.ever {
+		return u.verifyNeverAnchored(blk)
+	}
+
+	// Headers are retained for redacted ancestors
+	dataHash := DataHash(blk.Data())
+	ancestorID := blk.Parent()
+	for i := uint64(0); i < u.window; i++ {
+		ancestor, err := u.vm.getBlockHeader(ancestorID)
+		if err != nil {
+			return errDatabaseGet
+		}
+		if ancestor.DataHash == dataHash {
+			return errDuplicateData
+		}
+		// The genesis block has no ancestors
+		if ancestor.Hght == 0 {
+			return nil
+		}
+		ancestorID = ancestor.PrntID
+	}
+	return nil
+}
+
+// verifyNeverAnchored returns errDuplicateData if [blk]'s data is part of one
+// of its processing ancestors or of any accepted block
+func (u *uniquenessVerifier) verifyNeverAnchored(blk *Block) error {
+	data := blk.Data()
+	ancestorID := blk.Parent()
+	for {
+		ancestor, err := u.vm.getBlock(ancestorID)
+		if err != nil {
+			return errDatabaseGet
+		}
+		// Accepted blocks are covered by the data index
+		if ancestor.Status() == choices.Accepted {
+			break
+		}
+		if ancestor.Data() == data {
+			return errDuplicateData
+		}
+		ancestorID = ancestor.Parent()
+	}
+
+	switch _, err := u.vm.state.GetDataEntry(DataHash(data)); err {
+	case nil:
+		return errDuplicateData
+	case database.ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+}
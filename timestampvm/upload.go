@@ -0,0 +1,234 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chain4travel/caminogo/utils/formatting"
+)
+
+const (
+	// proposalMethod is the method proposals through the HTTP endpoints are
+	// authorized, rate limited and charged as, since each proposes a block
+	// like it
+	proposalMethod = Name + ".ProposeBlock"
+	// uploadFormField is the field of multipart uploads holding the file
+	uploadFormField = "file"
+)
+
+var (
+	errMaxUploadSize  = errors.New("maxUploadSize must not be negative")
+	errNoUploadedFile = fmt.Errorf("multipart upload has no %q field", uploadFormField)
+	errBadQueryTag    = errors.New("tags must be given as key=value")
+)
+
+// UploadReply is the reply of the upload endpoint
+type UploadReply struct {
+	Success bool `json:"success"`
+	// Base 58 encoded digest anchored as the data. It identifies the
+	// submission, e.g. to GetBlockByData.
+	Data string `json:"data"`
+	// Hex encoded digest, as printed by tools like sha256sum
+	Hash string `json:"hash"`
+	// HashAlgorithm is the algorithm the digest was computed with
+	HashAlgorithm string `json:"hashAlgorithm"`
+	// Size is the number of bytes hashed
+	Size int64 `json:"size"`
+}
+
+// serveUpload hashes the file posted to it and proposes a block anchoring
+// the digest, for integrators without the tooling to hash documents
+// themselves. The file is either the raw body or, for multipart forms, the
+// field "file". It's hashed as it's read, without holding the context lock,
+// and is neither stored nor logged. Each read of the body must complete
+// within [vm.config.RequestReadTimeout]. The query may set the "namespace", the
+// "hashAlgorithm", SHA-256 if left blank, and any number of "tag"s given as
+// key=value. The digest is proposed unsigned, as by ProposeBlock, so chains
+// requiring signatures or proofs of work refuse it.
+func (vm *VM) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if !vm.admitProposal(w, r) {
+		return
+	}
+	args, err := parseUploadArgs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = newTimeoutBody(r.Body, vm.config.RequestReadTimeout.Duration)
+	file, err := uploadedFile(r)
+	if err != nil {
+		writeReadError(w, err, err.Error())
+		return
+	}
+	hasher, err := newHasher(args.HashAlgorithm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The size of multipart forms isn't limited, as the other fields are
+	// discarded as they are read
+	maxSize := vm.config.MaxUploadSize
+	size, err := io.Copy(hasher, io.LimitReader(file, maxSize+1))
+	switch {
+	case err != nil:
+		writeReadError(w, err, "couldn't read upload")
+		return
+	case size > maxSize:
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	reply, err := vm.proposeUpload(r, args, hasher.Sum(nil), size)
+	if err != nil {
+		writeProposalError(w, err)
+		return
+	}
+	writeJSON(w, reply)
+}
+
+// admitProposal returns true if [r] may propose a block. Otherwise the
+// refusal is written to [w].
+func (vm *VM) admitProposal(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "proposals must be posted", http.StatusMethodNotAllowed)
+		return false
+	}
+	if bucket, err := vm.rateLimiter.Allow(proposalMethod); err != nil {
+		vm.rpcMetrics.limited.WithLabelValues(bucket).Inc()
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return false
+	}
+	if caller := requestCaller(r); caller != nil {
+		if err := vm.usage.Charge(caller, proposalMethod, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
+
+// parseUploadArgs returns the arguments of the proposal set by the query of
+// [r], see parseProposalArgs. The digest algorithm defaults to SHA-256.
+func parseUploadArgs(r *http.Request) (*ProposeBlockArgs, error) {
+	args, err := parseProposalArgs(r)
+	if err != nil {
+		return nil, err
+	}
+	if args.HashAlgorithm == "" {
+		args.HashAlgorithm = SHA256
+	}
+	return args, nil
+}
+
+// parseProposalArgs returns the namespace, digest algorithm and tags set by
+// the query of [r]. The data is left blank.
+func parseProposalArgs(r *http.Request) (*ProposeBlockArgs, error) {
+	query := r.URL.Query()
+	args := &ProposeBlockArgs{
+		Namespace:     query.Get("namespace"),
+		Tags:          make(map[string]string, len(query["tag"])),
+		HashAlgorithm: query.Get("hashAlgorithm"),
+	}
+	for _, tag := range query["tag"] {
+		key, value, ok := cutTag(tag)
+		if !ok {
+			return nil, errBadQueryTag
+		}
+		args.Tags[key] = value
+	}
+	return args, nil
+}
+
+// proposeUpload proposes a block anchoring the [digest] of a file of [size]
+// bytes uploaded with [args]
+func (vm *VM) proposeUpload(r *http.Request, args *ProposeBlockArgs, digest []byte, size int64) (*UploadReply, error) {
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, digest)
+	if err != nil {
+		return nil, err
+	}
+	proposed := *args
+	proposed.Data = data
+
+	vm.ctx.Lock.Lock()
+	err = (&Service{vm: vm}).ProposeBlock(r, &proposed, &ProposeBlockReply{})
+	vm.ctx.Lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &UploadReply{
+		Success:       true,
+		Data:          data,
+		Hash:          hex.EncodeToString(digest),
+		HashAlgorithm: args.HashAlgorithm,
+		Size:          size,
+	}, nil
+}
+
+// writeProposalError writes the refusal of a proposal with [err] to [w]
+func writeProposalError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errForbidden) {
+		status = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// writeReadError writes the failure to read the body of a request with [err]
+// to [w], described by [message] unless the read timed out
+func writeReadError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, errReadTimeout) {
+		http.Error(w, errReadTimeout.Error(), http.StatusRequestTimeout)
+		return
+	}
+	http.Error(w, message, http.StatusBadRequest)
+}
+
+// writeJSON writes [reply] encoded as JSON to [w]
+func writeJSON(w http.ResponseWriter, reply interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+// uploadedFile returns the file uploaded by [r], the field "file" of
+// multipart forms and the body otherwise
+func uploadedFile(r *http.Request) (io.Reader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	form, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, errNoUploadedFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == uploadFormField {
+			return part, nil
+		}
+	}
+}
+
+// cutTag splits [tag] given as key=value
+func cutTag(tag string) (string, string, bool) {
+	i := strings.IndexByte(tag, '=')
+	if i < 0 {
+		return "", "", false
+	}
+	return tag[:i], tag[i+1:], true
+}
@@ -0,0 +1,274 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chain4travel/caminogo/ids"
+)
+
+var (
+	errMaxUploadSessions      = errors.New("maxUploadSessions must not be negative")
+	errMaxUploadChunkSize     = errors.New("maxUploadChunkSize must be at least 1")
+	errTooManyUploadSessions  = errors.New("too many upload sessions are open, retry later")
+	errUnknownUploadSession   = errors.New("unknown or expired upload session")
+	errBadUploadOffset        = errors.New("offset must be a non-negative integer")
+	errUnexpectedUploadOffset = errors.New("offset doesn't match the bytes received")
+)
+
+// BeginUploadReply is the reply of the endpoint beginning an upload session
+type BeginUploadReply struct {
+	// Session identifies the upload session to the append and finish
+	// endpoints
+	Session ids.ID `json:"session"`
+}
+
+// AppendUploadReply is the reply of the endpoint appending to an upload
+// session
+type AppendUploadReply struct {
+	// Size is the number of bytes received by the session so far, the
+	// offset of the next chunk
+	Size int64 `json:"size"`
+}
+
+// uploadSession is a file being uploaded in chunks, hashed as they are
+// received
+type uploadSession struct {
+	// name of the caller who began the session, empty if the API is open
+	owner string
+	args  *ProposeBlockArgs
+	// guarded by the lock of the sessions
+	lastUsed time.Time
+
+	lock   sync.Mutex
+	closed bool
+	hasher hash.Hash
+	size   int64
+}
+
+// uploadSessions are the open upload sessions. They are kept in memory, so
+// they don't survive a restart.
+type uploadSessions struct {
+	maxSessions int
+	timeout     time.Duration
+
+	lock     sync.Mutex
+	sessions map[ids.ID]*uploadSession
+}
+
+// newUploadSessions returns the upload sessions configured by [config]
+func newUploadSessions(config *Config) *uploadSessions {
+	return &uploadSessions{
+		maxSessions: config.MaxUploadSessions,
+		timeout:     config.UploadSessionTimeout.Duration,
+		sessions:    make(map[ids.ID]*uploadSession),
+	}
+}
+
+// begin opens a session uploading a file with [args] by the caller named
+// [owner] at [now]
+func (u *uploadSessions) begin(owner string, args *ProposeBlockArgs, now time.Time) (ids.ID, error) {
+	hasher, err := newHasher(args.HashAlgorithm)
+	if err != nil {
+		return ids.Empty, err
+	}
+	var sessionID ids.ID
+	if _, err := rand.Read(sessionID[:]); err != nil {
+		return ids.Empty, err
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if len(u.sessions) >= u.maxSessions {
+		u.expire(now)
+	}
+	if len(u.sessions) >= u.maxSessions {
+		return ids.Empty, errTooManyUploadSessions
+	}
+	u.sessions[sessionID] = &uploadSession{
+		owner:    owner,
+		args:     args,
+		hasher:   hasher,
+		lastUsed: now,
+	}
+	return sessionID, nil
+}
+
+// expire closes the sessions idle at [now] for longer than the timeout.
+// Assumes [u.lock] is held.
+func (u *uploadSessions) expire(now time.Time) {
+	for sessionID, session := range u.sessions {
+		if now.Sub(session.lastUsed) > u.timeout {
+			delete(u.sessions, sessionID)
+		}
+	}
+}
+
+// get returns the open session [sessionID] of the caller named [owner] used
+// at [now], locked, or nil if there's none
+func (u *uploadSessions) get(sessionID ids.ID, owner string, now time.Time) *uploadSession {
+	u.lock.Lock()
+	session, ok := u.sessions[sessionID]
+	switch {
+	case !ok || session.owner != owner:
+		session = nil
+	case now.Sub(session.lastUsed) > u.timeout:
+		delete(u.sessions, sessionID)
+		session = nil
+	default:
+		session.lastUsed = now
+	}
+	u.lock.Unlock()
+	if session == nil {
+		return nil
+	}
+
+	// The session is locked after releasing the lock of the sessions, so
+	// sessions can be closed while locked
+	session.lock.Lock()
+	if session.closed {
+		session.lock.Unlock()
+		return nil
+	}
+	return session
+}
+
+// close closes [session], which is locked
+func (u *uploadSessions) close(sessionID ids.ID, session *uploadSession) {
+	session.closed = true
+	u.lock.Lock()
+	delete(u.sessions, sessionID)
+	u.lock.Unlock()
+}
+
+// serveBeginUpload begins a session uploading a file in chunks, for files
+// too large to be sent in a single request. The query sets the namespace,
+// digest algorithm and tags as for single uploads, see serveUpload.
+// Beginning a session counts as a proposal towards the rate limit and
+// quotas, the chunks don't.
+func (vm *VM) serveBeginUpload(w http.ResponseWriter, r *http.Request) {
+	if !vm.admitProposal(w, r) {
+		return
+	}
+	args, err := parseUploadArgs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sessionID, err := vm.uploads.begin(uploadOwner(r), args, time.Now())
+	switch {
+	case errors.Is(err, errTooManyUploadSessions):
+		w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, &BeginUploadReply{Session: sessionID})
+}
+
+// serveAppendUpload hashes the body, the next chunk of the session given by
+// the query, whose "offset" must be the number of bytes received so far. A
+// chunk whose offset doesn't match is refused, so chunks can be resent
+// safely when their reply is lost. Each chunk is read completely before
+// it's hashed, so a chunk cut off doesn't corrupt the session. Each read of
+// the chunk must complete within [vm.config.RequestReadTimeout].
+func (vm *VM) serveAppendUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "chunks must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionID, err := ids.FromString(r.URL.Query().Get("session"))
+	if err != nil {
+		http.Error(w, errUnknownUploadSession.Error(), http.StatusNotFound)
+		return
+	}
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, errBadUploadOffset.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxSize := vm.config.MaxUploadChunkSize
+	if r.ContentLength > maxSize {
+		http.Error(w, "chunk too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	chunk := &bytes.Buffer{}
+	body := newTimeoutBody(r.Body, vm.config.RequestReadTimeout.Duration)
+	switch n, err := chunk.ReadFrom(io.LimitReader(body, maxSize+1)); {
+	case err != nil:
+		writeReadError(w, err, "couldn't read chunk")
+		return
+	case n > maxSize:
+		http.Error(w, "chunk too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	session := vm.uploads.get(sessionID, uploadOwner(r), time.Now())
+	if session == nil {
+		http.Error(w, errUnknownUploadSession.Error(), http.StatusNotFound)
+		return
+	}
+	defer session.lock.Unlock()
+	if offset != session.size {
+		http.Error(w, fmt.Sprintf("%s: %d bytes were received", errUnexpectedUploadOffset, session.size), http.StatusConflict)
+		return
+	}
+	n, _ := chunk.WriteTo(session.hasher)
+	session.size += n
+	writeJSON(w, &AppendUploadReply{Size: session.size})
+}
+
+// serveFinishUpload proposes a block anchoring the digest of the file
+// uploaded in the session given by the query, and closes the session. The
+// session is kept open if the proposal is refused.
+func (vm *VM) serveFinishUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "uploads must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionID, err := ids.FromString(r.URL.Query().Get("session"))
+	if err != nil {
+		http.Error(w, errUnknownUploadSession.Error(), http.StatusNotFound)
+		return
+	}
+	session := vm.uploads.get(sessionID, uploadOwner(r), time.Now())
+	if session == nil {
+		http.Error(w, errUnknownUploadSession.Error(), http.StatusNotFound)
+		return
+	}
+	defer session.lock.Unlock()
+
+	reply, err := vm.proposeUpload(r, session.args, session.hasher.Sum(nil), session.size)
+	if err != nil {
+		writeProposalError(w, err)
+		return
+	}
+	vm.uploads.close(sessionID, session)
+	writeJSON(w, reply)
+}
+
+// uploadOwner returns the name of the caller of [r] owning the upload
+// sessions it begins, empty if the API is open
+func uploadOwner(r *http.Request) string {
+	if caller := requestCaller(r); caller != nil {
+		return caller.name
+	}
+	return ""
+}
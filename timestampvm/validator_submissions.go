@@ -0,0 +1,123 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/constants"
+)
+
+var (
+	errValidatorsWithoutSigning   = errors.New("validator submissions require signed submissions")
+	errValidatorsWithAllowlist    = errors.New("validator submissions and the submitter allowlist can't be enabled together")
+	errNoValidatorState           = errors.New("validator submissions require the validator set, which isn't available to this VM")
+	errBadValidatorNodeID         = errors.New("invalid node ID")
+	errNotValidator               = errors.New("submitter isn't the key of a current validator")
+	errPChainHeightDecreased      = errors.New("block's P-chain height is lower than its parent's")
+	errPChainHeightNotYetAccepted = errors.New("block's P-chain height isn't accepted by this node yet")
+
+	_ BlockVerifier = &validatorsVerifier{}
+)
+
+// parseValidatorSubmitters returns the submitter addresses of
+// [Config.ValidatorSubmitters] by node ID
+func parseValidatorSubmitters(submitters map[string]ids.ShortID) (map[ids.ShortID]ids.ShortID, error) {
+	parsed := make(map[ids.ShortID]ids.ShortID, len(submitters))
+	for nodeIDStr, submitter := range submitters {
+		nodeID, err := ids.ShortFromPrefixedString(nodeIDStr, constants.NodeIDPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", errBadValidatorNodeID, nodeIDStr)
+		}
+		parsed[nodeID] = submitter
+	}
+	return parsed, nil
+}
+
+// validatorsVerifier requires blocks to be signed with the submission key of
+// a validator of the subnet. Blocks record the P-chain height whose
+// validators may submit them, so every node checks against the same set.
+type validatorsVerifier struct {
+	vm *VM
+	// submission keys of the validators, by node ID
+	submitters map[ids.ShortID]ids.ShortID
+}
+
+// VerifyBlock implements the BlockVerifier interface
+func (v *validatorsVerifier) VerifyBlock(blk *Block) error {
+	if !blk.IsSigned() {
+		return errUnsignedSubmission
+	}
+	parent, err := v.vm.getBlock(blk.Parent())
+	if err != nil {
+		return errDatabaseGet
+	}
+	if blk.PChainHeight() < parent.PChainHeight() {
+		return errPChainHeightDecreased
+	}
+	currentHeight, err := v.vm.ctx.ValidatorState.GetCurrentHeight()
+	if err != nil {
+		return err
+	}
+	if blk.PChainHeight() > currentHeight {
+		return fmt.Errorf("%w: %d > %d", errPChainHeightNotYetAccepted, blk.PChainHeight(), currentHeight)
+	}
+	submitter, err := blk.Submitter()
+	if err != nil {
+		return err
+	}
+	return v.verifyValidator(blk.PChainHeight(), submitter)
+}
+
+// verifyValidator returns errNotValidator unless [submitter] is the
+// submission key of a validator of the subnet at [pChainHeight]
+func (v *validatorsVerifier) verifyValidator(pChainHeight uint64, submitter ids.ShortID) error {
+	validators, err := v.vm.ctx.ValidatorState.GetValidatorSet(pChainHeight, v.vm.ctx.SubnetID)
+	if err != nil {
+		return err
+	}
+	for nodeID := range validators {
+		if key, ok := v.submitters[nodeID]; ok && key == submitter {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s at P-chain height %d", errNotValidator, submitter, pChainHeight)
+}
+
+// pChainHeight returns the P-chain height a block built on [parentID] is
+// checked against: the current height, but not lower than the parent's
+func (v *validatorsVerifier) pChainHeight(parentID ids.ID) (uint64, error) {
+	parent, err := v.vm.getBlock(parentID)
+	if err != nil {
+		return 0, err
+	}
+	currentHeight, err := v.vm.ctx.ValidatorState.GetCurrentHeight()
+	if err != nil {
+		return 0, err
+	}
+	if currentHeight < parent.PChainHeight() {
+		return parent.PChainHeight(), nil
+	}
+	return currentHeight, nil
+}
+
+// initValidatorsVerifier sets [vm.validators] to the verifier of validator
+// submissions and registers it
+func (vm *VM) initValidatorsVerifier() error {
+	if vm.ctx.ValidatorState == nil {
+		return errNoValidatorState
+	}
+	submitters, err := parseValidatorSubmitters(vm.config.ValidatorSubmitters)
+	if err != nil {
+		return err
+	}
+	vm.validators = &validatorsVerifier{
+		vm:         vm,
+		submitters: submitters,
+	}
+	vm.verifiers = append(vm.verifiers, vm.validators)
+	return nil
+}
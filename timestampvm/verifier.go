@@ -0,0 +1,60 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package timestampvm
+
+import "context"
+
+// BlockVerifier is an additional validation rule that a block must satisfy
+// on top of the core checks performed by [Block.Verify].
+// Verifiers are registered when the VM is constructed and run, in order,
+// every time a block is verified (including blocks built by this node).
+type BlockVerifier interface {
+	// VerifyBlock returns nil iff [blk] satisfies this rule.
+	// [blk]'s parent is guaranteed to be known to the VM.
+	VerifyBlock(blk *Block) error
+}
+
+// ContextBlockVerifier is a BlockVerifier whose checks, e.g. calls to other
+// services, can be canceled. The VM calls VerifyBlockContext instead of
+// VerifyBlock, with a context canceled once the VM shuts down which holds
+// the span the block is verified in.
+type ContextBlockVerifier interface {
+	BlockVerifier
+	// VerifyBlockContext returns nil iff [blk] satisfies this rule, or the
+	// error of [ctx] once it's canceled
+	VerifyBlockContext(ctx context.Context, blk *Block) error
+}
+
+// BlockVerifierFunc allows a plain function to be used as a BlockVerifier
+type BlockVerifierFunc func(blk *Block) error
+
+// VerifyBlock implements the BlockVerifier interface
+func (f BlockVerifierFunc) VerifyBlock(blk *Block) error { return f(blk) }
+
+// NewVM returns a VM which, in addition to the core block validity rules,
+// requires every block to satisfy all of [verifiers]
+func NewVM(verifiers ...BlockVerifier) *VM {
+	return &VM{verifiers: verifiers}
+}
+
+// runVerifiers returns the first error reported by one of the registered
+// verifiers, or nil if [blk] satisfies all of them. Verifiers implementing
+// ContextBlockVerifier are passed [ctx].
+func (vm *VM) runVerifiers(ctx context.Context, blk *Block) error {
+	for _, verifier := range vm.verifiers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		if contextVerifier, ok := verifier.(ContextBlockVerifier); ok {
+			err = contextVerifier.VerifyBlockContext(ctx, blk)
+		} else {
+			err = verifier.VerifyBlock(blk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
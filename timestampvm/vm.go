@@ -15,14 +15,20 @@
 package timestampvm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/rpc/v2"
 	log "github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/chain4travel/caminogo/database"
 	"github.com/chain4travel/caminogo/database/manager"
+	"github.com/chain4travel/caminogo/database/meterdb"
 	"github.com/chain4travel/caminogo/ids"
 	"github.com/chain4travel/caminogo/snow"
 	"github.com/chain4travel/caminogo/snow/choices"
@@ -30,6 +36,7 @@ import (
 	"github.com/chain4travel/caminogo/snow/engine/common"
 	"github.com/chain4travel/caminogo/snow/engine/snowman/block"
 	"github.com/chain4travel/caminogo/utils"
+	"github.com/chain4travel/caminogo/utils/crypto"
 	"github.com/chain4travel/caminogo/utils/json"
 	"github.com/chain4travel/caminogo/version"
 )
@@ -54,6 +61,59 @@ type VM struct {
 	// The context of this vm
 	ctx       *snow.Context
 	dbManager manager.Manager
+	// The database the state is stored in
+	db database.Database
+	// [db] if it was opened for this vm rather than provided by the node,
+	// closed on shutdown
+	ownedDB database.Database
+	// Returns the key the state is encrypted with, overriding the config
+	newEncryptionKey func(*snow.Context) ([]byte, error)
+	// Creates the state on top of the database, NewState unless set by the
+	// factory
+	newState func(db database.Database, vm *VM) (State, error)
+
+	// Configuration of this vm
+	config Config
+
+	// Metrics of this vm, exposed through the node's metrics API
+	registry *prometheus.Registry
+	// Metrics of the block lifecycle, registered with [registry]
+	metrics *vmMetrics
+	// Metrics of the calls to the APIs, registered with [registry]
+	rpcMetrics *rpcMetrics
+	// Limits the rate of calls to the public API
+	rateLimiter *rateLimiter
+	// Counts the calls of each API key and enforces their quotas
+	usage *usageTracker
+	// Holds a value per request being served by the APIs, nil if unlimited
+	requestSlots chan struct{}
+	// Files being uploaded in chunks
+	uploads *uploadSessions
+	// Signs proof-of-existence certificates, nil if they are disabled
+	certificateKey crypto.PrivateKey
+	// Co-signs certificates, nil if they are only signed by this node
+	coSigner *coSigner
+	// Checks the submitters of blocks are validators, nil if anyone may
+	// submit
+	validators *validatorsVerifier
+	// Recognizes the submissions of privileged submitters, nil if there's no
+	// express lane
+	expressLane *expressLane
+	// Addresses whose transfers fund accounts with new funds
+	fundingIssuers ids.ShortSet
+	// Binds submitters to namespaces, nil if none are bound
+	namespaceSubmitters *namespaceSubmitters
+	// Submitters allowed to anchor the heads of other chains, nil if chain
+	// heads are disabled
+	chainHeadSources chainHeadSources
+	// JSON Schemas of the records proposed into namespaces
+	recordSchemas map[string]*jsonSchema
+	// Traces the block lifecycle and the calls to the APIs
+	tracer Tracer
+	// Exports the spans to an OTLP collector, nil unless it's [tracer]
+	otlpTracer *otlpTracer
+	// Records the operations requested through the APIs, nil if disabled
+	auditLog *auditLog
 
 	// State of this VM
 	state State
@@ -65,15 +125,95 @@ type VM struct {
 	toEngine chan<- common.Message
 
 	// Proposed pieces of data that haven't been put into a block and proposed yet
-	mempool [][dataLen]byte
+	mempool *mempool
 
 	// Block ID --> Block
 	// Each element is a block that passed verification but
 	// hasn't yet been accepted/rejected
 	verifiedBlocks map[ids.ID]*Block
 
+	// True while an operator paused the service for maintenance, proposals
+	// are refused with [maintenanceReason]
+	maintenance       bool
+	maintenanceReason string
+
+	// Time this node last accepted a block, or started if it accepted none
+	lastAcceptTime time.Time
+	// Recent durations of building blocks
+	buildDurations latencyWindow
+	// Recent times from proposing data to this node to accepting its block
+	acceptLatencies latencyWindow
+
 	// Indicates that this VM has finised bootstrapping for the chain
 	bootstrapped utils.AtomicBool
+
+	// Additional validation rules every block must satisfy
+	verifiers []BlockVerifier
+
+	// Deletes blocks outside of the configured retention
+	pruner *pruner
+	// Migrates block bodies to the configured compression
+	recompressor *batchJob
+	// Rebuilds the indexes from the stored blocks
+	reindexer *batchJob
+	// Drops the bodies of blocks outside of their namespace's retention
+	retention *batchJob
+	// Compacts the database
+	compactor *compactor
+	// Groups the commits of accepted blocks
+	committer *acceptCommitter
+	// Checks the stored chain for corruption
+	integrity *integrityChecker
+	// Writes snapshots of the database
+	snapshotter *snapshotter
+	// Counts the space taken by the state
+	dbStats *dbStatsCollector
+	// Raises alerts when the configured thresholds are crossed
+	alerter *alerter
+	// Holds the bodies of old blocks if archiving is enabled
+	archiveStore ArchiveStore
+	// Moves old blocks to [archiveStore], nil if archiving is disabled
+	archiver *archiver
+	// Holds scheduled backups if backups are enabled
+	backupStore BackupStore
+	// Writes backups to [backupStore], nil if backups are disabled
+	backups *backupScheduler
+	// External clocks compared with the local clock, in addition to the
+	// configured NTP servers
+	timeSources []TimeSource
+	// Measures the drift of the local clock, nil if there are no time sources
+	driftMonitor *driftMonitor
+	// Publishes the last accepted block to an external chain, nil if
+	// external anchoring is disabled
+	externalAnchorer *externalAnchorer
+	// Pins the content of the anchored CIDs, nil if no IPFS API is
+	// configured
+	ipfs *ipfsPinner
+	// Proposes the events of a C-Chain contract, nil if relaying is
+	// disabled
+	contractEvents *contractEventRelayer
+	// Exports the accepted blocks to PostgreSQL, nil if the export is
+	// disabled
+	postgresExporter *sqlExporter
+	// Mirrors the accepted blocks to a local SQLite database, nil if the
+	// mirror is disabled
+	sqliteMirror *sqlExporter
+	// Publishes the accepted blocks to an MQTT broker, nil if publishing is
+	// disabled
+	mqtt *mqttPublisher
+	// Exports the accepted blocks to a search index, nil if the export is
+	// disabled
+	searchExporter *searchExporter
+
+	// Closed when this VM shuts down, stops background tasks
+	shutdownChan chan struct{}
+	// Canceled when this VM shuts down, the parent of the contexts blocks
+	// are built and verified with and background tasks read the database
+	// with
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+	// Shuts this VM down once, later calls of Shutdown do nothing
+	shutdownOnce sync.Once
 }
 
 // Initialize this vm
@@ -99,27 +239,324 @@ func (vm *VM) Initialize(
 	}
 	log.Info("Initializing Timestamp VM", "Version", version)
 
+	config, err := ParseConfig(configData)
+	if err != nil {
+		return err
+	}
+
 	vm.dbManager = dbManager
 	vm.ctx = ctx
+	vm.config = config
+	vm.registry = prometheus.NewRegistry()
 	vm.toEngine = toEngine
 	vm.verifiedBlocks = make(map[ids.ID]*Block)
+	vm.lastAcceptTime = time.Now()
+	vm.pruner = newPruner(vm)
+	vm.recompressor = newBatchJob(vm, "recompression", vm.newRecompressionRun)
+	vm.reindexer = newBatchJob(vm, "index rebuild", vm.newReindexRun)
+	vm.retention = newBatchJob(vm, "retention", vm.newRetentionRun)
+	vm.integrity = newIntegrityChecker(vm)
+	vm.snapshotter = newSnapshotter(vm)
+	vm.dbStats = newDBStatsCollector(vm)
+	vm.alerter = newAlerter(vm)
+	vm.rateLimiter = newRateLimiter(&config)
+	vm.uploads = newUploadSessions(&config)
+	vm.expressLane, err = newExpressLane(&config, ctx.ChainID)
+	if err != nil {
+		return err
+	}
+	vm.usage = newUsageTracker(vm)
+	vm.certificateKey, err = readCertificateKey(config.CertificateKeyFile)
+	if err != nil {
+		return err
+	}
+	vm.coSigner, err = newCoSigner(&config)
+	if err != nil {
+		return err
+	}
+	vm.externalAnchorer, err = newExternalAnchorer(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.ipfs, err = newIPFSPinner(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.contractEvents, err = newContractEventRelayer(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.postgresExporter, err = newPostgresExporter(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.sqliteMirror, err = newSQLiteMirror(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.mqtt, err = newMQTTPublisher(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.searchExporter, err = newSearchExporter(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.fundingIssuers = ids.NewShortSet(len(config.FundingIssuers))
+	vm.fundingIssuers.Add(config.FundingIssuers...)
+	vm.namespaceSubmitters = newNamespaceSubmitters(&config)
+	vm.chainHeadSources = newChainHeadSources(&config)
+	vm.recordSchemas, err = parseRecordSchemas(config.RecordSchemas)
+	if err != nil {
+		return err
+	}
+	if config.MaxConcurrentRequests > 0 {
+		vm.requestSlots = make(chan struct{}, config.MaxConcurrentRequests)
+	}
+	vm.shutdownChan = make(chan struct{})
+	vm.runCtx, vm.cancelRun = context.WithCancel(context.Background())
+
+	// Register the built-in rules enabled by the config
+	if config.UniquenessWindow > 0 || config.RejectAnchoredData {
+		vm.verifiers = append(vm.verifiers, &uniquenessVerifier{
+			vm:     vm,
+			window: config.UniquenessWindow,
+			ever:   config.RejectAnchoredData,
+		})
+	}
+	if config.SubmitterAllowlistEnabled {
+		vm.verifiers = append(vm.verifiers, &allowlistVerifier{vm: vm})
+	}
+	if config.ValidatorSubmissionsOnly {
+		if err := vm.initValidatorsVerifier(); err != nil {
+			return err
+		}
+	}
+	if config.FeesEnabled {
+		vm.verifiers = append(vm.verifiers, &feeVerifier{vm: vm})
+	}
+	if config.ProofOfWorkBits > 0 {
+		vm.verifiers = append(vm.verifiers, &proofOfWorkVerifier{vm: vm})
+	}
+	if vm.namespaceSubmitters != nil {
+		vm.verifiers = append(vm.verifiers, &namespaceVerifier{vm: vm})
+	}
+	if vm.chainHeadSources != nil {
+		vm.verifiers = append(vm.verifiers, &chainHeadVerifier{vm: vm})
+	}
+	if len(config.SchemaAdmins) > 0 {
+		vm.verifiers = append(vm.verifiers, &schemaVerifier{vm: vm})
+	}
+	if config.RedactionsEnabled {
+		vm.verifiers = append(vm.verifiers, &redactionVerifier{vm: vm})
+	}
+	vm.verifiers = append(vm.verifiers, &revealVerifier{vm: vm})
+	if config.EncryptedPayloadsEnabled {
+		vm.verifiers = append(vm.verifiers, &encryptionVerifier{vm: vm})
+	}
+
+	if err := ctx.Metrics.Register(vm.registry); err != nil {
+		return err
+	}
+	vm.metrics, err = newVMMetrics(vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.mempool = newMempool(vm.metrics.mempoolSize, config.ExpressLaneShare, newNamespaceScheduler(&config))
+	vm.rpcMetrics, err = newRPCMetrics(vm.registry)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !config.TracingEnabled:
+		vm.tracer = noopTracer{}
+	case vm.tracer != nil: // the tracer provided by the factory takes precedence
+	case config.TracingOTLPEndpoint != "":
+		vm.otlpTracer = newOTLPTracer(ctx, &config)
+		vm.tracer = vm.otlpTracer
+	default:
+		vm.tracer = &logTracer{log: ctx.Log}
+	}
+	vm.committer, err = newAcceptCommitter(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+	vm.compactor, err = newCompactor(vm, vm.registry)
+	if err != nil {
+		return err
+	}
+
+	if err := vm.initDriftMonitor(); err != nil {
+		return err
+	}
+
+	if config.AuditLogFile != "" {
+		vm.auditLog, err = openAuditLog(config.AuditLogFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := vm.openDatabase(); err != nil {
+		return err
+	}
+
+	if config.RestoreSnapshot != "" {
+		if err := vm.restoreSnapshot(config.RestoreSnapshot); err != nil {
+			return err
+		}
+	}
+
+	stateDB, err := vm.stateDatabase()
+	if err != nil {
+		return err
+	}
+	if config.ReadOnly {
+		stateDB = &readOnlyDB{Database: stateDB}
+	}
+	// Report the latency and size of database operations
+	stateDB, err = meterdb.New("db", vm.registry, stateDB)
+	if err != nil {
+		return err
+	}
 
 	// Create new state
-	vm.state = NewState(vm.dbManager.Current().Database, vm)
+	newState := vm.newState
+	if newState == nil {
+		newState = NewState
+	}
+	vm.state, err = newState(stateDB, vm)
+	if err != nil {
+		return err
+	}
 
 	// Initialize genesis
 	if err := vm.initGenesis(genesisData); err != nil {
 		return err
 	}
 
+	// A read-only replica serves the allowlist and accounts it was opened with
+	if config.SubmitterAllowlistEnabled && !config.ReadOnly {
+		if err := vm.initAllowlist(); err != nil {
+			return err
+		}
+	}
+	if config.FeesEnabled && !config.ReadOnly {
+		if err := vm.initAccounts(); err != nil {
+			return err
+		}
+	}
+
 	// Get last accepted
 	lastAccepted, err := vm.state.GetLastAccepted()
 	if err != nil {
 		return err
 	}
 
+	// Fail fast on a corrupt chain tip rather than during consensus
+	if err := vm.checkConsistency(lastAccepted); err != nil {
+		return err
+	}
+
+	// Fill the accepted log and child links for chains created before they
+	// were introduced
+	if err := vm.initAcceptedLog(lastAccepted); err != nil {
+		return err
+	}
+
+	// Index the data of chains created before the data index was introduced
+	if err := vm.initDataIndex(); err != nil {
+		return err
+	}
+
+	if config.ArchiveEnabled {
+		if err := vm.initArchiver(); err != nil {
+			return err
+		}
+	}
+
+	if config.BackupSchedule != "" {
+		if err := vm.initBackups(); err != nil {
+			return err
+		}
+	}
+
+	if config.VerifyIntegrityOnStartup {
+		if err := vm.integrity.Verify(); err != nil {
+			return err
+		}
+	}
+
+	if !config.ReadOnly {
+		if err := vm.restoreMempool(); err != nil {
+			return err
+		}
+	}
+
 	ctx.Log.Info("initializing last accepted block as %s", lastAccepted)
 
+	if config.PruningEnabled {
+		go vm.pruner.runPeriodically()
+	}
+	if config.CommitBatchSize > 1 {
+		go vm.committer.runPeriodically()
+	}
+	if config.CompactionInterval.Duration > 0 {
+		go vm.compactor.runPeriodically()
+	}
+	if config.ArchiveEnabled {
+		go vm.archiver.runPeriodically()
+	}
+	if len(config.NamespaceRetention) > 0 {
+		go vm.runRetentionPeriodically()
+	}
+	if vm.backups != nil {
+		go vm.backups.runPeriodically()
+	}
+	if vm.driftMonitor != nil {
+		go vm.driftMonitor.runPeriodically()
+	}
+	if vm.alerter.enabled() {
+		go vm.alerter.runPeriodically()
+	}
+	if vm.externalAnchorer != nil {
+		go vm.externalAnchorer.runPeriodically()
+	}
+	if vm.ipfs != nil {
+		go vm.ipfs.run()
+	}
+	if vm.contractEvents != nil {
+		go vm.contractEvents.runPeriodically()
+	}
+	if vm.postgresExporter != nil {
+		go vm.postgresExporter.runPeriodically()
+	}
+	if vm.sqliteMirror != nil {
+		go vm.sqliteMirror.runPeriodically()
+	}
+	if vm.mqtt != nil {
+		go vm.mqtt.runPeriodically()
+	}
+	if vm.searchExporter != nil {
+		go vm.searchExporter.runPeriodically()
+	}
+	if vm.otlpTracer != nil {
+		go vm.otlpTracer.runPeriodically(vm.shutdownChan)
+	}
+	// Resume rebuilding the indexes if it was interrupted
+	_, err = vm.state.GetJobProgress(reindexJobName)
+	switch {
+	case err == database.ErrNotFound:
+	case err != nil:
+		return err
+	case config.ReadOnly:
+		ctx.Log.Warn("the indexes were being rebuilt, queries may miss blocks until a writable node completes the rebuild")
+	default:
+		if err := vm.reindexer.Trigger(); err != nil {
+			return err
+		}
+	}
+
 	// Build off the most recently accepted block
 	return vm.SetPreference(lastAccepted)
 }
@@ -135,6 +572,9 @@ func (vm *VM) initGenesis(genesisData []byte) error {
 	if stateInitialized {
 		return nil
 	}
+	if vm.config.ReadOnly {
+		return errReadOnlyUninitialized
+	}
 
 	if len(genesisData) > dataLen {
 		return errBadGenesisBytes
@@ -165,31 +605,264 @@ func (vm *VM) initGenesis(genesisData []byte) error {
 		return fmt.Errorf("error accepting genesis block: %w", err)
 	}
 
+	// The data of all blocks is indexed as they are accepted
+	if err := vm.state.SetDataIndexComplete(); err != nil {
+		return err
+	}
+
 	// Mark this vm's state as initialized, so we can skip initGenesis in further restarts
 	if err := vm.state.SetInitialized(); err != nil {
 		return fmt.Errorf("error while setting db to initialized: %w", err)
 	}
 
 	// Flush VM's database to underlying db
+	return vm.committer.Flush()
+}
+
+// initAcceptedLog rebuilds the accepted log and child links of chains created
+// before they were introduced, as the first phase of a reindex. The blocks
+// are added [jobBatchSize] at a time, so a restart resumes the rebuild, and
+// the remaining phase runs in the background once the VM is initialized.
+func (vm *VM) initAcceptedLog(lastAcceptedID ids.ID) error {
+	_, err := vm.state.GetJobProgress(reindexJobName)
+	pending := err == nil
+	if err != nil && err != database.ErrNotFound {
+		return err
+	}
+	if !pending {
+		lastAccepted, err := vm.state.GetBlock(lastAcceptedID)
+		if err != nil {
+			return err
+		}
+		loggedID, err := vm.state.GetAcceptedID(lastAccepted.Height())
+		if err == nil && loggedID == lastAcceptedID {
+			return nil
+		}
+		if err != nil && err != database.ErrNotFound {
+			return err
+		}
+	}
+
+	progress, err := vm.loadReindexProgress()
+	if err != nil || progress.Phase != reindexAcceptedLog {
+		return err
+	}
+	vm.ctx.Log.Info("adding the blocks up to height %d to the accepted log", progress.NextHeight)
+	for progress.Phase == reindexAcceptedLog {
+		if _, err := vm.reindexAcceptedLog(progress); err != nil {
+			vm.state.Abort()
+			return err
+		}
+		if err := vm.saveReindexProgress(progress); err != nil {
+			vm.state.Abort()
+			return err
+		}
+		if err := vm.state.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initDataIndex rebuilds the indexes unless the data index is known to cover
+// all accepted blocks, before blocks are verified against it. A read-only
+// replica can't rebuild them, so it refuses to reject anchored data instead.
+func (vm *VM) initDataIndex() error {
+	complete, err := vm.state.IsDataIndexComplete()
+	if err != nil || complete {
+		return err
+	}
+	if vm.config.ReadOnly {
+		if vm.config.RejectAnchoredData {
+			return errIncompleteDataIndex
+		}
+		vm.ctx.Log.Warn("the data index doesn't cover the blocks accepted before it was introduced")
+		return nil
+	}
+
+	vm.ctx.Log.Info("rebuilding the indexes to cover the blocks accepted before the data index was introduced")
+	step := vm.newReindexRun()
+	for done := false; !done; {
+		if _, _, done, err = step(0); err != nil {
+			vm.state.Abort()
+			return err
+		}
+	}
+	if err := vm.state.SetDataIndexComplete(); err != nil {
+		return err
+	}
 	return vm.state.Commit()
 }
 
+// initArchiver creates the archiver, storing into [vm.archiveStore] or, if
+// unset, into the configured directory
+func (vm *VM) initArchiver() error {
+	if vm.archiveStore == nil {
+		if vm.config.ArchiveDir == "" {
+			return errNoArchiveStore
+		}
+		store, err := NewDirArchiveStore(vm.config.ArchiveDir)
+		if err != nil {
+			return err
+		}
+		vm.archiveStore = store
+	}
+	archiver, err := newArchiver(vm, vm.archiveStore)
+	vm.archiver = archiver
+	return err
+}
+
+// initBackups creates the backup scheduler, storing into [vm.backupStore] or,
+// if unset, into the configured directory
+func (vm *VM) initBackups() error {
+	if vm.backupStore == nil {
+		if vm.config.BackupDir == "" {
+			return errNoBackupStore
+		}
+		store, err := NewDirBackupStore(vm.config.BackupDir)
+		if err != nil {
+			return err
+		}
+		vm.backupStore = store
+	}
+	backups, err := newBackupScheduler(vm, vm.backupStore)
+	vm.backups = backups
+	return err
+}
+
 // CreateHandlers returns a map where:
 // Keys: The path extension for this VM's API (empty in this case)
 // Values: The handler for the API
 func (vm *VM) CreateHandlers() (map[string]*common.HTTPHandler, error) {
+	handlers, err := vm.createHandlers()
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newIPFilter(vm.config.IPAllowlist, vm.config.IPDenylist)
+	if err != nil {
+		return nil, err
+	}
+	cors, err := newCORSPolicy(vm.config.CORSAllowedOrigins, vm.config.CORSAllowedMethods, vm.config.CORSAllowedHeaders)
+	if err != nil {
+		return nil, err
+	}
+	for _, handler := range handlers {
+		handler.Handler = limitConcurrency(vm.requestSlots, handler.Handler)
+		if cors.enabled() {
+			handler.Handler = cors.wrap(handler.Handler)
+		}
+		if filter.enabled() {
+			handler.Handler = filter.wrap(handler.Handler)
+		}
+	}
+	return handlers, nil
+}
+
+// createHandlers returns the handlers of the public, admin and profiling
+// APIs, as enabled by the config
+func (vm *VM) createHandlers() (map[string]*common.HTTPHandler, error) {
 	server := rpc.NewServer()
 	server.RegisterCodec(json.NewCodec(), "application/json")
 	server.RegisterCodec(json.NewCodec(), "application/json;charset=UTF-8")
+	vm.instrumentRPC(server, func(method string) bool { return auditedMethods[method] })
 	if err := server.RegisterService(&Service{vm: vm}, Name); err != nil {
 		return nil, err
 	}
+	if err := registerDiscovery(server, Name, &Service{}, Name); err != nil {
+		return nil, err
+	}
 
-	return map[string]*common.HTTPHandler{
+	access, err := vm.newAccessControl()
+	if err != nil {
+		return nil, err
+	}
+	handler := vm.serveLocked(vm.serveBatches(server))
+	if access.public {
+		handler = access.require(ReaderRole, handler)
+	}
+	handlers := map[string]*common.HTTPHandler{
 		"": {
-			Handler: server,
+			LockOptions: common.NoLock,
+			Handler:     handler,
 		},
-	}, nil
+	}
+	rawHandler := http.Handler(http.HandlerFunc(vm.serveRawProposal))
+	if access.public {
+		rawHandler = access.require(ProposerRole, rawHandler)
+	}
+	handlers["/propose"] = &common.HTTPHandler{
+		LockOptions: common.NoLock,
+		Handler:     vm.auditHTTP(rawHandler),
+	}
+	if vm.config.MaxUploadSize > 0 {
+		uploadHandler := http.Handler(http.HandlerFunc(vm.serveUpload))
+		if access.public {
+			uploadHandler = access.require(ProposerRole, uploadHandler)
+		}
+		// Uploads are hashed before taking the context lock
+		handlers["/upload"] = &common.HTTPHandler{
+			LockOptions: common.NoLock,
+			Handler:     vm.auditHTTP(uploadHandler),
+		}
+	}
+	if vm.config.MaxUploadSessions > 0 {
+		sessionHandlers := map[string]http.HandlerFunc{
+			"/upload/begin":  vm.serveBeginUpload,
+			"/upload/append": vm.serveAppendUpload,
+			"/upload/finish": vm.serveFinishUpload,
+		}
+		for path, sessionHandler := range sessionHandlers {
+			handler := http.Handler(sessionHandler)
+			if access.public {
+				handler = access.require(ProposerRole, handler)
+			}
+			handlers[path] = &common.HTTPHandler{
+				LockOptions: common.NoLock,
+				Handler:     vm.auditHTTP(handler),
+			}
+		}
+	}
+	if !vm.config.AdminAPIEnabled {
+		return handlers, nil
+	}
+
+	adminServer := rpc.NewServer()
+	adminServer.RegisterCodec(json.NewCodec(), "application/json")
+	adminServer.RegisterCodec(json.NewCodec(), "application/json;charset=UTF-8")
+	vm.instrumentRPC(adminServer, func(string) bool { return true })
+	if err := adminServer.RegisterService(&AdminService{vm: vm}, "admin"); err != nil {
+		return nil, err
+	}
+	if err := registerDiscovery(adminServer, Name+" admin", &AdminService{}, "admin"); err != nil {
+		return nil, err
+	}
+	// Keys listed in [APIKeysFile] are only known now
+	if !access.grants(AdminRole) {
+		return nil, errOpenAdminAPI
+	}
+	adminHandler := access.require(AdminRole, vm.serveLocked(vm.serveBatches(adminServer)))
+	snapshotHandler := access.require(AdminRole, http.HandlerFunc(vm.serveSnapshot))
+	handlers["/admin"] = &common.HTTPHandler{
+		LockOptions: common.NoLock,
+		Handler:     adminHandler,
+	}
+	// Streaming a snapshot only holds the context lock while opening it
+	handlers["/admin/snapshot"] = &common.HTTPHandler{
+		LockOptions: common.NoLock,
+		Handler:     vm.auditHTTP(snapshotHandler),
+	}
+	if !vm.config.ProfilingEnabled {
+		return handlers, nil
+	}
+
+	token, err := vm.profilingToken()
+	if err != nil {
+		return nil, err
+	}
+	for path, handler := range vm.profilingHandlers(token) {
+		handlers[path] = handler
+	}
+	return handlers, nil
 }
 
 // CreateStaticHandlers returns a map where:
@@ -211,22 +884,41 @@ func (vm *VM) CreateStaticHandlers() (map[string]*common.HTTPHandler, error) {
 	}, nil
 }
 
-// Health implements the common.VM interface
-func (vm *VM) HealthCheck() (interface{}, error) { return nil, nil }
-
 // BuildBlock returns a block that this vm wants to add to consensus
 func (vm *VM) BuildBlock() (snowman.Block, error) {
-	if len(vm.mempool) == 0 { // There is no block to be built
-		return nil, errNoPendingBlocks
+	if vm.config.ReadOnly {
+		return nil, errReadOnly
+	}
+	// Leave the pending submissions to be saved or dropped with the mempool
+	if err := vm.runCtx.Err(); err != nil {
+		return nil, err
 	}
+	start := time.Now()
+	defer observeSince(vm.metrics.buildDuration, start)
 
-	// Get the value to put in the new block
-	value := vm.mempool[0]
-	vm.mempool = vm.mempool[1:]
+	// Get the submission to put in the new block
+	sub, ok := vm.mempool.Pop()
+	if !ok { // There is no block to be built
+		return nil, errNoPendingBlocks
+	}
+	sub.mempoolSpan.End()
+	ctx, span := vm.tracer.Start(tracedContext(vm.runCtx, sub.traceCtx), "BuildBlock")
+	blk, err := vm.buildBlock(ctx, sub)
+	endSpan(span, err)
+	if err != nil {
+		vm.alerter.BuildFailed()
+		return nil, err
+	}
+	vm.metrics.built.Inc()
+	vm.buildDurations.Add(time.Since(start))
+	return blk, nil
+}
 
+// buildBlock returns a block holding [sub], traced in [ctx]
+func (vm *VM) buildBlock(ctx context.Context, sub *submission) (*Block, error) {
 	// Notify consensus engine that there are more pending data for blocks
 	// (if that is the case) when done building this block
-	if len(vm.mempool) > 0 {
+	if vm.mempool.Len() > 0 {
 		defer vm.NotifyBlockReady()
 	}
 
@@ -238,10 +930,15 @@ func (vm *VM) BuildBlock() (snowman.Block, error) {
 	preferredHeight := preferredBlock.Height()
 
 	// Build the block with preferred height
-	newBlock, err := vm.NewBlock(vm.preferred, preferredHeight+1, value, time.Now())
+	newBlock, err := vm.newBlock(vm.preferred, preferredHeight+1, sub, time.Now())
 	if err != nil {
 		return nil, fmt.Errorf("couldn't build block: %w", err)
 	}
+	// Mark the block as built by this VM, so its data is proposed again
+	// should the block be rejected
+	newBlock.local = true
+	newBlock.traceCtx = ctx
+	newBlock.proposedAt = sub.proposedAt
 
 	// Verifies block
 	if err := newBlock.Verify(); err != nil {
@@ -269,21 +966,80 @@ func (vm *VM) getBlock(blkID ids.ID) (*Block, error) {
 		return blk, nil
 	}
 
-	return vm.state.GetBlock(blkID)
+	blk, err := vm.state.GetBlock(blkID)
+	if err == database.ErrNotFound && vm.archiver != nil {
+		return vm.archiver.GetBlock(blkID)
+	}
+	return blk, err
+}
+
+// getBlockHeader returns the header of [blkID], which is retained when the
+// body of an accepted block is pruned or redacted
+func (vm *VM) getBlockHeader(blkID ids.ID) (*BlockHeader, error) {
+	if blk, exists := vm.verifiedBlocks[blkID]; exists {
+		return newBlockHeader(blk), nil
+	}
+	return vm.state.GetBlockHeader(blkID)
+}
+
+// lastAcceptedBlock returns the block most recently accepted
+func (vm *VM) lastAcceptedBlock() (*Block, error) {
+	lastAcceptedID, err := vm.state.GetLastAccepted()
+	if err != nil {
+		return nil, err
+	}
+	return vm.getBlock(lastAcceptedID)
 }
 
 // LastAccepted returns the block most recently accepted
 func (vm *VM) LastAccepted() (ids.ID, error) { return vm.state.GetLastAccepted() }
 
-// proposeBlock appends [data] to [p.mempool].
+// proposeBlock appends [data] to [vm.mempool].
 // Then it notifies the consensus engine
 // that a new block is ready to be added to consensus
 // (namely, a block with data [data])
 func (vm *VM) proposeBlock(data [dataLen]byte) {
-	vm.mempool = append(vm.mempool, data)
+	vm.proposeSubmission(&submission{data: data})
+}
+
+// proposeSubmission appends [sub] to [vm.mempool] and notifies the consensus
+// engine that a new block is ready to be added to consensus
+func (vm *VM) proposeSubmission(sub *submission) {
+	vm.markExpress(sub)
+	sub.proposedAt = time.Now()
+	_, sub.mempoolSpan = vm.tracer.Start(traceContext(sub.traceCtx), "mempool")
+	vm.mempool.Add(sub)
 	vm.NotifyBlockReady()
 }
 
+// requeueSubmission puts [sub] of a rejected block back into [vm.mempool],
+// unless its data is already pending, part of another processing block or
+// anchored, e.g. by the sibling accepted instead of the rejected block.
+// Returns true if [sub] was requeued.
+func (vm *VM) requeueSubmission(sub *submission) bool {
+	for _, blk := range vm.verifiedBlocks {
+		if blk.Data() == sub.data {
+			return false
+		}
+	}
+	switch _, err := vm.state.GetDataEntry(DataHash(sub.data)); err {
+	case nil:
+		return false
+	case database.ErrNotFound:
+	default:
+		// Proposed again rather than lost
+		vm.ctx.Log.Warn("couldn't look up the data of a rejected block: %s", err)
+	}
+	vm.markExpress(sub)
+	_, sub.mempoolSpan = vm.tracer.Start(traceContext(sub.traceCtx), "mempool")
+	if !vm.mempool.Requeue(sub) {
+		sub.mempoolSpan.End()
+		return false
+	}
+	vm.NotifyBlockReady()
+	return true
+}
+
 // ParseBlock parses [bytes] to a snowman.Block
 // This function is used by the vm's state to unmarshal blocks saved in state
 // and by the consensus layer when it receives the byte representation of a block
@@ -293,10 +1049,14 @@ func (vm *VM) ParseBlock(bytes []byte) (snowman.Block, error) {
 	block := &Block{}
 
 	// Unmarshal the byte repr. of the block into our empty block
-	_, err := Codec.Unmarshal(bytes, block)
+	codecVersion, err := Codec.Unmarshal(bytes, block)
 	if err != nil {
 		return nil, err
 	}
+	// Each block has exactly one valid encoding
+	if codecVersion != block.codecVersion() {
+		return nil, errNonCanonicalBlock
+	}
 
 	// Initialize the block
 	block.Initialize(bytes, choices.Processing, vm)
@@ -316,15 +1076,26 @@ func (vm *VM) ParseBlock(bytes []byte) (snowman.Block, error) {
 // - the block's data is [data]
 // - the block's timestamp is [timestamp]
 func (vm *VM) NewBlock(parentID ids.ID, height uint64, data [dataLen]byte, timestamp time.Time) (*Block, error) {
-	block := &Block{
-		PrntID: parentID,
-		Hght:   height,
-		Tmstmp: timestamp.Unix(),
-		Dt:     data,
+	return vm.newBlock(parentID, height, &submission{data: data}, timestamp)
+}
+
+// newBlock returns a new Block containing the submission [sub]
+func (vm *VM) newBlock(parentID ids.ID, height uint64, sub *submission, timestamp time.Time) (*Block, error) {
+	block := sub.block()
+	block.PrntID = parentID
+	block.Hght = height
+	block.Tmstmp = timestamp.Unix()
+	// The genesis block has no submitter
+	if vm.validators != nil && height > 0 {
+		var err error
+		block.PChnHt, err = vm.validators.pChainHeight(parentID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Get the byte representation of the block
-	blockBytes, err := Codec.Marshal(CodecVersion, block)
+	blockBytes, err := block.marshal()
 	if err != nil {
 		return nil, err
 	}
@@ -335,13 +1106,54 @@ func (vm *VM) NewBlock(parentID ids.ID, height uint64, data [dataLen]byte, times
 	return block, nil
 }
 
-// Shutdown this vm
+// Shutdown this vm. New proposals are refused from now on. Pending
+// submissions are saved if [vm.config.PersistMempool] is set, and dropped
+// otherwise. Gives up after [vm.config.ShutdownTimeout], if it's set.
+// Shutting down again does nothing.
 func (vm *VM) Shutdown() error {
 	if vm.state == nil {
 		return nil
 	}
 
-	return vm.state.Close() // close versionDB
+	first := false
+	vm.shutdownOnce.Do(func() {
+		first = true
+		vm.mempool.SetLocked(true)
+		close(vm.shutdownChan) // stop background tasks
+		vm.cancelRun()         // abort building and database reads
+	})
+	if !first {
+		return nil
+	}
+
+	timeout := vm.config.ShutdownTimeout.Duration
+	if timeout <= 0 {
+		return vm.shutdown()
+	}
+	done := make(chan error, 1)
+	go func() { done <- vm.shutdown() }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		vm.ctx.Log.Error("shutdown didn't complete within %s", timeout)
+		return errShutdownTimeout
+	}
+}
+
+// isShutdown returns true once this vm is shut down.
+// Background tasks must check it after acquiring the context lock, before
+// touching state.
+func (vm *VM) isShutdown() bool {
+	select {
+	case <-vm.shutdownChan:
+		return true
+	default:
+		return false
+	}
 }
 
 // SetPreference sets the block with ID [ID] as the preferred block
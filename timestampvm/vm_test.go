@@ -15,14 +15,54 @@
 package timestampvm
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/chain4travel/caminogo/api"
+	"github.com/chain4travel/caminogo/database"
 	"github.com/chain4travel/caminogo/database/manager"
+	"github.com/chain4travel/caminogo/database/memdb"
+	"github.com/chain4travel/caminogo/database/prefixdb"
 	"github.com/chain4travel/caminogo/ids"
 	"github.com/chain4travel/caminogo/snow"
+	"github.com/chain4travel/caminogo/snow/choices"
 	"github.com/chain4travel/caminogo/snow/engine/common"
+	"github.com/chain4travel/caminogo/snow/validators"
+	"github.com/chain4travel/caminogo/utils/constants"
+	"github.com/chain4travel/caminogo/utils/crypto"
+	"github.com/chain4travel/caminogo/utils/formatting"
+	"github.com/chain4travel/caminogo/utils/hashing"
+	"github.com/chain4travel/caminogo/utils/json"
+	"github.com/chain4travel/caminogo/utils/logging"
 	"github.com/chain4travel/caminogo/version"
+	"github.com/chain4travel/caminogo/vms"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	// the drivers the plugin links
+	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/chain4travel/camino-timestampvm/verify"
 )
 
 var blockchainID = ids.ID{1, 2, 3}
@@ -170,12 +210,5941 @@ func TestSetState(t *testing.T) {
 	assert.ErrorIs(vm.SetState(unknownState), snow.ErrUnknownState)
 }
 
-func newTestVM() (*VM, *snow.Context, chan common.Message, error) {
+func TestBlockVerifier(t *testing.T) {
+	assert := assert.New(t)
+	errRejected := errors.New("rejected by test verifier")
+	rejected := [dataLen]byte{0, 0, 0, 0, 9}
+	vm, _, _, err := newTestVM(BlockVerifierFunc(func(blk *Block) error {
+		if blk.Data() == rejected {
+			return errRejected
+		}
+		return nil
+	}))
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(lastAcceptedID))
+
+	// a block the verifier is fine with can be built
+	vm.proposeBlock([dataLen]byte{0, 0, 0, 0, 1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.SetPreference(blk.ID()))
+
+	// a block the verifier refuses is neither built nor verified
+	vm.proposeBlock(rejected)
+	_, err = vm.BuildBlock()
+	assert.ErrorIs(err, errRejected)
+
+	badBlk, err := vm.NewBlock(blk.ID(), blk.Height()+1, rejected, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(badBlk.Verify(), errRejected)
+	_, exists := vm.verifiedBlocks[badBlk.ID()]
+	assert.False(exists)
+}
+
+// cancelableVerifier records the contexts it verifies blocks with
+type cancelableVerifier struct {
+	contexts []context.Context
+}
+
+func (v *cancelableVerifier) VerifyBlock(*Block) error {
+	return errors.New("called without a context")
+}
+
+func (v *cancelableVerifier) VerifyBlockContext(ctx context.Context, _ *Block) error {
+	v.contexts = append(v.contexts, ctx)
+	return ctx.Err()
+}
+
+func TestContextBlockVerifier(t *testing.T) {
+	assert := assert.New(t)
+	verifier := &cancelableVerifier{}
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"tracingEnabled": true}`), verifier)
+	assert.NoError(err)
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(lastAcceptedID))
+
+	// blocks are built and verified in the span of the call proposing them,
+	// but aren't canceled with it
+	service := Service{vm}
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+	callCtx, cancelCall := context.WithCancel(context.Background())
+	callCtx, span := vm.tracer.Start(callCtx, "proposeBlock")
+	r := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(callCtx)
+	assert.NoError(service.ProposeBlock(r, &ProposeBlockArgs{Data: encodedData}, &ProposeBlockReply{}))
+	span.End()
+	cancelCall()
+	_, err = vm.BuildBlock()
+	assert.NoError(err)
+	assert.Len(verifier.contexts, 1)
+	assert.NoError(verifier.contexts[0].Err())
+	assert.NotNil(verifier.contexts[0].Value(logTracerKey{}))
+
+	// verification is canceled once the VM shuts down
+	vm.proposeBlock([dataLen]byte{1})
+	assert.NoError(vm.Shutdown())
+	assert.ErrorIs(verifier.contexts[0].Err(), context.Canceled)
+	_, err = vm.BuildBlock()
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(1, vm.mempool.Len())
+}
+
+func TestDroppedBlockFields(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+
+	// fields left out by the codec version of the block fail loudly
+	for _, sub := range []*submission{
+		{update: &AllowlistUpdate{}, pow: &ProofOfWork{}},
+		{transfer: &Transfer{Amount: 1}, tags: []Tag{{Key: "docType", Value: "invoice"}}},
+		{grant: &CreditGrant{Credits: 1}, namespace: "bookings"},
+		{redaction: &Redaction{Height: 1}, tags: []Tag{{Key: "docType", Value: "invoice"}}},
+	} {
+		_, err := vm.newBlock(genesisID, 1, sub, time.Now())
+		assert.ErrorIs(err, errDroppedBlockField)
+	}
+
+	// while the fields of a block are all parsed back
+	blk, err := vm.newBlock(genesisID, 1, &submission{
+		data:      [dataLen]byte{1},
+		sig:       make([]byte, crypto.SECP256K1RSigLen),
+		namespace: "bookings",
+		tags:      []Tag{{Key: "docType", Value: "invoice"}},
+		reveal:    &Reveal{Salt: ids.ID{2}},
+	}, time.Now())
+	assert.NoError(err)
+	parsed, err := vm.ParseBlock(blk.Bytes())
+	assert.NoError(err)
+	assert.Equal("bookings", parsed.(*Block).Namespace())
+	assert.Equal(blk.Tags(), parsed.(*Block).Tags())
+	assert.Equal(blk.Reveal(), parsed.(*Block).Reveal())
+}
+
+func TestUniquenessWindow(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"uniquenessWindow": 2}`))
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(lastAcceptedID))
+
+	buildAndAccept := func(data [dataLen]byte) error {
+		vm.proposeBlock(data)
+		blk, err := vm.BuildBlock()
+		if err != nil {
+			return err
+		}
+		if err := blk.Accept(); err != nil {
+			return err
+		}
+		return vm.SetPreference(blk.ID())
+	}
+
+	a, b, c := [dataLen]byte{1}, [dataLen]byte{2}, [dataLen]byte{3}
+	assert.NoError(buildAndAccept(a))
+	assert.NoError(buildAndAccept(b))
+	// [a] is one of the last 2 blocks
+	assert.ErrorIs(buildAndAccept(a), errDuplicateData)
+	assert.NoError(buildAndAccept(c))
+	// [a] dropped out of the window
+	assert.NoError(buildAndAccept(a))
+}
+
+func TestRejectRequeuesData(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(lastAcceptedID))
+
+	data := [dataLen]byte{0, 0, 0, 0, 1}
+	vm.proposeBlock(data)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.Equal(0, vm.mempool.Len())
+
+	// the data of our own rejected block is pending again, exactly once
+	assert.NoError(blk.Reject())
+	assert.Equal(1, vm.mempool.Len())
+	assert.False(vm.requeueSubmission(&submission{data: data}))
+	assert.Equal(1, vm.mempool.Len())
+
+	rebuilt, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.Equal(data, rebuilt.(*Block).Data())
+
+	// blocks built by other nodes don't feed our mempool
+	foreign, err := vm.NewBlock(lastAcceptedID, 1, [dataLen]byte{0, 0, 0, 0, 2}, time.Now())
+	assert.NoError(err)
+	assert.NoError(foreign.Verify())
+	assert.NoError(foreign.Reject())
+	assert.Equal(0, vm.mempool.Len())
+}
+
+func TestRejectAnchoredSibling(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(lastAcceptedID))
+
+	// another node built a block with the same data, e.g. relayed from the
+	// same contract event
+	data := [dataLen]byte{0, 0, 0, 0, 1}
+	vm.proposeBlock(data)
+	local, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(local.Verify())
+	foreign, err := vm.NewBlock(lastAcceptedID, 1, data, time.Now().Add(time.Second))
+	assert.NoError(err)
+	assert.NotEqual(local.ID(), foreign.ID())
+	assert.NoError(foreign.Verify())
+
+	// once the other block is accepted, the data isn't proposed again
+	assert.NoError(vm.SetPreference(foreign.ID()))
+	assert.NoError(foreign.Accept())
+	assert.NoError(local.Reject())
+	assert.Equal(0, vm.mempool.Len())
+	_, err = vm.BuildBlock()
+	assert.ErrorIs(err, errNoPendingBlocks)
+}
+
+func TestRejectAnchoredData(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"rejectAnchoredData": true}`))
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(lastAcceptedID))
+
+	data := [dataLen]byte{1}
+	vm.proposeBlock(data)
+	blk1, err := vm.BuildBlock()
+	assert.NoError(err)
+
+	// a processing ancestor holds the data
+	vm.proposeBlock(data)
+	assert.NoError(vm.SetPreference(blk1.ID()))
+	_, err = vm.BuildBlock()
+	assert.ErrorIs(err, errDuplicateData)
+
+	// an accepted block holds the data
+	assert.NoError(blk1.Accept())
+	vm.proposeBlock(data)
+	_, err = vm.BuildBlock()
+	assert.ErrorIs(err, errDuplicateData)
+
+	entry, err := vm.state.GetDataEntry(DataHash(data))
+	assert.NoError(err)
+	assert.Equal(blk1.ID(), entry.BlkID)
+	assert.Equal(uint64(1), entry.Height)
+
+	// the block can be looked up by its data
+	service := Service{vm}
+	encoded, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	reply := GetBlockReply{}
+	assert.NoError(service.GetBlockByData(nil, &GetBlockByDataArgs{Data: encoded}, &reply))
+	assert.Equal(blk1.ID(), reply.ID)
+}
+
+func TestDataIndexUpgrade(t *testing.T) {
+	assert := assert.New(t)
 	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	configData := []byte(`{"rejectAnchoredData": true}`)
+	vm, _, _, err := newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	data := [dataLen]byte{1}
+	vm.proposeBlock(data)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.Shutdown())
+
+	// drop the data index, as on a chain created before it was introduced
+	db := dbManager.Current().Database
+	dataIndexDB := prefixdb.New(dataIndexPrefix, db)
+	it := dataIndexDB.NewIterator()
+	for it.Next() {
+		assert.NoError(dataIndexDB.Delete(it.Key()))
+	}
+	assert.NoError(it.Error())
+	it.Release()
+	assert.NoError(prefixdb.New(singletonStatePrefix, db).Delete(dataIndexCompleteKey))
+
+	// a read-only replica can't rebuild it
+	_, _, _, err = newTestVMWithDB(dbManager, []byte(`{"rejectAnchoredData": true, "readOnly": true}`))
+	assert.ErrorIs(err, errIncompleteDataIndex)
+
+	// other nodes rebuild it before verifying blocks against it
+	vm, _, _, err = newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+	complete, err := vm.state.IsDataIndexComplete()
+	assert.NoError(err)
+	assert.True(complete)
+	entry, err := vm.state.GetDataEntry(DataHash(data))
+	assert.NoError(err)
+	assert.Equal(blk.ID(), entry.BlkID)
+
+	assert.NoError(vm.SetPreference(blk.ID()))
+	vm.proposeBlock(data)
+	_, err = vm.BuildBlock()
+	assert.ErrorIs(err, errDuplicateData)
+	assert.NoError(vm.Shutdown())
+}
+
+func TestSignedSubmissions(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"signedSubmissions": true}`))
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(lastAcceptedID))
+
+	key, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	data := [dataLen]byte{1}
+	msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+	assert.NoError(err)
+	sig, err := key.Sign(msg)
+	assert.NoError(err)
+
+	service := Service{vm}
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+	assert.NoError(err)
+
+	// malformed signatures are refused
+	malformedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig[1:])
+	assert.NoError(err)
+	err = service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Signature: malformedSig}, &ProposeBlockReply{})
+	assert.ErrorIs(err, errBadSignature)
+
+	proposeReply := ProposeBlockReply{}
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Signature: encodedSig}, &proposeReply))
+	assert.Equal(key.PublicKey().Address(), *proposeReply.Submitter)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+
+	// signed blocks survive a round trip through their bytes
+	parsed, err := vm.ParseBlock(blk.Bytes())
+	assert.NoError(err)
+	submitter, err := parsed.(*Block).Submitter()
+	assert.NoError(err)
+	assert.Equal(key.PublicKey().Address(), submitter)
+	assert.NoError(blk.Accept())
+
+	reply := GetBlocksReply{}
+	assert.NoError(service.GetBlocksBySubmitter(nil, &GetBlocksBySubmitterArgs{Submitter: submitter}, &reply))
+	assert.Len(reply.Blocks, 1)
+	assert.Equal(blk.ID(), reply.Blocks[0].ID)
+	assert.Equal(submitter, *reply.Blocks[0].Submitter)
+
+	reply = GetBlocksReply{}
+	assert.NoError(service.GetBlocksBySubmitter(nil, &GetBlocksBySubmitterArgs{Submitter: submitter, StartHeight: 2}, &reply))
+	assert.Empty(reply.Blocks)
+}
+
+func TestSignedSubmissionsDisabled(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	blk, err := vm.newBlock(lastAcceptedID, 1, &submission{data: [dataLen]byte{1}, sig: []byte{1}}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(blk.Verify(), errSignedSubmissionsDisabled)
+}
+
+func TestSubmitterAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	keys := make([]*crypto.PrivateKeySECP256K1R, 3)
+	for i := range keys {
+		key, err := secpFactory.NewPrivateKey()
+		assert.NoError(err)
+		keys[i] = key.(*crypto.PrivateKeySECP256K1R)
+	}
+	admin, member, outsider := keys[0], keys[1], keys[2]
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "submitterAllowlistEnabled": true, "submitterAllowlist": [%q], "submitterAllowlistAdmins": [%q]}`,
+		member.PublicKey().Address(), admin.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// returns a block on [parent] anchoring [data], or [update] if set
+	newBlock := func(parent *Block, key *crypto.PrivateKeySECP256K1R, data [dataLen]byte, update *AllowlistUpdate) *Block {
+		if update != nil {
+			data, err = AllowlistUpdateData(update)
+			assert.NoError(err)
+		}
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: data, sig: sig, update: update}, time.Now())
+		assert.NoError(err)
+		return blk
+	}
+	propose := func(key *crypto.PrivateKeySECP256K1R, data [dataLen]byte) error {
+		args := &ProposeBlockArgs{}
+		args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		if key != nil {
+			msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+			assert.NoError(err)
+			sig, err := key.Sign(msg)
+			assert.NoError(err)
+			args.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, sig)
+			assert.NoError(err)
+		}
+		return service.ProposeBlock(nil, args, &ProposeBlockReply{})
+	}
+	assert.ErrorIs(propose(nil, [dataLen]byte{1}), errUnsignedSubmission)
+	assert.ErrorIs(propose(outsider, [dataLen]byte{1}), errSubmitterNotAllowed)
+	assert.NoError(propose(member, [dataLen]byte{1}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.SetPreference(blk.ID()))
+	parent := blk.(*Block)
+	assert.ErrorIs(newBlock(parent, outsider, [dataLen]byte{2}, nil).Verify(), errSubmitterNotAllowed)
+
+	// only admins sign updates
+	update := &AllowlistUpdate{
+		Add:    []ids.ShortID{outsider.PublicKey().Address()},
+		Remove: []ids.ShortID{member.PublicKey().Address()},
+	}
+	assert.ErrorIs(newBlock(parent, member, [dataLen]byte{}, update).Verify(), errNotAllowlistAdmin)
+	updateMsg, err := AllowlistUpdateMessage(vm.ctx.ChainID, update)
+	assert.NoError(err)
+	updateSig, err := admin.Sign(updateMsg)
+	assert.NoError(err)
+	encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, updateSig)
+	assert.NoError(err)
+	proposeReply := ProposeBlockReply{}
+	assert.NoError(service.ProposeAllowlistUpdate(nil, &ProposeAllowlistUpdateArgs{AllowlistUpdate: *update, Signature: encodedSig}, &proposeReply))
+	assert.Equal(admin.PublicKey().Address(), *proposeReply.Submitter)
+	updateBlk, err := vm.BuildBlock()
+	assert.NoError(err)
+
+	// updates survive a round trip through their bytes
+	parsed, err := vm.ParseBlock(updateBlk.Bytes())
+	assert.NoError(err)
+	assert.Equal(update, parsed.(*Block).AllowlistUpdate())
+
+	// the update applies to the descendants of the processing update block
+	assert.NoError(newBlock(updateBlk.(*Block), outsider, [dataLen]byte{2}, nil).Verify())
+	assert.ErrorIs(newBlock(updateBlk.(*Block), member, [dataLen]byte{2}, nil).Verify(), errSubmitterNotAllowed)
+	// but not to its siblings
+	assert.NoError(newBlock(parent, member, [dataLen]byte{2}, nil).Verify())
+	// and it can't be replayed
+	assert.ErrorIs(newBlock(updateBlk.(*Block), admin, [dataLen]byte{}, update).Verify(), errAllowlistUpdateNonce)
+
+	assert.NoError(updateBlk.Accept())
+	reply := GetSubmitterAllowlistReply{}
+	assert.NoError(service.GetSubmitterAllowlist(nil, nil, &reply))
+	assert.True(reply.Enabled)
+	assert.Equal(json.Uint64(1), reply.Nonce)
+	assert.Equal([]ids.ShortID{outsider.PublicKey().Address()}, reply.Submitters)
+	assert.ErrorIs(propose(member, [dataLen]byte{3}), errSubmitterNotAllowed)
+	assert.NoError(propose(outsider, [dataLen]byte{3}))
+
+	_, err = ParseConfig([]byte(`{"submitterAllowlistEnabled": true}`))
+	assert.ErrorIs(err, errAllowlistWithoutSigning)
+
+	// chains without an allowlist refuse updates
+	vm, _, _, err = newTestVMWithConfig([]byte(`{"signedSubmissions": true}`))
+	assert.NoError(err)
+	genesis, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+	update.Nonce = 0
+	assert.ErrorIs(newBlock(genesis, admin, [dataLen]byte{}, update).Verify(), errAllowlistDisabled)
+}
+
+func TestValidatorSubmissions(t *testing.T) {
+	assert := assert.New(t)
+	validatorKey, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	otherKey, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	validatorID, formerValidatorID := ids.ShortID{1}, ids.ShortID{2}
+	configData := []byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "validatorSubmissionsOnly": true, "validatorSubmitters": {%q: %q, %q: %q}}`,
+		validatorID.PrefixedString(constants.NodeIDPrefix), validatorKey.PublicKey().Address(),
+		formerValidatorID.PrefixedString(constants.NodeIDPrefix), otherKey.PublicKey().Address(),
+	))
+
+	// the validator set must be available
+	_, _, _, err = newTestVMWithConfig(configData)
+	assert.ErrorIs(err, errNoValidatorState)
+
+	// the former validator left at P-chain height 10
+	pChainHeight := uint64(10)
+	validatorState := &validators.TestState{
+		GetCurrentHeightF: func() (uint64, error) { return pChainHeight, nil },
+		GetValidatorSetF: func(height uint64, _ ids.ID) (map[ids.ShortID]uint64, error) {
+			if height < 10 {
+				return map[ids.ShortID]uint64{validatorID: 1, formerValidatorID: 1}, nil
+			}
+			return map[ids.ShortID]uint64{validatorID: 1}, nil
+		},
+	}
+	vm := NewVM()
+	ctx := snow.DefaultContextTest()
+	ctx.ChainID = blockchainID
+	ctx.ValidatorState = validatorState
+	assert.NoError(vm.Initialize(ctx, manager.NewMemDB(version.DefaultVersion1_0_0), []byte{0, 0, 0, 0, 0}, nil, configData, make(chan common.Message, 1), nil, nil))
+	genesis, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesis.ID()))
+
+	service := Service{vm}
+	propose := func(key crypto.PrivateKey, data [dataLen]byte) error {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		args := &ProposeBlockArgs{}
+		args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		args.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		return service.ProposeBlock(nil, args, &ProposeBlockReply{})
+	}
+	assert.ErrorIs(propose(otherKey, [dataLen]byte{1}), errNotValidator)
+	assert.NoError(propose(validatorKey, [dataLen]byte{1}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.Equal(uint64(10), blk.(*Block).PChainHeight())
+
+	// blocks record the P-chain height, so they are checked against the same
+	// validators by all nodes
+	parsed, err := vm.ParseBlock(blk.Bytes())
+	assert.NoError(err)
+	assert.Equal(uint64(10), parsed.(*Block).PChainHeight())
+	assert.NoError(blk.Accept())
+
+	// the former validator's blocks stay valid at the height they recorded
+	msg, err := SubmissionMessage(vm.ctx.ChainID, [dataLen]byte{2})
+	assert.NoError(err)
+	sig, err := otherKey.Sign(msg)
+	assert.NoError(err)
+	newBlock := func(pChainHeight uint64) *Block {
+		block := &Block{
+			PrntID: genesis.ID(),
+			Hght:   1,
+			Tmstmp: time.Now().Unix(),
+			Dt:     [dataLen]byte{2},
+			Sgntr:  sig,
+			PChnHt: pChainHeight,
+		}
+		blockBytes, err := Codec.Marshal(block.codecVersion(), block)
+		assert.NoError(err)
+		block.Initialize(blockBytes, choices.Processing, vm)
+		return block
+	}
+	assert.NoError(newBlock(9).Verify())
+	assert.ErrorIs(newBlock(10).Verify(), errNotValidator)
+	assert.ErrorIs(newBlock(11).Verify(), errPChainHeightNotYetAccepted)
+
+	// schema updates don't record the P-chain height, so they can't be
+	// built on this chain: peers would parse them without it
+	update := &SchemaUpdate{Schema: PayloadSchema{Name: "booking"}}
+	updateData, err := SchemaUpdateData(update)
+	assert.NoError(err)
+	_, err = vm.newBlock(genesis.ID(), 1, &submission{data: updateData, sig: sig, schema: update}, time.Now())
+	assert.ErrorIs(err, errDroppedBlockField)
+	_, err = ParseConfig([]byte(fmt.Sprintf(`{"signedSubmissions": true, "validatorSubmissionsOnly": true, "schemaAdmins": [%q]}`, validatorKey.PublicKey().Address())))
+	assert.ErrorIs(err, errSchemasWithValidators)
+
+	// neither do redactions
+	redaction := &Redaction{BlkID: blk.ID(), Height: 1}
+	redactionData, err := RedactionData(redaction)
+	assert.NoError(err)
+	_, err = vm.newBlock(blk.ID(), 2, &submission{data: redactionData, sig: sig, redaction: redaction}, time.Now())
+	assert.ErrorIs(err, errDroppedBlockField)
+	_, err = ParseConfig([]byte(`{"signedSubmissions": true, "validatorSubmissionsOnly": true, "redactionsEnabled": true}`))
+	assert.ErrorIs(err, errRedactionsWithValidators)
+
+	// nor key registrations, which encrypted payloads require
+	registration := &KeyRegistration{PublicKey: validatorKey.PublicKey().Bytes()}
+	registrationData, err := KeyRegistrationData(registration)
+	assert.NoError(err)
+	_, err = vm.newBlock(blk.ID(), 2, &submission{data: registrationData, sig: sig, keyReg: registration}, time.Now())
+	assert.ErrorIs(err, errDroppedBlockField)
+	_, err = ParseConfig([]byte(`{"signedSubmissions": true, "validatorSubmissionsOnly": true, "encryptedPayloadsEnabled": true}`))
+	assert.ErrorIs(err, errEncryptionWithValidators)
+
+	_, err = ParseConfig([]byte(`{"validatorSubmissionsOnly": true}`))
+	assert.ErrorIs(err, errValidatorsWithoutSigning)
+	_, err = ParseConfig([]byte(`{"signedSubmissions": true, "validatorSubmissionsOnly": true, "submitterAllowlistEnabled": true}`))
+	assert.ErrorIs(err, errValidatorsWithAllowlist)
+	_, err = ParseConfig([]byte(`{"validatorSubmitters": {"node-1": "111111111111111111116DBWJs"}}`))
+	assert.ErrorIs(err, errBadValidatorNodeID)
+}
+
+func TestFees(t *testing.T) {
+	assert := assert.New(t)
+	alice, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	bob, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	recipient := ids.ShortID{9}
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "feesEnabled": true, "submissionFee": 10, "feeRecipient": %q, "feeBalances": {%q: 40}}`,
+		recipient, alice.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// returns a block on [parent] anchoring [data], or [transfer] if set
+	newBlock := func(parent *Block, key crypto.PrivateKey, data [dataLen]byte, transfer *Transfer) *Block {
+		if transfer != nil {
+			data, err = TransferData(transfer)
+			assert.NoError(err)
+		}
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: data, sig: sig, transfer: transfer}, time.Now())
+		assert.NoError(err)
+		return blk
+	}
+	propose := func(key crypto.PrivateKey, data [dataLen]byte) error {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		args := &ProposeBlockArgs{}
+		args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		args.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		return service.ProposeBlock(nil, args, &ProposeBlockReply{})
+	}
+	account := func(address ids.ShortID) GetAccountReply {
+		reply := GetAccountReply{}
+		assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: address}, &reply))
+		return reply
+	}
+
+	assert.ErrorIs(propose(bob, [dataLen]byte{1}), errInsufficientBalance)
+	assert.NoError(propose(alice, [dataLen]byte{1}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.SetPreference(blk.ID()))
+	parent := blk.(*Block)
+	assert.Equal(json.Uint64(30), account(alice.PublicKey().Address()).Balance)
+	assert.Equal(json.Uint64(10), account(recipient).Balance)
+	totals := GetFeeTotalsReply{}
+	assert.NoError(service.GetFeeTotals(nil, nil, &totals))
+	assert.Equal(FeesToTreasury, totals.Sink)
+	assert.Equal(&recipient, totals.Treasury)
+	assert.Equal(json.Uint64(10), totals.Collected)
+	assert.Equal(json.Uint64(0), totals.Burnt)
+
+	transfer := &Transfer{To: bob.PublicKey().Address(), Amount: 10}
+	transferMsg, err := TransferMessage(vm.ctx.ChainID, transfer)
+	assert.NoError(err)
+	transferSig, err := alice.Sign(transferMsg)
+	assert.NoError(err)
+	encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, transferSig)
+	assert.NoError(err)
+	assert.NoError(service.ProposeTransfer(nil, &ProposeTransferArgs{To: transfer.To, Amount: 10, Signature: encodedSig}, &ProposeBlockReply{}))
+	transferBlk, err := vm.BuildBlock()
+	assert.NoError(err)
+
+	// transfers survive a round trip through their bytes
+	parsed, err := vm.ParseBlock(transferBlk.Bytes())
+	assert.NoError(err)
+	assert.Equal(transfer, parsed.(*Block).Transfer())
+
+	// the transfer funds the descendants of the processing transfer block
+	assert.NoError(newBlock(transferBlk.(*Block), bob, [dataLen]byte{2}, nil).Verify())
+	// but not its siblings
+	assert.ErrorIs(newBlock(parent, bob, [dataLen]byte{2}, nil).Verify(), errInsufficientBalance)
+	// and it can't be replayed
+	assert.ErrorIs(newBlock(transferBlk.(*Block), alice, [dataLen]byte{}, transfer).Verify(), errTransferNonce)
+
+	assert.NoError(transferBlk.Accept())
+	assert.Equal(GetAccountReply{Balance: 10, Nonce: 1, Fee: 10}, account(alice.PublicKey().Address()))
+	assert.Equal(json.Uint64(10), account(bob.PublicKey().Address()).Balance)
+	assert.Equal(json.Uint64(20), account(recipient).Balance)
+
+	// signed data can't be replayed to charge its submitter again, whether
+	// the first submission is accepted or processing
+	assert.ErrorIs(newBlock(transferBlk.(*Block), alice, [dataLen]byte{1}, nil).Verify(), errReplayedSubmission)
+	bobBlk := newBlock(transferBlk.(*Block), bob, [dataLen]byte{2}, nil)
+	assert.NoError(bobBlk.Verify())
+	assert.ErrorIs(newBlock(bobBlk, bob, [dataLen]byte{2}, nil).Verify(), errReplayedSubmission)
+	// but other submitters may anchor the same data
+	assert.NoError(newBlock(transferBlk.(*Block), alice, [dataLen]byte{2}, nil).Verify())
+
+	_, err = ParseConfig([]byte(`{"feesEnabled": true}`))
+	assert.ErrorIs(err, errFeesWithoutSigning)
+	_, err = ParseConfig([]byte(`{"feeBalances": {"alice": 1}}`))
+	assert.ErrorIs(err, errBadFeeAddress)
+
+	// chains without fees refuse transfers
+	vm, _, _, err = newTestVMWithConfig([]byte(`{"signedSubmissions": true}`))
+	assert.NoError(err)
+	genesis, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+	assert.ErrorIs(newBlock(genesis, alice, [dataLen]byte{}, transfer).Verify(), errFeesDisabled)
+}
+
+func TestFeeTotals(t *testing.T) {
+	assert := assert.New(t)
+	alice, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "feesEnabled": true, "submissionFee": 10, "feeBalances": {%q: 100}}`,
+		alice.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesis, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesis.ID()))
+	service := Service{vm}
+
+	newBlock := func(parent *Block, data [dataLen]byte) *Block {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := alice.Sign(msg)
+		assert.NoError(err)
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: data, sig: sig}, time.Now())
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		return blk
+	}
+	burnt := func() json.Uint64 {
+		totals := GetFeeTotalsReply{}
+		assert.NoError(service.GetFeeTotals(nil, nil, &totals))
+		assert.Equal(json.Uint64(0), totals.Collected)
+		return totals.Burnt
+	}
+
+	// verified blocks aren't counted until they're accepted
+	accepted := newBlock(genesis, [dataLen]byte{1})
+	rejected := newBlock(genesis, [dataLen]byte{2})
+	assert.Equal(json.Uint64(0), burnt())
+	assert.NoError(accepted.Accept())
+	assert.Equal(json.Uint64(10), burnt())
+
+	// and rejected blocks never are
+	assert.NoError(rejected.Reject())
+	assert.Equal(json.Uint64(10), burnt())
+
+	// the totals add up the fees of every accepted block
+	next := newBlock(accepted, [dataLen]byte{3})
+	assert.NoError(next.Accept())
+	assert.Equal(json.Uint64(20), burnt())
+	account := GetAccountReply{}
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: alice.PublicKey().Address()}, &account))
+	assert.Equal(json.Uint64(80), account.Balance)
+}
+
+func TestFeeSchedule(t *testing.T) {
+	assert := assert.New(t)
+	alice, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	recipient := ids.ShortID{9}
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "feesEnabled": true, "submissionFee": 10, "feePerByte": 1, "feeDemandWindow": "1h", "feeDemandCurve": [{"blocks": 1, "percent": 200}], "feeRecipient": %q, "feeBalances": {%q: 1000}}`,
+		recipient, alice.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// the genesis block doesn't count as demand, so the base price applies
+	schedule := GetFeeScheduleReply{}
+	assert.NoError(service.GetFeeSchedule(nil, nil, &schedule))
+	assert.True(schedule.Enabled)
+	assert.Equal(json.Uint64(0), schedule.RecentBlocks)
+	assert.Equal(json.Uint64(100), schedule.Percent)
+	assert.Equal(10+schedule.SubmissionSize, schedule.SubmissionFee)
+	fee := uint64(schedule.SubmissionFee)
+
+	data := [dataLen]byte{1}
+	msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+	assert.NoError(err)
+	sig, err := alice.Sign(msg)
+	assert.NoError(err)
+	args := &ProposeBlockArgs{}
+	args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	args.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, sig)
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, args, &ProposeBlockReply{}))
+
+	// the pending submission will count as demand once it's built
+	estimate := func(size uint64, priority string) EstimateFeeReply {
+		reply := EstimateFeeReply{}
+		assert.NoError(service.EstimateFee(nil, &EstimateFeeArgs{Size: json.Uint64(size), Priority: priority}, &reply))
+		return reply
+	}
+	assert.Equal(json.Uint64(fee), estimate(0, LowFeePriority).Fee)
+	assert.Equal(json.Uint64(110), estimate(100, LowFeePriority).Fee)
+	medium := estimate(0, "")
+	assert.Equal(json.Uint64(1), medium.Pending)
+	assert.Equal(json.Uint64(200), medium.Percent)
+	assert.Equal(json.Uint64(2*fee), medium.Fee)
+	assert.Equal(json.Uint64(200), estimate(0, HighFeePriority).Percent)
+	assert.ErrorIs(service.EstimateFee(nil, &EstimateFeeArgs{Priority: "urgent"}, &EstimateFeeReply{}), errUnknownFeePriority)
+
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.Equal(int(schedule.SubmissionSize), len(blk.Bytes()))
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.SetPreference(blk.ID()))
+
+	account := GetAccountReply{}
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: recipient}, &account))
+	assert.Equal(json.Uint64(fee), account.Balance)
+
+	// a recent block doubles the price
+	assert.NoError(service.GetFeeSchedule(nil, nil, &schedule))
+	assert.Equal(json.Uint64(1), schedule.RecentBlocks)
+	assert.Equal(json.Uint64(200), schedule.Percent)
+	assert.Equal(json.Uint64(2*fee), schedule.SubmissionFee)
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: alice.PublicKey().Address()}, &account))
+	assert.Equal(json.Uint64(1000-fee), account.Balance)
+	assert.Equal(json.Uint64(2*fee), account.Fee)
+
+	_, err = ParseConfig([]byte(`{"feeDemandCurve": [{"blocks": 2, "percent": 200}, {"blocks": 2, "percent": 300}]}`))
+	assert.ErrorIs(err, errFeeDemandCurve)
+	_, err = ParseConfig([]byte(`{"signedSubmissions": true, "feesEnabled": true, "feeDemandCurve": [{"blocks": 8, "percent": 200}], "pruningEnabled": true, "pruningRetainBlocks": 8}`))
+	assert.ErrorIs(err, errRetentionBelowCurve)
+}
+
+func TestFundingIssuers(t *testing.T) {
+	assert := assert.New(t)
+	issuer, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	bobKey, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	bob := bobKey.PublicKey().Address()
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "feesEnabled": true, "submissionFee": 10, "fundingIssuers": [%q]}`,
+		issuer.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// the issuer holds no funds, but funds bob free of charge
+	funding := &Transfer{To: bob, Amount: 50}
+	fundingMsg, err := TransferMessage(vm.ctx.ChainID, funding)
+	assert.NoError(err)
+	fundingSig, err := issuer.Sign(fundingMsg)
+	assert.NoError(err)
+	encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, fundingSig)
+	assert.NoError(err)
+	assert.NoError(service.ProposeTransfer(nil, &ProposeTransferArgs{To: bob, Amount: 50, Signature: encodedSig}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+
+	account := GetAccountReply{}
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: bob}, &account))
+	assert.Equal(json.Uint64(50), account.Balance)
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: issuer.PublicKey().Address()}, &account))
+	assert.Equal(json.Uint64(0), account.Balance)
+	assert.Equal(json.Uint64(1), account.Nonce)
+
+	// the funding can't be replayed
+	replayed, err := vm.newBlock(blk.ID(), blk.Height()+1, &submission{data: blk.(*Block).Data(), sig: fundingSig, transfer: funding}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(replayed.Verify(), errTransferNonce)
+
+	// without a fee recipient, the fees bob pays are burnt
+	data := [dataLen]byte{1}
+	msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+	assert.NoError(err)
+	sig, err := bobKey.Sign(msg)
+	assert.NoError(err)
+	paid, err := vm.newBlock(blk.ID(), blk.Height()+1, &submission{data: data, sig: sig}, time.Now())
+	assert.NoError(err)
+	assert.NoError(paid.Verify())
+	assert.NoError(paid.Accept())
+	totals := GetFeeTotalsReply{}
+	assert.NoError(service.GetFeeTotals(nil, nil, &totals))
+	assert.Equal(FeesBurnt, totals.Sink)
+	assert.Nil(totals.Treasury)
+	assert.Equal(json.Uint64(10), totals.Burnt)
+	assert.Equal(json.Uint64(0), totals.Collected)
+
+	_, err = ParseConfig([]byte(fmt.Sprintf(`{"fundingIssuers": [%q]}`, bob)))
+	assert.ErrorIs(err, errFundingWithoutFees)
+}
+
+func TestCreditGrants(t *testing.T) {
+	assert := assert.New(t)
+	issuer, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	client, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "feesEnabled": true, "submissionFee": 10, "fundingIssuers": [%q]}`,
+		issuer.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	grant := &CreditGrant{To: client.PublicKey().Address(), Credits: 1}
+	proposeGrant := func(key crypto.PrivateKey) error {
+		msg, err := CreditGrantMessage(vm.ctx.ChainID, grant)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		args := &ProposeCreditGrantArgs{Nonce: json.Uint64(grant.Nonce), To: grant.To, Credits: json.Uint64(grant.Credits), Signature: encodedSig}
+		return service.ProposeCreditGrant(nil, args, &ProposeBlockReply{})
+	}
+	assert.ErrorIs(proposeGrant(client), errNotFundingIssuer)
+	assert.NoError(proposeGrant(issuer))
+	grantBlk, err := vm.BuildBlock()
+	assert.NoError(err)
+	// grants survive a round trip through their bytes
+	parsed, err := vm.ParseBlock(grantBlk.Bytes())
+	assert.NoError(err)
+	assert.Equal(grant, parsed.(*Block).CreditGrant())
+	assert.NoError(grantBlk.Accept())
+	assert.NoError(vm.SetPreference(grantBlk.ID()))
+
+	account := GetAccountReply{}
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: client.PublicKey().Address()}, &account))
+	assert.Equal(json.Uint64(1), account.Credits)
+	assert.Equal(json.Uint64(0), account.Balance)
+
+	// the client's only credit pays for one block, but not for its child
+	newBlock := func(parent *Block, data [dataLen]byte) *Block {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := client.Sign(msg)
+		assert.NoError(err)
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: data, sig: sig}, time.Now())
+		assert.NoError(err)
+		return blk
+	}
+	paid := newBlock(grantBlk.(*Block), [dataLen]byte{1})
+	assert.NoError(paid.Verify())
+	assert.ErrorIs(newBlock(paid, [dataLen]byte{2}).Verify(), errInsufficientBalance)
+	assert.NoError(paid.Accept())
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: client.PublicKey().Address()}, &account))
+	assert.Equal(json.Uint64(0), account.Credits)
+
+	// and the grant can't be replayed
+	replayed, err := vm.newBlock(paid.ID(), paid.Height()+1, &submission{data: grantBlk.(*Block).Data(), sig: grantBlk.(*Block).Sgntr, grant: grant}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(replayed.Verify(), errTransferNonce)
+}
+
+func TestFreeDailyAnchors(t *testing.T) {
+	assert := assert.New(t)
+	alice, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "feesEnabled": true, "submissionFee": 10, "feeBalances": {%q: 5}, "freeDailyAnchors": 1, "proofOfWorkBits": 8}`,
+		alice.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesis, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesis.ID()))
+	service := Service{vm}
+
+	sign := func(data [dataLen]byte) []byte {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := alice.Sign(msg)
+		assert.NoError(err)
+		return sig
+	}
+	propose := func(data [dataLen]byte, pow *ProofOfWork) error {
+		args := &ProposeBlockArgs{ProofOfWork: pow}
+		args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		args.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, sign(data))
+		assert.NoError(err)
+		return service.ProposeBlock(nil, args, &ProposeBlockReply{})
+	}
+
+	// the first anchor of the day is free, even on top of a processing one
+	first, err := vm.newBlock(genesis.ID(), 1, &submission{data: [dataLen]byte{1}, sig: sign([dataLen]byte{1})}, time.Now())
+	assert.NoError(err)
+	assert.NoError(first.Verify())
+	second, err := vm.newBlock(first.ID(), 2, &submission{data: [dataLen]byte{2}, sig: sign([dataLen]byte{2})}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(second.Verify(), errInsufficientBalance)
+	assert.NoError(first.Accept())
+	assert.NoError(vm.SetPreference(first.ID()))
+
+	account := GetAccountReply{}
+	assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: alice.PublicKey().Address()}, &account))
+	assert.Equal(json.Uint64(5), account.Balance)
+	free := GetFreeAnchorsReply{}
+	assert.NoError(service.GetFreeAnchors(nil, &GetFreeAnchorsArgs{Address: alice.PublicKey().Address()}, &free))
+	assert.Equal(json.Uint64(1), free.Limit)
+	assert.Equal(json.Uint64(0), free.Left)
+
+	// afterwards, proofs of work and fees apply
+	data := [dataLen]byte{2}
+	assert.ErrorIs(propose(data, nil), errMissingProofOfWork)
+	assert.ErrorIs(propose(data, SolveProofOfWork(first.ID(), data, 8)), errInsufficientBalance)
+
+	// until the next day
+	left, err := vm.freeAnchorsLeft(alice.PublicKey().Address(), time.Now().Add(24*time.Hour))
+	assert.NoError(err)
+	assert.Equal(uint64(1), left)
+
+	_, err = ParseConfig([]byte(`{"freeDailyAnchors": 1}`))
+	assert.ErrorIs(err, errFreeAnchorsWithoutSigning)
+}
+
+func TestEstimateFeeCharged(t *testing.T) {
+	for _, freeDailyAnchors := range []uint64{0, 1} {
+		assert := assert.New(t)
+		alice, err := secpFactory.NewPrivateKey()
+		assert.NoError(err)
+		address := alice.PublicKey().Address()
+		vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+			`{"signedSubmissions": true, "feesEnabled": true, "submissionFee": 10, "feePerByte": 1, "feeBalances": {%q: 1000}, "freeDailyAnchors": %d}`,
+			address, freeDailyAnchors,
+		)))
+		assert.NoError(err)
+		genesisID, err := vm.LastAccepted()
+		assert.NoError(err)
+		assert.NoError(vm.SetPreference(genesisID))
+		service := Service{vm}
+
+		balance := func() uint64 {
+			account := GetAccountReply{}
+			assert.NoError(service.GetAccount(nil, &GetAccountArgs{Address: address}, &account))
+			return uint64(account.Balance)
+		}
+		// anchorCharged anchors [data] for alice, returning the fee estimated
+		// beforehand and the fee debited
+		anchorCharged := func(data [dataLen]byte) (uint64, uint64) {
+			estimate := EstimateFeeReply{}
+			assert.NoError(service.EstimateFee(nil, &EstimateFeeArgs{Submitter: &address}, &estimate))
+
+			before := balance()
+			msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+			assert.NoError(err)
+			sig, err := alice.Sign(msg)
+			assert.NoError(err)
+			args := &ProposeBlockArgs{}
+			args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+			assert.NoError(err)
+			args.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, sig)
+			assert.NoError(err)
+			assert.NoError(service.ProposeBlock(nil, args, &ProposeBlockReply{}))
+			blk, err := vm.BuildBlock()
+			assert.NoError(err)
+			assert.NoError(blk.Verify())
+			assert.NoError(vm.SetPreference(blk.ID()))
+			assert.NoError(blk.Accept())
+			return uint64(estimate.Fee), before - balance()
+		}
+
+		// with an allowance, the first anchor of the day is estimated and
+		// charged free of charge
+		estimated, charged := anchorCharged([dataLen]byte{1})
+		assert.Equal(estimated, charged)
+		if freeDailyAnchors > 0 {
+			assert.Zero(charged)
+		} else {
+			assert.NotZero(charged)
+		}
+		// and the ones after it are charged the fee
+		estimated, charged = anchorCharged([dataLen]byte{2})
+		assert.Equal(estimated, charged)
+		assert.NotZero(charged)
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"proofOfWorkBits": 8, "proofOfWorkMaxAge": 2}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	propose := func(data [dataLen]byte, pow *ProofOfWork) error {
+		args := &ProposeBlockArgs{ProofOfWork: pow}
+		args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		return service.ProposeBlock(nil, args, &ProposeBlockReply{})
+	}
+	accept := func(data [dataLen]byte, recentID ids.ID) *Block {
+		assert.NoError(propose(data, SolveProofOfWork(recentID, data, 8)))
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		return blk.(*Block)
+	}
+
+	assert.ErrorIs(propose([dataLen]byte{1}, nil), errMissingProofOfWork)
+	weak := &ProofOfWork{RecentID: genesisID}
+	for leadingZeroBits(ProofOfWorkHash(weak.RecentID, [dataLen]byte{1}, weak.Nonce)) >= 8 {
+		weak.Nonce++
+	}
+	assert.ErrorIs(propose([dataLen]byte{1}, weak), errInsufficientWork)
+
+	blk := accept([dataLen]byte{1}, genesisID)
+	assert.NotNil(blk.ProofOfWork())
+	// proofs of work survive a round trip through their bytes
+	parsed, err := vm.ParseBlock(blk.Bytes())
+	assert.NoError(err)
+	assert.Equal(blk.ProofOfWork(), parsed.(*Block).ProofOfWork())
+
+	// the genesis block is still recent for a child of [blk], but not after
+	// another block
+	accept([dataLen]byte{2}, genesisID)
+	data := [dataLen]byte{3}
+	assert.ErrorIs(propose(data, SolveProofOfWork(genesisID, data, 8)), errStaleProofOfWork)
+	reply := GetProofOfWorkReply{}
+	assert.NoError(service.GetProofOfWork(nil, nil, &reply))
+	assert.Equal(json.Uint64(8), reply.Bits)
+	accept(data, reply.RecentID)
+
+	// chains without proofs of work refuse them
+	vm, _, _, err = newTestVM()
+	assert.NoError(err)
+	genesis, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+	powBlk, err := vm.newBlock(genesis.ID(), 1, &submission{data: data, pow: SolveProofOfWork(genesis.ID(), data, 8)}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(powBlk.Verify(), errProofOfWorkDisabled)
+
+	_, err = ParseConfig([]byte(`{"proofOfWorkBits": 65}`))
+	assert.ErrorIs(err, errProofOfWorkTooHard)
+	_, err = ParseConfig([]byte(`{"proofOfWorkBits": 8, "pruningEnabled": true, "pruningRetainBlocks": 16}`))
+	assert.ErrorIs(err, errRetentionBelowProofOfWork)
+}
+
+func TestExpressLane(t *testing.T) {
+	assert := assert.New(t)
+	public, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	operator, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "expressLaneShare": 0.5, "expressLaneSubmitters": [%q]}`,
+		operator.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	service := Service{vm}
+
+	propose := func(key crypto.PrivateKey, data [dataLen]byte) {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		args := &ProposeBlockArgs{}
+		args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		args.Signature, err = formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		assert.NoError(service.ProposeBlock(nil, args, &ProposeBlockReply{}))
+	}
+	propose(public, [dataLen]byte{1})
+	propose(public, [dataLen]byte{2})
+	propose(public, [dataLen]byte{3})
+	propose(operator, [dataLen]byte{4})
+	propose(operator, [dataLen]byte{5})
+
+	// every other block is reserved for the operator
+	for _, expected := range []byte{1, 4, 2, 5, 3} {
+		sub, ok := vm.mempool.Pop()
+		assert.True(ok)
+		assert.Equal([dataLen]byte{expected}, sub.data)
+	}
+
+	_, err = ParseConfig([]byte(`{"signedSubmissions": true, "expressLaneShare": 1.5}`))
+	assert.ErrorIs(err, errBadExpressLaneShare)
+	_, err = ParseConfig([]byte(`{"expressLaneShare": 0.5}`))
+	assert.ErrorIs(err, errExpressLaneWithoutSigning)
+}
+
+func TestNamespaces(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	propose := func(data [dataLen]byte, namespace string) error {
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		return service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: namespace}, &ProposeBlockReply{})
+	}
+	assert.ErrorIs(propose([dataLen]byte{1}, "bookings/2022"), errBadNamespace)
+	assert.ErrorIs(propose([dataLen]byte{1}, strings.Repeat("a", maxNamespaceLen+1)), errNamespaceTooLong)
+
+	blkIDs := map[string][]ids.ID{}
+	for i, namespace := range []string{"bookings", "", "invoices", "bookings"} {
+		assert.NoError(propose([dataLen]byte{byte(i + 1)}, namespace))
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		// namespaces survive a round trip through their bytes
+		parsed, err := vm.ParseBlock(blk.Bytes())
+		assert.NoError(err)
+		assert.Equal(namespace, parsed.(*Block).Namespace())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		blkIDs[namespace] = append(blkIDs[namespace], blk.ID())
+	}
+
+	bookings, err := vm.state.GetNamespaceBlockIDs(context.Background(), "bookings", 0, 10)
+	assert.NoError(err)
+	assert.Equal(blkIDs["bookings"], bookings)
+	bookings, err = vm.state.GetNamespaceBlockIDs(context.Background(), "bookings", 2, 10)
+	assert.NoError(err)
+	assert.Equal(blkIDs["bookings"][1:], bookings)
+	invoices, err := vm.state.GetNamespaceBlockIDs(context.Background(), "invoices", 0, 10)
+	assert.NoError(err)
+	assert.Equal(blkIDs["invoices"], invoices)
+
+	reply := GetBlockReply{}
+	assert.NoError(service.GetBlock(nil, &GetBlockArgs{ID: &blkIDs["invoices"][0]}, &reply))
+	assert.Equal("invoices", reply.Namespace)
+
+	// namespaces are queried page by page
+	assert.ErrorIs(service.GetByNamespace(nil, &GetByNamespaceArgs{}, &GetByNamespaceReply{}), errMissingNamespace)
+	page := GetByNamespaceReply{}
+	assert.NoError(service.GetByNamespace(nil, &GetByNamespaceArgs{Namespace: "bookings", Limit: 1}, &page))
+	assert.Len(page.Blocks, 1)
+	assert.Equal(blkIDs["bookings"][0], page.Blocks[0].ID)
+	assert.Equal(json.Uint64(2), *page.NextHeight)
+	args := &GetByNamespaceArgs{Namespace: "bookings", FromHeight: *page.NextHeight, Limit: 1}
+	page = GetByNamespaceReply{}
+	assert.NoError(service.GetByNamespace(nil, args, &page))
+	assert.Len(page.Blocks, 1)
+	assert.Equal(blkIDs["bookings"][1], page.Blocks[0].ID)
+	assert.Equal("bookings", page.Blocks[0].Namespace)
+	assert.Nil(page.NextHeight)
+
+	// operations aren't filed under a namespace. Their codec versions don't
+	// serialize it, so such blocks can't be built,
+	_, err = vm.newBlock(blkIDs["bookings"][1], 5, &submission{data: [dataLen]byte{5}, namespace: "bookings", update: &AllowlistUpdate{}}, time.Now())
+	assert.ErrorIs(err, errDroppedBlockField)
+	// and aren't valid either
+	blk := &Block{PrntID: blkIDs["bookings"][1], Hght: 5, Tmstmp: time.Now().Unix(), Dt: [dataLen]byte{5}, Nmspc: "bookings", Updt: &AllowlistUpdate{}}
+	blkBytes, err := Codec.Marshal(blk.codecVersion(), blk)
+	assert.NoError(err)
+	blk.Initialize(blkBytes, choices.Processing, vm)
+	assert.ErrorIs(blk.Verify(), errNamespacedOperation)
+	assert.NoError(vm.integrity.Verify())
+}
+
+func TestNamespacePagination(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// 4 bookings at heights 1, 3, 5 and 7, between blocks of other namespaces
+	bookings := []ids.ID(nil)
+	for i := 0; i < 7; i++ {
+		namespace := "bookings"
+		if i%2 == 1 {
+			namespace = "invoices"
+		}
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, []byte{31: byte(i + 1)})
+		assert.NoError(err)
+		assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: namespace}, &ProposeBlockReply{}))
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		if namespace == "bookings" {
+			bookings = append(bookings, blk.ID())
+		}
+	}
+
+	getPage := func(fromHeight json.Uint64, limit json.Uint32) GetByNamespaceReply {
+		page := GetByNamespaceReply{}
+		assert.NoError(service.GetByNamespace(nil, &GetByNamespaceArgs{Namespace: "bookings", FromHeight: fromHeight, Limit: limit}, &page))
+		return page
+	}
+	pageIDs := func(page GetByNamespaceReply) []ids.ID {
+		blkIDs := []ids.ID{}
+		for _, blk := range page.Blocks {
+			blkIDs = append(blkIDs, blk.ID)
+		}
+		return blkIDs
+	}
+
+	// following the cursor returns every block once, the last page ending
+	// with the last block
+	for _, limit := range []json.Uint32{1, 2, 3} {
+		paged := []ids.ID{}
+		cursor := json.Uint64(0)
+		for pages := 1; ; pages++ {
+			page := getPage(cursor, limit)
+			assert.LessOrEqual(len(page.Blocks), int(limit))
+			paged = append(paged, pageIDs(page)...)
+			if page.NextHeight == nil {
+				assert.Equal((len(bookings)+int(limit)-1)/int(limit), pages)
+				break
+			}
+			// the cursor is the height after the last block of the page
+			assert.Equal(page.Blocks[len(page.Blocks)-1].Height+1, *page.NextHeight)
+			cursor = *page.NextHeight
+		}
+		assert.Equal(bookings, paged)
+	}
+
+	// a page ending exactly with the last block has no next page
+	page := getPage(0, json.Uint32(len(bookings)))
+	assert.Equal(bookings, pageIDs(page))
+	assert.Nil(page.NextHeight)
+	// while one ending just before it continues with the last block
+	page = getPage(0, json.Uint32(len(bookings)-1))
+	assert.Equal(bookings[:len(bookings)-1], pageIDs(page))
+	assert.Equal(json.Uint64(6), *page.NextHeight)
+
+	// a cursor between blocks of the namespace starts at the next one
+	page = getPage(2, 1)
+	assert.Equal(bookings[1:2], pageIDs(page))
+	assert.Equal(json.Uint64(4), *page.NextHeight)
+	// no limit returns every block
+	page = getPage(0, 0)
+	assert.Equal(bookings, pageIDs(page))
+	assert.Nil(page.NextHeight)
+
+	// past the last block, the page is empty
+	page = getPage(8, 2)
+	assert.NotNil(page.Blocks)
+	assert.Empty(page.Blocks)
+	assert.Nil(page.NextHeight)
+	page = GetByNamespaceReply{}
+	assert.NoError(service.GetByNamespace(nil, &GetByNamespaceArgs{Namespace: "receipts"}, &page))
+	assert.Empty(page.Blocks)
+	assert.Nil(page.NextHeight)
+}
+
+func TestTags(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	propose := func(data [dataLen]byte, namespace string, tags map[string]string) error {
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		return service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: namespace, Tags: tags}, &ProposeBlockReply{})
+	}
+	assert.ErrorIs(propose([dataLen]byte{1}, "", map[string]string{"doc type": "invoice"}), errBadTagKey)
+	assert.ErrorIs(propose([dataLen]byte{1}, "", map[string]string{"docType": ""}), errBadTagValue)
+	tooMany := map[string]string{}
+	for i := 0; i <= maxTags; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+	assert.ErrorIs(propose([dataLen]byte{1}, "", tooMany), errTooManyTags)
+
+	var lastBlk *Block
+	for i, tagged := range []struct {
+		namespace string
+		tags      map[string]string
+	}{
+		{"documents", map[string]string{"org": "acme", "docType": "invoice"}},
+		{"documents", map[string]string{"docType": "receipt"}},
+		{"", map[string]string{"docType": "invoice"}},
+		{"documents", map[string]string{"docType": "invoice"}},
+	} {
+		assert.NoError(propose([dataLen]byte{byte(i + 1)}, tagged.namespace, tagged.tags))
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		// tags survive a round trip through their bytes
+		parsed, err := vm.ParseBlock(blk.Bytes())
+		assert.NoError(err)
+		assert.Equal(tagged.tags, tagMap(parsed.(*Block).Tags()))
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		lastBlk = blk.(*Block)
+	}
+
+	// tags are queried within a namespace
+	page := GetByNamespaceReply{}
+	assert.NoError(service.GetByTag(nil, &GetByTagArgs{Namespace: "documents", Key: "docType", Value: "invoice"}, &page))
+	assert.Len(page.Blocks, 2)
+	assert.Equal(json.Uint64(1), page.Blocks[0].Height)
+	assert.Equal(map[string]string{"org": "acme", "docType": "invoice"}, page.Blocks[0].Tags)
+	assert.Equal(json.Uint64(4), page.Blocks[1].Height)
+	page = GetByNamespaceReply{}
+	assert.NoError(service.GetByTag(nil, &GetByTagArgs{Key: "docType", Value: "invoice"}, &page))
+	assert.Len(page.Blocks, 1)
+	assert.Equal(json.Uint64(3), page.Blocks[0].Height)
+	page = GetByNamespaceReply{}
+	assert.NoError(service.GetByTag(nil, &GetByTagArgs{Namespace: "documents", Key: "org", Value: "acme", FromHeight: 2}, &page))
+	assert.Empty(page.Blocks)
+
+	// tags must be in canonical order
+	unsorted := []Tag{{Key: "org", Value: "acme"}, {Key: "docType", Value: "invoice"}}
+	blk, err := vm.newBlock(lastBlk.ID(), lastBlk.Height()+1, &submission{data: [dataLen]byte{5}, tags: unsorted}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(blk.Verify(), errUnsortedTags)
+	assert.NoError(vm.integrity.Verify())
+}
+
+func TestPayloadSchemas(t *testing.T) {
+	assert := assert.New(t)
+	admin, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"signedSubmissions": true, "schemaAdmins": [%q]}`, admin.PublicKey().Address())))
+	assert.NoError(err)
+	genesis, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesis.ID()))
+	service := Service{vm}
+
+	update := &SchemaUpdate{Schema: PayloadSchema{
+		Name: "booking",
+		Fields: []SchemaField{
+			{Name: "supplier", Type: Uint32Field, Min: 1},
+			{Name: "validFrom", Type: Uint32Field},
+			{Name: "docHash", Type: BytesField, Size: 20},
+		},
+	}}
+	sign := func(key crypto.PrivateKey, msg []byte) []byte {
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		return sig
+	}
+	msg, err := SchemaUpdateMessage(vm.ctx.ChainID, update)
+	assert.NoError(err)
+	encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sign(admin, msg))
+	assert.NoError(err)
+	assert.NoError(service.ProposeSchemaUpdate(nil, &ProposeSchemaUpdateArgs{SchemaUpdate: *update, Signature: encodedSig}, &ProposeBlockReply{}))
+	updateBlk, err := vm.BuildBlock()
+	assert.NoError(err)
+	// schema updates survive a round trip through their bytes
+	parsed, err := vm.ParseBlock(updateBlk.Bytes())
+	assert.NoError(err)
+	assert.Equal(update, parsed.(*Block).SchemaUpdate())
+
+	// the schema is in effect for the descendants of the processing update
+	newBlock := func(parent *Block, data [dataLen]byte, tags []Tag) *Block {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: data, sig: sign(admin, msg), tags: tags}, time.Now())
+		assert.NoError(err)
+		return blk
+	}
+	conforming := [dataLen]byte{0, 0, 0, 7, 0, 0, 1, 0, 0xaa}
+	assert.NoError(newBlock(updateBlk.(*Block), conforming, []Tag{{Key: schemaTagKey, Value: "booking"}}).Verify())
+	assert.ErrorIs(newBlock(updateBlk.(*Block), [dataLen]byte{31: 1}, []Tag{{Key: schemaTagKey, Value: "booking"}}).Verify(), errNonConformingData)
+	assert.ErrorIs(newBlock(updateBlk.(*Block), conforming, []Tag{{Key: schemaTagKey, Value: "invoice"}}).Verify(), errUnknownSchema)
+	assert.NoError(updateBlk.Accept())
+	assert.NoError(vm.SetPreference(updateBlk.ID()))
+
+	schemas := GetSchemasReply{}
+	assert.NoError(service.GetSchemas(nil, nil, &schemas))
+	assert.True(schemas.Enabled)
+	assert.Equal(json.Uint64(1), schemas.Nonce)
+	assert.Equal([]*PayloadSchema{&update.Schema}, schemas.Schemas)
+
+	// proposals are checked against the accepted schemas
+	propose := func(data [dataLen]byte) error {
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sign(admin, msg))
+		assert.NoError(err)
+		args := &ProposeBlockArgs{Data: encodedData, Signature: encodedSig, Tags: map[string]string{schemaTagKey: "booking"}}
+		return service.ProposeBlock(nil, args, &ProposeBlockReply{})
+	}
+	assert.ErrorIs(propose([dataLen]byte{}), errNonConformingData)
+	assert.NoError(propose(conforming))
+
+	// updates must be signed by an admin and can't be replayed
+	other, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	update.Nonce = 1
+	msg, err = SchemaUpdateMessage(vm.ctx.ChainID, update)
+	assert.NoError(err)
+	encodedSig, err = formatting.EncodeWithChecksum(formatting.CB58, sign(other, msg))
+	assert.NoError(err)
+	assert.ErrorIs(service.ProposeSchemaUpdate(nil, &ProposeSchemaUpdateArgs{SchemaUpdate: *update, Signature: encodedSig}, &ProposeBlockReply{}), errNotSchemaAdmin)
+	replayed := updateBlk.(*Block)
+	blk, err := vm.newBlock(replayed.ID(), replayed.Height()+1, &submission{data: replayed.Data(), sig: replayed.Sgntr, schema: replayed.SchemaUpdate()}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(blk.Verify(), errSchemaUpdateNonce)
+
+	bad := PayloadSchema{Name: "large", Fields: []SchemaField{{Name: "blob", Type: BytesField, Size: dataLen + 1}}}
+	assert.ErrorIs(bad.Verify(), errSchemaTooLarge)
+	bad = PayloadSchema{Name: "range", Fields: []SchemaField{{Name: "n", Type: Uint8Field, Min: 2, Max: 1}}}
+	assert.ErrorIs(bad.Verify(), errBadFieldRange)
+}
+
+func TestRecordSchemas(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"recordSchemas": {"bookings": {
+		"type": "object",
+		"required": ["ref", "nights"],
+		"properties": {
+			"ref": {"type": "string", "pattern": "^[A-Z]{6}$"},
+			"nights": {"type": "integer", "minimum": 1},
+			"status": {"enum": ["confirmed", "cancelled"]}
+		},
+		"additionalProperties": false
+	}}}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	propose := func(namespace string, record string, data string) error {
+		args := &ProposeBlockArgs{Data: data, Namespace: namespace, Record: stdjson.RawMessage(record)}
+		return service.ProposeBlock(nil, args, &ProposeBlockReply{})
+	}
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+	assert.ErrorIs(propose("bookings", "", encodedData), errMissingRecord)
+	assert.ErrorIs(propose("bookings", `{"ref": "ABCDEF", "nights": 0}`, ""), errInvalidRecord)
+	assert.ErrorIs(propose("bookings", `{"ref": "abc", "nights": 2}`, ""), errInvalidRecord)
+	assert.ErrorIs(propose("bookings", `{"ref": "ABCDEF", "nights": 2.5}`, ""), errInvalidRecord)
+	assert.ErrorIs(propose("bookings", `{"ref": "ABCDEF", "nights": 2, "status": "pending"}`, ""), errInvalidRecord)
+	assert.ErrorIs(propose("bookings", `{"ref": "ABCDEF", "nights": 2, "price": 100}`, ""), errInvalidRecord)
+	assert.ErrorIs(propose("bookings", `{"ref": "ABCDEF", "nights": 2}`, encodedData), errRecordData)
+
+	record := `{"ref": "ABCDEF", "nights": 2, "status": "confirmed"}`
+	assert.NoError(propose("bookings", record, ""))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	recordData, err := RecordData([]byte(record))
+	assert.NoError(err)
+	assert.Equal(recordData, blk.(*Block).Data())
+	// whitespace doesn't change the hash
+	spaced, err := RecordData([]byte(" {\"ref\":\"ABCDEF\",\n\"nights\":2,  \"status\":\"confirmed\"}"))
+	assert.NoError(err)
+	assert.Equal(recordData, spaced)
+
+	// other namespaces accept any record
+	assert.NoError(propose("invoices", `[1, 2, 3]`, ""))
+
+	_, err = ParseConfig([]byte(`{"recordSchemas": {"bookings": {"oneOf": []}}}`))
+	assert.ErrorIs(err, errUnsupportedKeyword)
+	_, err = ParseConfig([]byte(`{"recordSchemas": {"bookings": {"type": "date"}}}`))
+	assert.ErrorIs(err, errBadSchemaType)
+}
+
+func TestTravelDocuments(t *testing.T) {
+	assert := assert.New(t)
+	supplier, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"signedSubmissions": true}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	doc := TravelDocument{
+		BookingRefHash: hashing.ComputeHash256Array([]byte("ABC123")),
+		SupplierID:     "LX",
+		DocumentHash:   hashing.ComputeHash256Array([]byte("ticket")),
+		ValidFrom:      1000,
+		ValidUntil:     2000,
+	}
+	expired := doc
+	expired.ValidUntil = expired.ValidFrom
+	assert.ErrorIs(service.ProposeTravelDocument(nil, &ProposeTravelDocumentArgs{TravelDocument: expired}, &ProposeBlockReply{}), errBadValidityWindow)
+	assert.ErrorIs(service.VerifyTravelDocument(nil, &VerifyTravelDocumentArgs{TravelDocument: doc}, &VerifyTravelDocumentReply{}), errDocumentNotAnchored)
+
+	msg, err := TravelDocumentMessage(vm.ctx.ChainID, "bookings", &doc)
+	assert.NoError(err)
+	sig, err := supplier.Sign(msg)
+	assert.NoError(err)
+	encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+	assert.NoError(err)
+	reply := ProposeBlockReply{}
+	assert.NoError(service.ProposeTravelDocument(nil, &ProposeTravelDocumentArgs{TravelDocument: doc, Signature: encodedSig, Namespace: "bookings"}, &reply))
+	assert.Equal(supplier.PublicKey().Address(), *reply.Submitter)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+
+	verified := VerifyTravelDocumentReply{}
+	assert.NoError(service.VerifyTravelDocument(nil, &VerifyTravelDocumentArgs{TravelDocument: doc, At: 1500}, &verified))
+	assert.True(verified.Valid)
+	assert.Equal(blk.ID(), verified.Block.ID)
+	assert.Equal(supplier.PublicKey().Address(), *verified.Block.Submitter)
+	assert.Equal("bookings", verified.Block.Namespace)
+	verified = VerifyTravelDocumentReply{}
+	assert.NoError(service.VerifyTravelDocument(nil, &VerifyTravelDocumentArgs{TravelDocument: doc, At: 2000}, &verified))
+	assert.False(verified.Valid)
+
+	// any altered field fails verification
+	altered := doc
+	altered.ValidUntil = 3000
+	assert.ErrorIs(service.VerifyTravelDocument(nil, &VerifyTravelDocumentArgs{TravelDocument: altered}, &VerifyTravelDocumentReply{}), errDocumentNotAnchored)
+
+	// the documents of a supplier are listed by tag
+	page := GetByNamespaceReply{}
+	assert.NoError(service.GetByTag(nil, &GetByTagArgs{Namespace: "bookings", Key: supplierTagKey, Value: "LX"}, &page))
+	assert.Len(page.Blocks, 1)
+	assert.Equal(travelDocumentProfile, page.Blocks[0].Tags[profileTagKey])
+}
+
+func TestRedactions(t *testing.T) {
+	assert := assert.New(t)
+	submitter, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	admin, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	stranger, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"signedSubmissions": true, "redactionsEnabled": true, "redactionAdmins": [%q]}`, admin.PublicKey().Address())))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	sign := func(key crypto.PrivateKey, msg []byte) string {
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		return encodedSig
+	}
+	accept := func() *Block {
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		return blk.(*Block)
+	}
+	anchored := []*Block(nil)
+	for i := byte(1); i <= 2; i++ {
+		data := [dataLen]byte{i}
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+		assert.NoError(err)
+		assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Signature: sign(submitter, msg)}, &ProposeBlockReply{}))
+		anchored = append(anchored, accept())
+	}
+	redact := func(key crypto.PrivateKey, redaction Redaction) error {
+		msg, err := RedactionMessage(vm.ctx.ChainID, &redaction)
+		assert.NoError(err)
+		return service.ProposeRedaction(nil, &ProposeRedactionArgs{Redaction: redaction, Signature: sign(key, msg)}, &ProposeBlockReply{})
+	}
+	target := anchored[0]
+	targetID := target.ID()
+	assert.ErrorIs(redact(submitter, Redaction{BlkID: genesisID}), errRedactionTarget)
+	assert.ErrorIs(redact(submitter, Redaction{BlkID: target.ID(), Height: 2}), errRedactionTarget)
+	assert.ErrorIs(redact(stranger, Redaction{BlkID: target.ID(), Height: 1}), errNotRedactor)
+
+	// the submitter redacts its own data
+	assert.NoError(redact(submitter, Redaction{BlkID: target.ID(), Height: 1}))
+	redactionBlk := accept()
+	assert.ErrorIs(redact(submitter, Redaction{BlkID: target.ID(), Height: 1}), errAlreadyRedacted)
+	_, err = vm.state.GetBlockBody(target.ID())
+	assert.ErrorIs(err, database.ErrNotFound)
+
+	// only the header of the redacted block is served
+	reply := GetBlockReply{}
+	assert.NoError(service.GetBlock(nil, &GetBlockArgs{ID: &targetID}, &reply))
+	assert.Equal(redactionBlk.ID(), *reply.RedactedBy)
+	assert.Equal(json.Uint64(1), reply.Height)
+	assert.Equal(genesisID, reply.ParentID)
+	assert.Empty(reply.Data)
+	assert.Nil(reply.Submitter)
+	byData := GetBlockReply{}
+	redactedData := [dataLen]byte{1}
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, redactedData[:])
+	assert.NoError(err)
+	assert.NoError(service.GetBlockByData(nil, &GetBlockByDataArgs{Data: encodedData}, &byData))
+	assert.Equal(reply, byData)
+	header := GetBlockHeaderReply{}
+	assert.NoError(service.GetBlockHeader(nil, &GetBlockArgs{ID: &targetID}, &header))
+	assert.Equal(DataHash(redactedData), header.DataHash)
+	page := GetBlocksReply{}
+	assert.NoError(service.GetBlocksBySubmitter(nil, &GetBlocksBySubmitterArgs{Submitter: submitter.PublicKey().Address()}, &page))
+	assert.Len(page.Blocks, 3)
+	assert.NotNil(page.Blocks[0].RedactedBy)
+	assert.Nil(page.Blocks[1].RedactedBy)
+	assert.Equal(targetID, page.Blocks[2].Redaction.BlkID)
+
+	// admins redact any block
+	assert.NoError(redact(admin, Redaction{BlkID: anchored[1].ID(), Height: 2}))
+	accept()
+	assert.NoError(vm.integrity.Verify())
+}
+
+func TestNamespaceAccess(t *testing.T) {
+	assert := assert.New(t)
+	alice, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	bob, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "namespaceSubmitters": {"bookings": [%q]}, "apiKeyNamespaces": {"tenant": {"namespaces": ["bookings"], "restrictReads": true}}}`,
+		alice.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// requests of the tenant's API key
+	tenant := httptest.NewRequest(http.MethodPost, "/", nil)
+	tenant = tenant.WithContext(context.WithValue(tenant.Context(), callerKey{}, &apiCaller{name: "tenant", role: ProposerRole}))
+	propose := func(r *http.Request, key crypto.PrivateKey, data [dataLen]byte, namespace string) error {
+		msg, err := ScopedSubmissionMessage(vm.ctx.ChainID, data, namespace, nil)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		args := &ProposeBlockArgs{Data: encodedData, Signature: encodedSig, Namespace: namespace}
+		return service.ProposeBlock(r, args, &ProposeBlockReply{})
+	}
+	assert.ErrorIs(propose(tenant, alice, [dataLen]byte{1}, "invoices"), errForbidden)
+	assert.ErrorIs(propose(nil, bob, [dataLen]byte{1}, "bookings"), errNamespaceForbidden)
+	assert.ErrorIs(propose(nil, alice, [dataLen]byte{1}, ""), errNamespaceForbidden)
+	assert.NoError(propose(tenant, alice, [dataLen]byte{1}, "bookings"))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.SetPreference(blk.ID()))
+	assert.NoError(propose(nil, bob, [dataLen]byte{2}, ""))
+	other, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(other.Accept())
+
+	// blocks are verified against the bindings as well
+	sign := func(key crypto.PrivateKey, data [dataLen]byte, namespace string, tags []Tag) []byte {
+		msg, err := ScopedSubmissionMessage(vm.ctx.ChainID, data, namespace, tags)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		return sig
+	}
+	forged, err := vm.newBlock(other.ID(), other.Height()+1, &submission{data: [dataLen]byte{3}, sig: sign(bob, [dataLen]byte{3}, "bookings", nil), namespace: "bookings"}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(forged.Verify(), errNamespaceForbidden)
+	// the namespace and tags are signed, so a submission moved into another
+	// namespace or retagged is no longer the submitter's
+	sig := sign(alice, [dataLen]byte{3}, "bookings", []Tag{{Key: "docType", Value: "invoice"}})
+	signed, err := vm.newBlock(other.ID(), other.Height()+1, &submission{data: [dataLen]byte{3}, sig: sig, namespace: "bookings", tags: []Tag{{Key: "docType", Value: "invoice"}}}, time.Now())
+	assert.NoError(err)
+	assert.NoError(signed.Verify())
+	submitter, err := signed.Submitter()
+	assert.NoError(err)
+	assert.Equal(alice.PublicKey().Address(), submitter)
+	forged, err = vm.newBlock(other.ID(), other.Height()+1, &submission{data: [dataLen]byte{3}, sig: sig, namespace: "bookings", tags: []Tag{{Key: "docType", Value: "receipt"}}}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(forged.Verify(), errNamespaceForbidden)
+	forged, err = vm.newBlock(other.ID(), other.Height()+1, &submission{data: [dataLen]byte{3}, sig: sign(alice, [dataLen]byte{3}, "", nil), namespace: "bookings"}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(forged.Verify(), errNamespaceForbidden)
+	forged, err = vm.newBlock(other.ID(), other.Height()+1, &submission{data: [dataLen]byte{3}, namespace: "bookings"}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(forged.Verify(), errNamespaceForbidden)
+
+	// the tenant only reads its own namespace
+	blkID := blk.ID()
+	otherID := other.ID()
+	assert.NoError(service.GetBlock(tenant, &GetBlockArgs{ID: &blkID}, &GetBlockReply{}))
+	assert.ErrorIs(service.GetBlock(tenant, &GetBlockArgs{ID: &otherID}, &GetBlockReply{}), errForbidden)
+	assert.NoError(service.GetBlock(nil, &GetBlockArgs{ID: &otherID}, &GetBlockReply{}))
+	assert.NoError(service.GetBlockHeader(tenant, &GetBlockArgs{ID: &blkID}, &GetBlockHeaderReply{}))
+	assert.ErrorIs(service.GetBlockHeader(tenant, &GetBlockArgs{ID: &otherID}, &GetBlockHeaderReply{}), errForbidden)
+	assert.ErrorIs(service.GetBlockHeader(tenant, &GetBlockArgs{}, &GetBlockHeaderReply{}), errForbidden)
+	assert.NoError(service.GetBlockHeader(nil, &GetBlockArgs{ID: &otherID}, &GetBlockHeaderReply{}))
+	assert.NoError(service.GetByNamespace(tenant, &GetByNamespaceArgs{Namespace: "bookings"}, &GetByNamespaceReply{}))
+	assert.ErrorIs(service.GetByNamespace(tenant, &GetByNamespaceArgs{Namespace: "invoices"}, &GetByNamespaceReply{}), errForbidden)
+	assert.NoError(service.GetBlocksBySubmitter(tenant, &GetBlocksBySubmitterArgs{Submitter: alice.PublicKey().Address()}, &GetBlocksReply{}))
+	assert.ErrorIs(service.GetBlocksBySubmitter(tenant, &GetBlocksBySubmitterArgs{Submitter: bob.PublicKey().Address()}, &GetBlocksReply{}), errForbidden)
+
+	_, err = ParseConfig([]byte(`{"apiKeyNamespaces": {"tenant": {}}}`))
+	assert.ErrorIs(err, errNoNamespaces)
+	_, err = ParseConfig([]byte(`{"namespaceSubmitters": {"bookings": []}}`))
+	assert.ErrorIs(err, errNamespacesWithoutSigning)
+}
+
+func TestNamespaceRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"namespaceRoundRobin": true, "namespaceWeights": {"bookings": 2}}`))
+	assert.NoError(err)
+
+	// bookings flood the mempool before invoices arrive
+	for i := byte(1); i <= 4; i++ {
+		vm.proposeSubmission(&submission{data: [dataLen]byte{i}, namespace: "bookings"})
+	}
+	vm.proposeSubmission(&submission{data: [dataLen]byte{5}, namespace: "invoices"})
+	vm.proposeSubmission(&submission{data: [dataLen]byte{6}, namespace: "invoices"})
+
+	// the mempool takes two bookings for each invoice
+	namespaces := []string(nil)
+	data := []byte(nil)
+	for vm.mempool.Len() > 0 {
+		sub, ok := vm.mempool.Pop()
+		assert.True(ok)
+		namespaces = append(namespaces, sub.namespace)
+		data = append(data, sub.data[0])
+	}
+	assert.Equal([]string{"bookings", "invoices", "bookings", "bookings", "invoices", "bookings"}, namespaces)
+	assert.Equal([]byte{1, 5, 2, 3, 6, 4}, data)
+
+	_, err = ParseConfig([]byte(`{"namespaceRoundRobin": true, "namespaceWeights": {"bookings": 0}}`))
+	assert.ErrorIs(err, errZeroNamespaceWeight)
+}
+
+func TestCacheMetrics(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	lastAcceptedID, err := vm.LastAccepted()
+	assert.NoError(err)
+	_, err = vm.state.GetBlock(lastAcceptedID)
+	assert.NoError(err)
+	_, err = vm.state.GetAcceptedID(0)
+	assert.NoError(err)
+
+	metrics, err := vm.ctx.Metrics.Gather()
+	assert.NoError(err)
+	names := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		names = append(names, metric.GetName())
+	}
+	assert.Contains(names, "block_cache_hit")
+	assert.Contains(names, "block_id_cache_hit")
+}
+
+func TestPruning(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"pruningEnabled": true, "pruningRetainBlocks": 2, "pruningBatchSize": 2}`))
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	acceptedIDs := []ids.ID{genesisID}
+	for i := byte(1); i <= 5; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		acceptedIDs = append(acceptedIDs, blk.ID())
+	}
+
+	assert.NoError(vm.pruner.prune())
+	status := vm.pruner.Status()
+	assert.Equal(json.Uint64(4), status.PrunedHeight)
+	assert.Equal(json.Uint64(4), status.TargetHeight)
+
+	// genesis and the last 2 blocks are retained
+	for height, blkID := range acceptedIDs {
+		_, err := vm.getBlock(blkID)
+		if height == 0 || height >= 4 {
+			assert.NoError(err)
+		} else {
+			assert.ErrorIs(err, database.ErrNotFound)
+		}
+	}
+
+	// the retention must cover the uniqueness window
+	_, err = ParseConfig([]byte(`{"pruningEnabled": true, "pruningRetainBlocks": 2, "uniquenessWindow": 2}`))
+	assert.ErrorIs(err, errRetentionBelowUniquenessWindow)
+}
+
+func TestPruningKeepHeaders(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"pruningEnabled": true, "pruningRetainBlocks": 1, "pruningKeepHeaders": true}`))
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	data := [dataLen]byte{1}
+	vm.proposeBlock(data)
+	blk1, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk1.Accept())
+	assert.NoError(vm.SetPreference(blk1.ID()))
+	vm.proposeBlock([dataLen]byte{2})
+	blk2, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk2.Accept())
+
+	assert.NoError(vm.pruner.prune())
+
+	// the body is gone, but the header remains
+	_, err = vm.getBlock(blk1.ID())
+	assert.ErrorIs(err, database.ErrNotFound)
+	service := Service{vm}
+	blkID := blk1.ID()
+	reply := GetBlockHeaderReply{}
+	assert.NoError(service.GetBlockHeader(nil, &GetBlockArgs{ID: &blkID}, &reply))
+	assert.Equal(genesisID, reply.ParentID)
+	assert.Equal(json.Uint64(1), reply.Height)
+	assert.Equal(DataHash(data), reply.DataHash)
+	assert.Equal(choices.Accepted, reply.Status)
+}
+
+func TestNamespaceRetention(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"namespaceRetention": {"logs": "1h"}}`))
+	assert.NoError(err)
+	parent, err := vm.lastAcceptedBlock()
+	assert.NoError(err)
+
+	old := time.Now().Add(-2 * time.Hour)
+	anchored := []*Block(nil)
+	for i, anchor := range []struct {
+		namespace string
+		timestamp time.Time
+	}{
+		{"logs", old},
+		{"other", old},
+		{"logs", old},
+		{"logs", time.Now()},
+	} {
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: [dataLen]byte{byte(i + 1)}, namespace: anchor.namespace}, anchor.timestamp)
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		anchored = append(anchored, blk)
+		parent = blk
+	}
+	assert.NoError(vm.retention.Run())
+
+	// only the expired bodies of the namespace are dropped
+	for i, dropped := range []bool{true, false, true, false} {
+		_, err := vm.state.GetBlockBody(anchored[i].ID())
+		if dropped {
+			assert.ErrorIs(err, database.ErrNotFound)
+		} else {
+			assert.NoError(err)
+		}
+	}
+	service := Service{vm}
+	page := GetByNamespaceReply{}
+	assert.NoError(service.GetByNamespace(nil, &GetByNamespaceArgs{Namespace: "logs"}, &page))
+	assert.Len(page.Blocks, 3)
+	assert.True(page.Blocks[0].BodyDropped)
+	assert.Empty(page.Blocks[0].Data)
+	assert.Nil(page.Blocks[0].RedactedBy)
+	assert.True(page.Blocks[1].BodyDropped)
+	assert.False(page.Blocks[2].BodyDropped)
+	assert.NotEmpty(page.Blocks[2].Data)
+	header, err := vm.state.GetBlockHeader(anchored[0].ID())
+	assert.NoError(err)
+	assert.Equal(DataHash([dataLen]byte{1}), header.DataHash)
+
+	// runs resume after the dropped blocks
+	retentionHeight, err := vm.retentionHeight("logs")
+	assert.NoError(err)
+	assert.Equal(uint64(4), retentionHeight)
+	assert.NoError(vm.retention.Run())
+	assert.NoError(vm.integrity.Verify())
+
+	_, err = ParseConfig([]byte(`{"namespaceRetention": {"logs": "0s"}}`))
+	assert.ErrorIs(err, errNonPositiveRetention)
+}
+
+func TestCommitReveal(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	accept := func() *Block {
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		return blk.(*Block)
+	}
+	encode := func(data [dataLen]byte) string {
+		encoded, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		return encoded
+	}
+	salt := ids.GenerateTestID()
+	value := [dataLen]byte{1, 2, 3}
+	commitment := Commitment(salt, value)
+
+	// nothing can be revealed before the commitment is accepted
+	revealArgs := &ProposeRevealArgs{Salt: salt, Value: encode(value), Namespace: "bids"}
+	assert.ErrorIs(service.ProposeReveal(nil, revealArgs, &ProposeBlockReply{}), errCommitmentUnknown)
+	assert.ErrorIs(service.GetCommitment(nil, &GetCommitmentArgs{Commitment: encode(commitment)}, &GetCommitmentReply{}), errCommitmentUnknown)
+
+	assert.NoError(service.ProposeCommitment(nil, &ProposeCommitmentArgs{Commitment: encode(commitment)}, &ProposeBlockReply{}))
+	commitBlk := accept()
+	status := GetCommitmentReply{}
+	assert.NoError(service.GetCommitment(nil, &GetCommitmentArgs{Commitment: encode(commitment)}, &status))
+	assert.Equal(CommitmentCommitted, status.Status)
+	assert.Equal(commitBlk.ID(), status.Commitment.ID)
+	assert.Nil(status.Reveal)
+
+	// a wrong salt reveals nothing
+	assert.ErrorIs(service.ProposeReveal(nil, &ProposeRevealArgs{Salt: ids.GenerateTestID(), Value: encode(value)}, &ProposeBlockReply{}), errCommitmentUnknown)
+
+	assert.NoError(service.ProposeReveal(nil, revealArgs, &ProposeBlockReply{}))
+	revealBlk, err := vm.BuildBlock()
+	assert.NoError(err)
+	parsed, err := vm.ParseBlock(revealBlk.Bytes())
+	assert.NoError(err)
+	assert.Equal(salt, parsed.(*Block).Reveal().Salt)
+	assert.Equal("bids", parsed.(*Block).Namespace())
+	assert.NoError(revealBlk.Verify())
+
+	// a processing descendant can't reveal the commitment again
+	again, err := vm.newBlock(revealBlk.ID(), revealBlk.Height()+1, &submission{data: value, reveal: &Reveal{Salt: salt}}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(again.Verify(), errCommitmentRevealed)
+
+	assert.NoError(revealBlk.Accept())
+	assert.NoError(vm.SetPreference(revealBlk.ID()))
+	status = GetCommitmentReply{}
+	assert.NoError(service.GetCommitment(nil, &GetCommitmentArgs{Commitment: encode(commitment)}, &status))
+	assert.Equal(CommitmentRevealed, status.Status)
+	assert.Equal(commitBlk.ID(), status.Commitment.ID)
+	assert.Equal(revealBlk.ID(), status.Reveal.ID)
+	assert.Equal(salt, status.Reveal.Reveal.Salt)
+	assert.Equal(encode(value), status.Reveal.Data)
+
+	// each commitment is revealed once
+	assert.ErrorIs(service.ProposeReveal(nil, revealArgs, &ProposeBlockReply{}), errCommitmentRevealed)
+	again, err = vm.newBlock(revealBlk.ID(), revealBlk.Height()+1, &submission{data: value, reveal: &Reveal{Salt: salt}}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(again.Verify(), errCommitmentRevealed)
+}
+
+func TestEncryptedPayloads(t *testing.T) {
+	assert := assert.New(t)
+	recipient, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	encryptionKey, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	stranger, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"signedSubmissions": true, "encryptedPayloadsEnabled": true, "maxCiphertextSize": 64}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	sign := func(key crypto.PrivateKey, msg []byte) string {
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		return encodedSig
+	}
+	encode := func(b []byte) string {
+		encoded, err := formatting.EncodeWithChecksum(formatting.CB58, b)
+		assert.NoError(err)
+		return encoded
+	}
+	accept := func() *Block {
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		return blk.(*Block)
+	}
+	recipientAddr := recipient.PublicKey().Address()
+	payloadArgs := &ProposeEncryptedPayloadArgs{
+		Recipients: []ids.ShortID{recipientAddr},
+		Ciphertext: encode([]byte("ciphertext")),
+	}
+	assert.ErrorIs(service.ProposeEncryptedPayload(nil, payloadArgs, &ProposeBlockReply{}), errRecipientNotRegistered)
+
+	registration := &KeyRegistration{PublicKey: encryptionKey.PublicKey().Bytes()}
+	msg, err := KeyRegistrationMessage(vm.ctx.ChainID, registration)
+	assert.NoError(err)
+	registerArgs := &RegisterRecipientKeyArgs{PublicKey: encode(registration.PublicKey), Signature: sign(recipient, msg)}
+	assert.ErrorIs(service.RegisterRecipientKey(nil, &RegisterRecipientKeyArgs{PublicKey: encode([]byte{1, 2, 3}), Signature: registerArgs.Signature}, &ProposeBlockReply{}), errBadPublicKey)
+	reply := ProposeBlockReply{}
+	assert.NoError(service.RegisterRecipientKey(nil, registerArgs, &reply))
+	assert.Equal(recipientAddr, *reply.Submitter)
+	accept()
+	key := GetRecipientKeyReply{}
+	assert.NoError(service.GetRecipientKey(nil, &GetRecipientKeyArgs{Address: recipientAddr}, &key))
+	assert.EqualValues(1, key.Nonce)
+	assert.Equal(registerArgs.PublicKey, key.PublicKey)
+	// a registration can't be replayed
+	assert.ErrorIs(service.RegisterRecipientKey(nil, registerArgs, &ProposeBlockReply{}), errKeyRegistrationNonce)
+
+	// ciphertexts are bounded
+	assert.ErrorIs(service.ProposeEncryptedPayload(nil, &ProposeEncryptedPayloadArgs{
+		Recipients: payloadArgs.Recipients,
+		Ciphertext: encode(make([]byte, 65)),
+	}, &ProposeBlockReply{}), errCiphertextSize)
+
+	assert.NoError(service.ProposeEncryptedPayload(nil, payloadArgs, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	parsed, err := vm.ParseBlock(blk.Bytes())
+	assert.NoError(err)
+	assert.Equal([]byte("ciphertext"), parsed.(*Block).EncryptedPayload().Ciphertext)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	blkReply := GetBlockReply{}
+	blkID := blk.ID()
+	assert.NoError(service.GetBlock(nil, &GetBlockArgs{ID: &blkID}, &blkReply))
+	assert.Equal([]ids.ShortID{recipientAddr}, blkReply.EncryptedTo)
+
+	// the ciphertext is only served to its recipients
+	data := blk.(*Block).Data()
+	msg, err = CiphertextRequestMessage(vm.ctx.ChainID, data)
+	assert.NoError(err)
+	ciphertextArgs := &GetCiphertextArgs{Data: encode(data[:]), Signature: sign(stranger, msg)}
+	assert.ErrorIs(service.GetCiphertext(nil, ciphertextArgs, &GetCiphertextReply{}), errNotRecipient)
+	ciphertextArgs.Signature = sign(recipient, msg)
+	ciphertext := GetCiphertextReply{}
+	assert.NoError(service.GetCiphertext(nil, ciphertextArgs, &ciphertext))
+	assert.Equal(blkID, ciphertext.BlockID)
+	assert.Equal(encode([]byte("ciphertext")), ciphertext.Ciphertext)
+
+	// a block whose data isn't the hash of its payload is invalid
+	forged, err := vm.newBlock(blkID, blk.Height()+1, &submission{data: [dataLen]byte{1}, encrypted: blk.(*Block).EncryptedPayload()}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(forged.Verify(), errEncryptedPayloadData)
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	encode := func(b []byte) string {
+		encoded, err := formatting.EncodeWithChecksum(formatting.CB58, b)
+		assert.NoError(err)
+		return encoded
+	}
+	document := []byte("invoice")
+	digest, err := Digest(SHA3256, document)
+	assert.NoError(err)
+	assert.ErrorIs(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encode(digest[:]), HashAlgorithm: "md5"}, &ProposeBlockReply{}), errUnknownHashAlgorithm)
+	assert.ErrorIs(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encode(digest[:]), HashAlgorithm: SHA3256, Tags: map[string]string{hashAlgorithmTagKey: Blake2b256}}, &ProposeBlockReply{}), errConflictingAlgorithm)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encode(digest[:]), HashAlgorithm: SHA3256}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.SetPreference(blk.ID()))
+
+	verified := VerifyTimestampReply{}
+	assert.NoError(service.VerifyTimestamp(nil, &VerifyTimestampArgs{Document: encode(document)}, &verified))
+	assert.Equal(SHA3256, verified.HashAlgorithm)
+	assert.Equal(blk.ID(), verified.Block.ID)
+	assert.Equal(SHA3256, verified.Block.Tags[hashAlgorithmTagKey])
+	// the digest doesn't match under another algorithm
+	assert.ErrorIs(service.VerifyTimestamp(nil, &VerifyTimestampArgs{Document: encode(document), HashAlgorithm: Blake2b256}, &VerifyTimestampReply{}), errDataNotAnchored)
+
+	// undeclared digests are SHA-256
+	sha256Digest, err := Digest(SHA256, []byte("receipt"))
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encode(sha256Digest[:])}, &ProposeBlockReply{}))
+	blk, err = vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+	verified = VerifyTimestampReply{}
+	assert.NoError(service.VerifyTimestamp(nil, &VerifyTimestampArgs{Document: encode([]byte("receipt"))}, &verified))
+	assert.Equal(SHA256, verified.HashAlgorithm)
+
+	// blocks declaring unknown algorithms are invalid
+	unknown, err := vm.newBlock(blk.ID(), blk.Height()+1, &submission{data: [dataLen]byte{1}, tags: []Tag{{Key: hashAlgorithmTagKey, Value: "md5"}}}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(unknown.Verify(), errUnknownHashAlgorithm)
+}
+
+func TestSaltedContent(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	content, err := formatting.EncodeWithChecksum(formatting.CB58, []byte("PNR ABC123"))
+	assert.NoError(err)
+	salted := ProposeSaltedContentReply{}
+	assert.NoError(service.ProposeSaltedContent(nil, &ProposeSaltedContentArgs{Content: content}, &salted))
+	assert.True(salted.Success)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	// only the salted hash is anchored
+	data := blk.(*Block).Data()
+	assert.Equal(SaltedHash(salted.Salt, []byte("PNR ABC123")), data)
+	assert.ErrorIs(service.VerifyTimestamp(nil, &VerifyTimestampArgs{Document: content}, &VerifyTimestampReply{}), errDataNotAnchored)
+	verified := VerifyTimestampReply{}
+	assert.NoError(service.VerifyTimestamp(nil, &VerifyTimestampArgs{Document: content, Salt: salted.Salt}, &verified))
+	assert.Equal(blk.ID(), verified.Block.ID)
+
+	// each proposal is salted afresh
+	again := ProposeSaltedContentReply{}
+	assert.NoError(service.ProposeSaltedContent(nil, &ProposeSaltedContentArgs{Content: content}, &again))
+	assert.NotEqual(salted.Salt, again.Salt)
+	assert.NotEqual(salted.Data, again.Data)
+}
+
+func TestUpload(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"maxUploadSize": 16, "requestReadTimeout": "50ms"}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	upload := func(query string, contentType string, body io.Reader) (*httptest.ResponseRecorder, UploadReply) {
+		request := httptest.NewRequest(http.MethodPost, "/upload"+query, body)
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		handlers["/upload"].Handler.ServeHTTP(recorder, request)
+		reply := UploadReply{}
+		if recorder.Code == http.StatusOK {
+			assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &reply))
+		}
+		return recorder, reply
+	}
+
+	// raw bodies are hashed with SHA-256 by default
+	recorder, reply := upload("", "application/pdf", strings.NewReader("boarding pass"))
+	assert.Equal(http.StatusOK, recorder.Code)
+	digest := sha256.Sum256([]byte("boarding pass"))
+	assert.Equal(hex.EncodeToString(digest[:]), reply.Hash)
+	assert.Equal(SHA256, reply.HashAlgorithm)
+	assert.EqualValues(len("boarding pass"), reply.Size)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+	assert.Equal([dataLen]byte(digest), blk.(*Block).Data())
+	found := GetBlockReply{}
+	assert.NoError((&Service{vm}).GetBlockByData(nil, &GetBlockByDataArgs{Data: reply.Data}, &found))
+	assert.Equal(blk.ID(), found.ID)
+
+	// multipart forms upload the field "file", hashed as requested
+	form := &bytes.Buffer{}
+	writer := multipart.NewWriter(form)
+	assert.NoError(writer.WriteField("comment", "ignored"))
+	part, err := writer.CreateFormFile("file", "ticket.pdf")
+	assert.NoError(err)
+	_, err = part.Write([]byte("ticket"))
+	assert.NoError(err)
+	assert.NoError(writer.Close())
+	recorder, reply = upload("?hashAlgorithm=blake2b-256&tag=trip=42", writer.FormDataContentType(), form)
+	assert.Equal(http.StatusOK, recorder.Code)
+	blake, err := Digest(Blake2b256, []byte("ticket"))
+	assert.NoError(err)
+	assert.Equal(hex.EncodeToString(blake[:]), reply.Hash)
+	blk, err = vm.BuildBlock()
+	assert.NoError(err)
+	assert.Equal(blake, blk.(*Block).Data())
+	assert.Equal(Blake2b256, declaredHashAlgorithm(blk.(*Block).Tags()))
+
+	// files beyond the maximum size, unknown algorithms and other methods
+	// are refused
+	recorder, _ = upload("", "application/octet-stream", strings.NewReader("a file beyond 16 bytes"))
+	assert.Equal(http.StatusRequestEntityTooLarge, recorder.Code)
+	recorder, _ = upload("?hashAlgorithm=md5", "application/octet-stream", strings.NewReader("ticket"))
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	// as are files whose body stalls, though it started in time
+	stalled, stalledWriter := io.Pipe()
+	defer stalledWriter.Close()
+	go func() { _, _ = stalledWriter.Write([]byte("board")) }()
+	recorder, _ = upload("", "application/octet-stream", stalled)
+	assert.Equal(http.StatusRequestTimeout, recorder.Code)
+	request := httptest.NewRequest(http.MethodGet, "/upload", nil)
+	recorder = httptest.NewRecorder()
+	handlers["/upload"].Handler.ServeHTTP(recorder, request)
+	assert.Equal(http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestUploadSessions(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"maxUploadSessions": 1, "maxUploadChunkSize": 8, "requestReadTimeout": "50ms"}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		handlers[request.URL.Path].Handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := post("/upload/begin?hashAlgorithm=sha3-256", "")
+	assert.Equal(http.StatusOK, recorder.Code)
+	begun := BeginUploadReply{}
+	assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &begun))
+	// only one session may be open
+	assert.Equal(http.StatusServiceUnavailable, post("/upload/begin", "").Code)
+
+	session := "?session=" + begun.Session.String()
+	assert.Equal(http.StatusOK, post("/upload/append"+session+"&offset=0", "archive ").Code)
+	// resent chunks are refused
+	assert.Equal(http.StatusConflict, post("/upload/append"+session+"&offset=0", "archive ").Code)
+	// as are stalled chunks, which leave the session as it was
+	stalled, stalledWriter := io.Pipe()
+	defer stalledWriter.Close()
+	request := httptest.NewRequest(http.MethodPost, "/upload/append"+session+"&offset=8", stalled)
+	recorder = httptest.NewRecorder()
+	handlers["/upload/append"].Handler.ServeHTTP(recorder, request)
+	assert.Equal(http.StatusRequestTimeout, recorder.Code)
+	assert.Equal(http.StatusRequestEntityTooLarge, post("/upload/append"+session+"&offset=8", "of bookings").Code)
+	recorder = post("/upload/append"+session+"&offset=8", "2022")
+	assert.Equal(http.StatusOK, recorder.Code)
+	appended := AppendUploadReply{}
+	assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &appended))
+	assert.EqualValues(12, appended.Size)
+
+	recorder = post("/upload/finish"+session, "")
+	assert.Equal(http.StatusOK, recorder.Code)
+	finished := UploadReply{}
+	assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &finished))
+	digest, err := Digest(SHA3256, []byte("archive 2022"))
+	assert.NoError(err)
+	assert.Equal(hex.EncodeToString(digest[:]), finished.Hash)
+	assert.EqualValues(12, finished.Size)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.Equal(digest, blk.(*Block).Data())
+	assert.Equal(SHA3256, declaredHashAlgorithm(blk.(*Block).Tags()))
+
+	// finished sessions are closed
+	assert.Equal(http.StatusNotFound, post("/upload/append"+session+"&offset=12", "more").Code)
+	assert.Equal(http.StatusNotFound, post("/upload/finish"+session, "").Code)
+	assert.Equal(http.StatusOK, post("/upload/begin", "").Code)
+}
+
+func TestRawProposal(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"signedSubmissions": true}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	propose := func(query string, contentType string, body []byte) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/propose"+query, bytes.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		handlers["/propose"].Handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	data := [dataLen]byte{1, 2, 3}
+	key, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	message, err := ScopedSubmissionMessage(vm.ctx.ChainID, data, "", []Tag{{Key: "device", Value: "gate-7"}})
+	assert.NoError(err)
+	sig, err := key.Sign(message)
+	assert.NoError(err)
+	signature, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+	assert.NoError(err)
+
+	// the body must be the 32 bytes of the data, sent as binary
+	assert.Equal(http.StatusUnsupportedMediaType, propose("", "application/x-www-form-urlencoded", data[:]).Code)
+	assert.Equal(http.StatusBadRequest, propose("", rawProposalType, data[:31]).Code)
+	assert.Equal(http.StatusRequestEntityTooLarge, propose("", rawProposalType, append(data[:], 0)).Code)
+	assert.Equal(http.StatusBadRequest, propose("?signature=invalid", rawProposalType, data[:]).Code)
+
+	recorder := propose("?signature="+signature+"&tag=device=gate-7", rawProposalType, data[:])
+	assert.Equal(http.StatusOK, recorder.Code)
+	reply := RawProposalReply{}
+	assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &reply))
+	assert.True(reply.Success)
+	assert.Equal(key.PublicKey().Address(), *reply.Submitter)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.Equal(data, blk.(*Block).Data())
+	assert.Equal([]Tag{{Key: "device", Value: "gate-7"}}, blk.(*Block).Tags())
+}
+
+func TestOfflineVerification(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"signedSubmissions": true}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// the package mirrors the encoding of the VM
+	assert.EqualValues(EncryptedProofOfWorkCodecVersion, verify.MaxCodecVersion)
+	data := [dataLen]byte{7}
+	message, err := SubmissionMessage(vm.ctx.ChainID, data)
+	assert.NoError(err)
+	assert.Equal(message, verify.SubmissionMessage(vm.ctx.ChainID, data))
+	message, err = ScopedSubmissionMessage(vm.ctx.ChainID, data, "bookings", []Tag{{Key: "trip", Value: "42"}})
+	assert.NoError(err)
+	assert.Equal(message, verify.ScopedSubmissionMessage(vm.ctx.ChainID, data, "bookings", []verify.Tag{{Key: "trip", Value: "42"}}))
+
+	key, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	sig, err := key.Sign(message)
+	assert.NoError(err)
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	signature, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{
+		Data:      encodedData,
+		Signature: signature,
+		Namespace: "bookings",
+		Tags:      map[string]string{"trip": "42"},
+	}, &ProposeBlockReply{}))
+	anchor, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(anchor.Verify())
+	assert.NoError(anchor.Accept())
+	assert.NoError(vm.SetPreference(anchor.ID()))
+	tipData := [dataLen]byte{8}
+	encodedData, err = formatting.EncodeWithChecksum(formatting.CB58, tipData[:])
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData}, &ProposeBlockReply{}))
+	tip, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(tip.Verify())
+	assert.NoError(tip.Accept())
+
+	blockBytes := func(id ids.ID) []byte {
+		reply := GetBlockBytesReply{}
+		assert.NoError(service.GetBlockBytes(nil, &GetBlockArgs{ID: &id}, &reply))
+		decoded, err := formatting.Decode(formatting.Hex, reply.Bytes)
+		assert.NoError(err)
+		return decoded
+	}
+	blocks := [][]byte{blockBytes(anchor.ID()), blockBytes(tip.ID())}
+
+	header, err := verify.VerifyAnchor(vm.ctx.ChainID, tip.ID(), blocks, data, key.PublicKey().Address())
+	assert.NoError(err)
+	assert.Equal(anchor.ID(), header.ID)
+	assert.Equal(genesisID, header.ParentID)
+	assert.EqualValues(1, header.Height)
+	assert.Equal(anchor.Timestamp().Unix(), header.Timestamp)
+	assert.Equal("bookings", header.Namespace)
+	assert.Equal([]verify.Tag{{Key: "trip", Value: "42"}}, header.Tags)
+
+	// the blocks must lead up to the trusted block
+	_, err = verify.VerifyAncestry(genesisID, blocks)
+	assert.Error(err)
+	_, err = verify.VerifyAncestry(tip.ID(), [][]byte{blocks[1], blocks[0]})
+	assert.Error(err)
+	_, err = verify.VerifyAnchor(vm.ctx.ChainID, tip.ID(), blocks, tipData, ids.ShortEmpty)
+	assert.Error(err)
+	_, err = verify.VerifyAnchor(vm.ctx.ChainID, tip.ID(), blocks, data, ids.ShortID{1})
+	assert.Error(err)
+	// tampered blocks have other IDs
+	tampered := append([]byte{}, blocks[0]...)
+	tampered[len(tampered)-1]++
+	_, err = verify.VerifyAncestry(tip.ID(), [][]byte{tampered, blocks[1]})
+	assert.Error(err)
+}
+
+func TestParseHeaderCodecVersions(t *testing.T) {
+	assert := assert.New(t)
+	// every optional field is set, so each version encodes all of its fields
+	blk := &Block{
+		PrntID: ids.ID{1},
+		Hght:   2,
+		Tmstmp: 3,
+		Dt:     [dataLen]byte{4},
+		Sgntr:  []byte{5, 6},
+		Updt:   &AllowlistUpdate{Nonce: 7},
+		PChnHt: 8,
+		Trnsfr: &Transfer{Nonce: 9},
+		PrfWrk: &ProofOfWork{RecentID: ids.ID{10}, Nonce: 11},
+		Grnt:   &CreditGrant{Nonce: 12},
+		Nmspc:  "bookings",
+		Tgs:    []Tag{{Key: "trip", Value: "42"}, {Key: "zone", Value: "eu"}},
+		Schm:   &SchemaUpdate{Nonce: 13},
+		Rdctn:  &Redaction{Height: 14},
+		Rvl:    &Reveal{Salt: ids.ID{15}},
+		KyRg:   &KeyRegistration{Nonce: 16},
+		Ncrptd: &EncryptedPayload{Ciphertext: []byte{17}},
+	}
+	for version := uint16(0); version <= verify.MaxCodecVersion; version++ {
+		blockBytes, err := Codec.Marshal(version, blk)
+		assert.NoError(err, version)
+		decoded := &Block{}
+		_, err = Codec.Unmarshal(blockBytes, decoded)
+		assert.NoError(err, version)
+
+		// the package decodes the header of blocks encoded by the codec
+		header, err := verify.ParseHeader(blockBytes)
+		assert.NoError(err, version)
+		assert.Equal(ids.ID(hashing.ComputeHash256Array(blockBytes)), header.ID, version)
+		assert.Equal(version, header.CodecVersion)
+		assert.Equal(decoded.PrntID, header.ParentID, version)
+		assert.Equal(decoded.Hght, header.Height, version)
+		assert.Equal(decoded.Tmstmp, header.Timestamp, version)
+		assert.Equal(decoded.Dt, header.Data, version)
+		assert.Equal(len(decoded.Sgntr) > 0, len(header.Signature) > 0, version)
+		if len(decoded.Sgntr) > 0 {
+			assert.Equal(decoded.Sgntr, header.Signature, version)
+		}
+		assert.Equal(decoded.Nmspc, header.Namespace, version)
+		tags := []verify.Tag(nil)
+		for _, tag := range decoded.Tgs {
+			tags = append(tags, verify.Tag{Key: tag.Key, Value: tag.Value})
+		}
+		assert.Equal(tags, header.Tags, version)
+	}
+	// and knows every codec version
+	_, err := Codec.Marshal(verify.MaxCodecVersion+1, blk)
+	assert.Error(err)
+}
+
+func TestCertificate(t *testing.T) {
+	assert := assert.New(t)
+	nodeKey, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	encodedKey, err := formatting.EncodeWithChecksum(formatting.CB58, nodeKey.Bytes())
+	assert.NoError(err)
+	keyFile := filepath.Join(t.TempDir(), "certificate.key")
+	assert.NoError(os.WriteFile(keyFile, []byte(constants.SecretKeyPrefix+encodedKey+"\n"), 0o600))
+
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"certificateKeyFile": %q}`, keyFile)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	document := []byte("ticket 0815")
+	digest, err := Digest(Blake2b256, document)
+	assert.NoError(err)
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, digest[:])
+	assert.NoError(err)
+	assert.ErrorIs(service.GetCertificate(nil, &GetCertificateArgs{Data: data}, &GetCertificateReply{}), errDataNotAnchored)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{
+		Data:          data,
+		Namespace:     "tickets",
+		HashAlgorithm: Blake2b256,
+	}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	reply := GetCertificateReply{}
+	assert.NoError(service.GetCertificate(nil, &GetCertificateArgs{Data: data}, &reply))
+	assert.Equal(nodeKey.PublicKey().Address(), reply.Signer)
+	signature, err := formatting.Decode(formatting.CB58, reply.Signature)
+	assert.NoError(err)
+	signer, err := verify.VerifyCertificate([]byte(reply.Certificate), signature)
+	assert.NoError(err)
+	assert.Equal(reply.Signer, signer)
+	// altered certificates aren't signed by the node
+	altered := strings.Replace(reply.Certificate, `"namespace":"tickets"`, `"namespace":"other"`, 1)
+	assert.NotEqual(reply.Certificate, altered)
+	signer, err = verify.VerifyCertificate([]byte(altered), signature)
+	assert.NoError(err)
+	assert.NotEqual(reply.Signer, signer)
+
+	certificate := Certificate{}
+	assert.NoError(stdjson.Unmarshal([]byte(reply.Certificate), &certificate))
+	assert.Equal(blk.ID(), certificate.BlockID)
+	assert.Equal(data, certificate.Data)
+	assert.Equal(Blake2b256, certificate.HashAlgorithm)
+	assert.Equal("tickets", certificate.Namespace)
+	assert.Equal(blk.ID(), certificate.LastAcceptedID)
+	assert.NotEmpty(certificate.Instructions)
+	blockBytes, err := formatting.Decode(formatting.Hex, certificate.BlockBytes)
+	assert.NoError(err)
+	header, err := verify.ParseHeader(blockBytes)
+	assert.NoError(err)
+	assert.Equal(blk.ID(), header.ID)
+	assert.Equal(digest, header.Data)
+
+	// nodes without a certificate key issue none
+	plain, _, _, err := newTestVM()
+	assert.NoError(err)
+	assert.ErrorIs((&Service{plain}).GetCertificate(nil, &GetCertificateArgs{Data: data}, &GetCertificateReply{}), errCertificatesDisabled)
+}
+
+func TestCoSignedCertificate(t *testing.T) {
+	assert := assert.New(t)
+	nodeKey, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	encodedKey, err := formatting.EncodeWithChecksum(formatting.CB58, nodeKey.Bytes())
+	assert.NoError(err)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "certificate.key")
+	assert.NoError(os.WriteFile(keyFile, []byte(constants.SecretKeyPrefix+encodedKey), 0o600))
+	tokenFile := filepath.Join(dir, "cosigner.token")
+	assert.NoError(os.WriteFile(tokenFile, []byte("notary-token\n"), 0o600))
+
+	notaryKey, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	signingKey := notaryKey
+	notary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer notary-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		request := coSignRequest{}
+		assert.NoError(stdjson.NewDecoder(r.Body).Decode(&request))
+		sig, err := signingKey.Sign([]byte(request.Certificate))
+		assert.NoError(err)
+		signature, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		assert.NoError(stdjson.NewEncoder(w).Encode(&coSignReply{Signature: signature}))
+	}))
+	defer notary.Close()
+
+	config := fmt.Sprintf(`{"certificateKeyFile": %q, "coSignerURL": %q, "coSignerAddress": %q, "coSignerTokenFile": %q}`,
+		keyFile, notary.URL, notaryKey.PublicKey().Address(), tokenFile)
+	vm, _, _, err := newTestVMWithConfig([]byte(config))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, []byte{31: 1})
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: data}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	// the certificate is signed by both the node and the co-signer
+	reply := GetCertificateReply{}
+	assert.NoError(service.GetCertificate(nil, &GetCertificateArgs{Data: data}, &reply))
+	assert.Equal(notaryKey.PublicKey().Address(), *reply.CoSigner)
+	for signer, encoded := range map[ids.ShortID]string{reply.Signer: reply.Signature, *reply.CoSigner: reply.CoSignature} {
+		signature, err := formatting.Decode(formatting.CB58, encoded)
+		assert.NoError(err)
+		recovered, err := verify.VerifyCertificate([]byte(reply.Certificate), signature)
+		assert.NoError(err)
+		assert.Equal(signer, recovered)
+	}
+
+	// signatures by other keys are refused
+	signingKey = nodeKey
+	assert.ErrorIs(service.GetCertificate(nil, &GetCertificateArgs{Data: data}, &GetCertificateReply{}), errWrongCoSigner)
+}
+
+func TestExternalAnchor(t *testing.T) {
+	assert := assert.New(t)
+	lock := sync.Mutex{}
+	published := []externalAnchorRequest(nil)
+	failing := false
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failing {
+			http.Error(w, "out of gas", http.StatusInternalServerError)
+			return
+		}
+		request := externalAnchorRequest{}
+		assert.NoError(stdjson.NewDecoder(r.Body).Decode(&request))
+		published = append(published, request)
+		assert.NoError(stdjson.NewEncoder(w).Encode(&externalAnchorReply{
+			Network: "ethereum",
+			TxID:    fmt.Sprintf("0x%d", request.Height),
+		}))
+	}))
+	defer bridge.Close()
+
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"externalAnchorURL": %q, "externalAnchorInterval": "1h"}`, bridge.URL)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	// the genesis block is published once the VM starts
+	assert.Eventually(func() bool {
+		return vm.externalAnchorer.Status().Latest != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	accept := func(data byte) ids.ID {
+		vm.proposeBlock([dataLen]byte{data})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		return blk.ID()
+	}
+	firstID := accept(1)
+	secondID := accept(2)
+	assert.NoError(vm.externalAnchorer.anchor())
+	// the last accepted block isn't published twice
+	assert.NoError(vm.externalAnchorer.anchor())
+	lock.Lock()
+	assert.Len(published, 2)
+	assert.Equal(secondID, published[1].BlockID)
+	assert.Equal(uint64(2), published[1].Height)
+	lock.Unlock()
+
+	// blocks are proven by the transaction of their earliest published
+	// descendant
+	anchor := ExternalAnchor{}
+	assert.NoError(service.GetExternalAnchor(nil, &GetExternalAnchorArgs{ID: genesisID}, &anchor))
+	assert.Equal(genesisID, anchor.BlockID)
+	assert.Equal("0x0", anchor.TxID)
+	assert.NoError(service.GetExternalAnchor(nil, &GetExternalAnchorArgs{ID: firstID}, &anchor))
+	assert.Equal(secondID, anchor.BlockID)
+	assert.Equal("ethereum", anchor.Network)
+	assert.Equal("0x2", anchor.TxID)
+
+	thirdID := accept(3)
+	assert.ErrorIs(service.GetExternalAnchor(nil, &GetExternalAnchorArgs{ID: thirdID}, &anchor), errNoExternalAnchor)
+	assert.ErrorIs(service.GetExternalAnchor(nil, &GetExternalAnchorArgs{ID: ids.GenerateTestID()}, &anchor), errNoSuchBlock)
+
+	// failures are reported until the bridge recovers
+	lock.Lock()
+	failing = true
+	lock.Unlock()
+	assert.ErrorIs(vm.externalAnchorer.anchor(), errExternalAnchorFailed)
+	status := vm.externalAnchorer.Status()
+	assert.NotEmpty(status.LastError)
+	assert.Equal(secondID, status.Latest.BlockID)
+	lock.Lock()
+	failing = false
+	lock.Unlock()
+	assert.NoError(vm.externalAnchorer.anchor())
+	status = vm.externalAnchorer.Status()
+	assert.Empty(status.LastError)
+	assert.Equal(thirdID, status.Latest.BlockID)
+	assert.NoError(vm.Shutdown())
+
+	_, _, _, err = newTestVMWithConfig([]byte(`{"externalAnchorURL": "http://localhost", "readOnly": true}`))
+	assert.ErrorIs(err, errReadOnlyConflict)
+}
+
+func TestChainHeads(t *testing.T) {
+	assert := assert.New(t)
+	source, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	other, err := secpFactory.NewPrivateKey()
+	assert.NoError(err)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"signedSubmissions": true, "chainHeadSources": {"ledger": [%q]}}`,
+		source.PublicKey().Address(),
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	propose := func(key crypto.PrivateKey, head ChainHead) error {
+		msg, err := ChainHeadMessage(vm.ctx.ChainID, "", &head)
+		assert.NoError(err)
+		sig, err := key.Sign(msg)
+		assert.NoError(err)
+		encodedSig, err := formatting.EncodeWithChecksum(formatting.CB58, sig)
+		assert.NoError(err)
+		return service.ProposeChainHead(nil, &ProposeChainHeadArgs{ChainHead: head, Signature: encodedSig}, &ProposeBlockReply{})
+	}
+	accept := func() *Block {
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		return blk.(*Block)
+	}
+
+	// only the sources of a chain anchor its heads
+	head := ChainHead{Chain: "ledger", Height: 7, Hash: hashing.ComputeHash256Array([]byte("block 7"))}
+	assert.ErrorIs(propose(other, head), errChainHeadForbidden)
+	assert.ErrorIs(propose(source, ChainHead{Chain: "other", Height: 7, Hash: head.Hash}), errChainHeadForbidden)
+	assert.ErrorIs(propose(source, ChainHead{Chain: "ledger", Height: 7}), errEmptyChainHash)
+	assert.NoError(propose(source, head))
+	first := accept()
+	next := ChainHead{Chain: "ledger", Height: 8, Hash: hashing.ComputeHash256Array([]byte("block 8"))}
+	assert.NoError(propose(source, next))
+	accept()
+
+	// heads are looked up by height, or the highest one
+	reply := GetChainHeadReply{}
+	height := json.Uint64(7)
+	assert.NoError(service.GetChainHead(nil, &GetChainHeadArgs{Chain: "ledger", Height: &height}, &reply))
+	assert.Equal(head, reply.ChainHead)
+	assert.Equal(first.ID(), reply.Block.ID)
+	assert.NoError(service.GetChainHead(nil, &GetChainHeadArgs{Chain: "ledger"}, &reply))
+	assert.Equal(next, reply.ChainHead)
+	height = 9
+	assert.ErrorIs(service.GetChainHead(nil, &GetChainHeadArgs{Chain: "ledger", Height: &height}, &reply), errChainHeadNotAnchored)
+
+	// blocks whose tags don't match their data are refused
+	data, err := ChainHeadData(&head)
+	assert.NoError(err)
+	tags, err := parseTags(chainHeadTags(&next))
+	assert.NoError(err)
+	msg, err := SubmissionMessage(vm.ctx.ChainID, data)
+	assert.NoError(err)
+	sig, err := source.Sign(msg)
+	assert.NoError(err)
+	forged, err := vm.newBlock(vm.preferred, first.Height()+2, &submission{data: data, sig: sig, tags: tags}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(forged.Verify(), errBadChainHeadTags)
+
+	// the index is rebuilt with the others
+	assert.NoError(vm.reindexer.Run())
+	assert.NoError(service.GetChainHead(nil, &GetChainHeadArgs{Chain: "ledger"}, &reply))
+	assert.Equal(next, reply.ChainHead)
+
+	_, err = ParseConfig([]byte(`{"chainHeadSources": {"ledger": []}}`))
+	assert.ErrorIs(err, errChainHeadsWithoutSigning)
+	_, err = ParseConfig([]byte(`{"signedSubmissions": true, "chainHeadSources": {"bad chain": []}}`))
+	assert.ErrorIs(err, errBadChainName)
+}
+
+func TestCIDs(t *testing.T) {
+	assert := assert.New(t)
+	digest := hashing.ComputeHash256Array([]byte("content"))
+
+	// CIDs round trip through their canonical and alternative encodings
+	v1 := &CID{Version: 1, Codec: 0x55, HashAlgorithm: SHA256, Digest: digest}
+	encoded := v1.String()
+	assert.True(strings.HasPrefix(encoded, "bafkrei"))
+	for _, s := range []string{encoded, "B" + strings.ToUpper(encoded[1:]), "f" + hex.EncodeToString(v1.Bytes())} {
+		cid, err := ParseCID(s)
+		assert.NoError(err)
+		assert.Equal(v1, cid)
+	}
+	v0 := &CID{Codec: dagPBCodec, HashAlgorithm: SHA256, Digest: digest}
+	assert.True(strings.HasPrefix(v0.String(), "Qm"))
+	cid, err := ParseCID(v0.String())
+	assert.NoError(err)
+	assert.Equal(v0, cid)
+	_, err = ParseCID("xyz")
+	assert.ErrorIs(err, errUnknownMultibase)
+	_, err = ParseCID("bafy")
+	assert.ErrorIs(err, errBadCID)
+
+	var (
+		lock   sync.Mutex
+		pinned []string
+	)
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(http.MethodPost, r.Method)
+		assert.Equal("/api/v0/pin/add", r.URL.Path)
+		pinned = append(pinned, r.URL.Query().Get("arg"))
+		_, _ = w.Write([]byte(`{"Pins": []}`))
+	}))
+	defer node.Close()
+
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"ipfsAPIURL": %q, "ipfsPin": true}`, node.URL)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// the block anchoring a CID is found from it and its content is pinned
+	proposeReply := ProposeCIDReply{}
+	assert.NoError(service.ProposeCID(nil, &ProposeCIDArgs{CID: "B" + strings.ToUpper(encoded[1:])}, &proposeReply))
+	assert.Equal(encoded, proposeReply.CID)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+	assert.Equal(digest, blk.(*Block).Data())
+
+	reply := GetBlockReply{}
+	assert.NoError(service.GetBlockByCID(nil, &GetBlockByCIDArgs{CID: v0.String()}, &reply))
+	assert.Equal(blk.ID(), reply.ID)
+	sha3 := &CID{Version: 1, Codec: 0x55, HashAlgorithm: SHA3256, Digest: digest}
+	assert.ErrorIs(service.GetBlockByCID(nil, &GetBlockByCIDArgs{CID: sha3.String()}, &reply), errDataNotAnchored)
+
+	assert.Eventually(func() bool {
+		return vm.ipfs.Status().Processed == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.Equal([]string{encoded}, pinned)
+	lock.Unlock()
+	assert.NoError(vm.Shutdown())
+}
+
+func TestContractEvents(t *testing.T) {
+	assert := assert.New(t)
+	const (
+		contract = "0x00000000000000000000000000000000000000c0"
+		topic    = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+		txHash   = "0x00000000000000000000000000000000000000000000000000000000000000aa"
+	)
+	var (
+		lock    sync.Mutex
+		filters []map[string]interface{}
+	)
+	cChain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		request := struct {
+			Method string                   `json:"method"`
+			Params []map[string]interface{} `json:"params"`
+		}{}
+		assert.NoError(stdjson.NewDecoder(r.Body).Decode(&request))
+		switch request.Method {
+		case "eth_blockNumber":
+			_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": "0x11"}`))
+		case "eth_getLogs":
+			filters = append(filters, request.Params[0])
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "result": [
+				{"address": %q, "topics": [%q], "data": "0x01", "blockNumber": "0x10", "transactionHash": %q, "logIndex": "0x0"},
+				{"address": %q, "topics": [%q], "data": "0x02", "blockNumber": "0x10", "transactionHash": %q, "logIndex": "0x1", "removed": true},
+				{"address": %q, "topics": [%q], "data": "0x03", "blockNumber": "0x11", "transactionHash": %q, "logIndex": "0x0"}
+			]}`, contract, topic, txHash, contract, topic, txHash, contract, topic, txHash)))
+		}
+	}))
+	defer cChain.Close()
+
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"contractEventsURL": %q, "contractEventsAddress": %q, "contractEventsTopics": [%q], "contractEventsFromBlock": 16, "contractEventsNamespace": "events", "contractEventsInterval": "1h"}`,
+		cChain.URL, contract, topic,
+	)))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	// the events still part of the C-Chain are proposed and the position
+	// reached is persisted
+	assert.Eventually(func() bool {
+		return vm.contractEvents.Status().Relayed == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.Equal([]map[string]interface{}{{
+		"fromBlock": "0x10",
+		"toBlock":   "0x11",
+		"address":   contract,
+		"topics":    []interface{}{[]interface{}{topic}},
+	}}, filters)
+	lock.Unlock()
+	assert.EqualValues(18, vm.contractEvents.Status().NextBlock)
+	vm.ctx.Lock.Lock()
+	progressBytes, err := vm.state.GetJobProgress(contractEventsJobName)
+	vm.ctx.Lock.Unlock()
+	assert.NoError(err)
+	progress := contractEventsProgress{}
+	_, err = Codec.Unmarshal(progressBytes, &progress)
+	assert.NoError(err)
+	assert.Equal(contractEventsProgress{NextBlock: 18}, progress)
+
+	// the anchoring blocks are found from the transaction
+	for i := 0; i < 2; i++ {
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+	}
+	page := GetByNamespaceReply{}
+	assert.NoError(service.GetByTag(nil, &GetByTagArgs{Namespace: "events", Key: evmTxTagKey, Value: txHash[2:]}, &page))
+	assert.Len(page.Blocks, 2)
+	data, err := ContractEventData(&ContractEvent{
+		Contract:    contract,
+		BlockNumber: 17,
+		TxHash:      txHash,
+		Topics:      []string{topic},
+		Data:        []byte{3},
+	})
+	assert.NoError(err)
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	assert.Equal(encodedData, page.Blocks[1].Data)
+	assert.NoError(vm.Shutdown())
+
+	_, err = ParseConfig([]byte(`{"contractEventsURL": "http://localhost", "contractEventsAddress": "0x01"}`))
+	assert.ErrorIs(err, errBadContractAddress)
+	_, err = ParseConfig([]byte(fmt.Sprintf(`{"contractEventsURL": "http://localhost", "contractEventsAddress": %q, "contractEventsTopics": ["0x01"]}`, contract)))
+	assert.ErrorIs(err, errBadEventTopic)
+}
+
+func TestInclusionProof(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	blocks := []*Block{}
+	for i := 0; i < 3; i++ {
+		data := hashing.ComputeHash256Array([]byte{byte(i)})
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Tags: map[string]string{"n": fmt.Sprint(i)}}, &ProposeBlockReply{}))
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		blocks = append(blocks, blk.(*Block))
+	}
+	first := blocks[0]
+	data := first.Data()
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+
+	// the proof computes the anchoring block's ID, or the ID of a descendant
+	for _, root := range []*Block{first, blocks[2]} {
+		reply := GetInclusionProofReply{}
+		assert.NoError(service.GetInclusionProof(nil, &GetInclusionProofArgs{Data: encodedData, RootID: root.ID()}, &reply))
+		assert.Equal(first.ID(), reply.BlockID)
+		assert.Equal(root.ID(), reply.RootID)
+		proof := reply.Proof.Exist
+		assert.Equal(uint64(first.Tmstmp), binary.BigEndian.Uint64(proof.Key))
+		assert.Equal(data[:], proof.Value)
+		assert.Len(proof.Path, int(root.Height()-first.Height())+1)
+		computed, err := proof.Calculate()
+		assert.NoError(err)
+		rootID := root.ID()
+		assert.Equal(rootID[:], computed)
+		assert.Equal(hex.EncodeToString(reply.Proof.Bytes()), reply.ProofBytes)
+	}
+	assert.ErrorIs(service.GetInclusionProof(nil, &GetInclusionProofArgs{Data: encodedData, RootID: genesisID}, &GetInclusionProofReply{}), errProofRootNotDescendant)
+
+	// proofs are encoded like the ICS23 protobuf messages
+	proof := &CommitmentProof{Exist: &ExistenceProof{
+		Key:   []byte{1},
+		Value: []byte{2},
+		Leaf:  &LeafOp{Hash: HashOpSHA256, Prefix: []byte{0}},
+		Path:  []*InnerOp{{Hash: HashOpSHA256, Prefix: []byte{1}}},
+	}}
+	assert.Equal("0a140a01011201021a0508012a010022050801120101", hex.EncodeToString(proof.Bytes()))
+	leafJSON, err := stdjson.Marshal(proof.Exist.Leaf)
+	assert.NoError(err)
+	assert.JSONEq(`{"hash": "SHA256", "prehashKey": "NO_HASH", "prehashValue": "NO_HASH", "length": "NO_PREFIX", "prefix": "AA=="}`, string(leafJSON))
+}
+
+// fakeSQL is a database/sql driver standing in for PostgreSQL and SQLite. It
+// keeps the exported block rows, the number of tags of each block and the
+// cursor.
+type fakeSQL struct {
+	lock   sync.Mutex
+	blocks map[int64][]driver.Value
+	tags   map[int64]int
+	cursor *int64
+}
+
+var testSQL = registerFakePostgres()
+
+func registerFakePostgres() *fakeSQL {
+	db := &fakeSQL{}
+	db.reset()
+	sql.Register("fakepostgres", db)
+	sql.Register("fakesqlite", db)
+	return db
+}
+
+// reset empties the database
+func (db *fakeSQL) reset() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.blocks = map[int64][]driver.Value{}
+	db.tags = map[int64]int{}
+	db.cursor = nil
+}
+
+func (db *fakeSQL) Open(string) (driver.Conn, error) { return db, nil }
+func (db *fakeSQL) Begin() (driver.Tx, error)        { return db, nil }
+func (db *fakeSQL) Commit() error                    { return nil }
+func (db *fakeSQL) Rollback() error                  { return nil }
+func (db *fakeSQL) Close() error                     { return nil }
+
+func (db *fakeSQL) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{db: db, query: query}, nil
+}
+
+type fakeSQLStmt struct {
+	db    *fakeSQL
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+
+	query := strings.Replace(s.query, "timestampvm.", "", 1)
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO blocks"):
+		s.db.blocks[args[0].(int64)] = args
+	case strings.HasPrefix(query, "DELETE FROM tags"):
+		delete(s.db.tags, args[0].(int64))
+	case strings.HasPrefix(query, "INSERT INTO tags"):
+		s.db.tags[args[0].(int64)]++
+	case strings.HasPrefix(query, "INSERT INTO cursor"):
+		cursor := args[1].(int64)
+		s.db.cursor = &cursor
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeSQLStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+
+	rows := &fakeSQLRows{}
+	if s.db.cursor != nil {
+		rows.values = []driver.Value{*s.db.cursor}
+	}
+	return rows, nil
+}
+
+type fakeSQLRows struct{ values []driver.Value }
+
+func (r *fakeSQLRows) Columns() []string { return []string{"next_height"} }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = nil
+	return nil
+}
+
+func TestPostgresExport(t *testing.T) {
+	assert := assert.New(t)
+	testSQL.reset()
+	dsnFile := filepath.Join(t.TempDir(), "postgres.dsn")
+	assert.NoError(os.WriteFile(dsnFile, []byte("postgres://localhost/test\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"postgresDSNFile": %q, "postgresDriver": "fakepostgres", "postgresInterval": "1h"}`,
+		dsnFile,
+	)))
+	assert.NoError(err)
+
+	// the accepted blocks are backfilled from genesis
+	assert.Eventually(func() bool {
+		return vm.postgresExporter.Status().NextHeight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+	for i := 0; i < 2; i++ {
+		data := hashing.ComputeHash256Array([]byte{byte(i)})
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: "docs", Tags: map[string]string{"n": fmt.Sprint(i)}}, &ProposeBlockReply{}))
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+	}
+
+	// the export resumes from the cursor, or from the backfilled height
+	vm.postgresExporter.Backfill(1)
+	assert.Eventually(func() bool {
+		return vm.postgresExporter.Status().NextHeight == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	lastAccepted, err := vm.LastAccepted()
+	assert.NoError(err)
+	testSQL.lock.Lock()
+	assert.Len(testSQL.blocks, 3)
+	row := testSQL.blocks[2]
+	assert.Equal(lastAccepted.String(), row[1])
+	assert.Equal("docs", row[5])
+	assert.Nil(row[6])
+	assert.Equal(false, row[8])
+	assert.Equal(map[int64]int{1: 1, 2: 1}, testSQL.tags)
+	assert.EqualValues(3, *testSQL.cursor)
+	testSQL.lock.Unlock()
+	assert.EqualValues(3, vm.postgresExporter.Status().Exported)
+	assert.NoError(vm.Shutdown())
+
+	_, err = ParseConfig([]byte(`{"postgresDSNFile": "dsn", "postgresSchema": "Bad-Schema"}`))
+	assert.ErrorIs(err, errBadPostgresSchema)
+}
+
+// TestPostgresDriver exports with the driver the plugin links, to the
+// database of TIMESTAMPVM_POSTGRES_DSN if it's set, or to no server
+func TestPostgresDriver(t *testing.T) {
+	assert := assert.New(t)
+	dsn := os.Getenv("TIMESTAMPVM_POSTGRES_DSN")
+	reachable := dsn != ""
+	if !reachable {
+		dsn = "postgres://timestampvm@127.0.0.1:1/timestampvm?sslmode=disable&connect_timeout=1"
+	}
+	dsnFile := filepath.Join(t.TempDir(), "postgres.dsn")
+	assert.NoError(os.WriteFile(dsnFile, []byte(dsn+"\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"postgresDSNFile": %q, "postgresSchema": "timestampvm_test", "postgresInterval": "1h"}`,
+		dsnFile,
+	)))
+	assert.NoError(err)
+	defer func() { assert.NoError(vm.Shutdown()) }()
+
+	if !reachable {
+		// the default driver is registered, so the export fails to connect
+		assert.Eventually(func() bool {
+			return vm.postgresExporter.Status().LastError != ""
+		}, 5*time.Second, 10*time.Millisecond)
+		lastError := vm.postgresExporter.Status().LastError
+		assert.NotContains(lastError, "unknown driver")
+		assert.Contains(lastError, "connect")
+		return
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	assert.NoError(err)
+	defer func() {
+		_, err := db.Exec(`DROP SCHEMA timestampvm_test CASCADE`)
+		assert.NoError(err)
+		assert.NoError(db.Close())
+	}()
+	assert.Eventually(func() bool {
+		return vm.postgresExporter.Status().NextHeight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+	data := hashing.ComputeHash256Array([]byte{1})
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: "docs", Tags: map[string]string{"k": "v"}}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	vm.postgresExporter.Backfill(1)
+	assert.Eventually(func() bool {
+		return vm.postgresExporter.Status().NextHeight == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	var id, namespace, value string
+	assert.NoError(db.QueryRow(
+		`SELECT b.id, b.namespace, t.value FROM timestampvm_test.blocks b JOIN timestampvm_test.tags t USING (height) WHERE t.key = $1`,
+		"k",
+	).Scan(&id, &namespace, &value))
+	assert.Equal(blk.ID().String(), id)
+	assert.Equal("docs", namespace)
+	assert.Equal("v", value)
+}
+
+func TestSQLiteMirror(t *testing.T) {
+	assert := assert.New(t)
+	testSQL.reset()
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"sqlitePath": %q, "sqliteDriver": "fakesqlite", "sqliteInterval": "1h"}`,
+		filepath.Join(t.TempDir(), "mirror.db"),
+	)))
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return vm.sqliteMirror.Status().NextHeight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+	data := hashing.ComputeHash256Array([]byte{1})
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Tags: map[string]string{"k": "v"}}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	// the tables aren't qualified with a schema
+	vm.sqliteMirror.Backfill(1)
+	assert.Eventually(func() bool {
+		return vm.sqliteMirror.Status().NextHeight == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	testSQL.lock.Lock()
+	assert.Len(testSQL.blocks, 2)
+	assert.Equal(blk.ID().String(), testSQL.blocks[1][1])
+	assert.Equal(map[int64]int{1: 1}, testSQL.tags)
+	assert.EqualValues(2, *testSQL.cursor)
+	testSQL.lock.Unlock()
+	assert.NoError(vm.Shutdown())
+
+	_, err = ParseConfig([]byte(`{"sqlitePath": "mirror.db", "sqliteBatchSize": 0}`))
+	assert.ErrorIs(err, errSQLiteBatchSize)
+	_, _, _, err = newTestVMWithConfig([]byte(`{"sqlitePath": "mirror.db", "sqliteDriver": "unregistered"}`))
+	assert.ErrorIs(err, errNoSQLiteDriver)
+}
+
+func TestSearchExport(t *testing.T) {
+	assert := assert.New(t)
+	var (
+		lock      sync.Mutex
+		mapped    bool
+		documents = map[string]searchDocument{}
+	)
+	cluster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal("ApiKey secret", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/blocks":
+			assert.Equal(http.MethodPut, r.Method)
+			mapped = true
+		case "/_bulk":
+			decoder := stdjson.NewDecoder(r.Body)
+			for decoder.More() {
+				action := map[string]map[string]string{}
+				doc := searchDocument{}
+				assert.NoError(decoder.Decode(&action))
+				assert.NoError(decoder.Decode(&doc))
+				assert.Equal("blocks", action["index"]["_index"])
+				documents[action["index"]["_id"]] = doc
+			}
+			_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cluster.Close()
+
+	authorizationFile := filepath.Join(t.TempDir(), "search.auth")
+	assert.NoError(os.WriteFile(authorizationFile, []byte("ApiKey secret\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"searchURL": %q, "searchAuthorizationFile": %q, "searchIndex": "blocks", "searchInterval": "1h"}`,
+		cluster.URL, authorizationFile,
+	)))
+	assert.NoError(err)
+
+	// the index is created and the accepted blocks are backfilled from
+	// genesis
+	assert.Eventually(func() bool {
+		return vm.searchExporter.Status().NextHeight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+	data := hashing.ComputeHash256Array([]byte("invoice"))
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: "docs", Tags: map[string]string{"doc.type": "invoice"}}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	// the export resumes from the persisted height
+	vm.searchExporter.Backfill(1)
+	assert.Eventually(func() bool {
+		return vm.searchExporter.Status().NextHeight == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.True(mapped)
+	assert.Len(documents, 2)
+	doc := documents[blk.ID().String()]
+	lock.Unlock()
+	assert.Equal(encodedData, doc.Data)
+	assert.Equal(hex.EncodeToString(data[:]), doc.Hash)
+	assert.Equal([]string{"doc.type=invoice"}, doc.Tags)
+	assert.Equal("docs invoice", doc.Text)
+	vm.ctx.Lock.Lock()
+	cursor, err := vm.state.GetJobProgress(searchExportJobName)
+	vm.ctx.Lock.Unlock()
+	assert.NoError(err)
+	assert.Equal(database.PackUInt64(2), cursor)
+	assert.NoError(vm.Shutdown())
+
+	_, err = ParseConfig([]byte(`{"searchURL": "http://localhost:9200", "searchIndex": "Blocks"}`))
+	assert.ErrorIs(err, errBadSearchIndex)
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer listener.Close()
+	var (
+		lock      sync.Mutex
+		published = map[string]mqttEvent{}
+	)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		connect, ok := packet.(*packets.ConnectPacket)
+		if !ok {
+			return
+		}
+		assert.Equal(byte(mqttProtocolVersion), connect.ProtocolVersion)
+		assert.True(connect.CleanSession)
+		assert.Equal("sensor-feed", connect.Username)
+		assert.Equal([]byte("secret"), connect.Password)
+		if packets.NewControlPacket(packets.Connack).Write(conn) != nil {
+			return
+		}
+		for {
+			packet, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			switch packet := packet.(type) {
+			case *packets.PublishPacket:
+				// exactly once delivery
+				assert.Equal(byte(2), packet.Qos)
+				event := mqttEvent{}
+				assert.NoError(stdjson.Unmarshal(packet.Payload, &event))
+				lock.Lock()
+				published[packet.TopicName] = event
+				lock.Unlock()
+				pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				pubrec.MessageID = packet.MessageID
+				err = pubrec.Write(conn)
+			case *packets.PubrelPacket:
+				pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+				pubcomp.MessageID = packet.MessageID
+				err = pubcomp.Write(conn)
+			case *packets.PingreqPacket:
+				err = packets.NewControlPacket(packets.Pingresp).Write(conn)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	passwordFile := filepath.Join(t.TempDir(), "mqtt.password")
+	assert.NoError(os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"mqttBrokerURL": "tcp://%s", "mqttUsername": "sensor-feed", "mqttPasswordFile": %q, "mqttTopic": "sensors/{namespace}/{height}", "mqttQoS": 2, "mqttInterval": "1h"}`,
+		listener.Addr(), passwordFile,
+	)))
+	assert.NoError(err)
+
+	// genesis is published right away, accepted blocks once they're
+	// accepted
+	assert.Eventually(func() bool {
+		return vm.mqtt.Status().NextHeight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+	data := hashing.ComputeHash256Array([]byte{1})
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData, Namespace: "meters", Tags: map[string]string{"sensor": "42"}}, &ProposeBlockReply{}))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+	assert.Eventually(func() bool {
+		return vm.mqtt.Status().NextHeight == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	assert.Len(published, 2)
+	assert.Contains(published, "sensors//0")
+	event := published["sensors/meters/1"]
+	lock.Unlock()
+	assert.Equal(blk.ID().String(), event.ID)
+	assert.Equal(hex.EncodeToString(data[:]), event.Hash)
+	assert.Equal(map[string]string{"sensor": "42"}, event.Tags)
+	assert.NoError(vm.Shutdown())
+
+	_, err = ParseConfig([]byte(`{"mqttBrokerURL": "tcp://127.0.0.1:1883", "mqttTopic": "sensors/#"}`))
+	assert.ErrorIs(err, errBadMQTTTopic)
+	_, err = ParseConfig([]byte(`{"mqttBrokerURL": "http://127.0.0.1:1883"}`))
+	assert.ErrorIs(err, errBadMQTTScheme)
+	brokerURL, err := mqttBrokerAddress("mqtts://broker")
+	assert.NoError(err)
+	assert.Equal("mqtts://broker:8883", brokerURL)
+}
+
+func TestEmbedded(t *testing.T) {
+	assert := assert.New(t)
+	chain, err := NewEmbedded(EmbeddedConfig{ChainID: blockchainID, Genesis: []byte{1}})
+	assert.NoError(err)
+
+	// the stub engine accepts proposals right away
+	data := hashing.ComputeHash256Array([]byte{1})
+	encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+	assert.NoError(err)
+	assert.NoError(chain.Call(func(s *Service) error {
+		return s.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData}, &ProposeBlockReply{})
+	}))
+	assert.Eventually(func() bool {
+		reply := GetBlockReply{}
+		err := chain.Call(func(s *Service) error {
+			return s.GetBlockByData(nil, &GetBlockByDataArgs{Data: encodedData}, &reply)
+		})
+		return err == nil && reply.Height == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	handlers, err := chain.Handlers()
+	assert.NoError(err)
+	assert.Contains(handlers, "")
+	assert.NoError(chain.Shutdown())
+	assert.ErrorIs(chain.Call(func(*Service) error { return nil }), errEmbeddedShutdown)
+}
+
+// committedState is a State counting its commits
+type committedState struct {
+	State
+
+	lock    sync.Mutex
+	commits int
+}
+
+func (s *committedState) Commit() error {
+	s.lock.Lock()
+	s.commits++
+	s.lock.Unlock()
+	return s.State.Commit()
+}
+
+func TestFactoryNewState(t *testing.T) {
+	assert := assert.New(t)
+	wrapped := &committedState{}
+	chain, err := NewEmbedded(EmbeddedConfig{
+		Genesis: []byte{1},
+		Config:  []byte(`{"databaseBackend": "memdb"}`),
+		Factory: &Factory{NewState: func(db database.Database, vm *VM) (State, error) {
+			state, err := NewState(db, vm)
+			wrapped.State = state
+			return wrapped, err
+		}},
+	})
+	assert.NoError(err)
+	wrapped.lock.Lock()
+	genesisCommits := wrapped.commits
+	wrapped.lock.Unlock()
+	assert.NotZero(genesisCommits)
+	assert.NoError(chain.Call(func(s *Service) error {
+		assert.Same(wrapped, s.vm.state)
+		data := hashing.ComputeHash256Array([]byte{1})
+		encodedData, err := formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		return s.ProposeBlock(nil, &ProposeBlockArgs{Data: encodedData}, &ProposeBlockReply{})
+	}))
+
+	// the genesis and the accepted block are committed through the wrapper
+	assert.Eventually(func() bool {
+		wrapped.lock.Lock()
+		defer wrapped.lock.Unlock()
+		return wrapped.commits > genesisCommits
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(chain.Shutdown())
+}
+
+func TestRegister(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("tGas3T58KzdjLHhBDMnH2TvrddhqTji5iZAMZ3RXs2NLpSnhH", ID.String(), "the ID is the name of the plugin binary")
+
+	manager := vms.NewManager()
+	verifier := BlockVerifierFunc(func(*Block) error { return nil })
+	assert.NoError(Register(manager, &Factory{Verifiers: []BlockVerifier{verifier}}))
+	factory, err := manager.GetFactory(ID)
+	assert.NoError(err)
+	vmIntf, err := factory.New(snow.DefaultContextTest())
+	assert.NoError(err)
+	vm, ok := vmIntf.(*VM)
+	assert.True(ok)
+	assert.Len(vm.verifiers, 1)
+
+	// registering twice fails, as for any other VM
+	assert.Error(Register(manager, nil))
+	assert.NoError(Register(vms.NewManager(), nil))
+}
+
+func TestBlockCompression(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.Shutdown())
+
+	for algorithm, marker := range map[string]byte{
+		SnappyCompression: snappyMarker,
+		GzipCompression:   gzipMarker,
+		NoCompression:     uncompressedMarker,
+	} {
+		// blocks stored with another algorithm remain readable
+		vm, _, _, err = newTestVMWithDB(dbManager, []byte(fmt.Sprintf(`{"blockCompression": %q}`, algorithm)))
+		assert.NoError(err)
+		readBlk, err := vm.state.GetBlock(blk.ID())
+		assert.NoError(err)
+		assert.Equal(blk.Bytes(), readBlk.Bytes())
+
+		// and are migrated by recompressing them
+		assert.NoError(vm.recompressor.Run())
+		assert.Equal(JobStatus{Processed: 2, Total: 2}, vm.recompressor.Status())
+		blkState := vm.state.(*state).BlockState.(*blockState)
+		blkID := blk.ID()
+		storedBytes, err := blkState.blockDB.Get(blkID[:])
+		assert.NoError(err)
+		assert.Equal(marker, storedBytes[0])
+
+		blkState.blkCache.Flush()
+		readBlk, err = vm.state.GetBlock(blk.ID())
+		assert.NoError(err)
+		assert.Equal(blk.Bytes(), readBlk.Bytes())
+		assert.NoError(vm.Shutdown())
+	}
+
+	_, _, _, err = newTestVMWithConfig([]byte(`{"blockCompression": "lz4"}`))
+	assert.ErrorIs(err, errUnknownCompression)
+}
+
+func TestAcceptedLog(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	acceptedIDs := []ids.ID{genesisID}
+	for i := byte(1); i <= 2; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		acceptedIDs = append(acceptedIDs, blk.ID())
+	}
+
+	loggedIDs, err := vm.state.GetAcceptedIDs(context.Background(), 0, 10)
+	assert.NoError(err)
+	assert.Equal(acceptedIDs, loggedIDs)
+
+	loggedIDs, err = vm.state.GetAcceptedIDs(context.Background(), 1, 1)
+	assert.NoError(err)
+	assert.Equal(acceptedIDs[1:2], loggedIDs)
+
+	// the chain can be walked forward
+	for i := 0; i < len(acceptedIDs)-1; i++ {
+		childID, err := vm.state.GetAcceptedChild(acceptedIDs[i])
+		assert.NoError(err)
+		assert.Equal(acceptedIDs[i+1], childID)
+	}
+	_, err = vm.state.GetAcceptedChild(acceptedIDs[len(acceptedIDs)-1])
+	assert.ErrorIs(err, database.ErrNotFound)
+
+	// the log can't have gaps
+	assert.ErrorIs(vm.state.AppendAccepted(4, ids.GenerateTestID()), errAcceptedLogGap)
+}
+
+func TestChildIndex(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	newBlock := func(parentID ids.ID, height uint64, data byte) *Block {
+		blk, err := vm.newBlock(parentID, height, &submission{data: [dataLen]byte{data}}, time.Now())
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		return blk
+	}
+	childOf := func(parentID ids.ID) (ids.ID, error) {
+		return vm.state.GetAcceptedChild(parentID)
+	}
+
+	// processing blocks aren't children yet
+	accepted := newBlock(genesisID, 1, 1)
+	rejected := newBlock(genesisID, 1, 2)
+	_, err = childOf(genesisID)
+	assert.ErrorIs(err, database.ErrNotFound)
+
+	// the accepted sibling is the child, whichever is decided first
+	assert.NoError(rejected.Reject())
+	_, err = childOf(genesisID)
+	assert.ErrorIs(err, database.ErrNotFound)
+	assert.NoError(accepted.Accept())
+	childID, err := childOf(genesisID)
+	assert.NoError(err)
+	assert.Equal(accepted.ID(), childID)
+
+	next := newBlock(accepted.ID(), 2, 3)
+	abandoned := newBlock(accepted.ID(), 2, 4)
+	assert.NoError(next.Accept())
+	assert.NoError(abandoned.Reject())
+	childID, err = childOf(accepted.ID())
+	assert.NoError(err)
+	assert.Equal(next.ID(), childID)
+
+	// rejected blocks and the last accepted block have no child
+	for _, blkID := range []ids.ID{rejected.ID(), abandoned.ID(), next.ID()} {
+		_, err = childOf(blkID)
+		assert.ErrorIs(err, database.ErrNotFound)
+	}
+	assert.NoError(vm.integrity.Verify())
+}
+
+func TestAcceptedLogUpgrade(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	blkIDs := []ids.ID{genesisID}
+	for i := byte(1); i <= 3; i++ {
+		assert.NoError(vm.SetPreference(blkIDs[len(blkIDs)-1]))
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		blkIDs = append(blkIDs, blk.ID())
+	}
+	assert.NoError(vm.Shutdown())
+
+	// drop the accepted log and child links, as on a chain created before
+	// they were introduced
+	db := dbManager.Current().Database
+	for _, prefix := range [][]byte{acceptedLogPrefix, childIndexPrefix} {
+		prefixDB := prefixdb.New(prefix, db)
+		it := prefixDB.NewIterator()
+		for it.Next() {
+			assert.NoError(prefixDB.Delete(it.Key()))
+		}
+		assert.NoError(it.Error())
+		it.Release()
+	}
+
+	assertRebuilt := func(vm *VM) {
+		for height, blkID := range blkIDs {
+			loggedID, err := vm.state.GetAcceptedID(uint64(height))
+			assert.NoError(err)
+			assert.Equal(blkID, loggedID)
+			if height > 0 {
+				childID, err := vm.state.GetAcceptedChild(blkIDs[height-1])
+				assert.NoError(err)
+				assert.Equal(blkID, childID)
+			}
+		}
+	}
+	vm, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	assertRebuilt(vm)
+	assert.NoError(vm.Shutdown())
+
+	// a rebuild interrupted after logging the tip resumes below it
+	acceptedLogDB := prefixdb.New(acceptedLogPrefix, db)
+	for height := range blkIDs[:3] {
+		assert.NoError(acceptedLogDB.Delete(database.PackUInt64(uint64(height))))
+	}
+	progressBytes, err := Codec.Marshal(CodecVersion, &reindexProgress{
+		Phase:      reindexAcceptedLog,
+		NextID:     blkIDs[2],
+		NextHeight: 2,
+		TipHeight:  3,
+	})
+	assert.NoError(err)
+	assert.NoError(prefixdb.New(jobProgressPrefix, db).Put([]byte(reindexJobName), progressBytes))
+
+	vm, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	assertRebuilt(vm)
+
+	// and indexes the lookups in the background
+	assert.Eventually(func() bool {
+		return !vm.reindexer.Status().Running
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = vm.state.GetJobProgress(reindexJobName)
+	assert.ErrorIs(err, database.ErrNotFound)
+	assert.NoError(vm.integrity.Verify())
+	assert.NoError(vm.Shutdown())
+}
+
+func TestCompaction(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"compactionInterval":"1h"}`))
+	assert.NoError(err)
+
+	assert.NoError(vm.compactor.Trigger())
+	for vm.compactor.Status().Running {
+		time.Sleep(time.Millisecond)
+	}
+	status := vm.compactor.Status()
+	assert.Empty(status.LastError)
+	assert.False(status.LastStart.IsZero())
+
+	stats := "Compactions\n Level |   Tables   |    Size(MB)   |\n-------+------------+---------------+\n   0   |          1 |       0.50000 |\n Total |          1 |       2.00000 |\n"
+	size, ok := parseLevelDBSize(stats)
+	assert.True(ok)
+	assert.EqualValues(2*1024*1024, size)
+
+	_, ok = parseLevelDBSize("")
+	assert.False(ok)
+
+	_, err = ParseConfig([]byte(`{"compactionInterval":"-1s"}`))
+	assert.ErrorIs(err, errNonPositiveInterval)
+}
+
+func TestDatabaseStats(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	for i := byte(1); i <= 3; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+	}
+	assert.NoError(vm.dbStats.job.Run())
+	stats, err := vm.dbStats.Stats()
+	assert.NoError(err)
+	assert.False(stats.Running)
+	assert.EqualValues(len(statsPrefixes), stats.Processed)
+	assert.EqualValues(4, stats.Prefixes["header"].Keys)
+	assert.EqualValues(4, stats.Prefixes["accepted"].Keys)
+	assert.NotZero(stats.Prefixes["block"].Bytes)
+	assert.Contains(stats.Caches, "block_cache")
+}
+
+func TestIntegrityCheck(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, []byte(`{"pruningEnabled": true, "pruningRetainBlocks": 2}`))
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	for i := byte(1); i <= 4; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+	}
+
+	// pruned blocks aren't reported
+	assert.NoError(vm.pruner.prune())
+	assert.NoError(vm.integrity.Verify())
+	assert.Zero(vm.integrity.Status().IssueCount)
+
+	// replace an accepted block
+	assert.NoError(vm.state.AppendAccepted(3, ids.GenerateTestID()))
+	assert.NoError(vm.state.Commit())
+	assert.ErrorIs(vm.integrity.Verify(), errCorruptState)
+	assert.NotZero(vm.integrity.Status().IssueCount)
+	assert.NoError(vm.Shutdown())
+
+	// the corruption prevents the VM from starting
+	_, _, _, err = newTestVMWithDB(dbManager, []byte(`{"verifyIntegrityOnStartup": true}`))
+	assert.ErrorIs(err, errCorruptState)
+}
+
+func TestSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	for i := byte(1); i <= 3; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+	}
+	lastAccepted, err := vm.LastAccepted()
+	assert.NoError(err)
+
+	path, err := vm.snapshotter.Trigger(t.TempDir())
+	assert.NoError(err)
+	for vm.snapshotter.Status().Running {
+		time.Sleep(time.Millisecond)
+	}
+	status := vm.snapshotter.Status()
+	assert.Empty(status.LastError)
+	assert.Equal(lastAccepted, status.Snapshot.LastAccepted)
+	assert.NotZero(status.Snapshot.Records)
+
+	// a streamed snapshot holds the same state
+	recorder := httptest.NewRecorder()
+	vm.serveSnapshot(recorder, nil)
+	assert.Equal(http.StatusOK, recorder.Code)
+	info, err := RestoreSnapshot(memdb.New(), recorder.Body)
+	assert.NoError(err)
+	assert.Equal(status.Snapshot.Records, info.Records)
+
+	// a new node starts from the snapshot
+	restoredVM, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"restoreSnapshot": %q}`, path)))
+	assert.NoError(err)
+	restoredLastAccepted, err := restoredVM.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(lastAccepted, restoredLastAccepted)
+	assert.NoError(restoredVM.integrity.Verify())
+
+	// corrupt snapshots aren't restored
+	_, err = RestoreSnapshot(memdb.New(), bytes.NewReader(recorder.Body.Bytes()[:1024]))
+	assert.Error(err)
+}
+
+func TestBackups(t *testing.T) {
+	assert := assert.New(t)
+	backupDir := t.TempDir()
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"backupSchedule": "0 3 * * *", "backupRetain": 2, "backupDir": %q}`, backupDir)))
+	assert.NoError(err)
+	service := &AdminService{vm: vm}
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	for i := byte(1); i <= 3; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+
+		assert.NoError(service.CreateBackup(nil, nil, &api.SuccessResponse{}))
+		for running := true; running; time.Sleep(time.Millisecond) {
+			status, err := vm.backups.Status()
+			assert.NoError(err)
+			running = status.Running
+		}
+	}
+
+	// only the most recent backups are kept
+	status := BackupStatus{}
+	assert.NoError(service.GetBackupStatus(nil, nil, &status))
+	assert.Empty(status.LastError)
+	assert.Equal("0 3 * * *", status.Schedule)
+	assert.Equal(json.Uint64(3), status.Snapshot.Height)
+	assert.NotZero(status.Snapshot.Records)
+	assert.Len(status.Backups, 2)
+	assert.Equal(status.Name, status.Backups[1])
+	files, err := os.ReadDir(backupDir)
+	assert.NoError(err)
+	assert.Len(files, 2)
+
+	// a new node starts from the latest backup
+	restoredVM, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"restoreSnapshot": %q}`, filepath.Join(backupDir, status.Name))))
+	assert.NoError(err)
+	restoredLastAccepted, err := restoredVM.LastAccepted()
+	assert.NoError(err)
+	lastAccepted, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(lastAccepted, restoredLastAccepted)
+
+	schedule, err := parseSchedule("30 */6 * * 1-5")
+	assert.NoError(err)
+	friday := time.Date(2022, time.July, 1, 19, 0, 0, 0, time.UTC)
+	assert.Equal(time.Date(2022, time.July, 4, 0, 30, 0, 0, time.UTC), schedule.Next(friday))
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 31 2 *", "*/0 * * * *"} {
+		_, err := ParseConfig([]byte(fmt.Sprintf(`{"backupSchedule": %q}`, spec)))
+		assert.ErrorIs(err, errBadSchedule, spec)
+	}
+	_, err = ParseConfig([]byte(`{"backupSchedule": "0 3 * * *", "backupRetain": 0}`))
+	assert.ErrorIs(err, errBackupRetain)
+	_, _, _, err = newTestVMWithConfig([]byte(`{"backupSchedule": "0 3 * * *"}`))
+	assert.ErrorIs(err, errNoBackupStore)
+}
+
+func TestDiffDatabases(t *testing.T) {
+	assert := assert.New(t)
+	vmA, _, _, err := newTestVM()
+	assert.NoError(err)
+	// bodies stored with other compression don't diverge
+	vmB, _, _, err := newTestVMWithConfig([]byte(`{"blockCompression": "snappy"}`))
+	assert.NoError(err)
+
+	genesisID, err := vmA.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vmA.SetPreference(genesisID))
+	for i := byte(1); i <= 3; i++ {
+		vmA.proposeBlock([dataLen]byte{i})
+		blk, err := vmA.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vmA.SetPreference(blk.ID()))
+
+		parsed, err := vmB.ParseBlock(blk.Bytes())
+		assert.NoError(err)
+		assert.NoError(parsed.Verify())
+		assert.NoError(parsed.Accept())
+	}
+
+	diff, err := DiffDatabases(vmA.db, vmB.db, 10)
+	assert.NoError(err)
+	assert.True(diff.Equal())
+	assert.Equal(json.Uint64(3), diff.AcceptedHeightA)
+	assert.Equal(diff.LastAcceptedA, diff.LastAcceptedB)
+
+	// a database lagging behind diverges without conflicting
+	vmA.proposeBlock([dataLen]byte{4})
+	blk, err := vmA.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	diff, err = DiffDatabases(vmA.db, vmB.db, 10)
+	assert.NoError(err)
+	assert.False(diff.Equal())
+	assert.Equal(json.Uint64(4), diff.AcceptedHeightA)
+	assert.Equal(json.Uint64(3), diff.AcceptedHeightB)
+	assert.Nil(diff.FirstConflictingHeight)
+	assert.Equal(json.Uint64(1), diff.Prefixes["accepted"].OnlyInA)
+
+	// accepting another block at the same height conflicts
+	vmB.proposeBlock([dataLen]byte{5})
+	assert.NoError(vmB.SetPreference(diff.LastAcceptedB))
+	blk, err = vmB.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	diff, err = DiffDatabases(vmA.db, vmB.db, 1)
+	assert.NoError(err)
+	assert.Equal(json.Uint64(4), *diff.FirstConflictingHeight)
+	assert.Equal(json.Uint64(1), diff.Prefixes["accepted"].Different)
+	assert.NotEqual(diff.LastAcceptedA, diff.LastAcceptedB)
+	assert.Len(diff.Divergences, 1)
+}
+
+func TestArchive(t *testing.T) {
+	assert := assert.New(t)
+	archiveDir := t.TempDir()
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"archiveEnabled": true, "archiveRetainBlocks": 2, "archiveDir": %q}`, archiveDir)))
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	acceptedIDs := []ids.ID{genesisID}
+	for i := byte(1); i <= 5; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		acceptedIDs = append(acceptedIDs, blk.ID())
+	}
+
+	assert.NoError(vm.archiver.archive())
+	status := vm.archiver.Status()
+	assert.Equal(json.Uint64(4), status.ArchivedHeight)
+	assert.Equal(json.Uint64(4), status.TargetHeight)
+
+	// archived blocks are only held by the archive, but still readable
+	for height, blkID := range acceptedIDs {
+		_, err := vm.state.GetBlockBody(blkID)
+		if height == 0 || height >= 4 {
+			assert.NoError(err)
+		} else {
+			assert.ErrorIs(err, database.ErrNotFound)
+		}
+
+		blk, err := vm.getBlock(blkID)
+		assert.NoError(err)
+		assert.Equal(uint64(height), blk.Height())
+		assert.Equal([dataLen]byte{byte(height)}, blk.Data())
+	}
+	assert.NoError(vm.integrity.Verify())
+
+	// archiving moves blocks which pruning would delete
+	_, err = ParseConfig([]byte(`{"archiveEnabled": true, "pruningEnabled": true}`))
+	assert.ErrorIs(err, errArchiveWithPruning)
+	_, _, _, err = newTestVMWithConfig([]byte(`{"archiveEnabled": true}`))
+	assert.ErrorIs(err, errNoArchiveStore)
+}
+
+func TestDatabaseBackend(t *testing.T) {
+	assert := assert.New(t)
+	configData := []byte(fmt.Sprintf(`{"databaseBackend": "leveldb", "databaseDir": %q, "databaseConfig": {"writeBuffer": 1048576}}`, t.TempDir()))
+	vm, _, _, err := newTestVMWithConfig(configData)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.Shutdown())
+
+	// the dedicated database outlives the node's
+	vm, _, _, err = newTestVMWithConfig(configData)
+	assert.NoError(err)
+	lastAccepted, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(blk.ID(), lastAccepted)
+	assert.NoError(vm.Shutdown())
+
+	_, err = ParseConfig([]byte(`{"databaseBackend": "leveldb"}`))
+	assert.ErrorIs(err, errNoDatabaseDir)
+	_, err = ParseConfig([]byte(`{"databaseBackend": "rocksdb"}`))
+	assert.ErrorIs(err, errUnknownDatabaseBackend)
+}
+
+func TestStartupConsistency(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	acceptedIDs := []ids.ID{genesisID}
+	for i := byte(1); i <= 3; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		acceptedIDs = append(acceptedIDs, blk.ID())
+	}
+
+	// the accepted log disagrees with the last accepted block
+	assert.NoError(vm.state.AppendAccepted(2, ids.GenerateTestID()))
+	assert.NoError(vm.state.AppendAccepted(3, ids.GenerateTestID()))
+	assert.NoError(vm.state.Commit())
+	assert.NoError(vm.Shutdown())
+
+	_, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.ErrorIs(err, errInconsistentState)
+
+	vm, _, _, err = newTestVMWithDB(dbManager, []byte(`{"repairOnStartup": true}`))
+	assert.NoError(err)
+	loggedIDs, err := vm.state.GetAcceptedIDs(context.Background(), 0, 10)
+	assert.NoError(err)
+	assert.Equal(acceptedIDs, loggedIDs)
+	assert.NoError(vm.integrity.Verify())
+	assert.NoError(vm.Shutdown())
+
+	// the last accepted block is missing
+	vm, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	assert.NoError(vm.state.DeleteBlock(acceptedIDs[3]))
+	assert.NoError(vm.state.Commit())
+	assert.NoError(vm.Shutdown())
+
+	_, _, _, err = newTestVMWithDB(dbManager, []byte(`{"repairOnStartup": true}`))
+	assert.ErrorIs(err, errInconsistentState)
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+
+	acceptedIDs := []ids.ID{genesisID}
+	for i := byte(1); i <= 3; i++ {
+		vm.proposeBlock([dataLen]byte{i})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		acceptedIDs = append(acceptedIDs, blk.ID())
+	}
+
+	// corrupt the accepted log, the child links and the data index
+	dataHash := DataHash([dataLen]byte{2})
+	assert.NoError(vm.state.RepairAccepted(1, ids.GenerateTestID()))
+	assert.NoError(vm.state.PutAcceptedChild(acceptedIDs[1], ids.GenerateTestID()))
+	assert.NoError(vm.state.PutDataEntry(dataHash, &DataEntry{BlkID: ids.GenerateTestID(), Height: 1}))
+	assert.NoError(vm.state.Commit())
+	assert.ErrorIs(vm.integrity.Verify(), errCorruptState)
+
+	assert.NoError(vm.reindexer.Run())
+	status := vm.reindexer.Status()
+	assert.Empty(status.LastError)
+	assert.Equal(status.Total, status.Processed)
+	assert.EqualValues(8, status.Total)
+	assert.NoError(vm.integrity.Verify())
+
+	loggedIDs, err := vm.state.GetAcceptedIDs(context.Background(), 0, 10)
+	assert.NoError(err)
+	assert.Equal(acceptedIDs, loggedIDs)
+	entry, err := vm.state.GetDataEntry(dataHash)
+	assert.NoError(err)
+	assert.Equal(&DataEntry{BlkID: acceptedIDs[2], Height: 2}, entry)
+
+	// the rebuild is complete, so there's nothing to resume
+	_, err = vm.state.GetJobProgress(reindexJobName)
+	assert.ErrorIs(err, database.ErrNotFound)
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	assert := assert.New(t)
+	keyDir := t.TempDir()
+	keyFile := filepath.Join(keyDir, "key")
+	wrongKeyFile := filepath.Join(keyDir, "wrong")
+	assert.NoError(os.WriteFile(keyFile, []byte("secret\n"), 0o600))
+	assert.NoError(os.WriteFile(wrongKeyFile, []byte("guess"), 0o600))
+	configData := []byte(fmt.Sprintf(`{"encryptionKeyFile": %q}`, keyFile))
+
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	data := [dataLen]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	vm.proposeBlock(data)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.Shutdown())
+
+	// the data isn't stored in plain text
+	it := dbManager.Current().Database.NewIterator()
+	for it.Next() {
+		assert.False(bytes.Contains(it.Value(), data[:]))
+	}
+	assert.NoError(it.Error())
+	it.Release()
+
+	_, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.ErrorIs(err, errEncryptedDatabase)
+	_, _, _, err = newTestVMWithDB(dbManager, []byte(fmt.Sprintf(`{"encryptionKeyFile": %q}`, wrongKeyFile)))
+	assert.ErrorIs(err, errWrongEncryptionKey)
+
+	vm, _, _, err = newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+	lastAccepted, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(blk.ID(), lastAccepted)
+	assert.NoError(vm.Shutdown())
+
+	// existing plain text state can't be encrypted
+	dbManager = manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	assert.NoError(vm.Shutdown())
+	_, _, _, err = newTestVMWithDB(dbManager, configData)
+	assert.ErrorIs(err, errUnencryptedDatabase)
+}
+
+var errTestIndexFailure = errors.New("test index failure")
+
+// failingDataIndexState fails to index data, as if the node crashed part way
+// through accepting a block
+type failingDataIndexState struct{ State }
+
+func (failingDataIndexState) IndexData(*Block) error { return errTestIndexFailure }
+
+func TestAtomicAccept(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+
+	state := vm.state
+	vm.state = failingDataIndexState{State: state}
+	assert.ErrorIs(blk.Accept(), errTestIndexFailure)
+	vm.state = state
+
+	// none of the writes staged before the failure are visible or committed
+	// with later changes
+	assert.Equal(choices.Processing, blk.Status())
+	lastAccepted, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(genesisID, lastAccepted)
+	_, err = vm.state.GetAcceptedID(1)
+	assert.ErrorIs(err, database.ErrNotFound)
+	_, err = vm.state.GetBlock(blk.ID())
+	assert.ErrorIs(err, database.ErrNotFound)
+	assert.NoError(vm.state.Commit())
+	assert.NoError(vm.Shutdown())
+
+	// a restarted node finds the state before the block was accepted
+	vm, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	lastAccepted, err = vm.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(genesisID, lastAccepted)
+	assert.NoError(vm.integrity.Verify())
+
+	// and can accept the block
+	blk, err = vm.ParseBlock(blk.Bytes())
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.integrity.Verify())
+}
+
+func TestDataFilter(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	configData := []byte(`{"dataFilterCapacity": 1024}`)
+	vm, _, _, err := newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	data := [dataLen]byte{1}
+	vm.proposeBlock(data)
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+
+	filter := vm.state.(*state).DataIndex.(*dataIndex).filter
+	assert.True(filter.MayContain(DataHash(data)))
+	assert.False(filter.MayContain(DataHash([dataLen]byte{2})))
+	_, err = vm.state.GetDataEntry(DataHash([dataLen]byte{2}))
+	assert.ErrorIs(err, database.ErrNotFound)
+	assert.NoError(vm.Shutdown())
+
+	// the filter is reloaded on restart
+	vm, _, _, err = newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+	entry, err := vm.state.GetDataEntry(DataHash(data))
+	assert.NoError(err)
+	assert.Equal(blk.ID(), entry.BlkID)
+	assert.NoError(vm.Shutdown())
+
+	// and rebuilt from the index if it's sized differently
+	vm, _, _, err = newTestVMWithDB(dbManager, []byte(`{"dataFilterCapacity": 4096}`))
+	assert.NoError(err)
+	filter = vm.state.(*state).DataIndex.(*dataIndex).filter
+	assert.True(filter.MayContain(DataHash(data)))
+	entry, err = vm.state.GetDataEntry(DataHash(data))
+	assert.NoError(err)
+	assert.Equal(blk.ID(), entry.BlkID)
+
+	_, err = ParseConfig([]byte(`{"dataFilterFalsePositiveRate": 1}`))
+	assert.ErrorIs(err, errBadFalsePositiveRate)
+}
+
+func TestGroupCommits(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, []byte(`{"commitBatchSize": 3, "commitInterval": "1h"}`))
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	accept := func(data byte) ids.ID {
+		lastAccepted, err := vm.LastAccepted()
+		assert.NoError(err)
+		assert.NoError(vm.SetPreference(lastAccepted))
+		vm.proposeBlock([dataLen]byte{data})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		return blk.ID()
+	}
+	// the database holds the state as of the last commit
+	committedLastAccepted := func() ids.ID {
+		state, err := NewState(dbManager.Current().Database, &VM{
+			config:   vm.config,
+			registry: prometheus.NewRegistry(),
+		})
+		assert.NoError(err)
+		lastAccepted, err := state.GetLastAccepted()
+		assert.NoError(err)
+		return lastAccepted
+	}
+
+	accept(1)
+	accept(2)
+	assert.Equal(genesisID, committedLastAccepted())
+	blkID := accept(3)
+	assert.Equal(blkID, committedLastAccepted())
+
+	// pending blocks are committed on shutdown
+	blkID = accept(4)
+	assert.NoError(vm.Shutdown())
+	assert.Equal(blkID, committedLastAccepted())
+
+	// and after the commit interval
+	vm, _, _, err = newTestVMWithDB(dbManager, []byte(`{"commitBatchSize": 100, "commitInterval": "10ms"}`))
+	assert.NoError(err)
+	blkID = accept(5)
+	assert.Eventually(func() bool {
+		vm.ctx.Lock.Lock()
+		defer vm.ctx.Lock.Unlock()
+		return vm.committer.pending == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(blkID, committedLastAccepted())
+
+	_, err = ParseConfig([]byte(`{"commitBatchSize": 0}`))
+	assert.ErrorIs(err, errCommitBatchSize)
+}
+
+func TestGroupCommitAbort(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	config := []byte(`{"commitBatchSize": 3, "commitInterval": "1h"}`)
+	vm, _, _, err := newTestVMWithDB(dbManager, config)
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	build := func(data byte) *Block {
+		lastAccepted, err := vm.LastAccepted()
+		assert.NoError(err)
+		assert.NoError(vm.SetPreference(lastAccepted))
+		vm.proposeBlock([dataLen]byte{data})
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		return blk.(*Block)
+	}
+	first := build(1)
+	assert.NoError(first.Accept())
+	second := build(2)
+
+	// a failed Accept discards the writes of the pending first block too, so
+	// the VM refuses to go on with a state it didn't store
+	state := vm.state
+	vm.state = failingDataIndexState{State: state}
+	err = second.Accept()
+	vm.state = state
+	assert.ErrorIs(err, errAcceptedWritesLost)
+	assert.Contains(err.Error(), errTestIndexFailure.Error())
+	assert.Equal(choices.Processing, second.Status())
+	assert.ErrorIs(second.Accept(), errAcceptedWritesLost)
+	assert.ErrorIs(vm.committer.Flush(), errAcceptedWritesLost)
+	assert.ErrorIs(vm.Shutdown(), errAcceptedWritesLost)
+
+	// a restarted node finds the state of the last commit, and accepts the
+	// blocks again
+	vm, _, _, err = newTestVMWithDB(dbManager, config)
+	assert.NoError(err)
+	lastAccepted, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(genesisID, lastAccepted)
+	assert.NoError(vm.integrity.Verify())
+	for _, blk := range []*Block{first, second} {
+		parsed, err := vm.ParseBlock(blk.Bytes())
+		assert.NoError(err)
+		assert.NoError(parsed.Verify())
+		assert.NoError(parsed.Accept())
+	}
+	assert.NoError(vm.Shutdown())
+	vm, _, _, err = newTestVMWithDB(dbManager, config)
+	assert.NoError(err)
+	lastAccepted, err = vm.LastAccepted()
+	assert.NoError(err)
+	assert.Equal(second.ID(), lastAccepted)
+	assert.NoError(vm.integrity.Verify())
+}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	vm.proposeBlock([dataLen]byte{2})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+
+	families, err := vm.registry.Gather()
+	assert.NoError(err)
+	values := map[string]float64{}
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		switch {
+		case metric.Gauge != nil:
+			values[family.GetName()] = metric.GetGauge().GetValue()
+		case metric.Counter != nil:
+			values[family.GetName()] = metric.GetCounter().GetValue()
+		case metric.Histogram != nil:
+			values[family.GetName()] = float64(metric.GetHistogram().GetSampleCount())
+		}
+	}
+	assert.Equal(1.0, values["mempool_size"])
+	assert.Equal(1.0, values["blocks_built"])
+	// the genesis block is accepted on initialization
+	assert.Equal(2.0, values["blocks_accepted"])
+	assert.Equal(1.0, values["block_build_duration_seconds"])
+	assert.Equal(1.0, values["block_verify_duration_seconds"])
+	assert.Contains(values, "db_get_count")
+	assert.Contains(values, "block_cache_hit")
+}
+
+func TestRPCMetrics(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(method string, params string) int {
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": %q, "params": %s}`, method, params)
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handlers[""].Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(http.StatusOK, call("timestampvm.getBlock", `{}`))
+	assert.Equal(http.StatusOK, call("timestampvm.getBlock", `{}`))
+	// errors are reported in the JSON-RPC response
+	assert.Equal(http.StatusOK, call("timestampvm.getBlockByData", `{"data": "invalid"}`))
+	// unknown methods aren't recorded
+	assert.Equal(http.StatusOK, call("timestampvm.unknown", `{}`))
+
+	families, err := vm.registry.Gather()
+	assert.NoError(err)
+	requests := map[string]float64{}
+	observed := map[string]uint64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			switch family.GetName() {
+			case "rpc_requests":
+				requests[labels["method"]+" "+labels["code"]] = metric.GetCounter().GetValue()
+			case "rpc_duration_seconds":
+				observed[labels["method"]] = metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	assert.Equal(map[string]float64{
+		"timestampvm.GetBlock 200":       2,
+		"timestampvm.GetBlockByData 400": 1,
+	}, requests)
+	assert.Equal(map[string]uint64{
+		"timestampvm.GetBlock":       2,
+		"timestampvm.GetBlockByData": 1,
+	}, observed)
+}
+
+func TestProfiling(t *testing.T) {
+	assert := assert.New(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{%s, "profilingEnabled": true, "profilingTokenFile": %q}`, adminConfig(t), tokenFile)))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	heap := handlers["/admin/pprof/heap"]
+	assert.NotNil(heap)
+	assert.EqualValues(common.NoLock, heap.LockOptions)
+	get := func(token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/admin/pprof/heap", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		heap.Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(http.StatusUnauthorized, get(""))
+	assert.Equal(http.StatusUnauthorized, get("wrong"))
+	assert.Equal(http.StatusOK, get("secret"))
+
+	_, err = ParseConfig([]byte(`{"profilingEnabled": true, "profilingTokenFile": "token"}`))
+	assert.ErrorIs(err, errProfilingWithoutAdmin)
+	_, err = ParseConfig([]byte(`{"adminAPIEnabled": true, "profilingEnabled": true}`))
+	assert.ErrorIs(err, errNoProfilingToken)
+}
+
+func TestAPIKeys(t *testing.T) {
+	assert := assert.New(t)
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	assert.NoError(os.WriteFile(keysFile, []byte("# submitters\nfile-key\n\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"apiKeys": ["config-key", {"key": "named-key", "name": "tenant"}], "apiKeysFile": %q}`,
+		keysFile,
+	)))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(header string, value string) int {
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "%s.getBlock", "params": {}}`, Name)
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			request.Header.Set(header, value)
+		}
+		recorder := httptest.NewRecorder()
+		handlers[""].Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(http.StatusUnauthorized, call("", ""))
+	assert.Equal(http.StatusUnauthorized, call(apiKeyHeader, "wrong"))
+	assert.Equal(http.StatusUnauthorized, call(apiKeyHeader, "# submitters"))
+	assert.Equal(http.StatusOK, call(apiKeyHeader, "config-key"))
+	assert.Equal(http.StatusOK, call(apiKeyHeader, "named-key"))
+	assert.Equal(http.StatusOK, call("Authorization", "Bearer file-key"))
+
+	// keys are reported by their name
+	reply := GetAPIKeyUsageReply{}
+	assert.NoError((&AdminService{vm: vm}).GetAPIKeyUsage(nil, nil, &reply))
+	assert.Contains(reply.Keys, apiKeyName([]byte("config-key")))
+	assert.Contains(reply.Keys, "tenant")
+
+	_, err = ParseConfig([]byte(`{"apiKeys": [""]}`))
+	assert.ErrorIs(err, errEmptyAPIKey)
+	_, err = ParseConfig([]byte(`{"apiKeys": [{"name": "tenant"}]}`))
+	assert.ErrorIs(err, errEmptyAPIKey)
+	assert.NoError(os.WriteFile(keysFile, []byte("# no keys\n"), 0o600))
+	_, err = vm.CreateHandlers()
+	assert.ErrorIs(err, errNoAPIKeys)
+}
+
+func TestAccessControl(t *testing.T) {
+	assert := assert.New(t)
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	assert.NoError(os.WriteFile(keysFile, []byte("explorer reader\nsubmitter\noperator admin\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"adminAPIEnabled": true, "apiKeysFile": %q}`, keysFile)))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	// returns the status code and the error of the call, if any
+	call := func(path string, method string, key string) (int, string) {
+		data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+		assert.NoError(err)
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": %q, "params": {"data": %q}}`, method, data)
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			request.Header.Set(apiKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		handlers[path].Handler.ServeHTTP(recorder, request)
+		reply := struct {
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}{}
+		if recorder.Code != http.StatusOK && recorder.Code != http.StatusBadRequest {
+			return recorder.Code, ""
+		}
+		assert.NoError(stdjson.NewDecoder(recorder.Body).Decode(&reply))
+		if reply.Error == nil {
+			return recorder.Code, ""
+		}
+		return recorder.Code, reply.Error.Message
+	}
+
+	// readers may query but not propose
+	code, _ := call("", Name+".getChainInfo", "")
+	assert.Equal(http.StatusUnauthorized, code)
+	code, message := call("", Name+".getChainInfo", "explorer")
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(message)
+	_, message = call("", Name+".proposeBlock", "explorer")
+	assert.Contains(message, errForbidden.Error())
+
+	// proposers may propose but not call the admin API
+	_, message = call("", Name+".proposeBlock", "submitter")
+	assert.Empty(message)
+	code, _ = call("/admin", "admin.getProcessingBlocks", "submitter")
+	assert.Equal(http.StatusForbidden, code)
+	code, message = call("/admin", "admin.getProcessingBlocks", "operator")
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(message)
+
+	assert.NoError(os.WriteFile(keysFile, []byte("explorer root\n"), 0o600))
+	_, err = vm.CreateHandlers()
+	assert.ErrorIs(err, errUnknownRole)
+}
+
+func TestAPIKeyQuotas(t *testing.T) {
+	assert := assert.New(t)
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	assert.NoError(os.WriteFile(keysFile, []byte("alpha proposer tenant-a\nbeta\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"apiKeysFile": %q, "apiKeyQuotas": {"tenant-a": {"hourlyProposals": 1}}, "defaultAPIKeyQuota": {"dailyRequests": 2}}`,
+		keysFile,
+	)))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	// returns the error of the call, if any
+	call := func(method string, key string) string {
+		data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+		assert.NoError(err)
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": %q, "params": {"data": %q}}`, method, data)
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(apiKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		handlers[""].Handler.ServeHTTP(recorder, request)
+		reply := struct {
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}{}
+		assert.NoError(stdjson.NewDecoder(recorder.Body).Decode(&reply))
+		if reply.Error == nil {
+			return ""
+		}
+		return reply.Error.Message
+	}
+
+	assert.Empty(call(Name+".proposeBlock", "alpha"))
+	assert.Contains(call(Name+".proposeBlock", "alpha"), "hourly proposal quota of 1")
+	assert.Empty(call(Name+".getChainInfo", "alpha"))
+	// keys without a quota of their own get the default one
+	assert.Empty(call(Name+".getChainInfo", "beta"))
+	assert.Empty(call(Name+".getChainInfo", "beta"))
+	assert.Contains(call(Name+".getChainInfo", "beta"), errQuotaExceeded.Error())
+
+	reply := GetAPIKeyUsageReply{}
+	assert.NoError((&AdminService{vm: vm}).GetAPIKeyUsage(nil, nil, &reply))
+	usage := reply.Keys["tenant-a"]
+	assert.Equal(ProposerRole, usage.Role)
+	assert.Equal(json.Uint64(2), usage.Requests)
+	assert.Equal(json.Uint64(1), usage.Proposals)
+	assert.Equal(json.Uint64(1), usage.Rejected)
+	assert.Equal(uint64(1), usage.Quota.HourlyProposals)
+	usage = reply.Keys[apiKeyName([]byte("beta"))]
+	assert.Equal(json.Uint64(2), usage.DayRequests)
+	assert.Equal(json.Uint64(1), usage.Rejected)
+
+	// the quotas apply to the current hour and day
+	caller := &apiCaller{name: "tenant-a", role: ProposerRole}
+	assert.ErrorIs(vm.usage.Charge(caller, Name+".ProposeBlock", time.Now()), errQuotaExceeded)
+	assert.NoError(vm.usage.Charge(caller, Name+".ProposeBlock", time.Now().Add(time.Hour)))
+}
+
+func TestIPFilter(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{%s, "ipAllowlist": ["192.0.2.0/24", "2001:db8::1"], "ipDenylist": ["192.0.2.7"]}`,
+		adminConfig(t),
+	)))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(path string, remoteAddr string) int {
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "%s.getChainInfo", "params": {}}`, Name)
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handlers[path].Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(http.StatusOK, call("", "192.0.2.1:1234"))
+	assert.Equal(http.StatusOK, call("", "[2001:db8::1]:1234"))
+	assert.Equal(http.StatusForbidden, call("", "192.0.2.7:1234"))
+	assert.Equal(http.StatusForbidden, call("", "198.51.100.1:1234"))
+	assert.Equal(http.StatusForbidden, call("", "[2001:db8::2]:1234"))
+	// the filter applies to all APIs
+	assert.Equal(http.StatusForbidden, call("/admin", "198.51.100.1:1234"))
+
+	_, err = ParseConfig([]byte(`{"ipAllowlist": ["192.0.2.0/33"]}`))
+	assert.ErrorIs(err, errBadIPRange)
+	_, err = ParseConfig([]byte(`{"ipDenylist": ["localhost"]}`))
+	assert.ErrorIs(err, errBadIPRange)
+}
+
+func TestCORS(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(
+		`{"corsAllowedOrigins": ["https://dapp.example"], "corsAllowedHeaders": ["Content-Type", "X-API-Key"]}`,
+	))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(method string, origin string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "%s.getChainInfo", "params": {}}`, Name)
+		request := httptest.NewRequest(method, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		if origin != "" {
+			request.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		recorder := httptest.NewRecorder()
+		handlers[""].Handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	// requests not sent by browsers are passed on
+	response := call(http.MethodPost, "")
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Empty(response.Header().Get("Access-Control-Allow-Origin"))
+
+	response = call(http.MethodPost, "https://DApp.example")
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("https://DApp.example", response.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("Origin", response.Header().Get("Vary"))
+
+	response = call(http.MethodOptions, "https://dapp.example")
+	assert.Equal(http.StatusNoContent, response.Code)
+	assert.Equal("GET, POST", response.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal("Content-Type, X-API-Key", response.Header().Get("Access-Control-Allow-Headers"))
+
+	assert.Equal(http.StatusForbidden, call(http.MethodPost, "https://evil.example").Code)
+	assert.Equal(http.StatusForbidden, call(http.MethodOptions, "https://evil.example").Code)
+
+	_, err = ParseConfig([]byte(`{"corsAllowedOrigins": ["dapp.example"]}`))
+	assert.ErrorIs(err, errBadCORSOrigin)
+	_, err = ParseConfig([]byte(`{"corsAllowedOrigins": ["https://dapp.example/app"]}`))
+	assert.ErrorIs(err, errBadCORSOrigin)
+}
+
+func TestRequestLimits(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(
+		`{"maxRequestBodySize": 256, "requestReadTimeout": "50ms", "maxConcurrentRequests": 2}`,
+	))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(body io.Reader) int {
+		request := httptest.NewRequest(http.MethodPost, "/", body)
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handlers[""].Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "%s.getChainInfo", "params": {}}`, Name)
+	assert.Equal(http.StatusOK, call(bytes.NewBufferString(body)))
+	// too large, with and without a content length
+	largeBody := body + strings.Repeat(" ", 256)
+	assert.Equal(http.StatusRequestEntityTooLarge, call(bytes.NewBufferString(largeBody)))
+	assert.Equal(http.StatusRequestEntityTooLarge, call(io.MultiReader(strings.NewReader(largeBody))))
+
+	// the context lock isn't held while waiting for the body
+	slowBody, slowWriter := io.Pipe()
+	defer slowWriter.Close()
+	done := make(chan int)
+	go func() { done <- call(slowBody) }()
+	time.Sleep(10 * time.Millisecond)
+	vm.ctx.Lock.Lock()
+	vm.ctx.Lock.Unlock()
+	assert.Equal(http.StatusRequestTimeout, <-done)
+
+	// requests beyond the limit are refused while the others are served
+	vm.requestSlots <- struct{}{}
+	vm.requestSlots <- struct{}{}
+	assert.Equal(http.StatusServiceUnavailable, call(bytes.NewBufferString(body)))
+	<-vm.requestSlots
+	assert.Equal(http.StatusOK, call(bytes.NewBufferString(body)))
+	<-vm.requestSlots
+
+	_, err = ParseConfig([]byte(`{"maxRequestBodySize": 0}`))
+	assert.ErrorIs(err, errMaxRequestBodySize)
+	_, err = ParseConfig([]byte(`{"maxConcurrentRequests": -1}`))
+	assert.ErrorIs(err, errMaxConcurrentRequests)
+	_, err = ParseConfig([]byte(`{"requestReadTimeout": "0s"}`))
+	assert.ErrorIs(err, errNonPositiveInterval)
+}
+
+func TestBatchRequests(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"maxBatchSize": 3}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handlers[""].Handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	type response struct {
+		ID     int                 `json:"id"`
+		Result stdjson.RawMessage  `json:"result"`
+		Error  *stdjson.RawMessage `json:"error"`
+	}
+
+	// calls are answered in order, failed calls don't fail the batch and
+	// notifications aren't answered
+	recorder := call(fmt.Sprintf(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "%[1]s.getBlock", "params": {"id": "%[2]s"}},
+		{"jsonrpc": "2.0", "id": 2, "method": "%[1]s.noSuchMethod", "params": {}},
+		{"jsonrpc": "2.0", "method": "%[1]s.getChainInfo", "params": {}}
+	]`, Name, genesisID))
+	assert.Equal(http.StatusOK, recorder.Code)
+	responses := []response(nil)
+	assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &responses))
+	assert.Len(responses, 2)
+	assert.Equal(1, responses[0].ID)
+	assert.Nil(responses[0].Error)
+	reply := GetBlockReply{}
+	assert.NoError(stdjson.Unmarshal(responses[0].Result, &reply))
+	assert.Equal(genesisID, reply.ID)
+	assert.Equal(2, responses[1].ID)
+	assert.NotNil(responses[1].Error)
+
+	// single calls are served as before
+	recorder = call(fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "%s.getChainInfo", "params": {}}`, Name))
+	assert.Equal(http.StatusOK, recorder.Code)
+	single := response{}
+	assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &single))
+	assert.Nil(single.Error)
+
+	// empty, invalid and oversized batches are refused
+	for _, body := range []string{`[]`, `[{"jsonrpc": "2.0"`, `[{}, {}, {}, {}]`} {
+		recorder := call(body)
+		assert.Equal(http.StatusBadRequest, recorder.Code, body)
+		refused := response{}
+		assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &refused), body)
+		assert.NotNil(refused.Error, body)
+	}
+
+	_, err = ParseConfig([]byte(`{"maxBatchSize": -1}`))
+	assert.ErrorIs(err, errMaxBatchSize)
+}
+
+func TestOpenRPCDocument(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{%s}`, adminConfig(t))))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	discover := func(path string) *openRPCDocument {
+		body := `{"jsonrpc": "2.0", "id": 1, "method": "rpc.discover"}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer "+testAdminToken)
+		recorder := httptest.NewRecorder()
+		handlers[path].Handler.ServeHTTP(recorder, request)
+		assert.Equal(http.StatusOK, recorder.Code)
+		reply := struct {
+			Result *openRPCDocument `json:"result"`
+		}{}
+		assert.NoError(stdjson.Unmarshal(recorder.Body.Bytes(), &reply))
+		return reply.Result
+	}
+	findMethod := func(document *openRPCDocument, name string) *openRPCMethod {
+		for i := range document.Methods {
+			if document.Methods[i].Name == name {
+				return &document.Methods[i]
+			}
+		}
+		return nil
+	}
+
+	// every method of the service is described, with its arguments by name
+	document := discover("")
+	assert.Equal(openRPCVersion, document.OpenRPC)
+	assert.Equal(Name, document.Info.Title)
+	assert.Len(document.Methods, reflect.TypeOf(&Service{}).NumMethod())
+	getBlock := findMethod(document, Name+".getBlock")
+	assert.NotNil(getBlock)
+	assert.Equal("by-name", getBlock.ParamStructure)
+	assert.Equal([]openRPCContentDescriptor{{Name: "id", Schema: typeSchema{"type": "string"}}}, getBlock.Params)
+	assert.Equal(schemaRefPrefix+"GetBlockReply", getBlock.Result.Schema["$ref"])
+	properties := document.Components.Schemas["GetBlockReply"]["properties"].(map[string]interface{})
+	assert.Equal(map[string]interface{}{"type": "string"}, properties["height"])
+	assert.Nil(findMethod(document, "admin.pruneBlocks"))
+
+	// admin methods are described by the admin API
+	adminDocument := discover("/admin")
+	assert.NotNil(findMethod(adminDocument, "admin.pruneBlocks"))
+	assert.Nil(findMethod(adminDocument, Name+".getBlock"))
+
+	// recursive types refer to their own schema
+	type tree struct {
+		Children []tree `json:"children,omitempty"`
+		Hidden   string `json:"-"`
+	}
+	g := &schemaGenerator{names: map[reflect.Type]string{}, schemas: map[string]typeSchema{}}
+	assert.Equal(typeSchema{"$ref": schemaRefPrefix + "tree"}, g.schema(reflect.TypeOf(tree{})))
+	assert.Equal(typeSchema{
+		"type": "object",
+		"properties": map[string]typeSchema{
+			"children": {"type": "array", "items": typeSchema{"$ref": schemaRefPrefix + "tree"}},
+		},
+	}, g.schemas["tree"])
+}
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{%s, "rateLimitReadRPS": 0.001, "rateLimitReadBurst": 2, "rateLimitProposeRPS": 0.001, "rateLimitProposeBurst": 1}`,
+		adminConfig(t),
+	)))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	// returns the error of the call, if any
+	call := func(path string, method string) string {
+		data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+		assert.NoError(err)
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": %q, "params": {"data": %q}}`, method, data)
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer "+testAdminToken)
+		recorder := httptest.NewRecorder()
+		handlers[path].Handler.ServeHTTP(recorder, request)
+		reply := struct {
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}{}
+		assert.NoError(stdjson.NewDecoder(recorder.Body).Decode(&reply))
+		if reply.Error == nil {
+			return ""
+		}
+		return reply.Error.Message
+	}
+
+	// reads and proposals are limited separately
+	assert.Empty(call("", Name+".getChainInfo"))
+	assert.Empty(call("", Name+".getChainInfo"))
+	assert.Contains(call("", Name+".getChainInfo"), errRateLimited.Error())
+	assert.Empty(call("", Name+".proposeBlock"))
+	assert.Contains(call("", Name+".proposeBlock"), errRateLimited.Error())
+	// the admin API isn't limited
+	assert.Empty(call("/admin", "admin.getProcessingBlocks"))
+
+	// buckets refill over time
+	start := time.Now()
+	bucket := newTokenBucket(2, 1)
+	assert.True(bucket.Allow(start))
+	assert.False(bucket.Allow(start.Add(100 * time.Millisecond)))
+	assert.True(bucket.Allow(start.Add(600 * time.Millisecond)))
+
+	_, err = ParseConfig([]byte(`{"rateLimitReadRPS": 10, "rateLimitReadBurst": 0}`))
+	assert.ErrorIs(err, errRateLimitBurst)
+}
+
+func TestAdminOperations(t *testing.T) {
+	assert := assert.New(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(`{"adminAPIEnabled": true, "adminTokenFile": %q}`, tokenFile)))
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(token string) int {
+		body := `{"jsonrpc": "2.0", "id": 1, "method": "admin.getLogLevel", "params": {}}`
+		request := httptest.NewRequest(http.MethodPost, "/admin", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handlers["/admin"].Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(http.StatusUnauthorized, call(""))
+	assert.Equal(http.StatusOK, call("secret"))
+
+	log, err := logging.NewTestLog(logging.Config{})
+	assert.NoError(err)
+	defer log.Stop()
+	vm.ctx.Log = log
+	admin := &AdminService{vm: vm}
+	debug := logging.Debug
+	assert.NoError(admin.SetLogLevel(nil, &LogLevels{LogLevel: &debug}, &api.SuccessResponse{}))
+	levels := LogLevels{}
+	assert.NoError(admin.GetLogLevel(nil, &struct{}{}, &levels))
+	assert.Equal(logging.Debug, *levels.LogLevel)
+
+	service := &Service{vm: vm}
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+	assert.NoError(admin.LockMempool(nil, &LockMempoolArgs{Locked: true}, &api.SuccessResponse{}))
+	err = service.ProposeBlock(nil, &ProposeBlockArgs{Data: data}, &ProposeBlockReply{})
+	assert.ErrorIs(err, errMempoolLocked)
+
+	dump := DumpStateReply{}
+	assert.NoError(admin.DumpState(nil, &struct{}{}, &dump))
+	assert.True(dump.MempoolLocked)
+	assert.Zero(dump.MempoolSize)
+	assert.True(dump.Config.AdminAPIEnabled)
+
+	assert.NoError(admin.LockMempool(nil, &LockMempoolArgs{Locked: false}, &api.SuccessResponse{}))
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: data}, &ProposeBlockReply{}))
+	assert.NoError(admin.DumpState(nil, &struct{}{}, &dump))
+	assert.Equal(1, dump.MempoolSize)
+
+	// profiles are protected by the admin token by default
+	_, err = ParseConfig([]byte(fmt.Sprintf(`{"adminAPIEnabled": true, "profilingEnabled": true, "adminTokenFile": %q}`, tokenFile)))
+	assert.NoError(err)
+
+	// the admin API isn't served without a way to grant the admin role
+	_, err = ParseConfig([]byte(`{"adminAPIEnabled": true, "apiKeys": ["proposer"]}`))
+	assert.ErrorIs(err, errOpenAdminAPI)
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	assert.NoError(os.WriteFile(keysFile, []byte("reader-key reader\n"), 0o600))
+	vm, _, _, err = newTestVMWithConfig([]byte(fmt.Sprintf(`{"adminAPIEnabled": true, "apiKeysFile": %q}`, keysFile)))
+	assert.NoError(err)
+	_, err = vm.CreateHandlers()
+	assert.ErrorIs(err, errOpenAdminAPI)
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	admin := &AdminService{vm: vm}
+	service := &Service{vm: vm}
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+
+	assert.NoError(admin.SetMaintenanceMode(nil, &SetMaintenanceModeArgs{Enabled: true, Reason: "back at 10:00 UTC"}, &api.SuccessResponse{}))
+	err = service.ProposeBlock(nil, &ProposeBlockArgs{Data: data}, &ProposeBlockReply{})
+	assert.ErrorIs(err, errServicePaused)
+	assert.Contains(err.Error(), "back at 10:00 UTC")
+	// reads keep working
+	assert.NoError(service.GetBlock(nil, &GetBlockArgs{}, &GetBlockReply{}))
+	dump := DumpStateReply{}
+	assert.NoError(admin.DumpState(nil, &struct{}{}, &dump))
+	assert.True(dump.Maintenance)
+
+	assert.NoError(admin.SetMaintenanceMode(nil, &SetMaintenanceModeArgs{}, &api.SuccessResponse{}))
+	assert.NoError(service.ProposeBlock(nil, &ProposeBlockArgs{Data: data}, &ProposeBlockReply{}))
+}
+
+func TestGetProcessingBlocks(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	admin := &AdminService{vm: vm}
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(blk.ID()))
+	vm.proposeBlock([dataLen]byte{2})
+	child, err := vm.BuildBlock()
+	assert.NoError(err)
+
+	reply := GetProcessingBlocksReply{}
+	assert.NoError(admin.GetProcessingBlocks(nil, &struct{}{}, &reply))
+	assert.Equal(blk.ID(), reply.Preferred)
+	assert.Len(reply.Blocks, 2)
+	assert.Equal(blk.ID(), reply.Blocks[0].ID)
+	assert.Equal(child.ID(), reply.Blocks[1].ID)
+	assert.Equal(choices.Processing, reply.Blocks[1].Status)
+
+	assert.NoError(blk.Accept())
+	assert.NoError(admin.GetProcessingBlocks(nil, &struct{}{}, &reply))
+	assert.Len(reply.Blocks, 1)
+	assert.Equal(child.ID(), reply.Blocks[0].ID)
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	vm, _, _, err := newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	assert.NoError(vm.Shutdown())
+
+	vm, _, _, err = newTestVMWithDB(dbManager, []byte(`{"readOnly": true}`))
+	assert.NoError(err)
+	service := &Service{vm: vm}
+	reply := GetBlockReply{}
+	assert.NoError(service.GetBlock(nil, &GetBlockArgs{}, &reply))
+	assert.Equal(blk.ID(), reply.ID)
+
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+	assert.ErrorIs(service.ProposeBlock(nil, &ProposeBlockArgs{Data: data}, &ProposeBlockReply{}), errReadOnly)
+	_, err = vm.BuildBlock()
+	assert.ErrorIs(err, errReadOnly)
+	child, err := vm.newBlock(blk.ID(), blk.Height()+1, &submission{data: [dataLen]byte{2}}, time.Now())
+	assert.NoError(err)
+	assert.ErrorIs(child.Verify(), errReadOnly)
+	assert.NoError(vm.Shutdown())
+
+	// a pending rebuild of the indexes is left to a writable node
+	progressBytes, err := Codec.Marshal(CodecVersion, &reindexProgress{
+		Phase:     reindexLookups,
+		TipHeight: blk.Height(),
+	})
+	assert.NoError(err)
+	db := dbManager.Current().Database
+	assert.NoError(prefixdb.New(jobProgressPrefix, db).Put([]byte(reindexJobName), progressBytes))
+	vm, _, _, err = newTestVMWithDB(dbManager, []byte(`{"readOnly": true}`))
+	assert.NoError(err)
+	assert.False(vm.reindexer.Status().Running)
+	assert.Equal(blk.ID(), vm.preferred)
+	assert.NoError(vm.Shutdown())
+
+	_, _, _, err = newTestVMWithConfig([]byte(`{"readOnly": true}`))
+	assert.ErrorIs(err, errReadOnlyUninitialized)
+	_, err = ParseConfig([]byte(`{"readOnly": true, "pruningEnabled": true}`))
+	assert.ErrorIs(err, errReadOnlyConflict)
+}
+
+func TestPersistMempool(t *testing.T) {
+	assert := assert.New(t)
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	configData := []byte(`{"persistMempool": true, "shutdownTimeout": "10s"}`)
+	vm, _, _, err := newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+
+	vm.proposeBlock([dataLen]byte{1})
+	vm.proposeBlock([dataLen]byte{2})
+	tags := []Tag{{Key: "trip", Value: "42"}}
+	vm.proposeSubmission(&submission{data: [dataLen]byte{3}, namespace: "bookings", tags: tags})
+	assert.NoError(vm.Shutdown())
+	// shutting down again does nothing
+	assert.NoError(vm.Shutdown())
+	// proposals arriving while shutting down are refused
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+	service := &Service{vm: vm}
+	assert.ErrorIs(service.ProposeBlock(nil, &ProposeBlockArgs{Data: data}, &ProposeBlockReply{}), errMempoolLocked)
+
+	vm, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	assert.Equal(3, vm.mempool.Len())
+	sub, _ := vm.mempool.Pop()
+	assert.Equal([dataLen]byte{1}, sub.data)
+	vm.mempool.Pop()
+	sub, _ = vm.mempool.Pop()
+	assert.Equal("bookings", sub.namespace)
+	assert.Equal(tags, sub.tags)
+
+	// the mempool is only saved once, and dropped without persistMempool
+	assert.NoError(vm.Shutdown())
+	vm, _, _, err = newTestVMWithDB(dbManager, nil)
+	assert.NoError(err)
+	assert.Zero(vm.mempool.Len())
+	assert.NoError(vm.Shutdown())
+}
+
+func TestAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	dbManager := manager.NewMemDB(version.DefaultVersion1_0_0)
+	configData := []byte(fmt.Sprintf(`{%s, "auditLogFile": %q}`, adminConfig(t), auditFile))
+	vm, _, _, err := newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	call := func(path string, method string, params string) {
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": %q, "params": %s}`, method, params)
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer "+testAdminToken)
+		handlers[path].Handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+	call("", "timestampvm.proposeBlock", fmt.Sprintf(`{"data": %q}`, data))
+	content, err := formatting.EncodeWithChecksum(formatting.CB58, []byte("confidential contract"))
+	assert.NoError(err)
+	call("", "timestampvm.proposeSaltedContent", fmt.Sprintf(`{"content": %q, "namespace": "contracts"}`, content))
+	// reads aren't audited
+	call("", "timestampvm.getBlock", `{}`)
+	call("/admin", "admin.pruneBlocks", `{}`)
+	assert.NoError(vm.Shutdown())
+
+	auditBytes, err := os.ReadFile(auditFile)
+	assert.NoError(err)
+	_, count, err := VerifyAuditLog(bytes.NewReader(auditBytes))
+	assert.NoError(err)
+	assert.Equal(3, count)
+	lines := bytes.Split(bytes.TrimSpace(auditBytes), []byte("\n"))
+	proposed := AuditEntry{}
+	assert.NoError(stdjson.Unmarshal(lines[0], &proposed))
+	assert.Equal("timestampvm.ProposeBlock", proposed.Method)
+	assert.Contains(string(proposed.Params), data)
+	// salted content is audited without the content
+	salted := AuditEntry{}
+	assert.NoError(stdjson.Unmarshal(lines[1], &salted))
+	assert.Equal("timestampvm.ProposeSaltedContent", salted.Method)
+	assert.Contains(string(salted.Params), "contracts")
+	assert.NotContains(string(salted.Params), content)
+	assert.NotContains(string(salted.Params), "content")
+	pruned := AuditEntry{}
+	assert.NoError(stdjson.Unmarshal(lines[2], &pruned))
+	assert.Equal("admin.PruneBlocks", pruned.Method)
+	assert.Equal(errPruningDisabled.Error(), pruned.Error)
+	assert.Equal(salted.Hash, pruned.PrevHash)
+
+	// the log is continued after a restart
+	vm, _, _, err = newTestVMWithDB(dbManager, configData)
+	assert.NoError(err)
+	handlers, err = vm.CreateHandlers()
+	assert.NoError(err)
+	call("/admin", "admin.getPruningStatus", `{}`)
+	assert.NoError(vm.Shutdown())
+	file, err := os.Open(auditFile)
+	assert.NoError(err)
+	_, count, err = VerifyAuditLog(file)
+	assert.NoError(err)
+	assert.Equal(4, count)
+	assert.NoError(file.Close())
+
+	// a modified log is detected
+	assert.NoError(os.WriteFile(auditFile, bytes.Replace(auditBytes, []byte("PruneBlocks"), []byte("PruneBlockz"), 1), 0o600))
+	_, _, _, err = newTestVMWithDB(dbManager, configData)
+	assert.ErrorIs(err, errAuditLogTampered)
+}
+
+func TestHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"healthMaxAcceptDelay": "1m", "healthMaxMempoolSize": 1}`))
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+
+	status, err := vm.HealthCheck()
+	assert.NoError(err)
+	assert.Equal(genesisID, status.(*HealthStatus).LastAccepted)
+
+	// data which was just proposed didn't wait too long, even if the last
+	// accept is long ago
+	vm.lastAcceptTime = time.Now().Add(-time.Hour)
+	vm.proposeBlock([dataLen]byte{1})
+	_, err = vm.HealthCheck()
+	assert.NoError(err)
+
+	vm.mempool.nonEmptySince = time.Now().Add(-2 * time.Minute)
+	status, err = vm.HealthCheck()
+	assert.ErrorIs(err, errUnhealthy)
+	assert.Len(status.(*HealthStatus).Problems, 1)
+
+	vm.proposeBlock([dataLen]byte{2})
+	status, err = vm.HealthCheck()
+	assert.ErrorIs(err, errUnhealthy)
+	assert.Len(status.(*HealthStatus).Problems, 2)
+
+	// accepting a block restores liveness
+	assert.NoError(vm.SetPreference(genesisID))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+	status, err = vm.HealthCheck()
+	assert.NoError(err)
+	assert.Equal(blk.ID(), status.(*HealthStatus).LastAccepted)
+	assert.Equal(1, status.(*HealthStatus).MempoolSize)
+}
+
+// serveNTP answers NTP requests on [conn] with a clock [offset] ahead of the
+// local clock
+func serveNTP(conn net.PacketConn, offset time.Duration) {
+	request := make([]byte, ntpPacketLen)
+	for {
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+		now := toNTPTime(time.Now().Add(offset))
+		response := make([]byte, ntpPacketLen)
+		response[0] = 0x24 // version 4, server mode
+		response[1] = 1    // stratum
+		copy(response[24:], request[40:48])
+		binary.BigEndian.PutUint64(response[32:], now)
+		binary.BigEndian.PutUint64(response[40:], now)
+		_, _ = conn.WriteTo(response, addr)
+	}
+}
+
+func TestClockDrift(t *testing.T) {
+	assert := assert.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	go serveNTP(conn, 2*time.Second)
+
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"clockDriftServers": [%q], "clockDriftInterval": "1h", "healthMaxClockDrift": "1s"}`,
+		conn.LocalAddr(),
+	)))
+	assert.NoError(err)
+
+	vm.driftMonitor.check()
+	status, err := vm.HealthCheck()
+	assert.ErrorIs(err, errUnhealthy)
+	drift := status.(*HealthStatus).ClockDrift
+	assert.InDelta(2*time.Second, drift.Drift.Duration, float64(100*time.Millisecond))
+	assert.Empty(drift.LastError)
+
+	// the drift can't be vouched for once the time sources stop responding
+	assert.NoError(conn.Close())
+	vm.driftMonitor.check()
+	status, err = vm.HealthCheck()
+	assert.ErrorIs(err, errUnhealthy)
+	assert.NotEmpty(status.(*HealthStatus).ClockDrift.LastError)
+	assert.NoError(vm.Shutdown())
+
+	_, _, _, err = newTestVMWithConfig([]byte(`{"healthMaxClockDrift": "1s"}`))
+	assert.ErrorIs(err, errNoClockDriftRule)
+}
+
+func TestGetChainInfo(t *testing.T) {
+	assert := assert.New(t)
+	vm, ctx, _, err := newTestVM()
+	assert.NoError(err)
+	service := &Service{vm: vm}
+
+	reply := GetChainInfoReply{}
+	assert.NoError(service.GetChainInfo(nil, &struct{}{}, &reply))
+	assert.Equal(blockchainID, reply.ChainID)
+	assert.Equal(blockchainID.String(), reply.ChainAlias)
+	assert.Equal(Name, reply.VMName)
+	assert.Equal(Version.String(), reply.VMVersion)
+
+	assert.NoError(ctx.BCLookup.(ids.Aliaser).Alias(blockchainID, "timestamp"))
+	assert.NoError(service.GetChainInfo(nil, &struct{}{}, &reply))
+	assert.Equal("timestamp", reply.ChainAlias)
+}
+
+func TestAlerts(t *testing.T) {
+	assert := assert.New(t)
+	alerts := make(chan Alert, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alert := Alert{}
+		assert.NoError(stdjson.NewDecoder(r.Body).Decode(&alert))
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"alertMempoolSize": 2, "alertBuildFailures": 1, "alertInterval": "1h", "alertWebhookURL": %q}`,
+		webhook.URL,
+	)))
+	assert.NoError(err)
+
+	vm.proposeBlock([dataLen]byte{1})
+	vm.alerter.evaluate()
+	vm.proposeBlock([dataLen]byte{2})
+	vm.alerter.evaluate()
+	alert := <-alerts
+	assert.Equal(MempoolSizeAlert, alert.Rule)
+	assert.False(alert.Resolved)
+
+	// building on an unknown block fails, consuming a submission. Alerts
+	// arrive in the order they are raised.
+	assert.NoError(vm.SetPreference(ids.GenerateTestID()))
+	_, err = vm.BuildBlock()
+	assert.Error(err)
+	vm.alerter.evaluate()
+	alert = <-alerts
+	assert.Equal(MempoolSizeAlert, alert.Rule)
+	assert.True(alert.Resolved)
+	alert = <-alerts
+	assert.Equal(BuildFailuresAlert, alert.Rule)
+	assert.Contains(alert.Message, "1 blocks failed to build")
+	assert.NoError(vm.Shutdown())
+}
+
+func TestBlockStats(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+
+	parent := blk.(*Block)
+	for i, offset := range []time.Duration{10, 20, 25, 35} {
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: [dataLen]byte{byte(i + 2)}}, blk.Timestamp().Add(offset*time.Second))
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		parent = blk
+	}
+
+	service := &Service{vm: vm}
+	reply := BlockStats{}
+	assert.NoError(service.GetBlockStats(nil, &GetBlockStatsArgs{}, &reply))
+	assert.Equal(time.Hour, reply.Window.Duration)
+	assert.EqualValues(5, reply.Blocks)
+	assert.False(reply.Truncated)
+	assert.Equal(4, reply.Intervals.Count)
+	assert.Equal(5*time.Second, reply.Intervals.Min.Duration)
+	assert.Equal(10*time.Second, reply.Intervals.Median.Duration)
+	assert.Equal(10*time.Second, reply.Intervals.P95.Duration)
+	// only the block built by this node has latencies
+	assert.Equal(1, reply.BuildDurations.Count)
+	assert.Equal(1, reply.AcceptLatencies.Count)
+
+	_, err = vm.blockStats(context.Background(), -time.Hour)
+	assert.ErrorIs(err, errNonPositiveWindow)
+}
+
+func TestChainGrowth(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	genesis, err := vm.getBlock(genesisID)
+	assert.NoError(err)
+
+	hour := int64(time.Hour / time.Second)
+	start := time.Now().Unix()/hour*hour - 4*hour
+	parent := genesis
+	for i, offset := range []int64{0, 10, 2*hour + 5, 2*hour + 50, 2*hour + 100, 3*hour + 1} {
+		blk, err := vm.newBlock(parent.ID(), parent.Height()+1, &submission{data: [dataLen]byte{byte(i + 1)}}, time.Unix(start+offset, 0))
+		assert.NoError(err)
+		assert.NoError(blk.Verify())
+		assert.NoError(blk.Accept())
+		parent = blk
+	}
+
+	service := &Service{vm: vm}
+	reply := GetChainGrowthReply{}
+	assert.NoError(service.GetChainGrowth(nil, &GetChainGrowthArgs{
+		Interval: HourInterval,
+		Start:    json.Uint64(start + 30),
+		End:      json.Uint64(start + 3*hour),
+	}, &reply))
+	assert.Equal([]GrowthBucket{
+		{Start: json.Uint64(start), Blocks: 2},
+		{Start: json.Uint64(start + hour), Blocks: 0},
+		{Start: json.Uint64(start + 2*hour), Blocks: 3},
+		{Start: json.Uint64(start + 3*hour), Blocks: 0},
+	}, reply.Buckets)
+
+	// defaults to the last 24 intervals
+	assert.NoError(service.GetChainGrowth(nil, &GetChainGrowthArgs{Interval: HourInterval}, &reply))
+	assert.Len(reply.Buckets, defaultGrowthBuckets)
+	total := json.Uint64(0)
+	for _, bucket := range reply.Buckets {
+		total += bucket.Blocks
+	}
+	assert.EqualValues(6, total)
+
+	assert.ErrorIs(service.GetChainGrowth(nil, &GetChainGrowthArgs{Interval: "week"}, &reply), errUnknownInterval)
+	assert.ErrorIs(service.GetChainGrowth(nil, &GetChainGrowthArgs{Interval: HourInterval, Start: 1, End: json.Uint64(2000 * hour)}, &reply), errTooManyBuckets)
+}
+
+func TestCallContext(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"rpcTimeout":"10ms"}`))
+	assert.NoError(err)
+
+	// calls time out
+	ctx, cancel := vm.callContext(context.Background())
+	<-ctx.Done()
+	assert.ErrorIs(ctx.Err(), context.DeadlineExceeded)
+	cancel()
+
+	// long reads are aborted once the call is canceled
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	blk, err := vm.newBlock(genesisID, 1, &submission{data: [dataLen]byte{1}}, time.Now())
+	assert.NoError(err)
+	assert.NoError(blk.Verify())
+	assert.NoError(blk.Accept())
+	ctx, cancel = vm.callContext(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx)
+	service := &Service{vm: vm}
+	assert.ErrorIs(service.GetChainGrowth(r, &GetChainGrowthArgs{Interval: HourInterval}, &GetChainGrowthReply{}), context.Canceled)
+	assert.ErrorIs(service.GetBlockStats(r, &GetBlockStatsArgs{}, &BlockStats{}), context.Canceled)
+	assert.NoError(service.GetBlockStats(nil, &GetBlockStatsArgs{}, &BlockStats{}))
+
+	// and so are reads of the state iterating the database
+	_, err = vm.state.GetAcceptedIDs(ctx, 0, 10)
+	assert.ErrorIs(err, context.Canceled)
+	_, _, _, err = vm.state.ScanUsage(ctx, string(acceptedLogPrefix), nil, 10)
+	assert.ErrorIs(err, context.Canceled)
+
+	// calls are canceled on shutdown
+	ctx, cancel = vm.callContext(context.Background())
+	defer cancel()
+	assert.NoError(vm.Shutdown())
+	<-ctx.Done()
+	assert.ErrorIs(ctx.Err(), context.Canceled)
+}
+
+func TestExplorerSummaries(t *testing.T) {
+	assert := assert.New(t)
+	vm, _, _, err := newTestVM()
+	assert.NoError(err)
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	service := Service{vm}
+
+	blkIDs := []ids.ID{genesisID}
+	for i, args := range []ProposeBlockArgs{
+		{Namespace: "bookings", Tags: map[string]string{"booking": "42"}},
+		{},
+		{Namespace: "bookings", HashAlgorithm: SHA3256},
+	} {
+		data := [dataLen]byte{byte(i + 1)}
+		args.Data, err = formatting.EncodeWithChecksum(formatting.CB58, data[:])
+		assert.NoError(err)
+		assert.NoError(service.ProposeBlock(nil, &args, &ProposeBlockReply{}))
+		blk, err := vm.BuildBlock()
+		assert.NoError(err)
+		assert.NoError(blk.Accept())
+		assert.NoError(vm.SetPreference(blk.ID()))
+		blkIDs = append(blkIDs, blk.ID())
+	}
+
+	// blocks are summarized highest first, page by page
+	summaries := GetBlockSummariesReply{}
+	assert.NoError(service.GetBlockSummaries(nil, &GetBlockSummariesArgs{Limit: 2}, &summaries))
+	assert.Len(summaries.Blocks, 2)
+	assert.Equal(blkIDs[3], summaries.Blocks[0].ID)
+	assert.Equal(blkIDs[2], summaries.Blocks[1].ID)
+	assert.NotNil(summaries.NextHeight)
+	latest := summaries.Blocks[0]
+	assert.Equal(DataBlockKind, latest.Kind)
+	assert.Equal("bookings", latest.Namespace)
+	assert.Equal(SHA3256, latest.HashAlgorithm)
+	assert.Equal(SHA3256+":"+latest.Hash, latest.Preview)
+	assert.Equal([]ProofLink{{
+		Kind:   "inclusion",
+		Method: Name + ".getInclusionProof",
+		Params: map[string]string{"data": latest.Data},
+	}}, latest.Proofs)
+
+	assert.NoError(service.GetBlockSummaries(nil, &GetBlockSummariesArgs{BeforeHeight: *summaries.NextHeight}, &summaries))
+	assert.Len(summaries.Blocks, 2)
+	assert.Equal(blkIDs[1], summaries.Blocks[0].ID)
+	assert.Equal(map[string]string{"booking": "42"}, summaries.Blocks[0].Tags)
+	assert.Equal(genesisID, summaries.Blocks[1].ID)
+	assert.Nil(summaries.NextHeight)
+
+	// the head is the last accepted block
+	head := HeadSummary{}
+	assert.NoError(service.GetHeadSummary(nil, nil, &head))
+	assert.Equal(blkIDs[3], head.LastAccepted.ID)
+	assert.EqualValues(3, head.BlocksLastHour)
+	assert.Zero(head.Pending)
+
+	// activity is counted by namespace
+	activity := RecentActivity{}
+	assert.NoError(service.GetRecentActivity(nil, &GetRecentActivityArgs{}, &activity))
+	assert.EqualValues(3, activity.Blocks)
+	assert.False(activity.Truncated)
+	assert.Equal([]ActivityCount{{Key: "bookings", Blocks: 2}}, activity.Namespaces)
+	assert.Empty(activity.Submitters)
+	assert.ErrorIs(service.GetRecentActivity(nil, &GetRecentActivityArgs{Window: Duration{-time.Hour}}, &activity), errNonPositiveWindow)
+
+	// other kinds of blocks are previewed by what they anchor
+	kind, preview := blockKind(&Block{Trnsfr: &Transfer{To: ids.ShortID{1}, Amount: 5}})
+	assert.Equal(TransferBlockKind, kind)
+	assert.Equal(fmt.Sprintf("transfer of 5 to %s", ids.ShortID{1}), preview)
+	kind, _ = blockKind(&Block{Ncrptd: &EncryptedPayload{}})
+	assert.Equal(EncryptedBlockKind, kind)
+}
+
+// recordingTracer is a Tracer recording the spans it starts
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	err    error
+	ended  bool
+}
+
+type recordedSpanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, _ ...Attribute) (context.Context, Span) {
+	span := &recordedSpan{name: name}
+	span.parent, _ = ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+func (s *recordedSpan) End()                  { s.ended = true }
+
+func TestTracing(t *testing.T) {
+	assert := assert.New(t)
+	// spans are logged unless the factory provides a tracer
+	vm, _, _, err := newTestVMWithConfig([]byte(`{"tracingEnabled": true}`))
+	assert.NoError(err)
+	assert.IsType(&logTracer{}, vm.tracer)
+	tracer := &recordingTracer{}
+	vm.tracer = tracer
+	handlers, err := vm.CreateHandlers()
+	assert.NoError(err)
+
+	data, err := formatting.EncodeWithChecksum(formatting.CB58, make([]byte, dataLen))
+	assert.NoError(err)
+	body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "timestampvm.proposeBlock", "params": {"data": %q}}`, data)
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	request.Header.Set("Content-Type", "application/json")
+	handlers[""].Handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+
+	// the block lifecycle is traced as part of the call proposing its data
+	spans := map[string]*recordedSpan{}
+	for _, span := range tracer.spans {
+		assert.True(span.ended, span.name)
+		assert.NoError(span.err, span.name)
+		spans[span.name] = span
+	}
+	assert.Len(spans, 5)
+	propose := spans["timestampvm.ProposeBlock"]
+	assert.Nil(propose.parent)
+	assert.Equal(propose, spans["mempool"].parent)
+	assert.Equal(propose, spans["BuildBlock"].parent)
+	assert.Equal(spans["BuildBlock"], spans["Verify"].parent)
+	assert.Equal(spans["BuildBlock"], spans["Accept"].parent)
+}
+
+func TestOTLPTracing(t *testing.T) {
+	assert := assert.New(t)
+	lock := sync.Mutex{}
+	failures := 1
+	requests := []map[string]interface{}(nil)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(otlpTracesPath, r.URL.Path)
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		lock.Lock()
+		defer lock.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		request := map[string]interface{}{}
+		assert.NoError(stdjson.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+	}))
+	defer collector.Close()
+
+	vm, _, _, err := newTestVMWithConfig([]byte(fmt.Sprintf(
+		`{"tracingEnabled": true, "tracingOTLPEndpoint": %q, "tracingOTLPInterval": "1h"}`,
+		collector.URL,
+	)))
+	assert.NoError(err)
+	assert.Equal(vm.otlpTracer, vm.tracer)
+
+	genesisID, err := vm.LastAccepted()
+	assert.NoError(err)
+	assert.NoError(vm.SetPreference(genesisID))
+	vm.proposeBlock([dataLen]byte{1})
+	blk, err := vm.BuildBlock()
+	assert.NoError(err)
+	assert.NoError(blk.Accept())
+
+	// spans the collector refused are exported again
+	assert.ErrorIs(vm.otlpTracer.Flush(), errOTLPExportFailed)
+	assert.NoError(vm.otlpTracer.Flush())
+	assert.Len(requests, 1)
+
+	resourceSpans := requests[0]["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resource := resourceSpans["resource"].(map[string]interface{})
+	assert.Contains(resource["attributes"], map[string]interface{}{
+		"key":   "service.name",
+		"value": map[string]interface{}{"stringValue": Name},
+	})
+	spans := map[string]map[string]interface{}{}
+	for _, span := range resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{}) {
+		span := span.(map[string]interface{})
+		spans[span["name"].(string)] = span
+	}
+	build, accept := spans["BuildBlock"], spans["Accept"]
+	assert.NotNil(build)
+	assert.NotNil(accept)
+	assert.Len(build["traceId"], 32)
+	assert.Len(build["spanId"], 16)
+	assert.Equal(build["traceId"], accept["traceId"])
+	assert.Equal(build["spanId"], accept["parentSpanId"])
+	assert.Contains(accept["attributes"], map[string]interface{}{
+		"key":   "height",
+		"value": map[string]interface{}{"intValue": "1"},
+	})
+
+	// the spans ended since the last export are exported on shutdown
+	_, span := vm.tracer.Start(context.Background(), "last")
+	span.End()
+	assert.NoError(vm.Shutdown())
+	assert.Len(requests, 2)
+
+	_, err = ParseConfig([]byte(`{"tracingOTLPEndpoint": "http://localhost:4318"}`))
+	assert.ErrorIs(err, errOTLPWithoutTracing)
+	_, err = ParseConfig([]byte(`{"tracingEnabled": true, "tracingOTLPEndpoint": "localhost:4318"}`))
+	assert.ErrorIs(err, errBadOTLPEndpoint)
+}
+
+// testAdminToken is the admin token configured by adminConfig
+const testAdminToken = "admin-secret"
+
+// adminConfig returns the config entries enabling the admin API, protected by
+// [testAdminToken]
+func adminConfig(t *testing.T) string {
+	tokenFile := filepath.Join(t.TempDir(), "admin-token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte(testAdminToken), 0o600))
+	return fmt.Sprintf(`"adminAPIEnabled": true, "adminTokenFile": %q`, tokenFile)
+}
+
+func newTestVM(verifiers ...BlockVerifier) (*VM, *snow.Context, chan common.Message, error) {
+	return newTestVMWithConfig(nil, verifiers...)
+}
+
+func newTestVMWithConfig(configData []byte, verifiers ...BlockVerifier) (*VM, *snow.Context, chan common.Message, error) {
+	return newTestVMWithDB(manager.NewMemDB(version.DefaultVersion1_0_0), configData, verifiers...)
+}
+
+func newTestVMWithDB(dbManager manager.Manager, configData []byte, verifiers ...BlockVerifier) (*VM, *snow.Context, chan common.Message, error) {
 	msgChan := make(chan common.Message, 1)
-	vm := &VM{}
+	vm := NewVM(verifiers...)
 	ctx := snow.DefaultContextTest()
 	ctx.ChainID = blockchainID
-	err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, nil, msgChan, nil, nil)
+	err := vm.Initialize(ctx, dbManager, []byte{0, 0, 0, 0, 0}, nil, configData, msgChan, nil, nil)
 	return vm, ctx, msgChan, err
 }
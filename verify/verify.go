@@ -0,0 +1,310 @@
+// Copyright (C) 2022, Chain4Travel AG. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// Package verify verifies timestampvm blocks offline, so services can check
+// anchors without linking the VM or the consensus engine. It only depends on
+// the ID, hashing and signature packages of caminogo.
+//
+// A block is fetched as bytes with the timestampvm.getBlockBytes API method,
+// and is trusted once its ID is known to be accepted. As each block commits
+// to its parent's ID, the blocks from an anchoring block up to a trusted
+// block, e.g. the last accepted block as reported by a node the verifier
+// trusts, prove the anchoring block was accepted, see VerifyAncestry.
+package verify
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/chain4travel/caminogo/ids"
+	"github.com/chain4travel/caminogo/utils/crypto"
+	"github.com/chain4travel/caminogo/utils/hashing"
+)
+
+const (
+	// DataLen is the length of the data anchored by a block
+	DataLen = 32
+
+	// MaxCodecVersion is the latest codec version of blocks. Blocks of every
+	// version but 0 carry a signature, which may be empty.
+	MaxCodecVersion = 17
+
+	// length of the fields every block starts with: the codec version, the
+	// parent's ID, the height, the timestamp and the data
+	headerLen = 2 + 32 + 8 + 8 + DataLen
+	// lengths of the P-chain height and of the proof of work, which precede
+	// the namespace in the codec versions having them
+	pChainHeightLen = 8
+	proofOfWorkLen  = 32 + 8
+)
+
+var (
+	errShortBlock         = errors.New("block is too short")
+	errUnknownCodec       = fmt.Errorf("block codec version is above %d", MaxCodecVersion)
+	errUnsigned           = errors.New("block isn't signed")
+	errBadSignature       = errors.New("block signature is invalid")
+	errNoBlocks           = errors.New("no blocks given")
+	errNotParent          = errors.New("block isn't the parent of the next block")
+	errUntrustedAncestry  = errors.New("last block isn't the trusted block")
+	errDataMismatch       = errors.New("block doesn't anchor the data")
+	errSubmitterMismatch  = errors.New("block isn't signed by the submitter")
+	errNonMonotonicBlocks = errors.New("block timestamps decrease")
+	errBadCertificate     = errors.New("certificate signature is invalid")
+
+	secpFactory = crypto.FactorySECP256K1R{}
+
+	// scopedLayouts are the codec versions of blocks whose data may have a
+	// namespace and tags, which are signed along with it
+	scopedLayouts = map[uint16]scopedLayout{
+		7:  {skip: pChainHeightLen},
+		8:  {skip: proofOfWorkLen},
+		9:  {skip: pChainHeightLen, tags: true},
+		10: {skip: proofOfWorkLen, tags: true},
+		13: {skip: pChainHeightLen, tags: true},
+		14: {skip: proofOfWorkLen, tags: true},
+		16: {skip: pChainHeightLen, tags: true},
+		17: {skip: proofOfWorkLen, tags: true},
+	}
+)
+
+// scopedLayout is where the namespace and tags are in a codec version
+type scopedLayout struct {
+	// length of the fields between the signature and the namespace
+	skip int
+	// true if the namespace is followed by tags
+	tags bool
+}
+
+// Tag is a key=value label of the data of a block
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Header is the part of a block every codec version encodes the same way,
+// along with the namespace and tags of its data. The other fields following
+// it, like a transfer or an encrypted payload, aren't decoded.
+type Header struct {
+	// ID is the SHA-256 hash of the block's bytes
+	ID           ids.ID
+	CodecVersion uint16
+	ParentID     ids.ID
+	Height       uint64
+	// Timestamp is the Unix time in seconds the block was proposed at
+	Timestamp int64
+	Data      [DataLen]byte
+	// Signature is the submitter's signature of the data, namespace and
+	// tags, empty if the block is unsigned
+	Signature []byte
+	// Namespace of the data, empty if it has none
+	Namespace string
+	// Tags of the data, sorted by key
+	Tags []Tag
+}
+
+// ParseHeader returns the header of the block encoded as [blockBytes]
+func ParseHeader(blockBytes []byte) (*Header, error) {
+	if len(blockBytes) < headerLen {
+		return nil, errShortBlock
+	}
+	h := &Header{
+		ID:           hashing.ComputeHash256Array(blockBytes),
+		CodecVersion: binary.BigEndian.Uint16(blockBytes),
+	}
+	if h.CodecVersion > MaxCodecVersion {
+		return nil, errUnknownCodec
+	}
+	rest := blockBytes[2:]
+	copy(h.ParentID[:], rest)
+	rest = rest[len(h.ParentID):]
+	h.Height = binary.BigEndian.Uint64(rest)
+	rest = rest[8:]
+	h.Timestamp = int64(binary.BigEndian.Uint64(rest))
+	rest = rest[8:]
+	copy(h.Data[:], rest)
+	rest = rest[DataLen:]
+	if h.CodecVersion == 0 {
+		return h, nil
+	}
+
+	if len(rest) < 4 {
+		return nil, errShortBlock
+	}
+	sigLen := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	if uint64(len(rest)) < uint64(sigLen) {
+		return nil, errShortBlock
+	}
+	if sigLen > 0 {
+		h.Signature = rest[:sigLen]
+	}
+	rest = rest[sigLen:]
+
+	layout, scoped := scopedLayouts[h.CodecVersion]
+	if !scoped {
+		return h, nil
+	}
+	if len(rest) < layout.skip {
+		return nil, errShortBlock
+	}
+	rest = rest[layout.skip:]
+	var err error
+	if h.Namespace, rest, err = unpackStr(rest); err != nil {
+		return nil, err
+	}
+	if !layout.tags {
+		return h, nil
+	}
+	if len(rest) < 4 {
+		return nil, errShortBlock
+	}
+	numTags := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	// each tag takes at least the lengths of its key and value
+	if uint64(len(rest)) < 4*uint64(numTags) {
+		return nil, errShortBlock
+	}
+	for i := uint32(0); i < numTags; i++ {
+		tag := Tag{}
+		if tag.Key, rest, err = unpackStr(rest); err != nil {
+			return nil, err
+		}
+		if tag.Value, rest, err = unpackStr(rest); err != nil {
+			return nil, err
+		}
+		h.Tags = append(h.Tags, tag)
+	}
+	return h, nil
+}
+
+// unpackStr returns the string [b] starts with, encoded with its 2 byte
+// length, and the bytes following it
+func unpackStr(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errShortBlock
+	}
+	strLen := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < strLen {
+		return "", nil, errShortBlock
+	}
+	return string(b[:strLen]), b[strLen:], nil
+}
+
+// SubmissionMessage returns the message a submitter of [data] to the chain
+// [chainID] signs, as timestampvm.SubmissionMessage does
+func SubmissionMessage(chainID ids.ID, data [DataLen]byte) []byte {
+	msg := make([]byte, 2, 2+len(chainID)+DataLen)
+	msg = append(msg, chainID[:]...)
+	return append(msg, data[:]...)
+}
+
+// ScopedSubmissionMessage returns the message a submitter of [data] in
+// [namespace] with [tags], sorted by key, to the chain [chainID] signs, as
+// timestampvm.ScopedSubmissionMessage does
+func ScopedSubmissionMessage(chainID ids.ID, data [DataLen]byte, namespace string, tags []Tag) []byte {
+	msg := SubmissionMessage(chainID, data)
+	if namespace == "" && len(tags) == 0 {
+		return msg
+	}
+	msg = packStr(msg, namespace)
+	var numTags [4]byte
+	binary.BigEndian.PutUint32(numTags[:], uint32(len(tags)))
+	msg = append(msg, numTags[:]...)
+	for _, tag := range tags {
+		msg = packStr(msg, tag.Key)
+		msg = packStr(msg, tag.Value)
+	}
+	return msg
+}
+
+// packStr returns [b] followed by [s], encoded with its 2 byte length
+func packStr(b []byte, s string) []byte {
+	var strLen [2]byte
+	binary.BigEndian.PutUint16(strLen[:], uint16(len(s)))
+	b = append(b, strLen[:]...)
+	return append(b, s...)
+}
+
+// Submitter returns the address whose key signed the data, namespace and
+// tags of the block [h] of the chain [chainID]
+func (h *Header) Submitter(chainID ids.ID) (ids.ShortID, error) {
+	if len(h.Signature) == 0 {
+		return ids.ShortEmpty, errUnsigned
+	}
+	pubKey, err := secpFactory.RecoverPublicKey(ScopedSubmissionMessage(chainID, h.Data, h.Namespace, h.Tags), h.Signature)
+	if err != nil {
+		return ids.ShortEmpty, errBadSignature
+	}
+	return pubKey.Address(), nil
+}
+
+// VerifyCertificate returns the address of the node which signed the
+// proof-of-existence [certificate], as issued by the timestampvm.getCertificate
+// API method, with [signature]. The certificate must be given as the exact
+// bytes which were signed. Its block bytes are checked with ParseHeader,
+// and its ancestry with VerifyAncestry.
+func VerifyCertificate(certificate []byte, signature []byte) (ids.ShortID, error) {
+	pubKey, err := secpFactory.RecoverPublicKey(certificate, signature)
+	if err != nil {
+		return ids.ShortEmpty, errBadCertificate
+	}
+	return pubKey.Address(), nil
+}
+
+// VerifyAncestry returns the headers of [blocks], ordered from the oldest
+// to the last, which must be the block [trustedID]. Each block must be the
+// parent of the next, so all of them are ancestors of the trusted block.
+func VerifyAncestry(trustedID ids.ID, blocks [][]byte) ([]*Header, error) {
+	if len(blocks) == 0 {
+		return nil, errNoBlocks
+	}
+	headers := make([]*Header, len(blocks))
+	for i, blockBytes := range blocks {
+		h, err := ParseHeader(blockBytes)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		if i > 0 {
+			parent := headers[i-1]
+			if h.ParentID != parent.ID || h.Height != parent.Height+1 {
+				return nil, fmt.Errorf("block %d: %w", i-1, errNotParent)
+			}
+			if h.Timestamp < parent.Timestamp {
+				return nil, fmt.Errorf("block %d: %w", i, errNonMonotonicBlocks)
+			}
+		}
+		headers[i] = h
+	}
+	if headers[len(headers)-1].ID != trustedID {
+		return nil, errUntrustedAncestry
+	}
+	return headers, nil
+}
+
+// VerifyAnchor returns the header of the first of [blocks] once verified
+// that it anchors [data], that it's an ancestor of the trusted block
+// [trustedID], see VerifyAncestry, and, unless [submitter] is empty, that
+// its data was signed by [submitter] for the chain [chainID]
+func VerifyAnchor(chainID ids.ID, trustedID ids.ID, blocks [][]byte, data [DataLen]byte, submitter ids.ShortID) (*Header, error) {
+	headers, err := VerifyAncestry(trustedID, blocks)
+	if err != nil {
+		return nil, err
+	}
+	anchor := headers[0]
+	if anchor.Data != data {
+		return nil, errDataMismatch
+	}
+	if submitter == ids.ShortEmpty {
+		return anchor, nil
+	}
+	signer, err := anchor.Submitter(chainID)
+	if err != nil {
+		return nil, err
+	}
+	if signer != submitter {
+		return nil, errSubmitterMismatch
+	}
+	return anchor, nil
+}